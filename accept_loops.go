@@ -0,0 +1,214 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"github.com/michaelquigley/pfxlog"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// AcceptLoopsAuto sizes the number of accept loops to GOMAXPROCS
+	AcceptLoopsAuto = "auto"
+
+	// tlsListenerHandshakeTimeout and tlsListenerKeepAlive match the shared transport listener
+	tlsListenerHandshakeTimeout = 5 * time.Second
+	tlsListenerKeepAlive        = 15 * time.Second
+)
+
+// AcceptLoopOptions controls how many accept loops serve each bind point. By default, a single accept loop is run on
+// the shared transport listener. With more than one, that many sockets are bound to the bind point's address with
+// SO_REUSEPORT, letting the kernel spread new connections across them, and each is served by its own accept loop and
+// TLS listener. This reduces accept contention on machines serving very high connection churn. These sockets are not
+// shared with other users of the transport listener on the same address. On platforms without SO_REUSEPORT a single
+// accept loop is run.
+type AcceptLoopOptions struct {
+	AcceptLoops int
+}
+
+// Default runs a single accept loop
+func (acceptLoopOptions *AcceptLoopOptions) Default() {
+	acceptLoopOptions.AcceptLoops = 1
+}
+
+// Parse parses a config map. acceptLoops may be a positive number or "auto".
+func (acceptLoopOptions *AcceptLoopOptions) Parse(config map[interface{}]interface{}) error {
+	interfaceVal, ok := config["acceptLoops"]
+	if !ok {
+		return nil
+	}
+
+	switch val := interfaceVal.(type) {
+	case int:
+		acceptLoopOptions.AcceptLoops = val
+	case string:
+		if val != AcceptLoopsAuto {
+			return fmt.Errorf("invalid value [%s] for acceptLoops, must be a number or %s", val, AcceptLoopsAuto)
+		}
+		acceptLoopOptions.AcceptLoops = runtime.GOMAXPROCS(0)
+	default:
+		return errors.New("could not use value for acceptLoops, not a number or string")
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (acceptLoopOptions *AcceptLoopOptions) Validate() error {
+	if acceptLoopOptions.AcceptLoops < 1 {
+		return fmt.Errorf("value [%d] for acceptLoops too low, must be at least 1", acceptLoopOptions.AcceptLoops)
+	}
+
+	return nil
+}
+
+// listenAcceptLoops binds count sockets to the address with SO_REUSEPORT, letting the kernel spread new connections
+// across them. SO_REUSEPORT allows binding an address that another process has bound with SO_REUSEPORT as well, so
+// the address is first bound without it to verify that it is not in use. If the address has no port, the port chosen
+// for that check is used. If any socket fails to bind, those already opened are closed. A single socket is bound
+// without SO_REUSEPORT.
+func listenAcceptLoops(address string, count int) ([]net.Listener, error) {
+	probe, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	if count == 1 {
+		return []net.Listener{probe}, nil
+	}
+	address = probe.Addr().String()
+	if err = probe.Close(); err != nil {
+		return nil, err
+	}
+
+	listenConfig := &net.ListenConfig{
+		Control: reusePortControl,
+	}
+
+	var result []net.Listener
+
+	for i := 0; i < count; i++ {
+		listener, err := listenConfig.Listen(context.Background(), "tcp", address)
+		if err != nil {
+			for _, opened := range result {
+				_ = opened.Close()
+			}
+			return nil, err
+		}
+
+		result = append(result, listener)
+	}
+
+	return result, nil
+}
+
+// tlsListener serves TLS connections accepted from a socket bound by a Server the way the shared transport listener
+// does: TCP keep alive and no delay are set, handshakes complete outside of the accept loop within
+// tlsListenerHandshakeTimeout, and only connections that completed their handshake are returned by Accept. If
+// fingerprints is true, the ClientHello of each connection is captured for its TlsFingerprint.
+type tlsListener struct {
+	net.Listener
+	config       *gmtls.Config
+	fingerprints bool
+	conns        chan net.Conn
+	closeOnce    sync.Once
+	closed       chan struct{}
+	acceptErr    error
+}
+
+func newTlsListener(listener net.Listener, config *gmtls.Config, fingerprints bool) *tlsListener {
+	result := &tlsListener{
+		Listener:     listener,
+		config:       config,
+		fingerprints: fingerprints,
+		conns:        make(chan net.Conn, 16),
+		closed:       make(chan struct{}),
+	}
+	go result.runAccept()
+	return result
+}
+
+func (listener *tlsListener) runAccept() {
+	for {
+		conn, err := listener.Listener.Accept()
+		if err != nil {
+			listener.closeOnce.Do(func() {
+				listener.acceptErr = err
+				close(listener.closed)
+			})
+			return
+		}
+
+		go listener.handshake(conn)
+	}
+}
+
+func (listener *tlsListener) handshake(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetNoDelay(true)
+		_ = tcpConn.SetKeepAlive(true)
+		_ = tcpConn.SetKeepAlivePeriod(tlsListenerKeepAlive)
+	}
+
+	if listener.fingerprints {
+		conn = &fingerprintConn{Conn: conn}
+	}
+
+	tlsConn := gmtls.Server(conn, listener.config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), tlsListenerHandshakeTimeout)
+	defer cancel()
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		pfxlog.Logger().WithField("remote", conn.RemoteAddr().String()).WithError(err).Debug("handshake failed")
+		_ = tlsConn.Close()
+		return
+	}
+
+	select {
+	case listener.conns <- tlsConn:
+	case <-listener.closed:
+		_ = tlsConn.Close()
+	}
+}
+
+// Accept returns the next connection that completed its handshake
+func (listener *tlsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case <-listener.closed:
+		if listener.acceptErr != nil {
+			return nil, listener.acceptErr
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+func (listener *tlsListener) Close() error {
+	listener.closeOnce.Do(func() {
+		close(listener.closed)
+	})
+	return listener.Listener.Close()
+}
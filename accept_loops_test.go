@@ -0,0 +1,225 @@
+package xweb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"gitee.com/zhaochuninhefei/gmgo/x509"
+	"github.com/openziti/xweb/v2/metrics"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestAcceptLoopOptions(t *testing.T) {
+	parse := func(value interface{}) (*AcceptLoopOptions, error) {
+		options := &AcceptLoopOptions{}
+		options.Default()
+		err := options.Parse(map[interface{}]interface{}{"acceptLoops": value})
+		return options, err
+	}
+
+	t.Run("defaults to a single accept loop", func(t *testing.T) {
+		req := require.New(t)
+		options := &AcceptLoopOptions{}
+		options.Default()
+		req.NoError(options.Parse(map[interface{}]interface{}{}))
+		req.Equal(1, options.AcceptLoops)
+		req.NoError(options.Validate())
+	})
+
+	t.Run("auto uses GOMAXPROCS", func(t *testing.T) {
+		req := require.New(t)
+		options, err := parse(AcceptLoopsAuto)
+		req.NoError(err)
+		req.Equal(runtime.GOMAXPROCS(0), options.AcceptLoops)
+	})
+
+	t.Run("numbers are used as is", func(t *testing.T) {
+		req := require.New(t)
+		options, err := parse(4)
+		req.NoError(err)
+		req.Equal(4, options.AcceptLoops)
+		req.NoError(options.Validate())
+	})
+
+	t.Run("invalid values are rejected", func(t *testing.T) {
+		req := require.New(t)
+		_, err := parse("many")
+		req.Error(err)
+
+		options, err := parse(0)
+		req.NoError(err)
+		req.Error(options.Validate())
+	})
+}
+
+func TestAcceptLoops(t *testing.T) {
+	t.Run("listening fails if the address is already bound", func(t *testing.T) {
+		req := require.New(t)
+		bound, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+		defer func() { _ = bound.Close() }()
+
+		serverConfig := &ServerConfig{Name: "test"}
+		serverConfig.Options.AcceptLoops = 4
+
+		server := &Server{httpServers: []*namedHttpServer{{
+			Server:       &gmhttp.Server{Addr: bound.Addr().String(), TLSConfig: &gmtls.Config{}},
+			ServerConfig: serverConfig,
+		}}}
+
+		req.Error(server.Listen())
+		req.Empty(server.httpServers[0].listeners)
+	})
+
+	t.Run("listening fails if the address is bound with SO_REUSEPORT by another process", func(t *testing.T) {
+		if !reusePortSupported {
+			t.Skip("SO_REUSEPORT is not supported on " + runtime.GOOS)
+		}
+
+		req := require.New(t)
+		bound, err := (&net.ListenConfig{Control: reusePortControl}).Listen(context.Background(), "tcp", "127.0.0.1:0")
+		req.NoError(err)
+		defer func() { _ = bound.Close() }()
+
+		_, err = listenAcceptLoops(bound.Addr().String(), 2)
+		req.Error(err)
+	})
+
+	t.Run("each accept loop serves its own socket", func(t *testing.T) {
+		if !reusePortSupported {
+			t.Skip("SO_REUSEPORT is not supported on " + runtime.GOOS)
+		}
+
+		req := require.New(t)
+		serverConfig := &ServerConfig{Name: "test"}
+		serverConfig.Options.AcceptLoops = 3
+
+		httpServer := &namedHttpServer{
+			Server: &gmhttp.Server{
+				Addr:      "127.0.0.1:0",
+				TLSConfig: newTestServerTlsConfig(req),
+				Handler: gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, _ *gmhttp.Request) {
+					_, _ = writer.Write([]byte("ok"))
+				}),
+			},
+			ServerConfig: serverConfig,
+			connTracker:  newConnTracker(ConnectionReapOptions{}, metrics.NewRegistry(), metrics.Labels{}),
+		}
+		server := &Server{sloWatcher: newSloWatcher(nil, nil), httpServers: []*namedHttpServer{httpServer}}
+
+		req.NoError(server.Listen())
+		req.Len(httpServer.listeners, 3)
+		address := httpServer.listeners[0].Addr().String()
+		for _, listener := range httpServer.listeners {
+			req.Equal(address, listener.Addr().String())
+		}
+
+		served := make(chan error, 1)
+		go func() {
+			served <- server.Serve()
+		}()
+
+		client := &gmhttp.Client{
+			Timeout: 5 * time.Second,
+			Transport: &gmhttp.Transport{
+				TLSClientConfig:   &gmtls.Config{InsecureSkipVerify: true},
+				DisableKeepAlives: true,
+			},
+		}
+		for i := 0; i < 10; i++ {
+			response, err := client.Get("https://" + address + "/")
+			req.NoError(err)
+			_ = response.Body.Close()
+			req.Equal(gmhttp.StatusOK, response.StatusCode)
+		}
+
+		req.NoError(httpServer.Close())
+
+		select {
+		case err := <-served:
+			req.NoError(err)
+		case <-time.After(5 * time.Second):
+			req.Fail("serve did not return after every accept loop stopped")
+		}
+	})
+}
+
+func TestTlsListener(t *testing.T) {
+	t.Run("only connections that complete their handshake are accepted", func(t *testing.T) {
+		req := require.New(t)
+		socket, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+
+		listener := newTlsListener(socket, newTestServerTlsConfig(req), false)
+
+		accepted := make(chan net.Conn, 2)
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					close(accepted)
+					return
+				}
+				accepted <- conn
+			}
+		}()
+
+		plain, err := net.Dial("tcp", socket.Addr().String())
+		req.NoError(err)
+		_, err = plain.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		req.NoError(err)
+		defer func() { _ = plain.Close() }()
+
+		conn, err := gmtls.Dial("tcp", socket.Addr().String(), &gmtls.Config{InsecureSkipVerify: true})
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+
+		select {
+		case serverConn := <-accepted:
+			tlsConn, ok := serverConn.(*gmtls.Conn)
+			req.True(ok)
+			req.True(tlsConn.ConnectionState().HandshakeComplete)
+			_ = serverConn.Close()
+		case <-time.After(5 * time.Second):
+			req.Fail("handshaken connection was not accepted")
+		}
+
+		req.NoError(listener.Close())
+		select {
+		case conn, ok := <-accepted:
+			req.False(ok, "unexpected connection %v", conn)
+		case <-time.After(5 * time.Second):
+			req.Fail("accept did not return once closed")
+		}
+	})
+}
+
+// newTestServerTlsConfig returns a server TLS configuration with a self signed ECDSA certificate for localhost
+func newTestServerTlsConfig(req *require.Assertions) *gmtls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	req.NoError(err)
+
+	return &gmtls.Config{
+		Certificates: []gmtls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+}
@@ -0,0 +1,432 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogEntry describes a single completed request
+type AccessLogEntry struct {
+	Time       time.Time         `json:"time"`
+	RequestId  string            `json:"requestId,omitempty"`
+	Server     string            `json:"server"`
+	BindPoint  string            `json:"bindPoint"`
+	Binding    string            `json:"binding,omitempty"`
+	Name       string            `json:"name,omitempty"`
+	RemoteAddr string            `json:"remoteAddr"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Proto      string            `json:"proto"`
+	Status     int               `json:"status"`
+	Bytes      int64             `json:"bytes"`
+	Duration   time.Duration     `json:"duration"`
+	UserAgent  string            `json:"userAgent,omitempty"`
+	Ja3        string            `json:"ja3,omitempty"`
+	Ja4        string            `json:"ja4,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Verbose    bool              `json:"verbose,omitempty"`
+}
+
+// AccessLogSamplingRule selects a sampling rate for responses with a matching status. Status may be an exact code
+// (e.g. "404"), a class (e.g. "2xx"), or "*".
+type AccessLogSamplingRule struct {
+	Status string
+	Rate   float64
+}
+
+// matches returns true if the rule applies to the status code
+func (rule *AccessLogSamplingRule) matches(status int) bool {
+	if rule.Status == "*" {
+		return true
+	}
+
+	statusStr := strconv.Itoa(status)
+
+	if len(rule.Status) == 3 && strings.HasSuffix(rule.Status, "xx") {
+		return statusStr[:1] == rule.Status[:1]
+	}
+
+	return statusStr == rule.Status
+}
+
+// DefaultAccessLogRedactedHeaders are the headers whose values are never included in verbose access log entries
+var DefaultAccessLogRedactedHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Proxy-Authorization",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+	"Zt-Session",
+}
+
+// AccessLogOptions represents access logging options for a ServerConfig
+type AccessLogOptions struct {
+	AccessLogEnabled bool
+
+	// AccessLogSampling rules are evaluated in order, the first matching rule's rate is used
+	AccessLogSampling []*AccessLogSamplingRule
+
+	// AccessLogRedactedHeaders are the headers whose values are replaced with RedactedValue in verbose access log
+	// entries. Configured headers are added to DefaultAccessLogRedactedHeaders.
+	AccessLogRedactedHeaders []string
+}
+
+// Default disables access logging
+func (accessLogOptions *AccessLogOptions) Default() {
+	accessLogOptions.AccessLogEnabled = false
+	accessLogOptions.AccessLogSampling = nil
+	accessLogOptions.AccessLogRedactedHeaders = append([]string(nil), DefaultAccessLogRedactedHeaders...)
+}
+
+// Parse parses a config map looking for an `accessLog` section, for example:
+//
+//	accessLog:
+//	  enabled: true
+//	  sampling:
+//	    - status: 2xx
+//	      rate: 0.01
+//	    - status: "*"
+//	      rate: 1
+//	  redactHeaders:
+//	    - X-Session-Token
+func (accessLogOptions *AccessLogOptions) Parse(config map[interface{}]interface{}) error {
+	accessLogInterface, ok := config["accessLog"]
+
+	if !ok {
+		return nil
+	}
+
+	accessLogMap, ok := accessLogInterface.(map[interface{}]interface{})
+
+	if !ok {
+		return errors.New("could not use value for accessLog, not a map")
+	}
+
+	if interfaceVal, ok := accessLogMap["enabled"]; ok {
+		if enabled, ok := interfaceVal.(bool); ok {
+			accessLogOptions.AccessLogEnabled = enabled
+		} else {
+			return errors.New("could not use value for accessLog.enabled, not a boolean")
+		}
+	}
+
+	if interfaceVal, ok := accessLogMap["sampling"]; ok {
+		samplingArray, ok := interfaceVal.([]interface{})
+
+		if !ok {
+			return errors.New("could not use value for accessLog.sampling, not an array")
+		}
+
+		for i, ruleInterface := range samplingArray {
+			ruleMap, ok := ruleInterface.(map[interface{}]interface{})
+			if !ok {
+				return fmt.Errorf("could not use value for accessLog.sampling[%d], not a map", i)
+			}
+
+			rule := &AccessLogSamplingRule{}
+
+			if status, ok := ruleMap["status"].(string); ok {
+				rule.Status = status
+			} else if status, ok := ruleMap["status"].(int); ok {
+				rule.Status = strconv.Itoa(status)
+			} else {
+				return fmt.Errorf("could not use value for accessLog.sampling[%d].status, must be a status code, class (e.g. 2xx), or *", i)
+			}
+
+			switch rate := ruleMap["rate"].(type) {
+			case float64:
+				rule.Rate = rate
+			case int:
+				rule.Rate = float64(rate)
+			default:
+				return fmt.Errorf("could not use value for accessLog.sampling[%d].rate, not a number", i)
+			}
+
+			accessLogOptions.AccessLogSampling = append(accessLogOptions.AccessLogSampling, rule)
+		}
+	}
+
+	if interfaceVal, ok := accessLogMap["redactHeaders"]; ok {
+		headerArray, ok := interfaceVal.([]interface{})
+
+		if !ok {
+			return errors.New("could not use value for accessLog.redactHeaders, not an array")
+		}
+
+		for i, headerInterface := range headerArray {
+			header, ok := headerInterface.(string)
+			if !ok || header == "" {
+				return fmt.Errorf("could not use value for accessLog.redactHeaders[%d], not a header name", i)
+			}
+
+			accessLogOptions.AccessLogRedactedHeaders = append(accessLogOptions.AccessLogRedactedHeaders, header)
+		}
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (accessLogOptions *AccessLogOptions) Validate() error {
+	for i, rule := range accessLogOptions.AccessLogSampling {
+		if rule.Rate < 0 || rule.Rate > 1 {
+			return fmt.Errorf("accessLog.sampling[%d].rate must be between 0 and 1", i)
+		}
+	}
+
+	return nil
+}
+
+// redactedHeaders returns the canonical names of AccessLogRedactedHeaders
+func (accessLogOptions *AccessLogOptions) redactedHeaders() map[string]struct{} {
+	result := map[string]struct{}{}
+	for _, header := range accessLogOptions.AccessLogRedactedHeaders {
+		result[gmhttp.CanonicalHeaderKey(header)] = struct{}{}
+	}
+	return result
+}
+
+// sampleRate returns the configured sampling rate for a status, defaulting to 1 (log everything)
+func (accessLogOptions *AccessLogOptions) sampleRate(status int) float64 {
+	for _, rule := range accessLogOptions.AccessLogSampling {
+		if rule.matches(status) {
+			return rule.Rate
+		}
+	}
+	return 1
+}
+
+// AccessLogOverride temporarily raises access log verbosity for requests matching a binding (or api instance name)
+// and/or client IP. Matching requests are always logged, regardless of sampling or whether access logging is enabled,
+// and include request headers.
+type AccessLogOverride struct {
+	Id       string    `json:"id"`
+	Binding  string    `json:"binding,omitempty"`
+	ClientIp string    `json:"clientIp,omitempty"`
+	Until    time.Time `json:"until"`
+
+	ip net.IP
+}
+
+func (override *AccessLogOverride) matches(entry *AccessLogEntry, clientIp net.IP, now time.Time) bool {
+	if now.After(override.Until) {
+		return false
+	}
+
+	if override.Binding != "" && override.Binding != entry.Binding && override.Binding != entry.Name {
+		return false
+	}
+
+	if override.ip != nil && !override.ip.Equal(clientIp) {
+		return false
+	}
+
+	return true
+}
+
+// AccessLogController manages runtime AccessLogOverride's for all Server's of an Instance
+type AccessLogController struct {
+	lock      sync.RWMutex
+	nextId    uint64
+	overrides []*AccessLogOverride
+}
+
+// NewAccessLogController creates an AccessLogController with no overrides
+func NewAccessLogController() *AccessLogController {
+	return &AccessLogController{}
+}
+
+// AddOverride raises verbosity for requests matching binding and/or clientIp for duration. Empty values match all
+// requests. The id of the override is returned.
+func (controller *AccessLogController) AddOverride(binding, clientIp string, duration time.Duration) (*AccessLogOverride, error) {
+	if duration <= 0 {
+		return nil, errors.New("duration must be positive")
+	}
+
+	var ip net.IP
+	if clientIp != "" {
+		if ip = parseClientIp(clientIp); ip == nil {
+			return nil, fmt.Errorf("invalid client ip [%s]", clientIp)
+		}
+		clientIp = ip.String()
+	}
+
+	controller.lock.Lock()
+	defer controller.lock.Unlock()
+
+	controller.removeExpired(time.Now())
+
+	controller.nextId++
+	override := &AccessLogOverride{
+		Id:       strconv.FormatUint(controller.nextId, 10),
+		Binding:  binding,
+		ClientIp: clientIp,
+		Until:    time.Now().Add(duration),
+		ip:       ip,
+	}
+
+	controller.overrides = append(controller.overrides, override)
+
+	return override, nil
+}
+
+// RemoveOverride removes an override by id, returns true if it existed
+func (controller *AccessLogController) RemoveOverride(id string) bool {
+	controller.lock.Lock()
+	defer controller.lock.Unlock()
+
+	for i, override := range controller.overrides {
+		if override.Id == id {
+			controller.overrides = append(controller.overrides[:i:i], controller.overrides[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Overrides returns all unexpired overrides
+func (controller *AccessLogController) Overrides() []*AccessLogOverride {
+	controller.lock.Lock()
+	defer controller.lock.Unlock()
+
+	controller.removeExpired(time.Now())
+
+	result := make([]*AccessLogOverride, len(controller.overrides))
+	copy(result, controller.overrides)
+	return result
+}
+
+func (controller *AccessLogController) removeExpired(now time.Time) {
+	var active []*AccessLogOverride
+	for _, override := range controller.overrides {
+		if !now.After(override.Until) {
+			active = append(active, override)
+		}
+	}
+	controller.overrides = active
+}
+
+func (controller *AccessLogController) isOverridden(entry *AccessLogEntry, clientIp net.IP, now time.Time) bool {
+	controller.lock.RLock()
+	defer controller.lock.RUnlock()
+
+	for _, override := range controller.overrides {
+		if override.matches(entry, clientIp, now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseClientIp parses an IPv4 or IPv6 address. Zero padded IPv4 octets (e.g. 010.000.000.001) are read as decimal,
+// which net.ParseIP rejects.
+func parseClientIp(value string) net.IP {
+	if strings.Contains(value, ".") && !strings.Contains(value, ":") {
+		octets := strings.Split(value, ".")
+		for i, octet := range octets {
+			if trimmed := strings.TrimLeft(octet, "0"); trimmed != "" {
+				octets[i] = trimmed
+			} else if octet != "" {
+				octets[i] = "0"
+			}
+		}
+		value = strings.Join(octets, ".")
+	}
+
+	return net.ParseIP(value)
+}
+
+// wrapAccessLog wraps a http.Handler with access logging for a bind point
+func (server *Server) wrapAccessLog(point *BindPointConfig, capabilities *instanceCapabilities, handler gmhttp.Handler) gmhttp.Handler {
+	options := &server.ServerConfig.Options.AccessLogOptions
+	controller := capabilities.accessLog
+	sinks := capabilities.logSinks
+	redactedHeaders := options.redactedHeaders()
+
+	return gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		start := time.Now()
+
+		request, info := withRequestInfo(request, point)
+		statusWriter := acquireStatusResponseWriter(writer)
+		defer releaseStatusResponseWriter(statusWriter)
+
+		handler.ServeHTTP(statusWriter, request)
+
+		entry := &AccessLogEntry{
+			Time:       start,
+			RequestId:  info.requestId,
+			Server:     server.ServerConfig.Name,
+			BindPoint:  point.InterfaceAddress,
+			RemoteAddr: request.RemoteAddr,
+			Method:     request.Method,
+			Path:       request.URL.Path,
+			Proto:      request.Proto,
+			Status:     statusWriter.Status(),
+			Bytes:      statusWriter.written,
+			Duration:   time.Since(start),
+			UserAgent:  request.UserAgent(),
+		}
+
+		// bodies written for HEAD requests, i.e. by GET handlers serving synthesized HEAD requests, are not sent
+		if request.Method == gmhttp.MethodHead {
+			entry.Bytes = 0
+		}
+
+		if fingerprint := TlsFingerprintFromContext(request.Context()); fingerprint != nil {
+			entry.Ja3 = fingerprint.Ja3Hash
+			entry.Ja4 = fingerprint.Ja4
+		}
+
+		if info.api != nil {
+			entry.Binding = info.api.Binding()
+			entry.Name = info.api.Name()
+		}
+
+		clientHost, _, _ := net.SplitHostPort(request.RemoteAddr)
+		clientIp := net.ParseIP(clientHost)
+
+		if controller.isOverridden(entry, clientIp, start) {
+			entry.Verbose = true
+			entry.Headers = map[string]string{}
+			for name, values := range request.Header {
+				if _, redacted := redactedHeaders[name]; redacted {
+					entry.Headers[name] = RedactedValue
+				} else {
+					entry.Headers[name] = strings.Join(values, ", ")
+				}
+			}
+		} else if !options.AccessLogEnabled {
+			return
+		} else if rate := options.sampleRate(entry.Status); rate < 1 && (rate <= 0 || rand.Float64() >= rate) {
+			return
+		}
+
+		sinks.Access(entry)
+	})
+}
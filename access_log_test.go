@@ -0,0 +1,105 @@
+package xweb
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/openziti/xweb/v2/logsink"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	records []*logsink.Record
+}
+
+func (sink *recordingSink) Write(record *logsink.Record) {
+	sink.records = append(sink.records, record)
+}
+
+func (sink *recordingSink) Close() error {
+	return nil
+}
+
+func Test_wrapAccessLog(t *testing.T) {
+	serve := func(options AccessLogOptions, clientIp string, request *gmhttp.Request) *recordingSink {
+		capabilities := resolveInstanceCapabilities(&minimalInstance{})
+		sink := &recordingSink{}
+		capabilities.logSinks.AddSink(sink, map[string]struct{}{logsink.KindAccess: {}})
+		_, err := capabilities.accessLog.AddOverride("", clientIp, time.Minute)
+		require.NoError(t, err)
+
+		server := &Server{ServerConfig: &ServerConfig{Name: "test"}}
+		server.ServerConfig.Options.AccessLogOptions = options
+
+		handler := server.wrapAccessLog(&BindPointConfig{InterfaceAddress: "127.0.0.1:443"}, capabilities, gmhttp.HandlerFunc(func(gmhttp.ResponseWriter, *gmhttp.Request) {}))
+		handler.ServeHTTP(gmhttptest.NewRecorder(), request)
+		return sink
+	}
+
+	newRequest := func(remoteAddr string) *gmhttp.Request {
+		request := gmhttptest.NewRequest("GET", "/things", nil)
+		request.RemoteAddr = remoteAddr
+		request.Header.Set("Authorization", "Bearer secret")
+		request.Header.Set("X-Api-Key", "secret")
+		request.Header.Set("zt-session", "secret")
+		request.Header.Set("X-Custom-Token", "secret")
+		request.Header.Set("Accept", "application/json")
+		return request
+	}
+
+	t.Run("credentials are redacted from verbose entries", func(t *testing.T) {
+		req := require.New(t)
+		options := AccessLogOptions{}
+		options.Default()
+
+		sink := serve(options, "", newRequest("10.0.0.1:1234"))
+		req.Len(sink.records, 1)
+
+		fields := sink.records[0].Fields
+		req.Equal(RedactedValue, fields["header.Authorization"])
+		req.Equal(RedactedValue, fields["header.X-Api-Key"])
+		req.Equal(RedactedValue, fields["header.Zt-Session"])
+		req.Equal("secret", fields["header.X-Custom-Token"])
+		req.Equal("application/json", fields["header.Accept"])
+	})
+
+	t.Run("configured headers are redacted in addition to the defaults", func(t *testing.T) {
+		req := require.New(t)
+		options := AccessLogOptions{}
+		options.Default()
+		req.NoError(options.Parse(map[interface{}]interface{}{
+			"accessLog": map[interface{}]interface{}{
+				"redactHeaders": []interface{}{"x-custom-token"},
+			},
+		}))
+
+		sink := serve(options, "", newRequest("10.0.0.1:1234"))
+		req.Len(sink.records, 1)
+
+		fields := sink.records[0].Fields
+		req.Equal(RedactedValue, fields["header.Authorization"])
+		req.Equal(RedactedValue, fields["header.X-Custom-Token"])
+	})
+
+	t.Run("overrides match client ips by address rather than by text", func(t *testing.T) {
+		req := require.New(t)
+		options := AccessLogOptions{}
+		options.Default()
+
+		req.Len(serve(options, "10.0.0.1", newRequest("[::ffff:10.0.0.1]:1234")).records, 1)
+		req.Len(serve(options, "::ffff:10.0.0.1", newRequest("10.0.0.1:1234")).records, 1)
+		req.Len(serve(options, "010.000.000.001", newRequest("10.0.0.1:1234")).records, 1)
+		req.Len(serve(options, "2001:db8::1", newRequest("[2001:0db8:0:0::1]:1234")).records, 1)
+		req.Empty(serve(options, "10.0.0.2", newRequest("10.0.0.1:1234")).records)
+	})
+}
+
+func Test_parseClientIp(t *testing.T) {
+	req := require.New(t)
+	req.True(net.ParseIP("10.0.0.1").Equal(parseClientIp("010.000.000.001")))
+	req.True(net.ParseIP("10.0.0.1").Equal(parseClientIp("::ffff:10.0.0.1")))
+	req.Nil(parseClientIp("10.0.0"))
+	req.Nil(parseClientIp("not an ip"))
+}
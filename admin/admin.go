@@ -0,0 +1,539 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package admin provides an xweb.ApiHandlerFactory that exposes administrative information and operations for the
+// xweb.Instance it was created for. It should only be bound to management interfaces.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/xweb/v2"
+	"github.com/openziti/xweb/v2/breaker"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Binding         = "xweb-admin"
+	DefaultRootPath = "/xweb-admin"
+)
+
+// Instance is the xweb.Instance the admin API exposes, with the capabilities it reports on and operates.
+// xweb.InstanceImpl satisfies it.
+type Instance interface {
+	xweb.MetricsInstance
+	xweb.AccessLogInstance
+	xweb.FeatureFlagsInstance
+	xweb.ConfigHistoryInstance
+	xweb.CircuitBreakerInstance
+	xweb.ApiControlInstance
+}
+
+// Authenticator decides whether a request may use the admin API. Requests for which it returns an error are answered
+// with http.StatusUnauthorized before any admin route is reached.
+type Authenticator func(request *gmhttp.Request) error
+
+// RequireClientCertificate returns an Authenticator that accepts requests whose TLS client certificate was verified,
+// which requires the server's clientAuth option to verify client certificates
+func RequireClientCertificate() Authenticator {
+	return func(request *gmhttp.Request) error {
+		if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 {
+			return errors.New("a verified client certificate is required")
+		}
+		return nil
+	}
+}
+
+// RequireBearerToken returns an Authenticator that accepts requests with an `Authorization: Bearer <token>` header
+// carrying token
+func RequireBearerToken(token string) Authenticator {
+	expected := []byte(token)
+
+	return func(request *gmhttp.Request) error {
+		authorization := request.Header.Get("Authorization")
+		provided := strings.TrimPrefix(authorization, "Bearer ")
+		if provided == authorization || token == "" || subtle.ConstantTimeCompare([]byte(provided), expected) != 1 {
+			return errors.New("a valid bearer token is required")
+		}
+		return nil
+	}
+}
+
+// AllowUnauthenticated is an Authenticator that accepts all requests. It must only be used when the admin API is bound
+// to an interface that is not reachable by untrusted clients.
+func AllowUnauthenticated(*gmhttp.Request) error {
+	return nil
+}
+
+// Factory generates admin Handler instances for a specific xweb.Instance
+type Factory struct {
+	instance      Instance
+	authenticator Authenticator
+}
+
+var _ xweb.ApiHandlerFactory = &Factory{}
+
+// NewFactory creates a new Factory that will expose information and operations for the supplied Instance to
+// requests accepted by authenticator. An Authenticator is required, AllowUnauthenticated must be passed explicitly to
+// serve the admin API without authentication.
+func NewFactory(instance Instance, authenticator Authenticator) *Factory {
+	return &Factory{
+		instance:      instance,
+		authenticator: authenticator,
+	}
+}
+
+// Binding returns the admin binding value
+func (factory *Factory) Binding() string {
+	return Binding
+}
+
+// New creates a new admin Handler. The option `path` may be used to alter the root path from DefaultRootPath.
+func (factory *Factory) New(_ *xweb.ServerConfig, options map[interface{}]interface{}) (xweb.ApiHandler, error) {
+	if factory.authenticator == nil {
+		return nil, errors.New("no authenticator configured for the admin api")
+	}
+
+	handler := &Handler{
+		instance:      factory.instance,
+		authenticator: factory.authenticator,
+		options:       options,
+		rootPath:      DefaultRootPath,
+	}
+
+	if pathInterface, ok := options["path"]; ok {
+		if path, ok := pathInterface.(string); ok && path != "" {
+			handler.rootPath = "/" + strings.Trim(path, "/")
+		} else {
+			return nil, errors.New("path must be a non-empty string if specified")
+		}
+	}
+
+	handler.mux = gmhttp.NewServeMux()
+	handler.mux.HandleFunc(handler.rootPath+"/config", handler.getConfig)
+	handler.mux.HandleFunc(handler.rootPath+"/config/effective", handler.getEffectiveConfig)
+	handler.mux.HandleFunc(handler.rootPath+"/config/revisions", handler.getConfigRevisions)
+	handler.mux.HandleFunc(handler.rootPath+"/config/revisions/", handler.getConfigRevision)
+	handler.mux.HandleFunc(handler.rootPath+"/config/diff", handler.getConfigDiff)
+	handler.mux.HandleFunc(handler.rootPath+"/metrics", handler.getMetrics)
+	handler.mux.HandleFunc(handler.rootPath+"/apis", handler.getApis)
+	handler.mux.HandleFunc(handler.rootPath+"/apis/", handler.updateApi)
+	handler.mux.HandleFunc(handler.rootPath+"/access-log/overrides", handler.accessLogOverrides)
+	handler.mux.HandleFunc(handler.rootPath+"/access-log/overrides/", handler.deleteAccessLogOverride)
+	handler.mux.HandleFunc(handler.rootPath+"/feature-flags", handler.getFeatureFlags)
+	handler.mux.HandleFunc(handler.rootPath+"/feature-flags/", handler.updateFeatureFlag)
+	handler.mux.HandleFunc(handler.rootPath+"/circuit-breakers", handler.getCircuitBreakers)
+	handler.mux.HandleFunc(handler.rootPath+"/circuit-breakers/", handler.resetCircuitBreaker)
+
+	return handler, nil
+}
+
+// Validate performs no factory level validation
+func (factory *Factory) Validate(_ *xweb.InstanceConfig) error {
+	return nil
+}
+
+// Handler is the xweb.ApiHandler that serves admin requests
+type Handler struct {
+	instance      Instance
+	authenticator Authenticator
+	options       map[interface{}]interface{}
+	rootPath      string
+	mux           *gmhttp.ServeMux
+}
+
+var _ xweb.ApiHandler = &Handler{}
+
+func (handler *Handler) Binding() string {
+	return Binding
+}
+
+func (handler *Handler) Options() map[interface{}]interface{} {
+	return handler.options
+}
+
+func (handler *Handler) RootPath() string {
+	return handler.rootPath
+}
+
+func (handler *Handler) IsHandler(r *gmhttp.Request) bool {
+	return r.URL.Path == handler.rootPath || strings.HasPrefix(r.URL.Path, handler.rootPath+"/")
+}
+
+func (handler *Handler) ServeHTTP(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if err := handler.authenticator(request); err != nil {
+		pfxlog.Logger().WithField("remoteAddr", request.RemoteAddr).Warnf("rejected unauthenticated admin request for %s: %v", request.URL.Path, err)
+		writeError(writer, gmhttp.StatusUnauthorized, err)
+		return
+	}
+
+	handler.mux.ServeHTTP(writer, request)
+}
+
+// getConfig responds with the redacted source configuration, see xweb.InstanceConfig.MarshalSafe
+func (handler *Handler) getConfig(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if !requireMethod(writer, request, gmhttp.MethodGet) {
+		return
+	}
+
+	body, err := handler.instance.GetConfig().MarshalSafe()
+
+	if err != nil {
+		writeError(writer, gmhttp.StatusInternalServerError, err)
+		return
+	}
+
+	writeJsonBytes(writer, gmhttp.StatusOK, body)
+}
+
+// getEffectiveConfig responds with the resolved configuration and runtime state, see xweb.InstanceImpl.EffectiveConfig
+func (handler *Handler) getEffectiveConfig(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if !requireMethod(writer, request, gmhttp.MethodGet) {
+		return
+	}
+
+	writeJson(writer, gmhttp.StatusOK, handler.instance.EffectiveConfig())
+}
+
+// getConfigRevisions responds with the retained config revisions without their configuration, see
+// xweb.ConfigHistory
+func (handler *Handler) getConfigRevisions(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if !requireMethod(writer, request, gmhttp.MethodGet) {
+		return
+	}
+
+	writeJson(writer, gmhttp.StatusOK, handler.instance.GetConfigHistory().Revisions())
+}
+
+// getConfigRevision handles GET <root>/config/revisions/<revision>
+func (handler *Handler) getConfigRevision(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if !requireMethod(writer, request, gmhttp.MethodGet) {
+		return
+	}
+
+	revisionStr := strings.Trim(strings.TrimPrefix(request.URL.Path, handler.rootPath+"/config/revisions/"), "/")
+	revisionNumber, err := strconv.ParseUint(revisionStr, 10, 64)
+	if err != nil {
+		writeError(writer, gmhttp.StatusBadRequest, fmt.Errorf("invalid revision [%s]", revisionStr))
+		return
+	}
+
+	revision := handler.instance.GetConfigHistory().Get(revisionNumber)
+	if revision == nil {
+		writeError(writer, gmhttp.StatusNotFound, fmt.Errorf("config revision [%d] not found", revisionNumber))
+		return
+	}
+
+	writeJson(writer, gmhttp.StatusOK, revision)
+}
+
+// getConfigDiff responds with the changes between two config revisions given by the query parameters `from` and
+// `to`. The latest revision is used if `to` is not given and the revision before `to` if `from` is not given.
+func (handler *Handler) getConfigDiff(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if !requireMethod(writer, request, gmhttp.MethodGet) {
+		return
+	}
+
+	history := handler.instance.GetConfigHistory()
+	latest := history.Latest()
+	if latest == nil {
+		writeError(writer, gmhttp.StatusNotFound, errors.New("no config revisions recorded"))
+		return
+	}
+
+	query := request.URL.Query()
+
+	to := latest.Revision
+	if toStr := query.Get("to"); toStr != "" {
+		var err error
+		if to, err = strconv.ParseUint(toStr, 10, 64); err != nil {
+			writeError(writer, gmhttp.StatusBadRequest, fmt.Errorf("invalid to [%s]", toStr))
+			return
+		}
+	}
+
+	from := to - 1
+	if fromStr := query.Get("from"); fromStr != "" {
+		var err error
+		if from, err = strconv.ParseUint(fromStr, 10, 64); err != nil {
+			writeError(writer, gmhttp.StatusBadRequest, fmt.Errorf("invalid from [%s]", fromStr))
+			return
+		}
+	}
+
+	diff, err := history.Diff(from, to)
+	if err != nil {
+		writeError(writer, gmhttp.StatusNotFound, err)
+		return
+	}
+
+	writeJson(writer, gmhttp.StatusOK, diff)
+}
+
+// getMetrics responds with a snapshot of the instance's metrics.Registry
+func (handler *Handler) getMetrics(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if !requireMethod(writer, request, gmhttp.MethodGet) {
+		return
+	}
+
+	writeJson(writer, gmhttp.StatusOK, handler.instance.GetMetrics().Snapshot())
+}
+
+// getApis responds with the runtime state of all api instances
+func (handler *Handler) getApis(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if !requireMethod(writer, request, gmhttp.MethodGet) {
+		return
+	}
+
+	writeJson(writer, gmhttp.StatusOK, handler.instance.GetApiStates())
+}
+
+// updateApi handles POST <root>/apis/<server>/<name>/(disable|enable). Disable accepts an optional `retryAfter`
+// query parameter as a duration (e.g. 30s, 5m).
+func (handler *Handler) updateApi(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if !requireMethod(writer, request, gmhttp.MethodPost) {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, handler.rootPath+"/apis/"), "/"), "/")
+
+	if len(parts) != 3 {
+		writeError(writer, gmhttp.StatusNotFound, errors.New("expected path <server>/<api>/(disable|enable)"))
+		return
+	}
+
+	serverName, apiName, operation := parts[0], parts[1], parts[2]
+
+	var err error
+	switch operation {
+	case "disable":
+		retryAfter := time.Duration(0)
+		if retryAfterStr := request.URL.Query().Get("retryAfter"); retryAfterStr != "" {
+			if retryAfter, err = time.ParseDuration(retryAfterStr); err != nil {
+				writeError(writer, gmhttp.StatusBadRequest, fmt.Errorf("invalid retryAfter: %v", err))
+				return
+			}
+		}
+		err = handler.instance.DisableApi(serverName, apiName, retryAfter)
+	case "enable":
+		err = handler.instance.EnableApi(serverName, apiName)
+	default:
+		writeError(writer, gmhttp.StatusNotFound, fmt.Errorf("unknown operation [%s]", operation))
+		return
+	}
+
+	if err != nil {
+		writeError(writer, gmhttp.StatusNotFound, err)
+		return
+	}
+
+	writeJson(writer, gmhttp.StatusOK, handler.instance.GetApiStates())
+}
+
+// accessLogOverrides lists overrides on GET and creates one on POST. POST accepts the query parameters `binding`,
+// `clientIp`, and `duration` (required, e.g. 15m).
+func (handler *Handler) accessLogOverrides(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if !requireMethod(writer, request, gmhttp.MethodGet, gmhttp.MethodPost) {
+		return
+	}
+
+	controller := handler.instance.GetAccessLogController()
+
+	if request.Method == gmhttp.MethodGet {
+		writeJson(writer, gmhttp.StatusOK, controller.Overrides())
+		return
+	}
+
+	query := request.URL.Query()
+	duration, err := time.ParseDuration(query.Get("duration"))
+
+	if err != nil {
+		writeError(writer, gmhttp.StatusBadRequest, fmt.Errorf("invalid duration: %v", err))
+		return
+	}
+
+	override, err := controller.AddOverride(query.Get("binding"), query.Get("clientIp"), duration)
+
+	if err != nil {
+		writeError(writer, gmhttp.StatusBadRequest, err)
+		return
+	}
+
+	handler.instance.GetLogSinks().Audit("accessLog.override.add", map[string]interface{}{
+		"id":         override.Id,
+		"binding":    override.Binding,
+		"clientIp":   override.ClientIp,
+		"until":      override.Until.String(),
+		"remoteAddr": request.RemoteAddr,
+	})
+	handler.instance.RecordConfigRevision("accessLog.override.add")
+
+	writeJson(writer, gmhttp.StatusCreated, override)
+}
+
+// deleteAccessLogOverride handles DELETE <root>/access-log/overrides/<id>
+func (handler *Handler) deleteAccessLogOverride(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if !requireMethod(writer, request, gmhttp.MethodDelete) {
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(request.URL.Path, handler.rootPath+"/access-log/overrides/"), "/")
+
+	if !handler.instance.GetAccessLogController().RemoveOverride(id) {
+		writeError(writer, gmhttp.StatusNotFound, fmt.Errorf("override [%s] not found", id))
+		return
+	}
+
+	handler.instance.GetLogSinks().Audit("accessLog.override.remove", map[string]interface{}{
+		"id":         id,
+		"remoteAddr": request.RemoteAddr,
+	})
+	handler.instance.RecordConfigRevision("accessLog.override.remove")
+
+	writer.WriteHeader(gmhttp.StatusNoContent)
+}
+
+// getFeatureFlags responds with the current feature flags
+func (handler *Handler) getFeatureFlags(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if !requireMethod(writer, request, gmhttp.MethodGet) {
+		return
+	}
+
+	writeJson(writer, gmhttp.StatusOK, handler.instance.GetFeatureFlags().Values())
+}
+
+// updateFeatureFlag handles POST <root>/feature-flags/<name>/(enable|disable)
+func (handler *Handler) updateFeatureFlag(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if !requireMethod(writer, request, gmhttp.MethodPost) {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, handler.rootPath+"/feature-flags/"), "/"), "/")
+
+	if len(parts) != 2 || parts[0] == "" {
+		writeError(writer, gmhttp.StatusNotFound, errors.New("expected path <name>/(enable|disable)"))
+		return
+	}
+
+	name, operation := parts[0], parts[1]
+
+	var enabled bool
+	switch operation {
+	case "enable":
+		enabled = true
+	case "disable":
+		enabled = false
+	default:
+		writeError(writer, gmhttp.StatusNotFound, fmt.Errorf("unknown operation [%s]", operation))
+		return
+	}
+
+	featureFlags := handler.instance.GetFeatureFlags()
+	featureFlags.Set(name, enabled)
+
+	handler.instance.GetLogSinks().Audit("featureFlag.set", map[string]interface{}{
+		"name":       name,
+		"enabled":    enabled,
+		"remoteAddr": request.RemoteAddr,
+	})
+	handler.instance.RecordConfigRevision("featureFlag.set")
+
+	writeJson(writer, gmhttp.StatusOK, featureFlags.Values())
+}
+
+// getCircuitBreakers responds with the status of the instance's circuit breakers
+func (handler *Handler) getCircuitBreakers(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if !requireMethod(writer, request, gmhttp.MethodGet) {
+		return
+	}
+
+	statuses := handler.instance.GetCircuitBreakers().Statuses()
+	if statuses == nil {
+		statuses = []*breaker.Status{}
+	}
+	writeJson(writer, gmhttp.StatusOK, statuses)
+}
+
+// resetCircuitBreaker handles POST <root>/circuit-breakers/<name>/reset
+func (handler *Handler) resetCircuitBreaker(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if !requireMethod(writer, request, gmhttp.MethodPost) {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, handler.rootPath+"/circuit-breakers/"), "/"), "/")
+
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "reset" {
+		writeError(writer, gmhttp.StatusNotFound, errors.New("expected path <name>/reset"))
+		return
+	}
+
+	circuitBreaker, ok := handler.instance.GetCircuitBreakers().Lookup(parts[0])
+	if !ok {
+		writeError(writer, gmhttp.StatusNotFound, fmt.Errorf("no circuit breaker found for dependency [%s]", parts[0]))
+		return
+	}
+
+	circuitBreaker.Reset()
+
+	handler.instance.GetLogSinks().Audit("circuitBreaker.reset", map[string]interface{}{
+		"dependency": parts[0],
+		"remoteAddr": request.RemoteAddr,
+	})
+
+	writeJson(writer, gmhttp.StatusOK, circuitBreaker.Status())
+}
+
+func requireMethod(writer gmhttp.ResponseWriter, request *gmhttp.Request, methods ...string) bool {
+	for _, method := range methods {
+		if request.Method == method {
+			return true
+		}
+	}
+
+	writer.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(writer, gmhttp.StatusMethodNotAllowed, errors.New("method not allowed"))
+	return false
+}
+
+func writeJson(writer gmhttp.ResponseWriter, status int, value interface{}) {
+	body, err := json.MarshalIndent(value, "", "  ")
+
+	if err != nil {
+		pfxlog.Logger().Errorf("could not marshal admin response: %v", err)
+		writer.WriteHeader(gmhttp.StatusInternalServerError)
+		return
+	}
+
+	writeJsonBytes(writer, status, body)
+}
+
+func writeJsonBytes(writer gmhttp.ResponseWriter, status int, body []byte) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_, _ = writer.Write(body)
+}
+
+func writeError(writer gmhttp.ResponseWriter, status int, err error) {
+	writeJson(writer, status, map[string]string{
+		"error": err.Error(),
+	})
+}
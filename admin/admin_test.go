@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"gitee.com/zhaochuninhefei/gmgo/x509"
+	"github.com/openziti/xweb/v2"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_Authentication(t *testing.T) {
+	serve := func(req *require.Assertions, authenticator Authenticator, request *gmhttp.Request) *gmhttptest.ResponseRecorder {
+		handler, err := NewFactory(nil, authenticator).New(nil, map[interface{}]interface{}{})
+		req.NoError(err)
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	t.Run("an authenticator is required", func(t *testing.T) {
+		req := require.New(t)
+		_, err := NewFactory(nil, nil).New(nil, map[interface{}]interface{}{})
+		req.Error(err)
+	})
+
+	t.Run("bearer tokens are checked", func(t *testing.T) {
+		req := require.New(t)
+		authenticator := RequireBearerToken("s3cret")
+
+		request := gmhttptest.NewRequest("DELETE", DefaultRootPath+"/config", nil)
+		req.Equal(gmhttp.StatusUnauthorized, serve(req, authenticator, request).Code)
+
+		request.Header.Set("Authorization", "Bearer wrong")
+		req.Equal(gmhttp.StatusUnauthorized, serve(req, authenticator, request).Code)
+
+		request.Header.Set("Authorization", "Bearer s3cret")
+		req.Equal(gmhttp.StatusMethodNotAllowed, serve(req, authenticator, request).Code)
+	})
+
+	t.Run("empty bearer tokens are never accepted", func(t *testing.T) {
+		req := require.New(t)
+
+		request := gmhttptest.NewRequest("DELETE", DefaultRootPath+"/config", nil)
+		request.Header.Set("Authorization", "Bearer ")
+		req.Equal(gmhttp.StatusUnauthorized, serve(req, RequireBearerToken(""), request).Code)
+	})
+
+	t.Run("client certificates must be verified", func(t *testing.T) {
+		req := require.New(t)
+		authenticator := RequireClientCertificate()
+
+		request := gmhttptest.NewRequest("DELETE", DefaultRootPath+"/config", nil)
+		req.Equal(gmhttp.StatusUnauthorized, serve(req, authenticator, request).Code)
+
+		request.TLS = &gmtls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{}}},
+		}
+		req.Equal(gmhttp.StatusMethodNotAllowed, serve(req, authenticator, request).Code)
+	})
+
+	t.Run("unauthenticated access must be allowed explicitly", func(t *testing.T) {
+		req := require.New(t)
+
+		request := gmhttptest.NewRequest("DELETE", DefaultRootPath+"/config", nil)
+		req.Equal(gmhttp.StatusMethodNotAllowed, serve(req, AllowUnauthenticated, request).Code)
+	})
+	t.Run("feature flags are only changed by authenticated requests", func(t *testing.T) {
+		req := require.New(t)
+		instance := xweb.NewDefaultInstance(xweb.NewRegistryMap(), nil)
+		handler, err := NewFactory(instance, RequireBearerToken("s3cret")).New(nil, map[interface{}]interface{}{})
+		req.NoError(err)
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("POST", DefaultRootPath+"/feature-flags/beta/enable", nil))
+		req.Equal(gmhttp.StatusUnauthorized, recorder.Code)
+		req.False(instance.GetFeatureFlags().Enabled("beta"))
+
+		request := gmhttptest.NewRequest("POST", DefaultRootPath+"/feature-flags/beta/enable", nil)
+		request.Header.Set("Authorization", "Bearer s3cret")
+		recorder = gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.True(instance.GetFeatureFlags().Enabled("beta"))
+		req.Equal("featureFlag.set", instance.GetConfigHistory().Latest().Reason)
+		req.Equal(map[string]bool{"beta": true}, instance.GetConfigHistory().Latest().Config.FeatureFlags)
+	})
+}
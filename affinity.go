@@ -0,0 +1,320 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/openziti/xweb/v2/metrics"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultAffinityCookieName = "xweb_affinity"
+	DefaultAffinityTtl        = time.Hour
+
+	MetricAffinityIssued   = "xweb.affinity.issued"
+	MetricAffinityValid    = "xweb.affinity.valid"
+	MetricAffinityInvalid  = "xweb.affinity.invalid"
+	MetricAffinityExpired  = "xweb.affinity.expired"
+	MetricAffinityMismatch = "xweb.affinity.mismatch"
+
+	affinityContextKey = ContextKey("xweb.affinity.ContextKey")
+)
+
+// AffinityMismatchAction is the action taken when a request presents a valid affinity cookie issued by another
+// instance
+type AffinityMismatchAction string
+
+const (
+	// AffinityMismatchIgnore serves the request and re-issues the cookie for this instance
+	AffinityMismatchIgnore AffinityMismatchAction = "ignore"
+
+	// AffinityMismatchReject responds with http.StatusMisdirectedRequest and closes the connection so that the client
+	// reconnects, giving an L4 load balancer the chance to route it to the instance that issued the cookie
+	AffinityMismatchReject AffinityMismatchAction = "reject"
+)
+
+// AffinityOptions represents the options for issuing signed session affinity cookies. Cookies identify the xweb
+// instance that issued them and are validated on every request so that stateful handlers can detect when a client
+// has been routed to a different instance.
+type AffinityOptions struct {
+	AffinityEnabled    bool
+	AffinityCookieName string
+	AffinityInstanceId string
+	AffinitySecret     []byte
+	AffinityTtl        time.Duration
+	AffinityOnMismatch AffinityMismatchAction
+}
+
+// Default provides defaults for all necessary values
+func (options *AffinityOptions) Default() {
+	options.AffinityEnabled = false
+	options.AffinityCookieName = DefaultAffinityCookieName
+	options.AffinityInstanceId = ""
+	options.AffinitySecret = nil
+	options.AffinityTtl = DefaultAffinityTtl
+	options.AffinityOnMismatch = AffinityMismatchIgnore
+}
+
+// Parse parses a configuration map
+func (options *AffinityOptions) Parse(config map[interface{}]interface{}) error {
+	affinityInterface, ok := config["affinity"]
+
+	if !ok {
+		return nil
+	}
+
+	affinityMap, ok := affinityInterface.(map[interface{}]interface{})
+
+	if !ok {
+		return errors.New("could not use value for affinity, not a map")
+	}
+
+	options.AffinityEnabled = true
+
+	if interfaceVal, ok := affinityMap["enabled"]; ok {
+		if enabled, ok := interfaceVal.(bool); ok {
+			options.AffinityEnabled = enabled
+		} else {
+			return errors.New("could not use value for affinity.enabled, not a boolean")
+		}
+	}
+
+	for field, target := range map[string]*string{"cookieName": &options.AffinityCookieName, "instanceId": &options.AffinityInstanceId} {
+		if interfaceVal, ok := affinityMap[field]; ok {
+			if value, ok := interfaceVal.(string); ok {
+				*target = value
+			} else {
+				return fmt.Errorf("could not use value for affinity.%s, not a string", field)
+			}
+		}
+	}
+
+	if interfaceVal, ok := affinityMap["secret"]; ok {
+		if secret, ok := interfaceVal.(string); ok {
+			options.AffinitySecret = []byte(secret)
+		} else {
+			return errors.New("could not use value for affinity.secret, not a string")
+		}
+	}
+
+	if interfaceVal, ok := affinityMap["ttl"]; ok {
+		if ttlStr, ok := interfaceVal.(string); ok {
+			if ttl, err := time.ParseDuration(ttlStr); err == nil {
+				options.AffinityTtl = ttl
+			} else {
+				return fmt.Errorf("could not parse affinity.ttl %s as a duration (e.g. 1h): %v", ttlStr, err)
+			}
+		} else {
+			return errors.New("could not use value for affinity.ttl, not a string")
+		}
+	}
+
+	if interfaceVal, ok := affinityMap["onMismatch"]; ok {
+		if onMismatch, ok := interfaceVal.(string); ok {
+			options.AffinityOnMismatch = AffinityMismatchAction(onMismatch)
+		} else {
+			return errors.New("could not use value for affinity.onMismatch, not a string")
+		}
+	}
+
+	if options.AffinityEnabled && options.AffinityInstanceId == "" {
+		if hostname, err := os.Hostname(); err == nil {
+			options.AffinityInstanceId = hostname
+		}
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (options *AffinityOptions) Validate() error {
+	if !options.AffinityEnabled {
+		return nil
+	}
+
+	if len(options.AffinitySecret) < 16 {
+		return errors.New("affinity.secret must be at least 16 bytes")
+	}
+
+	if options.AffinityInstanceId == "" || strings.Contains(options.AffinityInstanceId, "|") {
+		return errors.New("affinity.instanceId must not be empty or contain '|'")
+	}
+
+	if options.AffinityCookieName == "" {
+		return errors.New("affinity.cookieName must not be empty")
+	}
+
+	if options.AffinityTtl <= 0 {
+		return fmt.Errorf("value [%s] for affinity.ttl too low, must be positive", options.AffinityTtl)
+	}
+
+	if options.AffinityOnMismatch != AffinityMismatchIgnore && options.AffinityOnMismatch != AffinityMismatchReject {
+		return fmt.Errorf("invalid affinity.onMismatch [%s], must be one of: %s, %s", options.AffinityOnMismatch, AffinityMismatchIgnore, AffinityMismatchReject)
+	}
+
+	return nil
+}
+
+// Affinity describes the affinity cookie state of a request
+type Affinity struct {
+	// InstanceId is the instance the client is bound to after this request
+	InstanceId string
+
+	// Presented is true if the request carried a valid, unexpired affinity cookie
+	Presented bool
+
+	// Mismatched is true if the presented cookie was issued by another instance
+	Mismatched bool
+
+	// PresentedInstanceId is the instance that issued the presented cookie
+	PresentedInstanceId string
+
+	Expires time.Time
+}
+
+// AffinityFromContext returns the Affinity of a request, or nil if affinity is not enabled for its server
+func AffinityFromContext(ctx context.Context) *Affinity {
+	if affinity, ok := ctx.Value(affinityContextKey).(*Affinity); ok {
+		return affinity
+	}
+	return nil
+}
+
+func (options *AffinityOptions) sign(payload string) string {
+	mac := hmac.New(sha256.New, options.AffinitySecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newCookieValue returns a signed cookie value of the form: base64(instanceId|expiresUnix).signature
+func (options *AffinityOptions) newCookieValue(expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(options.AffinityInstanceId + "|" + strconv.FormatInt(expires.Unix(), 10)))
+	return payload + "." + options.sign(payload)
+}
+
+var (
+	errAffinityInvalid = errors.New("invalid affinity cookie")
+	errAffinityExpired = errors.New("expired affinity cookie")
+)
+
+// parseCookieValue verifies a cookie value and returns the issuing instance id and expiry
+func (options *AffinityOptions) parseCookieValue(value string, now time.Time) (string, time.Time, error) {
+	payload, signature, found := strings.Cut(value, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(options.sign(payload))) {
+		return "", time.Time{}, errAffinityInvalid
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", time.Time{}, errAffinityInvalid
+	}
+
+	instanceId, expiresStr, found := strings.Cut(string(decoded), "|")
+	if !found {
+		return "", time.Time{}, errAffinityInvalid
+	}
+
+	expiresUnix, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		return "", time.Time{}, errAffinityInvalid
+	}
+
+	expires := time.Unix(expiresUnix, 0)
+	if !now.Before(expires) {
+		return instanceId, expires, errAffinityExpired
+	}
+
+	return instanceId, expires, nil
+}
+
+// wrapAffinity wraps the demux http.Handler with affinity cookie validation and issuance
+func (server *Server) wrapAffinity(registry metrics.Registry, handler gmhttp.Handler) gmhttp.Handler {
+	options := &server.ServerConfig.Options.AffinityOptions
+
+	if !options.AffinityEnabled {
+		return handler
+	}
+
+	labels := metrics.Labels{"server": server.ServerConfig.Name}
+	issued := registry.Counter(MetricAffinityIssued, labels)
+	valid := registry.Counter(MetricAffinityValid, labels)
+	invalid := registry.Counter(MetricAffinityInvalid, labels)
+	expired := registry.Counter(MetricAffinityExpired, labels)
+	mismatch := registry.Counter(MetricAffinityMismatch, labels)
+
+	return gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		now := time.Now()
+		affinity := &Affinity{
+			InstanceId: options.AffinityInstanceId,
+		}
+
+		if cookie, err := request.Cookie(options.AffinityCookieName); err == nil {
+			instanceId, expires, err := options.parseCookieValue(cookie.Value, now)
+
+			switch {
+			case errors.Is(err, errAffinityExpired):
+				expired.Inc(1)
+			case err != nil:
+				invalid.Inc(1)
+			case instanceId != options.AffinityInstanceId:
+				mismatch.Inc(1)
+				affinity.Presented = true
+				affinity.Mismatched = true
+				affinity.PresentedInstanceId = instanceId
+				affinity.Expires = expires
+			default:
+				valid.Inc(1)
+				affinity.Presented = true
+				affinity.PresentedInstanceId = instanceId
+				affinity.Expires = expires
+			}
+		}
+
+		if affinity.Mismatched && options.AffinityOnMismatch == AffinityMismatchReject {
+			writer.Header().Set("Connection", "close")
+			WriteError(writer, request, gmhttp.StatusMisdirectedRequest, fmt.Errorf("affinity cookie issued by instance [%s]", affinity.PresentedInstanceId), nil)
+			return
+		}
+
+		if !affinity.Presented || affinity.Mismatched {
+			affinity.Expires = now.Add(options.AffinityTtl)
+			issued.Inc(1)
+			gmhttp.SetCookie(writer, &gmhttp.Cookie{
+				Name:     options.AffinityCookieName,
+				Value:    options.newCookieValue(affinity.Expires),
+				Path:     "/",
+				Expires:  affinity.Expires,
+				MaxAge:   int(options.AffinityTtl / time.Second),
+				Secure:   true,
+				HttpOnly: true,
+				SameSite: gmhttp.SameSiteLaxMode,
+			})
+		}
+
+		handler.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), affinityContextKey, affinity)))
+	})
+}
@@ -0,0 +1,93 @@
+package xweb
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/openziti/xweb/v2/metrics"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newAffinityTestServer(instanceId string, onMismatch AffinityMismatchAction) *Server {
+	serverConfig := &ServerConfig{Name: "test"}
+	serverConfig.Options.Default()
+	serverConfig.Options.AffinityEnabled = true
+	serverConfig.Options.AffinityInstanceId = instanceId
+	serverConfig.Options.AffinitySecret = []byte("0123456789abcdef")
+	serverConfig.Options.AffinityOnMismatch = onMismatch
+
+	return &Server{ServerConfig: serverConfig}
+}
+
+func TestServer_wrapAffinity(t *testing.T) {
+	var lastAffinity *Affinity
+	inner := gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		lastAffinity = AffinityFromContext(request.Context())
+	})
+
+	serve := func(handler gmhttp.Handler, cookie *gmhttp.Cookie) *gmhttptest.ResponseRecorder {
+		request := gmhttptest.NewRequest("GET", "/", nil)
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	issuedCookie := func(req *require.Assertions, recorder *gmhttptest.ResponseRecorder) *gmhttp.Cookie {
+		cookies := recorder.Result().Cookies()
+		req.Len(cookies, 1)
+		req.Equal(DefaultAffinityCookieName, cookies[0].Name)
+		return cookies[0]
+	}
+
+	t.Run("issues and accepts cookies", func(t *testing.T) {
+		req := require.New(t)
+		handler := newAffinityTestServer("a", AffinityMismatchIgnore).wrapAffinity(metrics.NewRegistry(), inner)
+
+		cookie := issuedCookie(req, serve(handler, nil))
+		req.False(lastAffinity.Presented)
+
+		recorder := serve(handler, cookie)
+		req.Empty(recorder.Result().Cookies())
+		req.True(lastAffinity.Presented)
+		req.False(lastAffinity.Mismatched)
+		req.Equal("a", lastAffinity.PresentedInstanceId)
+	})
+
+	t.Run("reissues tampered cookies", func(t *testing.T) {
+		req := require.New(t)
+		registry := metrics.NewRegistry()
+		handler := newAffinityTestServer("a", AffinityMismatchIgnore).wrapAffinity(registry, inner)
+
+		cookie := issuedCookie(req, serve(handler, nil))
+		cookie.Value = "x" + cookie.Value
+
+		issuedCookie(req, serve(handler, cookie))
+		req.False(lastAffinity.Presented)
+		req.Equal(int64(1), registry.Counter(MetricAffinityInvalid, metrics.Labels{"server": "test"}).Count())
+	})
+
+	t.Run("rejects cookies from other instances when configured", func(t *testing.T) {
+		req := require.New(t)
+		other := newAffinityTestServer("b", AffinityMismatchIgnore).wrapAffinity(metrics.NewRegistry(), inner)
+		handler := newAffinityTestServer("a", AffinityMismatchReject).wrapAffinity(metrics.NewRegistry(), inner)
+
+		cookie := issuedCookie(req, serve(other, nil))
+		lastAffinity = nil
+
+		recorder := serve(handler, cookie)
+		req.Equal(gmhttp.StatusMisdirectedRequest, recorder.Code)
+		req.Nil(lastAffinity)
+	})
+
+	t.Run("expired cookies are not accepted", func(t *testing.T) {
+		req := require.New(t)
+		options := &newAffinityTestServer("a", AffinityMismatchIgnore).ServerConfig.Options.AffinityOptions
+		value := options.newCookieValue(time.Now().Add(-time.Minute))
+		_, _, err := options.parseCookieValue(value, time.Now())
+		req.ErrorIs(err, errAffinityExpired)
+	})
+}
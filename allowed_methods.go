@@ -0,0 +1,75 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// parseAllowedMethods parses a list of method names as upper case, de-duplicated methods
+func parseAllowedMethods(value interface{}) ([]string, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("could not use value for allowedMethods, not a list")
+	}
+
+	var result []string
+	for _, entry := range list {
+		method, ok := entry.(string)
+		if !ok {
+			return nil, fmt.Errorf("could not use value [%v] for allowedMethods, not a string", entry)
+		}
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if !containsMethod(result, method) {
+			result = append(result, method)
+		}
+	}
+
+	return result, nil
+}
+
+// validateAllowedMethods checks that methods are tokens
+func validateAllowedMethods(methods []string) error {
+	for _, method := range methods {
+		if !isHeaderToken(method) {
+			return fmt.Errorf("invalid allowed method [%s]", method)
+		}
+	}
+	return nil
+}
+
+// wrapAllowedMethods wraps a http.Handler with another http.Handler that answers requests with methods that are not in
+// methods with http.StatusMethodNotAllowed and an Allow header. All methods are allowed if methods is empty.
+func wrapAllowedMethods(methods []string, handler gmhttp.Handler) gmhttp.Handler {
+	if len(methods) == 0 {
+		return handler
+	}
+
+	allow := allowHeader(methods)
+
+	return gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		if !containsMethod(methods, request.Method) {
+			writer.Header().Set("Allow", allow)
+			WriteError(writer, request, gmhttp.StatusMethodNotAllowed, fmt.Errorf("method [%s] is not allowed", request.Method), nil)
+			return
+		}
+		handler.ServeHTTP(writer, request)
+	})
+}
@@ -0,0 +1,80 @@
+package xweb
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestAllowedMethods(t *testing.T) {
+	parse := func(config map[interface{}]interface{}) (*BindPointConfig, error) {
+		config["interface"] = "127.0.0.1:443"
+		config["address"] = "localhost:443"
+		bindPoint := &BindPointConfig{}
+		if err := bindPoint.Parse(config); err != nil {
+			return nil, err
+		}
+		return bindPoint, bindPoint.Validate()
+	}
+
+	t.Run("allowed methods are parsed as upper case and de-duplicated", func(t *testing.T) {
+		req := require.New(t)
+		bindPoint, err := parse(map[interface{}]interface{}{"allowedMethods": []interface{}{"get", "POST", "Get"}})
+		req.NoError(err)
+		req.Equal([]string{"GET", "POST"}, bindPoint.AllowedMethods)
+	})
+
+	t.Run("invalid allowed methods are rejected", func(t *testing.T) {
+		req := require.New(t)
+		_, err := parse(map[interface{}]interface{}{"allowedMethods": "GET"})
+		req.EqualError(err, "could not use value for allowedMethods, not a list")
+
+		_, err = parse(map[interface{}]interface{}{"allowedMethods": []interface{}{1}})
+		req.EqualError(err, "could not use value [1] for allowedMethods, not a string")
+
+		_, err = parse(map[interface{}]interface{}{"allowedMethods": []interface{}{"GET POST"}})
+		req.EqualError(err, "invalid allowed method [GET POST]")
+	})
+
+	t.Run("presets may restrict methods and be overridden", func(t *testing.T) {
+		req := require.New(t)
+		bindPoint, err := parse(map[interface{}]interface{}{"preset": BindPointPresetHardenedPublic})
+		req.NoError(err)
+		req.Contains(bindPoint.AllowedMethods, gmhttp.MethodGet)
+		req.NotContains(bindPoint.AllowedMethods, gmhttp.MethodTrace)
+
+		bindPoint, err = parse(map[interface{}]interface{}{"preset": BindPointPresetHardenedPublic, "allowedMethods": []interface{}{"GET"}})
+		req.NoError(err)
+		req.Equal([]string{"GET"}, bindPoint.AllowedMethods)
+		req.Len(BindPointPresets[BindPointPresetHardenedPublic].AllowedMethods, 7)
+	})
+
+	t.Run("methods that are not allowed are rejected before dispatch", func(t *testing.T) {
+		req := require.New(t)
+		served := 0
+		handler := wrapAllowedMethods([]string{"POST", "GET"}, gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			served++
+		}))
+
+		for _, method := range []string{gmhttp.MethodTrace, gmhttp.MethodConnect, "PROPFIND"} {
+			recorder := gmhttptest.NewRecorder()
+			handler.ServeHTTP(recorder, gmhttptest.NewRequest(method, "/things", nil))
+			req.Equal(gmhttp.StatusMethodNotAllowed, recorder.Code, method)
+			req.Equal("GET, POST", recorder.Header().Get("Allow"))
+		}
+		req.Zero(served)
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest(gmhttp.MethodGet, "/things", nil))
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal(1, served)
+	})
+
+	t.Run("all methods are allowed without an allow list", func(t *testing.T) {
+		req := require.New(t)
+		recorder := gmhttptest.NewRecorder()
+		wrapAllowedMethods(nil, gmhttp.NotFoundHandler()).ServeHTTP(recorder, gmhttptest.NewRequest(gmhttp.MethodTrace, "/things", nil))
+		req.Equal(gmhttp.StatusNotFound, recorder.Code)
+	})
+}
@@ -38,6 +38,25 @@ type ApiHandler interface {
 	gmhttp.Handler
 }
 
+// NamedApiHandler is an ApiHandler that is aware of the instance name it was configured with. Instance names distinguish
+// multiple ApiHandler's generated from the same binding on a single Server. All ApiHandler's provided to a DemuxFactory
+// by a Server satisfy this interface.
+type NamedApiHandler interface {
+	ApiHandler
+	Name() string
+}
+
+// MethodApiHandler is an ApiHandler that declares the http methods it supports. DemuxFactory implementations use the
+// declared methods to answer OPTIONS requests and reject unsupported methods consistently, see DemuxOptions.
+type MethodApiHandler interface {
+	ApiHandler
+
+	// AllowedMethods returns the methods supported for the request's path or nil if they are not known, in which case
+	// the request is passed to the ApiHandler unchecked. Returning http.MethodOptions indicates that the ApiHandler
+	// answers OPTIONS requests itself (i.e. CORS preflight).
+	AllowedMethods(request *gmhttp.Request) []string
+}
+
 // The ApiHandlerFactory interface generates ApiHandler instances. Factories can use a single instance or multiple
 // instances based on need. This interface allows ApiHandler logic to be reused across multiple xweb.Server's while
 // delegating the instance management to the factory.
@@ -53,3 +72,12 @@ type ApiHandlerFactory interface {
 	// instances or configuration from another section).
 	Validate(config *InstanceConfig) error
 }
+
+// ApiOptionsHandlerFactory is an ApiHandlerFactory that receives its options as ApiOptions. Servers call NewWithOptions
+// instead of New for factories that implement it, sparing them from handling interface{} keyed maps.
+type ApiOptionsHandlerFactory interface {
+	ApiHandlerFactory
+
+	// NewWithOptions creates a new instance of this factories ApiConfig from normalized options
+	NewWithOptions(serverConfig *ServerConfig, options ApiOptions) (ApiHandler, error)
+}
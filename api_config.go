@@ -16,15 +16,30 @@
 
 package xweb
 
-import "github.com/pkg/errors"
+import (
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/openziti/xweb/v2/middleware"
+	"github.com/pkg/errors"
+)
 
 // ApiConfig represents some "api" or "site" by binding name. Each ApiConfig configuration is used against a Registry
 // to locate the proper factory to generate a ApiHandler. The options provided by this structure are parsed by the
 // ApiHandlerFactory and the behavior, valid keys, and valid values are not defined by xweb components, but by that
 // ApiHandlerFactory and its resulting ApiHandler's.
 type ApiConfig struct {
-	binding string
-	options map[interface{}]interface{}
+	binding   string
+	name      string
+	weight    int
+	slo       *SloConfig
+	csrf      *middleware.CsrfOptions
+	multipart *middleware.MultipartLimits
+	replay    *middleware.ReplayOptions
+	content   *middleware.ContentTypeOptions
+	rewrite   *middleware.RewriteRules
+	coalesce  *middleware.CoalesceOptions
+	headers   gmhttp.Header
+	options   map[interface{}]interface{}
 }
 
 // Binding returns the string that uniquely identifies bo the ApiHandlerFactory and resulting ApiHandler instances that
@@ -33,11 +48,76 @@ func (api *ApiConfig) Binding() string {
 	return api.binding
 }
 
+// Name returns the instance name of this ApiConfig. Instance names allow the same binding to be declared multiple
+// times on a single ServerConfig with different options. If no name was configured, the binding is returned.
+func (api *ApiConfig) Name() string {
+	if api.name == "" {
+		return api.binding
+	}
+	return api.name
+}
+
+// Weight returns the relative weight of this ApiConfig when requests are balanced across multiple instances of the same
+// binding with BalanceStrategyWeighted. Defaults to 1 if not configured, configured weights must be positive.
+func (api *ApiConfig) Weight() int {
+	if api.weight == 0 {
+		return 1
+	}
+	return api.weight
+}
+
+// Slo returns the service level objectives for this ApiConfig or nil if none were configured.
+func (api *ApiConfig) Slo() *SloConfig {
+	return api.slo
+}
+
+// Csrf returns the CSRF protection options for this ApiConfig or nil if CSRF protection was not enabled.
+func (api *ApiConfig) Csrf() *middleware.CsrfOptions {
+	return api.csrf
+}
+
+// Multipart returns the multipart request limits for this ApiConfig or nil if none were configured.
+func (api *ApiConfig) Multipart() *middleware.MultipartLimits {
+	return api.multipart
+}
+
+// Replay returns the request replay protection options for this ApiConfig or nil if requests are not protected
+func (api *ApiConfig) Replay() *middleware.ReplayOptions {
+	return api.replay
+}
+
+// ContentTypes returns the accepted and produced media types for this ApiConfig or nil if none were declared
+func (api *ApiConfig) ContentTypes() *middleware.ContentTypeOptions {
+	return api.content
+}
+
+// Rewrite returns the response rewrite rules for this ApiConfig or nil if responses are not rewritten
+func (api *ApiConfig) Rewrite() *middleware.RewriteRules {
+	return api.rewrite
+}
+
+// Coalesce returns the options used to coalesce identical concurrent GET requests for this ApiConfig or nil if
+// requests are not coalesced
+func (api *ApiConfig) Coalesce() *middleware.CoalesceOptions {
+	return api.coalesce
+}
+
+// ResponseHeaders returns the static headers set on every response of this ApiConfig, which take precedence over those
+// of the bind point and may be overridden by the ApiHandler
+func (api *ApiConfig) ResponseHeaders() gmhttp.Header {
+	return api.headers
+}
+
 // Options returns the options associated with this ApiConfig binding.
 func (api *ApiConfig) Options() map[interface{}]interface{} {
 	return api.options
 }
 
+// ApiOptions returns a string keyed copy of the options associated with this ApiConfig binding, see NormalizeOptions.
+func (api *ApiConfig) ApiOptions() ApiOptions {
+	return NormalizeOptions(api.options)
+}
+
 // Parse the configuration map for an ApiConfig.
 func (api *ApiConfig) Parse(apiConfigMap map[interface{}]interface{}) error {
 	if bindingInterface, ok := apiConfigMap["binding"]; ok {
@@ -50,6 +130,113 @@ func (api *ApiConfig) Parse(apiConfigMap map[interface{}]interface{}) error {
 		return errors.New("binding is required")
 	}
 
+	if nameInterface, ok := apiConfigMap["name"]; ok {
+		if name, ok := nameInterface.(string); ok {
+			api.name = name
+		} else {
+			return errors.New("name must be a string")
+		}
+	} //no else optional, defaults to binding
+
+	if weightInterface, ok := apiConfigMap["weight"]; ok {
+		if weight, ok := weightInterface.(int); ok {
+			if weight < 1 {
+				return errors.New("weight must be a positive integer")
+			}
+			api.weight = weight
+		} else {
+			return errors.New("weight must be an integer")
+		}
+	} //no else optional, defaults to 1
+
+	if sloInterface, ok := apiConfigMap["slo"]; ok {
+		if sloMap, ok := sloInterface.(map[interface{}]interface{}); ok {
+			api.slo = &SloConfig{}
+			if err := api.slo.Parse(sloMap); err != nil {
+				return fmt.Errorf("error parsing slo: %v", err)
+			}
+		} else {
+			return errors.New("slo if declared must be a map")
+		}
+	} //no else optional
+
+	if csrfInterface, ok := apiConfigMap["csrf"]; ok {
+		if csrfMap, ok := csrfInterface.(map[interface{}]interface{}); ok {
+			api.csrf = &middleware.CsrfOptions{}
+			api.csrf.Default()
+			if err := api.csrf.Parse(csrfMap); err != nil {
+				return fmt.Errorf("error parsing csrf: %v", err)
+			}
+		} else {
+			return errors.New("csrf if declared must be a map")
+		}
+	} //no else optional
+
+	if multipartInterface, ok := apiConfigMap["multipart"]; ok {
+		if multipartMap, ok := multipartInterface.(map[interface{}]interface{}); ok {
+			api.multipart = &middleware.MultipartLimits{}
+			if err := api.multipart.Parse(multipartMap); err != nil {
+				return fmt.Errorf("error parsing multipart: %v", err)
+			}
+		} else {
+			return errors.New("multipart if declared must be a map")
+		}
+	} //no else optional
+
+	if replayInterface, ok := apiConfigMap["replay"]; ok {
+		if replayMap, ok := replayInterface.(map[interface{}]interface{}); ok {
+			api.replay = &middleware.ReplayOptions{}
+			api.replay.Default()
+			if err := api.replay.Parse(replayMap); err != nil {
+				return fmt.Errorf("error parsing replay: %v", err)
+			}
+		} else {
+			return errors.New("replay if declared must be a map")
+		}
+	} //no else optional
+
+	if contentInterface, ok := apiConfigMap["contentTypes"]; ok {
+		if contentMap, ok := contentInterface.(map[interface{}]interface{}); ok {
+			api.content = &middleware.ContentTypeOptions{}
+			if err := api.content.Parse(contentMap); err != nil {
+				return fmt.Errorf("error parsing contentTypes: %v", err)
+			}
+		} else {
+			return errors.New("contentTypes if declared must be a map")
+		}
+	} //no else optional
+
+	if rewriteInterface, ok := apiConfigMap["rewrite"]; ok {
+		if rewriteMap, ok := rewriteInterface.(map[interface{}]interface{}); ok {
+			api.rewrite = &middleware.RewriteRules{}
+			if err := api.rewrite.Parse(rewriteMap); err != nil {
+				return fmt.Errorf("error parsing rewrite: %v", err)
+			}
+		} else {
+			return errors.New("rewrite if declared must be a map")
+		}
+	} //no else optional
+
+	if coalesceInterface, ok := apiConfigMap["coalesce"]; ok {
+		if coalesceMap, ok := coalesceInterface.(map[interface{}]interface{}); ok {
+			api.coalesce = &middleware.CoalesceOptions{}
+			api.coalesce.Default()
+			if err := api.coalesce.Parse(coalesceMap); err != nil {
+				return fmt.Errorf("error parsing coalesce: %v", err)
+			}
+		} else {
+			return errors.New("coalesce if declared must be a map")
+		}
+	} //no else optional
+
+	if headersInterface, ok := apiConfigMap["responseHeaders"]; ok {
+		headers, err := parseResponseHeaders(headersInterface)
+		if err != nil {
+			return err
+		}
+		api.headers = headers
+	} //no else optional
+
 	if optionsInterface, ok := apiConfigMap["options"]; ok {
 		if optionsMap, ok := optionsInterface.(map[interface{}]interface{}); ok {
 			api.options = optionsMap //leave to bindings to interpret further
@@ -67,5 +254,55 @@ func (api *ApiConfig) Validate() error {
 		return errors.New("binding must be specified")
 	}
 
+	if api.weight < 0 {
+		return errors.New("weight must be a positive integer")
+	}
+
+	if api.slo != nil {
+		if err := api.slo.Validate(); err != nil {
+			return fmt.Errorf("invalid slo: %v", err)
+		}
+	}
+
+	if api.csrf != nil {
+		if err := api.csrf.Validate(); err != nil {
+			return fmt.Errorf("invalid csrf: %v", err)
+		}
+	}
+
+	if api.multipart != nil {
+		if err := api.multipart.Validate(); err != nil {
+			return fmt.Errorf("invalid multipart: %v", err)
+		}
+	}
+
+	if api.replay != nil {
+		if err := api.replay.Validate(); err != nil {
+			return fmt.Errorf("invalid replay: %v", err)
+		}
+	}
+
+	if api.content != nil {
+		if err := api.content.Validate(); err != nil {
+			return fmt.Errorf("invalid contentTypes: %v", err)
+		}
+	}
+
+	if api.rewrite != nil {
+		if err := api.rewrite.Validate(); err != nil {
+			return fmt.Errorf("invalid rewrite: %v", err)
+		}
+	}
+
+	if api.coalesce != nil {
+		if err := api.coalesce.Validate(); err != nil {
+			return fmt.Errorf("invalid coalesce: %v", err)
+		}
+	}
+
+	if err := validateResponseHeaders(api.headers); err != nil {
+		return err
+	}
+
 	return nil
 }
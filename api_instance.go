@@ -0,0 +1,280 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"errors"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/openziti/xweb/v2/metrics"
+	"github.com/openziti/xweb/v2/middleware"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	MetricApiRequests     = "xweb.api.requests"
+	MetricApiErrors       = "xweb.api.errors"
+	MetricApiResponseTime = "xweb.api.response_time"
+	MetricApiRejected     = "xweb.api.rejected"
+
+	// DefaultDisabledRetryAfter is the Retry-After value sent by disabled ApiHandler's if none is specified
+	DefaultDisabledRetryAfter = time.Minute
+)
+
+// ApiState describes the runtime state of an ApiHandler configured on a Server
+type ApiState struct {
+	Server     string     `json:"server"`
+	Binding    string     `json:"binding"`
+	Name       string     `json:"name"`
+	Disabled   bool       `json:"disabled"`
+	DisabledAt *time.Time `json:"disabledAt,omitempty"`
+	RetryAfter string     `json:"retryAfter,omitempty"`
+}
+
+// apiInstance wraps an ApiHandler generated from a specific ApiConfig. It provides the instance name to the demux and
+// records per instance metrics. The wrapped ApiHandler is what is stored in the request context under
+// HandlerContextKey.
+type apiInstance struct {
+	ApiHandler
+	handler      gmhttp.Handler
+	serverConfig *ServerConfig
+	config       *ApiConfig
+	requests     metrics.Counter
+	errors       metrics.Counter
+	rejected     metrics.Counter
+	responseTime metrics.Timer
+	slo          *sloTracker
+
+	stateLock  sync.RWMutex
+	disabledAt *time.Time
+	retryAfter time.Duration
+}
+
+var _ NamedApiHandler = &apiInstance{}
+var _ DefaultApiHandler = &apiInstance{}
+
+func newApiInstance(serverConfig *ServerConfig, config *ApiConfig, handler ApiHandler, registry metrics.Registry) *apiInstance {
+	labels := metrics.Labels{
+		"server":  serverConfig.Name,
+		"binding": config.Binding(),
+		"name":    config.Name(),
+	}
+
+	result := &apiInstance{
+		ApiHandler:   handler,
+		serverConfig: serverConfig,
+		config:       config,
+		requests:     registry.Counter(MetricApiRequests, labels),
+		errors:       registry.Counter(MetricApiErrors, labels),
+		rejected:     registry.Counter(MetricApiRejected, labels),
+		responseTime: registry.Timer(MetricApiResponseTime, labels),
+	}
+
+	if config.Slo() != nil {
+		result.slo = newSloTracker(config.Slo())
+	}
+
+	result.handler = wrapApiMiddleware(config, handler)
+
+	return result
+}
+
+// Name returns the ApiConfig instance name
+func (instance *apiInstance) Name() string {
+	return instance.config.Name()
+}
+
+// IsDefault delegates to the wrapped ApiHandler if it is a DefaultApiHandler
+func (instance *apiInstance) IsDefault() bool {
+	if defaultHandler, ok := instance.ApiHandler.(DefaultApiHandler); ok {
+		return defaultHandler.IsDefault()
+	}
+	return false
+}
+
+// AllowedMethods delegates to the wrapped ApiHandler if it is a MethodApiHandler, otherwise the methods are not known
+func (instance *apiInstance) AllowedMethods(request *gmhttp.Request) []string {
+	if methodHandler, ok := instance.ApiHandler.(MethodApiHandler); ok {
+		return methodHandler.AllowedMethods(request)
+	}
+	return nil
+}
+
+// Unwrap returns the ApiHandler generated by the ApiHandlerFactory
+func (instance *apiInstance) Unwrap() ApiHandler {
+	return instance.ApiHandler
+}
+
+// Disable causes all requests to be answered with http.StatusServiceUnavailable and a Retry-After header. The
+// ApiHandler and its configuration are retained so that Enable may restore service.
+func (instance *apiInstance) Disable(retryAfter time.Duration) {
+	if retryAfter <= 0 {
+		retryAfter = DefaultDisabledRetryAfter
+	}
+
+	now := time.Now()
+
+	instance.stateLock.Lock()
+	defer instance.stateLock.Unlock()
+
+	instance.disabledAt = &now
+	instance.retryAfter = retryAfter
+}
+
+// Enable restores service after Disable
+func (instance *apiInstance) Enable() {
+	instance.stateLock.Lock()
+	defer instance.stateLock.Unlock()
+
+	instance.disabledAt = nil
+	instance.retryAfter = 0
+}
+
+func (instance *apiInstance) isDisabled() (bool, time.Duration) {
+	instance.stateLock.RLock()
+	defer instance.stateLock.RUnlock()
+
+	return instance.disabledAt != nil, instance.retryAfter
+}
+
+// State returns the current ApiState
+func (instance *apiInstance) State() *ApiState {
+	instance.stateLock.RLock()
+	defer instance.stateLock.RUnlock()
+
+	state := &ApiState{
+		Server:   instance.serverConfig.Name,
+		Binding:  instance.config.Binding(),
+		Name:     instance.config.Name(),
+		Disabled: instance.disabledAt != nil,
+	}
+
+	if state.Disabled {
+		disabledAt := *instance.disabledAt
+		state.DisabledAt = &disabledAt
+		state.RetryAfter = instance.retryAfter.String()
+	}
+
+	return state
+}
+
+func (instance *apiInstance) ServeHTTP(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if disabled, retryAfter := instance.isDisabled(); disabled {
+		instance.rejected.Inc(1)
+		writer.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+		writer.WriteHeader(gmhttp.StatusServiceUnavailable)
+		_, _ = writer.Write([]byte{})
+		return
+	}
+
+	start := time.Now()
+	instance.requests.Inc(1)
+
+	if info := requestInfoFromContext(request.Context()); info != nil {
+		info.api = instance
+	}
+
+	statusWriter := acquireStatusResponseWriter(writer)
+	defer releaseStatusResponseWriter(statusWriter)
+
+	if instance.slo != nil {
+		done := instance.slo.begin()
+		defer func() {
+			done(statusWriter.Status())
+		}()
+	}
+
+	request = withHandler(request, instance.ApiHandler)
+	ctx := withLoggerFields(request.Context(), logrus.Fields{
+		"binding": instance.Binding(),
+		"api":     instance.Name(),
+	})
+	instance.handler.ServeHTTP(statusWriter, request.WithContext(ctx))
+
+	if statusWriter.Status() >= gmhttp.StatusInternalServerError {
+		instance.errors.Inc(1)
+	}
+	instance.responseTime.UpdateSince(start)
+}
+
+// wrapApiMiddleware wraps an ApiHandler with the middleware its ApiConfig has opted into. Middleware options are
+// copied before failure handlers are set so that the ApiConfig is not modified.
+func wrapApiMiddleware(config *ApiConfig, handler gmhttp.Handler) gmhttp.Handler {
+	// coalescing is innermost so every request still passes the checks of the other middleware
+	if coalesce := config.Coalesce(); coalesce != nil {
+		handler = middleware.NewCoalesceHandler(coalesce, handler)
+	}
+
+	if config.Csrf() != nil {
+		csrf := *config.Csrf()
+		csrf.OnFailure = func(writer gmhttp.ResponseWriter, request *gmhttp.Request, err error) {
+			WriteError(writer, request, gmhttp.StatusForbidden, err, nil)
+		}
+		handler = middleware.NewCsrfHandler(&csrf, handler)
+	}
+
+	if config.Multipart() != nil {
+		multipart := *config.Multipart()
+		multipart.OnTooLarge = func(writer gmhttp.ResponseWriter, request *gmhttp.Request, err error) {
+			WriteError(writer, request, gmhttp.StatusRequestEntityTooLarge, err, nil)
+		}
+		handler = middleware.NewMultipartLimitHandler(&multipart, handler)
+	}
+
+	if config.Replay() != nil {
+		replay := *config.Replay()
+		replay.OnFailure = func(writer gmhttp.ResponseWriter, request *gmhttp.Request, err error) {
+			WriteError(writer, request, replayFailureStatus(err), err, nil)
+		}
+		handler = middleware.NewReplayHandler(&replay, handler)
+	}
+
+	if config.ContentTypes() != nil {
+		content := *config.ContentTypes()
+		content.OnFailure = func(writer gmhttp.ResponseWriter, request *gmhttp.Request, err error) {
+			WriteError(writer, request, contentTypeFailureStatus(err), err, nil)
+		}
+		handler = middleware.NewContentTypeHandler(&content, handler)
+	}
+
+	if rewrite := config.Rewrite(); rewrite != nil {
+		handler = middleware.NewRewriteHandler(rewrite, handler)
+	}
+
+	return wrapResponseHeaders(config.ResponseHeaders(), handler)
+}
+
+// replayFailureStatus returns the status code for a request rejected by replay protection
+func replayFailureStatus(err error) int {
+	switch {
+	case errors.Is(err, middleware.ErrReplayBodyTooLarge):
+		return gmhttp.StatusRequestEntityTooLarge
+	case errors.Is(err, middleware.ErrReplayNonceStoreFull):
+		return gmhttp.StatusServiceUnavailable
+	}
+	return gmhttp.StatusUnauthorized
+}
+
+// contentTypeFailureStatus returns the status code for a request rejected by content type enforcement
+func contentTypeFailureStatus(err error) int {
+	if errors.Is(err, middleware.ErrNotAcceptable) {
+		return gmhttp.StatusNotAcceptable
+	}
+	return gmhttp.StatusUnsupportedMediaType
+}
@@ -0,0 +1,165 @@
+package xweb
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/openziti/xweb/v2/metrics"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_wrapApiMiddleware(t *testing.T) {
+	t.Run("content type mismatches are rejected before the handler", func(t *testing.T) {
+		req := require.New(t)
+		api := &ApiConfig{}
+		req.NoError(api.Parse(map[interface{}]interface{}{
+			"binding": "test",
+			"contentTypes": map[interface{}]interface{}{
+				"accepts":  "application/json",
+				"produces": "application/json",
+			},
+		}))
+		req.NoError(api.Validate())
+
+		served := 0
+		handler := wrapApiMiddleware(api, gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			served++
+		}))
+
+		request := gmhttptest.NewRequest("POST", "/things", strings.NewReader("<a/>"))
+		request.Header.Set("Content-Type", "application/xml")
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		req.Equal(gmhttp.StatusUnsupportedMediaType, recorder.Code)
+
+		request = gmhttptest.NewRequest("GET", "/things", nil)
+		request.Header.Set("Accept", "text/html")
+		recorder = gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		req.Equal(gmhttp.StatusNotAcceptable, recorder.Code)
+
+		request = gmhttptest.NewRequest("POST", "/things", strings.NewReader("{}"))
+		request.Header.Set("Content-Type", "application/json")
+		recorder = gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal(1, served)
+	})
+
+	t.Run("invalid content types are rejected", func(t *testing.T) {
+		req := require.New(t)
+		api := &ApiConfig{}
+		req.EqualError(api.Parse(map[interface{}]interface{}{"binding": "test", "contentTypes": "json"}), "contentTypes if declared must be a map")
+
+		req.NoError(api.Parse(map[interface{}]interface{}{"binding": "test", "contentTypes": map[interface{}]interface{}{"accepts": "json"}}))
+		req.EqualError(api.Validate(), "invalid contentTypes: invalid media type [json]")
+	})
+
+	t.Run("rewrite rules are applied to api responses", func(t *testing.T) {
+		req := require.New(t)
+		api := &ApiConfig{}
+		req.NoError(api.Parse(map[interface{}]interface{}{
+			"binding": "test",
+			"rewrite": map[interface{}]interface{}{
+				"locations": []interface{}{map[interface{}]interface{}{"from": "http://upstream/", "to": "/api/"}},
+				"status":    map[interface{}]interface{}{502: 503},
+			},
+		}))
+		req.NoError(api.Validate())
+
+		handler := wrapApiMiddleware(api, gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.Header().Set("Location", "http://upstream/retry")
+			writer.WriteHeader(gmhttp.StatusBadGateway)
+		}))
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/things", nil))
+		req.Equal(gmhttp.StatusServiceUnavailable, recorder.Code)
+		req.Equal("/api/retry", recorder.Header().Get("Location"))
+	})
+
+	t.Run("invalid rewrite rules are rejected", func(t *testing.T) {
+		req := require.New(t)
+		api := &ApiConfig{}
+		req.EqualError(api.Parse(map[interface{}]interface{}{"binding": "test", "rewrite": "x"}), "rewrite if declared must be a map")
+
+		req.NoError(api.Parse(map[interface{}]interface{}{"binding": "test", "rewrite": map[interface{}]interface{}{"status": map[interface{}]interface{}{200: 700}}}))
+		req.EqualError(api.Validate(), "invalid rewrite: invalid status mapping [200] to [700], status codes must be 200-599")
+	})
+
+	t.Run("invalid coalesce options are rejected", func(t *testing.T) {
+		req := require.New(t)
+		api := &ApiConfig{}
+		req.EqualError(api.Parse(map[interface{}]interface{}{"binding": "test", "coalesce": true}), "coalesce if declared must be a map")
+
+		req.NoError(api.Parse(map[interface{}]interface{}{"binding": "test", "coalesce": map[interface{}]interface{}{"paths": "discovery"}}))
+		req.EqualError(api.Validate(), "invalid coalesce: path [discovery] must start with /")
+	})
+
+	t.Run("middleware options of the api config are not modified", func(t *testing.T) {
+		req := require.New(t)
+		api := &ApiConfig{}
+		req.NoError(api.Parse(map[interface{}]interface{}{
+			"binding":      "test",
+			"csrf":         map[interface{}]interface{}{},
+			"contentTypes": map[interface{}]interface{}{"accepts": "application/json"},
+		}))
+		req.NoError(api.Validate())
+
+		wrapApiMiddleware(api, gmhttp.HandlerFunc(func(gmhttp.ResponseWriter, *gmhttp.Request) {}))
+
+		req.Nil(api.Csrf().OnFailure)
+		req.Nil(api.ContentTypes().OnFailure)
+	})
+}
+
+func Test_apiInstance_Disable(t *testing.T) {
+	newInstance := func() (*apiInstance, *testMethodApiHandler) {
+		handler := &testMethodApiHandler{}
+		api := &ApiConfig{binding: "test", name: "test"}
+		return newApiInstance(&ServerConfig{Name: "server"}, api, handler, metrics.NewRegistry()), handler
+	}
+
+	t.Run("disabled apis answer 503 with Retry-After", func(t *testing.T) {
+		req := require.New(t)
+		instance, handler := newInstance()
+		instance.Disable(90 * time.Second)
+
+		recorder := gmhttptest.NewRecorder()
+		instance.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/things", nil))
+
+		req.Equal(gmhttp.StatusServiceUnavailable, recorder.Code)
+		req.Equal("90", recorder.Header().Get("Retry-After"))
+		req.Empty(handler.served)
+	})
+
+	t.Run("the default Retry-After is used if none is given", func(t *testing.T) {
+		req := require.New(t)
+		instance, _ := newInstance()
+		instance.Disable(0)
+
+		disabled, retryAfter := instance.isDisabled()
+		req.True(disabled)
+		req.Equal(DefaultDisabledRetryAfter, retryAfter)
+
+		recorder := gmhttptest.NewRecorder()
+		instance.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/things", nil))
+		req.Equal(gmhttp.StatusServiceUnavailable, recorder.Code)
+		req.Equal("60", recorder.Header().Get("Retry-After"))
+	})
+
+	t.Run("enabled apis serve requests again", func(t *testing.T) {
+		req := require.New(t)
+		instance, handler := newInstance()
+		instance.Disable(time.Minute)
+		instance.Enable()
+
+		recorder := gmhttptest.NewRecorder()
+		instance.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/things", nil))
+
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal([]string{"GET"}, handler.served)
+	})
+}
@@ -0,0 +1,33 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import "github.com/openziti/xweb/v2/factory"
+
+// ErrOptionNotFound is returned by the ApiOptions getters when no value exists at the requested path
+var ErrOptionNotFound = factory.ErrOptionNotFound
+
+// ApiOptions is a string keyed view of the options for an ApiConfig. Nested maps are ApiOptions as well, and maps
+// nested in lists are normalized the same way, so that factories do not need to handle the interface{} keyed maps
+// produced by YAML parsing. It is the factory.Options type shared with the stable factory interfaces.
+type ApiOptions = factory.Options
+
+// NormalizeOptions returns a deep copy of an options map with all map keys, including those of maps nested in maps
+// and lists, converted to strings. Returns nil if options is nil.
+func NormalizeOptions(options map[interface{}]interface{}) ApiOptions {
+	return factory.NormalizeOptions(options)
+}
@@ -0,0 +1,167 @@
+package xweb
+
+import (
+	"github.com/openziti/xweb/v2/factory"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type testApiOptionsFactory struct {
+	options ApiOptions
+}
+
+func (factory *testApiOptionsFactory) Binding() string {
+	return "test"
+}
+
+func (factory *testApiOptionsFactory) New(*ServerConfig, map[interface{}]interface{}) (ApiHandler, error) {
+	return nil, errors.New("New should not be called")
+}
+
+func (factory *testApiOptionsFactory) NewWithOptions(_ *ServerConfig, options ApiOptions) (ApiHandler, error) {
+	factory.options = options
+	return &testMethodApiHandler{}, nil
+}
+
+func (factory *testApiOptionsFactory) Validate(*InstanceConfig) error {
+	return nil
+}
+
+func TestApiOptions(t *testing.T) {
+	raw := map[interface{}]interface{}{
+		"name":    "edge",
+		"enabled": true,
+		"port":    8443,
+		"ratio":   0.5,
+		"timeout": "5s",
+		"hosts":   []interface{}{"a", "b"},
+		1:         "numeric key",
+		"tls": map[interface{}]interface{}{
+			"ca": "ca.pem",
+			"pins": []interface{}{
+				map[interface{}]interface{}{"sha256": "abc"},
+			},
+		},
+	}
+
+	t.Run("nested maps are normalized recursively", func(t *testing.T) {
+		req := require.New(t)
+		options := NormalizeOptions(raw)
+
+		req.Equal("numeric key", options["1"])
+
+		tls, err := options.GetOptions("tls")
+		req.NoError(err)
+		req.Equal("ca.pem", tls["ca"])
+
+		pins, ok := options.Get("tls", "pins")
+		req.True(ok)
+		req.Equal([]interface{}{ApiOptions{"sha256": "abc"}}, pins)
+	})
+
+	t.Run("nil options normalize to nil", func(t *testing.T) {
+		require.Nil(t, NormalizeOptions(nil))
+	})
+
+	t.Run("typed getters convert values", func(t *testing.T) {
+		req := require.New(t)
+		options := NormalizeOptions(raw)
+
+		name, err := options.GetString("name")
+		req.NoError(err)
+		req.Equal("edge", name)
+
+		enabled, err := options.GetBool("enabled")
+		req.NoError(err)
+		req.True(enabled)
+
+		port, err := options.GetInt("port")
+		req.NoError(err)
+		req.Equal(8443, port)
+
+		ratio, err := options.GetFloat("ratio")
+		req.NoError(err)
+		req.Equal(0.5, ratio)
+
+		timeout, err := options.GetDuration("timeout")
+		req.NoError(err)
+		req.Equal(5*time.Second, timeout)
+
+		hosts, err := options.GetStringSlice("hosts")
+		req.NoError(err)
+		req.Equal([]string{"a", "b"}, hosts)
+
+		ca, err := options.GetString("tls", "ca")
+		req.NoError(err)
+		req.Equal("ca.pem", ca)
+	})
+
+	t.Run("getters report missing and mistyped values", func(t *testing.T) {
+		req := require.New(t)
+		options := NormalizeOptions(raw)
+
+		_, err := options.GetString("missing")
+		req.ErrorIs(err, ErrOptionNotFound)
+
+		_, err = options.GetString("name", "nested")
+		req.ErrorIs(err, ErrOptionNotFound)
+
+		_, err = options.GetInt("name")
+		req.EqualError(err, "option [name] is not an integer")
+
+		_, err = options.GetInt("ratio")
+		req.Error(err)
+
+		_, err = options.GetDuration("name")
+		req.Error(err)
+
+		req.False(options.Has())
+		req.True(options.Has("tls", "ca"))
+	})
+
+	t.Run("MustGet panics for missing values", func(t *testing.T) {
+		req := require.New(t)
+		options := NormalizeOptions(raw)
+
+		req.Equal("edge", options.MustGet("name"))
+		req.PanicsWithValue("required option [tls.missing] not found", func() {
+			options.MustGet("tls", "missing")
+		})
+	})
+
+	t.Run("api configs provide normalized options", func(t *testing.T) {
+		req := require.New(t)
+		api := &ApiConfig{options: raw}
+
+		port, err := api.ApiOptions().GetInt("port")
+		req.NoError(err)
+		req.Equal(8443, port)
+	})
+
+	t.Run("api options keep their getters and errors as factory options", func(t *testing.T) {
+		req := require.New(t)
+		options := NormalizeOptions(map[interface{}]interface{}{
+			"tls": map[interface{}]interface{}{"ca": "ca.pem"},
+		})
+
+		ca, err := options.GetString("tls", "ca")
+		req.NoError(err)
+		req.Equal("ca.pem", ca)
+
+		_, err = options.GetString("tls", "cert")
+		req.ErrorIs(err, ErrOptionNotFound)
+		req.ErrorIs(err, factory.ErrOptionNotFound)
+	})
+
+	t.Run("factories accepting api options are provided them", func(t *testing.T) {
+		req := require.New(t)
+		factory := &testApiOptionsFactory{}
+
+		handler, err := newApiHandler(factory, &ServerConfig{}, &ApiConfig{options: raw}, nil)
+		req.NoError(err)
+		req.NotNil(handler)
+		req.Equal("edge", factory.options["name"])
+	})
+}
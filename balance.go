@@ -0,0 +1,273 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type BalanceStrategy string
+
+const (
+	// BalanceStrategyNone disables balancing, duplicate ApiHandler's are given to the DemuxFactory as is
+	BalanceStrategyNone = BalanceStrategy("")
+
+	// BalanceStrategyRoundRobin distributes requests evenly across ApiHandler's in order
+	BalanceStrategyRoundRobin = BalanceStrategy("round-robin")
+
+	// BalanceStrategyWeighted distributes requests proportionally to each ApiConfig's weight
+	BalanceStrategyWeighted = BalanceStrategy("weighted")
+
+	// BalanceStrategyLeastInFlight sends requests to the ApiHandler with the fewest requests currently being served
+	BalanceStrategyLeastInFlight = BalanceStrategy("least-inflight")
+)
+
+var balanceStrategies = map[BalanceStrategy]struct{}{
+	BalanceStrategyNone:          {},
+	BalanceStrategyRoundRobin:    {},
+	BalanceStrategyWeighted:      {},
+	BalanceStrategyLeastInFlight: {},
+}
+
+// BalanceOptions represents options for distributing requests across ApiHandler's generated from the same binding
+// that share a root path.
+type BalanceOptions struct {
+	BalanceStrategy BalanceStrategy
+}
+
+// Default defaults balance options, balancing is disabled by default
+func (balanceOptions *BalanceOptions) Default() {
+	balanceOptions.BalanceStrategy = BalanceStrategyNone
+}
+
+// Parse parses a config map
+func (balanceOptions *BalanceOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["balanceStrategy"]; ok {
+		if strategyStr, ok := interfaceVal.(string); ok {
+			balanceOptions.BalanceStrategy = BalanceStrategy(strategyStr)
+		} else {
+			return fmt.Errorf("could not use value for balanceStrategy, not a string")
+		}
+	}
+
+	return nil
+}
+
+// Validate validates the configuration values and returns nil or error
+func (balanceOptions *BalanceOptions) Validate() error {
+	if _, ok := balanceStrategies[balanceOptions.BalanceStrategy]; !ok {
+		return fmt.Errorf("invalid balanceStrategy [%s], must be one of: %s, %s, %s", balanceOptions.BalanceStrategy, BalanceStrategyRoundRobin, BalanceStrategyWeighted, BalanceStrategyLeastInFlight)
+	}
+
+	return nil
+}
+
+// balanceApiInstances groups apiInstance's by binding and root path. Groups with more than one member are replaced by
+// a single balancedApiHandler that distributes requests according to strategy. Order of first appearance is retained.
+func balanceApiInstances(instances []*apiInstance, strategy BalanceStrategy) []ApiHandler {
+	var result []ApiHandler
+
+	if strategy == BalanceStrategyNone {
+		for _, instance := range instances {
+			result = append(result, instance)
+		}
+		return result
+	}
+
+	var groupKeys []string
+	groups := map[string][]*apiInstance{}
+
+	for _, instance := range instances {
+		key := instance.Binding() + "\x00" + instance.RootPath()
+		if _, ok := groups[key]; !ok {
+			groupKeys = append(groupKeys, key)
+		}
+		groups[key] = append(groups[key], instance)
+	}
+
+	for _, key := range groupKeys {
+		group := groups[key]
+		if len(group) == 1 {
+			result = append(result, group[0])
+		} else {
+			result = append(result, newBalancedApiHandler(group, strategy))
+		}
+	}
+
+	return result
+}
+
+// balancedApiHandler presents a group of apiInstance's that share a binding and root path as a single ApiHandler.
+// Members are generated by the same binding, so the ApiHandler methods that describe the group are answered by the
+// first member.
+type balancedApiHandler struct {
+	members  []*apiInstance
+	strategy BalanceStrategy
+	next     uint64
+	inFlight []int64
+
+	weightLock     sync.Mutex
+	currentWeights []int
+	totalWeight    int
+}
+
+var _ NamedApiHandler = &balancedApiHandler{}
+var _ DefaultApiHandler = &balancedApiHandler{}
+var _ MethodApiHandler = &balancedApiHandler{}
+
+func newBalancedApiHandler(members []*apiInstance, strategy BalanceStrategy) *balancedApiHandler {
+	result := &balancedApiHandler{
+		members:        members,
+		strategy:       strategy,
+		inFlight:       make([]int64, len(members)),
+		currentWeights: make([]int, len(members)),
+	}
+
+	for _, member := range members {
+		result.totalWeight += member.config.Weight()
+	}
+
+	return result
+}
+
+// Binding returns the binding shared by all members of the group
+func (handler *balancedApiHandler) Binding() string {
+	return handler.members[0].Binding()
+}
+
+// Options returns the options of the first member of the group
+func (handler *balancedApiHandler) Options() map[interface{}]interface{} {
+	return handler.members[0].Options()
+}
+
+// RootPath returns the root path shared by all members of the group
+func (handler *balancedApiHandler) RootPath() string {
+	return handler.members[0].RootPath()
+}
+
+// IsHandler returns true if the first member of the group handles the request
+func (handler *balancedApiHandler) IsHandler(request *gmhttp.Request) bool {
+	return handler.members[0].IsHandler(request)
+}
+
+// IsDefault returns true if the first member of the group is a default ApiHandler
+func (handler *balancedApiHandler) IsDefault() bool {
+	return handler.members[0].IsDefault()
+}
+
+// AllowedMethods returns the methods allowed by the first member of the group
+func (handler *balancedApiHandler) AllowedMethods(request *gmhttp.Request) []string {
+	return handler.members[0].AllowedMethods(request)
+}
+
+// Unwrap returns the ApiHandler generated for the first member of the group, see Members for all of them
+func (handler *balancedApiHandler) Unwrap() ApiHandler {
+	return handler.members[0].Unwrap()
+}
+
+// Name returns the names of all members of the group
+func (handler *balancedApiHandler) Name() string {
+	var names []string
+	for _, member := range handler.members {
+		names = append(names, member.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// Members returns the ApiHandler's requests are distributed across
+func (handler *balancedApiHandler) Members() []NamedApiHandler {
+	var result []NamedApiHandler
+	for _, member := range handler.members {
+		result = append(result, member)
+	}
+	return result
+}
+
+func (handler *balancedApiHandler) ServeHTTP(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	index := handler.selectMember()
+
+	//skip disabled members if possible, if all are disabled the selected member will reject the request
+	if disabled, _ := handler.members[index].isDisabled(); disabled {
+		for offset := 1; offset < len(handler.members); offset++ {
+			candidate := (index + offset) % len(handler.members)
+			if candidateDisabled, _ := handler.members[candidate].isDisabled(); !candidateDisabled {
+				index = candidate
+				break
+			}
+		}
+	}
+
+	atomic.AddInt64(&handler.inFlight[index], 1)
+	defer atomic.AddInt64(&handler.inFlight[index], -1)
+
+	handler.members[index].ServeHTTP(writer, request)
+}
+
+func (handler *balancedApiHandler) selectMember() int {
+	switch handler.strategy {
+	case BalanceStrategyWeighted:
+		return handler.selectWeighted()
+	case BalanceStrategyLeastInFlight:
+		return handler.selectLeastInFlight()
+	}
+
+	return handler.selectRoundRobin()
+}
+
+func (handler *balancedApiHandler) selectRoundRobin() int {
+	return int((atomic.AddUint64(&handler.next, 1) - 1) % uint64(len(handler.members)))
+}
+
+// selectWeighted implements smooth weighted round-robin, which interleaves selections rather than sending bursts to
+// the heaviest member.
+func (handler *balancedApiHandler) selectWeighted() int {
+	handler.weightLock.Lock()
+	defer handler.weightLock.Unlock()
+
+	selected := 0
+	for i, member := range handler.members {
+		handler.currentWeights[i] += member.config.Weight()
+		if handler.currentWeights[i] > handler.currentWeights[selected] {
+			selected = i
+		}
+	}
+
+	handler.currentWeights[selected] -= handler.totalWeight
+
+	return selected
+}
+
+// selectLeastInFlight selects the member with the fewest in flight requests, ties are resolved round-robin
+func (handler *balancedApiHandler) selectLeastInFlight() int {
+	start := handler.selectRoundRobin()
+	selected := start
+	least := atomic.LoadInt64(&handler.inFlight[start])
+
+	for offset := 1; offset < len(handler.members); offset++ {
+		i := (start + offset) % len(handler.members)
+		if count := atomic.LoadInt64(&handler.inFlight[i]); count < least {
+			selected = i
+			least = count
+		}
+	}
+
+	return selected
+}
@@ -0,0 +1,95 @@
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func newTestBalancedApiHandler(strategy BalanceStrategy, weights ...int) *balancedApiHandler {
+	var members []*apiInstance
+	for i, weight := range weights {
+		members = append(members, &apiInstance{
+			config: &ApiConfig{
+				binding: "test",
+				name:    string(rune('a' + i)),
+				weight:  weight,
+			},
+		})
+	}
+	return newBalancedApiHandler(members, strategy)
+}
+
+func Test_balancedApiHandler(t *testing.T) {
+	t.Run("round-robin selects members in order", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestBalancedApiHandler(BalanceStrategyRoundRobin, 1, 1, 1)
+
+		var selected []int
+		for i := 0; i < 6; i++ {
+			selected = append(selected, handler.selectMember())
+		}
+
+		req.Equal([]int{0, 1, 2, 0, 1, 2}, selected)
+	})
+
+	t.Run("weighted selects members proportionally and interleaved", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestBalancedApiHandler(BalanceStrategyWeighted, 5, 1, 1)
+
+		var selected []int
+		for i := 0; i < 7; i++ {
+			selected = append(selected, handler.selectMember())
+		}
+
+		req.Equal([]int{0, 0, 1, 0, 2, 0, 0}, selected)
+	})
+
+	t.Run("least-inflight avoids busy members", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestBalancedApiHandler(BalanceStrategyLeastInFlight, 1, 1, 1)
+		handler.inFlight[0] = 3
+		handler.inFlight[1] = 1
+		handler.inFlight[2] = 2
+
+		for i := 0; i < 3; i++ {
+			req.Equal(1, handler.selectMember())
+		}
+	})
+
+	t.Run("members without weights default to 1", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestBalancedApiHandler(BalanceStrategyWeighted, 0, 0)
+		req.Equal(2, handler.totalWeight)
+	})
+}
+
+func Test_ApiConfig_weight(t *testing.T) {
+	t.Run("a weight of 0 is rejected", func(t *testing.T) {
+		req := require.New(t)
+		api := &ApiConfig{}
+		req.Error(api.Parse(map[interface{}]interface{}{"binding": "test", "weight": 0}))
+	})
+
+	t.Run("a positive weight is accepted", func(t *testing.T) {
+		req := require.New(t)
+		api := &ApiConfig{}
+		req.NoError(api.Parse(map[interface{}]interface{}{"binding": "test", "weight": 3}))
+		req.Equal(3, api.Weight())
+	})
+}
+
+func Test_balancedApiHandler_delegation(t *testing.T) {
+	t.Run("the group is described by its first member", func(t *testing.T) {
+		req := require.New(t)
+		first := &testMethodApiHandler{}
+		members := []*apiInstance{
+			{ApiHandler: first, config: &ApiConfig{binding: "test", name: "a"}},
+			{ApiHandler: &testMethodApiHandler{}, config: &ApiConfig{binding: "test", name: "b"}},
+		}
+		handler := newBalancedApiHandler(members, BalanceStrategyRoundRobin)
+
+		req.Equal("a,b", handler.Name())
+		req.Same(first, handler.Unwrap())
+		req.False(handler.IsDefault())
+	})
+}
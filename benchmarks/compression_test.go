@@ -0,0 +1,35 @@
+package benchmarks
+
+import (
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/openziti/xweb/v2/middleware"
+	"strings"
+	"testing"
+)
+
+// BenchmarkCompressionHandler measures compressed response writing, see bufpool.Compression for buffer reuse
+func BenchmarkCompressionHandler(b *testing.B) {
+	body := []byte(strings.Repeat(`{"id":"abc123","name":"service","tags":["a","b"]},`, 200))
+
+	handler := middleware.NewCompressionHandler(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, _ *gmhttp.Request) {
+		_, _ = writer.Write(body)
+	}))
+
+	for _, encoding := range []string{"gzip", "deflate", "br"} {
+		b.Run(fmt.Sprintf("encoding=%s", encoding), func(b *testing.B) {
+			request := gmhttptest.NewRequest(gmhttp.MethodGet, "/things", nil)
+			request.Header.Set(middleware.HttpHeaderAcceptEncoding, encoding)
+			writer := &discardResponseWriter{header: gmhttp.Header{}}
+
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				handler.ServeHTTP(writer, request)
+			}
+		})
+	}
+}
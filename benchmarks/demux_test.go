@@ -0,0 +1,105 @@
+package benchmarks
+
+import (
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/openziti/xweb/v2"
+	"strings"
+	"testing"
+)
+
+type benchApiHandler struct {
+	rootPath string
+}
+
+func (handler *benchApiHandler) Binding() string {
+	return strings.Trim(handler.rootPath, "/")
+}
+
+func (handler *benchApiHandler) Options() map[interface{}]interface{} {
+	return nil
+}
+
+func (handler *benchApiHandler) RootPath() string {
+	return handler.rootPath
+}
+
+func (handler *benchApiHandler) IsHandler(request *gmhttp.Request) bool {
+	return strings.HasPrefix(request.URL.Path, handler.rootPath)
+}
+
+func (handler *benchApiHandler) AllowedMethods(*gmhttp.Request) []string {
+	return []string{gmhttp.MethodGet}
+}
+
+func (handler *benchApiHandler) ServeHTTP(writer gmhttp.ResponseWriter, _ *gmhttp.Request) {
+	writer.WriteHeader(gmhttp.StatusOK)
+}
+
+type discardResponseWriter struct {
+	header gmhttp.Header
+}
+
+func (w *discardResponseWriter) Header() gmhttp.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(int) {}
+
+func newBenchApiHandlers(count int) []xweb.ApiHandler {
+	var handlers []xweb.ApiHandler
+	for i := 0; i < count; i++ {
+		handlers = append(handlers, &benchApiHandler{rootPath: fmt.Sprintf("/api-%d/", i)})
+	}
+	return handlers
+}
+
+func benchmarkDemux(b *testing.B, factory xweb.DemuxFactory) {
+	for _, count := range []int{1, 10, 100, 1000} {
+		demux, err := factory.Build(newBenchApiHandlers(count))
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for _, position := range []string{"first", "last", "none"} {
+			path := "/api-0/things"
+			switch position {
+			case "last":
+				path = fmt.Sprintf("/api-%d/things", count-1)
+			case "none":
+				path = "/unknown/things"
+			}
+
+			b.Run(fmt.Sprintf("bindings=%d/match=%s", count, position), func(b *testing.B) {
+				request := gmhttptest.NewRequest(gmhttp.MethodGet, path, nil)
+				writer := &discardResponseWriter{header: gmhttp.Header{}}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					demux.ServeHTTP(writer, request)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkPathPrefixDemux(b *testing.B) {
+	benchmarkDemux(b, &xweb.PathPrefixDemuxFactory{})
+}
+
+func BenchmarkIsHandledDemux(b *testing.B) {
+	benchmarkDemux(b, &xweb.IsHandledDemuxFactory{})
+}
+
+func BenchmarkPathPrefixDemux_MethodHandling(b *testing.B) {
+	benchmarkDemux(b, &xweb.PathPrefixDemuxFactory{
+		DemuxOptions: xweb.DemuxOptions{AutoOptions: true, MethodNotAllowed: true, SynthesizeHead: true},
+	})
+}
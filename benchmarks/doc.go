@@ -0,0 +1,27 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+/*
+Package benchmarks contains benchmarks for xweb's request hot path: demux routing across many bindings, TLS and GM TLS
+handshakes, and parsing of requests with large headers. Benchmarks that exercise unexported middleware layers live
+alongside them in the xweb package (see Benchmark_wrapHandler).
+
+Run them with allocation reporting and compare runs with benchstat to detect regressions:
+
+	go test -run xxx -bench . -benchmem -count 10 ./benchmarks/ > new.txt
+	benchstat old.txt new.txt
+*/
+package benchmarks
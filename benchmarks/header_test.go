@@ -0,0 +1,45 @@
+package benchmarks
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"strings"
+	"testing"
+)
+
+func newRawRequest(headerCount, valueSize int) []byte {
+	buffer := &bytes.Buffer{}
+	buffer.WriteString("GET /api-0/things?filter=name HTTP/1.1\r\nHost: localhost\r\n")
+	for i := 0; i < headerCount; i++ {
+		buffer.WriteString(fmt.Sprintf("X-Bench-Header-%d: %s\r\n", i, strings.Repeat("v", valueSize)))
+	}
+	buffer.WriteString("\r\n")
+	return buffer.Bytes()
+}
+
+// BenchmarkReadRequest_LargeHeaders measures parsing of requests with many and/or large headers, as sent by clients
+// with large cookies or bearer tokens
+func BenchmarkReadRequest_LargeHeaders(b *testing.B) {
+	for _, size := range []struct{ count, valueSize int }{{10, 32}, {50, 256}, {100, 1024}, {10, 8192}} {
+		raw := newRawRequest(size.count, size.valueSize)
+
+		b.Run(fmt.Sprintf("headers=%d/valueSize=%d", size.count, size.valueSize), func(b *testing.B) {
+			reader := bytes.NewReader(raw)
+			bufferedReader := bufio.NewReaderSize(reader, 4096)
+
+			b.SetBytes(int64(len(raw)))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				reader.Reset(raw)
+				bufferedReader.Reset(reader)
+				if _, err := gmhttp.ReadRequest(bufferedReader); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
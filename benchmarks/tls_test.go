@@ -0,0 +1,114 @@
+package benchmarks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"gitee.com/zhaochuninhefei/gmgo/sm2"
+	"gitee.com/zhaochuninhefei/gmgo/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func newBenchCertificate(b *testing.B, key crypto.Signer) (gmtls.Certificate, *x509.CertPool) {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return gmtls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}
+
+func benchmarkHandshake(b *testing.B, serverConfig, clientConfig *gmtls.Config) {
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		serverConn, clientConn := net.Pipe()
+		server := gmtls.Server(serverConn, serverConfig)
+		client := gmtls.Client(clientConn, clientConfig)
+
+		errC := make(chan error, 1)
+		go func() {
+			errC <- server.Handshake()
+		}()
+
+		if err := client.Handshake(); err != nil {
+			b.Fatal(err)
+		}
+		if err := <-errC; err != nil {
+			b.Fatal(err)
+		}
+
+		// close the underlying conns, a close_notify would block on the unbuffered pipe
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+	}
+}
+
+// BenchmarkHandshake_TLS13 measures full TLS 1.3 handshakes with an ECDSA P-256 certificate and X25519 key exchange
+func BenchmarkHandshake_TLS13(b *testing.B) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	cert, pool := newBenchCertificate(b, key)
+
+	benchmarkHandshake(b,
+		&gmtls.Config{Certificates: []gmtls.Certificate{cert}, SessionTicketsDisabled: true, MinVersion: gmtls.VersionTLS13, CurvePreferences: []gmtls.CurveID{gmtls.X25519}},
+		&gmtls.Config{RootCAs: pool, ServerName: "localhost", MinVersion: gmtls.VersionTLS13, CurvePreferences: []gmtls.CurveID{gmtls.X25519}, SessionTicketsDisabled: true},
+	)
+}
+
+// BenchmarkHandshake_TLS12 measures full TLS 1.2 handshakes with an ECDSA P-256 certificate and X25519 key exchange
+func BenchmarkHandshake_TLS12(b *testing.B) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	cert, pool := newBenchCertificate(b, key)
+
+	benchmarkHandshake(b,
+		&gmtls.Config{Certificates: []gmtls.Certificate{cert}, SessionTicketsDisabled: true, MaxVersion: gmtls.VersionTLS12, CurvePreferences: []gmtls.CurveID{gmtls.X25519}},
+		&gmtls.Config{RootCAs: pool, ServerName: "localhost", MaxVersion: gmtls.VersionTLS12, CurvePreferences: []gmtls.CurveID{gmtls.X25519}, SessionTicketsDisabled: true},
+	)
+}
+
+// BenchmarkHandshake_GM measures full TLS 1.3 handshakes with an SM2 certificate, SM2 key exchange and TLS_SM4_GCM_SM3
+func BenchmarkHandshake_GM(b *testing.B) {
+	key, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	cert, pool := newBenchCertificate(b, key)
+
+	benchmarkHandshake(b,
+		&gmtls.Config{Certificates: []gmtls.Certificate{cert}, SessionTicketsDisabled: true, MinVersion: gmtls.VersionTLS13, CurvePreferences: []gmtls.CurveID{gmtls.Curve256Sm2}},
+		&gmtls.Config{RootCAs: pool, ServerName: "localhost", MinVersion: gmtls.VersionTLS13, CurvePreferences: []gmtls.CurveID{gmtls.Curve256Sm2}, SessionTicketsDisabled: true},
+	)
+}
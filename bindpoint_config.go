@@ -18,10 +18,12 @@ package xweb
 
 import (
 	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
 	"github.com/pkg/errors"
 	"net"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // BindPointConfig represents the interface:port address of where a http.Server should listen for a ServerConfig and the public
@@ -30,6 +32,29 @@ type BindPointConfig struct {
 	InterfaceAddress string //<interface>:<port>
 	Address          string //<ip/host>:<port>
 	NewAddress       string //<ip/host>:<port> sent out as a header for clients to alternatively swap to (ip -> hostname moves)
+	ErrorVerbosity   ErrorVerbosity
+	ResponseHeaders  gmhttp.Header // static headers set on every response, which handlers may override
+
+	// Preset is the name of the BindPointPreset the bind point's defaults were taken from, if any
+	Preset string
+
+	// ReadTimeout, WriteTimeout and IdleTimeout override the ServerConfig's TimeoutOptions if set
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// ReadHeaderTimeout bounds reading request headers, if not set ReadTimeout is used
+	ReadHeaderTimeout time.Duration
+
+	// MaxHeaderBytes bounds the size of request headers, if not set http.DefaultMaxHeaderBytes is used
+	MaxHeaderBytes int
+
+	// MinTLSVersion raises the ServerConfig's minimum TLS version for the bind point if set
+	MinTLSVersion int
+
+	// AllowedMethods are the only request methods served, requests with other methods, such as TRACE or CONNECT,
+	// are rejected with http.StatusMethodNotAllowed before reaching an ApiHandler. All methods are allowed if empty.
+	AllowedMethods []string
 }
 
 // Parse the configuration map for a BindPointConfig.
@@ -58,6 +83,85 @@ func (bindPoint *BindPointConfig) Parse(config map[interface{}]interface{}) erro
 		}
 	}
 
+	bindPoint.ErrorVerbosity = DefaultErrorVerbosity
+
+	if interfaceVal, ok := config["preset"]; ok {
+		preset, err := parseBindPointPreset(interfaceVal)
+		if err != nil {
+			return err
+		}
+		preset.applyTo(bindPoint)
+	}
+
+	if interfaceVal, ok := config["errorVerbosity"]; ok {
+		if verbosity, ok := interfaceVal.(string); ok {
+			bindPoint.ErrorVerbosity = ErrorVerbosity(verbosity)
+		} else {
+			return errors.New("could not use value for errorVerbosity, not a string")
+		}
+	}
+
+	if interfaceVal, ok := config["responseHeaders"]; ok {
+		headers, err := parseResponseHeaders(interfaceVal)
+		if err != nil {
+			return err
+		}
+		if bindPoint.ResponseHeaders == nil {
+			bindPoint.ResponseHeaders = headers
+		} else {
+			for name, values := range headers {
+				bindPoint.ResponseHeaders[name] = values
+			}
+		}
+	}
+
+	for key, target := range map[string]*time.Duration{
+		"readTimeout":       &bindPoint.ReadTimeout,
+		"readHeaderTimeout": &bindPoint.ReadHeaderTimeout,
+		"writeTimeout":      &bindPoint.WriteTimeout,
+		"idleTimeout":       &bindPoint.IdleTimeout,
+	} {
+		if interfaceVal, ok := config[key]; ok {
+			durationStr, ok := interfaceVal.(string)
+			if !ok {
+				return fmt.Errorf("could not use value for %s, not a string", key)
+			}
+			duration, err := time.ParseDuration(durationStr)
+			if err != nil {
+				return fmt.Errorf("could not parse %s %s as a duration (e.g. 1m): %v", key, durationStr, err)
+			}
+			*target = duration
+		}
+	}
+
+	if interfaceVal, ok := config["maxHeaderBytes"]; ok {
+		if maxHeaderBytes, ok := interfaceVal.(int); ok {
+			bindPoint.MaxHeaderBytes = maxHeaderBytes
+		} else {
+			return errors.New("could not use value for maxHeaderBytes, not a number")
+		}
+	}
+
+	if interfaceVal, ok := config["minTLSVersion"]; ok {
+		minTLSVersionStr, ok := interfaceVal.(string)
+		if !ok {
+			return errors.New("could not use value for minTLSVersion, not a string")
+		}
+		minTLSVersion, ok := TlsVersionMap[minTLSVersionStr]
+		if !ok {
+			return fmt.Errorf("could not use value for minTLSVersion, invalid value [%s]", minTLSVersionStr)
+		}
+		bindPoint.MinTLSVersion = minTLSVersion
+	}
+
+	if interfaceVal, ok := config["allowedMethods"]; ok {
+		allowedMethods, err := parseAllowedMethods(interfaceVal)
+		if err != nil {
+			return err
+		}
+		bindPoint.AllowedMethods = allowedMethods
+	}
+
 	return nil
 }
 
@@ -81,9 +185,77 @@ func (bindPoint *BindPointConfig) Validate() error {
 		}
 	}
 
+	if !bindPoint.ErrorVerbosity.IsValid() {
+		return fmt.Errorf("invalid error verbosity [%s], must be one of: %s, %s", bindPoint.ErrorVerbosity, ErrorVerbosityMinimal, ErrorVerbosityDetailed)
+	}
+
+	if err := validateResponseHeaders(bindPoint.ResponseHeaders); err != nil {
+		return err
+	}
+
+	if bindPoint.ReadTimeout < 0 || bindPoint.ReadHeaderTimeout < 0 || bindPoint.WriteTimeout < 0 || bindPoint.IdleTimeout < 0 {
+		return errors.New("timeouts must not be negative")
+	}
+
+	if bindPoint.MaxHeaderBytes < 0 {
+		return fmt.Errorf("invalid maxHeaderBytes [%d], must not be negative", bindPoint.MaxHeaderBytes)
+	}
+
+	if err := validateAllowedMethods(bindPoint.AllowedMethods); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// Overlaps returns true if this bind point and other would attempt to listen on the same address and port. Unspecified
+// addresses (0.0.0.0, ::) overlap with every address of their family on the same port, and the IPv6 unspecified
+// address overlaps with IPv4 addresses as listeners on it are typically dual stack. Host names are only compared by
+// name.
+func (bindPoint *BindPointConfig) Overlaps(other *BindPointConfig) bool {
+	host, port, err := net.SplitHostPort(bindPoint.InterfaceAddress)
+	if err != nil {
+		return false
+	}
+
+	otherHost, otherPort, err := net.SplitHostPort(other.InterfaceAddress)
+	if err != nil || port != otherPort {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	otherIp := net.ParseIP(otherHost)
+
+	if ip == nil || otherIp == nil {
+		if isUnspecifiedHost(ip, host) || isUnspecifiedHost(otherIp, otherHost) {
+			return true
+		}
+		return strings.EqualFold(host, otherHost)
+	}
+
+	if ip.Equal(otherIp) {
+		return true
+	}
+
+	if ip.IsUnspecified() || otherIp.IsUnspecified() {
+		isIpv4 := ip.To4() != nil
+		otherIsIpv4 := otherIp.To4() != nil
+
+		if isIpv4 == otherIsIpv4 {
+			return true
+		}
+
+		// only :: overlaps with IPv4 addresses, 0.0.0.0 does not overlap with IPv6 addresses
+		return (ip.IsUnspecified() && !isIpv4) || (otherIp.IsUnspecified() && !otherIsIpv4)
+	}
+
+	return false
+}
+
+func isUnspecifiedHost(ip net.IP, host string) bool {
+	return host == "" || (ip != nil && ip.IsUnspecified())
+}
+
 func validateHostPort(address string) error {
 	address = strings.TrimSpace(address)
 
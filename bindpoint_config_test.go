@@ -0,0 +1,43 @@
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBindPointConfig_Overlaps(t *testing.T) {
+	overlaps := func(first, second string) bool {
+		return (&BindPointConfig{InterfaceAddress: first}).Overlaps(&BindPointConfig{InterfaceAddress: second})
+	}
+
+	t.Run("identical addresses overlap", func(t *testing.T) {
+		req := require.New(t)
+		req.True(overlaps("127.0.0.1:443", "127.0.0.1:443"))
+		req.True(overlaps("Localhost:443", "localhost:443"))
+	})
+
+	t.Run("different ports do not overlap", func(t *testing.T) {
+		req := require.New(t)
+		req.False(overlaps("0.0.0.0:443", "127.0.0.1:8443"))
+	})
+
+	t.Run("different specific addresses do not overlap", func(t *testing.T) {
+		req := require.New(t)
+		req.False(overlaps("127.0.0.1:443", "10.0.0.1:443"))
+	})
+
+	t.Run("unspecified addresses overlap specific addresses on the same port", func(t *testing.T) {
+		req := require.New(t)
+		req.True(overlaps("0.0.0.0:443", "127.0.0.1:443"))
+		req.True(overlaps("127.0.0.1:443", "0.0.0.0:443"))
+		req.True(overlaps("0.0.0.0:443", "localhost:443"))
+		req.True(overlaps("[::]:443", "[::1]:443"))
+	})
+
+	t.Run("ipv6 unspecified overlaps ipv4 but ipv4 unspecified does not overlap ipv6", func(t *testing.T) {
+		req := require.New(t)
+		req.True(overlaps("[::]:443", "127.0.0.1:443"))
+		req.True(overlaps("0.0.0.0:443", "[::]:443"))
+		req.False(overlaps("0.0.0.0:443", "[::1]:443"))
+	})
+}
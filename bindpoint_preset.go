@@ -0,0 +1,186 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// BindPointPresetHardenedPublic suits bind points exposed to the internet: short timeouts, small header limits,
+	// TLS 1.3 only and browser security headers
+	BindPointPresetHardenedPublic = "hardened-public"
+
+	// BindPointPresetInternalManagement suits management APIs on private networks with long running requests
+	BindPointPresetInternalManagement = "internal-management"
+
+	// BindPointPresetDev suits local development: generous timeouts and detailed errors
+	BindPointPresetDev = "dev"
+)
+
+// BindPointPreset is a named set of bind point defaults selected with the `preset` bind point setting. Settings
+// configured on the bind point override the preset's values.
+type BindPointPreset struct {
+	Name string
+
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	MinTLSVersion     int
+	ErrorVerbosity    ErrorVerbosity
+	ResponseHeaders   gmhttp.Header
+	AllowedMethods    []string
+}
+
+// BindPointPresets are the known BindPointPreset's by name
+var BindPointPresets = map[string]*BindPointPreset{
+	BindPointPresetHardenedPublic: {
+		Name:              BindPointPresetHardenedPublic,
+		ReadTimeout:       5 * time.Second,
+		ReadHeaderTimeout: 2 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       30 * time.Second,
+		MaxHeaderBytes:    16 * 1024,
+		MinTLSVersion:     gmtls.VersionTLS13,
+		ErrorVerbosity:    ErrorVerbosityMinimal,
+		ResponseHeaders: gmhttp.Header{
+			"Strict-Transport-Security":  {"max-age=63072000; includeSubDomains"},
+			"X-Content-Type-Options":     {"nosniff"},
+			"X-Frame-Options":            {"DENY"},
+			"Referrer-Policy":            {"no-referrer"},
+			"Content-Security-Policy":    {"default-src 'none'; frame-ancestors 'none'"},
+			"Cross-Origin-Opener-Policy": {"same-origin"},
+		},
+		AllowedMethods: []string{
+			gmhttp.MethodGet, gmhttp.MethodHead, gmhttp.MethodPost, gmhttp.MethodPut,
+			gmhttp.MethodPatch, gmhttp.MethodDelete, gmhttp.MethodOptions,
+		},
+	},
+	BindPointPresetInternalManagement: {
+		Name:              BindPointPresetInternalManagement,
+		ReadTimeout:       30 * time.Second,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      time.Minute,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    64 * 1024,
+		MinTLSVersion:     gmtls.VersionTLS12,
+		ErrorVerbosity:    ErrorVerbosityMinimal,
+		ResponseHeaders: gmhttp.Header{
+			"X-Content-Type-Options": {"nosniff"},
+			"X-Frame-Options":        {"DENY"},
+			"Cache-Control":          {"no-store"},
+		},
+	},
+	BindPointPresetDev: {
+		Name:           BindPointPresetDev,
+		ReadTimeout:    5 * time.Minute,
+		WriteTimeout:   5 * time.Minute,
+		IdleTimeout:    10 * time.Minute,
+		MaxHeaderBytes: gmhttp.DefaultMaxHeaderBytes,
+		MinTLSVersion:  gmtls.VersionTLS12,
+		ErrorVerbosity: ErrorVerbosityDetailed,
+		ResponseHeaders: gmhttp.Header{
+			"X-Content-Type-Options": {"nosniff"},
+		},
+	},
+}
+
+// bindPointPresetNames returns the sorted names of the known BindPointPreset's
+func bindPointPresetNames() []string {
+	var names []string
+	for name := range BindPointPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyTo sets the preset's values on a bind point
+func (preset *BindPointPreset) applyTo(bindPoint *BindPointConfig) {
+	bindPoint.Preset = preset.Name
+	bindPoint.ReadTimeout = preset.ReadTimeout
+	bindPoint.ReadHeaderTimeout = preset.ReadHeaderTimeout
+	bindPoint.WriteTimeout = preset.WriteTimeout
+	bindPoint.IdleTimeout = preset.IdleTimeout
+	bindPoint.MaxHeaderBytes = preset.MaxHeaderBytes
+	bindPoint.MinTLSVersion = preset.MinTLSVersion
+	bindPoint.ErrorVerbosity = preset.ErrorVerbosity
+	bindPoint.ResponseHeaders = preset.ResponseHeaders.Clone()
+	bindPoint.AllowedMethods = append([]string(nil), preset.AllowedMethods...)
+}
+
+// parseBindPointPreset looks up the preset named by a `preset` value
+func parseBindPointPreset(value interface{}) (*BindPointPreset, error) {
+	name, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("could not use value for preset, not a string")
+	}
+
+	preset, ok := BindPointPresets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset [%s], must be one of: %s", name, strings.Join(bindPointPresetNames(), ", "))
+	}
+
+	return preset, nil
+}
+
+// timeouts returns the bind point's timeouts, using the ServerConfig's for those not set on the bind point
+func (bindPoint *BindPointConfig) timeouts(defaults *TimeoutOptions) TimeoutOptions {
+	result := *defaults
+	if bindPoint.ReadTimeout > 0 {
+		result.ReadTimeout = bindPoint.ReadTimeout
+	}
+	if bindPoint.WriteTimeout > 0 {
+		result.WriteTimeout = bindPoint.WriteTimeout
+	}
+	if bindPoint.IdleTimeout > 0 {
+		result.IdleTimeout = bindPoint.IdleTimeout
+	}
+	return result
+}
+
+// tlsConfig returns the server TLS configuration to use for the bind point. If the bind point raises the minimum TLS
+// version, a copy is returned that also raises it for configurations selected by GetConfigForClient hooks.
+func (bindPoint *BindPointConfig) tlsConfig(tlsConfig *gmtls.Config) *gmtls.Config {
+	minVersion := uint16(bindPoint.MinTLSVersion)
+	if minVersion <= tlsConfig.MinVersion {
+		return tlsConfig
+	}
+
+	result := tlsConfig.Clone()
+	result.MinVersion = minVersion
+
+	if getConfigForClient := tlsConfig.GetConfigForClient; getConfigForClient != nil {
+		result.GetConfigForClient = func(info *gmtls.ClientHelloInfo) (*gmtls.Config, error) {
+			config, err := getConfigForClient(info)
+			if config != nil && config.MinVersion < minVersion {
+				config = config.Clone()
+				config.MinVersion = minVersion
+			}
+			return config, err
+		}
+	}
+
+	return result
+}
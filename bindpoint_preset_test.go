@@ -0,0 +1,120 @@
+package xweb
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestBindPointPreset(t *testing.T) {
+	parse := func(req *require.Assertions, config map[interface{}]interface{}) *BindPointConfig {
+		config["interface"] = "127.0.0.1:443"
+		config["address"] = "localhost:443"
+		bindPoint := &BindPointConfig{}
+		req.NoError(bindPoint.Parse(config))
+		req.NoError(bindPoint.Validate())
+		return bindPoint
+	}
+
+	t.Run("presets expand into bind point defaults", func(t *testing.T) {
+		req := require.New(t)
+		bindPoint := parse(req, map[interface{}]interface{}{"preset": BindPointPresetHardenedPublic})
+
+		req.Equal(BindPointPresetHardenedPublic, bindPoint.Preset)
+		req.Equal(5*time.Second, bindPoint.ReadTimeout)
+		req.Equal(2*time.Second, bindPoint.ReadHeaderTimeout)
+		req.Equal(16*1024, bindPoint.MaxHeaderBytes)
+		req.Equal(gmtls.VersionTLS13, bindPoint.MinTLSVersion)
+		req.Equal(ErrorVerbosityMinimal, bindPoint.ErrorVerbosity)
+		req.Equal("nosniff", bindPoint.ResponseHeaders.Get("X-Content-Type-Options"))
+	})
+
+	t.Run("explicit settings override the preset", func(t *testing.T) {
+		req := require.New(t)
+		bindPoint := parse(req, map[interface{}]interface{}{
+			"preset":         BindPointPresetHardenedPublic,
+			"readTimeout":    "30s",
+			"maxHeaderBytes": 4096,
+			"minTLSVersion":  "TLS1.2",
+			"errorVerbosity": "detailed",
+			"responseHeaders": map[interface{}]interface{}{
+				"x-frame-options": "SAMEORIGIN",
+				"X-Custom":        "value",
+			},
+		})
+
+		req.Equal(30*time.Second, bindPoint.ReadTimeout)
+		req.Equal(10*time.Second, bindPoint.WriteTimeout)
+		req.Equal(4096, bindPoint.MaxHeaderBytes)
+		req.Equal(gmtls.VersionTLS12, bindPoint.MinTLSVersion)
+		req.Equal(ErrorVerbosityDetailed, bindPoint.ErrorVerbosity)
+		req.Equal([]string{"SAMEORIGIN"}, bindPoint.ResponseHeaders.Values("X-Frame-Options"))
+		req.Equal("value", bindPoint.ResponseHeaders.Get("X-Custom"))
+		req.Equal("no-referrer", bindPoint.ResponseHeaders.Get("Referrer-Policy"))
+	})
+
+	t.Run("overriding headers does not modify the preset", func(t *testing.T) {
+		req := require.New(t)
+		parse(req, map[interface{}]interface{}{
+			"preset":          BindPointPresetDev,
+			"responseHeaders": map[interface{}]interface{}{"X-Content-Type-Options": "changed"},
+		})
+
+		req.Equal("nosniff", BindPointPresets[BindPointPresetDev].ResponseHeaders.Get("X-Content-Type-Options"))
+	})
+
+	t.Run("unknown presets are rejected", func(t *testing.T) {
+		req := require.New(t)
+		err := (&BindPointConfig{}).Parse(map[interface{}]interface{}{"preset": "other"})
+		req.EqualError(err, "unknown preset [other], must be one of: dev, hardened-public, internal-management")
+	})
+
+	t.Run("bind points without settings use the server options", func(t *testing.T) {
+		req := require.New(t)
+		bindPoint := parse(req, map[interface{}]interface{}{"idleTimeout": "1m"})
+		defaults := &TimeoutOptions{}
+		defaults.Default()
+
+		timeouts := bindPoint.timeouts(defaults)
+		req.Equal(DefaultHttpReadTimeout, timeouts.ReadTimeout)
+		req.Equal(DefaultHttpWriteTimeout, timeouts.WriteTimeout)
+		req.Equal(time.Minute, timeouts.IdleTimeout)
+	})
+
+	t.Run("the minimum TLS version is only raised", func(t *testing.T) {
+		req := require.New(t)
+		tlsConfig := &gmtls.Config{MinVersion: gmtls.VersionTLS12}
+
+		req.Same(tlsConfig, (&BindPointConfig{}).tlsConfig(tlsConfig))
+		req.Same(tlsConfig, (&BindPointConfig{MinTLSVersion: gmtls.VersionTLS11}).tlsConfig(tlsConfig))
+
+		raised := (&BindPointConfig{MinTLSVersion: gmtls.VersionTLS13}).tlsConfig(tlsConfig)
+		req.Equal(uint16(gmtls.VersionTLS13), raised.MinVersion)
+		req.Equal(uint16(gmtls.VersionTLS12), tlsConfig.MinVersion)
+	})
+
+	t.Run("the minimum TLS version is raised for selected configurations", func(t *testing.T) {
+		req := require.New(t)
+		selected := &gmtls.Config{MinVersion: gmtls.VersionTLS12}
+		tlsConfig := &gmtls.Config{
+			MinVersion: gmtls.VersionTLS12,
+			GetConfigForClient: func(*gmtls.ClientHelloInfo) (*gmtls.Config, error) {
+				return selected, nil
+			},
+		}
+
+		raised := (&BindPointConfig{MinTLSVersion: gmtls.VersionTLS13}).tlsConfig(tlsConfig)
+		config, err := raised.GetConfigForClient(&gmtls.ClientHelloInfo{})
+		req.NoError(err)
+		req.Equal(uint16(gmtls.VersionTLS13), config.MinVersion)
+		req.Equal(uint16(gmtls.VersionTLS12), selected.MinVersion)
+	})
+
+	t.Run("invalid settings are rejected", func(t *testing.T) {
+		req := require.New(t)
+		req.Error((&BindPointConfig{}).Parse(map[interface{}]interface{}{"readTimeout": "soon"}))
+		req.Error((&BindPointConfig{}).Parse(map[interface{}]interface{}{"maxHeaderBytes": "big"}))
+		req.Error((&BindPointConfig{}).Parse(map[interface{}]interface{}{"minTLSVersion": "TLS9"}))
+	})
+}
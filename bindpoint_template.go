@@ -0,0 +1,242 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxBindPointTemplateExpansion bounds the number of bind points a single template may generate
+const MaxBindPointTemplateExpansion = 10000
+
+var bindPointTemplateVariable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)}`)
+
+// bindPointTemplate is a bind point configuration map declaring a `template` section of variables. The bind point is
+// generated once for every combination of the variables' values, with ${name} references in its string settings
+// replaced by the values, e.g.:
+//
+//	bindPoints:
+//	  - template:
+//	      port: 8440-8449
+//	      tenant: [ acme, globex ]
+//	    interface: 0.0.0.0:${port}
+//	    address: ${tenant}.example.com:${port}
+//
+// Variable values are a list of strings and integers or a string range of integers, e.g. "8440-8449". A setting that
+// consists only of a reference to an integer variable is replaced by the integer.
+type bindPointTemplate struct {
+	names  []string
+	values map[string][]interface{}
+	config map[interface{}]interface{}
+}
+
+// isBindPointTemplate returns true if a bind point configuration map declares a template section
+func isBindPointTemplate(config map[interface{}]interface{}) bool {
+	_, ok := config["template"]
+	return ok
+}
+
+// parseBindPointTemplate parses the template section of a bind point configuration map
+func parseBindPointTemplate(config map[interface{}]interface{}) (*bindPointTemplate, error) {
+	variablesMap, ok := config["template"].(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("template must be a map of variables")
+	}
+
+	if len(variablesMap) == 0 {
+		return nil, errors.New("template must declare at least one variable")
+	}
+
+	template := &bindPointTemplate{
+		values: map[string][]interface{}{},
+		config: map[interface{}]interface{}{},
+	}
+
+	for key, value := range config {
+		if key != "template" {
+			template.config[key] = value
+		}
+	}
+
+	count := 1
+	for nameInterface, valueInterface := range variablesMap {
+		name, ok := nameInterface.(string)
+		if !ok || !bindPointTemplateVariable.MatchString("${"+name+"}") {
+			return nil, fmt.Errorf("invalid template variable name [%v]", nameInterface)
+		}
+
+		values, err := parseBindPointTemplateValues(valueInterface)
+		if err != nil {
+			return nil, fmt.Errorf("invalid values for template variable [%s]: %v", name, err)
+		}
+
+		count *= len(values)
+		if count > MaxBindPointTemplateExpansion {
+			return nil, fmt.Errorf("template generates more than %d bind points", MaxBindPointTemplateExpansion)
+		}
+
+		template.names = append(template.names, name)
+		template.values[name] = values
+	}
+
+	sort.Strings(template.names)
+
+	return template, nil
+}
+
+// parseBindPointTemplateValues parses a list of values or a string range of integers
+func parseBindPointTemplateValues(value interface{}) ([]interface{}, error) {
+	switch values := value.(type) {
+	case string:
+		start, end, err := parseBindPointTemplateRange(values)
+		if err != nil {
+			return nil, err
+		}
+		if end-start >= MaxBindPointTemplateExpansion {
+			return nil, fmt.Errorf("range [%s] has more than %d values", values, MaxBindPointTemplateExpansion)
+		}
+
+		var result []interface{}
+		for i := start; i <= end; i++ {
+			result = append(result, i)
+		}
+		return result, nil
+	case []interface{}:
+		if len(values) == 0 {
+			return nil, errors.New("list is empty")
+		}
+
+		for _, entry := range values {
+			switch entry.(type) {
+			case string, int:
+			default:
+				return nil, fmt.Errorf("value [%v] is not a string or integer", entry)
+			}
+		}
+		return values, nil
+	}
+
+	return nil, errors.New("not a list or range")
+}
+
+// parseBindPointTemplateRange parses an inclusive range of integers of the form <start>-<end>
+func parseBindPointTemplateRange(value string) (int, int, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid range [%s], must be of the form <start>-<end>", value)
+	}
+
+	start, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range [%s], start is not an integer", value)
+	}
+
+	end, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range [%s], end is not an integer", value)
+	}
+
+	if end < start {
+		return 0, 0, fmt.Errorf("invalid range [%s], end is before start", value)
+	}
+
+	return start, end, nil
+}
+
+// Expand returns a bind point configuration map for every combination of the template's variable values. The
+// combinations are ordered by variable name, with the values of the last name varying fastest.
+func (template *bindPointTemplate) Expand() ([]map[interface{}]interface{}, error) {
+	var result []map[interface{}]interface{}
+
+	var expand func(index int, variables map[string]interface{}) error
+	expand = func(index int, variables map[string]interface{}) error {
+		if index == len(template.names) {
+			config, err := substituteBindPointTemplate(template.config, variables)
+			if err != nil {
+				return err
+			}
+			result = append(result, config.(map[interface{}]interface{}))
+			return nil
+		}
+
+		name := template.names[index]
+		for _, value := range template.values[name] {
+			variables[name] = value
+			if err := expand(index+1, variables); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := expand(0, map[string]interface{}{}); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// substituteBindPointTemplate returns a copy of value with the variable references in its strings replaced
+func substituteBindPointTemplate(value interface{}, variables map[string]interface{}) (interface{}, error) {
+	switch typed := value.(type) {
+	case string:
+		if match := bindPointTemplateVariable.FindStringSubmatch(typed); match != nil && match[0] == typed {
+			if variable, ok := variables[match[1]]; ok {
+				return variable, nil
+			}
+		}
+
+		var err error
+		result := bindPointTemplateVariable.ReplaceAllStringFunc(typed, func(reference string) string {
+			name := reference[2 : len(reference)-1]
+			variable, ok := variables[name]
+			if !ok {
+				err = fmt.Errorf("unknown template variable [%s] in [%s]", name, typed)
+				return reference
+			}
+			return fmt.Sprint(variable)
+		})
+		return result, err
+	case map[interface{}]interface{}:
+		result := map[interface{}]interface{}{}
+		for key, entry := range typed {
+			substituted, err := substituteBindPointTemplate(entry, variables)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = substituted
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, 0, len(typed))
+		for _, entry := range typed {
+			substituted, err := substituteBindPointTemplate(entry, variables)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, substituted)
+		}
+		return result, nil
+	}
+
+	return value, nil
+}
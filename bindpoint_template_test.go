@@ -0,0 +1,77 @@
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBindPointTemplate(t *testing.T) {
+	parse := func(bindPoints ...interface{}) (*ServerConfig, error) {
+		config := &ServerConfig{}
+		err := config.Parse(map[interface{}]interface{}{
+			"name":       "test",
+			"apis":       []interface{}{map[interface{}]interface{}{"binding": "test"}},
+			"bindPoints": bindPoints,
+		}, "test")
+		return config, err
+	}
+
+	t.Run("templates generate a bind point per combination of values", func(t *testing.T) {
+		req := require.New(t)
+		config, err := parse(
+			map[interface{}]interface{}{"interface": "127.0.0.1:443", "address": "localhost:443"},
+			map[interface{}]interface{}{
+				"template": map[interface{}]interface{}{
+					"port":   "8440-8442",
+					"tenant": []interface{}{"acme", "globex"},
+				},
+				"interface":      "0.0.0.0:${port}",
+				"address":        "${tenant}.example.com:${port}",
+				"maxHeaderBytes": "${port}",
+				"responseHeaders": map[interface{}]interface{}{
+					"X-Tenant": "${tenant}",
+				},
+			},
+		)
+		req.NoError(err)
+		req.Len(config.BindPoints, 7)
+
+		req.Equal("localhost:443", config.BindPoints[0].Address)
+		req.Equal("0.0.0.0:8440", config.BindPoints[1].InterfaceAddress)
+		req.Equal("acme.example.com:8440", config.BindPoints[1].Address)
+		req.Equal(8440, config.BindPoints[1].MaxHeaderBytes)
+		req.Equal("acme", config.BindPoints[1].ResponseHeaders.Get("X-Tenant"))
+		req.Equal("globex.example.com:8440", config.BindPoints[2].Address)
+		req.Equal("acme.example.com:8441", config.BindPoints[3].Address)
+		req.Equal("globex.example.com:8442", config.BindPoints[6].Address)
+		req.Equal("globex", config.BindPoints[6].ResponseHeaders.Get("X-Tenant"))
+	})
+
+	t.Run("unknown variables are rejected", func(t *testing.T) {
+		req := require.New(t)
+		_, err := parse(map[interface{}]interface{}{
+			"template":  map[interface{}]interface{}{"port": "8440-8441"},
+			"interface": "0.0.0.0:${port}",
+			"address":   "${host}:${port}",
+		})
+		req.EqualError(err, "error parsing address configuration at index [0]: unknown template variable [host] in [${host}:${port}]")
+	})
+
+	t.Run("invalid ranges are rejected", func(t *testing.T) {
+		req := require.New(t)
+		_, err := parse(map[interface{}]interface{}{
+			"template":  map[interface{}]interface{}{"port": "8441-8440"},
+			"interface": "0.0.0.0:${port}",
+		})
+		req.EqualError(err, "error parsing address configuration at index [0]: invalid values for template variable [port]: invalid range [8441-8440], end is before start")
+	})
+
+	t.Run("templates generating too many bind points are rejected", func(t *testing.T) {
+		req := require.New(t)
+		_, err := parse(map[interface{}]interface{}{
+			"template":  map[interface{}]interface{}{"port": "1000-1999", "shard": "1-20"},
+			"interface": "0.0.0.0:${port}",
+		})
+		req.EqualError(err, "error parsing address configuration at index [0]: template generates more than 10000 bind points")
+	})
+}
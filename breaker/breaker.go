@@ -0,0 +1,385 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package breaker provides circuit breakers for the downstream dependencies of xweb handlers. A Breaker is closed
+// while its dependency is healthy. After FailureThreshold consecutive failures it opens and rejects calls with ErrOpen
+// for OpenTimeout, then lets HalfOpenProbes calls through. If they all succeed the breaker closes, a failing probe
+// opens it again.
+//
+// Breakers are shared by name through a Registry, so every handler that calls the same dependency trips and observes
+// the same breaker:
+//
+//	call, err := breakers.Get("billing").Allow()
+//	if err != nil {
+//		return err // fail fast, billing is down
+//	}
+//	resp, err := client.Do(request)
+//	call.Done(err == nil && resp.StatusCode < 500)
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+
+	DefaultFailureThreshold = 5
+	DefaultOpenTimeout      = 30 * time.Second
+	DefaultHalfOpenProbes   = 1
+)
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+// Options configures a Breaker
+type Options struct {
+	// FailureThreshold is the number of consecutive failures that open the breaker
+	FailureThreshold int
+
+	// OpenTimeout is how long the breaker rejects calls before probing the dependency
+	OpenTimeout time.Duration
+
+	// HalfOpenProbes is the number of calls let through while half-open, all of which must succeed to close the
+	// breaker
+	HalfOpenProbes int
+}
+
+// Default provides defaults for all necessary values
+func (options *Options) Default() {
+	options.FailureThreshold = DefaultFailureThreshold
+	options.OpenTimeout = DefaultOpenTimeout
+	options.HalfOpenProbes = DefaultHalfOpenProbes
+}
+
+// Parse parses a configuration map
+func (options *Options) Parse(config map[interface{}]interface{}) error {
+	for field, target := range map[string]*int{"failureThreshold": &options.FailureThreshold, "halfOpenProbes": &options.HalfOpenProbes} {
+		if interfaceVal, ok := config[field]; ok {
+			if value, ok := interfaceVal.(int); ok {
+				*target = value
+			} else {
+				return fmt.Errorf("could not use value for %s, not an integer", field)
+			}
+		}
+	}
+
+	if interfaceVal, ok := config["openTimeout"]; ok {
+		if timeoutStr, ok := interfaceVal.(string); ok {
+			if timeout, err := time.ParseDuration(timeoutStr); err == nil {
+				options.OpenTimeout = timeout
+			} else {
+				return fmt.Errorf("could not parse openTimeout %s as a duration (e.g. 30s): %v", timeoutStr, err)
+			}
+		} else {
+			return errors.New("could not use value for openTimeout, not a string")
+		}
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (options *Options) Validate() error {
+	if options.FailureThreshold < 1 {
+		return errors.New("failureThreshold must be at least 1")
+	}
+
+	if options.OpenTimeout <= 0 {
+		return errors.New("openTimeout must be greater than 0")
+	}
+
+	if options.HalfOpenProbes < 1 {
+		return errors.New("halfOpenProbes must be at least 1")
+	}
+
+	return nil
+}
+
+// Status is a point in time reading of a Breaker
+type Status struct {
+	Name                string    `json:"name"`
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Failures            int64     `json:"failures"`
+	Successes           int64     `json:"successes"`
+	Rejected            int64     `json:"rejected"`
+	Since               time.Time `json:"since"`
+	FailureThreshold    int       `json:"failureThreshold"`
+	OpenTimeout         string    `json:"openTimeout"`
+	HalfOpenProbes      int       `json:"halfOpenProbes"`
+}
+
+// Breaker is a circuit breaker for a named dependency
+type Breaker struct {
+	name    string
+	lock    sync.Mutex
+	options Options
+
+	state               string
+	generation          uint64
+	since               time.Time
+	consecutiveFailures int
+	probes              int
+	probeSuccesses      int
+	failures            int64
+	successes           int64
+	rejected            int64
+
+	// onStateChange is called for state changes once the lock is released, see unlock
+	onStateChange func(breaker *Breaker, from, to string)
+	changes       []stateChange
+	notifying     bool
+
+	onResult   func(breaker *Breaker, success bool)
+	onRejected func(breaker *Breaker)
+	now        func() time.Time
+}
+
+// New returns a closed Breaker for validated Options
+func New(name string, options Options) *Breaker {
+	return &Breaker{
+		name:    name,
+		options: options,
+		state:   StateClosed,
+		since:   time.Now(),
+		now:     time.Now,
+	}
+}
+
+// Name returns the name of the dependency the Breaker protects
+func (breaker *Breaker) Name() string {
+	return breaker.name
+}
+
+// State returns StateClosed, StateOpen or StateHalfOpen
+func (breaker *Breaker) State() string {
+	breaker.lock.Lock()
+	defer breaker.unlock()
+
+	breaker.refresh()
+	return breaker.state
+}
+
+// Allow returns ErrOpen if the call should not be made. Otherwise, the outcome of the call must be reported once
+// through the returned Call.
+func (breaker *Breaker) Allow() (*Call, error) {
+	breaker.lock.Lock()
+	defer breaker.unlock()
+
+	breaker.refresh()
+
+	switch breaker.state {
+	case StateOpen:
+		return nil, breaker.reject()
+	case StateHalfOpen:
+		if breaker.probes >= breaker.options.HalfOpenProbes {
+			return nil, breaker.reject()
+		}
+		breaker.probes++
+	}
+
+	return &Call{
+		breaker:    breaker,
+		generation: breaker.generation,
+	}, nil
+}
+
+// Do calls fn if the breaker allows it, recording an error returned by fn as a failure
+func (breaker *Breaker) Do(fn func() error) error {
+	call, err := breaker.Allow()
+	if err != nil {
+		return err
+	}
+
+	err = fn()
+	call.Done(err == nil)
+	return err
+}
+
+// Reset closes the breaker, i.e. after an operator has verified the dependency has recovered
+func (breaker *Breaker) Reset() {
+	breaker.lock.Lock()
+	defer breaker.unlock()
+
+	breaker.transition(StateClosed)
+}
+
+// Status returns a point in time reading of the breaker
+func (breaker *Breaker) Status() *Status {
+	breaker.lock.Lock()
+	defer breaker.unlock()
+
+	breaker.refresh()
+
+	return &Status{
+		Name:                breaker.name,
+		State:               breaker.state,
+		ConsecutiveFailures: breaker.consecutiveFailures,
+		Failures:            breaker.failures,
+		Successes:           breaker.successes,
+		Rejected:            breaker.rejected,
+		Since:               breaker.since,
+		FailureThreshold:    breaker.options.FailureThreshold,
+		OpenTimeout:         breaker.options.OpenTimeout.String(),
+		HalfOpenProbes:      breaker.options.HalfOpenProbes,
+	}
+}
+
+// setOptions replaces the options of the breaker, its state is kept
+func (breaker *Breaker) setOptions(options Options) {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	breaker.options = options
+}
+
+func (breaker *Breaker) reject() error {
+	breaker.rejected++
+	if breaker.onRejected != nil {
+		breaker.onRejected(breaker)
+	}
+	return ErrOpen
+}
+
+// record counts the outcome of a call. Outcomes of calls allowed before the last state change are only counted in
+// the totals, they do not count towards the consecutive failures or change the state.
+func (breaker *Breaker) record(generation uint64, success bool) {
+	breaker.lock.Lock()
+	defer breaker.unlock()
+
+	if breaker.onResult != nil {
+		breaker.onResult(breaker, success)
+	}
+
+	if success {
+		breaker.successes++
+	} else {
+		breaker.failures++
+	}
+
+	if generation != breaker.generation {
+		return
+	}
+
+	if success {
+		breaker.consecutiveFailures = 0
+	} else {
+		breaker.consecutiveFailures++
+	}
+
+	switch breaker.state {
+	case StateClosed:
+		if breaker.consecutiveFailures >= breaker.options.FailureThreshold {
+			breaker.transition(StateOpen)
+		}
+	case StateHalfOpen:
+		if !success {
+			breaker.transition(StateOpen)
+			return
+		}
+		breaker.probeSuccesses++
+		if breaker.probeSuccesses >= breaker.options.HalfOpenProbes {
+			breaker.transition(StateClosed)
+		}
+	}
+}
+
+// abandon releases a half-open probe whose outcome is unknown, so that another call may probe the dependency
+func (breaker *Breaker) abandon(generation uint64) {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	if generation == breaker.generation && breaker.state == StateHalfOpen && breaker.probes > 0 {
+		breaker.probes--
+	}
+}
+
+// refresh moves an open breaker to half-open once the open timeout has passed
+func (breaker *Breaker) refresh() {
+	if breaker.state == StateOpen && breaker.now().Sub(breaker.since) >= breaker.options.OpenTimeout {
+		breaker.transition(StateHalfOpen)
+	}
+}
+
+func (breaker *Breaker) transition(state string) {
+	from := breaker.state
+	breaker.state = state
+	breaker.generation++
+	breaker.since = breaker.now()
+	breaker.probes = 0
+	breaker.probeSuccesses = 0
+	if state == StateClosed {
+		breaker.consecutiveFailures = 0
+	}
+
+	if from != state && breaker.onStateChange != nil {
+		breaker.changes = append(breaker.changes, stateChange{from: from, to: state})
+	}
+}
+
+// stateChange is a state change waiting to be delivered to onStateChange
+type stateChange struct {
+	from string
+	to   string
+}
+
+// unlock releases the lock and delivers the queued state changes to onStateChange, which may then use the breaker.
+// Changes are delivered in order by one goroutine at a time, changes queued while it delivers, including by
+// onStateChange itself, are delivered by it as well.
+func (breaker *Breaker) unlock() {
+	if breaker.notifying || len(breaker.changes) == 0 {
+		breaker.lock.Unlock()
+		return
+	}
+
+	breaker.notifying = true
+	for len(breaker.changes) > 0 {
+		change := breaker.changes[0]
+		breaker.changes = breaker.changes[1:]
+
+		breaker.lock.Unlock()
+		breaker.onStateChange(breaker, change.from, change.to)
+		breaker.lock.Lock()
+	}
+	breaker.notifying = false
+	breaker.lock.Unlock()
+}
+
+// Call is a call to a dependency allowed by a Breaker. Only the first outcome reported is recorded.
+type Call struct {
+	breaker    *Breaker
+	generation uint64
+	once       sync.Once
+}
+
+// Done records the outcome of the call
+func (call *Call) Done(success bool) {
+	call.once.Do(func() {
+		call.breaker.record(call.generation, success)
+	})
+}
+
+// Abandon ends a call without an outcome, i.e. because the caller went away before the dependency answered
+func (call *Call) Abandon() {
+	call.once.Do(func() {
+		call.breaker.abandon(call.generation)
+	})
+}
@@ -0,0 +1,216 @@
+package breaker
+
+import (
+	"errors"
+	"github.com/openziti/xweb/v2/metrics"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func Test_Breaker(t *testing.T) {
+	newBreaker := func(options Options) (*Breaker, *time.Time) {
+		now := time.Now()
+		breaker := New("test", options)
+		breaker.now = func() time.Time {
+			return now
+		}
+		return breaker, &now
+	}
+
+	failure := errors.New("failure")
+	fail := func() error { return failure }
+	succeed := func() error { return nil }
+
+	t.Run("consecutive failures open the breaker", func(t *testing.T) {
+		req := require.New(t)
+		breaker, _ := newBreaker(Options{FailureThreshold: 3, OpenTimeout: time.Minute, HalfOpenProbes: 1})
+
+		req.ErrorIs(breaker.Do(fail), failure)
+		req.ErrorIs(breaker.Do(fail), failure)
+		req.NoError(breaker.Do(succeed))
+		req.ErrorIs(breaker.Do(fail), failure)
+		req.ErrorIs(breaker.Do(fail), failure)
+		req.Equal(StateClosed, breaker.State())
+
+		req.ErrorIs(breaker.Do(fail), failure)
+		req.Equal(StateOpen, breaker.State())
+
+		called := false
+		req.ErrorIs(breaker.Do(func() error {
+			called = true
+			return nil
+		}), ErrOpen)
+		req.False(called)
+
+		status := breaker.Status()
+		req.Equal(int64(5), status.Failures)
+		req.Equal(int64(1), status.Successes)
+		req.Equal(int64(1), status.Rejected)
+	})
+
+	t.Run("half-open probes close the breaker on success", func(t *testing.T) {
+		req := require.New(t)
+		breaker, now := newBreaker(Options{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenProbes: 2})
+
+		req.ErrorIs(breaker.Do(fail), failure)
+		req.Equal(StateOpen, breaker.State())
+
+		*now = now.Add(time.Minute)
+		req.Equal(StateHalfOpen, breaker.State())
+
+		first, err := breaker.Allow()
+		req.NoError(err)
+		second, err := breaker.Allow()
+		req.NoError(err)
+		_, err = breaker.Allow()
+		req.ErrorIs(err, ErrOpen)
+
+		first.Done(true)
+		req.Equal(StateHalfOpen, breaker.State())
+		second.Done(true)
+		second.Done(false)
+		req.Equal(StateClosed, breaker.State())
+	})
+
+	t.Run("a failed probe opens the breaker again", func(t *testing.T) {
+		req := require.New(t)
+		breaker, now := newBreaker(Options{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenProbes: 1})
+
+		req.ErrorIs(breaker.Do(fail), failure)
+		*now = now.Add(time.Minute)
+
+		req.ErrorIs(breaker.Do(fail), failure)
+		req.Equal(StateOpen, breaker.State())
+	})
+
+	t.Run("abandoned probes free their slot", func(t *testing.T) {
+		req := require.New(t)
+		breaker, now := newBreaker(Options{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenProbes: 1})
+
+		req.ErrorIs(breaker.Do(fail), failure)
+		*now = now.Add(time.Minute)
+
+		call, err := breaker.Allow()
+		req.NoError(err)
+		call.Abandon()
+
+		req.NoError(breaker.Do(succeed))
+		req.Equal(StateClosed, breaker.State())
+	})
+
+	t.Run("calls allowed before a state change do not change the state", func(t *testing.T) {
+		req := require.New(t)
+		breaker, now := newBreaker(Options{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenProbes: 1})
+
+		stale, err := breaker.Allow()
+		req.NoError(err)
+
+		req.ErrorIs(breaker.Do(fail), failure)
+		*now = now.Add(time.Minute)
+		req.Equal(StateHalfOpen, breaker.State())
+
+		stale.Done(true)
+		req.Equal(StateHalfOpen, breaker.State())
+	})
+
+	t.Run("calls allowed before a state change do not count towards consecutive failures", func(t *testing.T) {
+		req := require.New(t)
+		breaker, _ := newBreaker(Options{FailureThreshold: 2, OpenTimeout: time.Minute, HalfOpenProbes: 1})
+
+		stale, err := breaker.Allow()
+		req.NoError(err)
+		breaker.Reset()
+
+		req.ErrorIs(breaker.Do(fail), failure)
+		stale.Done(false)
+		req.Equal(StateClosed, breaker.State())
+
+		status := breaker.Status()
+		req.Equal(1, status.ConsecutiveFailures)
+		req.Equal(int64(2), status.Failures)
+	})
+
+	t.Run("invalid options are rejected", func(t *testing.T) {
+		req := require.New(t)
+		options := Options{}
+		options.Default()
+		req.EqualError(options.Parse(map[interface{}]interface{}{"openTimeout": 5}), "could not use value for openTimeout, not a string")
+
+		req.NoError(options.Parse(map[interface{}]interface{}{"failureThreshold": 0}))
+		req.EqualError(options.Validate(), "failureThreshold must be at least 1")
+	})
+}
+
+func Test_Registry(t *testing.T) {
+	t.Run("breakers are shared by name and report metrics and state changes", func(t *testing.T) {
+		req := require.New(t)
+		metricsRegistry := metrics.NewRegistry()
+		registry := NewRegistry(metricsRegistry)
+		req.NoError(registry.Configure("billing", Options{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenProbes: 1}))
+
+		var changes []string
+		registry.AddListener(func(breaker *Breaker, from, to string) {
+			changes = append(changes, breaker.Name()+":"+from+"->"+to)
+		})
+
+		billing := registry.Get("billing")
+		req.Same(billing, registry.Get("billing"))
+
+		req.Error(billing.Do(func() error { return errors.New("down") }))
+		req.ErrorIs(billing.Do(func() error { return nil }), ErrOpen)
+
+		req.Equal([]string{"billing:closed->open"}, changes)
+
+		labels := metrics.Labels{"dependency": "billing"}
+		req.Equal(int64(2), metricsRegistry.Gauge(MetricState, labels).Value())
+		req.Equal(int64(1), metricsRegistry.Counter(MetricFailures, labels).Count())
+		req.Equal(int64(1), metricsRegistry.Counter(MetricRejected, labels).Count())
+
+		billing.Reset()
+		req.Equal(int64(0), metricsRegistry.Gauge(MetricState, labels).Value())
+		req.Equal([]string{"billing:closed->open", "billing:open->closed"}, changes)
+	})
+
+	t.Run("listeners may use the breaker", func(t *testing.T) {
+		req := require.New(t)
+		registry := NewRegistry(metrics.NewRegistry())
+		req.NoError(registry.Configure("billing", Options{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenProbes: 1}))
+
+		var states []string
+		registry.AddListener(func(breaker *Breaker, from, to string) {
+			states = append(states, breaker.Status().State)
+			if to == StateOpen {
+				breaker.Reset()
+			}
+		})
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = registry.Get("billing").Do(func() error { return errors.New("down") })
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			req.FailNow("listener deadlocked")
+		}
+
+		req.Equal([]string{StateOpen, StateClosed}, states)
+		req.Equal(int64(0), registry.metrics.Gauge(MetricState, metrics.Labels{"dependency": "billing"}).Value())
+	})
+
+	t.Run("unconfigured breakers use the defaults", func(t *testing.T) {
+		req := require.New(t)
+		registry := NewRegistry(nil)
+		req.Equal(DefaultFailureThreshold, registry.Get("search").Status().FailureThreshold)
+
+		req.NoError(registry.SetDefaults(Options{FailureThreshold: 2, OpenTimeout: time.Second, HalfOpenProbes: 1}))
+		req.Equal(2, registry.Get("search").Status().FailureThreshold)
+
+		statuses := registry.Statuses()
+		req.Len(statuses, 1)
+		req.Equal("search", statuses[0].Name)
+	})
+}
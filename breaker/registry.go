@@ -0,0 +1,197 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package breaker
+
+import (
+	"fmt"
+	"github.com/openziti/xweb/v2/metrics"
+	"sort"
+	"sync"
+)
+
+const (
+	// MetricState is a gauge of a breaker's state: 0 closed, 1 half-open, 2 open
+	MetricState       = "xweb.breaker.state"
+	MetricFailures    = "xweb.breaker.failures"
+	MetricRejected    = "xweb.breaker.rejected"
+	MetricTransitions = "xweb.breaker.transitions"
+)
+
+var stateValues = map[string]int64{
+	StateClosed:   0,
+	StateHalfOpen: 1,
+	StateOpen:     2,
+}
+
+// StateChangeListener is notified when a Breaker changes state. It is called synchronously while the Breaker is
+// locked and must neither block nor call the Breaker.
+type StateChangeListener func(breaker *Breaker, from, to string)
+
+// Registry creates and shares Breaker's by dependency name and reports their state and outcomes to a
+// metrics.Registry, labeled by dependency
+type Registry struct {
+	lock     sync.Mutex
+	breakers map[string]*Breaker
+	options  map[string]Options
+	defaults Options
+	metrics  metrics.Registry
+
+	// listeners are guarded separately as they are read while a Breaker is locked
+	listenersLock sync.RWMutex
+	listeners     []StateChangeListener
+}
+
+// NewRegistry returns a Registry whose Breaker's use default Options unless configured otherwise. metricsRegistry
+// may be nil.
+func NewRegistry(metricsRegistry metrics.Registry) *Registry {
+	registry := &Registry{
+		breakers: map[string]*Breaker{},
+		options:  map[string]Options{},
+		metrics:  metricsRegistry,
+	}
+	registry.defaults.Default()
+	return registry
+}
+
+// SetDefaults replaces the Options used by Breaker's without options of their own. Existing Breaker's are updated.
+func (registry *Registry) SetDefaults(options Options) error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	registry.defaults = options
+	for name, breaker := range registry.breakers {
+		if _, ok := registry.options[name]; !ok {
+			breaker.setOptions(options)
+		}
+	}
+	return nil
+}
+
+// Configure sets the Options of the Breaker for a dependency. An existing Breaker keeps its state.
+func (registry *Registry) Configure(name string, options Options) error {
+	if err := options.Validate(); err != nil {
+		return fmt.Errorf("invalid options for circuit breaker [%s]: %v", name, err)
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	registry.options[name] = options
+	if breaker, ok := registry.breakers[name]; ok {
+		breaker.setOptions(options)
+	}
+	return nil
+}
+
+// AddListener registers a StateChangeListener for all Breaker's of the Registry
+func (registry *Registry) AddListener(listener StateChangeListener) {
+	registry.listenersLock.Lock()
+	defer registry.listenersLock.Unlock()
+
+	registry.listeners = append(registry.listeners, listener)
+}
+
+// Get returns the Breaker for a dependency, creating it on first use
+func (registry *Registry) Get(name string) *Breaker {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if breaker, ok := registry.breakers[name]; ok {
+		return breaker
+	}
+
+	options, ok := registry.options[name]
+	if !ok {
+		options = registry.defaults
+	}
+
+	breaker := New(name, options)
+	registry.instrument(breaker)
+	registry.breakers[name] = breaker
+	return breaker
+}
+
+// Lookup returns the Breaker for a dependency if it has been created
+func (registry *Registry) Lookup(name string) (*Breaker, bool) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	breaker, ok := registry.breakers[name]
+	return breaker, ok
+}
+
+// Statuses returns the Status of every Breaker, sorted by name
+func (registry *Registry) Statuses() []*Status {
+	registry.lock.Lock()
+	var breakers []*Breaker
+	for _, breaker := range registry.breakers {
+		breakers = append(breakers, breaker)
+	}
+	registry.lock.Unlock()
+
+	var result []*Status
+	for _, breaker := range breakers {
+		result = append(result, breaker.Status())
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func (registry *Registry) instrument(breaker *Breaker) {
+	var state metrics.Gauge
+	var failures, rejected, transitions metrics.Counter
+
+	if registry.metrics != nil {
+		labels := metrics.Labels{"dependency": breaker.name}
+		state = registry.metrics.Gauge(MetricState, labels)
+		failures = registry.metrics.Counter(MetricFailures, labels)
+		rejected = registry.metrics.Counter(MetricRejected, labels)
+		transitions = registry.metrics.Counter(MetricTransitions, labels)
+		state.Set(stateValues[StateClosed])
+
+		breaker.onResult = func(_ *Breaker, success bool) {
+			if !success {
+				failures.Inc(1)
+			}
+		}
+		breaker.onRejected = func(*Breaker) {
+			rejected.Inc(1)
+		}
+	}
+
+	breaker.onStateChange = func(breaker *Breaker, from, to string) {
+		if state != nil {
+			state.Set(stateValues[to])
+			transitions.Inc(1)
+		}
+
+		registry.listenersLock.RLock()
+		listeners := registry.listeners
+		registry.listenersLock.RUnlock()
+
+		for _, listener := range listeners {
+			listener(breaker, from, to)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package bufpool provides named, instrumented sync.Pool's of bytes.Buffer's used on xweb's response writing paths:
+// compression, access log formatting and error rendering. Buffers that have grown beyond a pool's retention limit are
+// discarded rather than returned so that a few large responses do not pin memory.
+package bufpool
+
+import (
+	"bytes"
+	"github.com/openziti/xweb/v2/metrics"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	MetricGets        = "xweb.bufferPool.gets"
+	MetricAllocations = "xweb.bufferPool.allocations"
+	MetricDiscards    = "xweb.bufferPool.discards"
+)
+
+var (
+	// Compression buffers hold compressed response bodies until the Content-Length is known
+	Compression = New("compression", 1024*1024)
+
+	// AccessLog buffers hold formatted access and audit log records
+	AccessLog = New("accessLog", 64*1024)
+
+	// ErrorPage buffers hold rendered error responses
+	ErrorPage = New("errorPage", 16*1024)
+)
+
+var pools = []*Pool{Compression, AccessLog, ErrorPage}
+
+// Pools returns the pools provided by the package
+func Pools() []*Pool {
+	return append([]*Pool{}, pools...)
+}
+
+// Instrument reports the gets, allocations and discards of all pools to the registry, labeled by pool name. Pools are
+// process wide, if called for several registries the last one receives the values.
+func Instrument(registry metrics.Registry) {
+	for _, pool := range pools {
+		pool.Instrument(registry)
+	}
+}
+
+// Stats are the running totals for a Pool. Allocations relative to Gets indicate how well the pool is reused,
+// Discards indicate how often the retention limit is exceeded.
+type Stats struct {
+	Gets        int64
+	Allocations int64
+	Discards    int64
+}
+
+// Pool is a sync.Pool of bytes.Buffer's
+type Pool struct {
+	name        string
+	maxRetained int
+	pool        sync.Pool
+
+	gets        int64
+	allocations int64
+	discards    int64
+
+	counters atomic.Value
+}
+
+type poolCounters struct {
+	gets        metrics.Counter
+	allocations metrics.Counter
+	discards    metrics.Counter
+}
+
+// New creates a Pool. Buffers with a capacity larger than maxRetained are not returned to the pool.
+func New(name string, maxRetained int) *Pool {
+	result := &Pool{
+		name:        name,
+		maxRetained: maxRetained,
+	}
+
+	result.pool.New = func() interface{} {
+		atomic.AddInt64(&result.allocations, 1)
+		if counters := result.getCounters(); counters != nil {
+			counters.allocations.Inc(1)
+		}
+		return &bytes.Buffer{}
+	}
+
+	return result
+}
+
+// Name returns the name of the pool, used as the pool label of its metrics
+func (pool *Pool) Name() string {
+	return pool.name
+}
+
+// Get returns an empty buffer
+func (pool *Pool) Get() *bytes.Buffer {
+	atomic.AddInt64(&pool.gets, 1)
+	if counters := pool.getCounters(); counters != nil {
+		counters.gets.Inc(1)
+	}
+
+	buffer := pool.pool.Get().(*bytes.Buffer)
+	buffer.Reset()
+	return buffer
+}
+
+// Put returns a buffer to the pool. The buffer, and any slices returned by it, must not be used afterwards.
+func (pool *Pool) Put(buffer *bytes.Buffer) {
+	if buffer == nil {
+		return
+	}
+
+	if buffer.Cap() > pool.maxRetained {
+		atomic.AddInt64(&pool.discards, 1)
+		if counters := pool.getCounters(); counters != nil {
+			counters.discards.Inc(1)
+		}
+		return
+	}
+
+	pool.pool.Put(buffer)
+}
+
+// Stats returns the pool's running totals
+func (pool *Pool) Stats() Stats {
+	return Stats{
+		Gets:        atomic.LoadInt64(&pool.gets),
+		Allocations: atomic.LoadInt64(&pool.allocations),
+		Discards:    atomic.LoadInt64(&pool.discards),
+	}
+}
+
+// Instrument reports the pool's gets, allocations and discards to the registry from now on
+func (pool *Pool) Instrument(registry metrics.Registry) {
+	labels := metrics.Labels{"pool": pool.name}
+	pool.counters.Store(&poolCounters{
+		gets:        registry.Counter(MetricGets, labels),
+		allocations: registry.Counter(MetricAllocations, labels),
+		discards:    registry.Counter(MetricDiscards, labels),
+	})
+}
+
+func (pool *Pool) getCounters() *poolCounters {
+	counters, _ := pool.counters.Load().(*poolCounters)
+	return counters
+}
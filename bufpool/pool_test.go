@@ -0,0 +1,47 @@
+package bufpool
+
+import (
+	"github.com/openziti/xweb/v2/metrics"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	t.Run("buffers are returned empty", func(t *testing.T) {
+		req := require.New(t)
+		pool := New("test", 1024)
+
+		buffer := pool.Get()
+		buffer.WriteString("hello")
+		pool.Put(buffer)
+
+		req.Zero(pool.Get().Len())
+	})
+
+	t.Run("oversized buffers are discarded", func(t *testing.T) {
+		req := require.New(t)
+		pool := New("test", 16)
+
+		buffer := pool.Get()
+		buffer.Grow(1024)
+		pool.Put(buffer)
+
+		req.Equal(int64(1), pool.Stats().Discards)
+	})
+
+	t.Run("instrumented pools report to the registry", func(t *testing.T) {
+		req := require.New(t)
+		pool := New("test", 1024)
+		registry := metrics.NewRegistry()
+		pool.Instrument(registry)
+
+		pool.Put(pool.Get())
+		pool.Get()
+
+		labels := metrics.Labels{"pool": "test"}
+		req.Equal(int64(2), registry.Counter(MetricGets, labels).Count())
+		req.Equal(pool.Stats().Allocations, registry.Counter(MetricAllocations, labels).Count())
+		req.GreaterOrEqual(pool.Stats().Allocations, int64(1))
+		req.Zero(registry.Counter(MetricDiscards, labels).Count())
+	})
+}
@@ -0,0 +1,394 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"gitee.com/zhaochuninhefei/gmgo/x509"
+	"github.com/fsnotify/fsnotify"
+	"github.com/michaelquigley/pfxlog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	EventTypeCaBundleReload = "xweb.caBundle.reload"
+
+	// caBundleReloadDelay de-duplicates the bursts of file system events produced by editors and atomic renames
+	caBundleReloadDelay = 500 * time.Millisecond
+)
+
+// ClientAuthMap is a map of configuration strings to TLS client authentication types
+var ClientAuthMap = map[string]gmtls.ClientAuthType{
+	"request":          gmtls.RequestClientCert,
+	"require":          gmtls.RequireAnyClientCert,
+	"verifyIfGiven":    gmtls.VerifyClientCertIfGiven,
+	"requireAndVerify": gmtls.RequireAndVerifyClientCert,
+}
+
+// ClientCaOptions represents the client certificate verification options for a ServerConfig
+type ClientCaOptions struct {
+	// ClientAuth is the TLS client authentication type, defaults to gmtls.RequestClientCert which requests but does
+	// not verify client certificates, leaving verification to ApiHandler's
+	ClientAuth    gmtls.ClientAuthType
+	clientAuthStr string
+
+	// ClientCaBundle is a PEM file of CAs used to verify client certificates. The file is watched and reloaded on
+	// change. If not set, the CAs of the server identity are used.
+	ClientCaBundle string
+}
+
+// Default provides defaults for all necessary values
+func (options *ClientCaOptions) Default() {
+	options.ClientAuth = gmtls.RequestClientCert
+	options.clientAuthStr = ""
+	options.ClientCaBundle = ""
+}
+
+// Parse parses a configuration map
+func (options *ClientCaOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["clientAuth"]; ok {
+		if options.clientAuthStr, ok = interfaceVal.(string); ok {
+			if clientAuth, ok := ClientAuthMap[options.clientAuthStr]; ok {
+				options.ClientAuth = clientAuth
+			} else {
+				return fmt.Errorf("could not use value for clientAuth, invalid value [%s], must be one of: request, require, verifyIfGiven, requireAndVerify", options.clientAuthStr)
+			}
+		} else {
+			return errors.New("could not use value for clientAuth, not a string")
+		}
+	}
+
+	if interfaceVal, ok := config["clientCaBundle"]; ok {
+		if bundle, ok := interfaceVal.(string); ok {
+			options.ClientCaBundle = bundle
+		} else {
+			return errors.New("could not use value for clientCaBundle, not a string")
+		}
+	}
+
+	return nil
+}
+
+// Validate validates the configured options
+func (options *ClientCaOptions) Validate() error {
+	if options.ClientCaBundle != "" {
+		if _, err := loadCaBundle(options.ClientCaBundle); err != nil {
+			return fmt.Errorf("invalid clientCaBundle: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// CaBundleReloadEvent is dispatched when a CaBundle has been reloaded or has failed to reload. On failure the
+// previously loaded CAs remain in use.
+type CaBundleReloadEvent struct {
+	Path         string `json:"path"`
+	Certificates int    `json:"certificates"`
+	Error        string `json:"error,omitempty"`
+}
+
+func (event *CaBundleReloadEvent) EventType() string {
+	return EventTypeCaBundleReload
+}
+
+type loadedCaBundle struct {
+	pool         *x509.CertPool
+	certificates int
+	info         os.FileInfo
+}
+
+// isCurrent returns true if info describes the same file, unmodified, as the bundle was loaded from
+func (loaded *loadedCaBundle) isCurrent(info os.FileInfo) bool {
+	return loaded.info != nil && os.SameFile(loaded.info, info) && loaded.info.ModTime().Equal(info.ModTime()) && loaded.info.Size() == info.Size()
+}
+
+// CaBundle is a PEM file of CA certificates that is reloaded when the file changes. A single CaBundle is shared by all
+// bind points that reference the same file.
+type CaBundle struct {
+	path      string
+	loaded    atomic.Value
+	events    EventDispatcher
+	watcher   *fsnotify.Watcher
+	closeOnce sync.Once
+}
+
+// Path returns the file the CaBundle is loaded from
+func (bundle *CaBundle) Path() string {
+	return bundle.path
+}
+
+// Pool returns the currently loaded CAs
+func (bundle *CaBundle) Pool() *x509.CertPool {
+	return bundle.loaded.Load().(*loadedCaBundle).pool
+}
+
+// Reload reads the bundle file. If the file cannot be loaded the previously loaded CAs are retained.
+func (bundle *CaBundle) Reload() error {
+	loaded, err := loadCaBundle(bundle.path)
+
+	event := &CaBundleReloadEvent{
+		Path: bundle.path,
+	}
+
+	if err == nil {
+		bundle.loaded.Store(loaded)
+		event.Certificates = loaded.certificates
+		pfxlog.Logger().Infof("reloaded CA bundle [%s] with %d certificates", bundle.path, loaded.certificates)
+	} else {
+		event.Error = err.Error()
+		pfxlog.Logger().Errorf("could not reload CA bundle [%s], previous CAs remain in use: %v", bundle.path, err)
+	}
+
+	if bundle.events != nil {
+		bundle.events.Dispatch(event)
+	}
+
+	return err
+}
+
+// reloadIfChanged reloads the bundle if the file it resolves to, following symlinks, has changed since it was loaded
+func (bundle *CaBundle) reloadIfChanged() {
+	info, err := os.Stat(bundle.path)
+	if err == nil && bundle.loaded.Load().(*loadedCaBundle).isCurrent(info) {
+		return
+	}
+	_ = bundle.Reload()
+}
+
+// watchDirs returns the directories to watch for changes to path, which are its directory and, if path is a symlink,
+// the directory of the file it resolves to
+func watchDirs(path string) []string {
+	result := []string{filepath.Dir(path)}
+
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		if resolvedDir := filepath.Dir(resolved); resolvedDir != result[0] {
+			result = append(result, resolvedDir)
+		}
+	}
+
+	return result
+}
+
+func (bundle *CaBundle) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	//directories are watched as files replaced by rename (i.e. editors) lose file watches and Kubernetes secrets are
+	//updated by swapping the ..data symlink the bundle file resolves through, which produces no event for the file
+	for _, dir := range watchDirs(bundle.path) {
+		if err = watcher.Add(dir); err != nil {
+			_ = watcher.Close()
+			return err
+		}
+	}
+
+	bundle.watcher = watcher
+
+	go func() {
+		var reload <-chan time.Time
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				reload = time.After(caBundleReloadDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				pfxlog.Logger().Errorf("error watching CA bundle [%s]: %v", bundle.path, err)
+			case <-reload:
+				reload = nil
+				bundle.reloadIfChanged()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops watching the bundle file
+func (bundle *CaBundle) Close() {
+	bundle.closeOnce.Do(func() {
+		if bundle.watcher != nil {
+			_ = bundle.watcher.Close()
+		}
+	})
+}
+
+func loadCaBundle(path string) (*loadedCaBundle, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &loadedCaBundle{
+		pool: x509.NewCertPool(),
+		info: info,
+	}
+
+	for block, rest := pem.Decode(pemBytes); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse certificate %d in [%s]: %v", result.certificates+1, path, err)
+		}
+
+		result.pool.AddCert(cert)
+		result.certificates++
+	}
+
+	if result.certificates == 0 {
+		return nil, fmt.Errorf("no certificates found in [%s]", path)
+	}
+
+	return result, nil
+}
+
+// caBundles holds the CaBundle's of an Instance by path
+type caBundles struct {
+	lock    sync.Mutex
+	events  EventDispatcher
+	bundles map[string]*CaBundle
+}
+
+func newCaBundles(events EventDispatcher) *caBundles {
+	return &caBundles{
+		events:  events,
+		bundles: map[string]*CaBundle{},
+	}
+}
+
+// get returns the CaBundle for path, loading and watching it on first use
+func (bundles *caBundles) get(path string) (*CaBundle, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bundles.lock.Lock()
+	defer bundles.lock.Unlock()
+
+	if bundle, ok := bundles.bundles[absPath]; ok {
+		return bundle, nil
+	}
+
+	loaded, err := loadCaBundle(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &CaBundle{
+		path:   absPath,
+		events: bundles.events,
+	}
+	bundle.loaded.Store(loaded)
+
+	if err = bundle.watch(); err != nil {
+		return nil, fmt.Errorf("could not watch CA bundle [%s]: %v", absPath, err)
+	}
+
+	bundles.bundles[absPath] = bundle
+
+	return bundle, nil
+}
+
+func (bundles *caBundles) close() {
+	bundles.lock.Lock()
+	defer bundles.lock.Unlock()
+
+	for path, bundle := range bundles.bundles {
+		bundle.Close()
+		delete(bundles.bundles, path)
+	}
+}
+
+// clientCaConfig is a clone of a server TLS configuration with the client CAs current at the time it was created
+type clientCaConfig struct {
+	base   *gmtls.Config
+	pool   *x509.CertPool
+	config *gmtls.Config
+}
+
+// applyClientCas configures client certificate verification on a server TLS configuration. CAs are resolved for each
+// client hello so that reloaded bundles, or reloaded identity CAs, take effect for new connections immediately. The
+// configuration returned for client hellos is cached until the CAs, or the configuration it is cloned from, change.
+func (options *ClientCaOptions) applyClientCas(tlsConfig *gmtls.Config, bundle *CaBundle, caPool func() *x509.CertPool) {
+	tlsConfig.ClientAuth = options.ClientAuth
+
+	if options.ClientAuth < gmtls.VerifyClientCertIfGiven && bundle == nil {
+		return
+	}
+
+	currentPool := caPool
+	if bundle != nil {
+		currentPool = bundle.Pool
+	}
+
+	var cached atomic.Value
+
+	getConfigForClient := tlsConfig.GetConfigForClient
+	tlsConfig.GetConfigForClient = func(info *gmtls.ClientHelloInfo) (*gmtls.Config, error) {
+		base := tlsConfig
+		if getConfigForClient != nil {
+			config, err := getConfigForClient(info)
+			if err != nil {
+				return nil, err
+			}
+			if config != nil {
+				base = config
+			}
+		}
+
+		pool := currentPool()
+
+		if current, ok := cached.Load().(*clientCaConfig); ok && current.base == base && current.pool == pool {
+			return current.config, nil
+		}
+
+		config := base.Clone()
+		config.GetConfigForClient = nil
+		config.ClientCAs = pool
+
+		cached.Store(&clientCaConfig{
+			base:   base,
+			pool:   pool,
+			config: config,
+		})
+
+		return config, nil
+	}
+}
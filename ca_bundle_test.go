@@ -0,0 +1,166 @@
+package xweb
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"gitee.com/zhaochuninhefei/gmgo/x509"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCaPem(req *require.Assertions, name string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	req.NoError(err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCaBundle(t *testing.T) {
+	t.Run("reloads when the bundle changes", func(t *testing.T) {
+		req := require.New(t)
+		path := filepath.Join(t.TempDir(), "ca.pem")
+		first := newTestCaPem(req, "first")
+		req.NoError(os.WriteFile(path, first, 0600))
+
+		events := NewEventDispatcher()
+		reloaded := make(chan *CaBundleReloadEvent, 10)
+		events.AddListener(EventListenerFunc(func(event Event) {
+			reloaded <- event.(*CaBundleReloadEvent)
+		}))
+
+		bundles := newCaBundles(events)
+		defer bundles.close()
+
+		bundle, err := bundles.get(path)
+		req.NoError(err)
+		req.Len(bundle.Pool().Subjects(), 1)
+
+		sameBundle, err := bundles.get(path)
+		req.NoError(err)
+		req.Same(bundle, sameBundle)
+
+		req.NoError(os.WriteFile(path, append(first, newTestCaPem(req, "second")...), 0600))
+
+		select {
+		case event := <-reloaded:
+			req.Empty(event.Error)
+			req.Equal(2, event.Certificates)
+		case <-time.After(10 * time.Second):
+			req.Fail("timed out waiting for reload")
+		}
+
+		req.Len(bundle.Pool().Subjects(), 2)
+	})
+
+	t.Run("keeps the previous CAs if the bundle is invalid", func(t *testing.T) {
+		req := require.New(t)
+		path := filepath.Join(t.TempDir(), "ca.pem")
+		req.NoError(os.WriteFile(path, newTestCaPem(req, "first"), 0600))
+
+		bundles := newCaBundles(nil)
+		defer bundles.close()
+
+		bundle, err := bundles.get(path)
+		req.NoError(err)
+
+		req.NoError(os.WriteFile(path, []byte("not a pem"), 0600))
+		req.Error(bundle.Reload())
+		req.Len(bundle.Pool().Subjects(), 1)
+	})
+
+	t.Run("reloads when a kubernetes style symlink is swapped", func(t *testing.T) {
+		req := require.New(t)
+		dir := t.TempDir()
+
+		//<dir>/ca.pem -> ..data/ca.pem, ..data -> ..v1, updates create ..v2 and atomically replace ..data
+		req.NoError(os.Mkdir(filepath.Join(dir, "..v1"), 0700))
+		req.NoError(os.WriteFile(filepath.Join(dir, "..v1", "ca.pem"), newTestCaPem(req, "first"), 0600))
+		req.NoError(os.Symlink("..v1", filepath.Join(dir, "..data")))
+		req.NoError(os.Symlink(filepath.Join("..data", "ca.pem"), filepath.Join(dir, "ca.pem")))
+
+		events := NewEventDispatcher()
+		reloaded := make(chan *CaBundleReloadEvent, 10)
+		events.AddListener(EventListenerFunc(func(event Event) {
+			reloaded <- event.(*CaBundleReloadEvent)
+		}))
+
+		bundles := newCaBundles(events)
+		defer bundles.close()
+
+		bundle, err := bundles.get(filepath.Join(dir, "ca.pem"))
+		req.NoError(err)
+		req.Len(bundle.Pool().Subjects(), 1)
+
+		second := append(newTestCaPem(req, "first"), newTestCaPem(req, "second")...)
+		req.NoError(os.Mkdir(filepath.Join(dir, "..v2"), 0700))
+		req.NoError(os.WriteFile(filepath.Join(dir, "..v2", "ca.pem"), second, 0600))
+		req.NoError(os.Symlink("..v2", filepath.Join(dir, "..data_tmp")))
+		req.NoError(os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+
+		select {
+		case event := <-reloaded:
+			req.Empty(event.Error)
+			req.Equal(2, event.Certificates)
+		case <-time.After(10 * time.Second):
+			req.Fail("timed out waiting for reload")
+		}
+
+		req.Len(bundle.Pool().Subjects(), 2)
+	})
+}
+
+func Test_applyClientCas(t *testing.T) {
+	t.Run("client hello configurations are cached until the CAs change", func(t *testing.T) {
+		req := require.New(t)
+		path := filepath.Join(t.TempDir(), "ca.pem")
+		req.NoError(os.WriteFile(path, newTestCaPem(req, "first"), 0600))
+
+		bundles := newCaBundles(nil)
+		defer bundles.close()
+
+		bundle, err := bundles.get(path)
+		req.NoError(err)
+
+		options := &ClientCaOptions{}
+		options.Default()
+		tlsConfig := &gmtls.Config{}
+		options.applyClientCas(tlsConfig, bundle, nil)
+
+		first, err := tlsConfig.GetConfigForClient(&gmtls.ClientHelloInfo{})
+		req.NoError(err)
+		req.Same(bundle.Pool(), first.ClientCAs)
+
+		again, err := tlsConfig.GetConfigForClient(&gmtls.ClientHelloInfo{})
+		req.NoError(err)
+		req.Same(first, again)
+
+		req.NoError(os.WriteFile(path, append(newTestCaPem(req, "first"), newTestCaPem(req, "second")...), 0600))
+		req.NoError(bundle.Reload())
+
+		reloaded, err := tlsConfig.GetConfigForClient(&gmtls.ClientHelloInfo{})
+		req.NoError(err)
+		req.NotSame(first, reloaded)
+		req.Same(bundle.Pool(), reloaded.ClientCAs)
+	})
+}
@@ -0,0 +1,98 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/xweb/v2/breaker"
+	"github.com/openziti/xweb/v2/metrics"
+)
+
+const (
+	EventTypeCircuitBreakerStateChanged = "xweb.circuitBreaker.stateChanged"
+)
+
+// CircuitBreakerStateChangedEvent is dispatched when the circuit breaker of a dependency opens, starts probing or
+// closes
+type CircuitBreakerStateChangedEvent struct {
+	Dependency string `json:"dependency"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+}
+
+func (event *CircuitBreakerStateChangedEvent) EventType() string {
+	return EventTypeCircuitBreakerStateChanged
+}
+
+// NewCircuitBreakers returns a breaker.Registry reporting to metricsRegistry that logs state changes and dispatches
+// them as CircuitBreakerStateChangedEvent's to events, which may be nil
+func NewCircuitBreakers(metricsRegistry metrics.Registry, events EventDispatcher) *breaker.Registry {
+	breakers := breaker.NewRegistry(metricsRegistry)
+	breakers.AddListener(func(circuitBreaker *breaker.Breaker, from, to string) {
+		pfxlog.Logger().WithField("dependency", circuitBreaker.Name()).Warnf("circuit breaker changed from %s to %s", from, to)
+
+		if events != nil {
+			events.Dispatch(&CircuitBreakerStateChangedEvent{
+				Dependency: circuitBreaker.Name(),
+				From:       from,
+				To:         to,
+			})
+		}
+	})
+	return breakers
+}
+
+// parseCircuitBreakers parses the `circuitBreakers` map of an instance options section, which maps dependency names
+// to breaker.Options
+func parseCircuitBreakers(value interface{}) (map[string]breaker.Options, error) {
+	breakersMap, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("could not use value for circuitBreakers, not a map")
+	}
+
+	result := map[string]breaker.Options{}
+	for nameInterface, optionsInterface := range breakersMap {
+		name, ok := nameInterface.(string)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("circuit breaker name [%v] is not a string", nameInterface)
+		}
+
+		options := breaker.Options{}
+		options.Default()
+
+		if optionsInterface != nil {
+			optionsMap, ok := optionsInterface.(map[interface{}]interface{})
+			if !ok {
+				return nil, fmt.Errorf("could not use value for circuit breaker [%s], not a map", name)
+			}
+
+			if err := options.Parse(optionsMap); err != nil {
+				return nil, fmt.Errorf("error parsing circuit breaker [%s]: %v", name, err)
+			}
+		}
+
+		if err := options.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid circuit breaker [%s]: %v", name, err)
+		}
+
+		result[name] = options
+	}
+
+	return result, nil
+}
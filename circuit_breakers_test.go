@@ -0,0 +1,55 @@
+package xweb
+
+import (
+	"errors"
+	"github.com/openziti/xweb/v2/breaker"
+	"github.com/openziti/xweb/v2/factory"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakers(t *testing.T) {
+	t.Run("circuit breakers are parsed from instance options", func(t *testing.T) {
+		req := require.New(t)
+		options := &InstanceOptions{}
+		req.NoError(options.Parse(map[interface{}]interface{}{
+			"circuitBreakers": map[interface{}]interface{}{
+				"billing": map[interface{}]interface{}{"failureThreshold": 3, "openTimeout": "10s"},
+				"search":  nil,
+			},
+		}))
+
+		req.Equal(3, options.CircuitBreakers["billing"].FailureThreshold)
+		req.Equal(10*time.Second, options.CircuitBreakers["billing"].OpenTimeout)
+		req.Equal(breaker.DefaultHalfOpenProbes, options.CircuitBreakers["billing"].HalfOpenProbes)
+		req.Equal(breaker.DefaultFailureThreshold, options.CircuitBreakers["search"].FailureThreshold)
+
+		err := options.Parse(map[interface{}]interface{}{
+			"circuitBreakers": map[interface{}]interface{}{"billing": map[interface{}]interface{}{"halfOpenProbes": 0}},
+		})
+		req.EqualError(err, "invalid circuit breaker [billing]: halfOpenProbes must be at least 1")
+	})
+
+	t.Run("state changes are dispatched as events and breakers are provided to factories", func(t *testing.T) {
+		req := require.New(t)
+		instance := NewDefaultInstance(NewRegistryMap(), nil)
+
+		var events []*CircuitBreakerStateChangedEvent
+		instance.GetEventDispatcher().AddListener(EventListenerFunc(func(event Event) {
+			if changed, ok := event.(*CircuitBreakerStateChangedEvent); ok {
+				events = append(events, changed)
+			}
+		}))
+
+		breakers, err := factory.Resolve[*breaker.Registry](instance.GetServices())
+		req.NoError(err)
+		req.Same(instance.GetCircuitBreakers(), breakers)
+
+		req.NoError(breakers.Configure("billing", breaker.Options{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenProbes: 1}))
+		req.Error(breakers.Get("billing").Do(func() error { return errors.New("down") }))
+
+		req.Len(events, 1)
+		req.Equal(&CircuitBreakerStateChangedEvent{Dependency: "billing", From: breaker.StateClosed, To: breaker.StateOpen}, events[0])
+	})
+}
@@ -0,0 +1,89 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/openziti/xweb/v2/middleware"
+	"mime"
+	"strings"
+)
+
+// CompressionOptions represents response compression options for a ServerConfig
+type CompressionOptions struct {
+	// CompressionExcludedContentTypes are the media types, or ranges such as video/*, of responses that are not
+	// compressed
+	CompressionExcludedContentTypes []string
+}
+
+// Default excludes middleware.DefaultCompressionExcludedContentTypes
+func (compressionOptions *CompressionOptions) Default() {
+	compressionOptions.CompressionExcludedContentTypes = append([]string{}, middleware.DefaultCompressionExcludedContentTypes...)
+}
+
+// Parse parses a config map looking for a `compression` section, for example:
+//
+//	compression:
+//	  excludedContentTypes: [ image/png, video/*, application/x-protobuf ]
+//
+// The excluded content types replace the defaults, an empty list compresses all responses.
+func (compressionOptions *CompressionOptions) Parse(config map[interface{}]interface{}) error {
+	compressionInterface, ok := config["compression"]
+
+	if !ok {
+		return nil
+	}
+
+	compressionMap, ok := compressionInterface.(map[interface{}]interface{})
+
+	if !ok {
+		return errors.New("could not use value for compression, not a map")
+	}
+
+	if interfaceVal, ok := compressionMap["excludedContentTypes"]; ok {
+		excludedArray, ok := interfaceVal.([]interface{})
+
+		if !ok {
+			return errors.New("could not use value for compression.excludedContentTypes, not an array")
+		}
+
+		compressionOptions.CompressionExcludedContentTypes = []string{}
+
+		for i, excludedInterface := range excludedArray {
+			excluded, ok := excludedInterface.(string)
+			if !ok {
+				return fmt.Errorf("could not use value for compression.excludedContentTypes[%d], not a string", i)
+			}
+			compressionOptions.CompressionExcludedContentTypes = append(compressionOptions.CompressionExcludedContentTypes, excluded)
+		}
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (compressionOptions *CompressionOptions) Validate() error {
+	for _, mediaType := range compressionOptions.CompressionExcludedContentTypes {
+		parsed, _, err := mime.ParseMediaType(mediaType)
+		if err != nil || !strings.Contains(parsed, "/") {
+			return fmt.Errorf("invalid media type [%s] in compression.excludedContentTypes", mediaType)
+		}
+	}
+
+	return nil
+}
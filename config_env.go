@@ -0,0 +1,80 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// envReferencePattern matches `${env:NAME}` and `${env:NAME:-default}` references in configuration strings. The env:
+// prefix keeps references apart from bind point template variables, which use `${name}`.
+var envReferencePattern = regexp.MustCompile(`\$\{env:([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?}`)
+
+// expandEnv returns a copy of value with environment variable references in all string values replaced. A reference
+// to an unset variable without a default is an error. The path locates value in the configuration for error messages.
+func expandEnv(value interface{}, path string) (interface{}, error) {
+	switch typedValue := value.(type) {
+	case string:
+		return expandEnvString(typedValue, path)
+	case map[interface{}]interface{}:
+		result := make(map[interface{}]interface{}, len(typedValue))
+		for key, childValue := range typedValue {
+			expanded, err := expandEnv(childValue, fmt.Sprintf("%s.%v", path, key))
+			if err != nil {
+				return nil, err
+			}
+			result[key] = expanded
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(typedValue))
+		for i, childValue := range typedValue {
+			expanded, err := expandEnv(childValue, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			result[i] = expanded
+		}
+		return result, nil
+	}
+
+	return value, nil
+}
+
+func expandEnvString(value string, path string) (string, error) {
+	var err error
+
+	result := envReferencePattern.ReplaceAllStringFunc(value, func(reference string) string {
+		match := envReferencePattern.FindStringSubmatch(reference)
+		if envValue, ok := os.LookupEnv(match[1]); ok {
+			return envValue
+		}
+
+		if match[2] != "" {
+			return match[3]
+		}
+
+		if err == nil {
+			err = fmt.Errorf("environment variable [%s] referenced at %s is not set", match[1], path)
+		}
+		return reference
+	})
+
+	return result, err
+}
@@ -0,0 +1,94 @@
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_expandEnv(t *testing.T) {
+	t.Setenv("XWEB_TEST_HOST", "example.com")
+	t.Setenv("XWEB_TEST_EMPTY", "")
+
+	t.Run("expands references in nested strings", func(t *testing.T) {
+		req := require.New(t)
+
+		result, err := expandEnv(map[interface{}]interface{}{
+			"upstream": "https://${env:XWEB_TEST_HOST}:8443",
+			"list":     []interface{}{"${env:XWEB_TEST_HOST}", 5},
+			"template": "${port}",
+			"empty":    "[${env:XWEB_TEST_EMPTY}]",
+		}, "web")
+		req.NoError(err)
+
+		req.Equal(map[interface{}]interface{}{
+			"upstream": "https://example.com:8443",
+			"list":     []interface{}{"example.com", 5},
+			"template": "${port}",
+			"empty":    "[]",
+		}, result)
+	})
+
+	t.Run("defaults are used for unset variables", func(t *testing.T) {
+		req := require.New(t)
+
+		result, err := expandEnv("${env:XWEB_TEST_UNSET:-fallback} ${env:XWEB_TEST_HOST:-unused}", "web")
+		req.NoError(err)
+		req.Equal("fallback example.com", result)
+	})
+
+	t.Run("unset variables without a default are an error", func(t *testing.T) {
+		req := require.New(t)
+
+		_, err := expandEnv(map[interface{}]interface{}{"apis": []interface{}{"${env:XWEB_TEST_UNSET}"}}, "web")
+		req.EqualError(err, "environment variable [XWEB_TEST_UNSET] referenced at web.apis[0] is not set")
+	})
+
+	t.Run("the effective config reflects expanded values", func(t *testing.T) {
+		req := require.New(t)
+		t.Setenv("XWEB_TEST_PORT", "18443")
+
+		config := &InstanceConfig{
+			Section:                "web",
+			DefaultIdentitySection: "identity",
+			OptionsSection:         "xweb",
+		}
+
+		err := config.Parse(map[interface{}]interface{}{
+			"identity": map[interface{}]interface{}{
+				"cert":        "certs/${env:XWEB_TEST_HOST}.cert",
+				"server_cert": "certs/server.cert",
+				"key":         "certs/server.key",
+				"ca":          "certs/ca.pem",
+			},
+			"web": []interface{}{
+				map[interface{}]interface{}{
+					"name": "server1",
+					"bindPoints": []interface{}{
+						map[interface{}]interface{}{
+							"interface": "127.0.0.1:${env:XWEB_TEST_PORT}",
+							"address":   "${env:XWEB_TEST_HOST}:${env:XWEB_TEST_PORT}",
+						},
+					},
+					"apis": []interface{}{
+						map[interface{}]interface{}{
+							"binding": "test",
+							"options": map[interface{}]interface{}{
+								"upstream": "https://${env:XWEB_TEST_HOST}",
+							},
+						},
+					},
+				},
+			},
+		})
+		req.NoError(err)
+
+		effective := config.EffectiveConfig()
+		req.Equal("certs/example.com.cert", effective.Identity.Cert)
+		req.Equal("127.0.0.1:18443", effective.Servers[0].BindPoints[0].Interface)
+		req.Equal("example.com:18443", effective.Servers[0].BindPoints[0].Address)
+		req.Equal("https://example.com", effective.Servers[0].APIs[0].Options["upstream"])
+
+		source := config.SourceConfig["identity"].(map[interface{}]interface{})
+		req.Equal("certs/${env:XWEB_TEST_HOST}.cert", source["cert"])
+	})
+}
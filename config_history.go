@@ -0,0 +1,336 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultConfigHistorySize = 32
+
+	ConfigChangeAdded   = "added"
+	ConfigChangeRemoved = "removed"
+	ConfigChangeChanged = "changed"
+
+	// ConfigComponentInstance is the component of EffectiveConfig values outside of servers
+	ConfigComponentInstance = "instance"
+)
+
+// ConfigRevision is a numbered snapshot of an EffectiveConfig. Components holds the revision each component of the
+// configuration last changed in, keyed by ConfigComponentInstance, "servers[<name>]",
+// "servers[<name>].bindPoints[<interface>]" and "servers[<name>].apis[<name>]".
+type ConfigRevision struct {
+	Revision   uint64            `json:"revision"`
+	Time       time.Time         `json:"time"`
+	Reason     string            `json:"reason"`
+	Changed    []string          `json:"changed"`
+	Components map[string]uint64 `json:"components"`
+	Config     *EffectiveConfig  `json:"config,omitempty"`
+
+	values map[string]interface{}
+}
+
+// ConfigChange is a single value that differs between two ConfigRevision's. Paths follow the component keys of
+// ConfigRevision, i.e. "servers[edge].bindPoints[0.0.0.0:443].readTimeout".
+type ConfigChange struct {
+	Path string      `json:"path"`
+	Type string      `json:"type"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// ConfigDiff lists the changes between two ConfigRevision's ordered by path
+type ConfigDiff struct {
+	From    uint64          `json:"from"`
+	To      uint64          `json:"to"`
+	Changes []*ConfigChange `json:"changes"`
+}
+
+// ConfigHistory retains the most recent ConfigRevision's of an instance's EffectiveConfig so that changes made by hot
+// reloads may be audited
+type ConfigHistory struct {
+	lock       sync.Mutex
+	size       int
+	revisions  []*ConfigRevision
+	components map[string]uint64
+}
+
+// NewConfigHistory returns a ConfigHistory retaining up to size revisions, DefaultConfigHistorySize if not positive
+func NewConfigHistory(size int) *ConfigHistory {
+	if size <= 0 {
+		size = DefaultConfigHistorySize
+	}
+
+	return &ConfigHistory{
+		size:       size,
+		components: map[string]uint64{},
+	}
+}
+
+// Record adds a revision for config if it differs from the latest revision. The latest revision is returned along
+// with whether it was added.
+func (history *ConfigHistory) Record(reason string, config *EffectiveConfig) (*ConfigRevision, bool, error) {
+	values, err := flattenConfig(config)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not flatten effective config: %v", err)
+	}
+
+	history.lock.Lock()
+	defer history.lock.Unlock()
+
+	var latest *ConfigRevision
+	if len(history.revisions) > 0 {
+		latest = history.revisions[len(history.revisions)-1]
+	}
+
+	var previous map[string]interface{}
+	revisionNumber := uint64(1)
+	if latest != nil {
+		previous = latest.values
+		revisionNumber = latest.Revision + 1
+	}
+
+	changed := map[string]struct{}{}
+	for _, change := range diffValues(previous, values) {
+		changed[configComponent(change.Path)] = struct{}{}
+	}
+
+	if latest != nil && len(changed) == 0 {
+		return latest, false, nil
+	}
+
+	revision := &ConfigRevision{
+		Revision:   revisionNumber,
+		Time:       time.Now(),
+		Reason:     reason,
+		Changed:    []string{},
+		Components: map[string]uint64{},
+		Config:     config,
+		values:     values,
+	}
+
+	for component := range changed {
+		history.components[component] = revisionNumber
+		revision.Changed = append(revision.Changed, component)
+	}
+	sort.Strings(revision.Changed)
+
+	present := map[string]struct{}{}
+	for path := range values {
+		present[configComponent(path)] = struct{}{}
+	}
+	for component := range present {
+		revision.Components[component] = history.components[component]
+	}
+	for component := range history.components {
+		if _, ok := present[component]; !ok {
+			delete(history.components, component)
+		}
+	}
+
+	history.revisions = append(history.revisions, revision)
+	if len(history.revisions) > history.size {
+		history.revisions = history.revisions[len(history.revisions)-history.size:]
+	}
+
+	return revision, true, nil
+}
+
+// Latest returns the most recent ConfigRevision or nil if none have been recorded
+func (history *ConfigHistory) Latest() *ConfigRevision {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+
+	if len(history.revisions) == 0 {
+		return nil
+	}
+	return history.revisions[len(history.revisions)-1]
+}
+
+// Get returns a retained ConfigRevision or nil
+func (history *ConfigHistory) Get(revision uint64) *ConfigRevision {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+
+	return history.get(revision)
+}
+
+func (history *ConfigHistory) get(revision uint64) *ConfigRevision {
+	for _, retained := range history.revisions {
+		if retained.Revision == revision {
+			return retained
+		}
+	}
+	return nil
+}
+
+// Revisions returns the retained revisions, oldest first, without their EffectiveConfig
+func (history *ConfigHistory) Revisions() []*ConfigRevision {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+
+	result := make([]*ConfigRevision, 0, len(history.revisions))
+	for _, revision := range history.revisions {
+		summary := *revision
+		summary.Config = nil
+		result = append(result, &summary)
+	}
+	return result
+}
+
+// Diff returns the changes from one retained revision to another
+func (history *ConfigHistory) Diff(from, to uint64) (*ConfigDiff, error) {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+
+	fromRevision := history.get(from)
+	if fromRevision == nil {
+		return nil, fmt.Errorf("config revision [%d] not found", from)
+	}
+
+	toRevision := history.get(to)
+	if toRevision == nil {
+		return nil, fmt.Errorf("config revision [%d] not found", to)
+	}
+
+	return &ConfigDiff{
+		From:    from,
+		To:      to,
+		Changes: diffValues(fromRevision.values, toRevision.values),
+	}, nil
+}
+
+// diffValues returns the changes between two flattened configurations ordered by path
+func diffValues(from, to map[string]interface{}) []*ConfigChange {
+	changes := []*ConfigChange{}
+
+	for path, toValue := range to {
+		fromValue, ok := from[path]
+		if !ok {
+			changes = append(changes, &ConfigChange{Path: path, Type: ConfigChangeAdded, To: toValue})
+		} else if !reflect.DeepEqual(fromValue, toValue) {
+			changes = append(changes, &ConfigChange{Path: path, Type: ConfigChangeChanged, From: fromValue, To: toValue})
+		}
+	}
+
+	for path, fromValue := range from {
+		if _, ok := to[path]; !ok {
+			changes = append(changes, &ConfigChange{Path: path, Type: ConfigChangeRemoved, From: fromValue})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes
+}
+
+// configComponent returns the component a flattened path belongs to
+func configComponent(path string) string {
+	if !strings.HasPrefix(path, "servers[") {
+		return ConfigComponentInstance
+	}
+
+	server := path[:closingBracket(path, len("servers["))+1]
+	rest := path[len(server):]
+
+	for _, child := range []string{".bindPoints[", ".apis["} {
+		if strings.HasPrefix(rest, child) {
+			return server + rest[:closingBracket(rest, len(child))+1]
+		}
+	}
+
+	return server
+}
+
+// closingBracket returns the index of the bracket closing a key that starts at start, or the last index
+func closingBracket(path string, start int) int {
+	if end := strings.IndexByte(path[start:], ']'); end >= 0 {
+		return start + end
+	}
+	return len(path) - 1
+}
+
+// flattenConfig converts an EffectiveConfig to a map of paths to scalar values or lists of scalar values. Lists of
+// servers and APIs are keyed by name and lists of bind points by interface so that paths remain stable when entries
+// are reordered.
+func flattenConfig(config *EffectiveConfig) (map[string]interface{}, error) {
+	body, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	if err = json.Unmarshal(body, &generic); err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{}
+	flattenValue("", generic, result)
+	return result, nil
+}
+
+func flattenValue(path string, value interface{}, result map[string]interface{}) {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		for key, child := range typedValue {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			flattenValue(childPath, child, result)
+		}
+	case []interface{}:
+		if len(typedValue) == 0 {
+			return
+		}
+
+		if _, ok := typedValue[0].(map[string]interface{}); !ok {
+			result[path] = typedValue
+			return
+		}
+
+		for i, child := range typedValue {
+			flattenValue(path+"["+listKey(child, i)+"]", child, result)
+		}
+	case nil:
+		return
+	default:
+		result[path] = typedValue
+	}
+}
+
+// listKey returns the name, interface or id of a list entry, or its index if it has none of them
+func listKey(entry interface{}, index int) string {
+	if entryMap, ok := entry.(map[string]interface{}); ok {
+		for _, field := range []string{"name", "interface", "id"} {
+			if key, ok := entryMap[field].(string); ok && key != "" {
+				return key
+			}
+		}
+	}
+	return strconv.Itoa(index)
+}
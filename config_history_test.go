@@ -0,0 +1,152 @@
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newTestHistoryConfig() *InstanceConfig {
+	serverConfig := &ServerConfig{
+		Name: "edge",
+		BindPoints: []*BindPointConfig{
+			{InterfaceAddress: "0.0.0.0:443", Address: "edge.example.com:443"},
+			{InterfaceAddress: "0.0.0.0:8443", Address: "edge.example.com:8443"},
+		},
+		APIs: []*ApiConfig{{binding: "edge-client", name: "edge-client"}},
+	}
+	serverConfig.Options.Default()
+
+	return &InstanceConfig{
+		Section:       "web",
+		ServerConfigs: []*ServerConfig{serverConfig},
+	}
+}
+
+func TestConfigHistory(t *testing.T) {
+	t.Run("revisions are only recorded for changes", func(t *testing.T) {
+		req := require.New(t)
+		history := NewConfigHistory(0)
+		config := newTestHistoryConfig()
+
+		first, added, err := history.Record("build", config.EffectiveConfig())
+		req.NoError(err)
+		req.True(added)
+		req.Equal(uint64(1), first.Revision)
+		req.Equal([]string{
+			ConfigComponentInstance,
+			"servers[edge]",
+			"servers[edge].apis[edge-client]",
+			"servers[edge].bindPoints[0.0.0.0:443]",
+			"servers[edge].bindPoints[0.0.0.0:8443]",
+		}, first.Changed)
+
+		same, added, err := history.Record("reload", config.EffectiveConfig())
+		req.NoError(err)
+		req.False(added)
+		req.Same(first, same)
+	})
+
+	t.Run("component revisions track their last change", func(t *testing.T) {
+		req := require.New(t)
+		history := NewConfigHistory(0)
+		config := newTestHistoryConfig()
+
+		_, _, err := history.Record("build", config.EffectiveConfig())
+		req.NoError(err)
+
+		config.ServerConfigs[0].BindPoints[1].ReadTimeout = 30 * time.Second
+		second, added, err := history.Record("reload", config.EffectiveConfig())
+		req.NoError(err)
+		req.True(added)
+		req.Equal([]string{"servers[edge].bindPoints[0.0.0.0:8443]"}, second.Changed)
+		req.Equal(uint64(2), second.Components["servers[edge].bindPoints[0.0.0.0:8443]"])
+		req.Equal(uint64(1), second.Components["servers[edge].bindPoints[0.0.0.0:443]"])
+
+		config.Options.FeatureFlags = map[string]bool{"beta": true}
+		third, _, err := history.Record("featureFlags.reload", config.EffectiveConfig())
+		req.NoError(err)
+		req.Equal([]string{ConfigComponentInstance}, third.Changed)
+		req.Equal(uint64(2), third.Components["servers[edge].bindPoints[0.0.0.0:8443]"])
+
+		diff, err := history.Diff(1, 3)
+		req.NoError(err)
+		req.Equal([]*ConfigChange{
+			{Path: "featureFlags.beta", Type: ConfigChangeAdded, To: true},
+			{Path: "servers[edge].bindPoints[0.0.0.0:8443].readTimeout", Type: ConfigChangeAdded, To: "30s"},
+		}, diff.Changes)
+
+		config.ServerConfigs[0].BindPoints = config.ServerConfigs[0].BindPoints[:1]
+		fourth, _, err := history.Record("reload", config.EffectiveConfig())
+		req.NoError(err)
+		req.NotContains(fourth.Components, "servers[edge].bindPoints[0.0.0.0:8443]")
+
+		diff, err = history.Diff(3, 4)
+		req.NoError(err)
+		for _, change := range diff.Changes {
+			req.Equal(ConfigChangeRemoved, change.Type)
+		}
+		req.NotEmpty(diff.Changes)
+	})
+
+	t.Run("only the most recent revisions are retained", func(t *testing.T) {
+		req := require.New(t)
+		history := NewConfigHistory(2)
+		config := newTestHistoryConfig()
+
+		for i := 1; i <= 3; i++ {
+			config.ServerConfigs[0].BindPoints[0].MaxHeaderBytes = i
+			_, _, err := history.Record("reload", config.EffectiveConfig())
+			req.NoError(err)
+		}
+
+		revisions := history.Revisions()
+		req.Len(revisions, 2)
+		req.Equal(uint64(2), revisions[0].Revision)
+		req.Nil(revisions[0].Config)
+		req.NotNil(history.Get(3).Config)
+		req.Nil(history.Get(1))
+
+		_, err := history.Diff(1, 3)
+		req.Error(err)
+	})
+
+	t.Run("instances record runtime state from their live sources", func(t *testing.T) {
+		req := require.New(t)
+		instance := NewDefaultInstance(NewRegistryMap(), nil)
+		instance.Config = newTestHistoryConfig()
+
+		serverConfig := instance.Config.ServerConfigs[0]
+		instance.servers = []*Server{{
+			ServerConfig: serverConfig,
+			apiInstances: []*apiInstance{newApiInstance(serverConfig, serverConfig.APIs[0], &testMethodApiHandler{}, instance.Metrics)},
+		}}
+
+		instance.RecordConfigRevision("build")
+
+		instance.GetFeatureFlags().Set("beta", true)
+		instance.RecordConfigRevision("featureFlag.set")
+		req.Equal(map[string]bool{"beta": true}, instance.GetConfigHistory().Latest().Config.FeatureFlags)
+		req.Nil(instance.Config.Options.FeatureFlags)
+
+		req.NoError(instance.DisableApi("edge", "edge-client", time.Minute))
+		disabled := instance.GetConfigHistory().Latest()
+		req.Equal("api.disable", disabled.Reason)
+		req.Equal([]string{"servers[edge].apis[edge-client]"}, disabled.Changed)
+
+		req.NoError(instance.EnableApi("edge", "edge-client"))
+		req.Equal("api.enable", instance.GetConfigHistory().Latest().Reason)
+
+		override, err := instance.GetAccessLogController().AddOverride("edge-client", "", time.Minute)
+		req.NoError(err)
+		instance.RecordConfigRevision("accessLog.override.add")
+
+		diff, err := instance.GetConfigHistory().Diff(disabled.Revision+1, disabled.Revision+2)
+		req.NoError(err)
+		req.Equal([]*ConfigChange{
+			{Path: "accessLogOverrides[1].binding", Type: ConfigChangeAdded, To: "edge-client"},
+			{Path: "accessLogOverrides[1].id", Type: ConfigChangeAdded, To: override.Id},
+			{Path: "accessLogOverrides[1].until", Type: ConfigChangeAdded, To: override.Until.Format(time.RFC3339Nano)},
+		}, diff.Changes)
+	})
+}
@@ -0,0 +1,120 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const connInfoContextKey = ContextKey("xweb.ConnInfo.ContextKey")
+
+// lastConnId is the last connection id assigned by withConnInfo
+var lastConnId uint64
+
+// ConnInfo describes the connection a request was received on. It only uses net and builtin types so that handlers
+// can inspect connections the same way regardless of the http/tls stack they are written against.
+type ConnInfo struct {
+	// Id identifies the connection for the lifetime of the process, requests on the same connection share an Id
+	Id uint64 `json:"id"`
+
+	// OpenedAt is the time the connection was accepted
+	OpenedAt time.Time `json:"openedAt"`
+
+	LocalAddr  net.Addr `json:"-"`
+	RemoteAddr net.Addr `json:"-"`
+
+	// Tls is true if the connection is a TLS connection
+	Tls bool `json:"tls"`
+
+	// TlsVersion is the negotiated TLS version, e.g. 0x0304 for TLS 1.3 or 0x0101 for GMSSL
+	TlsVersion uint16 `json:"tlsVersion,omitempty"`
+
+	// CipherSuite is the negotiated cipher suite
+	CipherSuite uint16 `json:"cipherSuite,omitempty"`
+
+	// NegotiatedProtocol is the ALPN protocol, e.g. "h2", or empty if none was negotiated
+	NegotiatedProtocol string `json:"negotiatedProtocol,omitempty"`
+
+	// ServerName is the SNI value sent by the client
+	ServerName string `json:"serverName,omitempty"`
+
+	// Gm is true if the connection negotiated GMSSL or an SM4 cipher suite
+	Gm bool `json:"gm,omitempty"`
+}
+
+// connState is stored on connection contexts by withConnInfo
+type connState struct {
+	id       uint64
+	openedAt time.Time
+	conn     net.Conn
+}
+
+// tlsStateConn is implemented by gmtls.Conn and the connections of TLS listeners that embed it
+type tlsStateConn interface {
+	ConnectionState() gmtls.ConnectionState
+}
+
+// withConnInfo is used as a http.Server ConnContext to make the ConnInfo of a connection available to its requests
+func withConnInfo(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connInfoContextKey, &connState{
+		id:       atomic.AddUint64(&lastConnId, 1),
+		openedAt: time.Now(),
+		conn:     conn,
+	})
+}
+
+// newConnContext returns the http.Server ConnContext for a server, fingerprints enables TlsFingerprintFromContext
+func newConnContext(fingerprints bool) func(ctx context.Context, conn net.Conn) context.Context {
+	if !fingerprints {
+		return withConnInfo
+	}
+	return func(ctx context.Context, conn net.Conn) context.Context {
+		return withTlsFingerprint(withConnInfo(ctx, conn), conn)
+	}
+}
+
+// ConnInfoFromContext returns the ConnInfo of the connection a request was received on or nil if the request was not
+// received by a Server. TLS values are read when called, after the handshake they reflect the negotiated connection.
+func ConnInfoFromContext(ctx context.Context) *ConnInfo {
+	state, ok := ctx.Value(connInfoContextKey).(*connState)
+	if !ok {
+		return nil
+	}
+
+	info := &ConnInfo{
+		Id:         state.id,
+		OpenedAt:   state.openedAt,
+		LocalAddr:  state.conn.LocalAddr(),
+		RemoteAddr: state.conn.RemoteAddr(),
+	}
+
+	if tlsConn, ok := state.conn.(tlsStateConn); ok {
+		tlsState := tlsConn.ConnectionState()
+		info.Tls = true
+		info.TlsVersion = tlsState.Version
+		info.CipherSuite = tlsState.CipherSuite
+		info.NegotiatedProtocol = tlsState.NegotiatedProtocol
+		info.ServerName = tlsState.ServerName
+		info.Gm = tlsState.Version == gmtls.VersionGMSSL || tlsState.CipherSuite == gmtls.TLS_SM4_GCM_SM3
+	}
+
+	return info
+}
@@ -0,0 +1,107 @@
+package xweb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"gitee.com/zhaochuninhefei/gmgo/x509"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnInfo(t *testing.T) {
+	t.Run("plain connections are described", func(t *testing.T) {
+		req := require.New(t)
+		serverConn, clientConn := net.Pipe()
+		defer func() { _ = clientConn.Close() }()
+
+		ctx := withConnInfo(context.Background(), serverConn)
+		info := ConnInfoFromContext(ctx)
+		req.NotNil(info)
+		req.NotZero(info.Id)
+		req.False(info.OpenedAt.IsZero())
+		req.Equal(serverConn.LocalAddr(), info.LocalAddr)
+		req.Equal(serverConn.RemoteAddr(), info.RemoteAddr)
+		req.False(info.Tls)
+		req.False(info.Gm)
+
+		req.Equal(info.Id, ConnInfoFromContext(ctx).Id)
+		req.NotEqual(info.Id, ConnInfoFromContext(withConnInfo(context.Background(), clientConn)).Id)
+	})
+
+	t.Run("tls connections report their negotiated state", func(t *testing.T) {
+		req := require.New(t)
+
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		req.NoError(err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()),
+			Subject:      pkix.Name{CommonName: "localhost"},
+			DNSNames:     []string{"localhost"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		req.NoError(err)
+
+		serverConn, clientConn := net.Pipe()
+		defer func() { _ = serverConn.Close() }()
+		defer func() { _ = clientConn.Close() }()
+
+		server := gmtls.Server(serverConn, &gmtls.Config{
+			Certificates: []gmtls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+			NextProtos:   []string{"h2", "http/1.1"},
+		})
+		client := gmtls.Client(clientConn, &gmtls.Config{
+			ServerName:         "localhost",
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h2"},
+		})
+
+		ctx := withConnInfo(context.Background(), server)
+
+		errC := make(chan error, 1)
+		go func() {
+			errC <- client.Handshake()
+		}()
+		req.NoError(server.Handshake())
+		req.NoError(<-errC)
+
+		info := ConnInfoFromContext(ctx)
+		req.NotNil(info)
+		req.True(info.Tls)
+		req.Equal(uint16(gmtls.VersionTLS13), info.TlsVersion)
+		req.Equal("h2", info.NegotiatedProtocol)
+		req.Equal("localhost", info.ServerName)
+		req.Equal(info.CipherSuite == gmtls.TLS_SM4_GCM_SM3, info.Gm)
+	})
+
+	t.Run("fingerprints are added to connection contexts when enabled", func(t *testing.T) {
+		req := require.New(t)
+		serverConn, clientConn := net.Pipe()
+		defer func() { _ = clientConn.Close() }()
+
+		fingerprint := &TlsFingerprint{Ja3: "771,,,,"}
+		conn := &fingerprintConn{Conn: serverConn, done: true, fingerprint: fingerprint}
+		req.Nil(newConnContext(false)(context.Background(), conn).Value(tlsFingerprintContextKey))
+
+		ctx := newConnContext(true)(context.Background(), conn)
+		req.Equal(fingerprint, TlsFingerprintFromContext(ctx))
+		req.NotNil(ConnInfoFromContext(ctx))
+
+		req.Nil(TlsFingerprintFromContext(newConnContext(true)(context.Background(), serverConn)))
+	})
+
+	t.Run("contexts without connections return nil", func(t *testing.T) {
+		req := require.New(t)
+		req.Nil(ConnInfoFromContext(context.Background()))
+	})
+}
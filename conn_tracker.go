@@ -0,0 +1,328 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/xweb/v2/metrics"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	MetricConnectionsOpen   = "xweb.connections.open"
+	MetricConnectionsReaped = "xweb.connections.reaped"
+
+	MinConnectionReapInterval = time.Second
+
+	connTrackerContextKey = ContextKey("xweb.connTracker.ContextKey")
+)
+
+// ConnectionReapOptions represents options for closing connections that have been idle longer than the stdlib
+// http.Server timeouts allow for. This covers connections that never send a request (half-open), idle keep-alive
+// connections, and optionally hijacked connections which the http.Server no longer manages.
+type ConnectionReapOptions struct {
+	// ConnectionReapTimeout is the duration a connection may remain new, idle, or hijacked before being closed. Zero
+	// disables reaping.
+	ConnectionReapTimeout time.Duration
+
+	// ReapHijacked enables reaping hijacked connections (i.e. websockets) ConnectionReapTimeout after they were
+	// hijacked. Hijacked connections are not observable after hijacking, as such the duration is measured from the
+	// hijack.
+	ReapHijacked bool
+}
+
+// Default disables reaping
+func (reapOptions *ConnectionReapOptions) Default() {
+	reapOptions.ConnectionReapTimeout = 0
+	reapOptions.ReapHijacked = false
+}
+
+// Parse parses a config map
+func (reapOptions *ConnectionReapOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["connectionReapTimeout"]; ok {
+		if timeoutStr, ok := interfaceVal.(string); ok {
+			if timeout, err := time.ParseDuration(timeoutStr); err == nil {
+				reapOptions.ConnectionReapTimeout = timeout
+			} else {
+				return fmt.Errorf("could not parse connectionReapTimeout %s as a duration (e.g. 1m): %v", timeoutStr, err)
+			}
+		} else {
+			return errors.New("could not use value for connectionReapTimeout, not a string")
+		}
+	}
+
+	if interfaceVal, ok := config["reapHijacked"]; ok {
+		if reapHijacked, ok := interfaceVal.(bool); ok {
+			reapOptions.ReapHijacked = reapHijacked
+		} else {
+			return errors.New("could not use value for reapHijacked, not a boolean")
+		}
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (reapOptions *ConnectionReapOptions) Validate() error {
+	if reapOptions.ConnectionReapTimeout < 0 {
+		return fmt.Errorf("value [%s] for connectionReapTimeout too low, must be zero (disabled) or positive", reapOptions.ConnectionReapTimeout.String())
+	}
+
+	return nil
+}
+
+// trackedConnection is the last known state of a connection
+type trackedConnection struct {
+	state  gmhttp.ConnState
+	since  time.Time
+	opened time.Time
+}
+
+// connTracker records the state of all connections for a single http.Server via its ConnState hook and optionally
+// closes connections that have been idle for too long. Hijacked connections are no longer managed by the http.Server
+// and are tracked separately until they are closed through the net.Conn returned by Hijack.
+type connTracker struct {
+	lock        sync.Mutex
+	connections map[net.Conn]*trackedConnection
+	hijacked    map[net.Conn]time.Time
+	options     ConnectionReapOptions
+	open        metrics.Gauge
+	reaped      metrics.Counter
+	stopOnce    sync.Once
+	stopNotify  chan struct{}
+}
+
+func newConnTracker(options ConnectionReapOptions, registry metrics.Registry, labels metrics.Labels) *connTracker {
+	return &connTracker{
+		connections: map[net.Conn]*trackedConnection{},
+		hijacked:    map[net.Conn]time.Time{},
+		options:     options,
+		open:        registry.Gauge(MetricConnectionsOpen, labels),
+		reaped:      registry.Counter(MetricConnectionsReaped, labels),
+		stopNotify:  make(chan struct{}),
+	}
+}
+
+// ConnState satisfies http.Server.ConnState
+func (tracker *connTracker) ConnState(conn net.Conn, state gmhttp.ConnState) {
+	now := time.Now()
+
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	existing, isTracked := tracker.connections[conn]
+
+	switch state {
+	case gmhttp.StateClosed:
+		if isTracked {
+			delete(tracker.connections, conn)
+			tracker.open.Add(-1)
+		}
+		return
+	case gmhttp.StateHijacked:
+		if isTracked {
+			delete(tracker.connections, conn)
+		} else {
+			tracker.open.Add(1)
+		}
+		tracker.hijacked[conn] = now
+		return
+	}
+
+	if !isTracked {
+		existing = &trackedConnection{
+			opened: now,
+		}
+		tracker.connections[conn] = existing
+		tracker.open.Add(1)
+	}
+
+	existing.state = state
+	existing.since = now
+}
+
+// Start begins reaping connections if enabled
+func (tracker *connTracker) Start() {
+	if tracker.options.ConnectionReapTimeout <= 0 {
+		return
+	}
+
+	interval := tracker.options.ConnectionReapTimeout / 2
+	if interval < MinConnectionReapInterval {
+		interval = MinConnectionReapInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-tracker.stopNotify:
+				return
+			case now := <-ticker.C:
+				tracker.reap(now)
+			}
+		}
+	}()
+}
+
+// Stop stops reaping, tracking continues until the http.Server is closed
+func (tracker *connTracker) Stop() {
+	tracker.stopOnce.Do(func() {
+		close(tracker.stopNotify)
+	})
+}
+
+// reap closes all connections that are new, idle, or hijacked (if enabled) for longer than the reap timeout
+func (tracker *connTracker) reap(now time.Time) {
+	var toClose []net.Conn
+
+	tracker.lock.Lock()
+	for conn, tracked := range tracker.connections {
+		if tracked.state != gmhttp.StateActive && now.Sub(tracked.since) > tracker.options.ConnectionReapTimeout {
+			toClose = append(toClose, conn)
+		}
+	}
+
+	if tracker.options.ReapHijacked {
+		for conn, since := range tracker.hijacked {
+			if now.Sub(since) > tracker.options.ConnectionReapTimeout {
+				toClose = append(toClose, conn)
+
+				//hijacked connections will not report closed, stop tracking now
+				delete(tracker.hijacked, conn)
+				tracker.open.Add(-1)
+			}
+		}
+	}
+	tracker.lock.Unlock()
+
+	for _, conn := range toClose {
+		pfxlog.Logger().WithField("remote", conn.RemoteAddr().String()).Debug("reaping idle connection")
+		_ = conn.Close()
+		tracker.reaped.Inc(1)
+	}
+}
+
+// Count returns the number of currently tracked connections that are managed by the http.Server, hijacked connections
+// are not included
+func (tracker *connTracker) Count() int {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	return len(tracker.connections)
+}
+
+// countActive returns the number of tracked connections with a request in flight
+func (tracker *connTracker) countActive() int {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	count := 0
+	for _, tracked := range tracker.connections {
+		if tracked.state == gmhttp.StateActive {
+			count++
+		}
+	}
+	return count
+}
+
+// closeBusy closes all active connections, which a graceful http.Server.Shutdown that ran out of time leaves open, and
+// returns how many were closed
+func (tracker *connTracker) closeBusy() int {
+	var toClose []net.Conn
+
+	tracker.lock.Lock()
+	for conn, tracked := range tracker.connections {
+		if tracked.state == gmhttp.StateActive {
+			toClose = append(toClose, conn)
+		}
+	}
+	tracker.lock.Unlock()
+
+	for _, conn := range toClose {
+		_ = conn.Close()
+	}
+
+	return len(toClose)
+}
+
+// closeHijacked closes all hijacked connections that are still open, which http.Server.Shutdown ignores, and returns
+// how many were closed
+func (tracker *connTracker) closeHijacked() int {
+	var toClose []net.Conn
+
+	tracker.lock.Lock()
+	for conn := range tracker.hijacked {
+		toClose = append(toClose, conn)
+		delete(tracker.hijacked, conn)
+		tracker.open.Add(-1)
+	}
+	tracker.lock.Unlock()
+
+	for _, conn := range toClose {
+		_ = conn.Close()
+	}
+
+	return len(toClose)
+}
+
+// untrackHijacked stops tracking a hijacked connection, returning false if it was not tracked
+func (tracker *connTracker) untrackHijacked(conn net.Conn) bool {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	if _, ok := tracker.hijacked[conn]; !ok {
+		return false
+	}
+
+	delete(tracker.hijacked, conn)
+	tracker.open.Add(-1)
+	return true
+}
+
+// wrapHijacked wraps a net.Conn returned by http.Hijacker.Hijack so that it stops being tracked once closed by the
+// handler that hijacked it
+func (tracker *connTracker) wrapHijacked(conn net.Conn) net.Conn {
+	return &hijackedConn{
+		Conn:    conn,
+		tracker: tracker,
+	}
+}
+
+// hijackedConn is a hijacked net.Conn that is untracked from its connTracker when closed
+type hijackedConn struct {
+	net.Conn
+	tracker *connTracker
+}
+
+func (conn *hijackedConn) Close() error {
+	conn.tracker.untrackHijacked(conn.Conn)
+	return conn.Conn.Close()
+}
+
+// connTrackerFromContext returns the connTracker of the http.Server a request was received by or nil
+func connTrackerFromContext(ctx context.Context) *connTracker {
+	tracker, _ := ctx.Value(connTrackerContextKey).(*connTracker)
+	return tracker
+}
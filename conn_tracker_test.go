@@ -0,0 +1,153 @@
+package xweb
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/openziti/xweb/v2/metrics"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+type closeRecordingConn struct {
+	net.Conn
+	closed bool
+}
+
+func (conn *closeRecordingConn) Close() error {
+	conn.closed = true
+	return nil
+}
+
+func newCloseRecordingConn() *closeRecordingConn {
+	local, _ := net.Pipe()
+	return &closeRecordingConn{Conn: local}
+}
+
+func Test_connTracker(t *testing.T) {
+	newTracker := func(options ConnectionReapOptions) (*connTracker, metrics.Registry) {
+		registry := metrics.NewRegistry()
+		return newConnTracker(options, registry, metrics.Labels{}), registry
+	}
+
+	t.Run("connections are tracked until closed", func(t *testing.T) {
+		req := require.New(t)
+		tracker, registry := newTracker(ConnectionReapOptions{})
+		conn := newCloseRecordingConn()
+
+		tracker.ConnState(conn, gmhttp.StateNew)
+		tracker.ConnState(conn, gmhttp.StateActive)
+		tracker.ConnState(conn, gmhttp.StateIdle)
+		req.Equal(1, tracker.Count())
+		req.Equal(int64(1), registry.Gauge(MetricConnectionsOpen, metrics.Labels{}).Value())
+
+		tracker.ConnState(conn, gmhttp.StateClosed)
+		req.Equal(0, tracker.Count())
+		req.Equal(int64(0), registry.Gauge(MetricConnectionsOpen, metrics.Labels{}).Value())
+	})
+
+	t.Run("hijacked connections are tracked separately until closed by the handler", func(t *testing.T) {
+		req := require.New(t)
+		tracker, registry := newTracker(ConnectionReapOptions{})
+		conn := newCloseRecordingConn()
+		tracker.ConnState(conn, gmhttp.StateNew)
+		tracker.ConnState(conn, gmhttp.StateHijacked)
+		req.Equal(0, tracker.Count())
+		req.Equal(int64(1), registry.Gauge(MetricConnectionsOpen, metrics.Labels{}).Value())
+
+		//not reaped unless enabled
+		tracker.reap(time.Now().Add(time.Hour))
+		req.False(conn.closed)
+
+		req.NoError(tracker.wrapHijacked(conn).Close())
+		req.True(conn.closed)
+		req.Equal(int64(0), registry.Gauge(MetricConnectionsOpen, metrics.Labels{}).Value())
+		req.Equal(0, tracker.closeHijacked())
+	})
+
+	t.Run("hijacked connections are always closed on request", func(t *testing.T) {
+		req := require.New(t)
+		tracker, registry := newTracker(ConnectionReapOptions{})
+
+		active := newCloseRecordingConn()
+		tracker.ConnState(active, gmhttp.StateActive)
+
+		hijacked := newCloseRecordingConn()
+		tracker.ConnState(hijacked, gmhttp.StateNew)
+		tracker.ConnState(hijacked, gmhttp.StateHijacked)
+
+		req.Equal(1, tracker.closeHijacked())
+		req.True(hijacked.closed)
+		req.False(active.closed)
+		req.Equal(int64(1), registry.Gauge(MetricConnectionsOpen, metrics.Labels{}).Value())
+	})
+
+	t.Run("idle, new and hijacked connections are reaped after the timeout", func(t *testing.T) {
+		req := require.New(t)
+		tracker, registry := newTracker(ConnectionReapOptions{ConnectionReapTimeout: time.Minute, ReapHijacked: true})
+
+		idle := newCloseRecordingConn()
+		tracker.ConnState(idle, gmhttp.StateNew)
+		tracker.ConnState(idle, gmhttp.StateIdle)
+
+		halfOpen := newCloseRecordingConn()
+		tracker.ConnState(halfOpen, gmhttp.StateNew)
+
+		active := newCloseRecordingConn()
+		tracker.ConnState(active, gmhttp.StateActive)
+
+		hijacked := newCloseRecordingConn()
+		tracker.ConnState(hijacked, gmhttp.StateHijacked)
+
+		tracker.reap(time.Now().Add(30 * time.Second))
+		req.False(idle.closed)
+		req.False(halfOpen.closed)
+
+		tracker.reap(time.Now().Add(2 * time.Minute))
+		req.True(idle.closed)
+		req.True(halfOpen.closed)
+		req.True(hijacked.closed)
+		req.False(active.closed)
+		req.Equal(int64(3), registry.Counter(MetricConnectionsReaped, metrics.Labels{}).Count())
+
+		//hijacked connections never report closed and are no longer tracked once reaped
+		req.Equal(3, tracker.Count())
+	})
+
+	t.Run("busy connections are closed on request", func(t *testing.T) {
+		req := require.New(t)
+		tracker, _ := newTracker(ConnectionReapOptions{})
+
+		idle := newCloseRecordingConn()
+		tracker.ConnState(idle, gmhttp.StateIdle)
+
+		active := newCloseRecordingConn()
+		tracker.ConnState(active, gmhttp.StateActive)
+
+		req.Equal(1, tracker.closeBusy())
+		req.True(active.closed)
+		req.False(idle.closed)
+	})
+}
+
+func Test_ConnectionReapOptions(t *testing.T) {
+	t.Run("options are parsed", func(t *testing.T) {
+		req := require.New(t)
+		options := ConnectionReapOptions{}
+		options.Default()
+		req.NoError(options.Parse(map[interface{}]interface{}{
+			"connectionReapTimeout": "2m",
+			"reapHijacked":          true,
+		}))
+		req.NoError(options.Validate())
+		req.Equal(2*time.Minute, options.ConnectionReapTimeout)
+		req.True(options.ReapHijacked)
+	})
+
+	t.Run("negative timeouts are rejected", func(t *testing.T) {
+		req := require.New(t)
+		options := ConnectionReapOptions{}
+		req.NoError(options.Parse(map[interface{}]interface{}{"connectionReapTimeout": "-1s"}))
+		req.Error(options.Validate())
+	})
+}
@@ -21,6 +21,8 @@ import "context"
 const (
 	HandlerContextKey = ContextKey("xweb.ApiHandler.ContextKey")
 	ServerContextKey  = ContextKey("xweb.Server.ContextKey")
+
+	synthesizedHeadContextKey = ContextKey("xweb.SynthesizedHead.ContextKey")
 )
 
 // HandlerFromRequestContext us a utility function to retrieve a ApiHandler reference, that the demux http.Handler
@@ -30,6 +32,9 @@ func HandlerFromRequestContext(ctx context.Context) *ApiHandler {
 		if handler, ok := val.(*ApiHandler); ok {
 			return handler
 		}
+		if handler, ok := val.(ApiHandler); ok {
+			return &handler
+		}
 	}
 	return nil
 }
@@ -44,3 +49,10 @@ func ServerContextFromRequestContext(ctx context.Context) *ServerContext {
 	}
 	return nil
 }
+
+// IsSynthesizedHead returns true if the request is a HEAD request being served by a GET handler, see
+// DemuxOptions.SynthesizeHead. The request's method will be GET, any body written is discarded.
+func IsSynthesizedHead(ctx context.Context) bool {
+	synthesized, _ := ctx.Value(synthesizedHeadContextKey).(bool)
+	return synthesized
+}
@@ -20,8 +20,8 @@ import (
 	"context"
 	"fmt"
 	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
-	"github.com/michaelquigley/pfxlog"
-	"reflect"
+	"github.com/openziti/xweb/v2/demux"
+	"sort"
 	"strings"
 )
 
@@ -53,125 +53,185 @@ func (d *DemuxHandlerImpl) ServeHTTP(writer gmhttp.ResponseWriter, request *gmht
 // when a ApiHandler is not selected. By default an empty response with a http.StatusNotFound (404) will be sent.
 type PathPrefixDemuxFactory struct {
 	DefaultHttpHandlerProviderImpl
+	DemuxOptions
 }
 
 var _ DemuxFactory = &PathPrefixDemuxFactory{}
 
 // Build performs ApiHandler selection based on URL path prefixes
 func (factory *PathPrefixDemuxFactory) Build(handlers []ApiHandler) (DemuxHandler, error) {
-	var defaultApi ApiHandler = nil
-
-	for _, handler := range handlers {
-		if newDefaultApi, ok := handler.(DefaultApiHandler); ok {
-			if newDefaultApi.IsDefault() {
-
-				if defaultApi != nil {
-					pfxlog.Logger().
-						WithField("previous", reflect.TypeOf(defaultApi)).
-						WithField("new", reflect.TypeOf(newDefaultApi)).
-						Warn("multiple ApiHandlers registered as the default")
-				}
-				defaultApi = handler
-			}
-		}
-	}
-
 	handlerMap := map[string]ApiHandler{}
 
 	for _, handler := range handlers {
 		if existing, ok := handlerMap[handler.RootPath()]; ok {
-			return nil, fmt.Errorf("duplicate root path [%s] detected for both bindings [%s] and [%s]", handler.RootPath(), handler.Binding(), existing.Binding())
+			return nil, fmt.Errorf("duplicate root path [%s] detected for both bindings [%s] and [%s]", handler.RootPath(), apiHandlerLabel(handler), apiHandlerLabel(existing))
 		}
 		handlerMap[handler.RootPath()] = handler
 	}
 
-	return &DemuxHandlerImpl{
-		Handler: gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
-			for _, handler := range handlers {
-				if strings.HasPrefix(request.URL.Path, handler.RootPath()) {
-
-					//store this ApiHandler on the request context, useful for logging by downstream http handlers
-					ctx := context.WithValue(request.Context(), HandlerContextKey, handler)
-					newRequest := request.WithContext(ctx)
-					handler.ServeHTTP(writer, newRequest)
-					return
-				}
-			}
-
-			if defaultApi != nil {
-				ctx := context.WithValue(request.Context(), HandlerContextKey, defaultApi)
-				newRequest := request.WithContext(ctx)
-				defaultApi.ServeHTTP(writer, newRequest)
-				return
-			}
-
-			if defaultHttpHandler := factory.GetDefaultHttpHandler(); defaultHttpHandler != nil {
-				defaultHttpHandler.ServeHTTP(writer, request)
-				return
-			}
-
-			writer.WriteHeader(gmhttp.StatusNotFound)
-			_, _ = writer.Write([]byte{})
-		}),
-	}, nil
+	return newDemuxHandler(demux.PathPrefix, &factory.DefaultHttpHandlerProviderImpl, &factory.DemuxOptions, handlers)
 }
 
 // IsHandledDemuxFactory is a DemuxFactory that routes http.Request requests to a specific ApiHandler by delegating
 // to the ApiHandler's IsHandled function.
 type IsHandledDemuxFactory struct {
 	DefaultHttpHandlerProviderImpl
+	DemuxOptions
 }
 
 var _ DemuxFactory = &IsHandledDemuxFactory{}
 
 // Build performs ApiHandler selection based on IsHandled()
 func (factory *IsHandledDemuxFactory) Build(handlers []ApiHandler) (DemuxHandler, error) {
-	var defaultApi ApiHandler = nil
+	return newDemuxHandler(demux.IsHandled, &factory.DefaultHttpHandlerProviderImpl, &factory.DemuxOptions, handlers)
+}
+
+// newDemuxHandler returns a DemuxHandler backed by a demux.Router. Requests that select no ApiHandler are served by
+// the provider's default http.Handler or answered with http.StatusNotFound.
+func newDemuxHandler(mode demux.Mode, provider DefaultHttpHandlerProvider, options *DemuxOptions, handlers []ApiHandler) (DemuxHandler, error) {
+	router := demux.NewRouter(mode)
+
+	router.Serve = func(handler demux.Handler, writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		options.serveApi(handler.(ApiHandler), writer, request)
+	}
+
+	router.NotFound = gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		if defaultHttpHandler := provider.GetDefaultHttpHandler(); defaultHttpHandler != nil {
+			defaultHttpHandler.ServeHTTP(writer, request)
+			return
+		}
+
+		writer.WriteHeader(gmhttp.StatusNotFound)
+		_, _ = writer.Write([]byte{})
+	})
 
 	for _, handler := range handlers {
-		if newDefaultApi, ok := handler.(DefaultApiHandler); ok {
-			if newDefaultApi.IsDefault() {
-
-				if defaultApi != nil {
-					pfxlog.Logger().
-						WithField("previous", reflect.TypeOf(defaultApi)).
-						WithField("new", reflect.TypeOf(newDefaultApi)).
-						Warn("multiple ApiHandlers registered as the default")
-				}
-				defaultApi = handler
-			}
+		if err := router.AddHandler(handler); err != nil {
+			return nil, err
 		}
 	}
 
 	return &DemuxHandlerImpl{
-		Handler: gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		Handler: router,
+	}, nil
+}
+
+// DemuxOptions are optional behaviors shared by the DemuxFactory implementations provided by xweb. They apply to
+// ApiHandler's that declare their methods by implementing MethodApiHandler.
+type DemuxOptions struct {
+	// AutoOptions answers OPTIONS requests with http.StatusNoContent and an Allow header, unless the ApiHandler
+	// declares http.MethodOptions itself
+	AutoOptions bool
+
+	// MethodNotAllowed answers requests for undeclared methods with http.StatusMethodNotAllowed and an Allow header.
+	// Declaring GET implies HEAD.
+	MethodNotAllowed bool
 
-			for _, handler := range handlers {
-				if handler.IsHandler(request) {
-					ctx := context.WithValue(request.Context(), HandlerContextKey, handler)
-					newRequest := request.WithContext(ctx)
-					handler.ServeHTTP(writer, newRequest)
+	// SynthesizeHead serves HEAD requests for ApiHandler's that declare GET but not HEAD by invoking them with a GET
+	// request. Headers, including Content-Length and Content-Encoding, match those of the GET response.
+	SynthesizeHead bool
+}
+
+// serveApi stores the ApiHandler on the request context, useful for logging by downstream http handlers, and serves
+// the request with it after applying the DemuxOptions
+func (options *DemuxOptions) serveApi(handler ApiHandler, writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	request = withHandler(request, handler)
+
+	if options.AutoOptions || options.MethodNotAllowed || options.SynthesizeHead {
+		if methodHandler, ok := handler.(MethodApiHandler); ok {
+			if allowed := methodHandler.AllowedMethods(request); allowed != nil {
+				if options.synthesizesHead(allowed) && request.Method == gmhttp.MethodHead {
+					serveSynthesizedHead(handler, writer, request)
 					return
 				}
 
-			}
+				// GET implies HEAD, as with net/http handlers that serve HEAD requests through their GET logic
+				if containsMethod(allowed, gmhttp.MethodGet) && !containsMethod(allowed, gmhttp.MethodHead) {
+					allowed = append(append([]string{}, allowed...), gmhttp.MethodHead)
+				}
 
-			if defaultApi != nil {
-				ctx := context.WithValue(request.Context(), HandlerContextKey, defaultApi)
-				newRequest := request.WithContext(ctx)
-				defaultApi.ServeHTTP(writer, newRequest)
-				return
+				if options.handleMethod(allowed, writer, request) {
+					return
+				}
 			}
+		}
+	}
 
-			if defaultHttpHandler := factory.GetDefaultHttpHandler(); defaultHttpHandler != nil {
-				defaultHttpHandler.ServeHTTP(writer, request)
-				return
-			}
+	handler.ServeHTTP(writer, request)
+}
 
-			writer.WriteHeader(gmhttp.StatusNotFound)
-			_, _ = writer.Write([]byte{})
-		}),
-	}, nil
+// synthesizesHead returns true if HEAD requests should be served by the GET handler for the allowed methods
+func (options *DemuxOptions) synthesizesHead(allowed []string) bool {
+	return options.SynthesizeHead && containsMethod(allowed, gmhttp.MethodGet) && !containsMethod(allowed, gmhttp.MethodHead)
+}
+
+// serveSynthesizedHead serves a HEAD request by invoking the handler as a GET request, see DemuxOptions.SynthesizeHead.
+// The body is written through so that upstream handlers, i.e. compression, produce the same headers as for GET, the
+// http server discards it as it does for every response to a HEAD request.
+func serveSynthesizedHead(handler gmhttp.Handler, writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	ctx := context.WithValue(request.Context(), synthesizedHeadContextKey, true)
+	getRequest := request.Clone(ctx)
+	getRequest.Method = gmhttp.MethodGet
+
+	handler.ServeHTTP(writer, getRequest)
+}
+
+// handleMethod answers OPTIONS and undeclared method requests, returning true if a response was written
+func (options *DemuxOptions) handleMethod(allowed []string, writer gmhttp.ResponseWriter, request *gmhttp.Request) bool {
+	if containsMethod(allowed, request.Method) {
+		return false
+	}
+
+	if request.Method == gmhttp.MethodOptions && options.AutoOptions {
+		writer.Header().Set("Allow", allowHeader(allowed, gmhttp.MethodOptions))
+		writer.WriteHeader(gmhttp.StatusNoContent)
+		return true
+	}
+
+	if options.MethodNotAllowed {
+		extra := []string{}
+		if options.AutoOptions {
+			extra = append(extra, gmhttp.MethodOptions)
+		}
+		writer.Header().Set("Allow", allowHeader(allowed, extra...))
+		WriteError(writer, request, gmhttp.StatusMethodNotAllowed, fmt.Errorf("method [%s] is not allowed for path [%s]", request.Method, request.URL.Path), nil)
+		return true
+	}
+
+	return false
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, candidate := range methods {
+		if strings.EqualFold(candidate, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeader renders a sorted, de-duplicated Allow header value
+func allowHeader(methods []string, extra ...string) string {
+	unique := map[string]struct{}{}
+	for _, method := range append(append([]string{}, methods...), extra...) {
+		unique[strings.ToUpper(method)] = struct{}{}
+	}
+
+	var result []string
+	for method := range unique {
+		result = append(result, method)
+	}
+	sort.Strings(result)
+
+	return strings.Join(result, ", ")
+}
+
+// apiHandlerLabel returns a human readable identifier for an ApiHandler including its instance name if available
+func apiHandlerLabel(handler ApiHandler) string {
+	if namedHandler, ok := handler.(NamedApiHandler); ok && namedHandler.Name() != handler.Binding() {
+		return handler.Binding() + "/" + namedHandler.Name()
+	}
+	return handler.Binding()
 }
 
 type DefaultApiHandler interface {
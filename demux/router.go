@@ -0,0 +1,295 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package demux selects the http.Handler that serves a request from a set of Handler's. Router is the handler
+// selection used by xweb's DemuxFactory implementations and may be embedded in other servers.
+package demux
+
+import (
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/michaelquigley/pfxlog"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrDuplicateRootPath is returned by Router.AddHandler when a PathPrefix Router already has a Handler for the root path
+var ErrDuplicateRootPath = errors.New("duplicate root path")
+
+// Handler is a http.Handler that may be selected by a Router
+type Handler interface {
+	gmhttp.Handler
+
+	// RootPath is the path prefix matched by PathPrefix Router's
+	RootPath() string
+
+	// IsHandler returns true if the request should be served by this Handler, used by IsHandled Router's
+	IsHandler(request *gmhttp.Request) bool
+}
+
+// DefaultHandler is a Handler that may be selected when no other Handler matches a request
+type DefaultHandler interface {
+	Handler
+	IsDefault() bool
+}
+
+// Mode defines how a Router selects a Handler
+type Mode int
+
+const (
+	// PathPrefix selects the first added Handler whose RootPath is a prefix of the request path
+	PathPrefix Mode = iota
+
+	// IsHandled selects the first added Handler whose IsHandler function returns true
+	IsHandled
+)
+
+// ServeFunc serves a request with the Handler selected by a Router
+type ServeFunc func(handler Handler, writer gmhttp.ResponseWriter, request *gmhttp.Request)
+
+// Router is a http.Handler that serves requests with the Handler selected according to its Mode. If no Handler
+// matches, the last added DefaultHandler reporting IsDefault() is selected. Requests that select no Handler are
+// served by NotFound.
+//
+// Handler's may be added and removed while the Router is serving requests. Serve and NotFound must be set before the
+// Router serves requests.
+type Router struct {
+	// Serve is invoked to serve requests with the selected Handler, by default Handler.ServeHTTP is invoked
+	Serve ServeFunc
+
+	// NotFound serves requests that select no Handler, by default an empty http.StatusNotFound response is sent
+	NotFound gmhttp.Handler
+
+	mode  Mode
+	lock  sync.Mutex
+	state atomic.Value
+}
+
+// routerState is an immutable set of Handler's, replaced in full whenever a Handler is added or removed so that
+// requests may be routed without locking
+type routerState struct {
+	handlers       []Handler
+	table          *prefixTable
+	defaultHandler Handler
+}
+
+var _ gmhttp.Handler = &Router{}
+
+// NewRouter returns an empty Router for the given Mode
+func NewRouter(mode Mode) *Router {
+	router := &Router{mode: mode}
+	router.state.Store(newRouterState(nil))
+	return router
+}
+
+func newRouterState(handlers []Handler) *routerState {
+	state := &routerState{
+		handlers: handlers,
+		table:    newPrefixTable(handlers),
+	}
+
+	for _, handler := range handlers {
+		if defaultHandler, ok := handler.(DefaultHandler); ok && defaultHandler.IsDefault() {
+			if state.defaultHandler != nil {
+				pfxlog.Logger().
+					WithField("previous", reflect.TypeOf(state.defaultHandler)).
+					WithField("new", reflect.TypeOf(handler)).
+					Warn("multiple Handlers registered as the default")
+			}
+			state.defaultHandler = handler
+		}
+	}
+
+	return state
+}
+
+func (router *Router) load() *routerState {
+	if state, ok := router.state.Load().(*routerState); ok {
+		return state
+	}
+	return &routerState{table: &prefixTable{}}
+}
+
+// Mode returns how the Router selects a Handler
+func (router *Router) Mode() Mode {
+	return router.mode
+}
+
+// AddHandler adds a Handler with a lower precedence than the Handler's already added. For PathPrefix Router's an
+// error wrapping ErrDuplicateRootPath is returned if a Handler with the same RootPath has already been added.
+func (router *Router) AddHandler(handler Handler) error {
+	router.lock.Lock()
+	defer router.lock.Unlock()
+
+	current := router.load()
+
+	if router.mode == PathPrefix {
+		for _, existing := range current.handlers {
+			if existing.RootPath() == handler.RootPath() {
+				return fmt.Errorf("%w [%s]", ErrDuplicateRootPath, handler.RootPath())
+			}
+		}
+	}
+
+	handlers := make([]Handler, 0, len(current.handlers)+1)
+	handlers = append(append(handlers, current.handlers...), handler)
+	router.state.Store(newRouterState(handlers))
+
+	return nil
+}
+
+// RemoveHandler removes a previously added Handler, compared by identity. Returns false if the Handler was not found.
+func (router *Router) RemoveHandler(handler Handler) bool {
+	router.lock.Lock()
+	defer router.lock.Unlock()
+
+	current := router.load()
+
+	for i, existing := range current.handlers {
+		if existing == handler {
+			handlers := make([]Handler, 0, len(current.handlers)-1)
+			handlers = append(append(handlers, current.handlers[:i]...), current.handlers[i+1:]...)
+			router.state.Store(newRouterState(handlers))
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handlers returns the added Handler's in precedence order
+func (router *Router) Handlers() []Handler {
+	return append([]Handler(nil), router.load().handlers...)
+}
+
+// Match returns the Handler selected for the request or nil if none is selected
+func (router *Router) Match(request *gmhttp.Request) Handler {
+	state := router.load()
+
+	if router.mode == IsHandled {
+		for _, handler := range state.handlers {
+			if handler.IsHandler(request) {
+				return handler
+			}
+		}
+	} else if handler := state.table.match(request.URL.Path); handler != nil {
+		return handler
+	}
+
+	return state.defaultHandler
+}
+
+// ServeHTTP serves the request with the selected Handler or NotFound
+func (router *Router) ServeHTTP(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if handler := router.Match(request); handler != nil {
+		if router.Serve != nil {
+			router.Serve(handler, writer, request)
+		} else {
+			handler.ServeHTTP(writer, request)
+		}
+		return
+	}
+
+	if router.NotFound != nil {
+		router.NotFound.ServeHTTP(writer, request)
+		return
+	}
+
+	writer.WriteHeader(gmhttp.StatusNotFound)
+}
+
+// prefixTable selects the Handler for a path by root path prefix without allocating. Root paths are held sorted
+// so that for each distinct root path length, the path's prefix of that length can be found by binary search. When
+// several root paths match, the Handler added first is selected, as with a linear scan.
+type prefixTable struct {
+	entries []prefixEntry
+	lengths []int
+}
+
+type prefixEntry struct {
+	prefix  string
+	handler Handler
+	order   int
+}
+
+func newPrefixTable(handlers []Handler) *prefixTable {
+	table := &prefixTable{}
+	seenLengths := map[int]struct{}{}
+
+	for i, handler := range handlers {
+		table.entries = append(table.entries, prefixEntry{
+			prefix:  handler.RootPath(),
+			handler: handler,
+			order:   i,
+		})
+
+		if _, seen := seenLengths[len(handler.RootPath())]; !seen {
+			seenLengths[len(handler.RootPath())] = struct{}{}
+			table.lengths = append(table.lengths, len(handler.RootPath()))
+		}
+	}
+
+	sort.Slice(table.entries, func(i, j int) bool {
+		return table.entries[i].prefix < table.entries[j].prefix
+	})
+	sort.Ints(table.lengths)
+
+	return table
+}
+
+// match returns the first added Handler whose root path is a prefix of path or nil
+func (table *prefixTable) match(path string) Handler {
+	var result *prefixEntry
+
+	for _, length := range table.lengths {
+		if length > len(path) {
+			break
+		}
+
+		if entry := table.find(path[:length]); entry != nil && (result == nil || entry.order < result.order) {
+			result = entry
+			if result.order == 0 {
+				break
+			}
+		}
+	}
+
+	if result == nil {
+		return nil
+	}
+	return result.handler
+}
+
+// find returns the entry with exactly the given prefix or nil
+func (table *prefixTable) find(prefix string) *prefixEntry {
+	low, high := 0, len(table.entries)
+	for low < high {
+		mid := int(uint(low+high) >> 1)
+		if table.entries[mid].prefix < prefix {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+
+	if low < len(table.entries) && table.entries[low].prefix == prefix {
+		return &table.entries[low]
+	}
+	return nil
+}
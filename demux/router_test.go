@@ -0,0 +1,185 @@
+package demux
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+type testHandler struct {
+	rootPath  string
+	isDefault bool
+}
+
+func (handler *testHandler) RootPath() string {
+	return handler.rootPath
+}
+
+func (handler *testHandler) IsHandler(request *gmhttp.Request) bool {
+	return strings.HasPrefix(request.URL.Path, handler.rootPath)
+}
+
+func (handler *testHandler) IsDefault() bool {
+	return handler.isDefault
+}
+
+func (handler *testHandler) ServeHTTP(writer gmhttp.ResponseWriter, _ *gmhttp.Request) {
+	_, _ = writer.Write([]byte(handler.rootPath))
+}
+
+func Test_Router(t *testing.T) {
+	serve := func(router *Router, path string) *gmhttptest.ResponseRecorder {
+		recorder := gmhttptest.NewRecorder()
+		router.ServeHTTP(recorder, gmhttptest.NewRequest("GET", path, nil))
+		return recorder
+	}
+
+	t.Run("requests are served by the matching handler", func(t *testing.T) {
+		req := require.New(t)
+		router := NewRouter(PathPrefix)
+		req.NoError(router.AddHandler(&testHandler{rootPath: "/edge/"}))
+		req.NoError(router.AddHandler(&testHandler{rootPath: "/fabric/"}))
+
+		req.Equal("/fabric/", serve(router, "/fabric/things").Body.String())
+		req.Equal("/edge/", serve(router, "/edge/things").Body.String())
+		req.Equal(gmhttp.StatusNotFound, serve(router, "/other").Code)
+	})
+
+	t.Run("duplicate root paths are rejected by path prefix routers", func(t *testing.T) {
+		req := require.New(t)
+		router := NewRouter(PathPrefix)
+		req.NoError(router.AddHandler(&testHandler{rootPath: "/edge/"}))
+
+		err := router.AddHandler(&testHandler{rootPath: "/edge/"})
+		req.ErrorIs(err, ErrDuplicateRootPath)
+		req.Len(router.Handlers(), 1)
+
+		router = NewRouter(IsHandled)
+		req.NoError(router.AddHandler(&testHandler{rootPath: "/edge/"}))
+		req.NoError(router.AddHandler(&testHandler{rootPath: "/edge/"}))
+	})
+
+	t.Run("is handled routers select the first handler accepting the request", func(t *testing.T) {
+		req := require.New(t)
+		router := NewRouter(IsHandled)
+		req.NoError(router.AddHandler(&testHandler{rootPath: "/"}))
+		req.NoError(router.AddHandler(&testHandler{rootPath: "/edge/"}))
+
+		req.Equal("/", serve(router, "/edge/things").Body.String())
+	})
+
+	t.Run("removed handlers are no longer selected", func(t *testing.T) {
+		req := require.New(t)
+		router := NewRouter(PathPrefix)
+		edge := &testHandler{rootPath: "/edge/"}
+		req.NoError(router.AddHandler(edge))
+		req.NoError(router.AddHandler(&testHandler{rootPath: "/"}))
+
+		req.Equal("/edge/", serve(router, "/edge/things").Body.String())
+		req.True(router.RemoveHandler(edge))
+		req.False(router.RemoveHandler(edge))
+		req.Equal("/", serve(router, "/edge/things").Body.String())
+
+		req.NoError(router.AddHandler(&testHandler{rootPath: "/edge/"}))
+	})
+
+	t.Run("the last added default handler serves unmatched requests", func(t *testing.T) {
+		req := require.New(t)
+		router := NewRouter(PathPrefix)
+		req.NoError(router.AddHandler(&testHandler{rootPath: "/edge/"}))
+		req.NoError(router.AddHandler(&testHandler{rootPath: "/first/", isDefault: true}))
+		last := &testHandler{rootPath: "/last/", isDefault: true}
+		req.NoError(router.AddHandler(last))
+
+		req.Equal("/last/", serve(router, "/other").Body.String())
+
+		router.RemoveHandler(last)
+		req.Equal("/first/", serve(router, "/other").Body.String())
+	})
+
+	t.Run("serve and not found may be replaced", func(t *testing.T) {
+		req := require.New(t)
+		router := NewRouter(PathPrefix)
+		router.Serve = func(handler Handler, writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.Header().Set("X-Root-Path", handler.RootPath())
+			handler.ServeHTTP(writer, request)
+		}
+		router.NotFound = gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.WriteHeader(gmhttp.StatusTeapot)
+		})
+		req.NoError(router.AddHandler(&testHandler{rootPath: "/edge/"}))
+
+		req.Equal("/edge/", serve(router, "/edge/things").Header().Get("X-Root-Path"))
+		req.Equal(gmhttp.StatusTeapot, serve(router, "/other").Code)
+	})
+
+	t.Run("zero value routers are empty", func(t *testing.T) {
+		req := require.New(t)
+		router := &Router{}
+
+		req.Equal(gmhttp.StatusNotFound, serve(router, "/edge/things").Code)
+		req.NoError(router.AddHandler(&testHandler{rootPath: "/edge/"}))
+		req.Equal("/edge/", serve(router, "/edge/things").Body.String())
+	})
+}
+
+func Test_prefixTable(t *testing.T) {
+	newTable := func(rootPaths ...string) *prefixTable {
+		var handlers []Handler
+		for _, rootPath := range rootPaths {
+			handlers = append(handlers, &testHandler{rootPath: rootPath})
+		}
+		return newPrefixTable(handlers)
+	}
+
+	rootPathOf := func(handler Handler) string {
+		if handler == nil {
+			return "<nil>"
+		}
+		return handler.RootPath()
+	}
+
+	t.Run("the first registered matching root path is selected", func(t *testing.T) {
+		req := require.New(t)
+		table := newTable("/edge/client/", "/edge/", "/", "/fabric/")
+
+		req.Equal("/edge/client/", rootPathOf(table.match("/edge/client/v1/sessions")))
+		req.Equal("/edge/", rootPathOf(table.match("/edge/management/v1/services")))
+		req.Equal("/", rootPathOf(table.match("/fabric/v1/routers")))
+		req.Equal("/", rootPathOf(table.match("/")))
+	})
+
+	t.Run("unmatched paths select nothing", func(t *testing.T) {
+		req := require.New(t)
+		table := newTable("/edge/", "/fabric/")
+
+		req.Equal("<nil>", rootPathOf(table.match("/other")))
+		req.Equal("<nil>", rootPathOf(table.match("/edge")))
+		req.Equal("<nil>", rootPathOf(table.match("")))
+	})
+
+	t.Run("empty root paths match everything", func(t *testing.T) {
+		req := require.New(t)
+		table := newTable("/edge/", "")
+
+		req.Equal("/edge/", rootPathOf(table.match("/edge/things")))
+		req.Equal("", rootPathOf(table.match("/other")))
+	})
+
+	t.Run("routing requests does not allocate", func(t *testing.T) {
+		req := require.New(t)
+		router := NewRouter(PathPrefix)
+		req.NoError(router.AddHandler(&testHandler{rootPath: "/edge/"}))
+		req.NoError(router.AddHandler(&testHandler{rootPath: "/fabric/"}))
+
+		request := gmhttptest.NewRequest("GET", "/fabric/things", nil)
+
+		allocs := testing.AllocsPerRun(100, func() {
+			_ = router.Match(request)
+		})
+
+		req.Zero(allocs)
+	})
+}
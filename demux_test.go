@@ -0,0 +1,319 @@
+package xweb
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/openziti/xweb/v2/logsink"
+	"github.com/openziti/xweb/v2/middleware"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type testMethodApiHandler struct {
+	rootPath string
+	methods  []string
+	served   []string
+}
+
+func (handler *testMethodApiHandler) Binding() string {
+	return "test"
+}
+
+func (handler *testMethodApiHandler) Options() map[interface{}]interface{} {
+	return nil
+}
+
+func (handler *testMethodApiHandler) RootPath() string {
+	return handler.rootPath
+}
+
+func (handler *testMethodApiHandler) IsHandler(request *gmhttp.Request) bool {
+	return true
+}
+
+func (handler *testMethodApiHandler) AllowedMethods(*gmhttp.Request) []string {
+	return handler.methods
+}
+
+func (handler *testMethodApiHandler) ServeHTTP(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	handler.served = append(handler.served, request.Method)
+	writer.Header().Set("Content-Type", "text/plain")
+	_, _ = writer.Write([]byte("hello"))
+}
+
+func Test_DemuxOptions(t *testing.T) {
+	serve := func(options DemuxOptions, handler ApiHandler, method string) *gmhttptest.ResponseRecorder {
+		factory := &PathPrefixDemuxFactory{DemuxOptions: options}
+		demux, err := factory.Build([]ApiHandler{handler})
+		require.NoError(t, err)
+
+		recorder := gmhttptest.NewRecorder()
+		demux.ServeHTTP(recorder, gmhttptest.NewRequest(method, "/things", nil))
+		return recorder
+	}
+
+	t.Run("OPTIONS is answered with the declared methods", func(t *testing.T) {
+		req := require.New(t)
+		handler := &testMethodApiHandler{methods: []string{"POST", "get"}}
+
+		recorder := serve(DemuxOptions{AutoOptions: true}, handler, gmhttp.MethodOptions)
+
+		req.Equal(gmhttp.StatusNoContent, recorder.Code)
+		req.Equal("GET, HEAD, OPTIONS, POST", recorder.Header().Get("Allow"))
+		req.Empty(handler.served)
+	})
+
+	t.Run("OPTIONS is passed through if the handler declares it", func(t *testing.T) {
+		req := require.New(t)
+		handler := &testMethodApiHandler{methods: []string{"GET", "OPTIONS"}}
+
+		recorder := serve(DemuxOptions{AutoOptions: true, MethodNotAllowed: true}, handler, gmhttp.MethodOptions)
+
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal([]string{"OPTIONS"}, handler.served)
+	})
+
+	t.Run("undeclared methods are rejected with an Allow header", func(t *testing.T) {
+		req := require.New(t)
+		handler := &testMethodApiHandler{methods: []string{"GET"}}
+
+		recorder := serve(DemuxOptions{AutoOptions: true, MethodNotAllowed: true}, handler, gmhttp.MethodDelete)
+
+		req.Equal(gmhttp.StatusMethodNotAllowed, recorder.Code)
+		req.Equal("GET, HEAD, OPTIONS", recorder.Header().Get("Allow"))
+		req.Empty(handler.served)
+	})
+
+	t.Run("HEAD is passed to GET handlers without head synthesis", func(t *testing.T) {
+		req := require.New(t)
+		handler := &testMethodApiHandler{methods: []string{"GET"}}
+
+		recorder := serve(DemuxOptions{MethodNotAllowed: true}, handler, gmhttp.MethodHead)
+
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal([]string{"HEAD"}, handler.served)
+	})
+
+	t.Run("declared methods are served", func(t *testing.T) {
+		req := require.New(t)
+		handler := &testMethodApiHandler{methods: []string{"GET"}}
+
+		recorder := serve(DemuxOptions{AutoOptions: true, MethodNotAllowed: true}, handler, gmhttp.MethodGet)
+
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal([]string{"GET"}, handler.served)
+	})
+
+	t.Run("handlers without declared methods are served unchecked", func(t *testing.T) {
+		req := require.New(t)
+		handler := &testMethodApiHandler{}
+
+		recorder := serve(DemuxOptions{AutoOptions: true, MethodNotAllowed: true}, handler, gmhttp.MethodDelete)
+
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal([]string{"DELETE"}, handler.served)
+	})
+
+	t.Run("methods are unchecked when disabled", func(t *testing.T) {
+		req := require.New(t)
+		handler := &testMethodApiHandler{methods: []string{"GET"}}
+
+		recorder := serve(DemuxOptions{}, handler, gmhttp.MethodDelete)
+
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal([]string{"DELETE"}, handler.served)
+	})
+
+	t.Run("methods are resolved through api instances", func(t *testing.T) {
+		req := require.New(t)
+		handler := &testMethodApiHandler{methods: []string{"GET"}}
+		instance := &apiInstance{ApiHandler: handler}
+
+		req.Equal([]string{"GET"}, instance.AllowedMethods(nil))
+		req.Nil((&apiInstance{ApiHandler: &testMethodApiHandler{}}).AllowedMethods(nil))
+	})
+}
+
+func Test_DemuxOptions_SynthesizeHead(t *testing.T) {
+	serve := func(handler ApiHandler, method string) *gmhttptest.ResponseRecorder {
+		factory := &IsHandledDemuxFactory{DemuxOptions: DemuxOptions{AutoOptions: true, MethodNotAllowed: true, SynthesizeHead: true}}
+		demux, err := factory.Build([]ApiHandler{handler})
+		require.NoError(t, err)
+
+		recorder := gmhttptest.NewRecorder()
+		demux.ServeHTTP(recorder, gmhttptest.NewRequest(method, "/things", nil))
+		return recorder
+	}
+
+	// bodies of HEAD responses are discarded by the http server, so these requests go through one
+	serveHttp := func(handler gmhttp.Handler, method string) *gmhttp.Response {
+		server := gmhttptest.NewServer(handler)
+		defer server.Close()
+
+		request, err := gmhttp.NewRequest(method, server.URL+"/things", nil)
+		require.NoError(t, err)
+		request.Header.Set("Accept-Encoding", "gzip")
+
+		response, err := server.Client().Do(request)
+		require.NoError(t, err)
+		_ = response.Body.Close()
+		return response
+	}
+
+	newDemux := func(handler ApiHandler) gmhttp.Handler {
+		factory := &IsHandledDemuxFactory{DemuxOptions: DemuxOptions{SynthesizeHead: true}}
+		demux, err := factory.Build([]ApiHandler{handler})
+		require.NoError(t, err)
+		return demux
+	}
+
+	t.Run("HEAD is served by the GET handler without a body", func(t *testing.T) {
+		req := require.New(t)
+		handler := &testMethodApiHandler{methods: []string{"GET"}}
+
+		response := serveHttp(newDemux(handler), gmhttp.MethodHead)
+
+		req.Equal(gmhttp.StatusOK, response.StatusCode)
+		req.Equal(int64(5), response.ContentLength)
+		req.Equal("text/plain", response.Header.Get("Content-Type"))
+		req.Equal([]string{"GET"}, handler.served)
+	})
+
+	t.Run("HEAD headers match GET headers for compressed responses", func(t *testing.T) {
+		req := require.New(t)
+		handler := middleware.NewCompressionHandler(newDemux(&testMethodApiHandler{methods: []string{"GET"}}))
+
+		get := serveHttp(handler, gmhttp.MethodGet)
+		head := serveHttp(handler, gmhttp.MethodHead)
+
+		req.Equal("gzip", get.Header.Get("Content-Encoding"))
+		req.Equal(get.Header.Get("Content-Encoding"), head.Header.Get("Content-Encoding"))
+		req.Equal(get.Header.Get("Content-Length"), head.Header.Get("Content-Length"))
+	})
+
+	t.Run("synthesized HEAD requests are access logged as HEAD without bytes", func(t *testing.T) {
+		req := require.New(t)
+		capabilities := resolveInstanceCapabilities(&minimalInstance{})
+		sink := &recordingSink{}
+		capabilities.logSinks.AddSink(sink, map[string]struct{}{logsink.KindAccess: {}})
+
+		server := &Server{ServerConfig: &ServerConfig{Name: "test"}}
+		server.ServerConfig.Options.AccessLogOptions.Default()
+		server.ServerConfig.Options.AccessLogEnabled = true
+
+		serveHttp(server.wrapAccessLog(&BindPointConfig{}, capabilities, newDemux(&testMethodApiHandler{methods: []string{"GET"}})), gmhttp.MethodHead)
+
+		req.Len(sink.records, 1)
+		req.Equal("HEAD", sink.records[0].Fields["method"])
+		req.Equal(int64(0), sink.records[0].Fields["bytes"])
+	})
+
+	t.Run("HEAD is passed through if the handler declares it", func(t *testing.T) {
+		req := require.New(t)
+		handler := &testMethodApiHandler{methods: []string{"GET", "HEAD"}}
+
+		serve(handler, gmhttp.MethodHead)
+
+		req.Equal([]string{"HEAD"}, handler.served)
+	})
+
+	t.Run("HEAD is rejected without a GET handler", func(t *testing.T) {
+		req := require.New(t)
+		handler := &testMethodApiHandler{methods: []string{"POST"}}
+
+		recorder := serve(handler, gmhttp.MethodHead)
+
+		req.Equal(gmhttp.StatusMethodNotAllowed, recorder.Code)
+		req.Equal("OPTIONS, POST", recorder.Header().Get("Allow"))
+	})
+
+	t.Run("HEAD is included in Allow headers", func(t *testing.T) {
+		req := require.New(t)
+		handler := &testMethodApiHandler{methods: []string{"GET"}}
+
+		recorder := serve(handler, gmhttp.MethodOptions)
+
+		req.Equal("GET, HEAD, OPTIONS", recorder.Header().Get("Allow"))
+	})
+
+	t.Run("GET handlers can detect synthesized HEAD requests", func(t *testing.T) {
+		req := require.New(t)
+		var synthesized bool
+		handler := gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			synthesized = IsSynthesizedHead(request.Context())
+			writer.WriteHeader(gmhttp.StatusNoContent)
+		})
+
+		recorder := gmhttptest.NewRecorder()
+		serveSynthesizedHead(handler, recorder, gmhttptest.NewRequest(gmhttp.MethodHead, "/things", nil))
+
+		req.True(synthesized)
+		req.Equal(gmhttp.StatusNoContent, recorder.Code)
+		req.Empty(recorder.Header().Get("Content-Length"))
+	})
+}
+
+func Test_PathPrefixDemuxFactory(t *testing.T) {
+	t.Run("duplicate root paths are rejected", func(t *testing.T) {
+		req := require.New(t)
+		_, err := (&PathPrefixDemuxFactory{}).Build([]ApiHandler{&testMethodApiHandler{rootPath: "/edge/"}, &testMethodApiHandler{rootPath: "/edge/"}})
+		req.EqualError(err, "duplicate root path [/edge/] detected for both bindings [test] and [test]")
+	})
+
+	t.Run("the first registered matching root path is selected", func(t *testing.T) {
+		req := require.New(t)
+		client := &testMethodApiHandler{rootPath: "/edge/client/"}
+		edge := &testMethodApiHandler{rootPath: "/edge/"}
+		root := &testMethodApiHandler{rootPath: "/"}
+		fabric := &testMethodApiHandler{rootPath: "/fabric/"}
+
+		handler, err := (&PathPrefixDemuxFactory{}).Build([]ApiHandler{client, edge, root, fabric})
+		req.NoError(err)
+
+		for _, path := range []string{"/edge/client/v1/sessions", "/edge/management/v1/services", "/fabric/v1/routers", "/"} {
+			handler.ServeHTTP(gmhttptest.NewRecorder(), gmhttptest.NewRequest("GET", path, nil))
+		}
+
+		req.Len(client.served, 1)
+		req.Len(edge.served, 1)
+		req.Len(root.served, 2)
+		req.Empty(fabric.served)
+	})
+
+	t.Run("unmatched requests are served by the default http handler", func(t *testing.T) {
+		req := require.New(t)
+		factory := &PathPrefixDemuxFactory{}
+		handler, err := factory.Build([]ApiHandler{&testMethodApiHandler{rootPath: "/edge/"}})
+		req.NoError(err)
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/other", nil))
+		req.Equal(gmhttp.StatusNotFound, recorder.Code)
+
+		factory.SetDefaultHttpHandler(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.WriteHeader(gmhttp.StatusTeapot)
+		}))
+
+		recorder = gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/other", nil))
+		req.Equal(gmhttp.StatusTeapot, recorder.Code)
+	})
+
+	t.Run("routing requests served by a server does not allocate", func(t *testing.T) {
+		req := require.New(t)
+		handlers := []ApiHandler{&benchApiHandler{testMethodApiHandler{rootPath: "/edge/"}}, &benchApiHandler{testMethodApiHandler{rootPath: "/fabric/"}}}
+		demux, err := (&PathPrefixDemuxFactory{}).Build(handlers)
+		req.NoError(err)
+
+		request, info := withRequestInfo(gmhttptest.NewRequest("GET", "/fabric/things", nil), &BindPointConfig{})
+		writer := gmhttptest.NewRecorder()
+
+		allocs := testing.AllocsPerRun(100, func() {
+			demux.ServeHTTP(writer, request)
+		})
+
+		req.Zero(allocs)
+		req.Equal(handlers[1], info.Value(HandlerContextKey))
+		req.Equal(handlers[1], *HandlerFromRequestContext(request.Context()))
+	})
+}
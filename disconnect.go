@@ -0,0 +1,107 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Client disconnect detection
+//
+// Both the net/http and gmhttp servers cancel a request's context when the client connection is closed, when an
+// HTTP/2 stream is reset, or when the handler returns. The helpers below build on that guarantee so that long-running
+// handlers can abort work without depending on which stack is serving them. They accept a context.Context so that
+// both *http.Request and *gmhttp.Request values may be used via request.Context().
+//
+// For HTTP/1.x the server can only observe a disconnect while it is not reading the request body itself. Handlers
+// must read the request body to completion (or close it) before a disconnect can be detected. HTTP/2 streams do not
+// have this limitation.
+
+// DisconnectNotifier is returned by OnClientDisconnect and allows the registered callback to be cancelled
+type DisconnectNotifier struct {
+	lock    sync.Mutex
+	stopped bool
+	fired   bool
+	stop    chan struct{}
+}
+
+// Stop prevents the callback from being invoked if it has not been already. It returns true if the callback was
+// prevented from running and false if it has already been invoked. Stop does not wait for a running callback to
+// return and as such may be called from within the callback.
+func (notifier *DisconnectNotifier) Stop() bool {
+	notifier.lock.Lock()
+	defer notifier.lock.Unlock()
+
+	if notifier.fired {
+		return false
+	}
+
+	if !notifier.stopped {
+		notifier.stopped = true
+		close(notifier.stop)
+	}
+
+	return true
+}
+
+// fire marks the notifier as fired and returns true if it had not been stopped
+func (notifier *DisconnectNotifier) fire() bool {
+	notifier.lock.Lock()
+	defer notifier.lock.Unlock()
+
+	if notifier.stopped {
+		return false
+	}
+
+	notifier.fired = true
+	return true
+}
+
+// OnClientDisconnect invokes callback in its own goroutine when ctx is cancelled, which for a request context means
+// the client has disconnected or the request is complete. Handlers should call Stop on the returned notifier when the
+// work that callback would abort has finished.
+func OnClientDisconnect(ctx context.Context, callback func()) *DisconnectNotifier {
+	notifier := &DisconnectNotifier{
+		stop: make(chan struct{}),
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			if notifier.fire() {
+				callback()
+			}
+		case <-notifier.stop:
+		}
+	}()
+
+	return notifier
+}
+
+// ClientDisconnected returns a channel that is closed when the client disconnects or the request is complete. It is
+// equivalent to ctx.Done() and is provided for readability at call sites.
+func ClientDisconnected(ctx context.Context) <-chan struct{} {
+	return ctx.Done()
+}
+
+// IsClientDisconnected returns true if ctx was cancelled, as opposed to timing out via a deadline. For request
+// contexts, cancellation occurs when the client disconnects, the stream is reset, or the handler has returned.
+func IsClientDisconnected(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.Canceled)
+}
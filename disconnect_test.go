@@ -0,0 +1,141 @@
+package xweb
+
+import (
+	"context"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// disconnectingClient sends an incomplete keep-alive request and then closes the connection once the handler has
+// started.
+func disconnectingClient(t *testing.T, address string, started <-chan struct{}) {
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n"))
+	require.NoError(t, err)
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not start")
+	}
+
+	require.NoError(t, conn.Close())
+}
+
+// disconnectTestHandler registers OnClientDisconnect and blocks until it fires or a timeout elapses
+func disconnectTestHandler(ctx context.Context, started chan<- struct{}, result chan<- bool) {
+	fired := make(chan struct{})
+	notifier := OnClientDisconnect(ctx, func() {
+		close(fired)
+	})
+
+	close(started)
+
+	select {
+	case <-fired:
+		result <- IsClientDisconnected(ctx)
+	case <-time.After(5 * time.Second):
+		notifier.Stop()
+		result <- false
+	}
+}
+
+func TestOnClientDisconnect(t *testing.T) {
+	t.Run("fires on client disconnect for net/http", func(t *testing.T) {
+		req := require.New(t)
+		started := make(chan struct{})
+		result := make(chan bool, 1)
+
+		server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			disconnectTestHandler(r.Context(), started, result)
+		}))
+		defer server.Close()
+
+		disconnectingClient(t, server.Listener.Addr().String(), started)
+
+		req.True(<-result)
+	})
+
+	t.Run("fires on client disconnect for gmhttp", func(t *testing.T) {
+		req := require.New(t)
+		started := make(chan struct{})
+		result := make(chan bool, 1)
+
+		server := gmhttptest.NewServer(gmhttp.HandlerFunc(func(_ gmhttp.ResponseWriter, r *gmhttp.Request) {
+			disconnectTestHandler(r.Context(), started, result)
+		}))
+		defer server.Close()
+
+		disconnectingClient(t, server.Listener.Addr().String(), started)
+
+		req.True(<-result)
+	})
+
+	t.Run("stop prevents the callback", func(t *testing.T) {
+		req := require.New(t)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		notifier := OnClientDisconnect(ctx, func() {
+			t.Error("callback should not be invoked")
+		})
+
+		req.True(notifier.Stop())
+		cancel()
+	})
+
+	t.Run("stop reports if the callback already ran", func(t *testing.T) {
+		req := require.New(t)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		fired := make(chan struct{})
+		notifier := OnClientDisconnect(ctx, func() {
+			close(fired)
+		})
+		cancel()
+		<-fired
+
+		req.False(notifier.Stop())
+	})
+}
+
+func TestDisconnectNotifier_Stop(t *testing.T) {
+	t.Run("stop may be called from within the callback", func(t *testing.T) {
+		req := require.New(t)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var notifier *DisconnectNotifier
+		ready := make(chan struct{})
+		stopped := make(chan bool, 1)
+		notifier = OnClientDisconnect(ctx, func() {
+			<-ready
+			stopped <- notifier.Stop()
+		})
+		close(ready)
+		cancel()
+
+		select {
+		case result := <-stopped:
+			req.False(result)
+		case <-time.After(5 * time.Second):
+			req.Fail("stop blocked inside the callback")
+		}
+	})
+
+	t.Run("stop may be called more than once", func(t *testing.T) {
+		req := require.New(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		notifier := OnClientDisconnect(ctx, func() {})
+		req.True(notifier.Stop())
+		req.True(notifier.Stop())
+	})
+}
@@ -0,0 +1,259 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"encoding/json"
+	"github.com/openziti/identity"
+	"time"
+)
+
+// EffectiveConfig is the fully resolved view of an InstanceConfig: defaults have been applied, identities have been
+// resolved, environment variable references have been expanded, and secrets have been redacted. Values are reported
+// as xweb parsed them, see InstanceConfig.Parse. InstanceImpl.EffectiveConfig also reports the runtime state that is
+// changed without reloading the configuration, such as feature flags, access log overrides and disabled APIs.
+type EffectiveConfig struct {
+	Section  string                   `json:"section"`
+	Enabled  bool                     `json:"enabled"`
+	Identity *EffectiveIdentityConfig `json:"identity,omitempty"`
+	Servers  []*EffectiveServerConfig `json:"servers"`
+
+	FeatureFlags       map[string]bool      `json:"featureFlags,omitempty"`
+	AccessLogOverrides []*AccessLogOverride `json:"accessLogOverrides,omitempty"`
+}
+
+// EffectiveServerConfig is the resolved view of a ServerConfig.
+type EffectiveServerConfig struct {
+	Name             string                      `json:"name"`
+	Identity         *EffectiveIdentityConfig    `json:"identity,omitempty"`
+	InheritsIdentity bool                        `json:"inheritsIdentity"`
+	BindPoints       []*EffectiveBindPointConfig `json:"bindPoints"`
+	APIs             []*EffectiveApiConfig       `json:"apis"`
+	Options          *EffectiveOptions           `json:"options"`
+}
+
+// EffectiveBindPointConfig is the resolved view of a BindPointConfig.
+type EffectiveBindPointConfig struct {
+	Interface       string              `json:"interface"`
+	Address         string              `json:"address"`
+	NewAddress      string              `json:"newAddress,omitempty"`
+	ErrorVerbosity  string              `json:"errorVerbosity"`
+	ResponseHeaders map[string][]string `json:"responseHeaders,omitempty"`
+
+	Preset            string `json:"preset,omitempty"`
+	ReadTimeout       string `json:"readTimeout,omitempty"`
+	ReadHeaderTimeout string `json:"readHeaderTimeout,omitempty"`
+	WriteTimeout      string `json:"writeTimeout,omitempty"`
+	IdleTimeout       string `json:"idleTimeout,omitempty"`
+	MaxHeaderBytes    int    `json:"maxHeaderBytes,omitempty"`
+	MinTLSVersion     string `json:"minTLSVersion,omitempty"`
+
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+}
+
+// EffectiveApiConfig is the resolved view of an ApiConfig. Options are converted to string keyed maps so that they
+// may be rendered as JSON.
+type EffectiveApiConfig struct {
+	Binding         string                 `json:"binding"`
+	Name            string                 `json:"name"`
+	Weight          int                    `json:"weight"`
+	ResponseHeaders map[string][]string    `json:"responseHeaders,omitempty"`
+	Options         map[string]interface{} `json:"options,omitempty"`
+	Disabled        bool                   `json:"disabled,omitempty"`
+}
+
+// EffectiveOptions is the resolved view of the Options for a ServerConfig.
+type EffectiveOptions struct {
+	ReadTimeout           string   `json:"readTimeout"`
+	IdleTimeout           string   `json:"idleTimeout"`
+	WriteTimeout          string   `json:"writeTimeout"`
+	MinTLSVersion         string   `json:"minTLSVersion"`
+	MaxTLSVersion         string   `json:"maxTLSVersion"`
+	TlsProfile            string   `json:"tlsProfile,omitempty"`
+	CipherSuites          []string `json:"cipherSuites,omitempty"`
+	ClientAuth            string   `json:"clientAuth,omitempty"`
+	ClientCaBundle        string   `json:"clientCaBundle,omitempty"`
+	BalanceStrategy       string   `json:"balanceStrategy,omitempty"`
+	AffinityCookie        string   `json:"affinityCookie,omitempty"`
+	AffinityInstanceId    string   `json:"affinityInstanceId,omitempty"`
+	ConnectionReapTimeout string   `json:"connectionReapTimeout,omitempty"`
+	ReapHijacked          bool     `json:"reapHijacked,omitempty"`
+	AcceptLoops           int      `json:"acceptLoops"`
+	TlsFingerprints       bool     `json:"tlsFingerprints,omitempty"`
+	HandshakeLimits       bool     `json:"handshakeLimits,omitempty"`
+	HandshakeBackend      string   `json:"handshakeBackend,omitempty"`
+	AccessLogEnabled      bool     `json:"accessLogEnabled"`
+	DrainTimeout          string   `json:"drainTimeout"`
+
+	CompressionExcludedContentTypes []string `json:"compressionExcludedContentTypes,omitempty"`
+}
+
+// EffectiveIdentityConfig is the resolved view of an identity.Config with private key material redacted.
+type EffectiveIdentityConfig struct {
+	Cert           string   `json:"cert"`
+	ServerCert     string   `json:"serverCert,omitempty"`
+	Key            string   `json:"key"`
+	ServerKey      string   `json:"serverKey,omitempty"`
+	CA             string   `json:"ca,omitempty"`
+	AltServerCerts []string `json:"altServerCerts,omitempty"`
+}
+
+// EffectiveConfig renders the current state of the InstanceConfig as an EffectiveConfig. It is most accurate after
+// Validate has been called, as that is when default identities are loaded and assigned.
+func (config *InstanceConfig) EffectiveConfig() *EffectiveConfig {
+	result := &EffectiveConfig{
+		Section: config.Section,
+		Enabled: config.enabled,
+		Servers: []*EffectiveServerConfig{},
+
+		FeatureFlags: config.Options.FeatureFlags,
+	}
+
+	if config.DefaultIdentity != nil {
+		result.Identity = newEffectiveIdentityConfig(config.DefaultIdentity.GetConfig())
+	} else if config.defaultIdentityConfig != nil {
+		result.Identity = newEffectiveIdentityConfig(config.defaultIdentityConfig)
+	}
+
+	for _, serverConfig := range config.ServerConfigs {
+		result.Servers = append(result.Servers, serverConfig.effectiveConfig(config.SensitiveKeyFragments))
+	}
+
+	return result
+}
+
+// JSON renders the EffectiveConfig as indented JSON.
+func (config *EffectiveConfig) JSON() ([]byte, error) {
+	return json.MarshalIndent(config, "", "  ")
+}
+
+// EffectiveConfig renders the current state of the ServerConfig as an EffectiveServerConfig.
+func (config *ServerConfig) EffectiveConfig() *EffectiveServerConfig {
+	return config.effectiveConfig(nil)
+}
+
+func (config *ServerConfig) effectiveConfig(sensitiveKeyFragments []string) *EffectiveServerConfig {
+	result := &EffectiveServerConfig{
+		Name:       config.Name,
+		BindPoints: []*EffectiveBindPointConfig{},
+		APIs:       []*EffectiveApiConfig{},
+		Options: &EffectiveOptions{
+			ReadTimeout:      config.Options.ReadTimeout.String(),
+			IdleTimeout:      config.Options.IdleTimeout.String(),
+			WriteTimeout:     config.Options.WriteTimeout.String(),
+			MinTLSVersion:    ReverseTlsVersionMap[config.Options.MinTLSVersion],
+			MaxTLSVersion:    ReverseTlsVersionMap[config.Options.MaxTLSVersion],
+			TlsProfile:       string(config.Options.TlsProfile),
+			CipherSuites:     config.Options.cipherSuiteStrs,
+			ClientAuth:       config.Options.clientAuthStr,
+			ClientCaBundle:   config.Options.ClientCaBundle,
+			BalanceStrategy:  string(config.Options.BalanceStrategy),
+			ReapHijacked:     config.Options.ReapHijacked,
+			AcceptLoops:      config.Options.AcceptLoops,
+			TlsFingerprints:  config.Options.TlsFingerprints,
+			HandshakeLimits:  config.Options.HandshakeLimitsEnabled,
+			AccessLogEnabled: config.Options.AccessLogEnabled,
+			DrainTimeout:     config.Options.DrainTimeout.String(),
+
+			CompressionExcludedContentTypes: config.Options.CompressionExcludedContentTypes,
+		},
+	}
+
+	if config.Identity != nil {
+		result.Identity = newEffectiveIdentityConfig(config.Identity.GetConfig())
+		result.InheritsIdentity = config.Identity == config.DefaultIdentity
+	} else if config.DefaultIdentity != nil {
+		result.Identity = newEffectiveIdentityConfig(config.DefaultIdentity.GetConfig())
+		result.InheritsIdentity = true
+	}
+
+	if config.Options.AffinityEnabled {
+		result.Options.AffinityCookie = config.Options.AffinityCookieName
+		result.Options.AffinityInstanceId = config.Options.AffinityInstanceId
+	}
+
+	if config.Options.HandshakeLimitsEnabled {
+		result.Options.HandshakeBackend = config.Options.HandshakeBackend.Type
+	}
+
+	if config.Options.ConnectionReapTimeout > 0 {
+		result.Options.ConnectionReapTimeout = config.Options.ConnectionReapTimeout.String()
+	}
+
+	for _, bindPoint := range config.BindPoints {
+		errorVerbosity := bindPoint.ErrorVerbosity
+		if errorVerbosity == "" {
+			errorVerbosity = DefaultErrorVerbosity
+		}
+
+		result.BindPoints = append(result.BindPoints, &EffectiveBindPointConfig{
+			Interface:         bindPoint.InterfaceAddress,
+			Address:           bindPoint.Address,
+			NewAddress:        bindPoint.NewAddress,
+			ErrorVerbosity:    string(errorVerbosity),
+			ResponseHeaders:   bindPoint.ResponseHeaders,
+			Preset:            bindPoint.Preset,
+			ReadTimeout:       effectiveDuration(bindPoint.ReadTimeout),
+			ReadHeaderTimeout: effectiveDuration(bindPoint.ReadHeaderTimeout),
+			WriteTimeout:      effectiveDuration(bindPoint.WriteTimeout),
+			IdleTimeout:       effectiveDuration(bindPoint.IdleTimeout),
+			MaxHeaderBytes:    bindPoint.MaxHeaderBytes,
+			MinTLSVersion:     ReverseTlsVersionMap[bindPoint.MinTLSVersion],
+			AllowedMethods:    bindPoint.AllowedMethods,
+		})
+	}
+
+	for _, api := range config.APIs {
+		result.APIs = append(result.APIs, &EffectiveApiConfig{
+			Binding:         api.Binding(),
+			Name:            api.Name(),
+			Weight:          api.Weight(),
+			ResponseHeaders: api.ResponseHeaders(),
+			Options:         redactOptions(api.Options(), sensitiveKeyFragments),
+		})
+	}
+
+	return result
+}
+
+func newEffectiveIdentityConfig(idConfig *identity.Config) *EffectiveIdentityConfig {
+	if idConfig == nil {
+		return nil
+	}
+
+	result := &EffectiveIdentityConfig{
+		Cert:       idConfig.Cert,
+		ServerCert: idConfig.ServerCert,
+		Key:        redactKeyAddress(idConfig.Key),
+		ServerKey:  redactKeyAddress(idConfig.ServerKey),
+		CA:         idConfig.CA,
+	}
+
+	for _, altServerCert := range idConfig.AltServerCerts {
+		result.AltServerCerts = append(result.AltServerCerts, altServerCert.ServerCert)
+	}
+
+	return result
+}
+
+// effectiveDuration renders an optional duration, unset durations are omitted
+func effectiveDuration(duration time.Duration) string {
+	if duration == 0 {
+		return ""
+	}
+	return duration.String()
+}
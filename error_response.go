@@ -0,0 +1,130 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/xweb/v2/bufpool"
+)
+
+// ErrorVerbosity controls how much detail about a failure is returned to clients of a bind point
+type ErrorVerbosity string
+
+const (
+	// ErrorVerbosityMinimal returns only the status code and generic status text, suitable for public bind points
+	ErrorVerbosityMinimal ErrorVerbosity = "minimal"
+
+	// ErrorVerbosityDetailed additionally returns an error id, the cause, any details (i.e. validation failures) and,
+	// for panics, the stack. Intended for internal and management bind points.
+	ErrorVerbosityDetailed ErrorVerbosity = "detailed"
+
+	DefaultErrorVerbosity = ErrorVerbosityMinimal
+)
+
+// IsValid returns true if the verbosity is a known value, empty is treated as DefaultErrorVerbosity
+func (verbosity ErrorVerbosity) IsValid() bool {
+	switch verbosity {
+	case "", ErrorVerbosityMinimal, ErrorVerbosityDetailed:
+		return true
+	}
+	return false
+}
+
+// ErrorResponse is the JSON body written by WriteError
+type ErrorResponse struct {
+	Error *ErrorBody `json:"error"`
+}
+
+// ErrorBody describes a failure. Only Code and Message are populated for ErrorVerbosityMinimal.
+type ErrorBody struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Id      string      `json:"id,omitempty"`
+	Cause   string      `json:"cause,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+	Stack   string      `json:"stack,omitempty"`
+}
+
+// ErrorVerbosityFromContext returns the ErrorVerbosity of the bind point a request was received on. Requests not
+// served by an xweb Server return DefaultErrorVerbosity.
+func ErrorVerbosityFromContext(ctx context.Context) ErrorVerbosity {
+	if info := requestInfoFromContext(ctx); info != nil && info.bindPoint != nil && info.bindPoint.ErrorVerbosity != "" {
+		return info.bindPoint.ErrorVerbosity
+	}
+	return DefaultErrorVerbosity
+}
+
+// WriteError writes a JSON ErrorResponse for status. The cause and details are only returned to clients if the bind
+// point the request was received on is configured for ErrorVerbosityDetailed. The cause is always logged along with
+// an error id so that failures reported by clients of detailed bind points can be correlated with logs.
+func WriteError(writer gmhttp.ResponseWriter, request *gmhttp.Request, status int, cause error, details interface{}) {
+	writeError(writer, request, status, cause, details, "")
+}
+
+func writeError(writer gmhttp.ResponseWriter, request *gmhttp.Request, status int, cause error, details interface{}, stack string) {
+	id := newErrorId()
+
+	if cause != nil && status >= gmhttp.StatusInternalServerError {
+		pfxlog.Logger().WithField("errorId", id).WithField("path", request.URL.Path).Errorf("request failed with status %d: %v", status, cause)
+	}
+
+	writeErrorResponse(writer, request, status, id, cause, details, stack)
+}
+
+// writeErrorResponse writes the ErrorResponse for a failure that has already been logged with the error id
+func writeErrorResponse(writer gmhttp.ResponseWriter, request *gmhttp.Request, status int, id string, cause error, details interface{}, stack string) {
+	body := &ErrorBody{
+		Code:    status,
+		Message: gmhttp.StatusText(status),
+	}
+
+	if ErrorVerbosityFromContext(request.Context()) == ErrorVerbosityDetailed {
+		body.Id = id
+		body.Details = details
+		body.Stack = stack
+		if cause != nil {
+			body.Cause = cause.Error()
+		}
+	}
+
+	buffer := bufpool.ErrorPage.Get()
+	defer bufpool.ErrorPage.Put(buffer)
+
+	if err := json.NewEncoder(buffer).Encode(&ErrorResponse{Error: body}); err != nil {
+		pfxlog.Logger().WithField("errorId", id).Errorf("could not marshal error response: %v", err)
+		writer.WriteHeader(status)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_, _ = writer.Write(buffer.Bytes())
+}
+
+func newErrorId() string {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return fmt.Sprintf("%x", idBytes)
+	}
+	return hex.EncodeToString(idBytes)
+}
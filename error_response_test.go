@@ -0,0 +1,52 @@
+package xweb
+
+import (
+	"encoding/json"
+	"errors"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_WriteError(t *testing.T) {
+	writeFor := func(req *require.Assertions, verbosity ErrorVerbosity) *ErrorResponse {
+		request := gmhttptest.NewRequest("GET", "/things", nil)
+		request, _ = withRequestInfo(request, &BindPointConfig{ErrorVerbosity: verbosity})
+		recorder := gmhttptest.NewRecorder()
+
+		WriteError(recorder, request, gmhttp.StatusBadRequest, errors.New("name is required"), map[string]string{"field": "name"})
+
+		req.Equal(gmhttp.StatusBadRequest, recorder.Code)
+		req.Equal("application/json", recorder.Header().Get("Content-Type"))
+
+		response := &ErrorResponse{}
+		req.NoError(json.Unmarshal(recorder.Body.Bytes(), response))
+		return response
+	}
+
+	t.Run("minimal bind points return generic errors", func(t *testing.T) {
+		req := require.New(t)
+		response := writeFor(req, ErrorVerbosityMinimal)
+		req.Equal(gmhttp.StatusBadRequest, response.Error.Code)
+		req.Equal("Bad Request", response.Error.Message)
+		req.Empty(response.Error.Id)
+		req.Empty(response.Error.Cause)
+		req.Nil(response.Error.Details)
+	})
+
+	t.Run("detailed bind points return the cause and details", func(t *testing.T) {
+		req := require.New(t)
+		response := writeFor(req, ErrorVerbosityDetailed)
+		req.Equal("Bad Request", response.Error.Message)
+		req.NotEmpty(response.Error.Id)
+		req.Equal("name is required", response.Error.Cause)
+		req.Equal(map[string]interface{}{"field": "name"}, response.Error.Details)
+	})
+
+	t.Run("unconfigured bind points are minimal", func(t *testing.T) {
+		req := require.New(t)
+		response := writeFor(req, "")
+		req.Empty(response.Error.Cause)
+	})
+}
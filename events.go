@@ -0,0 +1,109 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/foundation/v2/debugz"
+	"sync"
+)
+
+// Event is emitted by xweb components to notify embedding applications of state changes
+type Event interface {
+	EventType() string
+}
+
+// EventListener receives Event's from an EventDispatcher. AcceptEvent is called synchronously and should not block.
+type EventListener interface {
+	AcceptEvent(event Event)
+}
+
+// EventListenerFunc adapts a function to the EventListener interface
+type EventListenerFunc func(event Event)
+
+func (f EventListenerFunc) AcceptEvent(event Event) {
+	f(event)
+}
+
+// EventDispatcher delivers Event's to registered EventListener's. AddListener returns a function that removes the
+// listener.
+type EventDispatcher interface {
+	AddListener(listener EventListener) (remove func())
+	Dispatch(event Event)
+}
+
+// NewEventDispatcher returns an EventDispatcher that delivers events to all listeners in registration order
+func NewEventDispatcher() EventDispatcher {
+	return &eventDispatcherImpl{}
+}
+
+type registeredListener struct {
+	id       uint64
+	listener EventListener
+}
+
+type eventDispatcherImpl struct {
+	lock      sync.RWMutex
+	nextId    uint64
+	listeners []registeredListener
+}
+
+func (dispatcher *eventDispatcherImpl) AddListener(listener EventListener) func() {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+
+	dispatcher.nextId++
+	id := dispatcher.nextId
+	dispatcher.listeners = append(dispatcher.listeners, registeredListener{id: id, listener: listener})
+
+	return func() {
+		dispatcher.removeListener(id)
+	}
+}
+
+func (dispatcher *eventDispatcherImpl) removeListener(id uint64) {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+
+	for i, existing := range dispatcher.listeners {
+		if existing.id == id {
+			//copy so that in progress dispatches are not affected
+			dispatcher.listeners = append(dispatcher.listeners[:i:i], dispatcher.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// Dispatch delivers event to all listeners. A panicking listener is logged and does not prevent delivery to others.
+func (dispatcher *eventDispatcherImpl) Dispatch(event Event) {
+	dispatcher.lock.RLock()
+	listeners := dispatcher.listeners
+	dispatcher.lock.RUnlock()
+
+	for _, registered := range listeners {
+		listener := registered.listener
+		func() {
+			defer func() {
+				if panicVal := recover(); panicVal != nil {
+					pfxlog.Logger().Errorf("panic caught by event dispatcher for event %s: %v\n%v", event.EventType(), panicVal, debugz.GenerateLocalStack())
+				}
+			}()
+
+			listener.AcceptEvent(event)
+		}()
+	}
+}
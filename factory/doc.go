@@ -0,0 +1,37 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+/*
+Package factory contains the stable interfaces for authors of xweb API factories. Factories implement Factory to create
+a WebHandler for each APIBinding configured on a server, and are registered with xweb.RegisterFactory.
+
+The interfaces in this package only change compatibly: new capabilities are added as new optional interfaces, such as
+MethodHandler and DefaultHandler, which WebHandler's may implement. xweb's server, demux and middleware machinery may
+be refactored freely behind them, and factories that depend only on this package are adapted to it by xweb.
+
+Servers passed to New may implement optional interfaces as well. A ServiceServer provides the Services published by
+the embedding application, which factories resolve by type rather than sharing state through package level variables:
+
+	func (f *myFactory) New(server factory.Server, binding factory.APIBinding) (factory.WebHandler, error) {
+		var services *factory.Services
+		if serviceServer, ok := server.(factory.ServiceServer); ok {
+			services = serviceServer.Services()
+		}
+		db, err := factory.Resolve[*sql.DB](services)
+		...
+	}
+*/
+package factory
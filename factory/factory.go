@@ -0,0 +1,116 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package factory
+
+import (
+	"context"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"time"
+)
+
+// Factory creates WebHandler's for the APIBinding's that reference its binding name
+type Factory interface {
+	// Binding returns the binding name used in configurations to reference this Factory
+	Binding() string
+
+	// New creates a WebHandler for an APIBinding configured on a Server
+	New(server Server, binding APIBinding) (WebHandler, error)
+}
+
+// Validator is a Factory that validates configuration shared by all of its APIBinding's before any WebHandler's are
+// created. It is called once with the APIBinding's of every configured Server that reference the Factory.
+type Validator interface {
+	Factory
+
+	Validate(bindings []APIBinding) error
+}
+
+// Server describes the server a WebHandler is created for
+type Server interface {
+	// Name returns the name of the server configuration
+	Name() string
+
+	// Addresses returns the interface addresses the server listens on
+	Addresses() []string
+}
+
+// TaskServer is a Server that runs background tasks for the WebHandler's created for it
+type TaskServer interface {
+	Server
+
+	// Tasks returns the TaskRunner for background work, which is stopped when the server's instance shuts down
+	Tasks() TaskRunner
+}
+
+// TaskRunner runs background tasks with a context that is cancelled when the server's instance shuts down. Panics
+// are recovered and runs are recorded in the instance's metrics.
+type TaskRunner interface {
+	// Go runs a task once in the background
+	Go(name string, task func(ctx context.Context) error)
+
+	// Every runs a task in the background each interval, starting one interval from now. An error is returned if
+	// interval is not positive.
+	Every(name string, interval time.Duration, task func(ctx context.Context) error) error
+}
+
+// APIBinding describes a single API configured on a server
+type APIBinding interface {
+	// Binding returns the binding name of the Factory the API is created by
+	Binding() string
+
+	// Name returns the instance name of the API, which distinguishes multiple APIs with the same binding on a server.
+	// It is the binding name unless configured otherwise.
+	Name() string
+
+	// Options returns the options configured for the API
+	Options() Options
+}
+
+// WebHandler serves requests for an APIBinding
+type WebHandler interface {
+	gmhttp.Handler
+
+	// RootPath returns the path prefix served, used by path prefix demultiplexing
+	RootPath() string
+
+	// IsHandler returns true if the request should be served, used by demultiplexing that delegates to handlers
+	IsHandler(request *gmhttp.Request) bool
+}
+
+// MethodHandler is a WebHandler that declares the http methods it supports, allowing servers to answer OPTIONS
+// requests and reject unsupported methods on its behalf
+type MethodHandler interface {
+	WebHandler
+
+	// AllowedMethods returns the methods supported for the request's path or nil if they are not known
+	AllowedMethods(request *gmhttp.Request) []string
+}
+
+// DefaultHandler is a WebHandler that may serve requests that no other WebHandler on a server matches
+type DefaultHandler interface {
+	WebHandler
+
+	IsDefault() bool
+}
+
+// Stopper is a WebHandler that holds resources which must be released when its server shuts down. Stop is called once
+// the server has stopped serving requests.
+type Stopper interface {
+	WebHandler
+
+	Stop(ctx context.Context) error
+}
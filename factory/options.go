@@ -0,0 +1,251 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package factory
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrOptionNotFound is returned by the Options getters when no value exists at the requested path
+var ErrOptionNotFound = errors.New("option not found")
+
+// Options is a string keyed view of the options configured for an APIBinding. Nested maps are Options as well, and maps
+// nested in lists are normalized the same way, so that factories do not need to handle the interface{} keyed maps
+// produced by YAML parsing. Getters accept a path of keys to reach into nested maps.
+type Options map[string]interface{}
+
+// NormalizeOptions returns a deep copy of an options map with all map keys, including those of maps nested in maps
+// and lists, converted to strings. Returns nil if options is nil.
+func NormalizeOptions(options map[interface{}]interface{}) Options {
+	if options == nil {
+		return nil
+	}
+
+	result, _ := normalizeOptionValue(options).(Options)
+	return result
+}
+
+func normalizeOptionValue(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case map[interface{}]interface{}:
+		result := Options{}
+		for key, childValue := range typedValue {
+			result[fmt.Sprint(key)] = normalizeOptionValue(childValue)
+		}
+		return result
+	case map[string]interface{}:
+		result := Options{}
+		for key, childValue := range typedValue {
+			result[key] = normalizeOptionValue(childValue)
+		}
+		return result
+	case Options:
+		result := Options{}
+		for key, childValue := range typedValue {
+			result[key] = normalizeOptionValue(childValue)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, 0, len(typedValue))
+		for _, childValue := range typedValue {
+			result = append(result, normalizeOptionValue(childValue))
+		}
+		return result
+	}
+
+	return value
+}
+
+// Has returns true if a value exists at the path
+func (options Options) Has(path ...string) bool {
+	_, ok := options.Get(path...)
+	return ok
+}
+
+// Get returns the value at the path and true, or nil and false if no value exists
+func (options Options) Get(path ...string) (interface{}, bool) {
+	if len(path) == 0 {
+		return nil, false
+	}
+
+	current := options
+	for i, key := range path {
+		value, ok := current[key]
+		if !ok {
+			return nil, false
+		}
+
+		if i == len(path)-1 {
+			return value, true
+		}
+
+		if current, ok = value.(Options); !ok {
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+// MustGet returns the value at the path and panics if no value exists. It is intended for use after options have
+// been validated.
+func (options Options) MustGet(path ...string) interface{} {
+	value, ok := options.Get(path...)
+	if !ok {
+		panic(fmt.Sprintf("required option [%s] not found", optionPath(path)))
+	}
+	return value
+}
+
+// GetString returns the string at the path
+func (options Options) GetString(path ...string) (string, error) {
+	value, err := options.lookup(path)
+	if err != nil {
+		return "", err
+	}
+
+	if result, ok := value.(string); ok {
+		return result, nil
+	}
+	return "", errors.Errorf("option [%s] is not a string", optionPath(path))
+}
+
+// GetBool returns the boolean at the path
+func (options Options) GetBool(path ...string) (bool, error) {
+	value, err := options.lookup(path)
+	if err != nil {
+		return false, err
+	}
+
+	if result, ok := value.(bool); ok {
+		return result, nil
+	}
+	return false, errors.Errorf("option [%s] is not a boolean", optionPath(path))
+}
+
+// GetInt returns the integer at the path. Floating point values are accepted if they have no fractional part.
+func (options Options) GetInt(path ...string) (int, error) {
+	value, err := options.lookup(path)
+	if err != nil {
+		return 0, err
+	}
+
+	switch typedValue := value.(type) {
+	case int:
+		return typedValue, nil
+	case int64:
+		if typedValue >= math.MinInt && typedValue <= math.MaxInt {
+			return int(typedValue), nil
+		}
+	case uint64:
+		if typedValue <= math.MaxInt {
+			return int(typedValue), nil
+		}
+	case float64:
+		if typedValue == math.Trunc(typedValue) && typedValue >= math.MinInt && typedValue <= math.MaxInt {
+			return int(typedValue), nil
+		}
+	}
+
+	return 0, errors.Errorf("option [%s] is not an integer", optionPath(path))
+}
+
+// GetFloat returns the number at the path
+func (options Options) GetFloat(path ...string) (float64, error) {
+	value, err := options.lookup(path)
+	if err != nil {
+		return 0, err
+	}
+
+	switch typedValue := value.(type) {
+	case float64:
+		return typedValue, nil
+	case int:
+		return float64(typedValue), nil
+	case int64:
+		return float64(typedValue), nil
+	case uint64:
+		return float64(typedValue), nil
+	}
+
+	return 0, errors.Errorf("option [%s] is not a number", optionPath(path))
+}
+
+// GetDuration returns the duration parsed from the string at the path (e.g. 1m)
+func (options Options) GetDuration(path ...string) (time.Duration, error) {
+	value, err := options.GetString(path...)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not parse option [%s] as a duration (e.g. 1m)", optionPath(path))
+	}
+	return result, nil
+}
+
+// GetStringSlice returns the list of strings at the path
+func (options Options) GetStringSlice(path ...string) ([]string, error) {
+	value, err := options.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("option [%s] is not a list", optionPath(path))
+	}
+
+	result := make([]string, 0, len(list))
+	for i, entry := range list {
+		str, ok := entry.(string)
+		if !ok {
+			return nil, errors.Errorf("option [%s] entry %d is not a string", optionPath(path), i)
+		}
+		result = append(result, str)
+	}
+	return result, nil
+}
+
+// GetOptions returns the map at the path
+func (options Options) GetOptions(path ...string) (Options, error) {
+	value, err := options.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if result, ok := value.(Options); ok {
+		return result, nil
+	}
+	return nil, errors.Errorf("option [%s] is not a map", optionPath(path))
+}
+
+func (options Options) lookup(path []string) (interface{}, error) {
+	if value, ok := options.Get(path...); ok {
+		return value, nil
+	}
+	return nil, errors.Wrapf(ErrOptionNotFound, "option [%s]", optionPath(path))
+}
+
+func optionPath(path []string) string {
+	return strings.Join(path, ".")
+}
@@ -0,0 +1,110 @@
+package factory
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	raw := map[interface{}]interface{}{
+		"name":    "edge",
+		"enabled": true,
+		"port":    8443,
+		"ratio":   0.5,
+		"timeout": "5s",
+		"hosts":   []interface{}{"a", "b"},
+		1:         "numeric key",
+		"tls": map[interface{}]interface{}{
+			"ca": "ca.pem",
+			"pins": []interface{}{
+				map[interface{}]interface{}{"sha256": "abc"},
+			},
+		},
+	}
+
+	t.Run("nested maps are normalized recursively", func(t *testing.T) {
+		req := require.New(t)
+		options := NormalizeOptions(raw)
+
+		req.Equal("numeric key", options["1"])
+
+		tls, err := options.GetOptions("tls")
+		req.NoError(err)
+		req.Equal("ca.pem", tls["ca"])
+
+		pins, ok := options.Get("tls", "pins")
+		req.True(ok)
+		req.Equal([]interface{}{Options{"sha256": "abc"}}, pins)
+	})
+
+	t.Run("nil options normalize to nil", func(t *testing.T) {
+		require.Nil(t, NormalizeOptions(nil))
+	})
+
+	t.Run("typed getters convert values", func(t *testing.T) {
+		req := require.New(t)
+		options := NormalizeOptions(raw)
+
+		name, err := options.GetString("name")
+		req.NoError(err)
+		req.Equal("edge", name)
+
+		enabled, err := options.GetBool("enabled")
+		req.NoError(err)
+		req.True(enabled)
+
+		port, err := options.GetInt("port")
+		req.NoError(err)
+		req.Equal(8443, port)
+
+		ratio, err := options.GetFloat("ratio")
+		req.NoError(err)
+		req.Equal(0.5, ratio)
+
+		timeout, err := options.GetDuration("timeout")
+		req.NoError(err)
+		req.Equal(5*time.Second, timeout)
+
+		hosts, err := options.GetStringSlice("hosts")
+		req.NoError(err)
+		req.Equal([]string{"a", "b"}, hosts)
+
+		ca, err := options.GetString("tls", "ca")
+		req.NoError(err)
+		req.Equal("ca.pem", ca)
+	})
+
+	t.Run("getters report missing and mistyped values", func(t *testing.T) {
+		req := require.New(t)
+		options := NormalizeOptions(raw)
+
+		_, err := options.GetString("missing")
+		req.ErrorIs(err, ErrOptionNotFound)
+
+		_, err = options.GetString("name", "nested")
+		req.ErrorIs(err, ErrOptionNotFound)
+
+		_, err = options.GetInt("name")
+		req.EqualError(err, "option [name] is not an integer")
+
+		_, err = options.GetInt("ratio")
+		req.Error(err)
+
+		_, err = options.GetDuration("name")
+		req.Error(err)
+
+		req.False(options.Has())
+		req.True(options.Has("tls", "ca"))
+	})
+
+	t.Run("MustGet panics for missing values", func(t *testing.T) {
+		req := require.New(t)
+		options := NormalizeOptions(raw)
+
+		req.Equal("edge", options.MustGet("name"))
+		req.PanicsWithValue("required option [tls.missing] not found", func() {
+			options.MustGet("tls", "missing")
+		})
+	})
+}
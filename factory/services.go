@@ -0,0 +1,132 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package factory
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrServiceExists   = errors.New("service already provided")
+	ErrServiceNotFound = errors.New("service not found")
+)
+
+// ServiceServer is a Server that provides the services published by the embedding application, allowing factories
+// to share database pools, caches and the like without package level variables
+type ServiceServer interface {
+	Server
+
+	// Services returns the services of the server's instance
+	Services() *Services
+}
+
+// Services is a registry of shared services keyed by type and an optional name. Applications publish services with
+// Provide or ProvideNamed before the instance is built, factories resolve them in New with Resolve or ResolveNamed.
+// Interface types are resolved by the interface a service was provided as, not by the types implementing it.
+type Services struct {
+	lock     sync.RWMutex
+	services map[serviceKey]interface{}
+}
+
+type serviceKey struct {
+	serviceType reflect.Type
+	name        string
+}
+
+func (key serviceKey) String() string {
+	if key.name == "" {
+		return key.serviceType.String()
+	}
+	return key.serviceType.String() + "/" + key.name
+}
+
+// NewServices returns an empty Services
+func NewServices() *Services {
+	return &Services{
+		services: map[serviceKey]interface{}{},
+	}
+}
+
+// Provide publishes service as the unnamed service of type T
+func Provide[T any](services *Services, service T) error {
+	return ProvideNamed(services, "", service)
+}
+
+// ProvideNamed publishes service as the service of type T with the given name. An error wrapping ErrServiceExists is
+// returned if a service of the same type and name has already been provided.
+func ProvideNamed[T any](services *Services, name string, service T) error {
+	key := newServiceKey[T](name)
+
+	services.lock.Lock()
+	defer services.lock.Unlock()
+
+	if _, ok := services.services[key]; ok {
+		return fmt.Errorf("%w: %s", ErrServiceExists, key)
+	}
+	services.services[key] = service
+
+	return nil
+}
+
+// Resolve returns the unnamed service of type T
+func Resolve[T any](services *Services) (T, error) {
+	return ResolveNamed[T](services, "")
+}
+
+// ResolveNamed returns the service of type T with the given name. An error wrapping ErrServiceNotFound is returned
+// if no such service has been provided or services is nil.
+func ResolveNamed[T any](services *Services, name string) (T, error) {
+	key := newServiceKey[T](name)
+
+	if services != nil {
+		services.lock.RLock()
+		service, ok := services.services[key]
+		services.lock.RUnlock()
+
+		if ok {
+			return service.(T), nil
+		}
+	}
+
+	var empty T
+	return empty, fmt.Errorf("%w: %s", ErrServiceNotFound, key)
+}
+
+// Keys returns the sorted type and name of each provided service, e.g. *sql.DB or *sql.DB/reporting
+func (services *Services) Keys() []string {
+	services.lock.RLock()
+	defer services.lock.RUnlock()
+
+	var result []string
+	for key := range services.services {
+		result = append(result, key.String())
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func newServiceKey[T any](name string) serviceKey {
+	return serviceKey{
+		serviceType: reflect.TypeOf((*T)(nil)).Elem(),
+		name:        name,
+	}
+}
@@ -0,0 +1,74 @@
+package factory
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type testCache struct {
+	name string
+}
+
+func TestServices(t *testing.T) {
+	t.Run("services are resolved by type and name", func(t *testing.T) {
+		req := require.New(t)
+		services := NewServices()
+
+		sessions := &testCache{name: "sessions"}
+		reports := &testCache{name: "reports"}
+		req.NoError(Provide(services, sessions))
+		req.NoError(ProvideNamed(services, "reports", reports))
+
+		resolved, err := Resolve[*testCache](services)
+		req.NoError(err)
+		req.Same(sessions, resolved)
+
+		resolved, err = ResolveNamed[*testCache](services, "reports")
+		req.NoError(err)
+		req.Same(reports, resolved)
+
+		req.Equal([]string{"*factory.testCache", "*factory.testCache/reports"}, services.Keys())
+	})
+
+	t.Run("interfaces are resolved by the type provided", func(t *testing.T) {
+		req := require.New(t)
+		services := NewServices()
+
+		req.NoError(Provide[fmt.Stringer](services, testStringer("value")))
+
+		stringer, err := Resolve[fmt.Stringer](services)
+		req.NoError(err)
+		req.Equal("value", stringer.String())
+
+		_, err = Resolve[testStringer](services)
+		req.ErrorIs(err, ErrServiceNotFound)
+	})
+
+	t.Run("services may only be provided once", func(t *testing.T) {
+		req := require.New(t)
+		services := NewServices()
+
+		req.NoError(Provide(services, &testCache{}))
+		err := Provide(services, &testCache{})
+		req.ErrorIs(err, ErrServiceExists)
+		req.EqualError(err, "service already provided: *factory.testCache")
+	})
+
+	t.Run("missing services are reported", func(t *testing.T) {
+		req := require.New(t)
+
+		resolved, err := ResolveNamed[*testCache](NewServices(), "reports")
+		req.Nil(resolved)
+		req.EqualError(err, "service not found: *factory.testCache/reports")
+
+		_, err = Resolve[*testCache](nil)
+		req.ErrorIs(err, ErrServiceNotFound)
+	})
+}
+
+type testStringer string
+
+func (s testStringer) String() string {
+	return string(s)
+}
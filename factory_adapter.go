@@ -0,0 +1,305 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/openziti/xweb/v2/factory"
+	"time"
+)
+
+// RegisterFactory adds a factory.Factory to a Registry, adapting it to an ApiHandlerFactory
+func RegisterFactory(registry Registry, stableFactory factory.Factory) error {
+	return registry.Add(NewFactoryAdapter(stableFactory))
+}
+
+// NewFactoryAdapter adapts a factory.Factory to an ApiHandlerFactory. The WebHandler's it creates are adapted to
+// ApiHandler's that also satisfy MethodApiHandler and DefaultApiHandler on behalf of WebHandler's implementing
+// factory.MethodHandler and factory.DefaultHandler.
+func NewFactoryAdapter(stableFactory factory.Factory) ApiOptionsHandlerFactory {
+	return &factoryAdapter{
+		factory: stableFactory,
+	}
+}
+
+// apiConfigHandlerFactory is an ApiHandlerFactory that is given the complete ApiConfig to create an ApiHandler from
+type apiConfigHandlerFactory interface {
+	newFromApiConfig(serverConfig *ServerConfig, api *ApiConfig, capabilities *instanceCapabilities) (ApiHandler, error)
+}
+
+type factoryAdapter struct {
+	factory factory.Factory
+}
+
+var _ apiConfigHandlerFactory = &factoryAdapter{}
+
+func (adapter *factoryAdapter) Binding() string {
+	return adapter.factory.Binding()
+}
+
+func (adapter *factoryAdapter) New(serverConfig *ServerConfig, options map[interface{}]interface{}) (ApiHandler, error) {
+	return adapter.newHandler(serverConfig, &apiBindingAdapter{
+		binding: adapter.Binding(),
+		name:    adapter.Binding(),
+		raw:     options,
+		options: NormalizeOptions(options),
+	}, nil)
+}
+
+func (adapter *factoryAdapter) NewWithOptions(serverConfig *ServerConfig, options ApiOptions) (ApiHandler, error) {
+	return adapter.newHandler(serverConfig, &apiBindingAdapter{
+		binding: adapter.Binding(),
+		name:    adapter.Binding(),
+		raw:     denormalizeOptions(options),
+		options: options,
+	}, nil)
+}
+
+func (adapter *factoryAdapter) newFromApiConfig(serverConfig *ServerConfig, api *ApiConfig, capabilities *instanceCapabilities) (ApiHandler, error) {
+	return adapter.newHandler(serverConfig, newApiBindingAdapter(api), capabilities)
+}
+
+// newHandler creates a WebHandler for the binding. If the instance runs tasks, the factory.Server provided is a
+// factory.TaskServer whose tasks are named after the server and api. If the instance provides services, it is a
+// factory.ServiceServer.
+func (adapter *factoryAdapter) newHandler(serverConfig *ServerConfig, binding *apiBindingAdapter, capabilities *instanceCapabilities) (ApiHandler, error) {
+	if capabilities == nil {
+		capabilities = &instanceCapabilities{}
+	}
+
+	var server factory.Server = &serverAdapter{config: serverConfig}
+	if capabilities.tasks != nil {
+		taskServer := &taskServerAdapter{
+			serverAdapter: serverAdapter{config: serverConfig},
+			tasks: &scopedTaskRunner{
+				runner: capabilities.tasks,
+				prefix: serverConfig.Name + "/" + binding.Name() + "/",
+			},
+		}
+		server = taskServer
+
+		if capabilities.services != nil {
+			server = &taskServiceServerAdapter{
+				taskServerAdapter: *taskServer,
+				services:          capabilities.services,
+			}
+		}
+	} else if capabilities.services != nil {
+		server = &serviceServerAdapter{
+			serverAdapter: serverAdapter{config: serverConfig},
+			services:      capabilities.services,
+		}
+	}
+
+	handler, err := adapter.factory.New(server, binding)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webHandlerAdapter{
+		WebHandler: handler,
+		binding:    binding,
+	}, nil
+}
+
+// Validate calls factory.Validator implementations with the APIBinding's of all servers that reference the factory
+func (adapter *factoryAdapter) Validate(config *InstanceConfig) error {
+	validator, ok := adapter.factory.(factory.Validator)
+	if !ok {
+		return nil
+	}
+
+	var bindings []factory.APIBinding
+	for _, serverConfig := range config.ServerConfigs {
+		for _, api := range serverConfig.APIs {
+			if api.Binding() == adapter.Binding() {
+				bindings = append(bindings, newApiBindingAdapter(api))
+			}
+		}
+	}
+
+	return validator.Validate(bindings)
+}
+
+// Unwrap returns the adapted factory.Factory
+func (adapter *factoryAdapter) Unwrap() factory.Factory {
+	return adapter.factory
+}
+
+// serverAdapter provides a ServerConfig as a factory.Server
+type serverAdapter struct {
+	config *ServerConfig
+}
+
+func (server *serverAdapter) Name() string {
+	return server.config.Name
+}
+
+func (server *serverAdapter) Addresses() []string {
+	var result []string
+	for _, bindPoint := range server.config.BindPoints {
+		result = append(result, bindPoint.InterfaceAddress)
+	}
+	return result
+}
+
+// taskServerAdapter provides a ServerConfig and TaskRunner as a factory.TaskServer
+type taskServerAdapter struct {
+	serverAdapter
+	tasks factory.TaskRunner
+}
+
+var _ factory.TaskServer = &taskServerAdapter{}
+
+func (server *taskServerAdapter) Tasks() factory.TaskRunner {
+	return server.tasks
+}
+
+// serviceServerAdapter provides a ServerConfig and the Services of its Instance as a factory.ServiceServer
+type serviceServerAdapter struct {
+	serverAdapter
+	services *factory.Services
+}
+
+var _ factory.ServiceServer = &serviceServerAdapter{}
+
+func (server *serviceServerAdapter) Services() *factory.Services {
+	return server.services
+}
+
+// taskServiceServerAdapter is a taskServerAdapter that also provides the Services of its Instance as a
+// factory.ServiceServer
+type taskServiceServerAdapter struct {
+	taskServerAdapter
+	services *factory.Services
+}
+
+var _ factory.TaskServer = &taskServiceServerAdapter{}
+var _ factory.ServiceServer = &taskServiceServerAdapter{}
+
+func (server *taskServiceServerAdapter) Services() *factory.Services {
+	return server.services
+}
+
+// scopedTaskRunner prefixes the names of tasks started through it
+type scopedTaskRunner struct {
+	runner *TaskRunner
+	prefix string
+}
+
+func (runner *scopedTaskRunner) Go(name string, task func(ctx context.Context) error) {
+	runner.runner.Go(runner.prefix+name, task)
+}
+
+func (runner *scopedTaskRunner) Every(name string, interval time.Duration, task func(ctx context.Context) error) error {
+	return runner.runner.Every(runner.prefix+name, interval, task)
+}
+
+// apiBindingAdapter provides an ApiConfig as a factory.APIBinding
+type apiBindingAdapter struct {
+	binding string
+	name    string
+	raw     map[interface{}]interface{}
+	options ApiOptions
+}
+
+func newApiBindingAdapter(api *ApiConfig) *apiBindingAdapter {
+	return &apiBindingAdapter{
+		binding: api.Binding(),
+		name:    api.Name(),
+		raw:     api.Options(),
+		options: api.ApiOptions(),
+	}
+}
+
+func (binding *apiBindingAdapter) Binding() string {
+	return binding.binding
+}
+
+func (binding *apiBindingAdapter) Name() string {
+	return binding.name
+}
+
+func (binding *apiBindingAdapter) Options() factory.Options {
+	return binding.options
+}
+
+// webHandlerAdapter provides a factory.WebHandler as an ApiHandler
+type webHandlerAdapter struct {
+	factory.WebHandler
+	binding *apiBindingAdapter
+}
+
+var _ MethodApiHandler = &webHandlerAdapter{}
+var _ DefaultApiHandler = &webHandlerAdapter{}
+
+func (adapter *webHandlerAdapter) Binding() string {
+	return adapter.binding.Binding()
+}
+
+func (adapter *webHandlerAdapter) Options() map[interface{}]interface{} {
+	return adapter.binding.raw
+}
+
+// AllowedMethods delegates to the WebHandler if it is a factory.MethodHandler, otherwise the methods are not known
+func (adapter *webHandlerAdapter) AllowedMethods(request *gmhttp.Request) []string {
+	if methodHandler, ok := adapter.WebHandler.(factory.MethodHandler); ok {
+		return methodHandler.AllowedMethods(request)
+	}
+	return nil
+}
+
+// IsDefault delegates to the WebHandler if it is a factory.DefaultHandler
+func (adapter *webHandlerAdapter) IsDefault() bool {
+	if defaultHandler, ok := adapter.WebHandler.(factory.DefaultHandler); ok {
+		return defaultHandler.IsDefault()
+	}
+	return false
+}
+
+// Unwrap returns the adapted factory.WebHandler
+func (adapter *webHandlerAdapter) Unwrap() factory.WebHandler {
+	return adapter.WebHandler
+}
+
+// denormalizeOptions converts ApiOptions back to an interface{} keyed map for ApiHandler.Options
+func denormalizeOptions(options ApiOptions) map[interface{}]interface{} {
+	if options == nil {
+		return nil
+	}
+
+	result := map[interface{}]interface{}{}
+	for key, value := range options {
+		result[key] = denormalizeOptionValue(value)
+	}
+	return result
+}
+
+func denormalizeOptionValue(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case ApiOptions:
+		return denormalizeOptions(typedValue)
+	case []interface{}:
+		result := make([]interface{}, 0, len(typedValue))
+		for _, childValue := range typedValue {
+			result = append(result, denormalizeOptionValue(childValue))
+		}
+		return result
+	}
+	return value
+}
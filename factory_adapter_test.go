@@ -0,0 +1,136 @@
+package xweb
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/openziti/xweb/v2/factory"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type testStableFactory struct {
+	server    factory.Server
+	binding   factory.APIBinding
+	validated []factory.APIBinding
+}
+
+func (f *testStableFactory) Binding() string {
+	return "stable"
+}
+
+func (f *testStableFactory) New(server factory.Server, binding factory.APIBinding) (factory.WebHandler, error) {
+	f.server = server
+	f.binding = binding
+	return &testWebHandler{methods: []string{"GET"}}, nil
+}
+
+func (f *testStableFactory) Validate(bindings []factory.APIBinding) error {
+	f.validated = bindings
+	return nil
+}
+
+type testWebHandler struct {
+	methods []string
+}
+
+func (handler *testWebHandler) RootPath() string {
+	return "/stable/"
+}
+
+func (handler *testWebHandler) IsHandler(*gmhttp.Request) bool {
+	return true
+}
+
+func (handler *testWebHandler) AllowedMethods(*gmhttp.Request) []string {
+	return handler.methods
+}
+
+func (handler *testWebHandler) ServeHTTP(writer gmhttp.ResponseWriter, _ *gmhttp.Request) {
+	writer.WriteHeader(gmhttp.StatusAccepted)
+}
+
+func TestFactoryAdapter(t *testing.T) {
+	serverConfig := &ServerConfig{
+		Name:       "test-server",
+		BindPoints: []*BindPointConfig{{InterfaceAddress: "127.0.0.1:1280"}},
+	}
+	api := &ApiConfig{
+		binding: "stable",
+		name:    "stable-a",
+		options: map[interface{}]interface{}{"limit": 5},
+	}
+	serverConfig.APIs = []*ApiConfig{api}
+
+	t.Run("factories are registered under their binding", func(t *testing.T) {
+		req := require.New(t)
+		registry := NewRegistryMap()
+
+		req.NoError(RegisterFactory(registry, &testStableFactory{}))
+		req.NotNil(registry.Get("stable"))
+		req.Error(RegisterFactory(registry, &testStableFactory{}))
+	})
+
+	t.Run("web handlers are created from api configs", func(t *testing.T) {
+		req := require.New(t)
+		stableFactory := &testStableFactory{}
+
+		handler, err := newApiHandler(NewFactoryAdapter(stableFactory), serverConfig, api, nil)
+		req.NoError(err)
+
+		req.Equal("test-server", stableFactory.server.Name())
+		req.Equal([]string{"127.0.0.1:1280"}, stableFactory.server.Addresses())
+		req.Equal("stable", stableFactory.binding.Binding())
+		req.Equal("stable-a", stableFactory.binding.Name())
+		req.Equal(5, stableFactory.binding.Options()["limit"])
+
+		req.Equal("stable", handler.Binding())
+		req.Equal("/stable/", handler.RootPath())
+		req.Equal(api.Options(), handler.Options())
+		req.Equal([]string{"GET"}, handler.(MethodApiHandler).AllowedMethods(nil))
+		req.False(handler.(DefaultApiHandler).IsDefault())
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/stable/", nil))
+		req.Equal(gmhttp.StatusAccepted, recorder.Code)
+	})
+
+	t.Run("web handlers are created from options", func(t *testing.T) {
+		req := require.New(t)
+		stableFactory := &testStableFactory{}
+
+		handler, err := NewFactoryAdapter(stableFactory).NewWithOptions(serverConfig, ApiOptions{"nested": ApiOptions{"key": "value"}})
+		req.NoError(err)
+
+		req.Equal("stable", stableFactory.binding.Name())
+		req.Equal(map[interface{}]interface{}{"nested": map[interface{}]interface{}{"key": "value"}}, handler.Options())
+	})
+
+	t.Run("validators are given the bindings of all servers", func(t *testing.T) {
+		req := require.New(t)
+		stableFactory := &testStableFactory{}
+
+		req.NoError(NewFactoryAdapter(stableFactory).Validate(&InstanceConfig{ServerConfigs: []*ServerConfig{serverConfig}}))
+		req.Len(stableFactory.validated, 1)
+		req.Equal("stable-a", stableFactory.validated[0].Name())
+	})
+
+	t.Run("factories resolve the services of the instance", func(t *testing.T) {
+		req := require.New(t)
+		instance := NewDefaultInstance(NewRegistryMap(), nil)
+		cache := map[string]string{"key": "value"}
+		req.NoError(factory.Provide(instance.GetServices(), cache))
+
+		stableFactory := &testStableFactory{}
+		_, err := newApiHandler(NewFactoryAdapter(stableFactory), serverConfig, api, resolveInstanceCapabilities(instance))
+		req.NoError(err)
+
+		serviceServer, ok := stableFactory.server.(factory.ServiceServer)
+		req.True(ok)
+		_, ok = stableFactory.server.(factory.TaskServer)
+		req.True(ok)
+
+		resolved, err := factory.Resolve[map[string]string](serviceServer.Services())
+		req.NoError(err)
+		req.Equal(cache, resolved)
+	})
+}
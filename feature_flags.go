@@ -0,0 +1,187 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	EventTypeFeatureFlagsChanged = "xweb.featureFlags.changed"
+)
+
+// FeatureFlagsChangedEvent is dispatched when feature flags are set or reloaded. Changed holds the new value of every
+// flag whose value changed, flags removed by a reload are reported as false.
+type FeatureFlagsChangedEvent struct {
+	Changed map[string]bool `json:"changed"`
+}
+
+func (event *FeatureFlagsChangedEvent) EventType() string {
+	return EventTypeFeatureFlagsChanged
+}
+
+// FeatureFlags are named boolean flags, configured in the `featureFlags` map of the instance options section, that
+// handlers may consult to gate experimental behavior. Flags may be set or reloaded at runtime. Unknown flags are
+// disabled.
+type FeatureFlags struct {
+	lock   sync.Mutex
+	flags  atomic.Value
+	events EventDispatcher
+}
+
+// NewFeatureFlags returns FeatureFlags with no flags enabled. Changes are dispatched to events, which may be nil.
+func NewFeatureFlags(events EventDispatcher) *FeatureFlags {
+	featureFlags := &FeatureFlags{
+		events: events,
+	}
+	featureFlags.flags.Store(map[string]bool{})
+	return featureFlags
+}
+
+func (featureFlags *FeatureFlags) load() map[string]bool {
+	if flags, ok := featureFlags.flags.Load().(map[string]bool); ok {
+		return flags
+	}
+	return nil
+}
+
+// Enabled returns true if the named flag is enabled. A nil FeatureFlags has no flags enabled.
+func (featureFlags *FeatureFlags) Enabled(name string) bool {
+	if featureFlags == nil {
+		return false
+	}
+	return featureFlags.load()[name]
+}
+
+// Values returns a copy of all configured flags
+func (featureFlags *FeatureFlags) Values() map[string]bool {
+	result := map[string]bool{}
+	for name, enabled := range featureFlags.load() {
+		result[name] = enabled
+	}
+	return result
+}
+
+// Names returns the sorted names of all configured flags
+func (featureFlags *FeatureFlags) Names() []string {
+	var result []string
+	for name := range featureFlags.load() {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Set enables or disables a single flag
+func (featureFlags *FeatureFlags) Set(name string, enabled bool) {
+	featureFlags.lock.Lock()
+	defer featureFlags.lock.Unlock()
+
+	flags := featureFlags.Values()
+	flags[name] = enabled
+	featureFlags.store(flags)
+}
+
+// Replace replaces all flags, as when reloading configuration
+func (featureFlags *FeatureFlags) Replace(flags map[string]bool) {
+	featureFlags.lock.Lock()
+	defer featureFlags.lock.Unlock()
+
+	replacement := map[string]bool{}
+	for name, enabled := range flags {
+		replacement[name] = enabled
+	}
+	featureFlags.store(replacement)
+}
+
+// store must be called with the lock held. The map must not be modified once stored.
+func (featureFlags *FeatureFlags) store(flags map[string]bool) {
+	current := featureFlags.load()
+	featureFlags.flags.Store(flags)
+
+	changed := map[string]bool{}
+	for name, enabled := range flags {
+		if current[name] != enabled {
+			changed[name] = enabled
+		}
+	}
+	for name, enabled := range current {
+		if _, ok := flags[name]; !ok && enabled {
+			changed[name] = false
+		}
+	}
+
+	if len(changed) > 0 && featureFlags.events != nil {
+		featureFlags.events.Dispatch(&FeatureFlagsChangedEvent{Changed: changed})
+	}
+}
+
+// parseFeatureFlags parses a `featureFlags` map of flag names to booleans
+func parseFeatureFlags(value interface{}) (map[string]bool, error) {
+	flagsMap, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("could not use value for featureFlags, not a map")
+	}
+
+	result := map[string]bool{}
+	for nameInterface, enabledInterface := range flagsMap {
+		name, ok := nameInterface.(string)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("feature flag name [%v] must be a non-empty string", nameInterface)
+		}
+
+		enabled, ok := enabledInterface.(bool)
+		if !ok {
+			return nil, fmt.Errorf("could not use value for featureFlags.%s, not a boolean", name)
+		}
+
+		result[name] = enabled
+	}
+
+	return result, nil
+}
+
+// FeatureFlagsFromContext returns the FeatureFlags of the Instance serving a request or nil
+func FeatureFlagsFromContext(ctx context.Context) *FeatureFlags {
+	if serverContext := ServerContextFromRequestContext(ctx); serverContext != nil {
+		return serverContext.FeatureFlags
+	}
+	return nil
+}
+
+// FeatureEnabled returns true if the named flag is enabled for the Instance serving a request
+func FeatureEnabled(ctx context.Context, name string) bool {
+	return FeatureFlagsFromContext(ctx).Enabled(name)
+}
+
+// NewFeatureGateHandler returns a http.Handler that answers requests with http.StatusNotFound unless the named flag
+// is enabled, in which case they are served by handler
+func NewFeatureGateHandler(name string, handler gmhttp.Handler) gmhttp.Handler {
+	return gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		if !FeatureEnabled(request.Context(), name) {
+			WriteError(writer, request, gmhttp.StatusNotFound, fmt.Errorf("feature [%s] is not enabled", name), nil)
+			return
+		}
+		handler.ServeHTTP(writer, request)
+	})
+}
@@ -0,0 +1,130 @@
+package xweb
+
+import (
+	"context"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestFeatureFlags(t *testing.T) {
+	newTestFeatureFlags := func() (*FeatureFlags, chan *FeatureFlagsChangedEvent) {
+		events := NewEventDispatcher()
+		changes := make(chan *FeatureFlagsChangedEvent, 10)
+		events.AddListener(EventListenerFunc(func(event Event) {
+			changes <- event.(*FeatureFlagsChangedEvent)
+		}))
+		return NewFeatureFlags(events), changes
+	}
+
+	t.Run("flags are set and replaced", func(t *testing.T) {
+		req := require.New(t)
+		featureFlags, changes := newTestFeatureFlags()
+
+		req.False(featureFlags.Enabled("beta"))
+
+		featureFlags.Set("beta", true)
+		req.True(featureFlags.Enabled("beta"))
+		req.Equal(map[string]bool{"beta": true}, (<-changes).Changed)
+
+		featureFlags.Replace(map[string]bool{"next": true, "old": false})
+		req.False(featureFlags.Enabled("beta"))
+		req.True(featureFlags.Enabled("next"))
+		req.Equal([]string{"next", "old"}, featureFlags.Names())
+		req.Equal(map[string]bool{"beta": false, "next": true}, (<-changes).Changed)
+	})
+
+	t.Run("unchanged values dispatch no events", func(t *testing.T) {
+		req := require.New(t)
+		featureFlags, changes := newTestFeatureFlags()
+
+		featureFlags.Set("beta", false)
+		featureFlags.Replace(map[string]bool{"beta": false})
+		req.Len(changes, 0)
+	})
+
+	t.Run("values are copies", func(t *testing.T) {
+		req := require.New(t)
+		featureFlags := NewFeatureFlags(nil)
+		featureFlags.Set("beta", true)
+
+		values := featureFlags.Values()
+		values["beta"] = false
+		req.True(featureFlags.Enabled("beta"))
+	})
+
+	t.Run("nil flags are disabled", func(t *testing.T) {
+		req := require.New(t)
+		var featureFlags *FeatureFlags
+		req.False(featureFlags.Enabled("beta"))
+		req.False(FeatureEnabled(context.Background(), "beta"))
+	})
+
+	t.Run("flags are parsed from instance options", func(t *testing.T) {
+		req := require.New(t)
+		options := &InstanceOptions{}
+
+		req.NoError(options.Parse(map[interface{}]interface{}{
+			"featureFlags": map[interface{}]interface{}{"beta": true, "next": false},
+		}))
+		req.Equal(map[string]bool{"beta": true, "next": false}, options.FeatureFlags)
+
+		req.Error(options.Parse(map[interface{}]interface{}{"featureFlags": []interface{}{"beta"}}))
+		req.Error(options.Parse(map[interface{}]interface{}{"featureFlags": map[interface{}]interface{}{"beta": "yes"}}))
+	})
+
+	t.Run("instances reload flags", func(t *testing.T) {
+		req := require.New(t)
+		instance := NewDefaultInstance(NewRegistryMap(), nil)
+		instance.GetFeatureFlags().Set("old", true)
+
+		req.NoError(instance.ReloadFeatureFlags(map[interface{}]interface{}{
+			"featureFlags": map[interface{}]interface{}{"beta": true},
+		}))
+		req.True(instance.GetFeatureFlags().Enabled("beta"))
+		req.False(instance.GetFeatureFlags().Enabled("old"))
+
+		req.Error(instance.ReloadFeatureFlags(map[interface{}]interface{}{"featureFlags": "beta"}))
+		req.True(instance.GetFeatureFlags().Enabled("beta"))
+		req.Nil(instance.Config.Options.FeatureFlags)
+	})
+
+	t.Run("built instances reload flags when their config is loaded", func(t *testing.T) {
+		req := require.New(t)
+		instance := NewDefaultInstance(NewRegistryMap(), nil)
+		instance.built = true
+
+		req.NoError(instance.LoadConfig(map[interface{}]interface{}{
+			DefaultOptionsSection: map[interface{}]interface{}{
+				"featureFlags": map[interface{}]interface{}{"beta": true},
+			},
+		}))
+		req.True(instance.GetFeatureFlags().Enabled("beta"))
+
+		req.Error(instance.LoadConfig(map[interface{}]interface{}{DefaultOptionsSection: "beta"}))
+		req.True(instance.GetFeatureFlags().Enabled("beta"))
+
+		req.NoError(instance.LoadConfig(map[interface{}]interface{}{}))
+		req.False(instance.GetFeatureFlags().Enabled("beta"))
+	})
+
+	t.Run("gated handlers are served only when enabled", func(t *testing.T) {
+		req := require.New(t)
+		featureFlags := NewFeatureFlags(nil)
+		handler := NewFeatureGateHandler("beta", gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.WriteHeader(gmhttp.StatusNoContent)
+		}))
+
+		serve := func() int {
+			ctx := context.WithValue(context.Background(), ServerContextKey, &ServerContext{FeatureFlags: featureFlags})
+			recorder := gmhttptest.NewRecorder()
+			handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/beta", nil).WithContext(ctx))
+			return recorder.Code
+		}
+
+		req.Equal(gmhttp.StatusNotFound, serve())
+		featureFlags.Set("beta", true)
+		req.Equal(gmhttp.StatusNoContent, serve())
+	})
+}
@@ -0,0 +1,468 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	tlsFingerprintContextKey = ContextKey("xweb.TlsFingerprint.ContextKey")
+
+	// maxClientHelloCapture bounds the bytes buffered while waiting for a complete ClientHello, a ClientHello is at
+	// most one 64KiB handshake message spread over several records
+	maxClientHelloCapture = 72 * 1024
+
+	tlsRecordTypeHandshake      = 22
+	tlsHandshakeTypeClientHello = 1
+
+	tlsExtensionServerName          = 0x0000
+	tlsExtensionSupportedGroups     = 0x000a
+	tlsExtensionPointFormats        = 0x000b
+	tlsExtensionSignatureAlgorithms = 0x000d
+	tlsExtensionAlpn                = 0x0010
+	tlsExtensionSupportedVersions   = 0x002b
+)
+
+// FingerprintOptions enables capturing TLS client fingerprints. Fingerprints are computed from the ClientHello as it is
+// read from the connection, so that extensions are fingerprinted in the order sent, including those the TLS stack does
+// not parse. When enabled, bind points are served by sockets bound by the Server, as with more than one accept loop,
+// which are not shared with other users of the transport listener on the same address.
+type FingerprintOptions struct {
+	TlsFingerprints bool
+}
+
+// Default disables TLS client fingerprints
+func (fingerprintOptions *FingerprintOptions) Default() {
+	fingerprintOptions.TlsFingerprints = false
+}
+
+// Parse parses a config map looking for a `tlsFingerprints` boolean
+func (fingerprintOptions *FingerprintOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["tlsFingerprints"]; ok {
+		if enabled, ok := interfaceVal.(bool); ok {
+			fingerprintOptions.TlsFingerprints = enabled
+		} else {
+			return errors.New("could not use value for tlsFingerprints, not a boolean")
+		}
+	}
+
+	return nil
+}
+
+// TlsFingerprint describes the ClientHello a connection was opened with
+type TlsFingerprint struct {
+	// Ja3 is the JA3 fingerprint string: version, ciphers, extensions, curves and point formats in the order sent
+	Ja3 string `json:"ja3"`
+
+	// Ja3Hash is the MD5 hash of Ja3 as is usually logged and matched
+	Ja3Hash string `json:"ja3Hash"`
+
+	// Ja4 is the JA4 fingerprint. Clients offering only GMSSL report a version of "g1".
+	Ja4 string `json:"ja4"`
+
+	// Gm is true if the client offered GMSSL or an SM4 cipher suite
+	Gm bool `json:"gm,omitempty"`
+}
+
+// TlsFingerprintFromContext returns the TlsFingerprint of the connection a request was received on or nil if
+// fingerprints are not enabled or the ClientHello could not be parsed
+func TlsFingerprintFromContext(ctx context.Context) *TlsFingerprint {
+	if conn, ok := ctx.Value(tlsFingerprintContextKey).(*fingerprintConn); ok {
+		return conn.Fingerprint()
+	}
+	return nil
+}
+
+// withTlsFingerprint is used as part of a http.Server ConnContext to make the connection's TlsFingerprint available to
+// its requests
+func withTlsFingerprint(ctx context.Context, conn net.Conn) context.Context {
+	if tlsConn, ok := conn.(*gmtls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if fingerprinted, ok := conn.(*fingerprintConn); ok {
+		return context.WithValue(ctx, tlsFingerprintContextKey, fingerprinted)
+	}
+	return ctx
+}
+
+// fingerprintConn buffers the bytes read from a connection until they hold a complete ClientHello, which is then
+// fingerprinted and released
+type fingerprintConn struct {
+	net.Conn
+	lock        sync.Mutex
+	done        bool
+	buffer      []byte
+	fingerprint *TlsFingerprint
+}
+
+func (conn *fingerprintConn) Read(b []byte) (int, error) {
+	n, err := conn.Conn.Read(b)
+
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if !conn.done && n > 0 {
+		conn.buffer = append(conn.buffer, b[:n]...)
+		if body, complete, parseErr := clientHelloFromRecords(conn.buffer); parseErr != nil || len(conn.buffer) > maxClientHelloCapture {
+			conn.done = true
+		} else if complete {
+			conn.done = true
+			if hello, parseErr := parseClientHello(body); parseErr == nil {
+				conn.fingerprint = newTlsFingerprint(hello)
+			}
+		}
+
+		if conn.done {
+			conn.buffer = nil
+		}
+	}
+
+	return n, err
+}
+
+// Fingerprint returns the TlsFingerprint or nil if the ClientHello has not been received or could not be parsed
+func (conn *fingerprintConn) Fingerprint() *TlsFingerprint {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	return conn.fingerprint
+}
+
+// clientHelloFromRecords reassembles the first handshake message from TLS records, returning the message body once
+// complete
+func clientHelloFromRecords(data []byte) ([]byte, bool, error) {
+	var message []byte
+
+	for len(data) >= 5 {
+		if data[0] != tlsRecordTypeHandshake {
+			return nil, false, fmt.Errorf("unexpected record type %d", data[0])
+		}
+
+		length := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < 5+length {
+			break
+		}
+
+		message = append(message, data[5:5+length]...)
+		data = data[5+length:]
+
+		if len(message) >= 4 {
+			if message[0] != tlsHandshakeTypeClientHello {
+				return nil, false, fmt.Errorf("unexpected handshake type %d", message[0])
+			}
+
+			messageLength := int(message[1])<<16 | int(message[2])<<8 | int(message[3])
+			if len(message) >= 4+messageLength {
+				return message[4 : 4+messageLength], true, nil
+			}
+		}
+	}
+
+	return nil, false, nil
+}
+
+// clientHello holds the ClientHello fields used by fingerprints
+type clientHello struct {
+	version           uint16
+	cipherSuites      []uint16
+	extensions        []uint16
+	curves            []uint16
+	pointFormats      []uint8
+	signatureSchemes  []uint16
+	supportedVersions []uint16
+	alpn              []string
+	hasServerName     bool
+}
+
+// helloReader reads big endian values from a ClientHello, recording the first out of range read
+type helloReader struct {
+	data []byte
+	err  error
+}
+
+func (reader *helloReader) bytes(n int) []byte {
+	if reader.err != nil || n > len(reader.data) {
+		reader.err = errors.New("truncated ClientHello")
+		return nil
+	}
+	result := reader.data[:n]
+	reader.data = reader.data[n:]
+	return result
+}
+
+func (reader *helloReader) uint8() uint8 {
+	if b := reader.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (reader *helloReader) uint16() uint16 {
+	if b := reader.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+// vector returns the contents of a vector with a lengthBytes sized length prefix
+func (reader *helloReader) vector(lengthBytes int) *helloReader {
+	var length int
+	if lengthBytes == 1 {
+		length = int(reader.uint8())
+	} else {
+		length = int(reader.uint16())
+	}
+	return &helloReader{data: reader.bytes(length), err: reader.err}
+}
+
+func (reader *helloReader) uint16s() []uint16 {
+	var result []uint16
+	for len(reader.data) >= 2 && reader.err == nil {
+		result = append(result, reader.uint16())
+	}
+	return result
+}
+
+// parseClientHello parses a ClientHello handshake message body, keeping every extension in the order sent
+func parseClientHello(body []byte) (*clientHello, error) {
+	reader := &helloReader{data: body}
+	hello := &clientHello{}
+
+	hello.version = reader.uint16()
+	reader.bytes(32)
+	reader.vector(1)
+	hello.cipherSuites = reader.vector(2).uint16s()
+	reader.vector(1)
+
+	if reader.err == nil && len(reader.data) > 0 {
+		extensions := reader.vector(2)
+		for len(extensions.data) > 0 && extensions.err == nil {
+			extensionType := extensions.uint16()
+			data := extensions.vector(2)
+			if extensions.err != nil {
+				break
+			}
+
+			hello.extensions = append(hello.extensions, extensionType)
+
+			switch extensionType {
+			case tlsExtensionServerName:
+				hello.hasServerName = true
+			case tlsExtensionSupportedGroups:
+				hello.curves = data.vector(2).uint16s()
+			case tlsExtensionPointFormats:
+				hello.pointFormats = data.vector(1).data
+			case tlsExtensionSignatureAlgorithms:
+				hello.signatureSchemes = data.vector(2).uint16s()
+			case tlsExtensionAlpn:
+				protocols := data.vector(2)
+				for len(protocols.data) > 0 && protocols.err == nil {
+					hello.alpn = append(hello.alpn, string(protocols.vector(1).data))
+				}
+			case tlsExtensionSupportedVersions:
+				hello.supportedVersions = data.vector(1).uint16s()
+			}
+		}
+		reader.err = extensions.err
+	}
+
+	if reader.err != nil {
+		return nil, reader.err
+	}
+
+	return hello, nil
+}
+
+// newTlsFingerprint computes the TlsFingerprint of a ClientHello
+func newTlsFingerprint(hello *clientHello) *TlsFingerprint {
+	ja3 := hello.ja3()
+	ja3Hash := md5.Sum([]byte(ja3))
+
+	return &TlsFingerprint{
+		Ja3:     ja3,
+		Ja3Hash: hex.EncodeToString(ja3Hash[:]),
+		Ja4:     hello.ja4(),
+		Gm:      hello.isGm(),
+	}
+}
+
+// isGrease returns true for the reserved GREASE values of RFC 8701, which are excluded from fingerprints
+func isGrease(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+func withoutGrease(values []uint16) []uint16 {
+	result := make([]uint16, 0, len(values))
+	for _, value := range values {
+		if !isGrease(value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+func joinDecimal(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = strconv.Itoa(int(value))
+	}
+	return strings.Join(parts, "-")
+}
+
+func joinHex(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = fmt.Sprintf("%04x", value)
+	}
+	return strings.Join(parts, ",")
+}
+
+func ja4Hash(value string) string {
+	if value == "" {
+		return "000000000000"
+	}
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+// ja3 renders the JA3 string: SSLVersion,Ciphers,Extensions,EllipticCurves,EllipticCurvePointFormats
+func (hello *clientHello) ja3() string {
+	pointFormats := make([]string, len(hello.pointFormats))
+	for i, pointFormat := range hello.pointFormats {
+		pointFormats[i] = strconv.Itoa(int(pointFormat))
+	}
+
+	return strings.Join([]string{
+		strconv.Itoa(int(hello.version)),
+		joinDecimal(withoutGrease(hello.cipherSuites)),
+		joinDecimal(withoutGrease(hello.extensions)),
+		joinDecimal(withoutGrease(hello.curves)),
+		strings.Join(pointFormats, "-"),
+	}, ",")
+}
+
+// ja4 renders the JA4 fingerprint for a TLS over TCP ClientHello
+func (hello *clientHello) ja4() string {
+	version := hello.version
+	if supportedVersions := withoutGrease(hello.supportedVersions); len(supportedVersions) > 0 {
+		version = supportedVersions[0]
+		for _, supportedVersion := range supportedVersions {
+			if supportedVersion != gmtls.VersionGMSSL && (supportedVersion > version || version == gmtls.VersionGMSSL) {
+				version = supportedVersion
+			}
+		}
+	}
+
+	serverName := "i"
+	if hello.hasServerName {
+		serverName = "d"
+	}
+
+	ciphers := withoutGrease(hello.cipherSuites)
+	extensions := withoutGrease(hello.extensions)
+
+	prefix := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(version), serverName, min99(len(ciphers)), min99(len(extensions)), ja4Alpn(hello.alpn))
+
+	sortedCiphers := append([]uint16(nil), ciphers...)
+	sort.Slice(sortedCiphers, func(i, j int) bool { return sortedCiphers[i] < sortedCiphers[j] })
+
+	var sortedExtensions []uint16
+	for _, extension := range extensions {
+		if extension != tlsExtensionServerName && extension != tlsExtensionAlpn {
+			sortedExtensions = append(sortedExtensions, extension)
+		}
+	}
+	sort.Slice(sortedExtensions, func(i, j int) bool { return sortedExtensions[i] < sortedExtensions[j] })
+
+	extensionStr := joinHex(sortedExtensions)
+	if signatureSchemes := withoutGrease(hello.signatureSchemes); len(signatureSchemes) > 0 && extensionStr != "" {
+		extensionStr += "_" + joinHex(signatureSchemes)
+	}
+
+	return prefix + "_" + ja4Hash(joinHex(sortedCiphers)) + "_" + ja4Hash(extensionStr)
+}
+
+// isGm returns true if the client offered GMSSL or an SM4 cipher suite
+func (hello *clientHello) isGm() bool {
+	for _, version := range append([]uint16{hello.version}, hello.supportedVersions...) {
+		if version == gmtls.VersionGMSSL {
+			return true
+		}
+	}
+	for _, cipherSuite := range hello.cipherSuites {
+		if cipherSuite == gmtls.TLS_SM4_GCM_SM3 {
+			return true
+		}
+	}
+	return false
+}
+
+func ja4Version(version uint16) string {
+	switch version {
+	case gmtls.VersionTLS13:
+		return "13"
+	case gmtls.VersionTLS12:
+		return "12"
+	case gmtls.VersionTLS11:
+		return "11"
+	case gmtls.VersionTLS10:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case gmtls.VersionGMSSL:
+		return "g1"
+	}
+	return "00"
+}
+
+// ja4Alpn renders the first and last characters of the first ALPN protocol, or their hex digits if either is not
+// alphanumeric
+func ja4Alpn(alpn []string) string {
+	if len(alpn) == 0 || alpn[0] == "" {
+		return "00"
+	}
+
+	first, last := alpn[0][0], alpn[0][len(alpn[0])-1]
+	if isAlphanumeric(first) && isAlphanumeric(last) {
+		return string([]byte{first, last})
+	}
+
+	return fmt.Sprintf("%02x", first)[:1] + fmt.Sprintf("%02x", last)[1:]
+}
+
+func isAlphanumeric(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func min99(count int) int {
+	if count > 99 {
+		return 99
+	}
+	return count
+}
@@ -0,0 +1,209 @@
+package xweb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strings"
+	"testing"
+)
+
+func u16(b []byte, value uint16) []byte {
+	return binary.BigEndian.AppendUint16(b, value)
+}
+
+func vector16(b []byte, contents []byte) []byte {
+	return append(u16(b, uint16(len(contents))), contents...)
+}
+
+func u16s(values ...uint16) []byte {
+	var b []byte
+	for _, value := range values {
+		b = u16(b, value)
+	}
+	return b
+}
+
+func extension(b []byte, extensionType uint16, data []byte) []byte {
+	return vector16(u16(b, extensionType), data)
+}
+
+// testClientHello describes the fields of a ClientHello body built by body
+type testClientHello struct {
+	cipherSuites []uint16
+	extensions   []byte
+}
+
+// newTestClientHello returns a ClientHello with GREASE values, SNI, ALPN and GM options
+func newTestClientHello() *testClientHello {
+	var extensions []byte
+	extensions = extension(extensions, 0x1a1a, nil)
+	extensions = extension(extensions, tlsExtensionServerName, vector16(nil, append([]byte{0}, vector16(nil, []byte("localhost"))...)))
+	extensions = extension(extensions, tlsExtensionSupportedGroups, vector16(nil, u16s(0x2a2a, 29, 26)))
+	extensions = extension(extensions, tlsExtensionPointFormats, []byte{1, 0})
+	extensions = extension(extensions, tlsExtensionSignatureAlgorithms, vector16(nil, u16s(0x0403, 0x0708)))
+	extensions = extension(extensions, tlsExtensionAlpn, vector16(nil, append([]byte("\x02h2"), []byte("\x08http/1.1")...)))
+	versions := u16s(0x0a0a, gmtls.VersionTLS13, gmtls.VersionTLS12, gmtls.VersionGMSSL)
+	extensions = extension(extensions, tlsExtensionSupportedVersions, append([]byte{byte(len(versions))}, versions...))
+
+	return &testClientHello{
+		cipherSuites: []uint16{0x0a0a, 0x1301, 0x00c6, 0xc02b},
+		extensions:   extensions,
+	}
+}
+
+// body returns the ClientHello handshake message body
+func (hello *testClientHello) body() []byte {
+	b := u16(nil, gmtls.VersionTLS12)
+	b = append(b, make([]byte, 32)...)
+	b = append(b, 0)
+	b = vector16(b, u16s(hello.cipherSuites...))
+	b = append(b, 1, 0)
+	return vector16(b, hello.extensions)
+}
+
+// message returns the ClientHello as a handshake message
+func (hello *testClientHello) message() []byte {
+	body := hello.body()
+	return append([]byte{tlsHandshakeTypeClientHello, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+func tlsRecord(fragment []byte) []byte {
+	return vector16([]byte{tlsRecordTypeHandshake, 3, 1}, fragment)
+}
+
+func fingerprintBody(req *require.Assertions, body []byte) *TlsFingerprint {
+	hello, err := parseClientHello(body)
+	req.NoError(err)
+	return newTlsFingerprint(hello)
+}
+
+func sha256Prefix(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+func TestTlsFingerprint(t *testing.T) {
+	t.Run("fingerprints exclude GREASE values", func(t *testing.T) {
+		req := require.New(t)
+
+		fingerprint := fingerprintBody(req, newTestClientHello().body())
+
+		req.Equal("771,4865-198-49195,0-10-11-13-16-43,29-26,0", fingerprint.Ja3)
+		req.Len(fingerprint.Ja3Hash, 32)
+		req.Equal("t13d0306h2_"+sha256Prefix("00c6,1301,c02b")+"_"+sha256Prefix("000a,000b,000d,002b_0403,0708"), fingerprint.Ja4)
+		req.True(fingerprint.Gm)
+	})
+
+	t.Run("extensions keep their wire order and include those gmtls does not parse", func(t *testing.T) {
+		req := require.New(t)
+		hello := newTestClientHello()
+		var extensions []byte
+		extensions = extension(extensions, 0x0033, vector16(nil, nil))
+		extensions = extension(extensions, 0x0017, nil)
+		hello.extensions = append(extensions, hello.extensions...)
+
+		fingerprint := fingerprintBody(req, hello.body())
+		req.Equal("771,4865-198-49195,51-23-0-10-11-13-16-43,29-26,0", fingerprint.Ja3)
+		req.Equal("t13d0308h2_"+sha256Prefix("00c6,1301,c02b")+"_"+sha256Prefix("000a,000b,000d,0017,002b,0033_0403,0708"), fingerprint.Ja4)
+	})
+
+	t.Run("ClientHellos without supported versions use their legacy version", func(t *testing.T) {
+		req := require.New(t)
+		hello := &testClientHello{cipherSuites: []uint16{0xc02b}}
+		hello.extensions = extension(nil, tlsExtensionSupportedGroups, vector16(nil, u16s(29)))
+
+		fingerprint := fingerprintBody(req, hello.body())
+		req.Equal("771,49195,10,29,", fingerprint.Ja3)
+		req.True(strings.HasPrefix(fingerprint.Ja4, "t12i010100_"), fingerprint.Ja4)
+		req.False(fingerprint.Gm)
+	})
+
+	t.Run("ClientHellos are reassembled across records", func(t *testing.T) {
+		req := require.New(t)
+		message := newTestClientHello().message()
+
+		data := tlsRecord(message[:10])
+		_, complete, err := clientHelloFromRecords(data)
+		req.NoError(err)
+		req.False(complete)
+
+		data = append(data, tlsRecord(message[10:])...)
+		_, complete, err = clientHelloFromRecords(data[:len(data)-1])
+		req.NoError(err)
+		req.False(complete)
+
+		body, complete, err := clientHelloFromRecords(data)
+		req.NoError(err)
+		req.True(complete)
+		req.Equal(newTestClientHello().body(), body)
+	})
+
+	t.Run("records that are not handshakes are rejected", func(t *testing.T) {
+		req := require.New(t)
+		data := tlsRecord(newTestClientHello().message())
+		data[0] = 23
+		_, _, err := clientHelloFromRecords(data)
+		req.Error(err)
+	})
+
+	t.Run("truncated ClientHellos are rejected", func(t *testing.T) {
+		req := require.New(t)
+		body := newTestClientHello().body()
+		_, err := parseClientHello(body[:len(body)-3])
+		req.Error(err)
+	})
+
+	t.Run("ALPN values that are not alphanumeric are rendered as hex", func(t *testing.T) {
+		req := require.New(t)
+		req.Equal("00", ja4Alpn(nil))
+		req.Equal("h1", ja4Alpn([]string{"http/1.1"}))
+		req.Equal("09", ja4Alpn([]string{"\x00\xf9"}))
+	})
+
+	t.Run("fingerprints are captured from accepted connections", func(t *testing.T) {
+		req := require.New(t)
+
+		socket, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+
+		tlsConfig := newTestServerTlsConfig(req)
+		tlsConfig.NextProtos = []string{"h2"}
+		listener := newTlsListener(socket, tlsConfig, true)
+		defer func() { _ = listener.Close() }()
+
+		captured := make(chan *TlsFingerprint, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				captured <- nil
+				return
+			}
+			defer func() { _ = conn.Close() }()
+			captured <- TlsFingerprintFromContext(withTlsFingerprint(context.Background(), conn))
+		}()
+
+		conn, err := gmtls.Dial("tcp", listener.Addr().String(), &gmtls.Config{
+			ServerName:         "localhost",
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h2"},
+		})
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+
+		fingerprint := <-captured
+		req.NotNil(fingerprint)
+		req.True(strings.HasPrefix(fingerprint.Ja4, "t13d"), fingerprint.Ja4)
+		req.True(strings.HasSuffix(strings.Split(fingerprint.Ja4, "_")[0], "h2"), fingerprint.Ja4)
+		req.True(strings.HasPrefix(fingerprint.Ja3, "771,"), fingerprint.Ja3)
+	})
+
+	t.Run("contexts without fingerprints return nil", func(t *testing.T) {
+		req := require.New(t)
+		req.Nil(TlsFingerprintFromContext(context.Background()))
+	})
+}
@@ -0,0 +1,736 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/xweb/v2/metrics"
+	"github.com/openziti/xweb/v2/ratelimit"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	EventTypeHandshakeBan = "xweb.handshake.ban"
+
+	MetricHandshakeRejected = "xweb.handshake.rejected"
+	MetricHandshakeFailures = "xweb.handshake.failures"
+	MetricHandshakeBans     = "xweb.handshake.bans"
+
+	MetricHandshakeBackendErrors = "xweb.handshake.backend.errors"
+
+	DefaultHandshakePerIpRate     = 10
+	DefaultHandshakePerIpBurst    = 20
+	DefaultHandshakeMaxFailures   = 10
+	DefaultHandshakeFailureWindow = time.Minute
+	DefaultHandshakeBanDuration   = 5 * time.Minute
+	DefaultHandshakeTimeout       = 10 * time.Second
+
+	// handshakeSweepInterval is how often idle per IP state is discarded
+	handshakeSweepInterval = time.Minute
+
+	// handshakeIpv6PrefixLength is the prefix length IPv6 clients are aggregated by
+	handshakeIpv6PrefixLength = 64
+
+	// handshakeBackendMinBackoff and handshakeBackendMaxBackoff bound how long a failed backend is skipped before
+	// it is tried again, the backoff doubles with each consecutive failure
+	handshakeBackendMinBackoff = time.Second
+	handshakeBackendMaxBackoff = 30 * time.Second
+)
+
+var (
+	errHandshakeBanned      = errors.New("client address is temporarily banned after repeated handshake failures")
+	errHandshakeRateLimited = errors.New("client address exceeded the handshake rate limit")
+	errHandshakeOverloaded  = errors.New("server exceeded the global handshake rate limit")
+)
+
+// HandshakeLimitOptions represents the options for limiting the rate of TLS handshakes, per client IP and in total,
+// and for temporarily banning client IPs that repeatedly fail to complete handshakes. Limits are enforced when the
+// ClientHello is received, before any certificate or key exchange operations are performed, so that junk clients
+// cannot consume CPU with SM2/ECDSA operations.
+//
+// A handshake is considered failed if it ends with an error or has not completed HandshakeTimeout after its
+// ClientHello. Completion is observed when the server verifies the connection, which for TLS 1.3 without requested
+// client certificates is after the server has sent its flight. As clientAuth defaults to requesting client
+// certificates, completion normally requires the client to respond.
+//
+// IPv4 clients are limited per address, IPv6 clients per /64 network, as a single host is commonly assigned a whole
+// /64 and could otherwise evade the per IP limits and bans by changing its address.
+type HandshakeLimitOptions struct {
+	HandshakeLimitsEnabled bool
+
+	// HandshakePerIpRate is the sustained number of handshakes per second allowed for a single client IP, zero is
+	// unlimited
+	HandshakePerIpRate  float64
+	HandshakePerIpBurst int
+
+	// HandshakeGlobalRate is the sustained number of handshakes per second allowed for all clients, zero is unlimited
+	HandshakeGlobalRate  float64
+	HandshakeGlobalBurst int
+
+	// HandshakeMaxFailures is the number of failed handshakes within HandshakeFailureWindow that causes a client IP
+	// to be banned for HandshakeBanDuration, zero disables banning
+	HandshakeMaxFailures   int
+	HandshakeFailureWindow time.Duration
+	HandshakeBanDuration   time.Duration
+	HandshakeTimeout       time.Duration
+
+	// HandshakeExempt is a list of networks that are never limited, e.g. health checkers and load balancers
+	HandshakeExempt []*net.IPNet
+
+	// HandshakeBackend holds the per IP and global rate buckets. The default memory backend limits each instance
+	// separately, a shared backend such as redis enforces the rates across all instances. Handshakes are not limited
+	// by rate if the backend fails, and a failed backend is skipped with an increasing backoff so that handshakes do
+	// not wait on it while it is down. Failures and bans are always tracked per instance.
+	HandshakeBackend ratelimit.BackendOptions
+}
+
+// Default provides defaults for all necessary values
+func (options *HandshakeLimitOptions) Default() {
+	options.HandshakeLimitsEnabled = false
+	options.HandshakePerIpRate = DefaultHandshakePerIpRate
+	options.HandshakePerIpBurst = DefaultHandshakePerIpBurst
+	options.HandshakeGlobalRate = 0
+	options.HandshakeGlobalBurst = 0
+	options.HandshakeMaxFailures = DefaultHandshakeMaxFailures
+	options.HandshakeFailureWindow = DefaultHandshakeFailureWindow
+	options.HandshakeBanDuration = DefaultHandshakeBanDuration
+	options.HandshakeTimeout = DefaultHandshakeTimeout
+	options.HandshakeExempt = nil
+	options.HandshakeBackend.Default()
+}
+
+// Parse parses a configuration map
+func (options *HandshakeLimitOptions) Parse(config map[interface{}]interface{}) error {
+	limitsInterface, ok := config["handshakeLimits"]
+
+	if !ok {
+		return nil
+	}
+
+	limitsMap, ok := limitsInterface.(map[interface{}]interface{})
+
+	if !ok {
+		return errors.New("could not use value for handshakeLimits, not a map")
+	}
+
+	options.HandshakeLimitsEnabled = true
+
+	if interfaceVal, ok := limitsMap["enabled"]; ok {
+		if enabled, ok := interfaceVal.(bool); ok {
+			options.HandshakeLimitsEnabled = enabled
+		} else {
+			return errors.New("could not use value for handshakeLimits.enabled, not a boolean")
+		}
+	}
+
+	if err := parseHandshakeRate(limitsMap, "perIpRate", &options.HandshakePerIpRate); err != nil {
+		return err
+	}
+
+	if err := parseHandshakeInt(limitsMap, "perIpBurst", &options.HandshakePerIpBurst); err != nil {
+		return err
+	}
+
+	if err := parseHandshakeRate(limitsMap, "globalRate", &options.HandshakeGlobalRate); err != nil {
+		return err
+	}
+
+	if err := parseHandshakeInt(limitsMap, "globalBurst", &options.HandshakeGlobalBurst); err != nil {
+		return err
+	}
+
+	if err := parseHandshakeInt(limitsMap, "maxFailures", &options.HandshakeMaxFailures); err != nil {
+		return err
+	}
+
+	if err := parseHandshakeDuration(limitsMap, "failureWindow", &options.HandshakeFailureWindow); err != nil {
+		return err
+	}
+
+	if err := parseHandshakeDuration(limitsMap, "banDuration", &options.HandshakeBanDuration); err != nil {
+		return err
+	}
+
+	if err := parseHandshakeDuration(limitsMap, "timeout", &options.HandshakeTimeout); err != nil {
+		return err
+	}
+
+	if interfaceVal, ok := limitsMap["exempt"]; ok {
+		exemptList, ok := interfaceVal.([]interface{})
+		if !ok {
+			return errors.New("could not use value for handshakeLimits.exempt, not an array")
+		}
+
+		for _, exemptInterface := range exemptList {
+			exemptStr, ok := exemptInterface.(string)
+			if !ok {
+				return errors.New("could not use value for handshakeLimits.exempt, entries must be strings")
+			}
+
+			network, err := parseHandshakeNetwork(exemptStr)
+			if err != nil {
+				return fmt.Errorf("could not parse handshakeLimits.exempt entry [%s] as an IP or CIDR: %v", exemptStr, err)
+			}
+			options.HandshakeExempt = append(options.HandshakeExempt, network)
+		}
+	}
+
+	if interfaceVal, ok := limitsMap["backend"]; ok {
+		backendMap, ok := interfaceVal.(map[interface{}]interface{})
+		if !ok {
+			return errors.New("could not use value for handshakeLimits.backend, not a map")
+		}
+
+		if err := options.HandshakeBackend.Parse(backendMap); err != nil {
+			return fmt.Errorf("could not parse handshakeLimits.backend: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (options *HandshakeLimitOptions) Validate() error {
+	if !options.HandshakeLimitsEnabled {
+		return nil
+	}
+
+	if options.HandshakePerIpRate < 0 {
+		return fmt.Errorf("value [%v] for handshakeLimits.perIpRate too low, must be zero (unlimited) or positive", options.HandshakePerIpRate)
+	}
+
+	if options.HandshakePerIpRate > 0 && options.HandshakePerIpBurst < 1 {
+		return fmt.Errorf("value [%d] for handshakeLimits.perIpBurst too low, must be at least 1", options.HandshakePerIpBurst)
+	}
+
+	if options.HandshakeGlobalRate < 0 {
+		return fmt.Errorf("value [%v] for handshakeLimits.globalRate too low, must be zero (unlimited) or positive", options.HandshakeGlobalRate)
+	}
+
+	if options.HandshakeGlobalRate > 0 && options.HandshakeGlobalBurst < 1 {
+		return fmt.Errorf("value [%d] for handshakeLimits.globalBurst too low, must be at least 1", options.HandshakeGlobalBurst)
+	}
+
+	if options.HandshakeMaxFailures < 0 {
+		return fmt.Errorf("value [%d] for handshakeLimits.maxFailures too low, must be zero (no bans) or positive", options.HandshakeMaxFailures)
+	}
+
+	if options.HandshakeMaxFailures > 0 {
+		if options.HandshakeFailureWindow <= 0 {
+			return fmt.Errorf("value [%s] for handshakeLimits.failureWindow too low, must be positive", options.HandshakeFailureWindow)
+		}
+
+		if options.HandshakeBanDuration <= 0 {
+			return fmt.Errorf("value [%s] for handshakeLimits.banDuration too low, must be positive", options.HandshakeBanDuration)
+		}
+	}
+
+	if options.HandshakeTimeout <= 0 {
+		return fmt.Errorf("value [%s] for handshakeLimits.timeout too low, must be positive", options.HandshakeTimeout)
+	}
+
+	if err := options.HandshakeBackend.Validate(); err != nil {
+		return fmt.Errorf("invalid handshakeLimits.backend: %v", err)
+	}
+
+	return nil
+}
+
+func (options *HandshakeLimitOptions) isExempt(ip net.IP) bool {
+	for _, network := range options.HandshakeExempt {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHandshakeRate(config map[interface{}]interface{}, field string, target *float64) error {
+	if interfaceVal, ok := config[field]; ok {
+		switch val := interfaceVal.(type) {
+		case int:
+			*target = float64(val)
+		case float64:
+			*target = val
+		default:
+			return fmt.Errorf("could not use value for handshakeLimits.%s, not a number", field)
+		}
+	}
+	return nil
+}
+
+func parseHandshakeInt(config map[interface{}]interface{}, field string, target *int) error {
+	if interfaceVal, ok := config[field]; ok {
+		if val, ok := interfaceVal.(int); ok {
+			*target = val
+		} else {
+			return fmt.Errorf("could not use value for handshakeLimits.%s, not an integer", field)
+		}
+	}
+	return nil
+}
+
+func parseHandshakeDuration(config map[interface{}]interface{}, field string, target *time.Duration) error {
+	if interfaceVal, ok := config[field]; ok {
+		if durationStr, ok := interfaceVal.(string); ok {
+			if duration, err := time.ParseDuration(durationStr); err == nil {
+				*target = duration
+			} else {
+				return fmt.Errorf("could not parse handshakeLimits.%s %s as a duration (e.g. 1m): %v", field, durationStr, err)
+			}
+		} else {
+			return fmt.Errorf("could not use value for handshakeLimits.%s, not a string", field)
+		}
+	}
+	return nil
+}
+
+// parseHandshakeNetwork parses a CIDR or a single IP address as a network
+func parseHandshakeNetwork(value string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(value); err == nil {
+		return network, nil
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, errors.New("invalid address")
+	}
+
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return &net.IPNet{IP: ipv4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// HandshakeBanEvent is dispatched when a client IP is banned after repeated handshake failures. For IPv6 clients, Ip
+// is the banned /64 network.
+type HandshakeBanEvent struct {
+	Server   string    `json:"server"`
+	Ip       string    `json:"ip"`
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+}
+
+func (event *HandshakeBanEvent) EventType() string {
+	return EventTypeHandshakeBan
+}
+
+// handshakeIpState is the handshake history of a single client IP
+type handshakeIpState struct {
+	pending     map[uint64]time.Time
+	failures    []time.Time
+	bannedUntil time.Time
+	lastSeen    time.Time
+}
+
+// handshakeLimiter enforces HandshakeLimitOptions for a single ServerConfig across all of its bind points
+type handshakeLimiter struct {
+	options    *HandshakeLimitOptions
+	serverName string
+	events     EventDispatcher
+	now        func() time.Time
+
+	backend   ratelimit.Backend
+	keyPrefix string
+
+	lock      sync.Mutex
+	ips       map[string]*handshakeIpState
+	nextId    uint64
+	lastSweep time.Time
+
+	// backendFailures counts consecutive backend failures, while it is not zero the backend is skipped until
+	// backendRetryAt and then probed by a single call
+	backendFailures int
+	backendRetryAt  time.Time
+	backendProbing  bool
+
+	rejectedBanned  metrics.Counter
+	rejectedRate    metrics.Counter
+	rejectedOverall metrics.Counter
+	failures        metrics.Counter
+	bans            metrics.Counter
+	backendErrors   metrics.Counter
+}
+
+func newHandshakeLimiter(options *HandshakeLimitOptions, serverName string, registry metrics.Registry, events EventDispatcher) *handshakeLimiter {
+	rejectedLabels := func(reason string) metrics.Labels {
+		return metrics.Labels{"server": serverName, "reason": reason}
+	}
+	labels := metrics.Labels{"server": serverName}
+
+	limiter := &handshakeLimiter{
+		options:         options,
+		serverName:      serverName,
+		events:          events,
+		now:             time.Now,
+		backend:         options.HandshakeBackend.NewBackend(),
+		keyPrefix:       "handshake:" + serverName + ":",
+		ips:             map[string]*handshakeIpState{},
+		rejectedBanned:  registry.Counter(MetricHandshakeRejected, rejectedLabels("banned")),
+		rejectedRate:    registry.Counter(MetricHandshakeRejected, rejectedLabels("perIpRate")),
+		rejectedOverall: registry.Counter(MetricHandshakeRejected, rejectedLabels("globalRate")),
+		failures:        registry.Counter(MetricHandshakeFailures, labels),
+		bans:            registry.Counter(MetricHandshakeBans, labels),
+		backendErrors:   registry.Counter(MetricHandshakeBackendErrors, labels),
+	}
+
+	// memory buckets follow the limiter's clock
+	if memoryBackend, ok := limiter.backend.(*ratelimit.MemoryBackend); ok && options.HandshakeBackend.Backend == nil {
+		memoryBackend.Now = func() time.Time {
+			return limiter.now()
+		}
+	}
+
+	return limiter
+}
+
+// begin records a handshake for the client IP, returning an id to pass to complete or an error if the handshake must
+// be rejected. Rates are checked without holding the lock as the backend may be remote.
+func (limiter *handshakeLimiter) begin(ip string) (uint64, error) {
+	var banEvent *HandshakeBanEvent
+
+	err := func() error {
+		limiter.lock.Lock()
+		defer limiter.lock.Unlock()
+
+		now := limiter.now()
+		limiter.sweep(now)
+
+		state, ok := limiter.ips[ip]
+		if !ok {
+			state = &handshakeIpState{pending: map[uint64]time.Time{}}
+			limiter.ips[ip] = state
+		}
+		state.lastSeen = now
+
+		banEvent = limiter.recordFailures(ip, state, now)
+
+		if now.Before(state.bannedUntil) {
+			limiter.rejectedBanned.Inc(1)
+			return errHandshakeBanned
+		}
+
+		return nil
+	}()
+
+	limiter.dispatchBan(banEvent)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if limiter.options.HandshakePerIpRate > 0 && !limiter.take("ip:"+ip, limiter.options.HandshakePerIpRate, limiter.options.HandshakePerIpBurst) {
+		limiter.rejectedRate.Inc(1)
+		return 0, errHandshakeRateLimited
+	}
+
+	if limiter.options.HandshakeGlobalRate > 0 && !limiter.take("global", limiter.options.HandshakeGlobalRate, limiter.options.HandshakeGlobalBurst) {
+		limiter.rejectedOverall.Inc(1)
+		return 0, errHandshakeOverloaded
+	}
+
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	state, ok := limiter.ips[ip]
+	if !ok {
+		state = &handshakeIpState{pending: map[uint64]time.Time{}, lastSeen: limiter.now()}
+		limiter.ips[ip] = state
+	}
+
+	limiter.nextId++
+	state.pending[limiter.nextId] = limiter.now()
+
+	return limiter.nextId, nil
+}
+
+// take takes a token from a backend bucket, allowing the handshake if the backend fails or is being skipped after
+// failing
+func (limiter *handshakeLimiter) take(key string, rate float64, burst int) bool {
+	if !limiter.backendAllowed() {
+		return true
+	}
+
+	allowed, err := limiter.backend.Take(limiter.keyPrefix+key, rate, burst)
+	limiter.backendResult(err)
+
+	if err != nil {
+		limiter.backendErrors.Inc(1)
+		pfxlog.Logger().WithField("server", limiter.serverName).WithError(err).Warn("handshake rate limit backend failed, handshake not limited by rate")
+		return true
+	}
+	return allowed
+}
+
+// backendAllowed returns true if the backend may be called. After a failure the backend is skipped until its backoff
+// has passed, then a single call probes it.
+func (limiter *handshakeLimiter) backendAllowed() bool {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	if limiter.backendFailures == 0 {
+		return true
+	}
+
+	if limiter.backendProbing || limiter.now().Before(limiter.backendRetryAt) {
+		return false
+	}
+
+	limiter.backendProbing = true
+	return true
+}
+
+// backendResult records the outcome of a backend call, backing off after failures
+func (limiter *handshakeLimiter) backendResult(err error) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	limiter.backendProbing = false
+
+	if err == nil {
+		limiter.backendFailures = 0
+		return
+	}
+
+	backoff := handshakeBackendMaxBackoff
+	if limiter.backendFailures < 5 {
+		backoff = handshakeBackendMinBackoff << limiter.backendFailures
+	}
+
+	limiter.backendFailures++
+	limiter.backendRetryAt = limiter.now().Add(backoff)
+}
+
+// close closes the backend if it was created by the limiter and holds resources, such as connections
+func (limiter *handshakeLimiter) close() error {
+	if closer, ok := limiter.backend.(io.Closer); ok && limiter.options.HandshakeBackend.Backend == nil {
+		return closer.Close()
+	}
+	return nil
+}
+
+// complete marks a handshake started by begin as completed
+func (limiter *handshakeLimiter) complete(ip string, id uint64) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	if state, ok := limiter.ips[ip]; ok {
+		delete(state.pending, id)
+	}
+}
+
+// fail counts a handshake started by begin as failed, unless it has already completed or been counted as failed
+func (limiter *handshakeLimiter) fail(ip string, id uint64) {
+	banEvent := func() *HandshakeBanEvent {
+		limiter.lock.Lock()
+		defer limiter.lock.Unlock()
+
+		state, ok := limiter.ips[ip]
+		if !ok {
+			return nil
+		}
+
+		if _, pending := state.pending[id]; !pending {
+			return nil
+		}
+
+		now := limiter.now()
+		delete(state.pending, id)
+		state.failures = append(state.failures, now)
+		limiter.failures.Inc(1)
+
+		return limiter.recordFailures(ip, state, now)
+	}()
+
+	limiter.dispatchBan(banEvent)
+}
+
+// watch counts the handshake as failed if it ends without completing. The handshake context is done as soon as the
+// handshake returns, by which point a completed handshake has been verified.
+func (limiter *handshakeLimiter) watch(ctx context.Context, ip string, id uint64) {
+	<-ctx.Done()
+	limiter.fail(ip, id)
+}
+
+// dispatchBan logs and dispatches a ban returned by recordFailures, if any. Must be called without the lock held.
+func (limiter *handshakeLimiter) dispatchBan(event *HandshakeBanEvent) {
+	if event == nil {
+		return
+	}
+
+	pfxlog.Logger().WithField("server", event.Server).WithField("ip", event.Ip).
+		Warnf("banning client ip until %s after %d failed tls handshakes", event.Until.Format(time.RFC3339), event.Failures)
+
+	if limiter.events != nil {
+		limiter.events.Dispatch(event)
+	}
+}
+
+// recordFailures counts pending handshakes that have timed out as failures and bans the IP if it has failed too often
+// within the failure window. A HandshakeBanEvent is returned if a ban was issued. Must be called with the lock held.
+func (limiter *handshakeLimiter) recordFailures(ip string, state *handshakeIpState, now time.Time) *HandshakeBanEvent {
+	for id, started := range state.pending {
+		if now.Sub(started) >= limiter.options.HandshakeTimeout {
+			delete(state.pending, id)
+			state.failures = append(state.failures, now)
+			limiter.failures.Inc(1)
+		}
+	}
+
+	if limiter.options.HandshakeMaxFailures == 0 {
+		state.failures = nil
+		return nil
+	}
+
+	windowStart := now.Add(-limiter.options.HandshakeFailureWindow)
+	retained := state.failures[:0]
+	for _, failedAt := range state.failures {
+		if failedAt.After(windowStart) {
+			retained = append(retained, failedAt)
+		}
+	}
+	state.failures = retained
+
+	if len(state.failures) < limiter.options.HandshakeMaxFailures || now.Before(state.bannedUntil) {
+		return nil
+	}
+
+	state.bannedUntil = now.Add(limiter.options.HandshakeBanDuration)
+	limiter.bans.Inc(1)
+
+	event := &HandshakeBanEvent{
+		Server:   limiter.serverName,
+		Ip:       ip,
+		Failures: len(state.failures),
+		Until:    state.bannedUntil,
+	}
+	state.failures = nil
+
+	return event
+}
+
+// sweep discards the state of IPs that are not banned and have not attempted a handshake recently enough to affect
+// bans. Must be called with the lock held.
+func (limiter *handshakeLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < handshakeSweepInterval {
+		return
+	}
+	limiter.lastSweep = now
+
+	retention := limiter.options.HandshakeFailureWindow + limiter.options.HandshakeTimeout
+
+	for ip, state := range limiter.ips {
+		if now.Before(state.bannedUntil) || now.Sub(state.lastSeen) < retention {
+			continue
+		}
+		delete(limiter.ips, ip)
+	}
+}
+
+// handshakeRejectConfig has no protocol versions, failing a rejected handshake at version negotiation before any
+// certificate or key exchange operation is performed
+var handshakeRejectConfig = &gmtls.Config{
+	MinVersion: 0xffff,
+	MaxVersion: 1,
+}
+
+// apply enforces the limits on a server TLS configuration. It must be applied after any other GetConfigForClient
+// hooks so that rejected handshakes are not given a usable configuration. As the shared transport listener ignores
+// errors returned by GetConfigForClient, rejected handshakes are returned a configuration without protocol versions
+// along with the error.
+func (limiter *handshakeLimiter) apply(tlsConfig *gmtls.Config) {
+	getConfigForClient := tlsConfig.GetConfigForClient
+	tlsConfig.GetConfigForClient = func(info *gmtls.ClientHelloInfo) (*gmtls.Config, error) {
+		config := tlsConfig
+		if getConfigForClient != nil {
+			var err error
+			if config, err = getConfigForClient(info); err != nil {
+				return handshakeRejectConfig, err
+			}
+			if config == nil {
+				config = tlsConfig
+			}
+		}
+
+		ip := handshakeClientIp(info)
+		if ip == nil || limiter.options.isExempt(ip) {
+			return config, nil
+		}
+
+		ipStr := handshakeClientKey(ip)
+		id, err := limiter.begin(ipStr)
+		if err != nil {
+			return handshakeRejectConfig, err
+		}
+
+		if ctx := info.Context(); ctx != nil {
+			go limiter.watch(ctx, ipStr, id)
+		}
+
+		config = config.Clone()
+		config.GetConfigForClient = nil
+
+		verifyConnection := config.VerifyConnection
+		config.VerifyConnection = func(state gmtls.ConnectionState) error {
+			if verifyConnection != nil {
+				if err := verifyConnection(state); err != nil {
+					return err
+				}
+			}
+
+			limiter.complete(ipStr, id)
+			return nil
+		}
+
+		return config, nil
+	}
+}
+
+// handshakeClientKey returns the address handshakes are limited by for a client IP, the IP itself for IPv4 and its /64
+// network for IPv6
+func handshakeClientKey(ip net.IP) string {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return ipv4.String()
+	}
+	network := &net.IPNet{IP: ip.Mask(net.CIDRMask(handshakeIpv6PrefixLength, 128)), Mask: net.CIDRMask(handshakeIpv6PrefixLength, 128)}
+	return network.String()
+}
+
+// handshakeClientIp returns the IP of the client sending a ClientHello or nil if it is not known
+func handshakeClientIp(info *gmtls.ClientHelloInfo) net.IP {
+	if info == nil || info.Conn == nil || info.Conn.RemoteAddr() == nil {
+		return nil
+	}
+
+	switch addr := info.Conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	default:
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			return net.ParseIP(host)
+		}
+	}
+
+	return nil
+}
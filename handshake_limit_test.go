@@ -0,0 +1,439 @@
+package xweb
+
+import (
+	"errors"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"github.com/openziti/xweb/v2/metrics"
+	"github.com/openziti/xweb/v2/ratelimit"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+type handshakeTestConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (conn *handshakeTestConn) RemoteAddr() net.Addr {
+	return conn.remote
+}
+
+func newHandshakeTestHello(ip string) *gmtls.ClientHelloInfo {
+	return &gmtls.ClientHelloInfo{
+		Conn: &handshakeTestConn{remote: &net.TCPAddr{IP: net.ParseIP(ip), Port: 4000}},
+	}
+}
+
+// failingRateBackend is a ratelimit.Backend that is unavailable while recovered is false
+type failingRateBackend struct {
+	calls     int
+	recovered bool
+}
+
+func (backend *failingRateBackend) Take(string, float64, int) (bool, error) {
+	backend.calls++
+	if backend.recovered {
+		return true, nil
+	}
+	return false, errors.New("unavailable")
+}
+
+func newTestHandshakeLimiter(configure func(options *HandshakeLimitOptions)) (*handshakeLimiter, *time.Time, EventDispatcher) {
+	options := &HandshakeLimitOptions{}
+	options.Default()
+	options.HandshakeLimitsEnabled = true
+	if configure != nil {
+		configure(options)
+	}
+
+	events := NewEventDispatcher()
+	limiter := newHandshakeLimiter(options, "test", metrics.NewRegistry(), events)
+
+	now := time.Now()
+	limiter.now = func() time.Time {
+		return now
+	}
+
+	return limiter, &now, events
+}
+
+func TestHandshakeLimitOptions(t *testing.T) {
+	t.Run("parses a handshakeLimits map", func(t *testing.T) {
+		req := require.New(t)
+		options := &HandshakeLimitOptions{}
+		options.Default()
+
+		req.NoError(options.Parse(map[interface{}]interface{}{
+			"handshakeLimits": map[interface{}]interface{}{
+				"perIpRate":     2.5,
+				"perIpBurst":    5,
+				"globalRate":    100,
+				"globalBurst":   200,
+				"maxFailures":   3,
+				"failureWindow": "30s",
+				"banDuration":   "1h",
+				"timeout":       "5s",
+				"exempt":        []interface{}{"10.0.0.0/8", "192.168.1.1"},
+			},
+		}))
+		req.NoError(options.Validate())
+
+		req.True(options.HandshakeLimitsEnabled)
+		req.Equal(2.5, options.HandshakePerIpRate)
+		req.Equal(5, options.HandshakePerIpBurst)
+		req.Equal(float64(100), options.HandshakeGlobalRate)
+		req.Equal(200, options.HandshakeGlobalBurst)
+		req.Equal(3, options.HandshakeMaxFailures)
+		req.Equal(30*time.Second, options.HandshakeFailureWindow)
+		req.Equal(time.Hour, options.HandshakeBanDuration)
+		req.Equal(5*time.Second, options.HandshakeTimeout)
+		req.True(options.isExempt(net.ParseIP("10.1.2.3")))
+		req.True(options.isExempt(net.ParseIP("192.168.1.1")))
+		req.False(options.isExempt(net.ParseIP("192.168.1.2")))
+	})
+
+	t.Run("parses a rate limit backend", func(t *testing.T) {
+		req := require.New(t)
+		options := &HandshakeLimitOptions{}
+		options.Default()
+		req.Equal(ratelimit.BackendTypeMemory, options.HandshakeBackend.Type)
+
+		req.NoError(options.Parse(map[interface{}]interface{}{
+			"handshakeLimits": map[interface{}]interface{}{
+				"backend": map[interface{}]interface{}{
+					"type":    "redis",
+					"address": "redis:6379",
+				},
+			},
+		}))
+		req.NoError(options.Validate())
+		req.Equal(ratelimit.BackendTypeRedis, options.HandshakeBackend.Type)
+		req.Equal("redis:6379", options.HandshakeBackend.Redis.Address)
+
+		req.EqualError(options.Parse(map[interface{}]interface{}{"handshakeLimits": map[interface{}]interface{}{"backend": "redis"}}), "could not use value for handshakeLimits.backend, not a map")
+
+		options.Default()
+		req.NoError(options.Parse(map[interface{}]interface{}{"handshakeLimits": map[interface{}]interface{}{"backend": map[interface{}]interface{}{"type": "etcd"}}}))
+		req.EqualError(options.Validate(), "invalid handshakeLimits.backend: invalid type [etcd], must be one of: memory, redis")
+	})
+
+	t.Run("is disabled without a handshakeLimits map", func(t *testing.T) {
+		req := require.New(t)
+		options := &HandshakeLimitOptions{}
+		options.Default()
+
+		req.NoError(options.Parse(map[interface{}]interface{}{}))
+		req.False(options.HandshakeLimitsEnabled)
+	})
+
+	t.Run("rejects invalid values", func(t *testing.T) {
+		req := require.New(t)
+		options := &HandshakeLimitOptions{}
+		options.Default()
+
+		req.Error(options.Parse(map[interface{}]interface{}{"handshakeLimits": "yes"}))
+		req.Error(options.Parse(map[interface{}]interface{}{"handshakeLimits": map[interface{}]interface{}{"banDuration": "forever"}}))
+		req.Error(options.Parse(map[interface{}]interface{}{"handshakeLimits": map[interface{}]interface{}{"exempt": []interface{}{"not-an-ip"}}}))
+
+		options.Default()
+		req.NoError(options.Parse(map[interface{}]interface{}{"handshakeLimits": map[interface{}]interface{}{"perIpBurst": 0}}))
+		req.Error(options.Validate())
+	})
+}
+
+func Test_handshakeLimiter(t *testing.T) {
+	t.Run("limits the handshake rate per ip", func(t *testing.T) {
+		req := require.New(t)
+		limiter, now, _ := newTestHandshakeLimiter(func(options *HandshakeLimitOptions) {
+			options.HandshakePerIpRate = 1
+			options.HandshakePerIpBurst = 2
+		})
+
+		_, err := limiter.begin("10.0.0.1")
+		req.NoError(err)
+		_, err = limiter.begin("10.0.0.1")
+		req.NoError(err)
+		_, err = limiter.begin("10.0.0.1")
+		req.ErrorIs(err, errHandshakeRateLimited)
+
+		_, err = limiter.begin("10.0.0.2")
+		req.NoError(err)
+
+		*now = now.Add(time.Second)
+		_, err = limiter.begin("10.0.0.1")
+		req.NoError(err)
+	})
+
+	t.Run("limits the global handshake rate", func(t *testing.T) {
+		req := require.New(t)
+		limiter, _, _ := newTestHandshakeLimiter(func(options *HandshakeLimitOptions) {
+			options.HandshakeGlobalRate = 1
+			options.HandshakeGlobalBurst = 1
+		})
+
+		_, err := limiter.begin("10.0.0.1")
+		req.NoError(err)
+		_, err = limiter.begin("10.0.0.2")
+		req.ErrorIs(err, errHandshakeOverloaded)
+	})
+
+	t.Run("shared backends limit rates across limiters", func(t *testing.T) {
+		req := require.New(t)
+		backend := ratelimit.NewMemoryBackend()
+		configure := func(options *HandshakeLimitOptions) {
+			options.HandshakePerIpRate = 1
+			options.HandshakePerIpBurst = 1
+			options.HandshakeBackend.Backend = backend
+		}
+		first, _, _ := newTestHandshakeLimiter(configure)
+		second, _, _ := newTestHandshakeLimiter(configure)
+
+		_, err := first.begin("10.0.0.1")
+		req.NoError(err)
+		_, err = second.begin("10.0.0.1")
+		req.ErrorIs(err, errHandshakeRateLimited)
+	})
+
+	t.Run("handshakes are not limited by rate if the backend fails", func(t *testing.T) {
+		req := require.New(t)
+		backend := &failingRateBackend{}
+		limiter, _, _ := newTestHandshakeLimiter(func(options *HandshakeLimitOptions) {
+			options.HandshakePerIpRate = 1
+			options.HandshakePerIpBurst = 1
+			options.HandshakeBackend.Backend = backend
+		})
+
+		for i := 0; i < 3; i++ {
+			_, err := limiter.begin("10.0.0.1")
+			req.NoError(err)
+		}
+		req.Equal(int64(1), limiter.backendErrors.Count())
+		req.Equal(1, backend.calls)
+	})
+
+	t.Run("failed backends are skipped with an increasing backoff", func(t *testing.T) {
+		req := require.New(t)
+		backend := &failingRateBackend{}
+		limiter, now, _ := newTestHandshakeLimiter(func(options *HandshakeLimitOptions) {
+			options.HandshakePerIpRate = 1
+			options.HandshakePerIpBurst = 100
+			options.HandshakeBackend.Backend = backend
+		})
+
+		begin := func() {
+			_, err := limiter.begin("10.0.0.1")
+			req.NoError(err)
+		}
+
+		begin()
+		req.Equal(1, backend.calls)
+
+		*now = now.Add(handshakeBackendMinBackoff)
+		begin()
+		begin()
+		req.Equal(2, backend.calls)
+
+		*now = now.Add(handshakeBackendMinBackoff)
+		begin()
+		req.Equal(2, backend.calls)
+
+		*now = now.Add(handshakeBackendMinBackoff)
+		backend.recovered = true
+		begin()
+		begin()
+		req.Equal(4, backend.calls)
+		req.Zero(limiter.backendFailures)
+	})
+
+	t.Run("redis backends created by the limiter are closed", func(t *testing.T) {
+		req := require.New(t)
+		limiter, _, _ := newTestHandshakeLimiter(func(options *HandshakeLimitOptions) {
+			options.HandshakePerIpRate = 1
+			options.HandshakeBackend.Type = ratelimit.BackendTypeRedis
+			options.HandshakeBackend.Redis.Address = "127.0.0.1:1"
+		})
+
+		req.NoError(limiter.close())
+		_, err := limiter.backend.Take("a", 1, 1)
+		req.EqualError(err, "redis backend is closed")
+	})
+
+	t.Run("bans ips after repeated failures", func(t *testing.T) {
+		req := require.New(t)
+		limiter, now, events := newTestHandshakeLimiter(func(options *HandshakeLimitOptions) {
+			options.HandshakeMaxFailures = 2
+			options.HandshakeTimeout = time.Second
+			options.HandshakeBanDuration = time.Minute
+		})
+
+		var bans []*HandshakeBanEvent
+		events.AddListener(EventListenerFunc(func(event Event) {
+			bans = append(bans, event.(*HandshakeBanEvent))
+		}))
+
+		id, err := limiter.begin("10.0.0.1")
+		req.NoError(err)
+		limiter.complete("10.0.0.1", id)
+
+		_, err = limiter.begin("10.0.0.1")
+		req.NoError(err)
+		_, err = limiter.begin("10.0.0.1")
+		req.NoError(err)
+
+		*now = now.Add(2 * time.Second)
+		_, err = limiter.begin("10.0.0.1")
+		req.ErrorIs(err, errHandshakeBanned)
+
+		req.Len(bans, 1)
+		req.Equal("10.0.0.1", bans[0].Ip)
+		req.Equal(2, bans[0].Failures)
+		req.Equal("test", bans[0].Server)
+
+		_, err = limiter.begin("10.0.0.2")
+		req.NoError(err)
+
+		*now = now.Add(time.Minute)
+		_, err = limiter.begin("10.0.0.1")
+		req.NoError(err)
+		req.Len(bans, 1)
+	})
+
+	t.Run("discards idle ip state", func(t *testing.T) {
+		req := require.New(t)
+		limiter, now, _ := newTestHandshakeLimiter(nil)
+
+		_, err := limiter.begin("10.0.0.1")
+		req.NoError(err)
+
+		*now = now.Add(time.Hour)
+		_, err = limiter.begin("10.0.0.2")
+		req.NoError(err)
+
+		req.Len(limiter.ips, 1)
+		req.Contains(limiter.ips, "10.0.0.2")
+	})
+
+	t.Run("rejected handshakes are given a configuration without protocol versions", func(t *testing.T) {
+		req := require.New(t)
+		limiter, _, _ := newTestHandshakeLimiter(func(options *HandshakeLimitOptions) {
+			options.HandshakePerIpBurst = 1
+		})
+
+		tlsConfig := &gmtls.Config{}
+		limiter.apply(tlsConfig)
+
+		config, err := tlsConfig.GetConfigForClient(newHandshakeTestHello("10.0.0.1"))
+		req.NoError(err)
+		req.NotNil(config.VerifyConnection)
+		req.Nil(config.GetConfigForClient)
+
+		config, err = tlsConfig.GetConfigForClient(newHandshakeTestHello("10.0.0.1"))
+		req.ErrorIs(err, errHandshakeRateLimited)
+		req.Same(handshakeRejectConfig, config)
+		req.Greater(config.MinVersion, config.MaxVersion)
+	})
+
+	t.Run("completed handshakes are not counted as failures", func(t *testing.T) {
+		req := require.New(t)
+		limiter, _, _ := newTestHandshakeLimiter(nil)
+
+		verified := false
+		tlsConfig := &gmtls.Config{VerifyConnection: func(gmtls.ConnectionState) error {
+			verified = true
+			return nil
+		}}
+		limiter.apply(tlsConfig)
+
+		config, err := tlsConfig.GetConfigForClient(newHandshakeTestHello("10.0.0.1"))
+		req.NoError(err)
+		req.Len(limiter.ips["10.0.0.1"].pending, 1)
+
+		req.NoError(config.VerifyConnection(gmtls.ConnectionState{}))
+		req.True(verified)
+		req.Empty(limiter.ips["10.0.0.1"].pending)
+	})
+
+	t.Run("failed handshakes are counted when they end", func(t *testing.T) {
+		req := require.New(t)
+		limiter, _, _ := newTestHandshakeLimiter(func(options *HandshakeLimitOptions) {
+			options.HandshakeMaxFailures = 1
+		})
+
+		// the server has no certificate, so every handshake fails after its ClientHello
+		tlsConfig := &gmtls.Config{}
+		limiter.apply(tlsConfig)
+
+		serverConn, clientConn := net.Pipe()
+		go func() {
+			_ = gmtls.Client(clientConn, &gmtls.Config{InsecureSkipVerify: true}).Handshake()
+			_ = clientConn.Close()
+		}()
+
+		conn := &handshakeTestConn{Conn: serverConn, remote: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4000}}
+		req.Error(gmtls.Server(conn, tlsConfig).Handshake())
+		_ = serverConn.Close()
+
+		req.Eventually(func() bool {
+			return limiter.failures.Count() == 1
+		}, 5*time.Second, 10*time.Millisecond)
+
+		_, err := limiter.begin("10.0.0.1")
+		req.ErrorIs(err, errHandshakeBanned)
+	})
+
+	t.Run("failures are only counted once", func(t *testing.T) {
+		req := require.New(t)
+		limiter, _, _ := newTestHandshakeLimiter(nil)
+
+		id, err := limiter.begin("10.0.0.1")
+		req.NoError(err)
+		limiter.fail("10.0.0.1", id)
+		limiter.fail("10.0.0.1", id)
+
+		completed, err := limiter.begin("10.0.0.1")
+		req.NoError(err)
+		limiter.complete("10.0.0.1", completed)
+		limiter.fail("10.0.0.1", completed)
+
+		req.Equal(int64(1), limiter.failures.Count())
+	})
+
+	t.Run("ipv6 clients are limited by /64 network", func(t *testing.T) {
+		req := require.New(t)
+		limiter, _, _ := newTestHandshakeLimiter(func(options *HandshakeLimitOptions) {
+			options.HandshakePerIpBurst = 1
+		})
+
+		tlsConfig := &gmtls.Config{}
+		limiter.apply(tlsConfig)
+
+		_, err := tlsConfig.GetConfigForClient(newHandshakeTestHello("2001:db8:0:1::1"))
+		req.NoError(err)
+		_, err = tlsConfig.GetConfigForClient(newHandshakeTestHello("2001:db8:0:1:ffff::2"))
+		req.ErrorIs(err, errHandshakeRateLimited)
+		_, err = tlsConfig.GetConfigForClient(newHandshakeTestHello("2001:db8:0:2::1"))
+		req.NoError(err)
+
+		req.Contains(limiter.ips, "2001:db8:0:1::/64")
+		req.Equal("10.0.0.1", handshakeClientKey(net.ParseIP("::ffff:10.0.0.1")))
+	})
+
+	t.Run("exempt ips are not limited", func(t *testing.T) {
+		req := require.New(t)
+		limiter, _, _ := newTestHandshakeLimiter(func(options *HandshakeLimitOptions) {
+			options.HandshakePerIpBurst = 1
+			options.HandshakeExempt = []*net.IPNet{{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(8, 32)}}
+		})
+
+		tlsConfig := &gmtls.Config{}
+		limiter.apply(tlsConfig)
+
+		for i := 0; i < 3; i++ {
+			_, err := tlsConfig.GetConfigForClient(newHandshakeTestHello("10.0.0.1"))
+			req.NoError(err)
+		}
+		req.Empty(limiter.ips)
+	})
+}
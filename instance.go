@@ -17,9 +17,15 @@ package xweb
 
 import (
 	"context"
+	"fmt"
 	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
 	"github.com/michaelquigley/pfxlog"
 	"github.com/openziti/identity"
+	"github.com/openziti/xweb/v2/breaker"
+	"github.com/openziti/xweb/v2/bufpool"
+	"github.com/openziti/xweb/v2/factory"
+	"github.com/openziti/xweb/v2/metrics"
+	"sync"
 	"time"
 )
 
@@ -30,7 +36,9 @@ type Instance interface {
 	Enabled() bool
 	LoadConfig(cfgmap map[interface{}]interface{}) error
 	Run()
-	Shutdown()
+	// Shutdown returns a ShutdownReport describing how each Server shut down. Before reports were added Shutdown
+	// returned nothing, this is a breaking change for Instance implementations outside of xweb.
+	Shutdown() *ShutdownReport
 	GetRegistry() Registry
 	GetDemuxFactory() DemuxFactory
 	GetConfig() *InstanceConfig
@@ -39,6 +47,7 @@ type Instance interface {
 const (
 	DefaultIdentitySection = "identity"
 	DefaultConfigSection   = "web"
+	DefaultOptionsSection  = "xweb"
 )
 
 // InstanceImpl is a basic implementation of Instance.
@@ -48,18 +57,76 @@ type InstanceImpl struct {
 	servers      []*Server
 	Registry     Registry
 	DemuxFactory DemuxFactory
+	Metrics      metrics.Registry
+	Events       EventDispatcher
+	AccessLog    *AccessLogController
+	LogSinks     *LogSinks
+	FeatureFlags *FeatureFlags
+	Tasks        *TaskRunner
+	Services     *factory.Services
+	History      *ConfigHistory
+	SandboxHooks []SandboxHook
+
+	caBundlesInit sync.Once
+	caBundles     *caBundles
+
+	featureFlagsInit sync.Once
+
+	// built is set by Build, after which LoadConfig reloads the runtime settings of the options section
+	built bool
+
+	// ServiceRegistrars are notified as bind points start and stop serving
+	ServiceRegistrars []ServiceRegistrar
+
+	// serving holds the Servers started by Start that have not stopped serving, mapped to true once they are
+	// registered with the ServiceRegistrars. It is guarded by servingLock, which is held while registering so that
+	// a Server is never deregistered before its registration completes.
+	servingLock sync.Mutex
+	serving     map[*Server]bool
+
+	// CircuitBreakers are shared by name by handlers calling the same downstream dependency
+	CircuitBreakers *breaker.Registry
 }
 
 var _ Instance = &InstanceImpl{}
+var _ RegisteringInstance = &InstanceImpl{}
+var _ MetricsInstance = &InstanceImpl{}
+var _ EventsInstance = &InstanceImpl{}
+var _ AccessLogInstance = &InstanceImpl{}
+var _ CaBundleInstance = &InstanceImpl{}
+var _ FeatureFlagsInstance = &InstanceImpl{}
+var _ TaskRunnerInstance = &InstanceImpl{}
+var _ ServicesInstance = &InstanceImpl{}
+var _ ConfigHistoryInstance = &InstanceImpl{}
+var _ CircuitBreakerInstance = &InstanceImpl{}
+var _ ApiControlInstance = &InstanceImpl{}
 
 func NewDefaultInstance(registry Registry, defaultIdentity identity.Identity) *InstanceImpl {
+	events := NewEventDispatcher()
+	metricsRegistry := metrics.NewRegistry()
+
+	circuitBreakers := NewCircuitBreakers(metricsRegistry, events)
+	services := factory.NewServices()
+	_ = factory.Provide(services, circuitBreakers)
+
 	return &InstanceImpl{
-		Registry:     registry,
-		DemuxFactory: &IsHandledDemuxFactory{},
+		Registry:        registry,
+		DemuxFactory:    &IsHandledDemuxFactory{},
+		Metrics:         metricsRegistry,
+		Tasks:           NewTaskRunner(metricsRegistry),
+		Events:          events,
+		caBundles:       newCaBundles(events),
+		AccessLog:       NewAccessLogController(),
+		LogSinks:        NewLogSinks(),
+		FeatureFlags:    NewFeatureFlags(events),
+		Services:        services,
+		History:         NewConfigHistory(DefaultConfigHistorySize),
+		CircuitBreakers: circuitBreakers,
 		Config: &InstanceConfig{
 			DefaultIdentitySection: DefaultIdentitySection,
 			DefaultIdentity:        defaultIdentity,
 			Section:                DefaultConfigSection,
+			OptionsSection:         DefaultOptionsSection,
 		},
 	}
 }
@@ -69,6 +136,17 @@ func (i *InstanceImpl) GetRegistry() Registry {
 	return i.Registry
 }
 
+// Register adds a factory to the associated Registry. If the allowRegistryOverride instance option is set and the
+// Registry is an OverridableRegistry any factory with the same binding is replaced, otherwise registering a binding
+// twice is an error. The option only applies to factories registered after the configuration is loaded.
+func (i *InstanceImpl) Register(factory ApiHandlerFactory) error {
+	if registry, ok := i.Registry.(OverridableRegistry); ok {
+		return registry.Set(factory, i.Config.Options.AllowRegistryOverride)
+	}
+
+	return i.Registry.Add(factory)
+}
+
 // GetDemuxFactory returns the associated DemuxFactory
 func (i *InstanceImpl) GetDemuxFactory() DemuxFactory {
 	return i.DemuxFactory
@@ -79,13 +157,169 @@ func (i *InstanceImpl) GetConfig() *InstanceConfig {
 	return i.Config
 }
 
+// GetMetrics returns the metrics.Registry used by all Server's of this instance
+func (i *InstanceImpl) GetMetrics() metrics.Registry {
+	return i.Metrics
+}
+
+// GetEventDispatcher returns the EventDispatcher used by all Server's of this instance
+func (i *InstanceImpl) GetEventDispatcher() EventDispatcher {
+	return i.Events
+}
+
+// GetAccessLogController returns the AccessLogController used to manage runtime access log verbosity
+func (i *InstanceImpl) GetAccessLogController() *AccessLogController {
+	return i.AccessLog
+}
+
+// GetCaBundle returns the watched CaBundle for a PEM file, loading it on first use. Bind points that reference the same
+// file share a CaBundle.
+func (i *InstanceImpl) GetCaBundle(path string) (*CaBundle, error) {
+	return i.getCaBundles().get(path)
+}
+
+func (i *InstanceImpl) getCaBundles() *caBundles {
+	i.caBundlesInit.Do(func() {
+		if i.caBundles == nil {
+			i.caBundles = newCaBundles(i.Events)
+		}
+	})
+	return i.caBundles
+}
+
+// GetFeatureFlags returns the FeatureFlags shared by all Server's of this instance
+func (i *InstanceImpl) GetFeatureFlags() *FeatureFlags {
+	i.featureFlagsInit.Do(func() {
+		if i.FeatureFlags == nil {
+			i.FeatureFlags = NewFeatureFlags(i.Events)
+		}
+	})
+	return i.FeatureFlags
+}
+
+// GetTaskRunner returns the TaskRunner for background tasks, which is stopped when the instance shuts down
+func (i *InstanceImpl) GetTaskRunner() *TaskRunner {
+	if i.Tasks == nil {
+		i.Tasks = NewTaskRunner(i.Metrics)
+	}
+	return i.Tasks
+}
+
+// GetServices returns the Services published by the embedding application for factories to resolve
+func (i *InstanceImpl) GetServices() *factory.Services {
+	if i.Services == nil {
+		i.Services = factory.NewServices()
+	}
+	return i.Services
+}
+
+// GetCircuitBreakers returns the circuit breakers of the instance's downstream dependencies, which are also provided
+// to factories as a service
+func (i *InstanceImpl) GetCircuitBreakers() *breaker.Registry {
+	if i.CircuitBreakers == nil {
+		i.CircuitBreakers = NewCircuitBreakers(i.Metrics, i.Events)
+		_ = factory.Provide(i.GetServices(), i.CircuitBreakers)
+	}
+	return i.CircuitBreakers
+}
+
+// GetConfigHistory returns the ConfigHistory of the instance's EffectiveConfig
+func (i *InstanceImpl) GetConfigHistory() *ConfigHistory {
+	if i.History == nil {
+		i.History = NewConfigHistory(DefaultConfigHistorySize)
+	}
+	return i.History
+}
+
+// EffectiveConfig renders the InstanceConfig as an EffectiveConfig with the instance's runtime state: the current
+// feature flags, the unexpired access log overrides and which APIs are disabled.
+func (i *InstanceImpl) EffectiveConfig() *EffectiveConfig {
+	result := i.Config.EffectiveConfig()
+	result.FeatureFlags = i.GetFeatureFlags().Values()
+	result.AccessLogOverrides = i.GetAccessLogController().Overrides()
+
+	for _, server := range i.servers {
+		for _, effectiveServer := range result.Servers {
+			if effectiveServer.Name != server.ServerConfig.Name {
+				continue
+			}
+
+			for _, effectiveApi := range effectiveServer.APIs {
+				if instance := server.getApiInstance(effectiveApi.Name); instance != nil {
+					effectiveApi.Disabled, _ = instance.isDisabled()
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// RecordConfigRevision records a revision of the EffectiveConfig in the ConfigHistory if it has changed. It is called
+// by Build, ReloadFeatureFlags, DisableApi and EnableApi, and by the admin API as it changes feature flags and access
+// log overrides. Embedding applications that change the InstanceConfig or runtime state should call it with a reason
+// describing the change.
+func (i *InstanceImpl) RecordConfigRevision(reason string) {
+	revision, added, err := i.GetConfigHistory().Record(reason, i.EffectiveConfig())
+	if err != nil {
+		pfxlog.Logger().Errorf("could not record xweb config revision for %s: %v", reason, err)
+		return
+	}
+
+	if added {
+		pfxlog.Logger().Infof("xweb config revision %d recorded for %s, changed: %v", revision.Revision, reason, revision.Changed)
+	}
+}
+
+// ReloadFeatureFlags replaces the FeatureFlags with those of the `featureFlags` map of an instance options section,
+// flags not present are removed and so disabled. It is called by LoadConfig once the instance is built. The
+// InstanceConfig is not modified, it retains the flags the instance was built with.
+func (i *InstanceImpl) ReloadFeatureFlags(optionsMap map[interface{}]interface{}) error {
+	flags := map[string]bool{}
+	if interfaceVal, ok := optionsMap["featureFlags"]; ok {
+		var err error
+		if flags, err = parseFeatureFlags(interfaceVal); err != nil {
+			return err
+		}
+	}
+
+	i.GetFeatureFlags().Replace(flags)
+	pfxlog.Logger().Infof("xweb feature flags reloaded: %v", flags)
+	i.LogSinks.Audit("featureFlags.reload", map[string]interface{}{
+		"flags": flags,
+	})
+	i.RecordConfigRevision("featureFlags.reload")
+
+	return nil
+}
+
+// AddSandboxHook adds a SandboxHook to be called by Start, see SandboxOptions for ordering
+func (i *InstanceImpl) AddSandboxHook(hook SandboxHook) {
+	i.SandboxHooks = append(i.SandboxHooks, hook)
+}
+
+// AddServiceRegistrar adds a ServiceRegistrar to be notified as bind points start and stop serving
+func (i *InstanceImpl) AddServiceRegistrar(registrar ServiceRegistrar) {
+	i.ServiceRegistrars = append(i.ServiceRegistrars, registrar)
+}
+
+// GetLogSinks returns the LogSinks access and audit records are written to
+func (i *InstanceImpl) GetLogSinks() *LogSinks {
+	return i.LogSinks
+}
+
 // Enabled returns true/false on whether this subconfig should be considered enabled
 func (i *InstanceImpl) Enabled() bool {
 	return i.Config.Enabled()
 }
 
-// LoadConfig handles subconfig operations for xweb.Instance components
+// LoadConfig handles subconfig operations for xweb.Instance components. Once the instance is built, configuration is
+// reloaded instead: only the feature flags of the options section are applied, other changes require a restart.
 func (i *InstanceImpl) LoadConfig(cfgmap map[interface{}]interface{}) error {
+	if i.built {
+		return i.reloadConfig(cfgmap)
+	}
+
 	if err := i.Config.Parse(cfgmap); err != nil {
 		return err
 	}
@@ -98,8 +332,49 @@ func (i *InstanceImpl) LoadConfig(cfgmap map[interface{}]interface{}) error {
 	return nil
 }
 
+// reloadConfig applies the settings of a reloaded configuration that may change while serving
+func (i *InstanceImpl) reloadConfig(cfgmap map[interface{}]interface{}) error {
+	optionsMap := map[interface{}]interface{}{}
+	if optionsInterface, ok := cfgmap[i.Config.OptionsSection]; ok {
+		if optionsMap, ok = optionsInterface.(map[interface{}]interface{}); !ok {
+			return fmt.Errorf("instance options section [%s] must be a map", i.Config.OptionsSection)
+		}
+	}
+
+	if err := i.ReloadFeatureFlags(optionsMap); err != nil {
+		return fmt.Errorf("error reloading instance options section [%s]: %v", i.Config.OptionsSection, err)
+	}
+
+	return nil
+}
+
 // Build assembles all the xweb components from configuration and prepares to have Start() called.
 func (i *InstanceImpl) Build() {
+	i.built = true
+
+	if i.Config.Options.LogEffectiveConfig {
+		if effectiveJson, err := i.Config.EffectiveConfig().JSON(); err == nil {
+			pfxlog.Logger().Infof("xweb effective configuration: %s", effectiveJson)
+		} else {
+			pfxlog.Logger().Errorf("could not render xweb effective configuration: %v", err)
+		}
+	}
+
+	if err := i.LogSinks.Build(i.Config.Options.LogSinks); err != nil {
+		pfxlog.Logger().Fatalf("error building xweb log sinks: %v", err)
+	}
+
+	bufpool.Instrument(i.Metrics)
+
+	i.GetFeatureFlags().Replace(i.Config.Options.FeatureFlags)
+	i.RecordConfigRevision("build")
+
+	for name, options := range i.Config.Options.CircuitBreakers {
+		if err := i.GetCircuitBreakers().Configure(name, options); err != nil {
+			pfxlog.Logger().Fatalf("error configuring xweb circuit breakers: %v", err)
+		}
+	}
+
 	for _, serverConfig := range i.Config.ServerConfigs {
 		server, err := NewServer(i, serverConfig)
 
@@ -111,15 +386,55 @@ func (i *InstanceImpl) Build() {
 	}
 }
 
-// Start calls Start() on all Servers that were built by calling Build().
+// Start listens on the bind points of all Servers that were built by calling Build(), applies sandboxing and drops
+// privileges as configured by InstanceOptions and then serves requests and notifies ServiceRegistrars. Servers are listened on first so that
+// privileged ports can be bound before privileges are dropped and the filesystem is restricted. Servers are registered
+// in the background and deregistered if they fail to serve.
 func (i *InstanceImpl) Start() {
+	var listening []*Server
+
 	for _, server := range i.servers {
+		if err := server.Listen(); err != nil {
+			pfxlog.Logger().Errorf("error starting server %s: %v", server.ServerConfig.Name, err)
+			continue
+		}
+		listening = append(listening, server)
+	}
+
+	//users and groups are looked up before the chroot, which may not contain the user and group databases
+	if err := i.Config.Options.RunAs.Resolve(); err != nil {
+		pfxlog.Logger().Fatalf("error dropping privileges: %v", err)
+	}
+
+	if err := i.Config.Options.Sandbox.ApplyChroot(); err != nil {
+		pfxlog.Logger().Fatalf("error applying sandbox: %v", err)
+	}
+
+	for _, hook := range i.SandboxHooks {
+		if err := hook(i); err != nil {
+			pfxlog.Logger().Fatalf("error applying sandbox hook: %v", err)
+		}
+	}
+
+	if err := i.Config.Options.RunAs.DropPrivileges(); err != nil {
+		pfxlog.Logger().Fatalf("error dropping privileges: %v", err)
+	}
+
+	if err := i.Config.Options.Sandbox.ApplyLandlock(); err != nil {
+		pfxlog.Logger().Fatalf("error applying sandbox: %v", err)
+	}
+
+	i.startServing(listening)
+
+	for _, server := range listening {
 		s := server //avoid closure scoping issues
 		go func() {
-			if err := s.Start(); err != nil {
+			if err := s.Serve(); err != nil {
 				pfxlog.Logger().Errorf("error starting server %s: %v", s.ServerConfig.Name, err)
 			}
+			i.stopServing(s)
 		}()
+		go i.registerServing(s)
 	}
 }
 
@@ -129,16 +444,130 @@ func (i *InstanceImpl) Run() {
 	i.Start()
 }
 
-// Shutdown stop all running xweb.Server's
-func (i *InstanceImpl) Shutdown() {
+// Shutdown stops all running xweb.Server's and returns once they have stopped serving. The returned ShutdownReport is
+// also dispatched as a ShutdownCompletedEvent. Background tasks are stopped and log sinks flushed afterwards.
+func (i *InstanceImpl) Shutdown() *ShutdownReport {
+	//deregister before serving stops so that clients are directed elsewhere while in flight requests complete
 	for _, server := range i.servers {
-		localServer := server
+		i.stopServing(server)
+	}
+
+	report := &ShutdownReport{
+		Started: time.Now(),
+		Servers: make([]*ServerShutdownReport, len(i.servers)),
+	}
+
+	shutdownGroup := &sync.WaitGroup{}
+
+	for idx, server := range i.servers {
+		localIdx, localServer := idx, server
+		shutdownGroup.Add(1)
 		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+			defer shutdownGroup.Done()
+			drainTimeout := localServer.ServerConfig.Options.DrainTimeout
+			if drainTimeout <= 0 {
+				drainTimeout = DefaultDrainTimeout
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
 			defer cancel()
-			localServer.Shutdown(ctx)
+			report.Servers[localIdx] = localServer.Shutdown(ctx)
 		}()
 	}
+
+	shutdownGroup.Wait()
+	report.Duration = time.Since(report.Started)
+
+	if report.Clean() {
+		pfxlog.Logger().Infof("xweb shutdown complete: %s", report)
+	} else {
+		pfxlog.Logger().Warnf("xweb shutdown complete with forced closes or errors: %s", report)
+	}
+
+	if i.Events != nil {
+		i.Events.Dispatch(&ShutdownCompletedEvent{Report: report})
+	}
+
+	//stop background tasks and flush log sinks once in flight requests have been logged
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+		defer cancel()
+		if err := i.GetTaskRunner().Stop(ctx); err != nil {
+			pfxlog.Logger().Errorf("error stopping xweb background tasks: %v", err)
+		}
+
+		i.getCaBundles().close()
+		i.LogSinks.Close()
+	}()
+
+	return report
+}
+
+// DisableApi temporarily disables the ApiHandler with the instance name apiName on the server named serverName. Requests
+// will receive a http.StatusServiceUnavailable response with a Retry-After header of retryAfter (or
+// DefaultDisabledRetryAfter if not positive). The ApiHandler and its configuration are retained.
+func (i *InstanceImpl) DisableApi(serverName, apiName string, retryAfter time.Duration) error {
+	instance, err := i.getApiInstance(serverName, apiName)
+
+	if err != nil {
+		return err
+	}
+
+	instance.Disable(retryAfter)
+	_, retryAfter = instance.isDisabled()
+	pfxlog.Logger().Infof("xweb api [%s] on server [%s] disabled, retry after %s", apiName, serverName, retryAfter)
+	i.LogSinks.Audit("api.disable", map[string]interface{}{
+		"server":     serverName,
+		"name":       apiName,
+		"retryAfter": retryAfter.String(),
+	})
+	i.RecordConfigRevision("api.disable")
+
+	return nil
+}
+
+// EnableApi re-enables an ApiHandler previously disabled via DisableApi
+func (i *InstanceImpl) EnableApi(serverName, apiName string) error {
+	instance, err := i.getApiInstance(serverName, apiName)
+
+	if err != nil {
+		return err
+	}
+
+	instance.Enable()
+	pfxlog.Logger().Infof("xweb api [%s] on server [%s] enabled", apiName, serverName)
+	i.LogSinks.Audit("api.enable", map[string]interface{}{
+		"server": serverName,
+		"name":   apiName,
+	})
+	i.RecordConfigRevision("api.enable")
+
+	return nil
+}
+
+// GetApiStates returns the ApiState of every ApiHandler on every built Server
+func (i *InstanceImpl) GetApiStates() []*ApiState {
+	var result []*ApiState
+
+	for _, server := range i.servers {
+		for _, instance := range server.apiInstances {
+			result = append(result, instance.State())
+		}
+	}
+
+	return result
+}
+
+func (i *InstanceImpl) getApiInstance(serverName, apiName string) (*apiInstance, error) {
+	for _, server := range i.servers {
+		if server.ServerConfig.Name == serverName {
+			if instance := server.getApiInstance(apiName); instance != nil {
+				return instance, nil
+			}
+			return nil, fmt.Errorf("api [%s] not found on server [%s]", apiName, serverName)
+		}
+	}
+
+	return nil, fmt.Errorf("server [%s] not found", serverName)
 }
 
 // DefaultHttpHandlerProvider is an interface that allows different levels of xweb's components: Instance, ServerConfig,
@@ -0,0 +1,171 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"github.com/openziti/xweb/v2/breaker"
+	"github.com/openziti/xweb/v2/factory"
+	"github.com/openziti/xweb/v2/metrics"
+	"time"
+)
+
+// The interfaces below are optional capabilities of an Instance. Servers use the capabilities an Instance provides and
+// fall back to instance independent defaults otherwise, so that Instance implementations outside of xweb keep working
+// as capabilities are added. InstanceImpl provides all of them.
+
+// RegisteringInstance is an Instance that registers ApiHandlerFactory's with its Registry, see InstanceImpl.Register
+type RegisteringInstance interface {
+	Instance
+	Register(factory ApiHandlerFactory) error
+}
+
+// MetricsInstance is an Instance that collects the metrics of its servers
+type MetricsInstance interface {
+	Instance
+	GetMetrics() metrics.Registry
+}
+
+// EventsInstance is an Instance that dispatches the Event's of its servers
+type EventsInstance interface {
+	Instance
+	GetEventDispatcher() EventDispatcher
+}
+
+// AccessLogInstance is an Instance that writes access and audit records to LogSinks and allows access log verbosity
+// to be changed at runtime
+type AccessLogInstance interface {
+	Instance
+	GetAccessLogController() *AccessLogController
+	GetLogSinks() *LogSinks
+}
+
+// CaBundleInstance is an Instance that loads and watches client CA bundles
+type CaBundleInstance interface {
+	Instance
+	GetCaBundle(path string) (*CaBundle, error)
+}
+
+// FeatureFlagsInstance is an Instance that provides FeatureFlags to request contexts and reloads them from an instance
+// options section
+type FeatureFlagsInstance interface {
+	Instance
+	GetFeatureFlags() *FeatureFlags
+	ReloadFeatureFlags(optionsMap map[interface{}]interface{}) error
+}
+
+// TaskRunnerInstance is an Instance that runs the background tasks of factories and stops them on shutdown
+type TaskRunnerInstance interface {
+	Instance
+	GetTaskRunner() *TaskRunner
+}
+
+// ServicesInstance is an Instance that provides shared services to factories
+type ServicesInstance interface {
+	Instance
+	GetServices() *factory.Services
+}
+
+// ConfigHistoryInstance is an Instance that records revisions of its effective configuration
+type ConfigHistoryInstance interface {
+	Instance
+	EffectiveConfig() *EffectiveConfig
+	GetConfigHistory() *ConfigHistory
+	RecordConfigRevision(reason string)
+}
+
+// CircuitBreakerInstance is an Instance that shares circuit breakers by downstream dependency
+type CircuitBreakerInstance interface {
+	Instance
+	GetCircuitBreakers() *breaker.Registry
+}
+
+// ApiControlInstance is an Instance whose api instances may be disabled and enabled at runtime
+type ApiControlInstance interface {
+	Instance
+	DisableApi(serverName, apiName string, retryAfter time.Duration) error
+	EnableApi(serverName, apiName string) error
+	GetApiStates() []*ApiState
+}
+
+// instanceCapabilities resolves the capabilities of an Instance once per Server, substituting defaults for those it
+// does not provide
+type instanceCapabilities struct {
+	metrics      metrics.Registry
+	events       EventDispatcher
+	accessLog    *AccessLogController
+	logSinks     *LogSinks
+	featureFlags *FeatureFlags
+	services     *factory.Services
+	tasks        *TaskRunner
+	caBundles    CaBundleInstance
+}
+
+func resolveInstanceCapabilities(instance Instance) *instanceCapabilities {
+	result := &instanceCapabilities{}
+
+	if metricsInstance, ok := instance.(MetricsInstance); ok {
+		result.metrics = metricsInstance.GetMetrics()
+	}
+	if result.metrics == nil {
+		result.metrics = metrics.NewRegistry()
+	}
+
+	if eventsInstance, ok := instance.(EventsInstance); ok {
+		result.events = eventsInstance.GetEventDispatcher()
+	}
+	if result.events == nil {
+		result.events = NewEventDispatcher()
+	}
+
+	if accessLogInstance, ok := instance.(AccessLogInstance); ok {
+		result.accessLog = accessLogInstance.GetAccessLogController()
+		result.logSinks = accessLogInstance.GetLogSinks()
+	}
+	if result.accessLog == nil {
+		result.accessLog = NewAccessLogController()
+	}
+	if result.logSinks == nil {
+		result.logSinks = NewLogSinks()
+	}
+
+	if featureFlagsInstance, ok := instance.(FeatureFlagsInstance); ok {
+		result.featureFlags = featureFlagsInstance.GetFeatureFlags()
+	}
+
+	if servicesInstance, ok := instance.(ServicesInstance); ok {
+		result.services = servicesInstance.GetServices()
+	}
+
+	if taskRunnerInstance, ok := instance.(TaskRunnerInstance); ok {
+		result.tasks = taskRunnerInstance.GetTaskRunner()
+	}
+
+	if caBundleInstance, ok := instance.(CaBundleInstance); ok {
+		result.caBundles = caBundleInstance
+	}
+
+	return result
+}
+
+// getCaBundle returns the CaBundle for path if the Instance is a CaBundleInstance
+func (capabilities *instanceCapabilities) getCaBundle(path string) (*CaBundle, error) {
+	if capabilities.caBundles == nil {
+		return nil, fmt.Errorf("instance does not provide client CA bundles, cannot load [%s]", path)
+	}
+	return capabilities.caBundles.GetCaBundle(path)
+}
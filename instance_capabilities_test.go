@@ -0,0 +1,42 @@
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// minimalInstance only provides the methods of Instance, as an Instance implemented outside of xweb would
+type minimalInstance struct {
+	Instance
+}
+
+func Test_resolveInstanceCapabilities(t *testing.T) {
+	t.Run("an instance without capabilities is given defaults", func(t *testing.T) {
+		req := require.New(t)
+		capabilities := resolveInstanceCapabilities(&minimalInstance{})
+
+		req.NotNil(capabilities.metrics)
+		req.NotNil(capabilities.events)
+		req.NotNil(capabilities.accessLog)
+		req.NotNil(capabilities.logSinks)
+		req.Nil(capabilities.featureFlags)
+		req.Nil(capabilities.services)
+		req.Nil(capabilities.tasks)
+
+		_, err := capabilities.getCaBundle("ca.pem")
+		req.Error(err)
+	})
+
+	t.Run("the capabilities of an instance are used", func(t *testing.T) {
+		req := require.New(t)
+		instance := NewDefaultInstance(NewRegistryMap(), nil)
+		capabilities := resolveInstanceCapabilities(instance)
+
+		req.Same(instance.GetEventDispatcher(), capabilities.events)
+		req.Same(instance.GetAccessLogController(), capabilities.accessLog)
+		req.Same(instance.GetLogSinks(), capabilities.logSinks)
+		req.Same(instance.GetFeatureFlags(), capabilities.featureFlags)
+		req.Same(instance.GetServices(), capabilities.services)
+		req.Same(instance.GetTaskRunner(), capabilities.tasks)
+	})
+}
@@ -21,6 +21,8 @@ import (
 	"fmt"
 	"gitee.com/zhaochuninhefei/gmgo/gmtls"
 	"github.com/openziti/identity"
+	"github.com/openziti/xweb/v2/breaker"
+	"github.com/openziti/xweb/v2/logsink"
 	"time"
 )
 
@@ -56,6 +58,13 @@ type InstanceConfig struct {
 	ServerConfigs []*ServerConfig
 	Section       string
 
+	OptionsSection string
+	Options        InstanceOptions
+
+	// SensitiveKeyFragments are additional configuration key fragments, beyond DefaultSensitiveKeyFragments, whose
+	// values are redacted by MarshalSafe and EffectiveConfig.
+	SensitiveKeyFragments []string
+
 	DefaultIdentity        identity.Identity
 	DefaultIdentitySection string
 
@@ -66,9 +75,16 @@ type InstanceConfig struct {
 }
 
 // Parse parses a configuration map, looking for sections that define an identity.InstanceConfig and an array of ServerConfig's.
+// Environment variable references of the form `${env:NAME}` or `${env:NAME:-default}` in the identity, options and web
+// sections are expanded, SourceConfig retains the unexpanded values.
 func (config *InstanceConfig) Parse(configMap map[interface{}]interface{}) error {
 	config.SourceConfig = configMap
 
+	configMap, err := config.expandEnvSections(configMap)
+	if err != nil {
+		return err
+	}
+
 	if config.DefaultIdentity == nil && config.DefaultIdentitySection == "" {
 		return errors.New("identity section not specified for configuration, must be specified if a default identity is not provided")
 	}
@@ -97,6 +113,18 @@ func (config *InstanceConfig) Parse(configMap map[interface{}]interface{}) error
 		config.defaultIdentityConfig = config.DefaultIdentity.GetConfig()
 	}
 
+	if config.OptionsSection != "" {
+		if optionsInterface, ok := configMap[config.OptionsSection]; ok {
+			if optionsMap, ok := optionsInterface.(map[interface{}]interface{}); ok {
+				if err := config.Options.Parse(optionsMap); err != nil {
+					return fmt.Errorf("error parsing instance options section [%s]: %v", config.OptionsSection, err)
+				}
+			} else {
+				return fmt.Errorf("instance options section [%s] must be a map", config.OptionsSection)
+			}
+		} //no else, optional
+	}
+
 	if sectionVal, ok := configMap[config.Section]; ok {
 		//treat section like an array of maps
 		if sectionArrayVals, ok := sectionVal.([]interface{}); ok {
@@ -122,6 +150,31 @@ func (config *InstanceConfig) Parse(configMap map[interface{}]interface{}) error
 	return nil
 }
 
+// expandEnvSections returns a shallow copy of configMap whose identity, options and web sections have their environment
+// variable references expanded
+func (config *InstanceConfig) expandEnvSections(configMap map[interface{}]interface{}) (map[interface{}]interface{}, error) {
+	result := make(map[interface{}]interface{}, len(configMap))
+	for key, value := range configMap {
+		result[key] = value
+	}
+
+	for _, section := range []string{config.DefaultIdentitySection, config.OptionsSection, config.Section} {
+		if section == "" {
+			continue
+		}
+
+		if sectionVal, ok := configMap[section]; ok {
+			expanded, err := expandEnv(sectionVal, section)
+			if err != nil {
+				return nil, err
+			}
+			result[section] = expanded
+		}
+	}
+
+	return result, nil
+}
+
 // Validate uses a Registry to validate that all ApiConfig bindings may be fulfilled. All other relevant
 // InstanceConfig values are also validated.
 func (config *InstanceConfig) Validate(registry Registry) error {
@@ -153,6 +206,10 @@ func (config *InstanceConfig) Validate(registry Registry) error {
 		}
 	}
 
+	if err := config.validateBindPointOverlaps(); err != nil {
+		return err
+	}
+
 	for presentApiBinding, presentApiFactory := range presentApis {
 		if err := presentApiFactory.Validate(config); err != nil {
 			return fmt.Errorf("error validating ApiConfig binding %s: %v", presentApiBinding, err)
@@ -165,22 +222,177 @@ func (config *InstanceConfig) Validate(registry Registry) error {
 	return nil
 }
 
+// validateBindPointOverlaps returns an error if any two bind points, in the same or different servers, would
+// attempt to listen on overlapping interface addresses.
+func (config *InstanceConfig) validateBindPointOverlaps() error {
+	type locatedBindPoint struct {
+		location  string
+		bindPoint *BindPointConfig
+	}
+
+	var seen []*locatedBindPoint
+
+	for i, serverConfig := range config.ServerConfigs {
+		for j, bindPoint := range serverConfig.BindPoints {
+			current := &locatedBindPoint{
+				location:  fmt.Sprintf("%s[%d].bindPoints[%d]", config.Section, i, j),
+				bindPoint: bindPoint,
+			}
+
+			for _, previous := range seen {
+				if current.bindPoint.Overlaps(previous.bindPoint) {
+					return fmt.Errorf("bind point %s interface [%s] overlaps with bind point %s interface [%s]", current.location, current.bindPoint.InterfaceAddress, previous.location, previous.bindPoint.InterfaceAddress)
+				}
+			}
+
+			seen = append(seen, current)
+		}
+	}
+
+	return nil
+}
+
 // Enabled returns true/false on whether this configuration should be considered "enabled". Set to true after
 // Validate passes.
 func (config *InstanceConfig) Enabled() bool {
 	return config.enabled
 }
 
+// InstanceOptions are the instance wide options parsed from the InstanceConfig.OptionsSection.
+type InstanceOptions struct {
+	// LogEffectiveConfig will log the EffectiveConfig as JSON when the instance is built
+	LogEffectiveConfig bool
+
+	// LogSinks are the destinations for access and audit records, see logsink.Config
+	LogSinks []*logsink.Config
+
+	// RunAs is the user and group to switch to after all bind points are listening
+	RunAs PrivilegeDropOptions
+
+	// Sandbox is the filesystem sandboxing applied after all bind points are listening
+	Sandbox SandboxOptions
+
+	// FeatureFlags are the initial values of the Instance's FeatureFlags
+	FeatureFlags map[string]bool
+
+	// CircuitBreakers are the options of the Instance's circuit breakers by dependency name, dependencies that are not
+	// configured use the breaker.Options defaults
+	CircuitBreakers map[string]breaker.Options
+
+	// AllowRegistryOverride allows InstanceImpl.Register to replace a factory previously registered for the same binding
+	AllowRegistryOverride bool
+}
+
+// Parse parses a configuration map
+func (options *InstanceOptions) Parse(optionsMap map[interface{}]interface{}) error {
+	if interfaceVal, ok := optionsMap["logEffectiveConfig"]; ok {
+		if logEffectiveConfig, ok := interfaceVal.(bool); ok {
+			options.LogEffectiveConfig = logEffectiveConfig
+		} else {
+			return errors.New("could not use value for logEffectiveConfig, not a boolean")
+		}
+	}
+
+	if interfaceVal, ok := optionsMap["allowRegistryOverride"]; ok {
+		if allowRegistryOverride, ok := interfaceVal.(bool); ok {
+			options.AllowRegistryOverride = allowRegistryOverride
+		} else {
+			return errors.New("could not use value for allowRegistryOverride, not a boolean")
+		}
+	}
+
+	if interfaceVal, ok := optionsMap["featureFlags"]; ok {
+		featureFlags, err := parseFeatureFlags(interfaceVal)
+		if err != nil {
+			return err
+		}
+		options.FeatureFlags = featureFlags
+	}
+
+	if interfaceVal, ok := optionsMap["circuitBreakers"]; ok {
+		circuitBreakers, err := parseCircuitBreakers(interfaceVal)
+		if err != nil {
+			return err
+		}
+		options.CircuitBreakers = circuitBreakers
+	}
+
+	if interfaceVal, ok := optionsMap["runAs"]; ok {
+		if runAsMap, ok := interfaceVal.(map[interface{}]interface{}); ok {
+			if err := options.RunAs.Parse(runAsMap); err != nil {
+				return fmt.Errorf("error parsing runAs: %v", err)
+			}
+		} else {
+			return errors.New("could not use value for runAs, not a map")
+		}
+	}
+
+	if interfaceVal, ok := optionsMap["sandbox"]; ok {
+		if sandboxMap, ok := interfaceVal.(map[interface{}]interface{}); ok {
+			if err := options.Sandbox.Parse(sandboxMap); err != nil {
+				return fmt.Errorf("error parsing sandbox: %v", err)
+			}
+		} else {
+			return errors.New("could not use value for sandbox, not a map")
+		}
+	}
+
+	if interfaceVal, ok := optionsMap["logSinks"]; ok {
+		sinkArray, ok := interfaceVal.([]interface{})
+		if !ok {
+			return errors.New("could not use value for logSinks, not an array")
+		}
+
+		for i, sinkInterface := range sinkArray {
+			sinkMap, ok := sinkInterface.(map[interface{}]interface{})
+			if !ok {
+				return fmt.Errorf("could not use value for logSinks[%d], not a map", i)
+			}
+
+			sinkConfig := &logsink.Config{}
+			if err := sinkConfig.Parse(sinkMap); err != nil {
+				return fmt.Errorf("error parsing logSinks[%d]: %v", i, err)
+			}
+
+			options.LogSinks = append(options.LogSinks, sinkConfig)
+		}
+	}
+
+	return nil
+}
+
 // Options is the shared options for a ServerConfig.
 type Options struct {
 	TimeoutOptions
 	TlsVersionOptions
+	TlsProfileOptions
+	ClientCaOptions
+	BalanceOptions
+	AffinityOptions
+	ConnectionReapOptions
+	AcceptLoopOptions
+	FingerprintOptions
+	HandshakeLimitOptions
+	AccessLogOptions
+	CompressionOptions
+	ShutdownOptions
 }
 
 // Default provides defaults for all necessary values
 func (options *Options) Default() {
 	options.TimeoutOptions.Default()
 	options.TlsVersionOptions.Default()
+	options.TlsProfileOptions.Default()
+	options.ClientCaOptions.Default()
+	options.BalanceOptions.Default()
+	options.AffinityOptions.Default()
+	options.ConnectionReapOptions.Default()
+	options.AcceptLoopOptions.Default()
+	options.FingerprintOptions.Default()
+	options.HandshakeLimitOptions.Default()
+	options.AccessLogOptions.Default()
+	options.CompressionOptions.Default()
+	options.ShutdownOptions.Default()
 }
 
 // Parse parses a configuration map
@@ -193,6 +405,51 @@ func (options *Options) Parse(optionsMap map[interface{}]interface{}) error {
 		return fmt.Errorf("error parsing options: %v", err)
 	}
 
+	if err := options.TlsProfileOptions.Parse(optionsMap); err != nil {
+		return fmt.Errorf("error parsing options: %v", err)
+	}
+	options.TlsProfileOptions.applyVersionDefaults(&options.TlsVersionOptions)
+
+	if err := options.ClientCaOptions.Parse(optionsMap); err != nil {
+		return fmt.Errorf("error parsing options: %v", err)
+	}
+
+	if err := options.BalanceOptions.Parse(optionsMap); err != nil {
+		return fmt.Errorf("error parsing options: %v", err)
+	}
+
+	if err := options.AffinityOptions.Parse(optionsMap); err != nil {
+		return fmt.Errorf("error parsing options: %v", err)
+	}
+
+	if err := options.ConnectionReapOptions.Parse(optionsMap); err != nil {
+		return fmt.Errorf("error parsing options: %v", err)
+	}
+
+	if err := options.AcceptLoopOptions.Parse(optionsMap); err != nil {
+		return fmt.Errorf("error parsing options: %v", err)
+	}
+
+	if err := options.FingerprintOptions.Parse(optionsMap); err != nil {
+		return fmt.Errorf("error parsing options: %v", err)
+	}
+
+	if err := options.HandshakeLimitOptions.Parse(optionsMap); err != nil {
+		return fmt.Errorf("error parsing options: %v", err)
+	}
+
+	if err := options.AccessLogOptions.Parse(optionsMap); err != nil {
+		return fmt.Errorf("error parsing options: %v", err)
+	}
+
+	if err := options.CompressionOptions.Parse(optionsMap); err != nil {
+		return fmt.Errorf("error parsing options: %v", err)
+	}
+
+	if err := options.ShutdownOptions.Parse(optionsMap); err != nil {
+		return fmt.Errorf("error parsing options: %v", err)
+	}
+
 	return nil
 }
 
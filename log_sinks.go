@@ -0,0 +1,155 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/xweb/v2/logsink"
+	"sync"
+	"time"
+)
+
+type configuredSink struct {
+	sink  logsink.Sink
+	kinds map[string]struct{}
+}
+
+// LogSinks routes access and audit records to the logsink.Sink's configured for an Instance. If no sinks are
+// configured for a kind of record, records are written to the process logger.
+type LogSinks struct {
+	lock   sync.RWMutex
+	sinks  []*configuredSink
+	logger logsink.Sink
+}
+
+// NewLogSinks creates a LogSinks with no sinks
+func NewLogSinks() *LogSinks {
+	return &LogSinks{
+		logger: logsink.NewLoggerSink(),
+	}
+}
+
+// Build creates and adds a sink for every config
+func (logSinks *LogSinks) Build(configs []*logsink.Config) error {
+	for i, config := range configs {
+		sink, err := config.Build()
+
+		if err != nil {
+			return fmt.Errorf("could not build log sink [%d] of type %s: %v", i, config.Type, err)
+		}
+
+		logSinks.AddSink(sink, config.Kinds)
+	}
+
+	return nil
+}
+
+// AddSink adds a sink that will receive records of the given kinds (logsink.KindAccess, logsink.KindAudit)
+func (logSinks *LogSinks) AddSink(sink logsink.Sink, kinds map[string]struct{}) {
+	logSinks.lock.Lock()
+	defer logSinks.lock.Unlock()
+
+	logSinks.sinks = append(logSinks.sinks, &configuredSink{
+		sink:  sink,
+		kinds: kinds,
+	})
+}
+
+// Write sends a record to all sinks that accept its kind
+func (logSinks *LogSinks) Write(record *logsink.Record) {
+	logSinks.lock.RLock()
+	defer logSinks.lock.RUnlock()
+
+	written := false
+	for _, configured := range logSinks.sinks {
+		if _, ok := configured.kinds[record.Kind]; ok {
+			configured.sink.Write(record)
+			written = true
+		}
+	}
+
+	if !written {
+		logSinks.logger.Write(record)
+	}
+}
+
+// Access writes an AccessLogEntry
+func (logSinks *LogSinks) Access(entry *AccessLogEntry) {
+	fields := map[string]interface{}{
+		"server":     entry.Server,
+		"bindPoint":  entry.BindPoint,
+		"binding":    entry.Binding,
+		"name":       entry.Name,
+		"remoteAddr": entry.RemoteAddr,
+		"method":     entry.Method,
+		"path":       entry.Path,
+		"proto":      entry.Proto,
+		"status":     entry.Status,
+		"bytes":      entry.Bytes,
+		"duration":   entry.Duration.String(),
+		"userAgent":  entry.UserAgent,
+	}
+
+	if entry.Ja3 != "" {
+		fields["ja3"] = entry.Ja3
+		fields["ja4"] = entry.Ja4
+	}
+
+	for name, value := range entry.Headers {
+		fields["header."+name] = value
+	}
+
+	logSinks.Write(&logsink.Record{
+		Time:    entry.Time,
+		Kind:    logsink.KindAccess,
+		Message: "access",
+		Fields:  fields,
+	})
+}
+
+// Audit writes an audit record for an administrative action (i.e. disabling an api)
+func (logSinks *LogSinks) Audit(action string, fields map[string]interface{}) {
+	auditFields := map[string]interface{}{
+		"action": action,
+	}
+
+	for key, value := range fields {
+		auditFields[key] = value
+	}
+
+	logSinks.Write(&logsink.Record{
+		Time:    time.Now(),
+		Kind:    logsink.KindAudit,
+		Message: action,
+		Fields:  auditFields,
+	})
+}
+
+// Close flushes and closes all sinks
+func (logSinks *LogSinks) Close() {
+	logSinks.lock.Lock()
+	sinks := logSinks.sinks
+	logSinks.sinks = nil
+	logSinks.lock.Unlock()
+
+	for _, configured := range sinks {
+		if err := configured.sink.Close(); err != nil {
+			pfxlog.Logger().Errorf("error closing log sink: %v", err)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package logsink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/xweb/v2/bufpool"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	FormatJson = "json"
+	FormatOtlp = "otlp"
+
+	DefaultHttpBatchSize     = 100
+	DefaultHttpFlushInterval = 5 * time.Second
+	DefaultHttpTimeout       = 10 * time.Second
+	DefaultHttpServiceName   = "xweb"
+)
+
+// HttpConfig configures a HttpSink
+type HttpConfig struct {
+	Url           string
+	Format        string
+	Headers       map[string]string
+	BatchSize     int
+	BufferSize    int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+	ServiceName   string
+}
+
+// Parse parses a config map
+func (config *HttpConfig) Parse(configMap map[interface{}]interface{}) error {
+	config.Format = FormatJson
+	config.BatchSize = DefaultHttpBatchSize
+	config.BufferSize = DefaultBufferSize
+	config.FlushInterval = DefaultHttpFlushInterval
+	config.Timeout = DefaultHttpTimeout
+	config.ServiceName = DefaultHttpServiceName
+	config.Headers = map[string]string{}
+
+	for field, target := range map[string]*string{"url": &config.Url, "format": &config.Format, "serviceName": &config.ServiceName} {
+		if err := parseString(configMap, field, target); err != nil {
+			return err
+		}
+	}
+
+	for field, target := range map[string]*int{"batchSize": &config.BatchSize, "bufferSize": &config.BufferSize} {
+		if err := parseInt(configMap, field, target); err != nil {
+			return err
+		}
+	}
+
+	for field, target := range map[string]*time.Duration{"flushInterval": &config.FlushInterval, "timeout": &config.Timeout} {
+		if err := parseDuration(configMap, field, target); err != nil {
+			return err
+		}
+	}
+
+	if headersInterface, ok := configMap["headers"]; ok {
+		headersMap, ok := headersInterface.(map[interface{}]interface{})
+		if !ok {
+			return errors.New("headers must be a map")
+		}
+		for name, value := range headersMap {
+			config.Headers[fmt.Sprint(name)] = fmt.Sprint(value)
+		}
+	}
+
+	if parsedUrl, err := url.Parse(config.Url); err != nil || (parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https") {
+		return fmt.Errorf("url [%s] must be a valid http or https url", config.Url)
+	}
+
+	if config.Format != FormatJson && config.Format != FormatOtlp {
+		return fmt.Errorf("invalid format [%s], must be one of: %s, %s", config.Format, FormatJson, FormatOtlp)
+	}
+
+	if config.BatchSize < 1 || config.BufferSize < 1 {
+		return errors.New("batchSize and bufferSize must be positive")
+	}
+
+	if config.FlushInterval <= 0 || config.Timeout <= 0 {
+		return errors.New("flushInterval and timeout must be positive")
+	}
+
+	return nil
+}
+
+// HttpSink buffers records and POSTs them in batches as a JSON array (FormatJson) or as an OTLP/HTTP JSON logs export
+// request (FormatOtlp), which is accepted by OpenTelemetry collectors on /v1/logs.
+type HttpSink struct {
+	config    *HttpConfig
+	client    *gmhttp.Client
+	records   chan *Record
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+var _ Sink = &HttpSink{}
+
+// NewHttpSink creates a HttpSink and begins processing records
+func NewHttpSink(config *HttpConfig) (*HttpSink, error) {
+	sink := &HttpSink{
+		config: config,
+		client: &gmhttp.Client{
+			Timeout: config.Timeout,
+		},
+		records: make(chan *Record, config.BufferSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go sink.run()
+
+	return sink, nil
+}
+
+// Write queues a record, dropping it if the buffer is full or the sink has been closed
+func (sink *HttpSink) Write(record *Record) {
+	select {
+	case <-sink.stop:
+		return
+	default:
+	}
+
+	select {
+	case sink.records <- record:
+	default:
+	}
+}
+
+// Close flushes queued records and stops the sink. Records written during or after Close are dropped.
+func (sink *HttpSink) Close() error {
+	sink.closeOnce.Do(func() {
+		close(sink.stop)
+	})
+	<-sink.done
+	return nil
+}
+
+func (sink *HttpSink) run() {
+	defer close(sink.done)
+
+	ticker := time.NewTicker(sink.config.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []*Record
+
+	add := func(record *Record) {
+		batch = append(batch, record)
+		if len(batch) >= sink.config.BatchSize {
+			sink.flush(batch)
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case record := <-sink.records:
+			add(record)
+		case <-ticker.C:
+			sink.flush(batch)
+			batch = nil
+		case <-sink.stop:
+			for {
+				select {
+				case record := <-sink.records:
+					add(record)
+				default:
+					sink.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (sink *HttpSink) flush(batch []*Record) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// the pooled buffer is only used for encoding. The transport may read the request body after Do returns, i.e. when
+	// the server responds before the body is written or when it is retried, so it is sent a copy.
+	body := bufpool.AccessLog.Get()
+	defer bufpool.AccessLog.Put(body)
+
+	var err error
+
+	if sink.config.Format == FormatOtlp {
+		err = json.NewEncoder(body).Encode(sink.otlpPayload(batch))
+	} else {
+		err = json.NewEncoder(body).Encode(jsonPayload(batch))
+	}
+
+	if err != nil {
+		pfxlog.Logger().Errorf("http log sink could not marshal %d records: %v", len(batch), err)
+		return
+	}
+
+	payload := append([]byte(nil), body.Bytes()...)
+	request, err := gmhttp.NewRequest(gmhttp.MethodPost, sink.config.Url, bytes.NewReader(payload))
+
+	if err != nil {
+		pfxlog.Logger().Errorf("http log sink could not create request: %v", err)
+		return
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range sink.config.Headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := sink.client.Do(request)
+
+	if err != nil {
+		pfxlog.Logger().Errorf("http log sink dropped %d records, could not send to %s: %v", len(batch), sink.config.Url, err)
+		return
+	}
+
+	_, _ = io.Copy(io.Discard, response.Body)
+	_ = response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		pfxlog.Logger().Errorf("http log sink dropped %d records, %s responded with status %d", len(batch), sink.config.Url, response.StatusCode)
+	}
+}
+
+func jsonPayload(batch []*Record) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, record := range batch {
+		entry := map[string]interface{}{}
+		for key, value := range record.Fields {
+			entry[key] = value
+		}
+		entry["time"] = record.Time.UTC().Format(time.RFC3339Nano)
+		entry["kind"] = record.Kind
+		entry["message"] = record.Message
+		result = append(result, entry)
+	}
+	return result
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func newOtlpAttribute(key string, value interface{}) otlpAttribute {
+	result := otlpAttribute{Key: key}
+
+	switch typedValue := value.(type) {
+	case bool:
+		result.Value.BoolValue = &typedValue
+	case int:
+		intStr := strconv.FormatInt(int64(typedValue), 10)
+		result.Value.IntValue = &intStr
+	case int64:
+		intStr := strconv.FormatInt(typedValue, 10)
+		result.Value.IntValue = &intStr
+	default:
+		str := fmt.Sprint(value)
+		result.Value.StringValue = &str
+	}
+
+	return result
+}
+
+func (sink *HttpSink) otlpPayload(batch []*Record) interface{} {
+	var logRecords []interface{}
+
+	for _, record := range batch {
+		var keys []string
+		for key := range record.Fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		attributes := []otlpAttribute{newOtlpAttribute("xweb.kind", record.Kind)}
+		for _, key := range keys {
+			attributes = append(attributes, newOtlpAttribute(key, record.Fields[key]))
+		}
+
+		message := record.Message
+		logRecords = append(logRecords, map[string]interface{}{
+			"timeUnixNano":   strconv.FormatInt(record.Time.UnixNano(), 10),
+			"severityNumber": 9, //INFO
+			"severityText":   "INFO",
+			"body":           otlpValue{StringValue: &message},
+			"attributes":     attributes,
+		})
+	}
+
+	return map[string]interface{}{
+		"resourceLogs": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttribute{newOtlpAttribute("service.name", sink.config.ServiceName)},
+				},
+				"scopeLogs": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{
+							"name": "xweb",
+						},
+						"logRecords": logRecords,
+					},
+				},
+			},
+		},
+	}
+}
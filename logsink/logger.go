@@ -0,0 +1,39 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package logsink
+
+import (
+	"github.com/michaelquigley/pfxlog"
+	"github.com/sirupsen/logrus"
+)
+
+// LoggerSink writes records to the process logger at info level. It is used when no sinks are configured.
+type LoggerSink struct{}
+
+var _ Sink = &LoggerSink{}
+
+func NewLoggerSink() *LoggerSink {
+	return &LoggerSink{}
+}
+
+func (sink *LoggerSink) Write(record *Record) {
+	pfxlog.Logger().WithFields(logrus.Fields(record.Fields)).WithField("kind", record.Kind).Info(record.Message)
+}
+
+func (sink *LoggerSink) Close() error {
+	return nil
+}
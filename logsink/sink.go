@@ -0,0 +1,160 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package logsink provides destinations for xweb access and audit records: the process logger, syslog (RFC 5424), and
+// a buffered HTTP shipper that supports plain JSON and OTLP/HTTP JSON payloads. Sinks that send over the network do
+// so asynchronously and drop records rather than block request processing when their buffers are full.
+package logsink
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	KindAccess = "access"
+	KindAudit  = "audit"
+
+	TypeLogger = "logger"
+	TypeSyslog = "syslog"
+	TypeHttp   = "http"
+
+	DefaultBufferSize = 1024
+)
+
+// Record is a single access or audit record
+type Record struct {
+	Time    time.Time
+	Kind    string
+	Message string
+	Fields  map[string]interface{}
+}
+
+// Sink receives records. Write must not block for extended periods of time.
+type Sink interface {
+	Write(record *Record)
+	Close() error
+}
+
+// Config is the parsed configuration for a Sink
+type Config struct {
+	Type  string
+	Kinds map[string]struct{}
+
+	Syslog *SyslogConfig
+	Http   *HttpConfig
+}
+
+// Accepts returns true if the sink should receive records of kind
+func (config *Config) Accepts(kind string) bool {
+	_, ok := config.Kinds[kind]
+	return ok
+}
+
+// Parse parses a sink config map. The `type` field selects the sink type and `kinds` is an optional array of record
+// kinds (access, audit) to send to the sink, defaulting to all kinds.
+func (config *Config) Parse(configMap map[interface{}]interface{}) error {
+	if sinkType, ok := configMap["type"].(string); ok {
+		config.Type = sinkType
+	} else {
+		return errors.New("type is required and must be a string")
+	}
+
+	config.Kinds = map[string]struct{}{}
+
+	if kindsInterface, ok := configMap["kinds"]; ok {
+		kinds, ok := kindsInterface.([]interface{})
+		if !ok {
+			return errors.New("kinds must be an array")
+		}
+
+		for i, kindInterface := range kinds {
+			kind, ok := kindInterface.(string)
+			if !ok || (kind != KindAccess && kind != KindAudit) {
+				return fmt.Errorf("kinds[%d] must be one of: %s, %s", i, KindAccess, KindAudit)
+			}
+			config.Kinds[kind] = struct{}{}
+		}
+	} else {
+		config.Kinds[KindAccess] = struct{}{}
+		config.Kinds[KindAudit] = struct{}{}
+	}
+
+	switch config.Type {
+	case TypeLogger:
+		return nil
+	case TypeSyslog:
+		config.Syslog = &SyslogConfig{}
+		return config.Syslog.Parse(configMap)
+	case TypeHttp:
+		config.Http = &HttpConfig{}
+		return config.Http.Parse(configMap)
+	}
+
+	return fmt.Errorf("unknown type [%s], must be one of: %s, %s, %s", config.Type, TypeLogger, TypeSyslog, TypeHttp)
+}
+
+// Build creates the Sink described by this Config
+func (config *Config) Build() (Sink, error) {
+	switch config.Type {
+	case TypeLogger:
+		return NewLoggerSink(), nil
+	case TypeSyslog:
+		return NewSyslogSink(config.Syslog)
+	case TypeHttp:
+		return NewHttpSink(config.Http)
+	}
+
+	return nil, fmt.Errorf("unknown type [%s]", config.Type)
+}
+
+func parseDuration(configMap map[interface{}]interface{}, field string, target *time.Duration) error {
+	if interfaceVal, ok := configMap[field]; ok {
+		if durationStr, ok := interfaceVal.(string); ok {
+			if duration, err := time.ParseDuration(durationStr); err == nil {
+				*target = duration
+			} else {
+				return fmt.Errorf("could not parse %s %s as a duration (e.g. 1m): %v", field, durationStr, err)
+			}
+		} else {
+			return fmt.Errorf("could not use value for %s, not a string", field)
+		}
+	}
+	return nil
+}
+
+func parseInt(configMap map[interface{}]interface{}, field string, target *int) error {
+	if interfaceVal, ok := configMap[field]; ok {
+		if value, ok := interfaceVal.(int); ok {
+			*target = value
+		} else {
+			return fmt.Errorf("could not use value for %s, not an integer", field)
+		}
+	}
+	return nil
+}
+
+func parseString(configMap map[interface{}]interface{}, field string, target *string) error {
+	if interfaceVal, ok := configMap[field]; ok {
+		if value, ok := interfaceVal.(string); ok {
+			*target = value
+		} else {
+			return fmt.Errorf("could not use value for %s, not a string", field)
+		}
+	}
+	return nil
+}
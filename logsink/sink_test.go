@@ -0,0 +1,195 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package logsink
+
+import (
+	"bytes"
+	"encoding/json"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig_Parse(t *testing.T) {
+	t.Run("defaults kinds to all kinds", func(t *testing.T) {
+		req := require.New(t)
+		config := &Config{}
+		req.NoError(config.Parse(map[interface{}]interface{}{"type": TypeLogger}))
+		req.True(config.Accepts(KindAccess))
+		req.True(config.Accepts(KindAudit))
+	})
+
+	t.Run("restricts kinds", func(t *testing.T) {
+		req := require.New(t)
+		config := &Config{}
+		req.NoError(config.Parse(map[interface{}]interface{}{"type": TypeLogger, "kinds": []interface{}{KindAudit}}))
+		req.False(config.Accepts(KindAccess))
+		req.True(config.Accepts(KindAudit))
+	})
+
+	t.Run("rejects unknown types", func(t *testing.T) {
+		req := require.New(t)
+		config := &Config{}
+		req.Error(config.Parse(map[interface{}]interface{}{"type": "kafka"}))
+	})
+
+	t.Run("requires a syslog address", func(t *testing.T) {
+		req := require.New(t)
+		config := &Config{}
+		req.Error(config.Parse(map[interface{}]interface{}{"type": TypeSyslog}))
+	})
+
+	t.Run("rejects unknown http formats", func(t *testing.T) {
+		req := require.New(t)
+		config := &Config{}
+		req.Error(config.Parse(map[interface{}]interface{}{"type": TypeHttp, "url": "https://logs.example.com", "format": "xml"}))
+	})
+}
+
+func TestSyslogSink(t *testing.T) {
+	t.Run("formats records as rfc 5424", func(t *testing.T) {
+		req := require.New(t)
+		sink := &SyslogSink{config: &SyslogConfig{Hostname: "host", AppName: "xweb", Facility: 16}}
+		record := &Record{
+			Time:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			Kind:    KindAudit,
+			Message: "api.disable",
+			Fields:  map[string]interface{}{"name": `a"b]`, "action": "api.disable"},
+		}
+
+		buffer := &bytes.Buffer{}
+		sink.format(record, buffer)
+		message := buffer.String()
+		req.True(strings.HasPrefix(message, "<134>1 2024-01-02T03:04:05Z host xweb "), message)
+		req.True(strings.HasSuffix(message, ` audit [xweb@32473 action="api.disable" name="a\"b\]"] api.disable`), message)
+	})
+
+	t.Run("sends records over udp", func(t *testing.T) {
+		req := require.New(t)
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+
+		config := &SyslogConfig{}
+		req.NoError(config.Parse(map[interface{}]interface{}{"network": "udp", "address": conn.LocalAddr().String()}))
+
+		sink, err := NewSyslogSink(config)
+		req.NoError(err)
+
+		sink.Write(&Record{Time: time.Now(), Kind: KindAccess, Message: "access"})
+		req.NoError(sink.Close())
+
+		buf := make([]byte, 2048)
+		req.NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		req.NoError(err)
+		req.Contains(string(buf[:n]), " access - access")
+	})
+
+	t.Run("writes during and after close are dropped", func(t *testing.T) {
+		req := require.New(t)
+		config := &SyslogConfig{}
+		req.NoError(config.Parse(map[interface{}]interface{}{"network": "udp", "address": "127.0.0.1:9"}))
+
+		sink, err := NewSyslogSink(config)
+		req.NoError(err)
+
+		writing := make(chan struct{})
+		go func() {
+			defer close(writing)
+			for i := 0; i < 1000; i++ {
+				sink.Write(&Record{Time: time.Now(), Kind: KindAccess, Message: "access"})
+			}
+		}()
+
+		req.NoError(sink.Close())
+		<-writing
+		sink.Write(&Record{Time: time.Now(), Kind: KindAccess, Message: "access"})
+	})
+}
+
+func TestHttpSink(t *testing.T) {
+	t.Run("queued records are sent on close and later writes are dropped", func(t *testing.T) {
+		req := require.New(t)
+		received := make(chan []map[string]interface{}, 10)
+		server := gmhttptest.NewServer(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			var batch []map[string]interface{}
+			if err := json.NewDecoder(request.Body).Decode(&batch); err == nil {
+				received <- batch
+			}
+		}))
+		defer server.Close()
+
+		config := &HttpConfig{}
+		req.NoError(config.Parse(map[interface{}]interface{}{"url": server.URL, "flushInterval": "1h"}))
+
+		sink, err := NewHttpSink(config)
+		req.NoError(err)
+
+		sink.Write(&Record{Time: time.Now(), Kind: KindAccess, Message: "access"})
+		sink.Write(&Record{Time: time.Now(), Kind: KindAudit, Message: "audit"})
+		req.NoError(sink.Close())
+		sink.Write(&Record{Time: time.Now(), Kind: KindAccess, Message: "dropped"})
+		req.NoError(sink.Close())
+
+		select {
+		case batch := <-received:
+			req.Len(batch, 2)
+			req.Equal("access", batch[0]["message"])
+			req.Equal("audit", batch[1]["message"])
+		case <-time.After(5 * time.Second):
+			req.Fail("batch was not sent")
+		}
+		req.Empty(received)
+	})
+	t.Run("request bodies are not reused while the transport may still read them", func(t *testing.T) {
+		req := require.New(t)
+		config := &HttpConfig{}
+		req.NoError(config.Parse(map[interface{}]interface{}{"url": "http://127.0.0.1:1", "flushInterval": "1h"}))
+
+		sink, err := NewHttpSink(config)
+		req.NoError(err)
+		defer func() { _ = sink.Close() }()
+
+		// responds without reading the body, leaving it to be read after Do returns
+		var bodies []io.Reader
+		sink.client.Transport = roundTripperFunc(func(request *gmhttp.Request) (*gmhttp.Response, error) {
+			bodies = append(bodies, request.Body)
+			return &gmhttp.Response{StatusCode: gmhttp.StatusOK, Body: io.NopCloser(strings.NewReader("")), Request: request}, nil
+		})
+
+		sink.flush([]*Record{{Time: time.Now(), Kind: KindAccess, Message: "first"}})
+		sink.flush([]*Record{{Time: time.Now(), Kind: KindAccess, Message: "second"}})
+		req.Len(bodies, 2)
+
+		var batch []map[string]interface{}
+		req.NoError(json.NewDecoder(bodies[0]).Decode(&batch))
+		req.Len(batch, 1)
+		req.Equal("first", batch[0]["message"])
+	})
+}
+
+type roundTripperFunc func(request *gmhttp.Request) (*gmhttp.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *gmhttp.Request) (*gmhttp.Response, error) {
+	return f(request)
+}
@@ -0,0 +1,294 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package logsink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/xweb/v2/bufpool"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSyslogNetwork  = "udp"
+	DefaultSyslogAppName  = "xweb"
+	DefaultSyslogFacility = 16 //local0
+
+	syslogSeverityInfo = 6
+	syslogVersion      = 1
+
+	// syslogSdId is the structured data id used for record fields, 32473 is the IANA example enterprise number
+	syslogSdId = "xweb@32473"
+)
+
+// SyslogConfig configures a SyslogSink
+type SyslogConfig struct {
+	Network    string
+	Address    string
+	AppName    string
+	Hostname   string
+	Facility   int
+	BufferSize int
+}
+
+// Parse parses a config map
+func (config *SyslogConfig) Parse(configMap map[interface{}]interface{}) error {
+	config.Network = DefaultSyslogNetwork
+	config.AppName = DefaultSyslogAppName
+	config.Facility = DefaultSyslogFacility
+	config.BufferSize = DefaultBufferSize
+
+	for field, target := range map[string]*string{"network": &config.Network, "address": &config.Address, "appName": &config.AppName, "hostname": &config.Hostname} {
+		if err := parseString(configMap, field, target); err != nil {
+			return err
+		}
+	}
+
+	if err := parseInt(configMap, "facility", &config.Facility); err != nil {
+		return err
+	}
+
+	if err := parseInt(configMap, "bufferSize", &config.BufferSize); err != nil {
+		return err
+	}
+
+	if config.Network != "udp" && config.Network != "tcp" && config.Network != "unix" && config.Network != "unixgram" {
+		return fmt.Errorf("invalid network [%s], must be one of: udp, tcp, unix, unixgram", config.Network)
+	}
+
+	if config.Address == "" {
+		return errors.New("address is required")
+	}
+
+	if config.Facility < 0 || config.Facility > 23 {
+		return errors.New("facility must be between 0 and 23")
+	}
+
+	if config.BufferSize < 1 {
+		return errors.New("bufferSize must be positive")
+	}
+
+	return nil
+}
+
+// SyslogSink sends records to a syslog server formatted per RFC 5424. Record fields are sent as structured data.
+// Stream based networks use octet counting framing (RFC 6587).
+type SyslogSink struct {
+	config    *SyslogConfig
+	records   chan *Record
+	conn      net.Conn
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+var _ Sink = &SyslogSink{}
+
+// NewSyslogSink creates a SyslogSink and begins processing records
+func NewSyslogSink(config *SyslogConfig) (*SyslogSink, error) {
+	if config.Hostname == "" {
+		if hostname, err := os.Hostname(); err == nil {
+			config.Hostname = hostname
+		} else {
+			config.Hostname = "-"
+		}
+	}
+
+	sink := &SyslogSink{
+		config:  config,
+		records: make(chan *Record, config.BufferSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go sink.run()
+
+	return sink, nil
+}
+
+// Write queues a record, dropping it if the buffer is full or the sink has been closed
+func (sink *SyslogSink) Write(record *Record) {
+	select {
+	case <-sink.stop:
+		return
+	default:
+	}
+
+	select {
+	case sink.records <- record:
+	default:
+	}
+}
+
+// Close flushes queued records and closes the connection. Records written during or after Close are dropped.
+func (sink *SyslogSink) Close() error {
+	sink.closeOnce.Do(func() {
+		close(sink.stop)
+	})
+	<-sink.done
+	return nil
+}
+
+func (sink *SyslogSink) run() {
+	defer close(sink.done)
+	defer func() {
+		if sink.conn != nil {
+			_ = sink.conn.Close()
+		}
+	}()
+
+	failing := false
+
+	for {
+		var record *Record
+
+		select {
+		case record = <-sink.records:
+		case <-sink.stop:
+			select {
+			case record = <-sink.records:
+			default:
+				return
+			}
+		}
+
+		buffer := bufpool.AccessLog.Get()
+		sink.format(record, buffer)
+		err := sink.send(buffer)
+		bufpool.AccessLog.Put(buffer)
+
+		if err != nil {
+			if !failing {
+				pfxlog.Logger().Errorf("syslog sink could not send to %s://%s, records will be dropped until it recovers: %v", sink.config.Network, sink.config.Address, err)
+			}
+			failing = true
+		} else {
+			failing = false
+		}
+	}
+}
+
+func (sink *SyslogSink) send(message *bytes.Buffer) error {
+	if sink.conn == nil {
+		conn, err := net.DialTimeout(sink.config.Network, sink.config.Address, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		sink.conn = conn
+	}
+
+	_ = sink.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+
+	var err error
+	if sink.config.Network == "tcp" || sink.config.Network == "unix" {
+		frame := []byte(strconv.Itoa(message.Len()) + " ")
+		_, err = (&net.Buffers{frame, message.Bytes()}).WriteTo(sink.conn)
+	} else {
+		_, err = sink.conn.Write(message.Bytes())
+	}
+
+	if err != nil {
+		_ = sink.conn.Close()
+		sink.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+// format renders a record as an RFC 5424 message:
+// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (sink *SyslogSink) format(record *Record, builder *bytes.Buffer) {
+	_, _ = fmt.Fprintf(builder, "<%d>%d %s %s %s %d %s ",
+		sink.config.Facility*8+syslogSeverityInfo,
+		syslogVersion,
+		record.Time.UTC().Format(time.RFC3339Nano),
+		syslogHeaderValue(sink.config.Hostname),
+		syslogHeaderValue(sink.config.AppName),
+		os.Getpid(),
+		syslogHeaderValue(record.Kind),
+	)
+
+	if len(record.Fields) == 0 {
+		builder.WriteString("-")
+	} else {
+		var keys []string
+		for key := range record.Fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		builder.WriteString("[" + syslogSdId)
+		for _, key := range keys {
+			builder.WriteString(" ")
+			builder.WriteString(syslogParamName(key))
+			builder.WriteString(`="`)
+			builder.WriteString(syslogParamValue(fmt.Sprint(record.Fields[key])))
+			builder.WriteString(`"`)
+		}
+		builder.WriteString("]")
+	}
+
+	if record.Message != "" {
+		builder.WriteString(" ")
+		builder.WriteString(record.Message)
+	}
+}
+
+// syslogHeaderValue returns "-" for empty values and replaces characters not allowed in header fields
+func syslogHeaderValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// syslogParamName removes characters not allowed in structured data parameter names
+func syslogParamName(name string) string {
+	result := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 || r == '=' || r == ' ' || r == ']' || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+
+	if len(result) > 32 {
+		result = result[:32]
+	}
+
+	return result
+}
+
+var syslogParamValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogParamValue escapes structured data parameter values
+func syslogParamValue(value string) string {
+	return syslogParamValueReplacer.Replace(value)
+}
@@ -0,0 +1,261 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package metrics provides a small, dependency free, metrics registry used by xweb components. Metrics are identified
+// by a name and a set of labels. Embedding applications may read all values via Registry.Snapshot and forward them to
+// the monitoring system of their choice.
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	TypeCounter = "counter"
+	TypeGauge   = "gauge"
+	TypeTimer   = "timer"
+)
+
+// Labels are key/value pairs that distinguish metrics with the same name (i.e. binding, server, instance name)
+type Labels map[string]string
+
+// Registry creates and tracks metrics
+type Registry interface {
+	Counter(name string, labels Labels) Counter
+	Gauge(name string, labels Labels) Gauge
+	Timer(name string, labels Labels) Timer
+	Snapshot() []*Sample
+}
+
+// Counter is a monotonically increasing value
+type Counter interface {
+	Inc(delta int64)
+	Count() int64
+}
+
+// Gauge is a value that may go up and down
+type Gauge interface {
+	Set(value int64)
+	Add(delta int64)
+	Value() int64
+}
+
+// Timer tracks the count, total, and maximum of a duration
+type Timer interface {
+	Update(duration time.Duration)
+	UpdateSince(start time.Time)
+	Count() int64
+	Total() time.Duration
+	Max() time.Duration
+}
+
+// Sample is a point in time reading of a single metric
+type Sample struct {
+	Name   string `json:"name"`
+	Labels Labels `json:"labels,omitempty"`
+	Type   string `json:"type"`
+	Value  int64  `json:"value"`
+	Total  int64  `json:"total,omitempty"`
+	Max    int64  `json:"max,omitempty"`
+}
+
+// NewRegistry returns an in memory Registry
+func NewRegistry() Registry {
+	return &registryImpl{
+		metrics: map[string]*entry{},
+	}
+}
+
+type entry struct {
+	name   string
+	labels Labels
+	metric interface{}
+}
+
+type registryImpl struct {
+	lock    sync.Mutex
+	metrics map[string]*entry
+}
+
+func (registry *registryImpl) Counter(name string, labels Labels) Counter {
+	return registry.getOrCreate(name, labels, func() interface{} { return &counterImpl{} }).(Counter)
+}
+
+func (registry *registryImpl) Gauge(name string, labels Labels) Gauge {
+	return registry.getOrCreate(name, labels, func() interface{} { return &gaugeImpl{} }).(Gauge)
+}
+
+func (registry *registryImpl) Timer(name string, labels Labels) Timer {
+	return registry.getOrCreate(name, labels, func() interface{} { return &timerImpl{} }).(Timer)
+}
+
+// getOrCreate returns the existing metric for name and labels or stores the result of newMetric. Requesting the same
+// name and labels with a different metric type will panic as it is a programming error.
+func (registry *registryImpl) getOrCreate(name string, labels Labels, newMetric func() interface{}) interface{} {
+	key := metricKey(name, labels)
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if existing, ok := registry.metrics[key]; ok {
+		return existing.metric
+	}
+
+	copiedLabels := Labels{}
+	for k, v := range labels {
+		copiedLabels[k] = v
+	}
+
+	result := &entry{
+		name:   name,
+		labels: copiedLabels,
+		metric: newMetric(),
+	}
+
+	registry.metrics[key] = result
+
+	return result.metric
+}
+
+// Snapshot returns the current value of all metrics sorted by name and labels
+func (registry *registryImpl) Snapshot() []*Sample {
+	registry.lock.Lock()
+	var keys []string
+	entries := map[string]*entry{}
+	for key, e := range registry.metrics {
+		keys = append(keys, key)
+		entries[key] = e
+	}
+	registry.lock.Unlock()
+
+	sort.Strings(keys)
+
+	var result []*Sample
+	for _, key := range keys {
+		e := entries[key]
+		sample := &Sample{
+			Name:   e.name,
+			Labels: e.labels,
+		}
+
+		switch metric := e.metric.(type) {
+		case *counterImpl:
+			sample.Type = TypeCounter
+			sample.Value = metric.Count()
+		case *gaugeImpl:
+			sample.Type = TypeGauge
+			sample.Value = metric.Value()
+		case *timerImpl:
+			sample.Type = TypeTimer
+			sample.Value = metric.Count()
+			sample.Total = int64(metric.Total())
+			sample.Max = int64(metric.Max())
+		}
+
+		result = append(result, sample)
+	}
+
+	return result
+}
+
+func metricKey(name string, labels Labels) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	var labelKeys []string
+	for k := range labels {
+		labelKeys = append(labelKeys, k)
+	}
+	sort.Strings(labelKeys)
+
+	builder := strings.Builder{}
+	builder.WriteString(name)
+	for _, k := range labelKeys {
+		builder.WriteString("|")
+		builder.WriteString(k)
+		builder.WriteString("=")
+		builder.WriteString(labels[k])
+	}
+
+	return builder.String()
+}
+
+type counterImpl struct {
+	count int64
+}
+
+func (c *counterImpl) Inc(delta int64) {
+	atomic.AddInt64(&c.count, delta)
+}
+
+func (c *counterImpl) Count() int64 {
+	return atomic.LoadInt64(&c.count)
+}
+
+type gaugeImpl struct {
+	value int64
+}
+
+func (g *gaugeImpl) Set(value int64) {
+	atomic.StoreInt64(&g.value, value)
+}
+
+func (g *gaugeImpl) Add(delta int64) {
+	atomic.AddInt64(&g.value, delta)
+}
+
+func (g *gaugeImpl) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+type timerImpl struct {
+	count int64
+	total int64
+	max   int64
+}
+
+func (t *timerImpl) Update(duration time.Duration) {
+	atomic.AddInt64(&t.count, 1)
+	atomic.AddInt64(&t.total, int64(duration))
+
+	for {
+		current := atomic.LoadInt64(&t.max)
+		if int64(duration) <= current || atomic.CompareAndSwapInt64(&t.max, current, int64(duration)) {
+			return
+		}
+	}
+}
+
+func (t *timerImpl) UpdateSince(start time.Time) {
+	t.Update(time.Since(start))
+}
+
+func (t *timerImpl) Count() int64 {
+	return atomic.LoadInt64(&t.count)
+}
+
+func (t *timerImpl) Total() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.total))
+}
+
+func (t *timerImpl) Max() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.max))
+}
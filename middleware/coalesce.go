@@ -0,0 +1,343 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"strings"
+	"sync"
+)
+
+const (
+	// HttpHeaderCoalesced is set to "true" on responses shared from a request that was executed on behalf of others
+	HttpHeaderCoalesced = "X-Coalesced"
+
+	DefaultCoalesceMaxBodySize = 1 << 20
+)
+
+// DefaultCoalesceVaryHeaders are the request headers that distinguish otherwise identical requests by default, so
+// that responses are never shared between different credentials or negotiated representations
+var DefaultCoalesceVaryHeaders = []string{"Authorization", "Cookie", "Accept", "Accept-Encoding", "Accept-Language"}
+
+// CoalesceOptions configures a handler created by NewCoalesceHandler. Concurrent GET requests with the same path,
+// query, VaryHeaders values and TLS client certificate are coalesced: the first executes the handler and the others
+// wait for and receive a copy of its response.
+type CoalesceOptions struct {
+	// Paths are the path prefixes whose requests are coalesced, all paths if empty
+	Paths []string
+
+	// VaryHeaders are request headers whose values are part of the coalescing key
+	VaryHeaders []string
+
+	// MaxBodySize bounds the response bodies held in memory to be shared. Waiting requests for responses that
+	// exceed it execute the handler themselves.
+	MaxBodySize int64
+}
+
+// Default provides defaults for all necessary values
+func (options *CoalesceOptions) Default() {
+	options.VaryHeaders = append([]string{}, DefaultCoalesceVaryHeaders...)
+	options.MaxBodySize = DefaultCoalesceMaxBodySize
+}
+
+// Parse parses a configuration map. paths and varyHeaders may be a string or a list of strings, varyHeaders replaces
+// the defaults.
+func (options *CoalesceOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["paths"]; ok {
+		paths, err := parseRewriteStrings(interfaceVal)
+		if err != nil {
+			return fmt.Errorf("could not use value for paths: %v", err)
+		}
+		options.Paths = paths
+	}
+
+	if interfaceVal, ok := config["varyHeaders"]; ok {
+		names, err := parseRewriteStrings(interfaceVal)
+		if err != nil {
+			return fmt.Errorf("could not use value for varyHeaders: %v", err)
+		}
+		options.VaryHeaders = nil
+		for _, name := range names {
+			options.VaryHeaders = append(options.VaryHeaders, gmhttp.CanonicalHeaderKey(name))
+		}
+	}
+
+	if interfaceVal, ok := config["maxBodySize"]; ok {
+		size, err := parseByteSize(interfaceVal)
+		if err != nil {
+			return fmt.Errorf("could not use value for maxBodySize: %v", err)
+		}
+		options.MaxBodySize = size
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (options *CoalesceOptions) Validate() error {
+	for _, path := range options.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("path [%s] must start with /", path)
+		}
+	}
+
+	for _, name := range options.VaryHeaders {
+		if !isRewriteHeaderName(name) {
+			return fmt.Errorf("invalid vary header name [%s]", name)
+		}
+	}
+
+	if options.MaxBodySize <= 0 {
+		return errors.New("maxBodySize must be greater than 0")
+	}
+
+	return nil
+}
+
+// NewCoalesceHandler returns a http.Handler that executes next once for concurrent identical GET requests and shares
+// the response with every request that arrived while it was in flight. Requests that are not GETs, do not match
+// Paths or ask to upgrade the connection are passed to next unchanged. Responses are not cached beyond the request
+// that produced them. Responses that set cookies, are marked private or no-store by Cache-Control, or were produced
+// for a request that was canceled are not shared, the waiting requests execute the handler themselves.
+func NewCoalesceHandler(options *CoalesceOptions, next gmhttp.Handler) gmhttp.Handler {
+	group := &coalesceGroup{
+		calls: map[string]*coalesceCall{},
+	}
+
+	return gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		if !options.coalesces(request) {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		key := options.key(request)
+		call, leader := group.join(key)
+
+		if leader {
+			defer group.finish(key, call)
+			recorder := &coalesceResponseWriter{ResponseWriter: writer, call: call, maxBodySize: options.MaxBodySize}
+			next.ServeHTTP(recorder, request)
+			// a response produced for a canceled request may be incomplete
+			if request.Context().Err() == nil {
+				recorder.complete()
+			}
+			return
+		}
+
+		select {
+		case <-call.done:
+		case <-request.Context().Done():
+			return
+		}
+
+		if !call.shared {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		call.writeTo(writer)
+	})
+}
+
+func (options *CoalesceOptions) coalesces(request *gmhttp.Request) bool {
+	if request.Method != gmhttp.MethodGet || request.Header.Get("Upgrade") != "" {
+		return false
+	}
+
+	if len(options.Paths) == 0 {
+		return true
+	}
+
+	for _, path := range options.Paths {
+		if strings.HasPrefix(request.URL.Path, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// key identifies identical requests by host, path, query, the values of the VaryHeaders and the TLS client
+// certificate, so that responses are not shared between clients authenticated by different certificates
+func (options *CoalesceOptions) key(request *gmhttp.Request) string {
+	key := &strings.Builder{}
+	key.WriteString(request.Host)
+	key.WriteString(" ")
+	key.WriteString(request.URL.RequestURI())
+	for _, name := range options.VaryHeaders {
+		key.WriteString("\n")
+		key.WriteString(name)
+		key.WriteString(": ")
+		key.WriteString(strings.Join(request.Header.Values(name), ", "))
+	}
+	if request.TLS != nil && len(request.TLS.PeerCertificates) > 0 {
+		sum := sha256.Sum256(request.TLS.PeerCertificates[0].Raw)
+		key.WriteString("\ncertificate: ")
+		key.WriteString(hex.EncodeToString(sum[:]))
+	}
+	return key.String()
+}
+
+// isCoalesceShareable returns false for responses specific to the client, those that set cookies or whose
+// Cache-Control is private or no-store
+func isCoalesceShareable(header gmhttp.Header) bool {
+	if len(header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name := strings.TrimSpace(directive)
+			if index := strings.IndexByte(name, '='); index >= 0 {
+				name = strings.TrimSpace(name[:index])
+			}
+
+			if strings.EqualFold(name, "private") || strings.EqualFold(name, "no-store") {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// coalesceGroup tracks the in flight calls by key
+type coalesceGroup struct {
+	lock  sync.Mutex
+	calls map[string]*coalesceCall
+}
+
+// join returns the in flight call for key, creating it if there is none in which case the caller is the leader and
+// must finish it
+func (group *coalesceGroup) join(key string) (*coalesceCall, bool) {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	if call, ok := group.calls[key]; ok {
+		return call, false
+	}
+
+	call := &coalesceCall{done: make(chan struct{})}
+	group.calls[key] = call
+	return call, true
+}
+
+// finish releases the requests waiting on call. If the leader panicked or its response was not shareable, they
+// execute the handler themselves.
+func (group *coalesceGroup) finish(key string, call *coalesceCall) {
+	group.lock.Lock()
+	delete(group.calls, key)
+	group.lock.Unlock()
+
+	close(call.done)
+}
+
+// coalesceCall is a response being produced for a group of identical requests
+type coalesceCall struct {
+	done   chan struct{}
+	shared bool
+	status int
+	header gmhttp.Header
+	body   []byte
+}
+
+func (call *coalesceCall) writeTo(writer gmhttp.ResponseWriter) {
+	for name, values := range call.header {
+		writer.Header()[name] = append([]string{}, values...)
+	}
+	writer.Header().Set(HttpHeaderCoalesced, "true")
+	writer.WriteHeader(call.status)
+	_, _ = writer.Write(call.body)
+}
+
+// coalesceResponseWriter writes the leader's response through while keeping a copy for the waiting requests
+type coalesceResponseWriter struct {
+	gmhttp.ResponseWriter
+	call        *coalesceCall
+	maxBodySize int64
+	status      int
+	header      gmhttp.Header
+	body        bytes.Buffer
+	overflow    bool
+}
+
+func (w *coalesceResponseWriter) WriteHeader(status int) {
+	// informational responses are passed through, the final response follows
+	if w.status == 0 && (status < 100 || status > 199) {
+		w.status = status
+		w.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *coalesceResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(gmhttp.StatusOK)
+	}
+
+	if !w.overflow {
+		if int64(w.body.Len()+len(data)) > w.maxBodySize {
+			w.overflow = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(data)
+		}
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *coalesceResponseWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(gmhttp.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(gmhttp.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped http.ResponseWriter
+func (w *coalesceResponseWriter) Unwrap() gmhttp.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// complete publishes the recorded response to the call once the handler has returned normally, unless it is too
+// large or not shareable
+func (w *coalesceResponseWriter) complete() {
+	if w.overflow {
+		return
+	}
+
+	if w.status == 0 {
+		w.status = gmhttp.StatusOK
+		w.header = w.Header().Clone()
+	}
+
+	if !isCoalesceShareable(w.header) {
+		return
+	}
+
+	w.call.status = w.status
+	w.call.header = w.header
+	w.call.body = w.body.Bytes()
+	w.call.shared = true
+}
@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"context"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"gitee.com/zhaochuninhefei/gmgo/x509"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_CoalesceHandler(t *testing.T) {
+	newOptions := func(req *require.Assertions, config map[interface{}]interface{}) *CoalesceOptions {
+		options := &CoalesceOptions{}
+		options.Default()
+		req.NoError(options.Parse(config))
+		req.NoError(options.Validate())
+		return options
+	}
+
+	// blockingHandler counts executions and holds each until release is closed
+	blockingHandler := func(calls *int32, release chan struct{}, body string) gmhttp.Handler {
+		return gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			atomic.AddInt32(calls, 1)
+			<-release
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(gmhttp.StatusAccepted)
+			_, _ = writer.Write([]byte(body))
+		})
+	}
+
+	serveConcurrently := func(handler gmhttp.Handler, requests []*gmhttp.Request, release chan struct{}, calls *int32, expectedCalls int32) []*gmhttptest.ResponseRecorder {
+		recorders := make([]*gmhttptest.ResponseRecorder, len(requests))
+		wg := sync.WaitGroup{}
+		for i, request := range requests {
+			recorders[i] = gmhttptest.NewRecorder()
+			wg.Add(1)
+			go func(recorder *gmhttptest.ResponseRecorder, request *gmhttp.Request) {
+				defer wg.Done()
+				handler.ServeHTTP(recorder, request)
+			}(recorders[i], request)
+		}
+
+		// let every request reach the handler or join a call before the leaders respond
+		require.Eventually(t, func() bool { return atomic.LoadInt32(calls) == expectedCalls }, time.Second, time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		return recorders
+	}
+
+	t.Run("identical concurrent requests execute the handler once", func(t *testing.T) {
+		req := require.New(t)
+		calls := int32(0)
+		release := make(chan struct{})
+		handler := NewCoalesceHandler(newOptions(req, nil), blockingHandler(&calls, release, `{"ok":true}`))
+
+		var requests []*gmhttp.Request
+		for i := 0; i < 10; i++ {
+			requests = append(requests, gmhttptest.NewRequest("GET", "/discovery?v=1", nil))
+		}
+
+		recorders := serveConcurrently(handler, requests, release, &calls, 1)
+		req.Equal(int32(1), calls)
+
+		coalesced := 0
+		for _, recorder := range recorders {
+			req.Equal(gmhttp.StatusAccepted, recorder.Code)
+			req.Equal("application/json", recorder.Header().Get("Content-Type"))
+			req.Equal(`{"ok":true}`, recorder.Body.String())
+			if recorder.Header().Get(HttpHeaderCoalesced) == "true" {
+				coalesced++
+			}
+		}
+		req.Equal(9, coalesced)
+	})
+
+	t.Run("requests differing by query or vary header are not coalesced", func(t *testing.T) {
+		req := require.New(t)
+		calls := int32(0)
+		release := make(chan struct{})
+		handler := NewCoalesceHandler(newOptions(req, nil), blockingHandler(&calls, release, "ok"))
+
+		first := gmhttptest.NewRequest("GET", "/discovery?v=1", nil)
+		second := gmhttptest.NewRequest("GET", "/discovery?v=2", nil)
+		third := gmhttptest.NewRequest("GET", "/discovery?v=1", nil)
+		third.Header.Set("Authorization", "Bearer other")
+
+		serveConcurrently(handler, []*gmhttp.Request{first, second, third}, release, &calls, 3)
+		req.Equal(int32(3), calls)
+	})
+
+	t.Run("only get requests on configured paths are coalesced", func(t *testing.T) {
+		req := require.New(t)
+		calls := int32(0)
+		release := make(chan struct{})
+		handler := NewCoalesceHandler(newOptions(req, map[interface{}]interface{}{"paths": "/discovery"}), blockingHandler(&calls, release, "ok"))
+
+		requests := []*gmhttp.Request{
+			gmhttptest.NewRequest("GET", "/things", nil),
+			gmhttptest.NewRequest("GET", "/things", nil),
+			gmhttptest.NewRequest("POST", "/discovery", strings.NewReader("{}")),
+			gmhttptest.NewRequest("POST", "/discovery", strings.NewReader("{}")),
+		}
+
+		serveConcurrently(handler, requests, release, &calls, 4)
+		req.Equal(int32(4), calls)
+	})
+
+	t.Run("responses over the max body size are executed by each request", func(t *testing.T) {
+		req := require.New(t)
+		calls := int32(0)
+		release := make(chan struct{})
+		handler := NewCoalesceHandler(newOptions(req, map[interface{}]interface{}{"maxBodySize": 4}), blockingHandler(&calls, release, "too large"))
+
+		requests := []*gmhttp.Request{
+			gmhttptest.NewRequest("GET", "/discovery", nil),
+			gmhttptest.NewRequest("GET", "/discovery", nil),
+		}
+
+		recorders := serveConcurrently(handler, requests, release, &calls, 1)
+		req.Equal(int32(2), calls)
+		for _, recorder := range recorders {
+			req.Equal("too large", recorder.Body.String())
+			req.Empty(recorder.Header().Get(HttpHeaderCoalesced))
+		}
+	})
+
+	t.Run("responses specific to the client are executed by each request", func(t *testing.T) {
+		req := require.New(t)
+
+		for _, header := range []gmhttp.Header{
+			{"Set-Cookie": {"session=1"}},
+			{"Cache-Control": {"private"}},
+			{"Cache-Control": {"max-age=0, No-Store"}},
+		} {
+			calls := int32(0)
+			release := make(chan struct{})
+			handler := NewCoalesceHandler(newOptions(req, nil), gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				for name, values := range header {
+					writer.Header()[name] = values
+				}
+				_, _ = writer.Write([]byte("ok"))
+			}))
+
+			requests := []*gmhttp.Request{
+				gmhttptest.NewRequest("GET", "/discovery", nil),
+				gmhttptest.NewRequest("GET", "/discovery", nil),
+			}
+
+			recorders := serveConcurrently(handler, requests, release, &calls, 1)
+			req.Equal(int32(2), calls, "%v", header)
+			for _, recorder := range recorders {
+				req.Empty(recorder.Header().Get(HttpHeaderCoalesced))
+			}
+		}
+	})
+
+	t.Run("requests with different client certificates are not coalesced", func(t *testing.T) {
+		req := require.New(t)
+		calls := int32(0)
+		release := make(chan struct{})
+		handler := NewCoalesceHandler(newOptions(req, nil), blockingHandler(&calls, release, "ok"))
+
+		var requests []*gmhttp.Request
+		for _, raw := range []string{"first", "second"} {
+			request := gmhttptest.NewRequest("GET", "/discovery", nil)
+			request.TLS = &gmtls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: []byte(raw)}}}
+			requests = append(requests, request)
+		}
+
+		serveConcurrently(handler, requests, release, &calls, 2)
+		req.Equal(int32(2), calls)
+	})
+
+	t.Run("responses of canceled requests are not shared", func(t *testing.T) {
+		req := require.New(t)
+		calls := int32(0)
+		release := make(chan struct{})
+		handler := NewCoalesceHandler(newOptions(req, nil), blockingHandler(&calls, release, "ok"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		leaderDone := make(chan struct{})
+		go func() {
+			defer close(leaderDone)
+			handler.ServeHTTP(gmhttptest.NewRecorder(), gmhttptest.NewRequest("GET", "/discovery", nil).WithContext(ctx))
+		}()
+		req.Eventually(func() bool { return atomic.LoadInt32(&calls) == 1 }, time.Second, time.Millisecond)
+
+		followerDone := make(chan struct{})
+		recorder := gmhttptest.NewRecorder()
+		go func() {
+			defer close(followerDone)
+			handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/discovery", nil))
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		cancel()
+		close(release)
+		<-leaderDone
+		<-followerDone
+
+		req.Equal(int32(2), calls)
+		req.Equal("ok", recorder.Body.String())
+		req.Empty(recorder.Header().Get(HttpHeaderCoalesced))
+	})
+
+	t.Run("invalid options are rejected", func(t *testing.T) {
+		req := require.New(t)
+		options := &CoalesceOptions{}
+		options.Default()
+		req.EqualError(options.Parse(map[interface{}]interface{}{"maxBodySize": true}), "could not use value for maxBodySize: not an integer or string")
+
+		options.Default()
+		req.NoError(options.Parse(map[interface{}]interface{}{"varyHeaders": "bad header"}))
+		req.EqualError(options.Validate(), "invalid vary header name [bad header]")
+	})
+}
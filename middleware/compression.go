@@ -23,8 +23,10 @@ import (
 	"fmt"
 	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
 	"github.com/andybalholm/brotli"
+	"github.com/openziti/xweb/v2/bufpool"
 	"io"
 	"io/ioutil"
+	"mime"
 	"strconv"
 	"strings"
 	"sync"
@@ -36,6 +38,8 @@ const (
 	HttpHeaderContentLength   = "Content-Length"
 	HttpHeaderAcceptEncoding  = "Accept-Encoding"
 	HttpHeaderContentEncoding = "Content-Encoding"
+	HttpHeaderContentRange    = "Content-Range"
+	HttpHeaderVary            = "Vary"
 
 	HttpEncodingGzip     = HttpEncoding("gzip")
 	HttpEncodingBr       = HttpEncoding("br")
@@ -49,53 +53,97 @@ var supportedEncodings = map[HttpEncoding]struct{}{
 	HttpEncodingDeflate: {},
 }
 
-var gzPool = sync.Pool{
-	New: func() interface{} {
-		w := gzip.NewWriter(ioutil.Discard)
-		return w
-	},
+// DefaultCompressionExcludedContentTypes are media types whose content is already compressed and gains little from
+// being compressed again
+var DefaultCompressionExcludedContentTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"image/avif",
+	"video/*",
+	"audio/*",
+	"font/woff",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
 }
 
-var brPool = sync.Pool{
-	New: func() interface{} {
-		w := brotli.NewWriter(ioutil.Discard)
-		return w
-	},
+// encoder is a pooled compression writer
+type encoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
 }
 
-var deflatePool = sync.Pool{
-	New: func() interface{} {
-		w, _ := flate.NewWriter(ioutil.Discard, 4)
-		return w
+var encoderPools = map[HttpEncoding]*sync.Pool{
+	HttpEncodingGzip: {
+		New: func() interface{} {
+			return gzip.NewWriter(ioutil.Discard)
+		},
+	},
+	HttpEncodingBr: {
+		New: func() interface{} {
+			return brotli.NewWriter(ioutil.Discard)
+		},
 	},
+	HttpEncodingDeflate: {
+		New: func() interface{} {
+			w, _ := flate.NewWriter(ioutil.Discard, 4)
+			return w
+		},
+	},
+}
+
+// CompressionOptions configures a handler created by NewCompressionHandlerWithOptions
+type CompressionOptions struct {
+	// ExcludedContentTypes are media types, or ranges such as video/*, of responses that are written uncompressed
+	ExcludedContentTypes []string
 }
 
 // NewCompressionHandler will return a http.Handler that should be at the top of a response pipeline (i.e. before any
 // other http.handlers that write). The returned handler will handle accept-encoding http header interpretation and
 // provide a wrapped writer to all downstream http.handlers that will result in all written content to be compressed if
-// possible.
+// possible. Responses with a DefaultCompressionExcludedContentTypes content type are not compressed.
 //
 // The handler will alter the http responses content encoding header (specified algorithm), content body (compressed),
 // and content length header (to match compressed body size). Attempting to set any of these values or alter the
 // content response body (including writing more data) after the handler exits may cause issues for the receiving
 // client.
 func NewCompressionHandler(next gmhttp.Handler) gmhttp.Handler {
+	return NewCompressionHandlerWithOptions(&CompressionOptions{
+		ExcludedContentTypes: DefaultCompressionExcludedContentTypes,
+	}, next)
+}
+
+// NewCompressionHandlerWithOptions returns a compression handler as NewCompressionHandler does, which does not
+// compress responses of the ExcludedContentTypes. Responses that downstream handlers have already encoded, i.e. by
+// serving a pre-compressed file, partial content responses, whose ranges refer to the unencoded content, and responses
+// without a body are written unchanged.
+func NewCompressionHandlerWithOptions(options *CompressionOptions, next gmhttp.Handler) gmhttp.Handler {
 	return gmhttp.HandlerFunc(func(w gmhttp.ResponseWriter, r *gmhttp.Request) {
 		acceptEncodingHeader := getSupportedAcceptEncoding(r)
 
-		switch acceptEncodingHeader {
-		case HttpEncodingGzip:
-			handleGZip(w, r, next)
-			return
-		case HttpEncodingBr:
-			handleBr(w, r, next)
-			return
-		case HttpEncodingDeflate:
-			handleDeflate(w, r, next)
+		pool, ok := encoderPools[acceptEncodingHeader]
+		if !ok {
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		wrappedWriter := &compressionResponseWriter{
+			ResponseWriter: w,
+			options:        options,
+			encoding:       acceptEncodingHeader,
+			pool:           pool,
+		}
+		defer wrappedWriter.finish()
+
+		next.ServeHTTP(wrappedWriter, r)
 	})
 }
 
@@ -149,108 +197,124 @@ func getSupportedAcceptEncoding(r *gmhttp.Request) HttpEncoding {
 	return highestSupported
 }
 
-// wrappedResponseWriter satisfies http.ResponseWriter and allows the compression handler to redirect
-// Write() calls to compression encoder instead of the actual http.ResponseWriter.
-type wrappedResponseWriter struct {
-	status int
-	io.Writer
+// compressionResponseWriter satisfies http.ResponseWriter and decides on the first Write whether the response is
+// compressed. Compressed content is redirected to an encoder and buffered so that the content length header can be
+// set once the downstream handlers are done, other responses are written through.
+type compressionResponseWriter struct {
 	gmhttp.ResponseWriter
+	options  *CompressionOptions
+	encoding HttpEncoding
+	pool     *sync.Pool
+	status   int
+	decided  bool
+	encoder  encoder
+	buffer   *bytes.Buffer
 }
 
-// WriteHeader delays writing the status header till after compression is complete. This is done
-// so that the content length header can be properly set. Prematurely calling WriteHeader()
-// will cause all subsequent header changes to not be applied.
-func (w *wrappedResponseWriter) WriteHeader(status int) {
-	w.status = status
+// WriteHeader delays writing the status header till the response is known to be compressed or not. Prematurely
+// writing the status would cause all subsequent header changes to not be applied.
+func (w *compressionResponseWriter) WriteHeader(status int) {
+	if w.decided {
+		if w.encoder == nil {
+			w.ResponseWriter.WriteHeader(status)
+		}
+		return
+	}
+
+	if w.status == 0 {
+		w.status = status
+	}
 }
 
-// Write proxies the normal Write() to instead run through the compression encoder. Actual writing
-// to the http.ResponseWriter is handled via a defer'ed function call.
-func (w *wrappedResponseWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
+// Write proxies the normal Write() to instead run through the compression encoder if the response is compressed.
+// Writing the compressed content to the http.ResponseWriter is handled by finish.
+func (w *compressionResponseWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.decide(true)
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
 }
 
-// CloseHeaderSection is used by the encoder specific function handler to apply the
-// requested HTTP status and close the header section. This is called during the encoders
-// defer'ed section to occur after all content is written. Emulates
-// net/https default http.StatusOk value.
-func (w *wrappedResponseWriter) CloseHeaderSection() {
+// decide compresses responses with a body that are not excluded, the headers of others are written immediately
+func (w *compressionResponseWriter) decide(hasBody bool) {
+	w.decided = true
+
 	if w.status == 0 {
 		//emulate default status ok behaviour
 		w.status = gmhttp.StatusOK
 	}
-	w.ResponseWriter.WriteHeader(w.status)
-}
 
-// handleGZip pulls a gzip encoder from the pool encoders and sets it as the writer
-// for the response. The next http.Handler is then invoked and when finished
-// a deferred function will then pull the compressed contents out of the encoder
-// and set the appropriate http headers.
-func handleGZip(w gmhttp.ResponseWriter, r *gmhttp.Request, next gmhttp.Handler) {
-	gz := gzPool.Get().(*gzip.Writer)
-	defer gzPool.Put(gz)
-
-	var b bytes.Buffer
-	gz.Reset(&b)
-
-	wrappedWriter := &wrappedResponseWriter{ResponseWriter: w, Writer: gz}
-
-	defer func() {
-		_ = gz.Close()
-		length := len(b.Bytes())
-		w.Header().Set(HttpHeaderContentEncoding, string(HttpEncodingGzip))
-		w.Header().Set(HttpHeaderContentLength, fmt.Sprint(length))
-		wrappedWriter.CloseHeaderSection()
-		_, _ = w.Write(b.Bytes())
-	}()
-
-	next.ServeHTTP(wrappedWriter, r)
+	if hasBody && w.compressible() {
+		w.encoder = w.pool.Get().(encoder)
+		w.buffer = bufpool.Compression.Get()
+		w.encoder.Reset(w.buffer)
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
 }
 
-// handleDeflate pulls a deflate encoder from the pool encoders and sets it as the writer
-// for the response. The next http.Handler is then invoked and when finished
-// a deferred function will then pull the compressed contents out of the encoder
-// and set the appropriate http headers.
-func handleDeflate(w gmhttp.ResponseWriter, r *gmhttp.Request, next gmhttp.Handler) {
-	deflate := deflatePool.Get().(*flate.Writer)
-	defer deflatePool.Put(deflate)
+func (w *compressionResponseWriter) compressible() bool {
+	if w.status < gmhttp.StatusOK || w.status == gmhttp.StatusNoContent || w.status == gmhttp.StatusPartialContent ||
+		w.status == gmhttp.StatusNotModified {
+		return false
+	}
 
-	var b bytes.Buffer
-	deflate.Reset(&b)
+	header := w.Header()
+	if header.Get(HttpHeaderContentRange) != "" {
+		return false
+	}
 
-	wrappedWriter := &wrappedResponseWriter{ResponseWriter: w, Writer: deflate}
+	if encoding := header.Get(HttpHeaderContentEncoding); encoding != "" && encoding != string(HttpEncodingIdentity) {
+		return false
+	}
 
-	defer func() {
-		_ = deflate.Close()
-		w.Header().Set(HttpHeaderContentEncoding, string(HttpEncodingDeflate))
-		w.Header().Set(HttpHeaderContentLength, fmt.Sprint(len(b.Bytes())))
-		wrappedWriter.CloseHeaderSection()
-		_, _ = w.Write(b.Bytes())
-	}()
+	if contentType := header.Get(HttpHeaderContentType); contentType != "" {
+		if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && acceptsMediaType(w.options.ExcludedContentTypes, mediaType) {
+			return false
+		}
+	}
 
-	next.ServeHTTP(wrappedWriter, r)
+	return true
 }
 
-// handleBr pulls a brotli encoder from the pool encoders and sets it as the writer
-// for the response. The next http.Handler is then invoked and when finished
-// a deferred function will then pull the compressed contents out of the encoder
-// and set the appropriate http headers.
-func handleBr(w gmhttp.ResponseWriter, r *gmhttp.Request, next gmhttp.Handler) {
-	w.Header().Set(HttpHeaderContentEncoding, string(HttpEncodingBr))
-
-	br := brPool.Get().(*brotli.Writer)
-	defer brPool.Put(br)
+// finish writes the compressed content with the appropriate http headers, or the status of responses without a body,
+// once the downstream handlers are done
+func (w *compressionResponseWriter) finish() {
+	if !w.decided {
+		w.decide(false)
+	}
 
-	var b bytes.Buffer
-	br.Reset(&b)
+	if w.encoder == nil {
+		return
+	}
 
-	wrappedWriter := &wrappedResponseWriter{ResponseWriter: w, Writer: br}
+	defer w.pool.Put(w.encoder)
+	defer bufpool.Compression.Put(w.buffer)
 
-	defer func() {
-		_ = br.Close()
-		w.Header().Set(HttpHeaderContentLength, fmt.Sprint(len(b.Bytes())))
-		_, _ = w.Write(b.Bytes())
-	}()
+	_ = w.encoder.Close()
+	w.Header().Set(HttpHeaderContentEncoding, string(w.encoding))
+	w.Header().Set(HttpHeaderContentLength, fmt.Sprint(w.buffer.Len()))
+	if !varies(w.Header(), HttpHeaderAcceptEncoding) {
+		w.Header().Add(HttpHeaderVary, HttpHeaderAcceptEncoding)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(w.buffer.Bytes())
+}
 
-	next.ServeHTTP(wrappedWriter, r)
+// varies returns true if the Vary headers already name the request header, or are *
+func varies(header gmhttp.Header, name string) bool {
+	for _, value := range header.Values(HttpHeaderVary) {
+		for _, varied := range strings.Split(value, ",") {
+			varied = strings.TrimSpace(varied)
+			if varied == "*" || strings.EqualFold(varied, name) {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -1,16 +1,21 @@
 package middleware
 
 import (
+	"compress/gzip"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
 	"github.com/stretchr/testify/require"
-	"net/http"
+	"io"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingIdentity if accept encodings are not specified", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{},
 		}
 
@@ -21,7 +26,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingIdentity if accept encodings are not supported, well formatted", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {"abc,one;q=0,two,three"},
 			},
@@ -34,7 +39,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingIdentity if accept encodings are not supported, not well formatted", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {"a,b;;;;;q="},
 			},
@@ -47,7 +52,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingIdentity if accept encodings has gzip, not well formatted", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {"a,b;;;;;q=,gzip"},
 			},
@@ -60,7 +65,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingGzip if supplied as: gzip", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingGzip)},
 			},
@@ -73,7 +78,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingIdentity if supplied as: gzip, q>1", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingGzip) + ";q=1.1"},
 			},
@@ -86,7 +91,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingIdentity if supplied as: gzip, q<0", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingGzip) + ";q=-0.1"},
 			},
@@ -99,7 +104,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingIdentity if supplied as: gzip, non-float q", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingGzip) + ";q=abc"},
 			},
@@ -112,7 +117,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingIdentity if supplied as: gzip, q is empty", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingGzip) + ";q="},
 			},
@@ -125,7 +130,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingBr if supplied as: br/gzip, multiple headers, no q factors", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingBr), string(HttpEncodingGzip)},
 			},
@@ -138,7 +143,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingBr if supplied as: br/gzip, one header, no q factors", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingBr) + "," + string(HttpEncodingGzip)},
 			},
@@ -151,7 +156,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingBr if supplied as: br/gzip/deflate, multiple mixed header, no q factors", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {
 					string(HttpEncodingBr),
@@ -166,7 +171,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingBr if supplied as: br/gzip/deflate, multiple mixed header, q factors, last header q=1 explicit", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {
 					string(HttpEncodingDeflate) + ";q=0.5" + "," + string(HttpEncodingGzip) + ";q=0.2",
@@ -182,7 +187,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingBr if supplied as: br/gzip/deflate, multiple mixed header, q factors, last header q=1 implicit", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {
 					string(HttpEncodingDeflate) + ";q=0.5" + "," + string(HttpEncodingBr) + ";q=0.2",
@@ -198,7 +203,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingBr if supplied as: br/gzip/deflate, unsupported encodings, multiple mixed header, q factors, middle header q=1 implicit", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {
 					"text/html;q=1",
@@ -217,7 +222,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingBr if supplied as: br/gzip/deflate, unsupported encodings, multiple mixed header, q factors, middle header q=1 explicit", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {
 					"text/html;q=1",
@@ -238,7 +243,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingGzip if supplied as: gzip;q=0", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingGzip) + ";q=0"},
 			},
@@ -251,7 +256,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingGzip if supplied as: gzip;q=1", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingGzip) + ";q=1"},
 			},
@@ -264,7 +269,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingGzip if supplied as: gzip;q=0.5", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingGzip) + ";q=1"},
 			},
@@ -279,7 +284,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingBr if supplied as: br;q=0", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingBr) + ";q=0"},
 			},
@@ -292,7 +297,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingBr if supplied as: br;q=1", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingBr) + ";q=1"},
 			},
@@ -305,7 +310,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingBr if supplied as: br;q=0.5", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingBr) + ";q=1"},
 			},
@@ -320,7 +325,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingDeflate if supplied as: deflate;q=0", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingDeflate) + ";q=0"},
 			},
@@ -333,7 +338,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingDeflate if supplied as: deflate;q=1", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingDeflate) + ";q=1"},
 			},
@@ -346,7 +351,7 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 
 	t.Run("returns HttpEncodingDeflate if supplied as: deflate;q=0.5", func(t *testing.T) {
 		req := require.New(t)
-		r := &http.Request{
+		r := &gmhttp.Request{
 			Header: map[string][]string{
 				HttpHeaderAcceptEncoding: {string(HttpEncodingDeflate) + ";q=1"},
 			},
@@ -357,3 +362,112 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 		req.Equal(HttpEncodingDeflate, encoding)
 	})
 }
+
+func Test_CompressionHandler(t *testing.T) {
+	serve := func(handler gmhttp.HandlerFunc) *gmhttptest.ResponseRecorder {
+		recorder := gmhttptest.NewRecorder()
+		request := gmhttptest.NewRequest("GET", "/things", nil)
+		request.Header.Set(HttpHeaderAcceptEncoding, "gzip")
+		NewCompressionHandler(handler).ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	t.Run("compressible responses are compressed", func(t *testing.T) {
+		req := require.New(t)
+		body := strings.Repeat("compress me ", 100)
+		recorder := serve(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.Header().Set(HttpHeaderContentType, "text/plain; charset=utf-8")
+			writer.WriteHeader(gmhttp.StatusCreated)
+			_, _ = writer.Write([]byte(body))
+		})
+
+		req.Equal(gmhttp.StatusCreated, recorder.Code)
+		req.Equal("gzip", recorder.Header().Get(HttpHeaderContentEncoding))
+		req.Equal(HttpHeaderAcceptEncoding, recorder.Header().Get(HttpHeaderVary))
+
+		reader, err := gzip.NewReader(recorder.Body)
+		req.NoError(err)
+		decompressed, err := io.ReadAll(reader)
+		req.NoError(err)
+		req.Equal(body, string(decompressed))
+	})
+
+	t.Run("excluded content types are not compressed", func(t *testing.T) {
+		req := require.New(t)
+		recorder := serve(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.Header().Set(HttpHeaderContentType, "video/mp4")
+			_, _ = writer.Write([]byte("frames"))
+		})
+
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Empty(recorder.Header().Get(HttpHeaderContentEncoding))
+		req.Equal("frames", recorder.Body.String())
+	})
+
+	t.Run("responses encoded by the handler are not compressed again", func(t *testing.T) {
+		req := require.New(t)
+		recorder := serve(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.Header().Set(HttpHeaderContentEncoding, "br")
+			_, _ = writer.Write([]byte("already compressed"))
+		})
+
+		req.Equal("br", recorder.Header().Get(HttpHeaderContentEncoding))
+		req.Equal("already compressed", recorder.Body.String())
+	})
+
+	t.Run("responses without a body are not compressed", func(t *testing.T) {
+		req := require.New(t)
+		recorder := serve(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.WriteHeader(gmhttp.StatusNoContent)
+		})
+
+		req.Equal(gmhttp.StatusNoContent, recorder.Code)
+		req.Empty(recorder.Header().Get(HttpHeaderContentEncoding))
+		req.Zero(recorder.Body.Len())
+	})
+
+	t.Run("range requests are not compressed", func(t *testing.T) {
+		req := require.New(t)
+		body := strings.Repeat("compress me ", 100)
+		recorder := gmhttptest.NewRecorder()
+		request := gmhttptest.NewRequest("GET", "/things.txt", nil)
+		request.Header.Set(HttpHeaderAcceptEncoding, "gzip")
+		request.Header.Set("Range", "bytes=0-7")
+
+		NewCompressionHandler(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			gmhttp.ServeContent(writer, request, "things.txt", time.Time{}, strings.NewReader(body))
+		})).ServeHTTP(recorder, request)
+
+		req.Equal(gmhttp.StatusPartialContent, recorder.Code)
+		req.Empty(recorder.Header().Get(HttpHeaderContentEncoding))
+		req.Equal("bytes 0-7/1200", recorder.Header().Get(HttpHeaderContentRange))
+		req.Equal("compress", recorder.Body.String())
+	})
+
+	t.Run("accept encoding is added to vary once", func(t *testing.T) {
+		req := require.New(t)
+		recorder := serve(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.Header().Add(HttpHeaderVary, "Origin, accept-encoding")
+			_, _ = writer.Write([]byte(strings.Repeat("compress me ", 100)))
+		})
+
+		req.Equal("gzip", recorder.Header().Get(HttpHeaderContentEncoding))
+		req.Equal([]string{"Origin, accept-encoding"}, recorder.Header().Values(HttpHeaderVary))
+	})
+
+	t.Run("excluded content types may be configured", func(t *testing.T) {
+		req := require.New(t)
+		recorder := gmhttptest.NewRecorder()
+		request := gmhttptest.NewRequest("GET", "/things", nil)
+		request.Header.Set(HttpHeaderAcceptEncoding, "br")
+
+		handler := NewCompressionHandlerWithOptions(&CompressionOptions{ExcludedContentTypes: []string{"application/*"}}, gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.Header().Set(HttpHeaderContentType, "application/json")
+			_, _ = writer.Write([]byte("{}"))
+		}))
+		handler.ServeHTTP(recorder, request)
+
+		req.Empty(recorder.Header().Get(HttpHeaderContentEncoding))
+		req.Equal("{}", recorder.Body.String())
+	})
+}
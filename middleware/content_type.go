@@ -0,0 +1,149 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"mime"
+	"strings"
+)
+
+const HttpHeaderContentType = "Content-Type"
+
+var (
+	ErrUnsupportedMediaType = errors.New("request content type is not supported")
+	ErrNotAcceptable        = errors.New("no acceptable response content type")
+)
+
+// ContentTypeOptions declares the media types an API accepts in request bodies and produces in responses. Media
+// types may be exact, e.g. application/json, or ranges, e.g. text/* or */*. Parameters are ignored when matching.
+type ContentTypeOptions struct {
+	// Accepts are the media types request bodies may have. Requests with a body of another or no Content-Type are
+	// rejected with ErrUnsupportedMediaType. Any type is accepted if empty.
+	Accepts []string
+
+	// Produces are the media types responses are available in. Requests whose Accept header allows none of them are
+	// rejected with ErrNotAcceptable. Any type may be requested if empty.
+	Produces []string
+
+	// OnFailure writes the response for rejected requests, defaults to http.StatusUnsupportedMediaType for
+	// ErrUnsupportedMediaType and http.StatusNotAcceptable for ErrNotAcceptable
+	OnFailure func(writer gmhttp.ResponseWriter, request *gmhttp.Request, err error)
+}
+
+// Parse parses a configuration map. accepts and produces may be a string or a list of strings.
+func (options *ContentTypeOptions) Parse(config map[interface{}]interface{}) error {
+	for field, target := range map[string]*[]string{"accepts": &options.Accepts, "produces": &options.Produces} {
+		if interfaceVal, ok := config[field]; ok {
+			mediaTypes, err := parseMediaTypes(interfaceVal)
+			if err != nil {
+				return fmt.Errorf("could not use value for %s: %v", field, err)
+			}
+			*target = mediaTypes
+		}
+	}
+
+	return nil
+}
+
+func parseMediaTypes(val interface{}) ([]string, error) {
+	switch mediaTypes := val.(type) {
+	case string:
+		return []string{mediaTypes}, nil
+	case []interface{}:
+		var result []string
+		for _, entry := range mediaTypes {
+			mediaType, ok := entry.(string)
+			if !ok {
+				return nil, fmt.Errorf("media type [%v] is not a string", entry)
+			}
+			result = append(result, mediaType)
+		}
+		return result, nil
+	}
+
+	return nil, errors.New("not a string or list of strings")
+}
+
+// Validate validates all settings and return nil or an error
+func (options *ContentTypeOptions) Validate() error {
+	for _, mediaType := range append(append([]string{}, options.Accepts...), options.Produces...) {
+		parsed, _, err := mime.ParseMediaType(mediaType)
+		if err != nil || !strings.Contains(parsed, "/") {
+			return fmt.Errorf("invalid media type [%s]", mediaType)
+		}
+	}
+
+	return nil
+}
+
+// NewContentTypeHandler returns a http.Handler that rejects requests whose body or Accept header do not match the
+// ContentTypeOptions before next is called. It is served behind NewNegotiationHandler, so next can select among the
+// produced types with NegotiationFromRequest without parsing the headers again.
+func NewContentTypeHandler(options *ContentTypeOptions, next gmhttp.Handler) gmhttp.Handler {
+	onFailure := options.OnFailure
+	if onFailure == nil {
+		onFailure = func(writer gmhttp.ResponseWriter, _ *gmhttp.Request, err error) {
+			status := gmhttp.StatusUnsupportedMediaType
+			if errors.Is(err, ErrNotAcceptable) {
+				status = gmhttp.StatusNotAcceptable
+			}
+			gmhttp.Error(writer, err.Error(), status)
+		}
+	}
+
+	return NewNegotiationHandler(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		if len(options.Accepts) > 0 && hasBody(request) {
+			contentType := request.Header.Get(HttpHeaderContentType)
+			mediaType, _, err := mime.ParseMediaType(contentType)
+			if err != nil || !acceptsMediaType(options.Accepts, mediaType) {
+				onFailure(writer, request, fmt.Errorf("%w: [%s], must be one of: %s", ErrUnsupportedMediaType, contentType, strings.Join(options.Accepts, ", ")))
+				return
+			}
+		}
+
+		if len(options.Produces) > 0 && NegotiationFromRequest(request).ContentType(options.Produces...) == "" {
+			onFailure(writer, request, fmt.Errorf("%w, available: %s", ErrNotAcceptable, strings.Join(options.Produces, ", ")))
+			return
+		}
+
+		next.ServeHTTP(writer, request)
+	}))
+}
+
+// hasBody returns true if the request has a body, servers set a ContentLength of -1 for bodies of unknown length
+func hasBody(request *gmhttp.Request) bool {
+	return request.ContentLength != 0
+}
+
+// acceptsMediaType returns true if mediaType matches one of the accepted media types or ranges
+func acceptsMediaType(accepts []string, mediaType string) bool {
+	for _, accepted := range accepts {
+		accepted, _, _ = strings.Cut(strings.ToLower(accepted), ";")
+		accepted = strings.TrimSpace(accepted)
+
+		if accepted == mediaType || accepted == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(accepted, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(accepted, "*")) {
+			return true
+		}
+	}
+	return false
+}
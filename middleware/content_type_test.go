@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func Test_ContentTypeHandler(t *testing.T) {
+	newOptions := func(req *require.Assertions, config map[interface{}]interface{}) *ContentTypeOptions {
+		options := &ContentTypeOptions{}
+		req.NoError(options.Parse(config))
+		req.NoError(options.Validate())
+		return options
+	}
+
+	serve := func(options *ContentTypeOptions, request *gmhttp.Request) (*gmhttptest.ResponseRecorder, *gmhttp.Request) {
+		var served *gmhttp.Request
+		handler := NewContentTypeHandler(options, gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			served = request
+		}))
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder, served
+	}
+
+	newRequest := func(body string, contentType string, accept string) *gmhttp.Request {
+		var request *gmhttp.Request
+		if body == "" {
+			request = gmhttptest.NewRequest("GET", "/things", nil)
+		} else {
+			request = gmhttptest.NewRequest("POST", "/things", strings.NewReader(body))
+		}
+		if contentType != "" {
+			request.Header.Set(HttpHeaderContentType, contentType)
+		}
+		if accept != "" {
+			request.Header.Set(HttpHeaderAccept, accept)
+		}
+		return request
+	}
+
+	t.Run("accepted content types are served", func(t *testing.T) {
+		req := require.New(t)
+		options := newOptions(req, map[interface{}]interface{}{"accepts": []interface{}{"application/json", "text/*"}})
+
+		for _, contentType := range []string{"application/json", "application/JSON; charset=utf-8", "text/plain"} {
+			recorder, served := serve(options, newRequest("{}", contentType, ""))
+			req.Equal(gmhttp.StatusOK, recorder.Code, contentType)
+			req.NotNil(served, contentType)
+		}
+	})
+
+	t.Run("unsupported content types are rejected with 415", func(t *testing.T) {
+		req := require.New(t)
+		options := newOptions(req, map[interface{}]interface{}{"accepts": "application/json"})
+
+		for _, contentType := range []string{"application/xml", "", "not a type"} {
+			recorder, served := serve(options, newRequest("<a/>", contentType, ""))
+			req.Equal(gmhttp.StatusUnsupportedMediaType, recorder.Code, contentType)
+			req.Nil(served, contentType)
+		}
+	})
+
+	t.Run("requests without a body are not checked against accepted types", func(t *testing.T) {
+		req := require.New(t)
+		options := newOptions(req, map[interface{}]interface{}{"accepts": "application/json"})
+
+		recorder, served := serve(options, newRequest("", "", ""))
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.NotNil(served)
+	})
+
+	t.Run("requests that accept none of the produced types are rejected with 406", func(t *testing.T) {
+		req := require.New(t)
+		options := newOptions(req, map[interface{}]interface{}{"produces": []interface{}{"application/json"}})
+
+		recorder, served := serve(options, newRequest("", "", "text/html"))
+		req.Equal(gmhttp.StatusNotAcceptable, recorder.Code)
+		req.Nil(served)
+
+		for _, accept := range []string{"", "*/*", "application/*", "text/html, application/json;q=0.5"} {
+			recorder, served = serve(options, newRequest("", "", accept))
+			req.Equal(gmhttp.StatusOK, recorder.Code, accept)
+			req.IsType(&Negotiation{}, served.Context().Value(negotiationContextKey{}), accept)
+			req.Equal("application/json", NegotiationFromRequest(served).ContentType(options.Produces...), accept)
+		}
+	})
+
+	t.Run("failures can be customized", func(t *testing.T) {
+		req := require.New(t)
+		options := newOptions(req, map[interface{}]interface{}{"produces": "application/json"})
+		var failure error
+		options.OnFailure = func(writer gmhttp.ResponseWriter, request *gmhttp.Request, err error) {
+			failure = err
+			writer.WriteHeader(gmhttp.StatusTeapot)
+		}
+
+		recorder, _ := serve(options, newRequest("", "", "text/html"))
+		req.Equal(gmhttp.StatusTeapot, recorder.Code)
+		req.ErrorIs(failure, ErrNotAcceptable)
+	})
+
+	t.Run("invalid options are rejected", func(t *testing.T) {
+		req := require.New(t)
+		options := &ContentTypeOptions{}
+		req.EqualError(options.Parse(map[interface{}]interface{}{"accepts": 1}), "could not use value for accepts: not a string or list of strings")
+		req.EqualError(options.Parse(map[interface{}]interface{}{"produces": []interface{}{1}}), "could not use value for produces: media type [1] is not a string")
+
+		req.NoError(options.Parse(map[interface{}]interface{}{"accepts": "json"}))
+		req.EqualError(options.Validate(), "invalid media type [json]")
+	})
+}
@@ -0,0 +1,310 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"path"
+	"strings"
+)
+
+type CsrfMode string
+
+const (
+	// CsrfModeDoubleSubmit issues a random token in a cookie readable by scripts. Unsafe requests must echo the cookie
+	// value in a header or form field, which cross site requests are unable to do.
+	CsrfModeDoubleSubmit = CsrfMode("doubleSubmit")
+
+	// CsrfModeSynchronizer derives the token from the session cookie with an HMAC. Unsafe requests must present the
+	// token for their session, which is provided to handlers via CsrfToken for embedding in pages and forms.
+	CsrfModeSynchronizer = CsrfMode("synchronizer")
+
+	DefaultCsrfCookieName = "xweb_csrf"
+	DefaultCsrfHeaderName = "X-CSRF-Token"
+	DefaultCsrfFormField  = "csrf_token"
+
+	csrfTokenBytes = 32
+)
+
+type csrfContextKey struct{}
+
+// CsrfOptions configures a handler created by NewCsrfHandler
+type CsrfOptions struct {
+	Mode CsrfMode
+
+	// CookieName is the cookie that holds the token in CsrfModeDoubleSubmit
+	CookieName string
+
+	// HeaderName and FormField are where unsafe requests may present the token. Form fields are only read from
+	// application/x-www-form-urlencoded bodies so that multipart uploads are not buffered, multipart requests must use
+	// the header.
+	HeaderName string
+	FormField  string
+
+	// SessionCookieName is the session cookie tokens are bound to in CsrfModeSynchronizer
+	SessionCookieName string
+
+	// Secret signs tokens. Required for CsrfModeSynchronizer, optional for CsrfModeDoubleSubmit where it prevents
+	// tokens from being planted by sibling domains able to set cookies.
+	Secret []byte
+
+	// ExemptPaths are paths, and the paths beneath them, that are not checked, i.e. token authenticated API paths
+	ExemptPaths []string
+
+	// OnFailure writes the response for requests that fail verification, defaults to http.StatusForbidden
+	OnFailure func(writer gmhttp.ResponseWriter, request *gmhttp.Request, err error)
+}
+
+// Default provides defaults for all necessary values
+func (options *CsrfOptions) Default() {
+	options.Mode = CsrfModeDoubleSubmit
+	options.CookieName = DefaultCsrfCookieName
+	options.HeaderName = DefaultCsrfHeaderName
+	options.FormField = DefaultCsrfFormField
+}
+
+// Parse parses a configuration map
+func (options *CsrfOptions) Parse(config map[interface{}]interface{}) error {
+	for field, target := range map[string]*string{"cookieName": &options.CookieName, "headerName": &options.HeaderName, "formField": &options.FormField, "sessionCookieName": &options.SessionCookieName} {
+		if interfaceVal, ok := config[field]; ok {
+			if value, ok := interfaceVal.(string); ok {
+				*target = value
+			} else {
+				return fmt.Errorf("could not use value for %s, not a string", field)
+			}
+		}
+	}
+
+	if interfaceVal, ok := config["mode"]; ok {
+		if mode, ok := interfaceVal.(string); ok {
+			options.Mode = CsrfMode(mode)
+		} else {
+			return errors.New("could not use value for mode, not a string")
+		}
+	}
+
+	if interfaceVal, ok := config["secret"]; ok {
+		if secret, ok := interfaceVal.(string); ok {
+			options.Secret = []byte(secret)
+		} else {
+			return errors.New("could not use value for secret, not a string")
+		}
+	}
+
+	if interfaceVal, ok := config["exemptPaths"]; ok {
+		paths, ok := interfaceVal.([]interface{})
+		if !ok {
+			return errors.New("could not use value for exemptPaths, not an array")
+		}
+
+		for i, pathInterface := range paths {
+			path, ok := pathInterface.(string)
+			if !ok || path == "" {
+				return fmt.Errorf("could not use value for exemptPaths[%d], not a non-empty string", i)
+			}
+			options.ExemptPaths = append(options.ExemptPaths, path)
+		}
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (options *CsrfOptions) Validate() error {
+	switch options.Mode {
+	case CsrfModeDoubleSubmit:
+		if options.CookieName == "" {
+			return errors.New("cookieName must not be empty")
+		}
+	case CsrfModeSynchronizer:
+		if options.SessionCookieName == "" {
+			return errors.New("sessionCookieName is required for mode synchronizer")
+		}
+		if len(options.Secret) < 16 {
+			return errors.New("secret of at least 16 bytes is required for mode synchronizer")
+		}
+	default:
+		return fmt.Errorf("invalid mode [%s], must be one of: %s, %s", options.Mode, CsrfModeDoubleSubmit, CsrfModeSynchronizer)
+	}
+
+	if len(options.Secret) > 0 && len(options.Secret) < 16 {
+		return errors.New("secret must be at least 16 bytes")
+	}
+
+	if options.HeaderName == "" && options.FormField == "" {
+		return errors.New("at least one of headerName and formField must be set")
+	}
+
+	return nil
+}
+
+var (
+	ErrCsrfTokenMissing = errors.New("CSRF token missing")
+	ErrCsrfTokenInvalid = errors.New("CSRF token invalid")
+)
+
+// CsrfToken returns the token that unsafe requests made by the client must present, for embedding in pages and
+// forms. Empty if the request was not served by a CSRF handler or, in CsrfModeSynchronizer, has no session.
+func CsrfToken(request *gmhttp.Request) string {
+	if token, ok := request.Context().Value(csrfContextKey{}).(string); ok {
+		return token
+	}
+	return ""
+}
+
+// NewCsrfHandler returns a http.Handler that verifies a CSRF token is presented on all requests with unsafe methods
+// (those other than GET, HEAD, OPTIONS and TRACE) before calling next.
+func NewCsrfHandler(options *CsrfOptions, next gmhttp.Handler) gmhttp.Handler {
+	onFailure := options.OnFailure
+	if onFailure == nil {
+		onFailure = func(writer gmhttp.ResponseWriter, _ *gmhttp.Request, err error) {
+			gmhttp.Error(writer, err.Error(), gmhttp.StatusForbidden)
+		}
+	}
+
+	return gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		token := options.expectedToken(writer, request)
+
+		if !isSafeMethod(request.Method) && !options.isExempt(request.URL.Path) {
+			if err := options.verify(request, token); err != nil {
+				onFailure(writer, request, err)
+				return
+			}
+		}
+
+		next.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), csrfContextKey{}, token)))
+	})
+}
+
+// expectedToken returns the token the request must present, issuing a new double submit cookie if necessary
+func (options *CsrfOptions) expectedToken(writer gmhttp.ResponseWriter, request *gmhttp.Request) string {
+	if options.Mode == CsrfModeSynchronizer {
+		if session, err := request.Cookie(options.SessionCookieName); err == nil && session.Value != "" {
+			return options.sign(session.Value)
+		}
+		return ""
+	}
+
+	if cookie, err := request.Cookie(options.CookieName); err == nil && options.isValidDoubleSubmitToken(cookie.Value) {
+		return cookie.Value
+	}
+
+	token := options.newDoubleSubmitToken()
+
+	gmhttp.SetCookie(writer, &gmhttp.Cookie{
+		Name:     options.CookieName,
+		Value:    token,
+		Path:     "/",
+		Secure:   true,
+		SameSite: gmhttp.SameSiteStrictMode,
+	})
+
+	// a newly issued token can never have been presented by the client
+	if !isSafeMethod(request.Method) {
+		return ""
+	}
+
+	return token
+}
+
+func (options *CsrfOptions) verify(request *gmhttp.Request, expected string) error {
+	if expected == "" {
+		return ErrCsrfTokenMissing
+	}
+
+	presented := ""
+	if options.HeaderName != "" {
+		presented = request.Header.Get(options.HeaderName)
+	}
+
+	if presented == "" && options.FormField != "" && strings.HasPrefix(request.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		presented = request.PostFormValue(options.FormField)
+	}
+
+	if presented == "" {
+		return ErrCsrfTokenMissing
+	}
+
+	if subtle.ConstantTimeCompare([]byte(presented), []byte(expected)) != 1 {
+		return ErrCsrfTokenInvalid
+	}
+
+	return nil
+}
+
+func (options *CsrfOptions) sign(value string) string {
+	mac := hmac.New(sha256.New, options.Secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (options *CsrfOptions) newDoubleSubmitToken() string {
+	tokenBytes := make([]byte, csrfTokenBytes)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		panic(fmt.Errorf("could not generate CSRF token: %v", err))
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	if len(options.Secret) > 0 {
+		token = token + "." + options.sign(token)
+	}
+
+	return token
+}
+
+func (options *CsrfOptions) isValidDoubleSubmitToken(token string) bool {
+	if token == "" {
+		return false
+	}
+
+	if len(options.Secret) == 0 {
+		return true
+	}
+
+	value, signature, found := strings.Cut(token, ".")
+	return found && hmac.Equal([]byte(signature), []byte(options.sign(value)))
+}
+
+// isExempt returns true if requestPath is, or is beneath, one of ExemptPaths. Paths are compared by segment, so that
+// /api exempts /api/things but not /apikeys. requestPath is cleaned first, so that /api/../ui is not exempt.
+func (options *CsrfOptions) isExempt(requestPath string) bool {
+	cleanPath := path.Clean("/" + requestPath)
+	for _, exemptPath := range options.ExemptPaths {
+		prefix := strings.TrimSuffix(exemptPath, "/")
+		if cleanPath == prefix || strings.HasPrefix(cleanPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case gmhttp.MethodGet, gmhttp.MethodHead, gmhttp.MethodOptions, gmhttp.MethodTrace:
+		return true
+	}
+	return false
+}
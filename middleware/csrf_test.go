@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newTestCsrfHandler(req *require.Assertions, config map[interface{}]interface{}) gmhttp.Handler {
+	options := &CsrfOptions{}
+	options.Default()
+	req.NoError(options.Parse(config))
+	req.NoError(options.Validate())
+
+	return NewCsrfHandler(options, gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		_, _ = writer.Write([]byte(CsrfToken(request)))
+	}))
+}
+
+func serveCsrf(handler gmhttp.Handler, request *gmhttp.Request) *gmhttptest.ResponseRecorder {
+	recorder := gmhttptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func Test_CsrfHandler(t *testing.T) {
+	t.Run("double submit issues a cookie and accepts it echoed in a header", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestCsrfHandler(req, map[interface{}]interface{}{"secret": "0123456789abcdef"})
+
+		recorder := serveCsrf(handler, gmhttptest.NewRequest("GET", "/", nil))
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		cookies := recorder.Result().Cookies()
+		req.Len(cookies, 1)
+		req.Equal(cookies[0].Value, recorder.Body.String())
+
+		post := gmhttptest.NewRequest("POST", "/things", nil)
+		post.AddCookie(cookies[0])
+		req.Equal(gmhttp.StatusForbidden, serveCsrf(handler, post).Code)
+
+		post = gmhttptest.NewRequest("POST", "/things", nil)
+		post.AddCookie(cookies[0])
+		post.Header.Set(DefaultCsrfHeaderName, cookies[0].Value)
+		req.Equal(gmhttp.StatusOK, serveCsrf(handler, post).Code)
+	})
+
+	t.Run("double submit rejects unsigned cookies when a secret is set", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestCsrfHandler(req, map[interface{}]interface{}{"secret": "0123456789abcdef"})
+
+		post := gmhttptest.NewRequest("POST", "/things", nil)
+		post.AddCookie(&gmhttp.Cookie{Name: DefaultCsrfCookieName, Value: "planted"})
+		post.Header.Set(DefaultCsrfHeaderName, "planted")
+		req.Equal(gmhttp.StatusForbidden, serveCsrf(handler, post).Code)
+	})
+
+	t.Run("synchronizer tokens are bound to the session and accepted from forms", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestCsrfHandler(req, map[interface{}]interface{}{
+			"mode":              "synchronizer",
+			"sessionCookieName": "session",
+			"secret":            "0123456789abcdef",
+		})
+
+		get := gmhttptest.NewRequest("GET", "/", nil)
+		get.AddCookie(&gmhttp.Cookie{Name: "session", Value: "abc"})
+		token := serveCsrf(handler, get).Body.String()
+		req.NotEmpty(token)
+
+		newPost := func(session, token string) *gmhttp.Request {
+			post := gmhttptest.NewRequest("POST", "/things", strings.NewReader(url.Values{DefaultCsrfFormField: {token}}.Encode()))
+			post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			post.AddCookie(&gmhttp.Cookie{Name: "session", Value: session})
+			return post
+		}
+
+		req.Equal(gmhttp.StatusOK, serveCsrf(handler, newPost("abc", token)).Code)
+		req.Equal(gmhttp.StatusForbidden, serveCsrf(handler, newPost("other", token)).Code)
+	})
+
+	t.Run("exempt paths are not checked", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestCsrfHandler(req, map[interface{}]interface{}{"exemptPaths": []interface{}{"/api/"}})
+
+		req.Equal(gmhttp.StatusOK, serveCsrf(handler, gmhttptest.NewRequest("POST", "/api/things", nil)).Code)
+		req.Equal(gmhttp.StatusForbidden, serveCsrf(handler, gmhttptest.NewRequest("POST", "/ui/things", nil)).Code)
+	})
+
+	t.Run("exempt paths match whole path segments", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestCsrfHandler(req, map[interface{}]interface{}{"exemptPaths": []interface{}{"/api"}})
+
+		req.Equal(gmhttp.StatusOK, serveCsrf(handler, gmhttptest.NewRequest("POST", "/api", nil)).Code)
+		req.Equal(gmhttp.StatusOK, serveCsrf(handler, gmhttptest.NewRequest("POST", "/api/things", nil)).Code)
+		req.Equal(gmhttp.StatusForbidden, serveCsrf(handler, gmhttptest.NewRequest("POST", "/apikeys", nil)).Code)
+	})
+
+	t.Run("exempt paths are matched against the cleaned path", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestCsrfHandler(req, map[interface{}]interface{}{"exemptPaths": []interface{}{"/api"}})
+
+		req.Equal(gmhttp.StatusForbidden, serveCsrf(handler, gmhttptest.NewRequest("POST", "/api/../ui/things", nil)).Code)
+		req.Equal(gmhttp.StatusForbidden, serveCsrf(handler, gmhttptest.NewRequest("POST", "/api/./../ui", nil)).Code)
+		req.Equal(gmhttp.StatusOK, serveCsrf(handler, gmhttptest.NewRequest("POST", "/ui/../api/things", nil)).Code)
+	})
+
+	t.Run("synchronizer requires a secret", func(t *testing.T) {
+		req := require.New(t)
+		options := &CsrfOptions{}
+		options.Default()
+		req.NoError(options.Parse(map[interface{}]interface{}{"mode": "synchronizer", "sessionCookieName": "session"}))
+		req.Error(options.Validate())
+	})
+}
@@ -0,0 +1,460 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrMultipartTooLarge     = errors.New("multipart body exceeds the maximum total size")
+	ErrMultipartPartTooLarge = errors.New("multipart part exceeds the maximum part size")
+	ErrMultipartTooManyParts = errors.New("multipart body exceeds the maximum number of parts")
+)
+
+type multipartContextKey struct{}
+
+// MultipartLimits bounds multipart request bodies. Zero values are unlimited.
+type MultipartLimits struct {
+	MaxParts     int
+	MaxPartSize  int64
+	MaxTotalSize int64
+
+	// OnTooLarge writes the response for requests whose declared Content-Length exceeds MaxTotalSize, defaults to
+	// http.StatusRequestEntityTooLarge
+	OnTooLarge func(writer gmhttp.ResponseWriter, request *gmhttp.Request, err error)
+}
+
+// Parse parses a configuration map. Sizes may be integers (bytes) or strings with a KB, MB or GB suffix.
+func (limits *MultipartLimits) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["maxParts"]; ok {
+		if maxParts, ok := interfaceVal.(int); ok {
+			limits.MaxParts = maxParts
+		} else {
+			return errors.New("could not use value for maxParts, not an integer")
+		}
+	}
+
+	for field, target := range map[string]*int64{"maxPartSize": &limits.MaxPartSize, "maxTotalSize": &limits.MaxTotalSize} {
+		if interfaceVal, ok := config[field]; ok {
+			size, err := parseByteSize(interfaceVal)
+			if err != nil {
+				return fmt.Errorf("could not use value for %s: %v", field, err)
+			}
+			*target = size
+		}
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (limits *MultipartLimits) Validate() error {
+	if limits.MaxParts < 0 || limits.MaxPartSize < 0 || limits.MaxTotalSize < 0 {
+		return errors.New("multipart limits must be zero (unlimited) or positive")
+	}
+
+	if limits.MaxTotalSize > 0 && limits.MaxPartSize > limits.MaxTotalSize {
+		return errors.New("maxPartSize must not be greater than maxTotalSize")
+	}
+
+	return nil
+}
+
+func parseByteSize(val interface{}) (int64, error) {
+	switch size := val.(type) {
+	case int:
+		return int64(size), nil
+	case int64:
+		return size, nil
+	case string:
+		multiplier := int64(1)
+		upper := strings.ToUpper(strings.TrimSpace(size))
+		for suffix, suffixMultiplier := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+			if strings.HasSuffix(upper, suffix) {
+				multiplier = suffixMultiplier
+				upper = strings.TrimSpace(strings.TrimSuffix(upper, suffix))
+				break
+			}
+		}
+
+		value, err := strconv.ParseInt(strings.TrimSuffix(upper, "B"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid size [%s], must be bytes or have a KB, MB or GB suffix", size)
+		}
+
+		return value * multiplier, nil
+	}
+
+	return 0, errors.New("not an integer or string")
+}
+
+// NewMultipartLimitHandler returns a http.Handler that enforces the MultipartLimits on multipart request bodies and
+// makes them available to NewMultipartReader. Requests declaring a Content-Length over MaxTotalSize are rejected
+// before next is called, bodies that exceed a limit while streaming fail reads with ErrMultipartTooLarge,
+// ErrMultipartTooManyParts or ErrMultipartPartTooLarge. Limits are enforced on the body itself, so they also apply
+// when next uses http.Request.ParseMultipartForm or http.Request.MultipartReader.
+func NewMultipartLimitHandler(limits *MultipartLimits, next gmhttp.Handler) gmhttp.Handler {
+	onTooLarge := limits.OnTooLarge
+	if onTooLarge == nil {
+		onTooLarge = func(writer gmhttp.ResponseWriter, _ *gmhttp.Request, err error) {
+			gmhttp.Error(writer, err.Error(), gmhttp.StatusRequestEntityTooLarge)
+		}
+	}
+
+	return gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		if !isMultipart(request) {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		if limits.MaxTotalSize > 0 {
+			if request.ContentLength > limits.MaxTotalSize {
+				onTooLarge(writer, request, ErrMultipartTooLarge)
+				return
+			}
+
+			request.Body = &limitedBody{
+				ReadCloser: request.Body,
+				remaining:  limits.MaxTotalSize,
+				err:        ErrMultipartTooLarge,
+			}
+		}
+
+		if limits.MaxParts > 0 || limits.MaxPartSize > 0 {
+			if boundary := multipartBoundary(request); boundary != "" {
+				request.Body = newMultipartBody(request.Body, boundary, limits)
+			}
+		}
+
+		next.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), multipartContextKey{}, limits)))
+	})
+}
+
+func isMultipart(request *gmhttp.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	return err == nil && strings.HasPrefix(mediaType, "multipart/")
+}
+
+func multipartBoundary(request *gmhttp.Request) string {
+	_, params, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return params["boundary"]
+}
+
+// MultipartErrorStatus returns the http status code appropriate for an error from MultipartReader
+func MultipartErrorStatus(err error) int {
+	if errors.Is(err, ErrMultipartTooLarge) || errors.Is(err, ErrMultipartPartTooLarge) || errors.Is(err, ErrMultipartTooManyParts) {
+		return gmhttp.StatusRequestEntityTooLarge
+	}
+	return gmhttp.StatusBadRequest
+}
+
+// limitedBody fails reads with err once more than remaining bytes have been read
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	err       error
+}
+
+func (body *limitedBody) Read(p []byte) (int, error) {
+	if body.remaining < 0 {
+		return 0, body.err
+	}
+
+	// read one byte past the limit to distinguish bodies that end exactly at the limit
+	if int64(len(p)) > body.remaining+1 {
+		p = p[:body.remaining+1]
+	}
+
+	n, err := body.ReadCloser.Read(p)
+	body.remaining -= int64(n)
+
+	if body.remaining < 0 {
+		return n + int(body.remaining), body.err
+	}
+
+	return n, err
+}
+
+// multipartBody enforces MaxParts and MaxPartSize on a multipart body as it is read, by scanning for the boundary
+// delimiters. Part sizes count part content only, part headers are excluded.
+type multipartBody struct {
+	io.ReadCloser
+	limits    *MultipartLimits
+	delimiter []byte
+	failure   []int
+	matched   int
+	parts     int
+	inHeaders bool
+	headerEnd int
+	dashes    int
+	trailing  int
+	size      int64
+	closed    bool
+	err       error
+}
+
+func newMultipartBody(body io.ReadCloser, boundary string, limits *MultipartLimits) *multipartBody {
+	delimiter := []byte("\r\n--" + boundary)
+
+	// KMP failure table so delimiters split across reads or preceded by partial matches are found
+	failure := make([]int, len(delimiter))
+	for i, k := 1, 0; i < len(delimiter); i++ {
+		for k > 0 && delimiter[i] != delimiter[k] {
+			k = failure[k-1]
+		}
+		if delimiter[i] == delimiter[k] {
+			k++
+		}
+		failure[i] = k
+	}
+
+	return &multipartBody{
+		ReadCloser: body,
+		limits:     limits,
+		delimiter:  delimiter,
+		failure:    failure,
+		matched:    2, // the first delimiter may start the body without a preceding CRLF
+	}
+}
+
+func (body *multipartBody) Read(p []byte) (int, error) {
+	if body.err != nil {
+		return 0, body.err
+	}
+
+	n, err := body.ReadCloser.Read(p)
+	for i := 0; i < n; i++ {
+		if body.err = body.scan(p[i]); body.err != nil {
+			return i, body.err
+		}
+	}
+
+	return n, err
+}
+
+func (body *multipartBody) scan(b byte) error {
+	if body.closed {
+		return nil
+	}
+
+	for body.matched > 0 && body.delimiter[body.matched] != b {
+		body.matched = body.failure[body.matched-1]
+	}
+	if body.delimiter[body.matched] == b {
+		body.matched++
+	}
+
+	if body.matched == len(body.delimiter) {
+		body.matched = 0
+		body.parts++
+		body.inHeaders = true
+		body.headerEnd = 0
+		body.dashes = 0
+		body.trailing = 0
+		body.size = 0
+		return nil
+	}
+
+	if body.parts == 0 {
+		return nil
+	}
+
+	if body.inHeaders {
+		// a delimiter followed by "--" closes the body, anything else starts a new part
+		if body.trailing < 2 {
+			body.trailing++
+			if b == '-' {
+				body.dashes++
+			}
+
+			if body.trailing == 2 {
+				if body.dashes == 2 {
+					body.parts--
+					body.closed = true
+					return nil
+				}
+
+				if body.limits.MaxParts > 0 && body.parts > body.limits.MaxParts {
+					return ErrMultipartTooManyParts
+				}
+			}
+		}
+
+		if b == "\r\n\r\n"[body.headerEnd] {
+			body.headerEnd++
+		} else if b == '\r' {
+			body.headerEnd = 1
+		} else {
+			body.headerEnd = 0
+		}
+
+		body.inHeaders = body.headerEnd < 4
+		return nil
+	}
+
+	// bytes that may be the start of the next delimiter don't count towards the part yet
+	body.size++
+	if body.limits.MaxPartSize > 0 && body.size-int64(body.matched) > body.limits.MaxPartSize {
+		return ErrMultipartPartTooLarge
+	}
+
+	return nil
+}
+
+// MultipartReader streams the parts of a multipart request, enforcing the MultipartLimits of the handler that served
+// the request. Parts are never buffered in memory beyond what the caller reads.
+type MultipartReader struct {
+	reader *multipart.Reader
+	limits *MultipartLimits
+	parts  int
+	part   *MultipartPart
+}
+
+// NewMultipartReader returns a MultipartReader for a request. If the request was not served by a
+// NewMultipartLimitHandler, no limits are enforced.
+func NewMultipartReader(request *gmhttp.Request) (*MultipartReader, error) {
+	reader, err := request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	limits, _ := request.Context().Value(multipartContextKey{}).(*MultipartLimits)
+	if limits == nil {
+		limits = &MultipartLimits{}
+	}
+
+	return &MultipartReader{
+		reader: reader,
+		limits: limits,
+	}, nil
+}
+
+// NextPart returns the next part or io.EOF when there are no more parts. Any unread data in the previous part is
+// discarded, counting towards MaxTotalSize.
+func (reader *MultipartReader) NextPart() (*MultipartPart, error) {
+	if reader.part != nil {
+		_ = reader.part.Close()
+	}
+
+	part, err := reader.reader.NextPart()
+	if err != nil {
+		return nil, err
+	}
+
+	reader.parts++
+	if reader.limits.MaxParts > 0 && reader.parts > reader.limits.MaxParts {
+		_ = part.Close()
+		return nil, ErrMultipartTooManyParts
+	}
+
+	reader.part = &MultipartPart{
+		Part:      part,
+		remaining: reader.limits.MaxPartSize,
+		limited:   reader.limits.MaxPartSize > 0,
+	}
+
+	return reader.part, nil
+}
+
+// ForEach calls handle for every part, stopping at the first error. io.EOF is not returned.
+func (reader *MultipartReader) ForEach(handle func(part *MultipartPart) error) error {
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err = handle(part); err != nil {
+			return err
+		}
+	}
+}
+
+// MultipartPart is a single part of a multipart body. Reads fail with ErrMultipartPartTooLarge once MaxPartSize is
+// exceeded.
+type MultipartPart struct {
+	*multipart.Part
+	remaining int64
+	limited   bool
+}
+
+func (part *MultipartPart) Read(p []byte) (int, error) {
+	if !part.limited {
+		return part.Part.Read(p)
+	}
+
+	if part.remaining < 0 {
+		return 0, ErrMultipartPartTooLarge
+	}
+
+	if int64(len(p)) > part.remaining+1 {
+		p = p[:part.remaining+1]
+	}
+
+	n, err := part.Part.Read(p)
+	part.remaining -= int64(n)
+
+	if part.remaining < 0 {
+		return n + int(part.remaining), ErrMultipartPartTooLarge
+	}
+
+	return n, err
+}
+
+// CopyTo streams the part to writer
+func (part *MultipartPart) CopyTo(writer io.Writer) (int64, error) {
+	return io.Copy(writer, struct{ io.Reader }{part})
+}
+
+// SaveToTempFile streams the part to a new file in dir (os.TempDir if empty) and returns its path. The file is
+// removed if the part cannot be read completely.
+func (part *MultipartPart) SaveToTempFile(dir, pattern string) (string, int64, error) {
+	file, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", 0, err
+	}
+
+	n, err := part.CopyTo(file)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", n, err
+	}
+
+	return file.Name(), n, nil
+}
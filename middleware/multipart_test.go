@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"bytes"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/stretchr/testify/require"
+	"io"
+	"mime/multipart"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func newMultipartRequest(req *require.Assertions, parts map[string]string) *gmhttp.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for name, content := range parts {
+		part, err := writer.CreateFormFile(name, name+".txt")
+		req.NoError(err)
+		_, err = part.Write([]byte(content))
+		req.NoError(err)
+	}
+	req.NoError(writer.Close())
+
+	request := gmhttptest.NewRequest("POST", "/upload", body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+func Test_MultipartLimits(t *testing.T) {
+	var handlerErr error
+	var received map[string]int64
+
+	handler := func(limits *MultipartLimits) gmhttp.Handler {
+		return NewMultipartLimitHandler(limits, gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			received = map[string]int64{}
+			reader, err := NewMultipartReader(request)
+			if err != nil {
+				handlerErr = err
+				return
+			}
+
+			handlerErr = reader.ForEach(func(part *MultipartPart) error {
+				n, err := part.CopyTo(io.Discard)
+				received[part.FormName()] = n
+				return err
+			})
+		}))
+	}
+
+	t.Run("parses sizes with units", func(t *testing.T) {
+		req := require.New(t)
+		limits := &MultipartLimits{}
+		req.NoError(limits.Parse(map[interface{}]interface{}{"maxParts": 3, "maxPartSize": "1MB", "maxTotalSize": "2 GB"}))
+		req.Equal(3, limits.MaxParts)
+		req.Equal(int64(1<<20), limits.MaxPartSize)
+		req.Equal(int64(2<<30), limits.MaxTotalSize)
+		req.Error(limits.Parse(map[interface{}]interface{}{"maxPartSize": "lots"}))
+	})
+
+	t.Run("streams parts within the limits", func(t *testing.T) {
+		req := require.New(t)
+		limits := &MultipartLimits{MaxParts: 2, MaxPartSize: 10, MaxTotalSize: 4096}
+		handler(limits).ServeHTTP(gmhttptest.NewRecorder(), newMultipartRequest(req, map[string]string{"a": "1234567890", "b": "12345"}))
+		req.NoError(handlerErr)
+		req.Equal(map[string]int64{"a": 10, "b": 5}, received)
+	})
+
+	t.Run("rejects parts over the part size", func(t *testing.T) {
+		req := require.New(t)
+		handler(&MultipartLimits{MaxPartSize: 4}).ServeHTTP(gmhttptest.NewRecorder(), newMultipartRequest(req, map[string]string{"a": "12345"}))
+		req.ErrorIs(handlerErr, ErrMultipartPartTooLarge)
+		req.Equal(gmhttp.StatusRequestEntityTooLarge, MultipartErrorStatus(handlerErr))
+	})
+
+	t.Run("rejects too many parts", func(t *testing.T) {
+		req := require.New(t)
+		handler(&MultipartLimits{MaxParts: 1}).ServeHTTP(gmhttptest.NewRecorder(), newMultipartRequest(req, map[string]string{"a": "1", "b": "2"}))
+		req.ErrorIs(handlerErr, ErrMultipartTooManyParts)
+	})
+
+	t.Run("rejects declared bodies over the total size before reading", func(t *testing.T) {
+		req := require.New(t)
+		handlerErr = nil
+		recorder := gmhttptest.NewRecorder()
+		handler(&MultipartLimits{MaxTotalSize: 16}).ServeHTTP(recorder, newMultipartRequest(req, map[string]string{"a": "1234567890"}))
+		req.Equal(gmhttp.StatusRequestEntityTooLarge, recorder.Code)
+	})
+
+	t.Run("rejects streamed bodies over the total size", func(t *testing.T) {
+		req := require.New(t)
+		request := newMultipartRequest(req, map[string]string{"a": strings.Repeat("x", 1024)})
+		request.ContentLength = -1
+		handler(&MultipartLimits{MaxTotalSize: 512}).ServeHTTP(gmhttptest.NewRecorder(), request)
+		req.ErrorIs(handlerErr, ErrMultipartTooLarge)
+	})
+
+	t.Run("enforces part limits when the form is parsed", func(t *testing.T) {
+		parseForm := func(limits *MultipartLimits, request *gmhttp.Request) error {
+			var err error
+			NewMultipartLimitHandler(limits, gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+				err = request.ParseMultipartForm(1 << 20)
+			})).ServeHTTP(gmhttptest.NewRecorder(), request)
+			return err
+		}
+
+		req := require.New(t)
+		req.NoError(parseForm(&MultipartLimits{MaxParts: 2, MaxPartSize: 10}, newMultipartRequest(req, map[string]string{"a": "1234567890", "b": "12345"})))
+		req.ErrorIs(parseForm(&MultipartLimits{MaxParts: 1}, newMultipartRequest(req, map[string]string{"a": "1", "b": "2"})), ErrMultipartTooManyParts)
+		req.ErrorIs(parseForm(&MultipartLimits{MaxPartSize: 4}, newMultipartRequest(req, map[string]string{"a": "12345"})), ErrMultipartPartTooLarge)
+	})
+
+	t.Run("finds delimiters split across reads", func(t *testing.T) {
+		req := require.New(t)
+		request := newMultipartRequest(req, map[string]string{"a": "1234567890", "b": "12345", "c": "1"})
+		limits := &MultipartLimits{MaxParts: 3, MaxPartSize: 10}
+
+		body := newMultipartBody(io.NopCloser(iotest.OneByteReader(request.Body)), multipartBoundary(request), limits)
+		_, err := io.Copy(io.Discard, body)
+		req.NoError(err)
+		req.Equal(3, body.parts)
+
+		request = newMultipartRequest(req, map[string]string{"a": "1234567890", "b": "12345678901"})
+		body = newMultipartBody(io.NopCloser(iotest.OneByteReader(request.Body)), multipartBoundary(request), limits)
+		_, err = io.Copy(io.Discard, body)
+		req.ErrorIs(err, ErrMultipartPartTooLarge)
+	})
+
+	t.Run("saves parts to temp files", func(t *testing.T) {
+		req := require.New(t)
+		request := newMultipartRequest(req, map[string]string{"a": "hello"})
+		reader, err := NewMultipartReader(request)
+		req.NoError(err)
+
+		part, err := reader.NextPart()
+		req.NoError(err)
+
+		path, n, err := part.SaveToTempFile(t.TempDir(), "upload-*")
+		req.NoError(err)
+		req.Equal(int64(5), n)
+
+		content, err := os.ReadFile(path)
+		req.NoError(err)
+		req.Equal("hello", string(content))
+	})
+}
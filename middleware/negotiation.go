@@ -0,0 +1,296 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package middleware
+
+import (
+	"context"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	HttpHeaderAccept         = "Accept"
+	HttpHeaderAcceptLanguage = "Accept-Language"
+)
+
+type negotiationContextKey struct{}
+
+// AcceptValue is a single entry of an Accept, Accept-Language or Accept-Encoding header
+type AcceptValue struct {
+	// Value is the lower cased media range, language range or content coding
+	Value string
+
+	// Params are the parameters of a media range other than the weight, e.g. charset or version
+	Params map[string]string
+
+	// Q is the weight of the entry, 0 means not acceptable
+	Q float64
+}
+
+// AcceptList is the entries of an Accept style header ordered by descending weight. Entries of equal weight are in
+// the order the client sent them.
+type AcceptList []AcceptValue
+
+// ParseAcceptHeader parses the values of an Accept style header. Entries with unparsable weights are ignored.
+func ParseAcceptHeader(values []string) AcceptList {
+	var result AcceptList
+
+	for _, value := range values {
+		for _, rawEntry := range strings.Split(value, ",") {
+			parts := strings.Split(rawEntry, ";")
+
+			entry := AcceptValue{
+				Value: strings.ToLower(strings.TrimSpace(parts[0])),
+				Q:     1,
+			}
+
+			if entry.Value == "" {
+				continue
+			}
+
+			valid := true
+			for _, rawParam := range parts[1:] {
+				name, paramValue, _ := strings.Cut(strings.TrimSpace(rawParam), "=")
+				name = strings.ToLower(strings.TrimSpace(name))
+				paramValue = strings.Trim(strings.TrimSpace(paramValue), `"`)
+
+				if name == "q" {
+					q, err := strconv.ParseFloat(paramValue, 64)
+					if err != nil || q < 0 || q > 1 {
+						valid = false
+						break
+					}
+					entry.Q = q
+				} else if name != "" {
+					if entry.Params == nil {
+						entry.Params = map[string]string{}
+					}
+					entry.Params[name] = paramValue
+				}
+			}
+
+			if valid {
+				result = append(result, entry)
+			}
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Q > result[j].Q
+	})
+
+	return result
+}
+
+// Negotiation holds the parsed content negotiation headers of a request. A nil list indicates that the client did not
+// send the header, in which case every offer is acceptable.
+type Negotiation struct {
+	Accept         AcceptList
+	AcceptLanguage AcceptList
+	AcceptEncoding AcceptList
+}
+
+// ParseNegotiation parses the content negotiation headers of a request
+func ParseNegotiation(request *gmhttp.Request) *Negotiation {
+	result := &Negotiation{}
+
+	if values := request.Header.Values(HttpHeaderAccept); len(values) > 0 {
+		result.Accept = ensureList(ParseAcceptHeader(values))
+	}
+
+	if values := request.Header.Values(HttpHeaderAcceptLanguage); len(values) > 0 {
+		result.AcceptLanguage = ensureList(ParseAcceptHeader(values))
+	}
+
+	if values := request.Header.Values(HttpHeaderAcceptEncoding); len(values) > 0 {
+		result.AcceptEncoding = ensureList(ParseAcceptHeader(values))
+	}
+
+	return result
+}
+
+// ensureList distinguishes a header that was sent without valid entries from one that was not sent
+func ensureList(list AcceptList) AcceptList {
+	if list == nil {
+		return AcceptList{}
+	}
+	return list
+}
+
+// NegotiationFromRequest returns the Negotiation stored by a negotiation handler, or parses the request's headers if
+// it was not served by one
+func NegotiationFromRequest(request *gmhttp.Request) *Negotiation {
+	if negotiation, ok := request.Context().Value(negotiationContextKey{}).(*Negotiation); ok {
+		return negotiation
+	}
+	return ParseNegotiation(request)
+}
+
+// NewNegotiationHandler returns a http.Handler that parses the content negotiation headers of each request once and
+// stores the result on the request context for NegotiationFromRequest
+func NewNegotiationHandler(next gmhttp.Handler) gmhttp.Handler {
+	return gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		ctx := context.WithValue(request.Context(), negotiationContextKey{}, ParseNegotiation(request))
+		next.ServeHTTP(writer, request.WithContext(ctx))
+	})
+}
+
+// ContentType returns the offered media type most preferred by the Accept header or an empty string if none are
+// acceptable. Media ranges with wildcards match with lower precedence than exact media types, and media range
+// parameters must all be present on the offer. Ties are broken by the order of the offers.
+func (negotiation *Negotiation) ContentType(offers ...string) string {
+	return negotiate(negotiation.Accept, offers, matchMediaType)
+}
+
+// Language returns the offered language tag most preferred by the Accept-Language header or an empty string if none
+// are acceptable. Language ranges match tags they are equal to or a prefix of, e.g. en matches en-US, with longer
+// ranges taking precedence. Ties are broken by the order of the offers.
+func (negotiation *Negotiation) Language(offers ...string) string {
+	return negotiate(negotiation.AcceptLanguage, offers, matchLanguage)
+}
+
+// Encoding returns the offered content coding most preferred by the Accept-Encoding header or an empty string if none
+// are acceptable. identity is acceptable unless excluded by the client, with the lowest weight the client sent. Ties
+// are broken by the order of the offers.
+func (negotiation *Negotiation) Encoding(offers ...string) string {
+	return negotiate(negotiation.AcceptEncoding, offers, matchEncoding)
+}
+
+// acceptMatcher returns how specifically an entry matches an offer, zero if it does not match
+type acceptMatcher func(entry *AcceptValue, offer string) int
+
+func negotiate(list AcceptList, offers []string, matcher acceptMatcher) string {
+	if len(offers) == 0 {
+		return ""
+	}
+
+	if list == nil {
+		return offers[0]
+	}
+
+	// offers acceptable when not mentioned are least preferred
+	implicitQ := 1.0
+	for _, entry := range list {
+		if entry.Q > 0 && entry.Q < implicitQ {
+			implicitQ = entry.Q
+		}
+	}
+
+	best := ""
+	bestQ := 0.0
+
+	for _, offer := range offers {
+		q, matched := -1.0, 0
+		for i := range list {
+			if precedence := matcher(&list[i], offer); precedence > matched {
+				q, matched = list[i].Q, precedence
+			}
+		}
+
+		if matched == 0 && matcher(nil, offer) > 0 {
+			q = implicitQ
+		}
+
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best
+}
+
+func matchMediaType(entry *AcceptValue, offer string) int {
+	if entry == nil {
+		return 0
+	}
+
+	offerType, offerParams, _ := strings.Cut(strings.ToLower(offer), ";")
+	offerType = strings.TrimSpace(offerType)
+
+	precedence := 0
+	switch {
+	case entry.Value == offerType:
+		precedence = 3
+	case entry.Value == "*/*":
+		precedence = 1
+	case strings.HasSuffix(entry.Value, "/*") && strings.HasPrefix(offerType, strings.TrimSuffix(entry.Value, "*")):
+		precedence = 2
+	default:
+		return 0
+	}
+
+	for name, value := range entry.Params {
+		if !hasMediaParam(offerParams, name, value) {
+			return 0
+		}
+	}
+
+	// parameters make a media range more specific
+	return precedence*100 + len(entry.Params)
+}
+
+func hasMediaParam(params string, name string, value string) bool {
+	for _, rawParam := range strings.Split(params, ";") {
+		paramName, paramValue, _ := strings.Cut(strings.TrimSpace(rawParam), "=")
+		if strings.TrimSpace(paramName) == name && strings.Trim(strings.TrimSpace(paramValue), `"`) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchLanguage(entry *AcceptValue, offer string) int {
+	if entry == nil {
+		return 0
+	}
+
+	offer = strings.ToLower(offer)
+
+	if entry.Value == "*" {
+		return 1
+	}
+
+	if entry.Value == offer || strings.HasPrefix(offer, entry.Value+"-") {
+		return 1 + len(entry.Value)
+	}
+
+	return 0
+}
+
+func matchEncoding(entry *AcceptValue, offer string) int {
+	offer = strings.ToLower(offer)
+
+	if entry == nil {
+		// identity is acceptable when not mentioned
+		if offer == string(HttpEncodingIdentity) {
+			return 1
+		}
+		return 0
+	}
+
+	if entry.Value == offer {
+		return 2
+	}
+
+	if entry.Value == "*" {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func newNegotiationRequest(headers map[string]string) *gmhttp.Request {
+	request := gmhttptest.NewRequest("GET", "/", nil)
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	return request
+}
+
+func Test_Negotiation(t *testing.T) {
+	t.Run("accept headers are parsed and ordered by weight", func(t *testing.T) {
+		req := require.New(t)
+		list := ParseAcceptHeader([]string{"text/html;level=1, application/json;q=0.9, */*;q=0.1", "text/plain;q=bad"})
+
+		req.Len(list, 3)
+		req.Equal("text/html", list[0].Value)
+		req.Equal(map[string]string{"level": "1"}, list[0].Params)
+		req.Equal("application/json", list[1].Value)
+		req.Equal(0.9, list[1].Q)
+		req.Equal("*/*", list[2].Value)
+	})
+
+	t.Run("content types are negotiated by weight and specificity", func(t *testing.T) {
+		req := require.New(t)
+		negotiation := ParseNegotiation(newNegotiationRequest(map[string]string{
+			"Accept": "text/*;q=0.5, application/json, application/xml;q=0",
+		}))
+
+		req.Equal("application/json", negotiation.ContentType("text/html", "application/json"))
+		req.Equal("text/html", negotiation.ContentType("text/html", "application/xml"))
+		req.Equal("", negotiation.ContentType("application/xml", "image/png"))
+	})
+
+	t.Run("media range parameters must match", func(t *testing.T) {
+		req := require.New(t)
+		negotiation := ParseNegotiation(newNegotiationRequest(map[string]string{
+			"Accept": "application/json;version=2, application/json;q=0.1",
+		}))
+
+		req.Equal("application/json; version=2", negotiation.ContentType("application/json; version=1", "application/json; version=2"))
+	})
+
+	t.Run("languages match by prefix", func(t *testing.T) {
+		req := require.New(t)
+		negotiation := ParseNegotiation(newNegotiationRequest(map[string]string{
+			"Accept-Language": "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.1",
+		}))
+
+		req.Equal("fr-CH", negotiation.Language("en-US", "fr-FR", "fr-CH"))
+		req.Equal("fr-FR", negotiation.Language("en-US", "fr-FR"))
+		req.Equal("en-US", negotiation.Language("de", "en-US"))
+		req.Equal("de", negotiation.Language("de"))
+	})
+
+	t.Run("identity is acceptable unless excluded", func(t *testing.T) {
+		req := require.New(t)
+
+		negotiation := ParseNegotiation(newNegotiationRequest(map[string]string{"Accept-Encoding": "br;q=0.5"}))
+		req.Equal("identity", negotiation.Encoding("gzip", "identity"))
+		req.Equal("br", negotiation.Encoding("br", "identity"))
+
+		negotiation = ParseNegotiation(newNegotiationRequest(map[string]string{"Accept-Encoding": "gzip, *;q=0"}))
+		req.Equal("", negotiation.Encoding("br", "identity"))
+	})
+
+	t.Run("absent headers accept the first offer", func(t *testing.T) {
+		req := require.New(t)
+		negotiation := ParseNegotiation(newNegotiationRequest(nil))
+
+		req.Equal("application/json", negotiation.ContentType("application/json", "text/html"))
+		req.Equal("en", negotiation.Language("en", "fr"))
+		req.Equal("gzip", negotiation.Encoding("gzip"))
+		req.Equal("", negotiation.ContentType())
+	})
+
+	t.Run("the handler parses headers once for downstream handlers", func(t *testing.T) {
+		req := require.New(t)
+		var first, second *Negotiation
+
+		handler := NewNegotiationHandler(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			first = NegotiationFromRequest(request)
+			second = NegotiationFromRequest(request)
+		}))
+
+		handler.ServeHTTP(gmhttptest.NewRecorder(), newNegotiationRequest(map[string]string{"Accept": "text/html"}))
+
+		req.NotNil(first)
+		req.Same(first, second)
+		req.Equal("text/html", first.ContentType("application/json", "text/html"))
+	})
+}
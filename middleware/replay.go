@@ -0,0 +1,552 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package middleware
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"gitee.com/zhaochuninhefei/gmgo/sm2"
+	"gitee.com/zhaochuninhefei/gmgo/sm3"
+	"gitee.com/zhaochuninhefei/gmgo/x509"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ReplaySchemeHmacSha256 signs requests with HMAC-SHA256 over shared secrets and digests bodies with SHA-256
+	ReplaySchemeHmacSha256 = "hmac-sha256"
+
+	// ReplaySchemeHmacSm3 signs requests with HMAC-SM3 over shared secrets and digests bodies with SM3
+	ReplaySchemeHmacSm3 = "hmac-sm3"
+
+	// ReplaySchemeSm2 signs requests with SM2 private keys, verified with the configured public keys, and digests
+	// bodies with SM3. Signatures are ASN.1 encoded and made with the default SM2 user id.
+	ReplaySchemeSm2 = "sm2"
+
+	DefaultReplayKeyIdHeader     = "X-Signature-Key"
+	DefaultReplayTimestampHeader = "X-Signature-Timestamp"
+	DefaultReplayNonceHeader     = "X-Signature-Nonce"
+	DefaultReplaySignatureHeader = "X-Signature"
+	DefaultReplayWindow          = 5 * time.Minute
+	DefaultReplayMaxBodySize     = 1 << 20
+	DefaultReplayMaxNonces       = 100000
+
+	minReplayNonceLength = 8
+	maxReplayNonceLength = 128
+)
+
+var (
+	ErrReplaySignatureMissing = errors.New("request signature, key, timestamp or nonce missing")
+	ErrReplaySignatureInvalid = errors.New("request signature invalid")
+	ErrReplayTimestampInvalid = errors.New("request timestamp invalid or outside the allowed window")
+	ErrReplayNonceInvalid     = errors.New("request nonce invalid")
+	ErrReplayNonceReused      = errors.New("request nonce has already been used")
+	ErrReplayBodyTooLarge     = errors.New("signed request body exceeds the maximum size")
+	ErrReplayNonceStoreFull   = errors.New("request nonce store is full")
+)
+
+// ReplaySignatureScheme verifies the signatures of requests protected by a replay handler
+type ReplaySignatureScheme interface {
+	// Digest hashes a request body for inclusion in the signed message
+	Digest(body []byte) []byte
+
+	// Verify returns nil if signature is valid for message under the key identified by keyId
+	Verify(keyId string, message []byte, signature []byte) error
+}
+
+// ReplayNonceStore records the nonces of accepted requests
+type ReplayNonceStore interface {
+	// Use records a nonce until expiresAt, returning false if it is already recorded
+	Use(nonce string, expiresAt time.Time) (bool, error)
+}
+
+// ReplayOptions configures a handler created by NewReplayHandler. Clients sign ReplayMessage for each request and send
+// the key id, unix timestamp, nonce and base64 signature in headers. Requests are rejected if their timestamp is more
+// than Window away from the server's clock, their signature is invalid, or their nonce has been used by the same key
+// within the window.
+type ReplayOptions struct {
+	Scheme     ReplaySignatureScheme
+	schemeName string
+
+	KeyIdHeader     string
+	TimestampHeader string
+	NonceHeader     string
+	SignatureHeader string
+
+	Window time.Duration
+
+	// MaxBodySize bounds the request bodies read into memory to be digested
+	MaxBodySize int64
+
+	// NonceStore records used nonces, defaults to an in memory store holding at most MaxNonces nonces
+	NonceStore ReplayNonceStore
+	MaxNonces  int
+
+	// OnFailure writes the response for requests that fail verification, defaults to http.StatusUnauthorized
+	OnFailure func(writer gmhttp.ResponseWriter, request *gmhttp.Request, err error)
+
+	now func() time.Time
+}
+
+// Default provides defaults for all necessary values
+func (options *ReplayOptions) Default() {
+	options.KeyIdHeader = DefaultReplayKeyIdHeader
+	options.TimestampHeader = DefaultReplayTimestampHeader
+	options.NonceHeader = DefaultReplayNonceHeader
+	options.SignatureHeader = DefaultReplaySignatureHeader
+	options.Window = DefaultReplayWindow
+	options.MaxBodySize = DefaultReplayMaxBodySize
+	options.MaxNonces = DefaultReplayMaxNonces
+}
+
+// Parse parses a configuration map. For the HMAC schemes, secrets maps key ids to shared secrets. For ReplaySchemeSm2,
+// publicKeys maps key ids to PEM encoded public keys or PEM file paths.
+func (options *ReplayOptions) Parse(config map[interface{}]interface{}) error {
+	for field, target := range map[string]*string{"keyIdHeader": &options.KeyIdHeader, "timestampHeader": &options.TimestampHeader, "nonceHeader": &options.NonceHeader, "signatureHeader": &options.SignatureHeader} {
+		if interfaceVal, ok := config[field]; ok {
+			if value, ok := interfaceVal.(string); ok {
+				*target = value
+			} else {
+				return fmt.Errorf("could not use value for %s, not a string", field)
+			}
+		}
+	}
+
+	if interfaceVal, ok := config["window"]; ok {
+		if windowStr, ok := interfaceVal.(string); ok {
+			if window, err := time.ParseDuration(windowStr); err == nil {
+				options.Window = window
+			} else {
+				return fmt.Errorf("could not parse window %s as a duration (e.g. 1m): %v", windowStr, err)
+			}
+		} else {
+			return errors.New("could not use value for window, not a string")
+		}
+	}
+
+	if interfaceVal, ok := config["maxBodySize"]; ok {
+		size, err := parseByteSize(interfaceVal)
+		if err != nil {
+			return fmt.Errorf("could not use value for maxBodySize: %v", err)
+		}
+		options.MaxBodySize = size
+	}
+
+	if interfaceVal, ok := config["maxNonces"]; ok {
+		if maxNonces, ok := interfaceVal.(int); ok {
+			options.MaxNonces = maxNonces
+		} else {
+			return errors.New("could not use value for maxNonces, not an integer")
+		}
+	}
+
+	options.schemeName = ReplaySchemeHmacSha256
+	if interfaceVal, ok := config["scheme"]; ok {
+		if options.schemeName, ok = interfaceVal.(string); !ok {
+			return errors.New("could not use value for scheme, not a string")
+		}
+	}
+
+	keysField := "secrets"
+	if options.schemeName == ReplaySchemeSm2 {
+		keysField = "publicKeys"
+	}
+
+	keys := map[string]string{}
+	if interfaceVal, ok := config[keysField]; ok {
+		keyMap, ok := interfaceVal.(map[interface{}]interface{})
+		if !ok {
+			return fmt.Errorf("could not use value for %s, not a map", keysField)
+		}
+
+		for keyIdInterface, keyInterface := range keyMap {
+			keyId, ok := keyIdInterface.(string)
+			if !ok {
+				return fmt.Errorf("could not use key id [%v] in %s, not a string", keyIdInterface, keysField)
+			}
+			key, ok := keyInterface.(string)
+			if !ok || key == "" {
+				return fmt.Errorf("could not use value for %s.%s, not a non-empty string", keysField, keyId)
+			}
+			keys[keyId] = key
+		}
+	}
+
+	scheme, err := newReplaySignatureScheme(options.schemeName, keys)
+	if err != nil {
+		return err
+	}
+	options.Scheme = scheme
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (options *ReplayOptions) Validate() error {
+	if options.Scheme == nil {
+		return errors.New("a signature scheme is required")
+	}
+
+	if options.KeyIdHeader == "" || options.TimestampHeader == "" || options.NonceHeader == "" || options.SignatureHeader == "" {
+		return errors.New("keyIdHeader, timestampHeader, nonceHeader and signatureHeader must not be empty")
+	}
+
+	if options.Window <= 0 {
+		return fmt.Errorf("value [%s] for window too low, must be positive", options.Window)
+	}
+
+	if options.MaxBodySize < 0 {
+		return errors.New("maxBodySize must not be negative")
+	}
+
+	if options.NonceStore == nil && options.MaxNonces < 1 {
+		return errors.New("maxNonces must be at least 1")
+	}
+
+	return nil
+}
+
+func newReplaySignatureScheme(name string, keys map[string]string) (ReplaySignatureScheme, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one key is required")
+	}
+
+	switch name {
+	case ReplaySchemeHmacSha256, ReplaySchemeHmacSm3:
+		secrets := map[string][]byte{}
+		for keyId, secret := range keys {
+			if len(secret) < 16 {
+				return nil, fmt.Errorf("secret for key [%s] must be at least 16 bytes", keyId)
+			}
+			secrets[keyId] = []byte(secret)
+		}
+
+		if name == ReplaySchemeHmacSm3 {
+			return NewHmacSignatureScheme(sm3.New, secrets), nil
+		}
+		return NewHmacSignatureScheme(sha256.New, secrets), nil
+	case ReplaySchemeSm2:
+		publicKeys := map[string]*sm2.PublicKey{}
+		for keyId, key := range keys {
+			publicKey, err := loadSm2PublicKey(key)
+			if err != nil {
+				return nil, fmt.Errorf("could not load public key [%s]: %v", keyId, err)
+			}
+			publicKeys[keyId] = publicKey
+		}
+		return NewSm2SignatureScheme(publicKeys), nil
+	}
+
+	return nil, fmt.Errorf("invalid scheme [%s], must be one of: %s, %s, %s", name, ReplaySchemeHmacSha256, ReplaySchemeHmacSm3, ReplaySchemeSm2)
+}
+
+// loadSm2PublicKey parses an inline PEM public key or reads one from a file
+func loadSm2PublicKey(key string) (*sm2.PublicKey, error) {
+	pemBytes := []byte(key)
+	if !strings.Contains(key, "-----BEGIN") {
+		var err error
+		if pemBytes, err = os.ReadFile(key); err != nil {
+			return nil, err
+		}
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if sm2Key, ok := publicKey.(*sm2.PublicKey); ok {
+		return sm2Key, nil
+	}
+	return nil, errors.New("not an SM2 public key")
+}
+
+// NewHmacSignatureScheme returns a ReplaySignatureScheme verifying HMACs made with newHash, which also digests bodies,
+// over the secrets of the key ids
+func NewHmacSignatureScheme(newHash func() hash.Hash, secrets map[string][]byte) ReplaySignatureScheme {
+	return &hmacSignatureScheme{
+		newHash: newHash,
+		secrets: secrets,
+	}
+}
+
+type hmacSignatureScheme struct {
+	newHash func() hash.Hash
+	secrets map[string][]byte
+}
+
+func (scheme *hmacSignatureScheme) Digest(body []byte) []byte {
+	h := scheme.newHash()
+	_, _ = h.Write(body)
+	return h.Sum(nil)
+}
+
+func (scheme *hmacSignatureScheme) Verify(keyId string, message []byte, signature []byte) error {
+	secret, ok := scheme.secrets[keyId]
+	if !ok {
+		return ErrReplaySignatureInvalid
+	}
+
+	mac := hmac.New(scheme.newHash, secret)
+	_, _ = mac.Write(message)
+
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return ErrReplaySignatureInvalid
+	}
+	return nil
+}
+
+// NewSm2SignatureScheme returns a ReplaySignatureScheme verifying SM2 signatures with the public keys of the key ids
+// and digesting bodies with SM3
+func NewSm2SignatureScheme(publicKeys map[string]*sm2.PublicKey) ReplaySignatureScheme {
+	return &sm2SignatureScheme{
+		publicKeys: publicKeys,
+	}
+}
+
+type sm2SignatureScheme struct {
+	publicKeys map[string]*sm2.PublicKey
+}
+
+func (scheme *sm2SignatureScheme) Digest(body []byte) []byte {
+	return sm3.Sm3Sum(body)
+}
+
+func (scheme *sm2SignatureScheme) Verify(keyId string, message []byte, signature []byte) error {
+	publicKey, ok := scheme.publicKeys[keyId]
+	if !ok || !publicKey.Verify(message, signature) {
+		return ErrReplaySignatureInvalid
+	}
+	return nil
+}
+
+// ReplayMessage returns the message signed for a request: the method, request URI, timestamp, nonce, key id and hex
+// encoded body digest separated by newlines
+func ReplayMessage(method string, requestUri string, timestamp int64, nonce string, keyId string, bodyDigest []byte) []byte {
+	return []byte(strings.Join([]string{
+		strings.ToUpper(method),
+		requestUri,
+		strconv.FormatInt(timestamp, 10),
+		nonce,
+		keyId,
+		hex.EncodeToString(bodyDigest),
+	}, "\n"))
+}
+
+// NewMemoryReplayNonceStore returns a ReplayNonceStore holding at most maxNonces unexpired nonces in memory. Nonces
+// are kept in order of expiry so that expired nonces are evicted from the front without scanning the whole store.
+func NewMemoryReplayNonceStore(maxNonces int) ReplayNonceStore {
+	return &memoryReplayNonceStore{
+		nonces:    map[string]time.Time{},
+		maxNonces: maxNonces,
+		now:       time.Now,
+	}
+}
+
+type memoryReplayNonceStore struct {
+	lock      sync.Mutex
+	nonces    map[string]time.Time
+	expiries  replayNonceExpiries
+	maxNonces int
+	now       func() time.Time
+}
+
+func (store *memoryReplayNonceStore) Use(nonce string, expiresAt time.Time) (bool, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	now := store.now()
+	store.evict(now)
+
+	if _, ok := store.nonces[nonce]; ok {
+		return false, nil
+	}
+
+	if len(store.nonces) >= store.maxNonces {
+		return false, ErrReplayNonceStoreFull
+	}
+
+	store.nonces[nonce] = expiresAt
+	heap.Push(&store.expiries, replayNonceExpiry{nonce: nonce, expiresAt: expiresAt})
+	return true, nil
+}
+
+// evict removes nonces that have expired by now, must be called with the lock held
+func (store *memoryReplayNonceStore) evict(now time.Time) {
+	for len(store.expiries) > 0 && !now.Before(store.expiries[0].expiresAt) {
+		expired := heap.Pop(&store.expiries).(replayNonceExpiry)
+		delete(store.nonces, expired.nonce)
+	}
+}
+
+type replayNonceExpiry struct {
+	nonce     string
+	expiresAt time.Time
+}
+
+// replayNonceExpiries is a heap.Interface of nonces ordered by expiry, earliest first
+type replayNonceExpiries []replayNonceExpiry
+
+func (expiries replayNonceExpiries) Len() int {
+	return len(expiries)
+}
+
+func (expiries replayNonceExpiries) Less(i, j int) bool {
+	return expiries[i].expiresAt.Before(expiries[j].expiresAt)
+}
+
+func (expiries replayNonceExpiries) Swap(i, j int) {
+	expiries[i], expiries[j] = expiries[j], expiries[i]
+}
+
+func (expiries *replayNonceExpiries) Push(x interface{}) {
+	*expiries = append(*expiries, x.(replayNonceExpiry))
+}
+
+func (expiries *replayNonceExpiries) Pop() interface{} {
+	old := *expiries
+	last := old[len(old)-1]
+	*expiries = old[:len(old)-1]
+	return last
+}
+
+// NewReplayHandler returns a http.Handler that verifies request signatures, timestamps and nonces before calling next,
+// see ReplayOptions. Request bodies are read into memory to be digested and are replayed to next.
+func NewReplayHandler(options *ReplayOptions, next gmhttp.Handler) gmhttp.Handler {
+	onFailure := options.OnFailure
+	if onFailure == nil {
+		onFailure = func(writer gmhttp.ResponseWriter, _ *gmhttp.Request, err error) {
+			gmhttp.Error(writer, err.Error(), gmhttp.StatusUnauthorized)
+		}
+	}
+
+	nonceStore := options.NonceStore
+	if nonceStore == nil {
+		nonceStore = NewMemoryReplayNonceStore(options.MaxNonces)
+	}
+
+	now := options.now
+	if now == nil {
+		now = time.Now
+	}
+
+	return gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		if err := options.verify(request, nonceStore, now()); err != nil {
+			onFailure(writer, request, err)
+			return
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func (options *ReplayOptions) verify(request *gmhttp.Request, nonceStore ReplayNonceStore, now time.Time) error {
+	keyId := request.Header.Get(options.KeyIdHeader)
+	timestampStr := request.Header.Get(options.TimestampHeader)
+	nonce := request.Header.Get(options.NonceHeader)
+	signatureStr := request.Header.Get(options.SignatureHeader)
+
+	if keyId == "" || timestampStr == "" || nonce == "" || signatureStr == "" {
+		return ErrReplaySignatureMissing
+	}
+
+	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return ErrReplayTimestampInvalid
+	}
+
+	signedAt := time.Unix(timestamp, 0)
+	if signedAt.Before(now.Add(-options.Window)) || signedAt.After(now.Add(options.Window)) {
+		return ErrReplayTimestampInvalid
+	}
+
+	if len(nonce) < minReplayNonceLength || len(nonce) > maxReplayNonceLength || strings.ContainsAny(nonce, " \t\r\n") {
+		return ErrReplayNonceInvalid
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(signatureStr)
+	if err != nil {
+		return ErrReplaySignatureInvalid
+	}
+
+	body, err := readReplayBody(request, options.MaxBodySize)
+	if err != nil {
+		return err
+	}
+
+	message := ReplayMessage(request.Method, request.URL.RequestURI(), timestamp, nonce, keyId, options.Scheme.Digest(body))
+	if err = options.Scheme.Verify(keyId, message, signature); err != nil {
+		return err
+	}
+
+	// nonces are scoped to keys and only need to be remembered while their timestamp is within the window
+	unused, err := nonceStore.Use(keyId+"\x00"+nonce, signedAt.Add(options.Window))
+	if err != nil {
+		return err
+	}
+	if !unused {
+		return ErrReplayNonceReused
+	}
+
+	return nil
+}
+
+// readReplayBody reads the request body into memory and replaces it so that it may be read again
+func readReplayBody(request *gmhttp.Request, maxBodySize int64) ([]byte, error) {
+	if request.Body == nil || request.Body == gmhttp.NoBody {
+		return nil, nil
+	}
+
+	reader := io.Reader(request.Body)
+	if maxBodySize > 0 {
+		if request.ContentLength > maxBodySize {
+			return nil, ErrReplayBodyTooLarge
+		}
+		reader = io.LimitReader(request.Body, maxBodySize+1)
+	}
+
+	body, err := io.ReadAll(reader)
+	_ = request.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if maxBodySize > 0 && int64(len(body)) > maxBodySize {
+		return nil, ErrReplayBodyTooLarge
+	}
+
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
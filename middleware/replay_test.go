@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"gitee.com/zhaochuninhefei/gmgo/sm2"
+	"gitee.com/zhaochuninhefei/gmgo/sm3"
+	"gitee.com/zhaochuninhefei/gmgo/x509"
+	"github.com/stretchr/testify/require"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testReplaySecret = "0123456789abcdef"
+
+func newTestReplayHandler(req *require.Assertions, config map[interface{}]interface{}, now time.Time) gmhttp.Handler {
+	options := &ReplayOptions{}
+	options.Default()
+	req.NoError(options.Parse(config))
+	req.NoError(options.Validate())
+	options.now = func() time.Time {
+		return now
+	}
+
+	return NewReplayHandler(options, gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		body, _ := io.ReadAll(request.Body)
+		_, _ = writer.Write(body)
+	}))
+}
+
+func newSignedRequest(body string, timestamp time.Time, nonce string, sign func(message []byte) []byte, digest func([]byte) []byte) *gmhttp.Request {
+	request := gmhttptest.NewRequest("POST", "/things?a=b", strings.NewReader(body))
+	message := ReplayMessage(request.Method, request.URL.RequestURI(), timestamp.Unix(), nonce, "client-a", digest([]byte(body)))
+
+	request.Header.Set(DefaultReplayKeyIdHeader, "client-a")
+	request.Header.Set(DefaultReplayTimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+	request.Header.Set(DefaultReplayNonceHeader, nonce)
+	request.Header.Set(DefaultReplaySignatureHeader, base64.StdEncoding.EncodeToString(sign(message)))
+	return request
+}
+
+func hmacSigner(newHash func() hash.Hash) func([]byte) []byte {
+	return func(message []byte) []byte {
+		mac := hmac.New(newHash, []byte(testReplaySecret))
+		_, _ = mac.Write(message)
+		return mac.Sum(nil)
+	}
+}
+
+func hashDigest(newHash func() hash.Hash) func([]byte) []byte {
+	return func(body []byte) []byte {
+		h := newHash()
+		_, _ = h.Write(body)
+		return h.Sum(nil)
+	}
+}
+
+func serveReplay(handler gmhttp.Handler, request *gmhttp.Request) *gmhttptest.ResponseRecorder {
+	recorder := gmhttptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func Test_ReplayHandler(t *testing.T) {
+	now := time.Now()
+	hmacConfig := map[interface{}]interface{}{
+		"secrets": map[interface{}]interface{}{"client-a": testReplaySecret},
+	}
+	sign := hmacSigner(sha256.New)
+	digest := hashDigest(sha256.New)
+
+	t.Run("signed requests are served with their body", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestReplayHandler(req, hmacConfig, now)
+
+		recorder := serveReplay(handler, newSignedRequest("hello", now, "nonce-0001", sign, digest))
+
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal("hello", recorder.Body.String())
+	})
+
+	t.Run("replayed nonces are rejected", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestReplayHandler(req, hmacConfig, now)
+
+		req.Equal(gmhttp.StatusOK, serveReplay(handler, newSignedRequest("hello", now, "nonce-0002", sign, digest)).Code)
+
+		recorder := serveReplay(handler, newSignedRequest("hello", now, "nonce-0002", sign, digest))
+		req.Equal(gmhttp.StatusUnauthorized, recorder.Code)
+		req.Contains(recorder.Body.String(), ErrReplayNonceReused.Error())
+	})
+
+	t.Run("timestamps outside the window are rejected", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestReplayHandler(req, hmacConfig, now)
+
+		recorder := serveReplay(handler, newSignedRequest("hello", now.Add(-10*time.Minute), "nonce-0003", sign, digest))
+		req.Equal(gmhttp.StatusUnauthorized, recorder.Code)
+		req.Contains(recorder.Body.String(), ErrReplayTimestampInvalid.Error())
+	})
+
+	t.Run("tampered bodies are rejected", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestReplayHandler(req, hmacConfig, now)
+
+		request := newSignedRequest("hello", now, "nonce-0004", sign, digest)
+		request.Body = io.NopCloser(strings.NewReader("goodbye"))
+
+		recorder := serveReplay(handler, request)
+		req.Equal(gmhttp.StatusUnauthorized, recorder.Code)
+		req.Contains(recorder.Body.String(), ErrReplaySignatureInvalid.Error())
+	})
+
+	t.Run("unsigned requests are rejected", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestReplayHandler(req, hmacConfig, now)
+
+		recorder := serveReplay(handler, gmhttptest.NewRequest("GET", "/things", nil))
+		req.Equal(gmhttp.StatusUnauthorized, recorder.Code)
+	})
+
+	t.Run("large bodies are rejected", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestReplayHandler(req, map[interface{}]interface{}{
+			"secrets":     hmacConfig["secrets"],
+			"maxBodySize": 4,
+		}, now)
+
+		recorder := serveReplay(handler, newSignedRequest("hello", now, "nonce-0005", sign, digest))
+		req.Equal(gmhttp.StatusUnauthorized, recorder.Code)
+		req.Contains(recorder.Body.String(), ErrReplayBodyTooLarge.Error())
+	})
+
+	t.Run("hmac-sm3 signatures are verified", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestReplayHandler(req, map[interface{}]interface{}{
+			"scheme":  ReplaySchemeHmacSm3,
+			"secrets": hmacConfig["secrets"],
+		}, now)
+
+		req.Equal(gmhttp.StatusOK, serveReplay(handler, newSignedRequest("hello", now, "nonce-0006", hmacSigner(sm3.New), hashDigest(sm3.New))).Code)
+		req.Equal(gmhttp.StatusUnauthorized, serveReplay(handler, newSignedRequest("hello", now, "nonce-0007", sign, digest)).Code)
+	})
+
+	t.Run("sm2 signatures are verified", func(t *testing.T) {
+		req := require.New(t)
+		privateKey, err := sm2.GenerateKey(rand.Reader)
+		req.NoError(err)
+		publicDer, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+		req.NoError(err)
+		publicPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})
+
+		handler := newTestReplayHandler(req, map[interface{}]interface{}{
+			"scheme":     ReplaySchemeSm2,
+			"publicKeys": map[interface{}]interface{}{"client-a": string(publicPem)},
+		}, now)
+
+		sm2Sign := func(message []byte) []byte {
+			signature, err := privateKey.Sign(rand.Reader, message, nil)
+			req.NoError(err)
+			return signature
+		}
+
+		req.Equal(gmhttp.StatusOK, serveReplay(handler, newSignedRequest("hello", now, "nonce-0008", sm2Sign, sm3.Sm3Sum)).Code)
+		req.Equal(gmhttp.StatusUnauthorized, serveReplay(handler, newSignedRequest("hello", now, "nonce-0009", sign, sm3.Sm3Sum)).Code)
+	})
+
+	t.Run("invalid configurations are rejected", func(t *testing.T) {
+		req := require.New(t)
+		options := &ReplayOptions{}
+		options.Default()
+
+		req.Error(options.Parse(map[interface{}]interface{}{}))
+		req.Error(options.Parse(map[interface{}]interface{}{"scheme": "md5", "secrets": hmacConfig["secrets"]}))
+		req.Error(options.Parse(map[interface{}]interface{}{"secrets": map[interface{}]interface{}{"client-a": "short"}}))
+		req.Error(options.Parse(map[interface{}]interface{}{"scheme": ReplaySchemeSm2, "publicKeys": map[interface{}]interface{}{"client-a": "-----BEGIN PUBLIC KEY-----"}}))
+	})
+}
+
+func Test_memoryReplayNonceStore(t *testing.T) {
+	req := require.New(t)
+	now := time.Now()
+	store := NewMemoryReplayNonceStore(1).(*memoryReplayNonceStore)
+	store.now = func() time.Time {
+		return now
+	}
+
+	unused, err := store.Use("a", now.Add(time.Second))
+	req.NoError(err)
+	req.True(unused)
+
+	_, err = store.Use("b", now.Add(time.Second))
+	req.ErrorIs(err, ErrReplayNonceStoreFull)
+
+	now = now.Add(2 * time.Second)
+	unused, err = store.Use("b", now.Add(time.Second))
+	req.NoError(err)
+	req.True(unused)
+}
+
+func Test_memoryReplayNonceStoreEviction(t *testing.T) {
+	req := require.New(t)
+	now := time.Now()
+	store := NewMemoryReplayNonceStore(3).(*memoryReplayNonceStore)
+	store.now = func() time.Time {
+		return now
+	}
+
+	for nonce, ttl := range map[string]time.Duration{"late": 3 * time.Second, "early": time.Second, "middle": 2 * time.Second} {
+		unused, err := store.Use(nonce, now.Add(ttl))
+		req.NoError(err)
+		req.True(unused)
+	}
+
+	now = now.Add(1500 * time.Millisecond)
+	unused, err := store.Use("next", now.Add(time.Second))
+	req.NoError(err)
+	req.True(unused)
+
+	req.Len(store.nonces, 3)
+	req.NotContains(store.nonces, "early")
+
+	unused, err = store.Use("middle", now.Add(time.Second))
+	req.NoError(err)
+	req.False(unused)
+
+	now = now.Add(time.Second)
+	unused, err = store.Use("middle", now.Add(time.Second))
+	req.NoError(err)
+	req.True(unused)
+	req.Equal(len(store.nonces), store.expiries.Len())
+}
@@ -0,0 +1,350 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// RewriteRules are declarative changes applied to responses as their headers are written: headers are removed, set
+// and added, in that order, Location and Content-Location prefixes are rewritten and status codes are mapped.
+type RewriteRules struct {
+	// RemoveHeaders are header names removed from responses
+	RemoveHeaders []string
+
+	// SetHeaders replace any values of the response headers
+	SetHeaders gmhttp.Header
+
+	// AddHeaders are appended to the response headers
+	AddHeaders gmhttp.Header
+
+	// Locations rewrite the Location and Content-Location headers of responses, i.e. redirects from a proxied
+	// upstream. The first LocationRewrite whose From is a prefix of the header is applied.
+	Locations []LocationRewrite
+
+	// StatusMap replaces response status codes, e.g. 502 with 503
+	StatusMap map[int]int
+}
+
+// LocationRewrite replaces the prefix From of a location with To
+type LocationRewrite struct {
+	From string
+	To   string
+}
+
+// Parse parses a configuration map of the form:
+//
+//	headers:
+//	  remove: [ X-Powered-By ]
+//	  set: { Server: xweb }
+//	  add: { Vary: [ Origin ] }
+//	locations:
+//	  - from: http://10.0.0.5:8080/
+//	    to: https://api.example.com/
+//	status:
+//	  502: 503
+func (rules *RewriteRules) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["headers"]; ok {
+		headersMap, ok := interfaceVal.(map[interface{}]interface{})
+		if !ok {
+			return errors.New("could not use value for headers, not a map")
+		}
+
+		if interfaceVal, ok := headersMap["remove"]; ok {
+			names, err := parseRewriteStrings(interfaceVal)
+			if err != nil {
+				return fmt.Errorf("could not use value for headers.remove: %v", err)
+			}
+			for _, name := range names {
+				rules.RemoveHeaders = append(rules.RemoveHeaders, gmhttp.CanonicalHeaderKey(name))
+			}
+		}
+
+		for field, target := range map[string]*gmhttp.Header{"set": &rules.SetHeaders, "add": &rules.AddHeaders} {
+			if interfaceVal, ok := headersMap[field]; ok {
+				headers, err := parseRewriteHeaders(interfaceVal)
+				if err != nil {
+					return fmt.Errorf("could not use value for headers.%s: %v", field, err)
+				}
+				*target = headers
+			}
+		}
+	}
+
+	if interfaceVal, ok := config["locations"]; ok {
+		locationList, ok := interfaceVal.([]interface{})
+		if !ok {
+			return errors.New("could not use value for locations, not a list")
+		}
+
+		for _, locationInterface := range locationList {
+			locationMap, ok := locationInterface.(map[interface{}]interface{})
+			if !ok {
+				return errors.New("could not use value for locations, entries must be maps")
+			}
+
+			from, fromOk := locationMap["from"].(string)
+			to, toOk := locationMap["to"].(string)
+			if !fromOk || !toOk {
+				return errors.New("could not use value for locations, entries must have from and to strings")
+			}
+			rules.Locations = append(rules.Locations, LocationRewrite{From: from, To: to})
+		}
+	}
+
+	if interfaceVal, ok := config["status"]; ok {
+		statusMap, ok := interfaceVal.(map[interface{}]interface{})
+		if !ok {
+			return errors.New("could not use value for status, not a map")
+		}
+
+		rules.StatusMap = map[int]int{}
+		for fromInterface, toInterface := range statusMap {
+			from, err := parseRewriteStatus(fromInterface)
+			if err != nil {
+				return err
+			}
+			to, err := parseRewriteStatus(toInterface)
+			if err != nil {
+				return err
+			}
+			rules.StatusMap[from] = to
+		}
+	}
+
+	return nil
+}
+
+func parseRewriteStrings(val interface{}) ([]string, error) {
+	switch values := val.(type) {
+	case string:
+		return []string{values}, nil
+	case []interface{}:
+		var result []string
+		for _, entry := range values {
+			value, ok := entry.(string)
+			if !ok {
+				return nil, fmt.Errorf("value [%v] is not a string", entry)
+			}
+			result = append(result, value)
+		}
+		return result, nil
+	}
+
+	return nil, errors.New("not a string or list of strings")
+}
+
+func parseRewriteHeaders(val interface{}) (gmhttp.Header, error) {
+	headerMap, ok := val.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("not a map")
+	}
+
+	result := gmhttp.Header{}
+	for nameInterface, valueInterface := range headerMap {
+		name, ok := nameInterface.(string)
+		if !ok {
+			return nil, fmt.Errorf("header name [%v] is not a string", nameInterface)
+		}
+
+		values, err := parseRewriteStrings(valueInterface)
+		if err != nil {
+			return nil, fmt.Errorf("header [%s]: %v", name, err)
+		}
+
+		for _, value := range values {
+			result.Add(name, value)
+		}
+	}
+
+	return result, nil
+}
+
+func parseRewriteStatus(val interface{}) (int, error) {
+	switch status := val.(type) {
+	case int:
+		return status, nil
+	case string:
+		if result, err := strconv.Atoi(status); err == nil {
+			return result, nil
+		}
+	}
+
+	return 0, fmt.Errorf("could not use value [%v] for status, not a status code", val)
+}
+
+// Validate validates all settings and return nil or an error
+func (rules *RewriteRules) Validate() error {
+	for _, name := range rules.RemoveHeaders {
+		if !isRewriteHeaderName(name) {
+			return fmt.Errorf("invalid header name [%s]", name)
+		}
+	}
+
+	for _, headers := range []gmhttp.Header{rules.SetHeaders, rules.AddHeaders} {
+		for name, values := range headers {
+			if !isRewriteHeaderName(name) {
+				return fmt.Errorf("invalid header name [%s]", name)
+			}
+			for _, value := range values {
+				if strings.ContainsAny(value, "\r\n\x00") {
+					return fmt.Errorf("invalid value for header [%s], must not contain control characters", name)
+				}
+			}
+		}
+	}
+
+	for _, location := range rules.Locations {
+		if location.From == "" {
+			return errors.New("location rewrites must have a from prefix")
+		}
+		if strings.ContainsAny(location.To, "\r\n\x00") {
+			return fmt.Errorf("invalid location rewrite to [%s], must not contain control characters", location.To)
+		}
+	}
+
+	for from, to := range rules.StatusMap {
+		if from < 200 || from > 599 || to < 200 || to > 599 {
+			return fmt.Errorf("invalid status mapping [%d] to [%d], status codes must be 200-599", from, to)
+		}
+	}
+
+	return nil
+}
+
+func isRewriteHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewRewriteHandler returns a http.Handler that applies the RewriteRules to the responses of next
+func NewRewriteHandler(rules *RewriteRules, next gmhttp.Handler) gmhttp.Handler {
+	return gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		rewriteWriter := &rewriteResponseWriter{ResponseWriter: writer, rules: rules}
+		next.ServeHTTP(rewriteWriter, request)
+
+		// responses without a status or body are completed by the server after the handler returns
+		if !rewriteWriter.wroteHeader && !rewriteWriter.hijacked {
+			rewriteWriter.WriteHeader(gmhttp.StatusOK)
+		}
+	})
+}
+
+// rewriteResponseWriter applies RewriteRules when the status is written
+type rewriteResponseWriter struct {
+	gmhttp.ResponseWriter
+	rules       *RewriteRules
+	wroteHeader bool
+	hijacked    bool
+}
+
+func (w *rewriteResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	// informational responses are passed through, the final response follows
+	if status >= 100 && status <= 199 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(w.rules.apply(w.Header(), status))
+}
+
+func (w *rewriteResponseWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(gmhttp.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *rewriteResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(gmhttp.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(gmhttp.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *rewriteResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(gmhttp.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("wrapped response writer does not support hijacking")
+	}
+	w.hijacked = true
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the wrapped http.ResponseWriter
+func (w *rewriteResponseWriter) Unwrap() gmhttp.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// apply rewrites the headers of a response and returns its status
+func (rules *RewriteRules) apply(header gmhttp.Header, status int) int {
+	for _, name := range rules.RemoveHeaders {
+		header.Del(name)
+	}
+
+	for name, values := range rules.SetHeaders {
+		header[name] = append([]string(nil), values...)
+	}
+
+	for name, values := range rules.AddHeaders {
+		header[name] = append(header[name], values...)
+	}
+
+	if len(rules.Locations) > 0 {
+		for _, name := range []string{"Location", "Content-Location"} {
+			if location := header.Get(name); location != "" {
+				header.Set(name, rules.rewriteLocation(location))
+			}
+		}
+	}
+
+	if mapped, ok := rules.StatusMap[status]; ok {
+		return mapped
+	}
+
+	return status
+}
+
+func (rules *RewriteRules) rewriteLocation(location string) string {
+	for _, rewrite := range rules.Locations {
+		if strings.HasPrefix(location, rewrite.From) {
+			return rewrite.To + strings.TrimPrefix(location, rewrite.From)
+		}
+	}
+	return location
+}
@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_RewriteHandler(t *testing.T) {
+	newRules := func(req *require.Assertions, config map[interface{}]interface{}) *RewriteRules {
+		rules := &RewriteRules{}
+		req.NoError(rules.Parse(config))
+		req.NoError(rules.Validate())
+		return rules
+	}
+
+	serve := func(rules *RewriteRules, handler gmhttp.HandlerFunc) *gmhttptest.ResponseRecorder {
+		recorder := gmhttptest.NewRecorder()
+		NewRewriteHandler(rules, handler).ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/things", nil))
+		return recorder
+	}
+
+	t.Run("headers are removed, set and added", func(t *testing.T) {
+		req := require.New(t)
+		rules := newRules(req, map[interface{}]interface{}{
+			"headers": map[interface{}]interface{}{
+				"remove": []interface{}{"x-powered-by"},
+				"set":    map[interface{}]interface{}{"Server": "xweb"},
+				"add":    map[interface{}]interface{}{"Vary": []interface{}{"Origin"}},
+			},
+		})
+
+		recorder := serve(rules, func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.Header().Set("X-Powered-By", "upstream")
+			writer.Header().Add("Server", "upstream")
+			writer.Header().Add("Vary", "Accept")
+			_, _ = writer.Write([]byte("ok"))
+		})
+
+		req.Empty(recorder.Header().Values("X-Powered-By"))
+		req.Equal([]string{"xweb"}, recorder.Header().Values("Server"))
+		req.Equal([]string{"Accept", "Origin"}, recorder.Header().Values("Vary"))
+		req.Equal("ok", recorder.Body.String())
+	})
+
+	t.Run("locations are rewritten by prefix", func(t *testing.T) {
+		req := require.New(t)
+		rules := newRules(req, map[interface{}]interface{}{
+			"locations": []interface{}{
+				map[interface{}]interface{}{"from": "http://10.0.0.5:8080/", "to": "https://api.example.com/v1/"},
+			},
+		})
+
+		recorder := serve(rules, func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.Header().Set("Location", "http://10.0.0.5:8080/things/1")
+			writer.Header().Set("Content-Location", "https://other.example.com/")
+			writer.WriteHeader(gmhttp.StatusFound)
+		})
+
+		req.Equal(gmhttp.StatusFound, recorder.Code)
+		req.Equal("https://api.example.com/v1/things/1", recorder.Header().Get("Location"))
+		req.Equal("https://other.example.com/", recorder.Header().Get("Content-Location"))
+	})
+
+	t.Run("status codes are mapped", func(t *testing.T) {
+		req := require.New(t)
+		rules := newRules(req, map[interface{}]interface{}{
+			"status": map[interface{}]interface{}{502: 503, "404": "410"},
+		})
+
+		for status, expected := range map[int]int{502: 503, 404: 410, 500: 500} {
+			recorder := serve(rules, func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+				writer.WriteHeader(status)
+			})
+			req.Equal(expected, recorder.Code)
+		}
+	})
+
+	t.Run("rules are applied to responses the handler did not write", func(t *testing.T) {
+		req := require.New(t)
+		rules := newRules(req, map[interface{}]interface{}{
+			"headers": map[interface{}]interface{}{"set": map[interface{}]interface{}{"Cache-Control": "no-store"}},
+			"status":  map[interface{}]interface{}{200: 204},
+		})
+
+		recorder := serve(rules, func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {})
+
+		req.Equal(gmhttp.StatusNoContent, recorder.Code)
+		req.Equal("no-store", recorder.Header().Get("Cache-Control"))
+	})
+
+	t.Run("informational responses are passed through", func(t *testing.T) {
+		req := require.New(t)
+		rules := newRules(req, map[interface{}]interface{}{"status": map[interface{}]interface{}{200: 202}})
+
+		writer := &statusRecordingWriter{ResponseRecorder: gmhttptest.NewRecorder()}
+		NewRewriteHandler(rules, gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.WriteHeader(gmhttp.StatusEarlyHints)
+			_, _ = writer.Write([]byte("ok"))
+		})).ServeHTTP(writer, gmhttptest.NewRequest("GET", "/things", nil))
+
+		req.Equal([]int{gmhttp.StatusEarlyHints, gmhttp.StatusAccepted}, writer.statuses)
+	})
+
+	t.Run("invalid rules are rejected", func(t *testing.T) {
+		req := require.New(t)
+		for config, expected := range map[string]map[interface{}]interface{}{
+			"could not use value for headers, not a map":                               {"headers": "x"},
+			"could not use value for headers.remove: value [1] is not a string":        {"headers": map[interface{}]interface{}{"remove": []interface{}{1}}},
+			"could not use value for locations, entries must have from and to strings": {"locations": []interface{}{map[interface{}]interface{}{"from": "a"}}},
+			"could not use value [abc] for status, not a status code":                  {"status": map[interface{}]interface{}{"abc": 200}},
+		} {
+			req.EqualError((&RewriteRules{}).Parse(expected), config)
+		}
+
+		for config, expected := range map[string]map[interface{}]interface{}{
+			"invalid header name [bad name]":                                      {"headers": map[interface{}]interface{}{"remove": "bad name"}},
+			"invalid value for header [X-A], must not contain control characters": {"headers": map[interface{}]interface{}{"set": map[interface{}]interface{}{"X-A": "a\r\nb"}}},
+			"location rewrites must have a from prefix":                           {"locations": []interface{}{map[interface{}]interface{}{"from": "", "to": "b"}}},
+			"invalid status mapping [200] to [99], status codes must be 200-599":  {"status": map[interface{}]interface{}{200: 99}},
+		} {
+			rules := &RewriteRules{}
+			req.NoError(rules.Parse(expected))
+			req.EqualError(rules.Validate(), config)
+		}
+	})
+}
+
+type statusRecordingWriter struct {
+	*gmhttptest.ResponseRecorder
+	statuses []int
+}
+
+func (writer *statusRecordingWriter) WriteHeader(status int) {
+	writer.statuses = append(writer.statuses, status)
+}
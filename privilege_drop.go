@@ -0,0 +1,97 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"errors"
+)
+
+// PrivilegeDropOptions configures the user and group the process switches to after all bind points have been
+// listened on and before any requests are served. This allows privileged ports to be bound while running as root
+// without serving as root. Files required after startup (i.e. identity files that are reloaded) must be readable by
+// the configured user.
+type PrivilegeDropOptions struct {
+	// User is a user name or numeric uid. If Group is not set, the user's primary group is used.
+	User string
+
+	// Group is a group name or numeric gid
+	Group string
+
+	resolved bool
+	uid      int
+	gid      int
+}
+
+// IsEnabled returns true if a user or group has been configured
+func (options *PrivilegeDropOptions) IsEnabled() bool {
+	return options.User != "" || options.Group != ""
+}
+
+// Parse parses a configuration map
+func (options *PrivilegeDropOptions) Parse(optionsMap map[interface{}]interface{}) error {
+	if interfaceVal, ok := optionsMap["user"]; ok {
+		if user, ok := interfaceVal.(string); ok {
+			options.User = user
+		} else {
+			return errors.New("could not use value for user, not a string")
+		}
+	}
+
+	if interfaceVal, ok := optionsMap["group"]; ok {
+		if group, ok := interfaceVal.(string); ok {
+			options.Group = group
+		} else {
+			return errors.New("could not use value for group, not a string")
+		}
+	}
+
+	return nil
+}
+
+// Resolve looks up the uid and gid of the configured user and group. User and group databases may not be readable
+// once SandboxOptions.Chroot has been applied, so Resolve must be called before. It is a no-op if no user or group has
+// been configured or they have already been resolved.
+func (options *PrivilegeDropOptions) Resolve() error {
+	if !options.IsEnabled() || options.resolved {
+		return nil
+	}
+
+	uid, gid, err := lookupIds(options)
+	if err != nil {
+		return err
+	}
+
+	options.uid = uid
+	options.gid = gid
+	options.resolved = true
+
+	return nil
+}
+
+// DropPrivileges switches the process to the configured user and group, resolving them first if Resolve has not been
+// called. It is a no-op if no user or group has been configured.
+func (options *PrivilegeDropOptions) DropPrivileges() error {
+	if !options.IsEnabled() {
+		return nil
+	}
+
+	if err := options.Resolve(); err != nil {
+		return err
+	}
+
+	return setIds(options.uid, options.gid)
+}
@@ -0,0 +1,32 @@
+//go:build windows || plan9 || js || wasip1
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func lookupIds(_ *PrivilegeDropOptions) (int, int, error) {
+	return -1, -1, fmt.Errorf("dropping privileges is not supported on %s", runtime.GOOS)
+}
+
+func setIds(_, _ int) error {
+	return fmt.Errorf("dropping privileges is not supported on %s", runtime.GOOS)
+}
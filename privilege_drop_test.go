@@ -0,0 +1,57 @@
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"runtime"
+	"testing"
+)
+
+func Test_PrivilegeDropOptions(t *testing.T) {
+	t.Run("user and group are parsed", func(t *testing.T) {
+		req := require.New(t)
+		options := &PrivilegeDropOptions{}
+		req.False(options.IsEnabled())
+
+		req.NoError(options.Parse(map[interface{}]interface{}{"user": "nobody", "group": "nogroup"}))
+		req.True(options.IsEnabled())
+		req.Equal("nobody", options.User)
+		req.Equal("nogroup", options.Group)
+	})
+
+	t.Run("non string values are rejected", func(t *testing.T) {
+		req := require.New(t)
+		options := &PrivilegeDropOptions{}
+		req.EqualError(options.Parse(map[interface{}]interface{}{"user": 65534}), "could not use value for user, not a string")
+	})
+
+	t.Run("dropping privileges is a no-op if not configured", func(t *testing.T) {
+		req := require.New(t)
+		options := &PrivilegeDropOptions{}
+		req.NoError(options.DropPrivileges())
+	})
+
+	t.Run("users and groups are resolved once, before privileges are dropped", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("dropping privileges is not supported on windows")
+		}
+
+		req := require.New(t)
+		options := &PrivilegeDropOptions{User: "root"}
+		req.NoError(options.Resolve())
+		req.True(options.resolved)
+		req.Equal(0, options.uid)
+		req.Equal(0, options.gid)
+
+		//resolved ids are retained, i.e. after a chroot without user databases
+		options.User = "no-such-user"
+		req.NoError(options.Resolve())
+		req.Equal(0, options.uid)
+	})
+
+	t.Run("unknown users are reported when resolved", func(t *testing.T) {
+		req := require.New(t)
+		options := &PrivilegeDropOptions{User: "no-such-user-xweb"}
+		req.Error(options.Resolve())
+		req.False(options.resolved)
+	})
+}
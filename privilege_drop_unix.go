@@ -0,0 +1,97 @@
+//go:build !windows && !plan9 && !js && !wasip1
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"github.com/michaelquigley/pfxlog"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// lookupIds returns the uid and gid of the configured user and group, -1 for those that are not configured
+func lookupIds(options *PrivilegeDropOptions) (int, int, error) {
+	uid, gid := -1, -1
+
+	if options.User != "" {
+		runAs, err := user.Lookup(options.User)
+		if err != nil {
+			if runAs, err = user.LookupId(options.User); err != nil {
+				return -1, -1, fmt.Errorf("could not find user [%s]: %v", options.User, err)
+			}
+		}
+
+		if uid, err = strconv.Atoi(runAs.Uid); err != nil {
+			return -1, -1, fmt.Errorf("could not use uid [%s] of user [%s]: %v", runAs.Uid, options.User, err)
+		}
+
+		if options.Group == "" {
+			if gid, err = strconv.Atoi(runAs.Gid); err != nil {
+				return -1, -1, fmt.Errorf("could not use primary gid [%s] of user [%s]: %v", runAs.Gid, options.User, err)
+			}
+		}
+	}
+
+	if options.Group != "" {
+		group, err := user.LookupGroup(options.Group)
+		if err != nil {
+			if group, err = user.LookupGroupId(options.Group); err != nil {
+				return -1, -1, fmt.Errorf("could not find group [%s]: %v", options.Group, err)
+			}
+		}
+
+		if gid, err = strconv.Atoi(group.Gid); err != nil {
+			return -1, -1, fmt.Errorf("could not use gid [%s] of group [%s]: %v", group.Gid, options.Group, err)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// setIds switches the process to uid and gid, either may be -1 to leave it unchanged
+func setIds(uid, gid int) error {
+	//the group must be changed first, changing the user removes the permission to do so
+	if gid >= 0 {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("could not set supplementary groups to [%d]: %v", gid, err)
+		}
+
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("could not set gid to [%d]: %v", gid, err)
+		}
+	}
+
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("could not set uid to [%d]: %v", uid, err)
+		}
+
+		if uid != 0 {
+			if err := syscall.Setuid(0); err == nil {
+				return fmt.Errorf("root privileges could be regained after setting uid to [%d]", uid)
+			}
+		}
+	}
+
+	pfxlog.Logger().Infof("xweb dropped privileges to uid [%d] gid [%d]", os.Getuid(), os.Getgid())
+
+	return nil
+}
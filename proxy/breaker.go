@@ -0,0 +1,99 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/openziti/xweb/v2/breaker"
+	"github.com/openziti/xweb/v2/factory"
+)
+
+// BreakerOptions attaches the proxy to the circuit breaker of a named dependency. While the breaker is open requests
+// are answered with http.StatusServiceUnavailable without calling the upstream. Transport errors and
+// http.StatusBadGateway, http.StatusServiceUnavailable and http.StatusGatewayTimeout responses are failures.
+type BreakerOptions struct {
+	// CircuitBreaker is the name of the dependency, empty to not use a circuit breaker. APIs naming the same
+	// dependency share its breaker.
+	CircuitBreaker string
+
+	breaker *breaker.Breaker
+}
+
+// resolveBreaker looks up the circuit breaker of the dependency in the breaker.Registry provided by the server, if
+// any. Otherwise, the proxy uses a breaker of its own with default breaker.Options.
+func (options *BreakerOptions) resolveBreaker(server factory.Server) {
+	if options.CircuitBreaker == "" {
+		return
+	}
+
+	if serviceServer, ok := server.(factory.ServiceServer); ok {
+		if breakers, err := factory.Resolve[*breaker.Registry](serviceServer.Services()); err == nil {
+			options.breaker = breakers.Get(options.CircuitBreaker)
+			return
+		}
+	}
+
+	defaults := breaker.Options{}
+	defaults.Default()
+	options.breaker = breaker.New(options.CircuitBreaker, defaults)
+}
+
+// newBreakerTransport wraps transport with the circuit breaker of the BreakerOptions, if any
+func newBreakerTransport(transport gmhttp.RoundTripper, options *BreakerOptions) gmhttp.RoundTripper {
+	if options.breaker == nil {
+		return transport
+	}
+
+	return &breakerTransport{
+		transport: transport,
+		breaker:   options.breaker,
+	}
+}
+
+// breakerTransport is a gmhttp.RoundTripper that records the outcome of upstream calls with a circuit breaker
+type breakerTransport struct {
+	transport gmhttp.RoundTripper
+	breaker   *breaker.Breaker
+}
+
+func (transport *breakerTransport) RoundTrip(request *gmhttp.Request) (*gmhttp.Response, error) {
+	call, err := transport.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := transport.transport.RoundTrip(request)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		// the inbound request went away, which says nothing about the upstream
+		call.Abandon()
+	case err != nil:
+		call.Done(false)
+	default:
+		switch response.StatusCode {
+		case gmhttp.StatusBadGateway, gmhttp.StatusServiceUnavailable, gmhttp.StatusGatewayTimeout:
+			call.Done(false)
+		default:
+			call.Done(true)
+		}
+	}
+
+	return response, err
+}
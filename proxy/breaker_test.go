@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/openziti/xweb/v2/breaker"
+	"github.com/openziti/xweb/v2/factory"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testServiceServer struct {
+	services *factory.Services
+}
+
+func (server *testServiceServer) Name() string {
+	return "test"
+}
+
+func (server *testServiceServer) Addresses() []string {
+	return nil
+}
+
+func (server *testServiceServer) Services() *factory.Services {
+	return server.services
+}
+
+func Test_CircuitBreaker(t *testing.T) {
+	t.Run("failing upstreams open the breaker shared through the server's registry", func(t *testing.T) {
+		req := require.New(t)
+		var calls int32
+		upstream := gmhttptest.NewServer(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			atomic.AddInt32(&calls, 1)
+			writer.WriteHeader(gmhttp.StatusServiceUnavailable)
+		}))
+		defer upstream.Close()
+
+		breakers := breaker.NewRegistry(nil)
+		req.NoError(breakers.Configure("backend", breaker.Options{FailureThreshold: 2, OpenTimeout: time.Minute, HalfOpenProbes: 1}))
+		services := factory.NewServices()
+		req.NoError(factory.Provide(services, breakers))
+
+		handler, err := NewFactory().New(&testServiceServer{services: services}, &testBinding{options: factory.Options{
+			"upstream":       upstream.URL,
+			"circuitBreaker": "backend",
+		}})
+		req.NoError(err)
+
+		for i := 0; i < 2; i++ {
+			recorder := gmhttptest.NewRecorder()
+			handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/things", nil))
+			req.Equal(gmhttp.StatusServiceUnavailable, recorder.Code)
+		}
+
+		circuitBreaker, ok := breakers.Lookup("backend")
+		req.True(ok)
+		req.Equal(breaker.StateOpen, circuitBreaker.State())
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/things", nil))
+		req.Equal(gmhttp.StatusServiceUnavailable, recorder.Code)
+		req.Equal(int32(2), atomic.LoadInt32(&calls))
+		req.Equal(int64(1), circuitBreaker.Status().Rejected)
+	})
+
+	t.Run("successful responses keep the breaker closed", func(t *testing.T) {
+		req := require.New(t)
+		upstream := gmhttptest.NewServer(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.WriteHeader(gmhttp.StatusNotFound)
+		}))
+		defer upstream.Close()
+
+		options := &Options{}
+		options.Default()
+		req.NoError(options.Parse(factory.Options{"upstream": upstream.URL, "circuitBreaker": "backend"}))
+		req.NoError(options.Validate())
+		handler := NewHandler(options)
+
+		for i := 0; i < breaker.DefaultFailureThreshold+1; i++ {
+			recorder := gmhttptest.NewRecorder()
+			handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/things", nil))
+			req.Equal(gmhttp.StatusNotFound, recorder.Code)
+		}
+
+		req.Equal(breaker.StateClosed, options.breaker.State())
+		req.Equal(int64(breaker.DefaultFailureThreshold+1), options.breaker.Status().Successes)
+	})
+}
@@ -0,0 +1,69 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"strconv"
+	"time"
+)
+
+// withBudget returns a context that is done when the request's budget is exhausted along with the remaining budget,
+// which is negative if the request has no deadline
+func (options *BudgetOptions) withBudget(ctx context.Context, received time.Time) (context.Context, context.CancelFunc, time.Duration) {
+	if options.Timeout > 0 {
+		deadline := received.Add(options.Timeout)
+		if inbound, ok := ctx.Deadline(); !ok || deadline.Before(inbound) {
+			ctx, cancel := context.WithDeadline(ctx, deadline)
+			return ctx, cancel, time.Until(deadline)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		return ctx, cancel, time.Until(deadline)
+	}
+	return ctx, cancel, -1
+}
+
+// setBudgetHeaders replaces any budget headers sent by the client with the request's remaining budget
+func (options *BudgetOptions) setBudgetHeaders(request *gmhttp.Request) {
+	if options.TimeoutHeader != "" {
+		request.Header.Del(options.TimeoutHeader)
+	}
+	if options.DeadlineHeader != "" {
+		request.Header.Del(options.DeadlineHeader)
+	}
+
+	deadline, ok := request.Context().Deadline()
+	if !ok {
+		return
+	}
+
+	if options.TimeoutHeader != "" {
+		remaining := time.Until(deadline).Milliseconds()
+		if remaining < 0 {
+			remaining = 0
+		}
+		request.Header.Set(options.TimeoutHeader, strconv.FormatInt(remaining, 10))
+	}
+
+	if options.DeadlineHeader != "" {
+		request.Header.Set(options.DeadlineHeader, strconv.FormatInt(deadline.UnixMilli(), 10))
+	}
+}
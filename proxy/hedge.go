@@ -0,0 +1,210 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHedgeMaxAttempts   = 2
+	DefaultHedgeBudgetPercent = 10
+
+	// hedgeBudgetBurst is the most hedged attempts that may be saved up while requests complete without hedging
+	hedgeBudgetBurst = 10
+)
+
+// HedgeOptions controls hedged requests. Requests with idempotent methods and no body that have not been answered
+// within HedgeDelay are sent again, up to HedgeMaxAttempts in total, and the first response received is used. Hedged
+// attempts are not made once the request budget of BudgetOptions has less than MinBudget remaining.
+type HedgeOptions struct {
+	// HedgeDelay is the time to wait for a response before sending another attempt, zero disables hedging
+	HedgeDelay time.Duration
+
+	// HedgeUpstreams are the upstreams hedged attempts are sent to in turn, the Upstream if empty. Only their scheme
+	// and host are used.
+	HedgeUpstreams []*url.URL
+
+	// HedgeMaxAttempts is the most attempts made for a request, including the first
+	HedgeMaxAttempts int
+
+	// HedgeBudgetPercent limits hedged attempts to a percentage of hedgeable requests, so that a slow upstream is not
+	// sent multiples of its usual load
+	HedgeBudgetPercent float64
+}
+
+// hedgingTransport is a gmhttp.RoundTripper that hedges requests as configured by HedgeOptions
+type hedgingTransport struct {
+	transport gmhttp.RoundTripper
+	options   *Options
+	budget    *hedgeBudget
+}
+
+type hedgeResult struct {
+	response *gmhttp.Response
+	err      error
+	cancel   context.CancelFunc
+}
+
+func newHedgingTransport(transport gmhttp.RoundTripper, options *Options) gmhttp.RoundTripper {
+	if options.HedgeDelay <= 0 || options.HedgeMaxAttempts < 2 {
+		return transport
+	}
+
+	return &hedgingTransport{
+		transport: transport,
+		options:   options,
+		budget: &hedgeBudget{
+			tokens: hedgeBudgetBurst,
+			ratio:  options.HedgeBudgetPercent / 100,
+		},
+	}
+}
+
+func (hedging *hedgingTransport) RoundTrip(request *gmhttp.Request) (*gmhttp.Response, error) {
+	if !isHedgeable(request) {
+		return hedging.transport.RoundTrip(request)
+	}
+
+	hedging.budget.deposit()
+
+	results := make(chan *hedgeResult, hedging.options.HedgeMaxAttempts)
+	attempt := func(n int) {
+		ctx, cancel := context.WithCancel(request.Context())
+		attemptRequest := request.Clone(ctx)
+		if n > 0 && len(hedging.options.HedgeUpstreams) > 0 {
+			upstream := hedging.options.HedgeUpstreams[(n-1)%len(hedging.options.HedgeUpstreams)]
+			attemptRequest.URL.Scheme = httpScheme(upstream.Scheme)
+			attemptRequest.URL.Host = upstream.Host
+		}
+
+		response, err := hedging.transport.RoundTrip(attemptRequest)
+		results <- &hedgeResult{response: response, err: err, cancel: cancel}
+	}
+
+	go attempt(0)
+	attempts, pending := 1, 1
+
+	timer := time.NewTimer(hedging.options.HedgeDelay)
+	defer timer.Stop()
+
+	var lastErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if attempts < hedging.options.HedgeMaxAttempts && hedging.hasBudget(request) {
+				go attempt(attempts)
+				attempts++
+				pending++
+				timer.Reset(hedging.options.HedgeDelay)
+			}
+		case result := <-results:
+			pending--
+			if result.err != nil {
+				result.cancel()
+				lastErr = result.err
+				continue
+			}
+
+			go discardHedgeResults(results, pending)
+			result.response.Body = &cancelOnClose{ReadCloser: result.response.Body, cancel: result.cancel}
+			return result.response, nil
+		}
+	}
+
+	return nil, lastErr
+}
+
+// hasBudget returns true if a hedged attempt may be sent for the request, consuming hedge budget if so
+func (hedging *hedgingTransport) hasBudget(request *gmhttp.Request) bool {
+	if deadline, ok := request.Context().Deadline(); ok && time.Until(deadline) < hedging.options.MinBudget {
+		return false
+	}
+	return hedging.budget.withdraw()
+}
+
+// discardHedgeResults cancels and closes the responses of attempts that completed after a response was chosen
+func discardHedgeResults(results chan *hedgeResult, pending int) {
+	for i := 0; i < pending; i++ {
+		result := <-results
+		result.cancel()
+		if result.response != nil {
+			_ = result.response.Body.Close()
+		}
+	}
+}
+
+// isHedgeable returns true if the request may safely be sent more than once
+func isHedgeable(request *gmhttp.Request) bool {
+	switch request.Method {
+	case gmhttp.MethodGet, gmhttp.MethodHead, gmhttp.MethodOptions, gmhttp.MethodTrace, gmhttp.MethodPut, gmhttp.MethodDelete:
+	default:
+		return false
+	}
+
+	if request.Body != nil && request.Body != gmhttp.NoBody && request.ContentLength != 0 {
+		return false
+	}
+
+	return request.Header.Get("Upgrade") == ""
+}
+
+// cancelOnClose cancels the context of the chosen attempt once its response body has been closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body *cancelOnClose) Close() error {
+	err := body.ReadCloser.Close()
+	body.cancel()
+	return err
+}
+
+// hedgeBudget is a token bucket that is credited a ratio of a token for every hedgeable request and debited a token
+// for every hedged attempt
+type hedgeBudget struct {
+	lock   sync.Mutex
+	tokens float64
+	ratio  float64
+}
+
+func (budget *hedgeBudget) deposit() {
+	budget.lock.Lock()
+	defer budget.lock.Unlock()
+
+	budget.tokens += budget.ratio
+	if budget.tokens > hedgeBudgetBurst {
+		budget.tokens = hedgeBudgetBurst
+	}
+}
+
+func (budget *hedgeBudget) withdraw() bool {
+	budget.lock.Lock()
+	defer budget.lock.Unlock()
+
+	if budget.tokens < 1 {
+		return false
+	}
+	budget.tokens--
+	return true
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/openziti/xweb/v2/factory"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newNamedUpstream returns a server that answers with its name after delay, or when the request is cancelled
+func newNamedUpstream(name string, delay time.Duration, calls *int32) *gmhttptest.Server {
+	return gmhttptest.NewServer(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		atomic.AddInt32(calls, 1)
+		select {
+		case <-time.After(delay):
+			_, _ = writer.Write([]byte(name))
+		case <-request.Context().Done():
+		}
+	}))
+}
+
+func Test_Hedging(t *testing.T) {
+	t.Run("slow requests are hedged to other upstreams", func(t *testing.T) {
+		req := require.New(t)
+		var slowCalls, fastCalls int32
+		slow := newNamedUpstream("slow", 5*time.Second, &slowCalls)
+		defer slow.Close()
+		fast := newNamedUpstream("fast", 0, &fastCalls)
+		defer fast.Close()
+
+		handler := newTestHandler(req, slow.URL, factory.Options{"hedgeDelay": "50ms", "hedgeUpstreams": []interface{}{fast.URL}})
+
+		start := time.Now()
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/things", nil))
+
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal("fast", recorder.Body.String())
+		req.Less(time.Since(start), 2*time.Second)
+		req.Equal(int32(1), atomic.LoadInt32(&slowCalls))
+		req.Equal(int32(1), atomic.LoadInt32(&fastCalls))
+	})
+
+	t.Run("fast requests are not hedged", func(t *testing.T) {
+		req := require.New(t)
+		var primaryCalls, hedgeCalls int32
+		primary := newNamedUpstream("primary", 0, &primaryCalls)
+		defer primary.Close()
+		hedge := newNamedUpstream("hedge", 0, &hedgeCalls)
+		defer hedge.Close()
+
+		handler := newTestHandler(req, primary.URL, factory.Options{"hedgeDelay": "1s", "hedgeUpstreams": []interface{}{hedge.URL}})
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/things", nil))
+
+		req.Equal("primary", recorder.Body.String())
+		req.Equal(int32(0), atomic.LoadInt32(&hedgeCalls))
+	})
+
+	t.Run("requests with bodies or non-idempotent methods are not hedged", func(t *testing.T) {
+		req := require.New(t)
+		var primaryCalls, hedgeCalls int32
+		primary := newNamedUpstream("primary", 200*time.Millisecond, &primaryCalls)
+		defer primary.Close()
+		hedge := newNamedUpstream("hedge", 0, &hedgeCalls)
+		defer hedge.Close()
+
+		handler := newTestHandler(req, primary.URL, factory.Options{"hedgeDelay": "20ms", "hedgeUpstreams": []interface{}{hedge.URL}})
+
+		for _, request := range []*gmhttp.Request{
+			gmhttptest.NewRequest("POST", "/things", nil),
+			gmhttptest.NewRequest("PUT", "/things", strings.NewReader("body")),
+		} {
+			recorder := gmhttptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			req.Equal("primary", recorder.Body.String())
+		}
+
+		req.Equal(int32(0), atomic.LoadInt32(&hedgeCalls))
+	})
+
+	t.Run("hedged attempts are limited by the hedge budget", func(t *testing.T) {
+		req := require.New(t)
+		budget := &hedgeBudget{tokens: hedgeBudgetBurst, ratio: 0.1}
+
+		for i := 0; i < hedgeBudgetBurst; i++ {
+			req.True(budget.withdraw())
+		}
+		req.False(budget.withdraw())
+
+		for i := 0; i < 9; i++ {
+			budget.deposit()
+		}
+		req.False(budget.withdraw())
+
+		budget.deposit()
+		budget.deposit()
+		req.True(budget.withdraw())
+		req.False(budget.withdraw())
+	})
+
+	t.Run("hedge options are validated", func(t *testing.T) {
+		req := require.New(t)
+
+		for _, invalid := range []factory.Options{
+			{"upstream": "http://host", "hedgeDelay": "-1s"},
+			{"upstream": "http://host", "hedgeMaxAttempts": 0},
+			{"upstream": "http://host", "hedgeBudgetPercent": 150},
+			{"upstream": "http://host", "hedgeUpstreams": []interface{}{"ftp://other"}},
+		} {
+			_, err := NewFactory().New(nil, &testBinding{options: invalid})
+			req.Error(err, "%v", invalid)
+		}
+	})
+}
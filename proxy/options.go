@@ -0,0 +1,253 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/x509"
+	"github.com/openziti/xweb/v2/factory"
+	"github.com/pkg/errors"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTimeoutHeader = "X-Request-Timeout"
+	DefaultMinBudget     = 10 * time.Millisecond
+)
+
+// Options configures a reverse proxy WebHandler
+type Options struct {
+	// RootPath is the path prefix proxied
+	RootPath string
+
+	// Upstream is the base URL requests are proxied to
+	Upstream *url.URL
+
+	// StripPrefix removes RootPath from request paths before they are joined to the Upstream path
+	StripPrefix bool
+
+	// UpstreamCa is a PEM file of CAs used to verify https upstreams, the system roots are used if not set
+	UpstreamCa  string
+	upstreamCas *x509.CertPool
+
+	BudgetOptions
+	WebSocketOptions
+	HedgeOptions
+	BreakerOptions
+}
+
+// BudgetOptions controls the request deadline budget propagated to upstreams. Each request's budget is the time until
+// the earlier of the inbound request's deadline, if any, and Timeout after the request was received. Upstream calls
+// are cancelled when the budget is exhausted or the inbound request is done.
+type BudgetOptions struct {
+	// Timeout bounds the time spent proxying a request, zero leaves it bounded only by the inbound request's deadline
+	Timeout time.Duration
+
+	// TimeoutHeader carries the remaining budget in whole milliseconds to upstreams, empty to not send it
+	TimeoutHeader string
+
+	// DeadlineHeader carries the budget's deadline as unix milliseconds to upstreams, empty to not send it
+	DeadlineHeader string
+
+	// MinBudget is the least remaining budget worth sending upstream, requests with less are answered with
+	// http.StatusGatewayTimeout without calling the upstream
+	MinBudget time.Duration
+}
+
+// Default provides defaults for all necessary values
+func (options *Options) Default() {
+	options.RootPath = "/"
+	options.Upstream = nil
+	options.StripPrefix = false
+	options.UpstreamCa = ""
+	options.Timeout = 0
+	options.TimeoutHeader = DefaultTimeoutHeader
+	options.DeadlineHeader = ""
+	options.MinBudget = DefaultMinBudget
+	options.WebSocketIdleTimeout = 0
+	options.WebSocketMaxLifetime = 0
+	options.HedgeDelay = 0
+	options.HedgeUpstreams = nil
+	options.HedgeMaxAttempts = DefaultHedgeMaxAttempts
+	options.HedgeBudgetPercent = DefaultHedgeBudgetPercent
+}
+
+// Parse parses options
+func (options *Options) Parse(config factory.Options) error {
+	if rootPath, err := config.GetString("rootPath"); err == nil {
+		options.RootPath = rootPath
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	if upstream, err := config.GetString("upstream"); err == nil {
+		upstreamUrl, err := url.Parse(upstream)
+		if err != nil {
+			return fmt.Errorf("could not parse upstream [%s] as a URL: %v", upstream, err)
+		}
+		options.Upstream = upstreamUrl
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	if stripPrefix, err := config.GetBool("stripPrefix"); err == nil {
+		options.StripPrefix = stripPrefix
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	if upstreamCa, err := config.GetString("upstreamCa"); err == nil {
+		options.UpstreamCa = upstreamCa
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	durations := map[string]*time.Duration{
+		"timeout":              &options.Timeout,
+		"minBudget":            &options.MinBudget,
+		"webSocketIdleTimeout": &options.WebSocketIdleTimeout,
+		"webSocketMaxLifetime": &options.WebSocketMaxLifetime,
+		"hedgeDelay":           &options.HedgeDelay,
+	}
+
+	for field, target := range durations {
+		if duration, err := config.GetDuration(field); err == nil {
+			*target = duration
+		} else if !isNotFound(err) {
+			return err
+		}
+	}
+
+	for field, target := range map[string]*string{"timeoutHeader": &options.TimeoutHeader, "deadlineHeader": &options.DeadlineHeader, "circuitBreaker": &options.CircuitBreaker} {
+		if header, err := config.GetString(field); err == nil {
+			*target = header
+		} else if !isNotFound(err) {
+			return err
+		}
+	}
+
+	if hedgeUpstreams, err := config.GetStringSlice("hedgeUpstreams"); err == nil {
+		for _, hedgeUpstream := range hedgeUpstreams {
+			hedgeUpstreamUrl, err := url.Parse(hedgeUpstream)
+			if err != nil {
+				return fmt.Errorf("could not parse hedgeUpstreams value [%s] as a URL: %v", hedgeUpstream, err)
+			}
+			options.HedgeUpstreams = append(options.HedgeUpstreams, hedgeUpstreamUrl)
+		}
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	if hedgeMaxAttempts, err := config.GetInt("hedgeMaxAttempts"); err == nil {
+		options.HedgeMaxAttempts = hedgeMaxAttempts
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	if hedgeBudgetPercent, err := config.GetFloat("hedgeBudgetPercent"); err == nil {
+		options.HedgeBudgetPercent = hedgeBudgetPercent
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error. The UpstreamCa is loaded.
+func (options *Options) Validate() error {
+	if !strings.HasPrefix(options.RootPath, "/") {
+		return fmt.Errorf("rootPath [%s] must begin with /", options.RootPath)
+	}
+
+	if options.Upstream == nil {
+		return errors.New("upstream is required")
+	}
+
+	if err := validateUpstream(options.Upstream); err != nil {
+		return err
+	}
+
+	if options.Timeout < 0 {
+		return fmt.Errorf("value [%s] for timeout too low, must be zero (unbounded) or positive", options.Timeout)
+	}
+
+	if options.MinBudget < 0 {
+		return fmt.Errorf("value [%s] for minBudget too low, must be zero or positive", options.MinBudget)
+	}
+
+	if options.WebSocketIdleTimeout < 0 {
+		return fmt.Errorf("value [%s] for webSocketIdleTimeout too low, must be zero (unbounded) or positive", options.WebSocketIdleTimeout)
+	}
+
+	if options.WebSocketMaxLifetime < 0 {
+		return fmt.Errorf("value [%s] for webSocketMaxLifetime too low, must be zero (unbounded) or positive", options.WebSocketMaxLifetime)
+	}
+
+	if options.HedgeDelay < 0 {
+		return fmt.Errorf("value [%s] for hedgeDelay too low, must be zero (disabled) or positive", options.HedgeDelay)
+	}
+
+	if options.HedgeMaxAttempts < 1 {
+		return fmt.Errorf("value [%d] for hedgeMaxAttempts too low, must be at least 1", options.HedgeMaxAttempts)
+	}
+
+	if options.HedgeBudgetPercent < 0 || options.HedgeBudgetPercent > 100 {
+		return fmt.Errorf("value [%v] for hedgeBudgetPercent must be between 0 and 100", options.HedgeBudgetPercent)
+	}
+
+	for _, hedgeUpstream := range options.HedgeUpstreams {
+		if err := validateUpstream(hedgeUpstream); err != nil {
+			return fmt.Errorf("invalid hedgeUpstreams value: %v", err)
+		}
+	}
+
+	if options.UpstreamCa != "" {
+		pemBytes, err := os.ReadFile(options.UpstreamCa)
+		if err != nil {
+			return fmt.Errorf("could not read upstreamCa [%s]: %v", options.UpstreamCa, err)
+		}
+
+		options.upstreamCas = x509.NewCertPool()
+		if !options.upstreamCas.AppendCertsFromPEM(pemBytes) {
+			return fmt.Errorf("no certificates found in upstreamCa [%s]", options.UpstreamCa)
+		}
+	}
+
+	return nil
+}
+
+// validateUpstream returns an error if upstream is not an absolute http, https, ws or wss URL
+func validateUpstream(upstream *url.URL) error {
+	switch upstream.Scheme {
+	case "http", "https", "ws", "wss":
+	default:
+		return fmt.Errorf("upstream [%s] must be an http, https, ws or wss URL", upstream)
+	}
+
+	if upstream.Host == "" {
+		return fmt.Errorf("upstream [%s] must have a host", upstream)
+	}
+
+	return nil
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, factory.ErrOptionNotFound)
+}
@@ -0,0 +1,220 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package proxy provides an xweb API factory that reverse proxies requests for a root path to an upstream server.
+// Register it with xweb.RegisterFactory and configure APIs with the "proxy" binding:
+//
+//	apis:
+//	  - binding: proxy
+//	    options:
+//	      rootPath: /backend/
+//	      upstream: https://backend.internal:8443
+//	      stripPrefix: true
+//	      timeout: 30s
+//	      webSocketIdleTimeout: 5m
+//	      hedgeDelay: 50ms
+//	      hedgeUpstreams: [https://backend-2.internal:8443]
+//	      circuitBreaker: backend
+//
+// WebSocket upgrades are passed through to upstreams, which may be given as http, https, ws or wss URLs. TLS upstreams
+// are dialed with gmtls, so GM TLS upstreams are supported.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp/httputil"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"github.com/openziti/xweb/v2"
+	"github.com/openziti/xweb/v2/breaker"
+	"github.com/openziti/xweb/v2/factory"
+	"strings"
+	"time"
+)
+
+// Binding is the binding name of the proxy Factory
+const Binding = "proxy"
+
+var (
+	ErrBudgetExhausted = errors.New("request deadline budget exhausted")
+)
+
+// Factory creates reverse proxy WebHandler's
+type Factory struct{}
+
+var _ factory.Factory = &Factory{}
+
+// NewFactory returns a proxy Factory
+func NewFactory() *Factory {
+	return &Factory{}
+}
+
+func (f *Factory) Binding() string {
+	return Binding
+}
+
+func (f *Factory) New(server factory.Server, binding factory.APIBinding) (factory.WebHandler, error) {
+	options := &Options{}
+	options.Default()
+
+	if err := options.Parse(binding.Options()); err != nil {
+		return nil, fmt.Errorf("error parsing options for proxy api [%s]: %v", binding.Name(), err)
+	}
+
+	if err := options.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options for proxy api [%s]: %v", binding.Name(), err)
+	}
+
+	options.resolveBreaker(server)
+
+	return NewHandler(options), nil
+}
+
+// Handler is a factory.WebHandler that proxies requests to an upstream
+type Handler struct {
+	options *Options
+	proxy   *httputil.ReverseProxy
+}
+
+var _ factory.WebHandler = &Handler{}
+
+// NewHandler returns a Handler for validated Options. A circuit breaker not resolved by the Factory is created with
+// default options.
+func NewHandler(options *Options) *Handler {
+	if options.CircuitBreaker != "" && options.breaker == nil {
+		options.resolveBreaker(nil)
+	}
+
+	transport := gmhttp.DefaultTransport.(*gmhttp.Transport).Clone()
+	if options.upstreamCas != nil {
+		transport.TLSClientConfig = &gmtls.Config{
+			RootCAs: options.upstreamCas,
+		}
+	}
+
+	handler := &Handler{
+		options: options,
+	}
+
+	handler.proxy = &httputil.ReverseProxy{
+		Director:       handler.direct,
+		Transport:      newBreakerTransport(newHedgingTransport(transport, options), &options.BreakerOptions),
+		ErrorHandler:   handler.handleError,
+		ModifyResponse: options.trackIdle,
+	}
+
+	return handler
+}
+
+func (handler *Handler) RootPath() string {
+	return handler.options.RootPath
+}
+
+func (handler *Handler) IsHandler(request *gmhttp.Request) bool {
+	return strings.HasPrefix(request.URL.Path, handler.options.RootPath)
+}
+
+func (handler *Handler) ServeHTTP(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if isWebSocket(request) {
+		ctx, cancel := handler.options.withLifetime(request.Context())
+		defer cancel()
+
+		handler.proxy.ServeHTTP(writer, request.WithContext(ctx))
+		return
+	}
+
+	ctx, cancel, remaining := handler.options.withBudget(request.Context(), time.Now())
+	defer cancel()
+
+	if remaining >= 0 && remaining < handler.options.MinBudget {
+		xweb.WriteError(writer, request, gmhttp.StatusGatewayTimeout, ErrBudgetExhausted, nil)
+		return
+	}
+
+	handler.proxy.ServeHTTP(writer, request.WithContext(ctx))
+}
+
+// direct rewrites requests to the upstream. The escaped form of the path is rewritten alongside it so that encoded
+// characters such as %2F reach the upstream unchanged.
+func (handler *Handler) direct(request *gmhttp.Request) {
+	upstream := handler.options.Upstream
+
+	path := request.URL.Path
+	rawPath := request.URL.RawPath
+	if handler.options.StripPrefix {
+		path = handler.stripPrefix(path)
+		if rawPath != "" {
+			rawPath = handler.stripPrefix(rawPath)
+		}
+	}
+
+	request.URL.Scheme = httpScheme(upstream.Scheme)
+	request.URL.Host = upstream.Host
+	request.URL.Path = strings.TrimSuffix(upstream.Path, "/") + path
+	request.URL.RawPath = ""
+	if rawPath != "" {
+		// URL.EscapedPath ignores a RawPath that is not a valid encoding of Path
+		request.URL.RawPath = strings.TrimSuffix(upstream.EscapedPath(), "/") + rawPath
+	}
+
+	if upstream.RawQuery != "" {
+		if request.URL.RawQuery == "" {
+			request.URL.RawQuery = upstream.RawQuery
+		} else {
+			request.URL.RawQuery = upstream.RawQuery + "&" + request.URL.RawQuery
+		}
+	}
+
+	if _, ok := request.Header["User-Agent"]; !ok {
+		// explicitly disable User-Agent so it's not set to the default value
+		request.Header.Set("User-Agent", "")
+	}
+
+	handler.options.setBudgetHeaders(request)
+}
+
+// stripPrefix removes the root path from the start of a request path
+func (handler *Handler) stripPrefix(path string) string {
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(path, strings.TrimSuffix(handler.options.RootPath, "/")), "/")
+}
+
+// httpScheme returns the http scheme WebSocket upstream schemes are requested with
+func httpScheme(scheme string) string {
+	switch scheme {
+	case "ws":
+		return "http"
+	case "wss":
+		return "https"
+	default:
+		return scheme
+	}
+}
+
+// handleError answers requests that could not be proxied. Requests whose inbound side is done are not answered.
+func (handler *Handler) handleError(writer gmhttp.ResponseWriter, request *gmhttp.Request, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		xweb.WriteError(writer, request, gmhttp.StatusGatewayTimeout, fmt.Errorf("upstream did not respond within the request budget: %v", err), nil)
+	case errors.Is(err, context.Canceled):
+		return
+	case errors.Is(err, breaker.ErrOpen):
+		xweb.WriteError(writer, request, gmhttp.StatusServiceUnavailable, fmt.Errorf("upstream [%s] is unavailable: %v", handler.options.CircuitBreaker, err), nil)
+	default:
+		xweb.WriteError(writer, request, gmhttp.StatusBadGateway, fmt.Errorf("error proxying to upstream: %v", err), nil)
+	}
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"context"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/openziti/xweb/v2/factory"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testBinding struct {
+	options factory.Options
+}
+
+func (binding *testBinding) Binding() string {
+	return Binding
+}
+
+func (binding *testBinding) Name() string {
+	return Binding
+}
+
+func (binding *testBinding) Options() factory.Options {
+	return binding.options
+}
+
+func newTestHandler(req *require.Assertions, upstream string, options factory.Options) factory.WebHandler {
+	options["upstream"] = upstream
+	handler, err := NewFactory().New(nil, &testBinding{options: options})
+	req.NoError(err)
+	return handler
+}
+
+func Test_Handler(t *testing.T) {
+	t.Run("requests are proxied with the prefix stripped", func(t *testing.T) {
+		req := require.New(t)
+		upstream := gmhttptest.NewServer(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			_, _ = writer.Write([]byte(request.URL.RequestURI()))
+		}))
+		defer upstream.Close()
+
+		handler := newTestHandler(req, upstream.URL+"/base", factory.Options{"rootPath": "/backend/", "stripPrefix": true})
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/backend/things?a=b", nil))
+
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal("/base/things?a=b", recorder.Body.String())
+
+		recorder = gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/backend/a%2Fb/c", nil))
+		req.Equal("/base/a%2Fb/c", recorder.Body.String())
+		req.True(handler.IsHandler(gmhttptest.NewRequest("GET", "/backend/things", nil)))
+		req.False(handler.IsHandler(gmhttptest.NewRequest("GET", "/other", nil)))
+	})
+
+	t.Run("the remaining budget is propagated and replaces client values", func(t *testing.T) {
+		req := require.New(t)
+		headers := make(chan gmhttp.Header, 1)
+		upstream := gmhttptest.NewServer(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			headers <- request.Header.Clone()
+		}))
+		defer upstream.Close()
+
+		handler := newTestHandler(req, upstream.URL, factory.Options{"timeout": "2s", "deadlineHeader": "X-Request-Deadline"})
+
+		request := gmhttptest.NewRequest("GET", "/things", nil)
+		request.Header.Set(DefaultTimeoutHeader, "999999")
+		handler.ServeHTTP(gmhttptest.NewRecorder(), request)
+
+		header := <-headers
+		remaining, err := strconv.ParseInt(header.Get(DefaultTimeoutHeader), 10, 64)
+		req.NoError(err)
+		req.LessOrEqual(remaining, int64(2000))
+		req.Greater(remaining, int64(1000))
+
+		deadline, err := strconv.ParseInt(header.Get("X-Request-Deadline"), 10, 64)
+		req.NoError(err)
+		req.InDelta(time.Now().Add(2*time.Second).UnixMilli(), deadline, 1000)
+	})
+
+	t.Run("no budget is sent without a deadline", func(t *testing.T) {
+		req := require.New(t)
+		headers := make(chan gmhttp.Header, 1)
+		upstream := gmhttptest.NewServer(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			headers <- request.Header.Clone()
+		}))
+		defer upstream.Close()
+
+		handler := newTestHandler(req, upstream.URL, factory.Options{})
+
+		request := gmhttptest.NewRequest("GET", "/things", nil)
+		request.Header.Set(DefaultTimeoutHeader, "999999")
+		handler.ServeHTTP(gmhttptest.NewRecorder(), request)
+
+		req.Empty((<-headers).Get(DefaultTimeoutHeader))
+	})
+
+	t.Run("upstream calls are cancelled when the budget is exhausted", func(t *testing.T) {
+		req := require.New(t)
+		cancelled := make(chan struct{})
+		upstream := gmhttptest.NewServer(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			<-request.Context().Done()
+			close(cancelled)
+		}))
+		defer upstream.Close()
+
+		handler := newTestHandler(req, upstream.URL, factory.Options{"timeout": "100ms"})
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/things", nil))
+		req.Equal(gmhttp.StatusGatewayTimeout, recorder.Code)
+
+		select {
+		case <-cancelled:
+		case <-time.After(5 * time.Second):
+			req.Fail("upstream request was not cancelled")
+		}
+	})
+
+	t.Run("upstream calls are cancelled when the inbound request is done", func(t *testing.T) {
+		req := require.New(t)
+		started := make(chan struct{})
+		cancelled := make(chan struct{})
+		upstream := gmhttptest.NewServer(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			close(started)
+			<-request.Context().Done()
+			close(cancelled)
+		}))
+		defer upstream.Close()
+
+		handler := newTestHandler(req, upstream.URL, factory.Options{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-started
+			cancel()
+		}()
+
+		handler.ServeHTTP(gmhttptest.NewRecorder(), gmhttptest.NewRequest("GET", "/things", nil).WithContext(ctx))
+
+		select {
+		case <-cancelled:
+		case <-time.After(5 * time.Second):
+			req.Fail("upstream request was not cancelled")
+		}
+	})
+
+	t.Run("requests without enough budget are not proxied", func(t *testing.T) {
+		req := require.New(t)
+		upstream := gmhttptest.NewServer(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			req.Fail("upstream should not be called")
+		}))
+		defer upstream.Close()
+
+		handler := newTestHandler(req, upstream.URL, factory.Options{"minBudget": "1s"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/things", nil).WithContext(ctx))
+		req.Equal(gmhttp.StatusGatewayTimeout, recorder.Code)
+	})
+
+	t.Run("invalid options are rejected", func(t *testing.T) {
+		req := require.New(t)
+
+		_, err := NewFactory().New(nil, &testBinding{options: factory.Options{}})
+		req.Error(err)
+
+		_, err = NewFactory().New(nil, &testBinding{options: factory.Options{"upstream": "ftp://host"}})
+		req.Error(err)
+
+		_, err = NewFactory().New(nil, &testBinding{options: factory.Options{"upstream": "http://host", "timeout": 5}})
+		req.Error(err)
+	})
+}
@@ -0,0 +1,120 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocketOptions controls WebSocket upgrades passed through to upstreams. Upgraded connections are not bounded by
+// the request budget of BudgetOptions, only by these limits and the inbound request being done.
+type WebSocketOptions struct {
+	// WebSocketIdleTimeout closes upgraded connections that have not carried data in either direction for the
+	// duration, zero to never close idle connections
+	WebSocketIdleTimeout time.Duration
+
+	// WebSocketMaxLifetime closes upgraded connections the duration after the upgrade was requested, zero for no limit
+	WebSocketMaxLifetime time.Duration
+}
+
+// isWebSocket returns true if the request asks to be upgraded to a WebSocket
+func isWebSocket(request *gmhttp.Request) bool {
+	if !strings.EqualFold(request.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+
+	for _, value := range request.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// withLifetime returns a context that is done when an upgraded connection has reached WebSocketMaxLifetime
+func (options *WebSocketOptions) withLifetime(ctx context.Context) (context.Context, context.CancelFunc) {
+	if options.WebSocketMaxLifetime > 0 {
+		return context.WithTimeout(ctx, options.WebSocketMaxLifetime)
+	}
+	return context.WithCancel(ctx)
+}
+
+// trackIdle wraps the upstream side of switched protocol responses so that they are closed after
+// WebSocketIdleTimeout without traffic. Both directions of an upgraded connection pass through the upstream side.
+func (options *WebSocketOptions) trackIdle(response *gmhttp.Response) error {
+	if response.StatusCode != gmhttp.StatusSwitchingProtocols || options.WebSocketIdleTimeout <= 0 {
+		return nil
+	}
+
+	if conn, ok := response.Body.(io.ReadWriteCloser); ok {
+		response.Body = newIdleConn(conn, options.WebSocketIdleTimeout)
+	}
+
+	return nil
+}
+
+// idleConn closes the wrapped connection if no data is read or written within a timeout
+type idleConn struct {
+	io.ReadWriteCloser
+	timeout   time.Duration
+	timer     *time.Timer
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func newIdleConn(conn io.ReadWriteCloser, timeout time.Duration) *idleConn {
+	idle := &idleConn{
+		ReadWriteCloser: conn,
+		timeout:         timeout,
+	}
+	idle.timer = time.AfterFunc(timeout, func() {
+		_ = idle.Close()
+	})
+	return idle
+}
+
+func (idle *idleConn) Read(p []byte) (int, error) {
+	n, err := idle.ReadWriteCloser.Read(p)
+	if n > 0 {
+		idle.timer.Reset(idle.timeout)
+	}
+	return n, err
+}
+
+func (idle *idleConn) Write(p []byte) (int, error) {
+	n, err := idle.ReadWriteCloser.Write(p)
+	if n > 0 {
+		idle.timer.Reset(idle.timeout)
+	}
+	return n, err
+}
+
+func (idle *idleConn) Close() error {
+	idle.closeOnce.Do(func() {
+		idle.timer.Stop()
+		idle.closeErr = idle.ReadWriteCloser.Close()
+	})
+	return idle.closeErr
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"bufio"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/openziti/xweb/v2/factory"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newEchoUpstream returns a server that accepts WebSocket upgrades and echoes all bytes sent after the upgrade
+func newEchoUpstream(req *require.Assertions) *gmhttptest.Server {
+	return gmhttptest.NewServer(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		req.True(isWebSocket(request))
+
+		conn, brw, err := writer.(gmhttp.Hijacker).Hijack()
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		_ = brw.Flush()
+		_, _ = io.Copy(conn, brw)
+	}))
+}
+
+// dialWebSocket requests a WebSocket upgrade through a server and returns the upgraded connection
+func dialWebSocket(req *require.Assertions, front *gmhttptest.Server) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	req.NoError(err)
+
+	_, err = conn.Write([]byte("GET /socket HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"))
+	req.NoError(err)
+
+	reader := bufio.NewReader(conn)
+	response, err := gmhttp.ReadResponse(reader, nil)
+	req.NoError(err)
+	req.Equal(gmhttp.StatusSwitchingProtocols, response.StatusCode)
+
+	return conn, reader
+}
+
+func Test_WebSocket(t *testing.T) {
+	t.Run("upgrades are passed through to ws upstreams", func(t *testing.T) {
+		req := require.New(t)
+		upstream := newEchoUpstream(req)
+		defer upstream.Close()
+
+		handler := newTestHandler(req, strings.Replace(upstream.URL, "http://", "ws://", 1), factory.Options{"timeout": "100ms"})
+		front := gmhttptest.NewServer(handler)
+		defer front.Close()
+
+		conn, reader := dialWebSocket(req, front)
+		defer func() { _ = conn.Close() }()
+
+		// outlive the request budget, which does not apply to upgraded connections
+		time.Sleep(200 * time.Millisecond)
+
+		_, err := conn.Write([]byte("ping\n"))
+		req.NoError(err)
+
+		line, err := reader.ReadString('\n')
+		req.NoError(err)
+		req.Equal("ping\n", line)
+	})
+
+	t.Run("idle connections are closed", func(t *testing.T) {
+		req := require.New(t)
+		upstream := newEchoUpstream(req)
+		defer upstream.Close()
+
+		handler := newTestHandler(req, upstream.URL, factory.Options{"webSocketIdleTimeout": "100ms"})
+		front := gmhttptest.NewServer(handler)
+		defer front.Close()
+
+		conn, reader := dialWebSocket(req, front)
+		defer func() { _ = conn.Close() }()
+
+		for i := 0; i < 3; i++ {
+			time.Sleep(50 * time.Millisecond)
+			_, err := conn.Write([]byte("ping\n"))
+			req.NoError(err)
+			_, err = reader.ReadString('\n')
+			req.NoError(err)
+		}
+
+		req.NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+		_, err := reader.ReadByte()
+		req.ErrorIs(err, io.EOF)
+	})
+
+	t.Run("connections are closed after their max lifetime", func(t *testing.T) {
+		req := require.New(t)
+		upstream := newEchoUpstream(req)
+		defer upstream.Close()
+
+		handler := newTestHandler(req, upstream.URL, factory.Options{"webSocketMaxLifetime": "200ms"})
+		front := gmhttptest.NewServer(handler)
+		defer front.Close()
+
+		conn, reader := dialWebSocket(req, front)
+		defer func() { _ = conn.Close() }()
+
+		req.NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+		_, err := reader.ReadByte()
+		req.ErrorIs(err, io.EOF)
+	})
+
+	t.Run("websocket options are validated", func(t *testing.T) {
+		req := require.New(t)
+
+		_, err := NewFactory().New(nil, &testBinding{options: factory.Options{"upstream": "wss://host", "webSocketIdleTimeout": "-1s"}})
+		req.Error(err)
+
+		_, err = NewFactory().New(nil, &testBinding{options: factory.Options{"upstream": "wss://host", "webSocketMaxLifetime": "1m"}})
+		req.NoError(err)
+	})
+}
@@ -0,0 +1,106 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package ratelimit provides the token bucket storage used by xweb's rate limits. A Backend may be local to an
+// instance, as MemoryBackend is, or shared by all instances behind a load balancer, as RedisBackend is, so that limits
+// are enforced consistently regardless of which instance a client reaches.
+package ratelimit
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+const (
+	BackendTypeMemory = "memory"
+	BackendTypeRedis  = "redis"
+)
+
+// Backend stores token buckets by key
+type Backend interface {
+	// Take removes a token from the bucket for key, which is refilled at rate tokens per second up to burst tokens,
+	// returning false if no token was available. Buckets that have not been used start full.
+	Take(key string, rate float64, burst int) (bool, error)
+}
+
+// BackendOptions selects and configures a Backend
+type BackendOptions struct {
+	Type  string
+	Redis RedisOptions
+
+	// Backend, if set, is used instead of creating a Backend of Type. It allows applications to provide their own.
+	Backend Backend
+}
+
+// Default provides defaults for all necessary values
+func (options *BackendOptions) Default() {
+	options.Type = BackendTypeMemory
+	options.Redis.Default()
+	options.Backend = nil
+}
+
+// Parse parses a configuration map
+func (options *BackendOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["type"]; ok {
+		if backendType, ok := interfaceVal.(string); ok {
+			options.Type = strings.ToLower(backendType)
+		} else {
+			return errors.New("could not use value for type, not a string")
+		}
+	}
+
+	if options.Type == BackendTypeRedis {
+		return options.Redis.Parse(config)
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (options *BackendOptions) Validate() error {
+	if options.Backend != nil {
+		return nil
+	}
+
+	switch options.Type {
+	case BackendTypeMemory:
+		return nil
+	case BackendTypeRedis:
+		return options.Redis.Validate()
+	}
+
+	return fmt.Errorf("invalid type [%s], must be one of: %s, %s", options.Type, BackendTypeMemory, BackendTypeRedis)
+}
+
+// NewBackend returns the configured Backend. Backends are not connected until they are first used.
+func (options *BackendOptions) NewBackend() Backend {
+	if options.Backend != nil {
+		return options.Backend
+	}
+
+	if options.Type == BackendTypeRedis {
+		return NewRedisBackend(&options.Redis)
+	}
+
+	return NewMemoryBackend()
+}
+
+// refill returns how long an emptied bucket takes to fill
+func refill(rate float64, burst int) time.Duration {
+	return time.Duration(float64(burst) / rate * float64(time.Second))
+}
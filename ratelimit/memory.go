@@ -0,0 +1,103 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// memorySweepInterval is how often full buckets are discarded
+const memorySweepInterval = time.Minute
+
+// MemoryBackend is a Backend that keeps buckets in memory, limiting each instance separately
+type MemoryBackend struct {
+	// Now returns the current time, it defaults to time.Now
+	Now func() time.Time
+
+	lock      sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+var _ Backend = &MemoryBackend{}
+
+// memoryBucket is a token bucket that is refilled lazily when tokens are taken
+type memoryBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time
+}
+
+// NewMemoryBackend returns an empty MemoryBackend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		Now:     time.Now,
+		buckets: map[string]*memoryBucket{},
+	}
+}
+
+// Take satisfies Backend
+func (backend *MemoryBackend) Take(key string, rate float64, burst int) (bool, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	now := backend.Now()
+	backend.sweep(now)
+
+	bucket, ok := backend.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(burst)}
+		backend.buckets[key] = bucket
+	} else if elapsed := now.Sub(bucket.last); elapsed > 0 {
+		bucket.tokens += elapsed.Seconds() * rate
+		if bucket.tokens > float64(burst) {
+			bucket.tokens = float64(burst)
+		}
+	}
+	bucket.last = now
+	bucket.full = now.Add(refill(rate, burst))
+
+	if bucket.tokens < 1 {
+		return false, nil
+	}
+
+	bucket.tokens--
+	return true, nil
+}
+
+// Len returns the number of buckets held
+func (backend *MemoryBackend) Len() int {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	return len(backend.buckets)
+}
+
+// sweep discards buckets that have refilled, as they are equivalent to buckets that have not been used. Must be
+// called with the lock held.
+func (backend *MemoryBackend) sweep(now time.Time) {
+	if now.Sub(backend.lastSweep) < memorySweepInterval {
+		return
+	}
+	backend.lastSweep = now
+
+	for key, bucket := range backend.buckets {
+		if !now.Before(bucket.full) {
+			delete(backend.buckets, key)
+		}
+	}
+}
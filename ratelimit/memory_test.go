@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	newBackend := func() (*MemoryBackend, *time.Time) {
+		backend := NewMemoryBackend()
+		now := time.Now()
+		backend.Now = func() time.Time {
+			return now
+		}
+		return backend, &now
+	}
+
+	take := func(req *require.Assertions, backend Backend, key string) bool {
+		allowed, err := backend.Take(key, 1, 2)
+		req.NoError(err)
+		return allowed
+	}
+
+	t.Run("buckets start full and refill at the rate", func(t *testing.T) {
+		req := require.New(t)
+		backend, now := newBackend()
+
+		req.True(take(req, backend, "a"))
+		req.True(take(req, backend, "a"))
+		req.False(take(req, backend, "a"))
+		req.True(take(req, backend, "b"))
+
+		*now = now.Add(time.Second)
+		req.True(take(req, backend, "a"))
+		req.False(take(req, backend, "a"))
+
+		*now = now.Add(time.Hour)
+		req.True(take(req, backend, "a"))
+		req.True(take(req, backend, "a"))
+		req.False(take(req, backend, "a"))
+	})
+
+	t.Run("refilled buckets are discarded", func(t *testing.T) {
+		req := require.New(t)
+		backend, now := newBackend()
+
+		take(req, backend, "a")
+		take(req, backend, "b")
+		req.Equal(2, backend.Len())
+
+		*now = now.Add(memorySweepInterval)
+		take(req, backend, "c")
+		req.Equal(1, backend.Len())
+	})
+}
+
+func TestBackendOptions(t *testing.T) {
+	t.Run("the memory backend is the default", func(t *testing.T) {
+		req := require.New(t)
+		options := &BackendOptions{}
+		options.Default()
+		req.NoError(options.Validate())
+		req.IsType(&MemoryBackend{}, options.NewBackend())
+	})
+
+	t.Run("redis backends are parsed", func(t *testing.T) {
+		req := require.New(t)
+		options := &BackendOptions{}
+		options.Default()
+		req.NoError(options.Parse(map[interface{}]interface{}{
+			"type":      "redis",
+			"address":   "redis:6379",
+			"password":  "secret",
+			"db":        2,
+			"keyPrefix": "edge:",
+			"timeout":   "1s",
+			"poolSize":  8,
+			"tls":       true,
+		}))
+		req.NoError(options.Validate())
+
+		req.Equal(RedisOptions{Address: "redis:6379", Password: "secret", Db: 2, KeyPrefix: "edge:", Timeout: time.Second, PoolSize: 8, Tls: true}, options.Redis)
+		req.IsType(&RedisBackend{}, options.NewBackend())
+	})
+
+	t.Run("provided backends are used", func(t *testing.T) {
+		req := require.New(t)
+		backend := NewMemoryBackend()
+		options := &BackendOptions{Type: "custom", Backend: backend}
+		req.NoError(options.Validate())
+		req.Equal(backend, options.NewBackend())
+	})
+
+	t.Run("invalid options are rejected", func(t *testing.T) {
+		req := require.New(t)
+		options := &BackendOptions{}
+		options.Default()
+		req.EqualError(options.Parse(map[interface{}]interface{}{"type": 1}), "could not use value for type, not a string")
+
+		req.NoError(options.Parse(map[interface{}]interface{}{"type": "etcd"}))
+		req.EqualError(options.Validate(), "invalid type [etcd], must be one of: memory, redis")
+
+		options.Default()
+		req.NoError(options.Parse(map[interface{}]interface{}{"type": "redis"}))
+		req.EqualError(options.Validate(), "invalid address []: missing port in address")
+
+		req.EqualError(options.Parse(map[interface{}]interface{}{"type": "redis", "timeout": "soon"}), `could not parse timeout soon as a duration (e.g. 1m): time: invalid duration "soon"`)
+
+		options.Default()
+		req.NoError(options.Parse(map[interface{}]interface{}{"type": "redis", "address": "redis:6379", "password": "secret"}))
+		req.EqualError(options.Validate(), "tls is required to authenticate with redis at [redis:6379], credentials must not be sent in plaintext")
+
+		options.Default()
+		req.NoError(options.Parse(map[interface{}]interface{}{"type": "redis", "address": "127.0.0.1:6379", "password": "secret"}))
+		req.NoError(options.Validate())
+
+		options.Default()
+		req.NoError(options.Parse(map[interface{}]interface{}{"type": "redis", "address": "redis:6379", "tls": true, "tlsCaFile": "/does/not/exist"}))
+		req.Error(options.Validate())
+	})
+}
@@ -0,0 +1,477 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"gitee.com/zhaochuninhefei/gmgo/x509"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRedisKeyPrefix = "xweb:ratelimit:"
+	DefaultRedisTimeout   = 250 * time.Millisecond
+	DefaultRedisPoolSize  = 4
+)
+
+// redisTakeScript takes a token from a bucket stored as a hash of tokens and the last update time. Redis' clock is
+// used so that instances with skewed clocks share buckets consistently. Buckets expire once they would have refilled.
+const redisTakeScript = `
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+if tokens == nil then
+	tokens = burst
+elseif now > last then
+	tokens = math.min(burst, tokens + (now - last) * rate)
+end
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return allowed
+`
+
+var redisTakeScriptSha = func() string {
+	sum := sha1.Sum([]byte(redisTakeScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// RedisOptions configures a RedisBackend
+type RedisOptions struct {
+	// Address is the host:port of the Redis server
+	Address  string
+	Username string
+	Password string
+	Db       int
+
+	// KeyPrefix is prepended to bucket keys so that the Redis server may be shared
+	KeyPrefix string
+
+	// Timeout bounds connecting and each command
+	Timeout time.Duration
+
+	// PoolSize is the number of idle connections retained
+	PoolSize int
+
+	// Tls connects to the server over TLS. It is required to send credentials to a server that is not on a loopback
+	// address.
+	Tls bool
+
+	// TlsCaFile is a PEM file of the CAs that verify the server's certificate, the system CAs are used if empty
+	TlsCaFile string
+
+	// TlsServerName is the name verified in the server's certificate, the host of Address if empty
+	TlsServerName string
+}
+
+// Default provides defaults for all necessary values
+func (options *RedisOptions) Default() {
+	options.Address = ""
+	options.Username = ""
+	options.Password = ""
+	options.Db = 0
+	options.KeyPrefix = DefaultRedisKeyPrefix
+	options.Timeout = DefaultRedisTimeout
+	options.PoolSize = DefaultRedisPoolSize
+	options.Tls = false
+	options.TlsCaFile = ""
+	options.TlsServerName = ""
+}
+
+// Parse parses a configuration map
+func (options *RedisOptions) Parse(config map[interface{}]interface{}) error {
+	for field, target := range map[string]*string{"address": &options.Address, "username": &options.Username, "password": &options.Password, "keyPrefix": &options.KeyPrefix, "tlsCaFile": &options.TlsCaFile, "tlsServerName": &options.TlsServerName} {
+		if interfaceVal, ok := config[field]; ok {
+			if value, ok := interfaceVal.(string); ok {
+				*target = value
+			} else {
+				return fmt.Errorf("could not use value for %s, not a string", field)
+			}
+		}
+	}
+
+	for field, target := range map[string]*int{"db": &options.Db, "poolSize": &options.PoolSize} {
+		if interfaceVal, ok := config[field]; ok {
+			if value, ok := interfaceVal.(int); ok {
+				*target = value
+			} else {
+				return fmt.Errorf("could not use value for %s, not an integer", field)
+			}
+		}
+	}
+
+	if interfaceVal, ok := config["tls"]; ok {
+		if enabled, ok := interfaceVal.(bool); ok {
+			options.Tls = enabled
+		} else {
+			return errors.New("could not use value for tls, not a boolean")
+		}
+	}
+
+	if interfaceVal, ok := config["timeout"]; ok {
+		if timeoutStr, ok := interfaceVal.(string); ok {
+			if timeout, err := time.ParseDuration(timeoutStr); err == nil {
+				options.Timeout = timeout
+			} else {
+				return fmt.Errorf("could not parse timeout %s as a duration (e.g. 1m): %v", timeoutStr, err)
+			}
+		} else {
+			return errors.New("could not use value for timeout, not a string")
+		}
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (options *RedisOptions) Validate() error {
+	host, _, err := net.SplitHostPort(options.Address)
+	if err != nil {
+		return fmt.Errorf("invalid address [%s]: %v", options.Address, err)
+	}
+
+	if options.Password != "" && !options.Tls && !isLoopbackHost(host) {
+		return fmt.Errorf("tls is required to authenticate with redis at [%s], credentials must not be sent in plaintext", options.Address)
+	}
+
+	if options.TlsCaFile != "" || options.TlsServerName != "" {
+		if !options.Tls {
+			return errors.New("tlsCaFile and tlsServerName require tls to be enabled")
+		}
+		if _, err = options.tlsConfig(); err != nil {
+			return err
+		}
+	}
+
+	if options.Db < 0 {
+		return fmt.Errorf("invalid db [%d], must not be negative", options.Db)
+	}
+
+	if options.Timeout <= 0 {
+		return fmt.Errorf("value [%s] for timeout too low, must be positive", options.Timeout)
+	}
+
+	if options.PoolSize < 1 {
+		return fmt.Errorf("value [%d] for poolSize too low, must be at least 1", options.PoolSize)
+	}
+
+	return nil
+}
+
+// isLoopbackHost returns true if host is localhost or a loopback IP
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// tlsConfig returns the configuration used to connect over TLS or nil if Tls is not enabled
+func (options *RedisOptions) tlsConfig() (*gmtls.Config, error) {
+	if !options.Tls {
+		return nil, nil
+	}
+
+	config := &gmtls.Config{
+		ServerName: options.TlsServerName,
+		MinVersion: gmtls.VersionTLS12,
+	}
+
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(options.Address)
+	}
+
+	if options.TlsCaFile != "" {
+		pemBytes, err := os.ReadFile(options.TlsCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read tlsCaFile [%s]: %v", options.TlsCaFile, err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("no certificates found in tlsCaFile [%s]", options.TlsCaFile)
+		}
+	}
+
+	return config, nil
+}
+
+// RedisBackend is a Backend that keeps buckets in Redis, sharing limits between every instance using the same server
+// and KeyPrefix. Buckets are updated atomically by a script, which requires Redis 3.2 or later. It must be closed
+// once it is no longer used.
+type RedisBackend struct {
+	options   RedisOptions
+	tlsConfig *gmtls.Config
+	tlsErr    error
+	idle      chan *redisConn
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+var _ Backend = &RedisBackend{}
+
+// NewRedisBackend returns a RedisBackend that connects on first use
+func NewRedisBackend(options *RedisOptions) *RedisBackend {
+	poolSize := options.PoolSize
+	if poolSize < 1 {
+		poolSize = DefaultRedisPoolSize
+	}
+
+	backend := &RedisBackend{
+		options: *options,
+		idle:    make(chan *redisConn, poolSize),
+		closed:  make(chan struct{}),
+	}
+	backend.tlsConfig, backend.tlsErr = options.tlsConfig()
+
+	return backend
+}
+
+// Take satisfies Backend
+func (backend *RedisBackend) Take(key string, rate float64, burst int) (bool, error) {
+	args := []string{"1", backend.options.KeyPrefix + key, strconv.FormatFloat(rate, 'g', -1, 64), strconv.Itoa(burst)}
+
+	reply, err := backend.do(append([]string{"EVALSHA", redisTakeScriptSha}, args...)...)
+	if redisErr, ok := err.(redisError); ok && strings.HasPrefix(string(redisErr), "NOSCRIPT") {
+		reply, err = backend.do(append([]string{"EVAL", redisTakeScript}, args...)...)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	allowed, ok := reply.(int64)
+	if !ok {
+		return false, fmt.Errorf("unexpected redis reply [%v]", reply)
+	}
+
+	return allowed == 1, nil
+}
+
+// Close closes idle connections, subsequent calls to Take fail
+func (backend *RedisBackend) Close() error {
+	backend.closeOnce.Do(func() {
+		close(backend.closed)
+		for {
+			select {
+			case conn := <-backend.idle:
+				_ = conn.Close()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// do runs a command on an idle or new connection. Connections are discarded after network or protocol errors.
+func (backend *RedisBackend) do(args ...string) (interface{}, error) {
+	conn, err := backend.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(backend.options.Timeout, args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	backend.put(conn)
+	return reply, err
+}
+
+func (backend *RedisBackend) get() (*redisConn, error) {
+	select {
+	case <-backend.closed:
+		return nil, errors.New("redis backend is closed")
+	case conn := <-backend.idle:
+		return conn, nil
+	default:
+	}
+
+	if backend.tlsErr != nil {
+		return nil, backend.tlsErr
+	}
+
+	dialer := &net.Dialer{Timeout: backend.options.Timeout}
+
+	var netConn net.Conn
+	var err error
+	if backend.tlsConfig != nil {
+		netConn, err = gmtls.DialWithDialer(dialer, "tcp", backend.options.Address, backend.tlsConfig)
+	} else {
+		netConn, err = dialer.Dial("tcp", backend.options.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &redisConn{Conn: netConn, reader: bufio.NewReader(netConn)}
+
+	if backend.options.Password != "" {
+		args := []string{"AUTH", backend.options.Password}
+		if backend.options.Username != "" {
+			args = []string{"AUTH", backend.options.Username, backend.options.Password}
+		}
+		if _, err = conn.do(backend.options.Timeout, args...); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("redis authentication failed: %v", err)
+		}
+	}
+
+	if backend.options.Db != 0 {
+		if _, err = conn.do(backend.options.Timeout, "SELECT", strconv.Itoa(backend.options.Db)); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("redis select failed: %v", err)
+		}
+	}
+
+	return conn, nil
+}
+
+func (backend *RedisBackend) put(conn *redisConn) {
+	select {
+	case <-backend.closed:
+		_ = conn.Close()
+		return
+	default:
+	}
+
+	select {
+	case backend.idle <- conn:
+	default:
+		_ = conn.Close()
+	}
+}
+
+// redisError is an error reply from the server, the connection remains usable
+type redisError string
+
+func (err redisError) Error() string {
+	return "redis: " + string(err)
+}
+
+// redisConn is a connection speaking RESP, the Redis protocol
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// do writes a command and reads its reply
+func (conn *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write(encodeRedisCommand(args)); err != nil {
+		return nil, err
+	}
+
+	return readRedisReply(conn.reader)
+}
+
+// encodeRedisCommand encodes a command as an array of bulk strings
+func encodeRedisCommand(args []string) []byte {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+
+	return buf
+}
+
+// readRedisReply reads a reply as a string, int64, nil, []interface{} or a redisError
+func readRedisReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("invalid redis reply [%q]", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis bulk string length [%s]", line[1:])
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, length+2)
+		if _, err = io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:length]), nil
+	case '*':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis array length [%s]", line[1:])
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		result := make([]interface{}, 0, length)
+		for i := 0; i < length; i++ {
+			entry, err := readRedisReply(reader)
+			if _, ok := err.(redisError); err != nil && !ok {
+				return nil, err
+			}
+			result = append(result, entry)
+		}
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("invalid redis reply type [%c]", line[0])
+}
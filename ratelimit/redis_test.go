@@ -0,0 +1,292 @@
+package ratelimit
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"gitee.com/zhaochuninhefei/gmgo/x509"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRedisServer answers RESP commands with replies from respond and records the commands received
+type testRedisServer struct {
+	listener net.Listener
+	respond  func(args []string) string
+
+	lock     sync.Mutex
+	commands [][]string
+	conns    int
+}
+
+func newTestRedisServer(t *testing.T, respond func(args []string) string) *testRedisServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return serveTestRedis(t, listener, respond)
+}
+
+// newTestRedisTlsServer is a testRedisServer served over TLS with a certificate for localhost, whose PEM file is
+// returned as the CA to trust
+func newTestRedisTlsServer(t *testing.T, respond func(args []string) string) (*testRedisServer, string) {
+	req := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	req.NoError(err)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	req.NoError(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+
+	return serveTestRedis(t, gmtls.NewListener(listener, &gmtls.Config{
+		Certificates: []gmtls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}), respond), caFile
+}
+
+func serveTestRedis(t *testing.T, listener net.Listener, respond func(args []string) string) *testRedisServer {
+	server := &testRedisServer{listener: listener, respond: respond}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.lock.Lock()
+			server.conns++
+			server.lock.Unlock()
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (server *testRedisServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+
+	for {
+		reply, err := readRedisReply(reader)
+		if err != nil {
+			return
+		}
+
+		var args []string
+		for _, arg := range reply.([]interface{}) {
+			args = append(args, arg.(string))
+		}
+
+		server.lock.Lock()
+		server.commands = append(server.commands, args)
+		server.lock.Unlock()
+
+		if _, err = conn.Write([]byte(server.respond(args))); err != nil {
+			return
+		}
+	}
+}
+
+func (server *testRedisServer) commandNames() []string {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	var result []string
+	for _, command := range server.commands {
+		result = append(result, command[0])
+	}
+	return result
+}
+
+func TestRedisBackend(t *testing.T) {
+	newBackend := func(server *testRedisServer, configure func(options *RedisOptions)) *RedisBackend {
+		options := &RedisOptions{}
+		options.Default()
+		options.Address = server.listener.Addr().String()
+		if configure != nil {
+			configure(options)
+		}
+		backend := NewRedisBackend(options)
+		t.Cleanup(func() { _ = backend.Close() })
+		return backend
+	}
+
+	t.Run("tokens are taken with the bucket script", func(t *testing.T) {
+		req := require.New(t)
+		var replies = []string{":1\r\n", ":0\r\n"}
+		server := newTestRedisServer(t, func(args []string) string {
+			reply := replies[0]
+			replies = replies[1:]
+			return reply
+		})
+		backend := newBackend(server, nil)
+
+		allowed, err := backend.Take("a", 2.5, 5)
+		req.NoError(err)
+		req.True(allowed)
+
+		allowed, err = backend.Take("a", 2.5, 5)
+		req.NoError(err)
+		req.False(allowed)
+
+		req.Equal([]string{"EVALSHA", "EVALSHA"}, server.commandNames())
+		req.Equal([]string{"EVALSHA", redisTakeScriptSha, "1", DefaultRedisKeyPrefix + "a", "2.5", "5"}, server.commands[0])
+		req.Equal(1, server.conns)
+	})
+
+	t.Run("the script is loaded if it is not cached", func(t *testing.T) {
+		req := require.New(t)
+		server := newTestRedisServer(t, func(args []string) string {
+			if args[0] == "EVALSHA" {
+				return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+			}
+			return ":1\r\n"
+		})
+		backend := newBackend(server, nil)
+
+		allowed, err := backend.Take("a", 1, 1)
+		req.NoError(err)
+		req.True(allowed)
+		req.Equal([]string{"EVALSHA", "EVAL"}, server.commandNames())
+		req.Equal(redisTakeScript, server.commands[1][1])
+	})
+
+	t.Run("connections authenticate and select the db", func(t *testing.T) {
+		req := require.New(t)
+		server := newTestRedisServer(t, func(args []string) string {
+			if args[0] == "EVALSHA" {
+				return ":1\r\n"
+			}
+			return "+OK\r\n"
+		})
+		backend := newBackend(server, func(options *RedisOptions) {
+			options.Username = "xweb"
+			options.Password = "secret"
+			options.Db = 3
+		})
+
+		_, err := backend.Take("a", 1, 1)
+		req.NoError(err)
+		req.Equal([]string{"AUTH", "SELECT", "EVALSHA"}, server.commandNames())
+		req.Equal([]string{"AUTH", "xweb", "secret"}, server.commands[0])
+		req.Equal([]string{"SELECT", "3"}, server.commands[1])
+	})
+
+	t.Run("connections are made over tls", func(t *testing.T) {
+		req := require.New(t)
+		server, caFile := newTestRedisTlsServer(t, func(args []string) string {
+			if args[0] == "EVALSHA" {
+				return ":1\r\n"
+			}
+			return "+OK\r\n"
+		})
+
+		backend := newBackend(server, func(options *RedisOptions) {
+			options.Password = "secret"
+			options.Tls = true
+			options.TlsCaFile = caFile
+			options.TlsServerName = "localhost"
+		})
+		allowed, err := backend.Take("a", 1, 1)
+		req.NoError(err)
+		req.True(allowed)
+		req.Equal([]string{"AUTH", "EVALSHA"}, server.commandNames())
+
+		untrusted := newBackend(server, func(options *RedisOptions) {
+			options.Tls = true
+			options.TlsServerName = "localhost"
+		})
+		_, err = untrusted.Take("a", 1, 1)
+		req.Error(err)
+		req.Equal([]string{"AUTH", "EVALSHA"}, server.commandNames())
+	})
+
+	t.Run("errors are returned", func(t *testing.T) {
+		req := require.New(t)
+		server := newTestRedisServer(t, func(args []string) string {
+			return "-ERR wrong number of arguments\r\n"
+		})
+		backend := newBackend(server, nil)
+
+		_, err := backend.Take("a", 1, 1)
+		req.EqualError(err, "redis: ERR wrong number of arguments")
+
+		_, err = newBackend(&testRedisServer{listener: closedListener(t)}, nil).Take("a", 1, 1)
+		req.Error(err)
+
+		req.NoError(backend.Close())
+		_, err = backend.Take("a", 1, 1)
+		req.EqualError(err, "redis backend is closed")
+	})
+}
+
+func closedListener(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+	return listener
+}
+
+func TestRedisProtocol(t *testing.T) {
+	read := func(reply string) (interface{}, error) {
+		return readRedisReply(bufio.NewReader(strings.NewReader(reply)))
+	}
+
+	t.Run("commands are encoded as bulk string arrays", func(t *testing.T) {
+		req := require.New(t)
+		req.Equal("*2\r\n$3\r\nGET\r\n$0\r\n\r\n", string(encodeRedisCommand([]string{"GET", ""})))
+	})
+
+	t.Run("replies are decoded", func(t *testing.T) {
+		req := require.New(t)
+		for reply, expected := range map[string]interface{}{
+			"+OK\r\n":                              "OK",
+			":42\r\n":                              int64(42),
+			"$5\r\nhello\r\n":                      "hello",
+			"$-1\r\n":                              nil,
+			"*2\r\n:1\r\n$1\r\na\r\n":              []interface{}{int64(1), "a"},
+			"*1\r\n-ERR in array\r\n":              []interface{}{nil},
+			"$" + strconv.Itoa(2) + "\r\n\r\n\r\n": "\r\n",
+		} {
+			value, err := read(reply)
+			req.NoError(err, reply)
+			req.Equal(expected, value, reply)
+		}
+
+		_, err := read("-ERR failed\r\n")
+		req.Equal(redisError("ERR failed"), err)
+
+		for _, reply := range []string{"?\r\n", "+OK\n", "$x\r\n", ":1"} {
+			_, err = read(reply)
+			req.Error(err, fmt.Sprintf("%q", reply))
+		}
+	})
+}
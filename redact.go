@@ -0,0 +1,227 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const RedactedValue = "<redacted>"
+
+// DefaultSensitiveKeyFragments are lower case fragments of configuration keys whose values are considered secret. A
+// fragment matches one or more whole segments of a key, where keys are split into segments at camel case humps,
+// underscores, dashes and dots, i.e. "privatekey" matches privateKey and private_key, "pin" matches pin and userPin
+// but not pinnedCerts or spinner. A trailing s is ignored, so "secret" also matches secrets.
+var DefaultSensitiveKeyFragments = []string{
+	"password",
+	"passphrase",
+	"secret",
+	"token",
+	"pin",
+	"privatekey",
+	"apikey",
+	"credential",
+}
+
+// keyAddressKeys are configuration keys that hold identity private key addresses. These are redacted with
+// redactKeyAddress so that file locations remain visible while inline key material is removed.
+var keyAddressKeys = map[string]struct{}{
+	"key":        {},
+	"server_key": {},
+}
+
+// MarshalSafe renders the identity, options, and web sections of the source configuration as JSON with private keys,
+// passphrases, tokens and other secrets redacted. The output is suitable for inclusion in bug reports and for
+// exposure via administrative APIs. Additional sensitive key fragments may be supplied via SensitiveKeyFragments.
+func (config *InstanceConfig) MarshalSafe() ([]byte, error) {
+	redactor := newRedactor(config.SensitiveKeyFragments)
+
+	result := map[string]interface{}{}
+
+	for _, section := range []string{config.DefaultIdentitySection, config.OptionsSection, config.Section} {
+		if section == "" {
+			continue
+		}
+
+		if sectionVal, ok := config.SourceConfig[section]; ok {
+			result[section] = redactor.redact("", sectionVal)
+		}
+	}
+
+	return json.MarshalIndent(result, "", "  ")
+}
+
+// redactor replaces secret values in configuration maps
+type redactor struct {
+	fragments []string
+}
+
+func newRedactor(additionalFragments []string) *redactor {
+	result := &redactor{}
+
+	for _, fragment := range append(append([]string{}, DefaultSensitiveKeyFragments...), additionalFragments...) {
+		if normalized := strings.Join(keySegments(fragment), ""); normalized != "" {
+			result.fragments = append(result.fragments, normalized)
+		}
+	}
+
+	return result
+}
+
+// isSensitiveKey returns true if the supplied configuration key is considered to hold a secret value, see
+// DefaultSensitiveKeyFragments
+func (r *redactor) isSensitiveKey(key string) bool {
+	segments := keySegments(key)
+
+	for start := range segments {
+		joined := ""
+		for _, segment := range segments[start:] {
+			joined += segment
+			for _, fragment := range r.fragments {
+				if joined == fragment || joined == fragment+"s" {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// keySegments splits a configuration key into lower case segments at camel case humps, underscores, dashes, dots and
+// other separators, i.e. bearerToken, bearer_token and BEARER-TOKEN all yield [bearer token]. Runs of upper case
+// letters are kept together, such that JWTSecret yields [jwt secret].
+func keySegments(key string) []string {
+	var segments []string
+	var current []rune
+
+	flush := func() {
+		if len(current) > 0 {
+			segments = append(segments, strings.ToLower(string(current)))
+			current = current[:0]
+		}
+	}
+
+	runes := []rune(key)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+
+		if unicode.IsUpper(r) && i > 0 {
+			previous := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextIsLower) {
+				flush()
+			}
+		}
+
+		current = append(current, r)
+	}
+	flush()
+
+	return segments
+}
+
+// redact returns a string keyed deep copy of value with any sensitive values redacted. Key is the configuration key
+// value was found under, if any.
+func (r *redactor) redact(key string, value interface{}) interface{} {
+	return r.redactValue(key, value, false)
+}
+
+// redactValue redacts value, redacting all values nested within it if it was found under a sensitive key, i.e. a map
+// of client ids to secrets
+func (r *redactor) redactValue(key string, value interface{}, sensitive bool) interface{} {
+	sensitive = sensitive || (key != "" && r.isSensitiveKey(key))
+
+	switch typedValue := value.(type) {
+	case map[interface{}]interface{}:
+		result := map[string]interface{}{}
+		for childKey, childValue := range typedValue {
+			childKeyStr := fmt.Sprint(childKey)
+			result[childKeyStr] = r.redactValue(childKeyStr, childValue, sensitive)
+		}
+		return result
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+		for childKey, childValue := range typedValue {
+			result[childKey] = r.redactValue(childKey, childValue, sensitive)
+		}
+		return result
+	case []interface{}:
+		var result []interface{}
+		for _, childValue := range typedValue {
+			result = append(result, r.redactValue(key, childValue, sensitive))
+		}
+		return result
+	}
+
+	if key == "" {
+		return value
+	}
+
+	if _, ok := keyAddressKeys[strings.ToLower(key)]; ok {
+		if address, ok := value.(string); ok {
+			return redactKeyAddress(address)
+		}
+		return RedactedValue
+	}
+
+	if sensitive {
+		return RedactedValue
+	}
+
+	return value
+}
+
+// redactKeyAddress redacts inline private key material and any query parameters (i.e. PINs for `engine` keys)
+// from an identity key address. File locations are left intact, values that do not look like a location are
+// redacted entirely.
+func redactKeyAddress(address string) string {
+	if address == "" {
+		return ""
+	}
+
+	if strings.HasPrefix(address, "pem:") {
+		return "pem:" + RedactedValue
+	}
+
+	if queryIndex := strings.Index(address, "?"); queryIndex >= 0 {
+		return address[:queryIndex+1] + RedactedValue
+	}
+
+	if strings.HasPrefix(address, "file:") || strings.HasPrefix(address, "engine:") || strings.ContainsAny(address, `/\.`) {
+		return address
+	}
+
+	return RedactedValue
+}
+
+// redactOptions returns a string keyed deep copy of an options map with any sensitive values redacted using the
+// default sensitive key fragments and additionalFragments.
+func redactOptions(options map[interface{}]interface{}, additionalFragments []string) map[string]interface{} {
+	if options == nil {
+		return nil
+	}
+
+	result, _ := newRedactor(additionalFragments).redact("", options).(map[string]interface{})
+	return result
+}
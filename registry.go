@@ -27,6 +27,14 @@ type Registry interface {
 	Get(binding string) ApiHandlerFactory
 }
 
+// OverridableRegistry is a Registry that can replace a factory previously registered for the same binding
+type OverridableRegistry interface {
+	Registry
+	Set(factory ApiHandlerFactory, allowOverride bool) error
+}
+
+var _ OverridableRegistry = &RegistryMap{}
+
 // RegistryMap is a basic Registry implementation backed by a simple mapping of binding (string) to ApiHandlerFactory instances
 type RegistryMap struct {
 	factories map[string]ApiHandlerFactory
@@ -51,6 +59,24 @@ func (registry RegistryMap) Add(factory ApiHandlerFactory) error {
 	return nil
 }
 
+// Set adds a factory to the registry. If a previous factory with the same binding is registered it is replaced when
+// allowOverride is true, otherwise an error is returned as with Add.
+func (registry RegistryMap) Set(factory ApiHandlerFactory, allowOverride bool) error {
+	if !allowOverride {
+		return registry.Add(factory)
+	}
+
+	if _, ok := registry.factories[factory.Binding()]; ok {
+		logrus.Warnf("overriding xweb factory with binding: %v", factory.Binding())
+	} else {
+		logrus.Debugf("adding xweb factory with binding: %v", factory.Binding())
+	}
+
+	registry.factories[factory.Binding()] = factory
+
+	return nil
+}
+
 // Get retrieves a factory based on a binding or nil if no factory for the binding is registered
 func (registry RegistryMap) Get(binding string) ApiHandlerFactory {
 	return registry.factories[binding]
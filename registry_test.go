@@ -0,0 +1,80 @@
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRegistryMap(t *testing.T) {
+	t.Run("add fails on duplicate bindings", func(t *testing.T) {
+		req := require.New(t)
+		registry := NewRegistryMap()
+
+		req.NoError(registry.Add(&testApiOptionsFactory{}))
+		req.Error(registry.Add(&testApiOptionsFactory{}))
+	})
+
+	t.Run("set without override fails on duplicate bindings", func(t *testing.T) {
+		req := require.New(t)
+		registry := NewRegistryMap()
+		first := &testApiOptionsFactory{}
+
+		req.NoError(registry.Set(first, false))
+		req.Error(registry.Set(&testApiOptionsFactory{}, false))
+		req.Same(first, registry.Get("test"))
+	})
+
+	t.Run("set with override replaces earlier registrations", func(t *testing.T) {
+		req := require.New(t)
+		registry := NewRegistryMap()
+		second := &testApiOptionsFactory{}
+
+		req.NoError(registry.Add(&testApiOptionsFactory{}))
+		req.NoError(registry.Set(second, true))
+		req.Same(second, registry.Get("test"))
+	})
+
+	t.Run("instances register according to the allowRegistryOverride option", func(t *testing.T) {
+		req := require.New(t)
+		instance := NewDefaultInstance(NewRegistryMap(), nil)
+
+		req.NoError(instance.Register(&testApiOptionsFactory{}))
+		req.Error(instance.Register(&testApiOptionsFactory{}))
+
+		req.NoError(instance.Config.Options.Parse(map[interface{}]interface{}{
+			"allowRegistryOverride": true,
+		}))
+		replacement := &testApiOptionsFactory{}
+		req.NoError(instance.Register(replacement))
+		req.Same(replacement, instance.GetRegistry().Get("test"))
+	})
+
+	t.Run("instances add to registries that cannot override", func(t *testing.T) {
+		req := require.New(t)
+		instance := NewDefaultInstance(addOnlyRegistry{NewRegistryMap()}, nil)
+		instance.Config.Options.AllowRegistryOverride = true
+
+		req.NoError(instance.Register(&testApiOptionsFactory{}))
+		req.Error(instance.Register(&testApiOptionsFactory{}))
+	})
+
+	t.Run("non boolean allowRegistryOverride options are rejected", func(t *testing.T) {
+		options := &InstanceOptions{}
+		require.EqualError(t, options.Parse(map[interface{}]interface{}{
+			"allowRegistryOverride": "yes",
+		}), "could not use value for allowRegistryOverride, not a boolean")
+	})
+}
+
+// addOnlyRegistry is a Registry that is not an OverridableRegistry
+type addOnlyRegistry struct {
+	registry *RegistryMap
+}
+
+func (registry addOnlyRegistry) Add(factory ApiHandlerFactory) error {
+	return registry.registry.Add(factory)
+}
+
+func (registry addOnlyRegistry) Get(binding string) ApiHandlerFactory {
+	return registry.registry.Get(binding)
+}
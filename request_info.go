@@ -0,0 +1,79 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+)
+
+const requestInfoContextKey = ContextKey("xweb.requestInfo.ContextKey")
+
+// requestInfo is a mutable per request value placed in the request context by the outermost Server handler. Inner
+// handlers record what they have learned about the request (i.e. the selected api instance) so that outer handlers,
+// like access logging, can report it after the fact.
+//
+// requestInfo is itself the request's context.Context. This lets the demux record the selected ApiHandler under
+// HandlerContextKey without allocating a new context and request for every request, see withHandler.
+type requestInfo struct {
+	context.Context
+	bindPoint *BindPointConfig
+	api       *apiInstance
+	handler   ApiHandler
+	requestId string
+}
+
+// withRequestInfo returns a request with a new requestInfo for the bind point as its context
+func withRequestInfo(request *gmhttp.Request, bindPoint *BindPointConfig) (*gmhttp.Request, *requestInfo) {
+	info := &requestInfo{
+		Context:   request.Context(),
+		bindPoint: bindPoint,
+	}
+	return request.WithContext(info), info
+}
+
+// Value returns the requestInfo itself for requestInfoContextKey and the recorded ApiHandler, if any, for
+// HandlerContextKey. All other keys are resolved by the parent context.
+func (info *requestInfo) Value(key interface{}) interface{} {
+	switch key {
+	case requestInfoContextKey:
+		return info
+	case HandlerContextKey:
+		if info.handler != nil {
+			return info.handler
+		}
+	}
+	return info.Context.Value(key)
+}
+
+// requestInfoFromContext returns the requestInfo for a request or nil
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	if info, ok := ctx.Value(requestInfoContextKey).(*requestInfo); ok {
+		return info
+	}
+	return nil
+}
+
+// withHandler returns a request whose context resolves HandlerContextKey to the ApiHandler. Requests served by a
+// Server record the ApiHandler on their requestInfo and are returned as is, others are given a new context.
+func withHandler(request *gmhttp.Request, handler ApiHandler) *gmhttp.Request {
+	if info := requestInfoFromContext(request.Context()); info != nil {
+		info.handler = handler
+		return request
+	}
+	return request.WithContext(context.WithValue(request.Context(), HandlerContextKey, handler))
+}
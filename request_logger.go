@@ -0,0 +1,101 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// RequestIdHeader is the header used to propagate request ids. A well-formed incoming value is reused, otherwise
+	// one is generated. The request id is always returned in the response.
+	RequestIdHeader = "X-Request-Id"
+
+	// maxRequestIdLength bounds incoming request ids so clients can not inflate log lines
+	maxRequestIdLength = 128
+
+	loggerContextKey = ContextKey("xweb.Logger.ContextKey")
+)
+
+// LoggerFromContext returns the request scoped logger placed in the context by the Server. It carries the requestId,
+// server, bindPoint and clientIdentity fields, and once an ApiHandler has been selected the binding and api fields.
+// If the context has no request scoped logger, the default logger is returned.
+func LoggerFromContext(ctx context.Context) *logrus.Entry {
+	if logger, ok := ctx.Value(loggerContextKey).(*logrus.Entry); ok {
+		return logger
+	}
+	return pfxlog.Logger().Entry
+}
+
+// withLoggerFields returns a context with a request scoped logger that has the given fields added
+func withLoggerFields(ctx context.Context, fields logrus.Fields) context.Context {
+	return context.WithValue(ctx, loggerContextKey, LoggerFromContext(ctx).WithFields(fields))
+}
+
+// wrapRequestLogger wraps a http.Handler with one that assigns the request id and places a request scoped logger in the
+// request context, see LoggerFromContext
+func (server *Server) wrapRequestLogger(point *BindPointConfig, handler gmhttp.Handler) gmhttp.Handler {
+	return gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		requestId := request.Header.Get(RequestIdHeader)
+		if !isValidRequestId(requestId) {
+			requestId = newErrorId()
+		}
+		writer.Header().Set(RequestIdHeader, requestId)
+
+		if info := requestInfoFromContext(request.Context()); info != nil {
+			info.requestId = requestId
+		}
+
+		fields := logrus.Fields{
+			"requestId": requestId,
+			"server":    server.ServerConfig.Name,
+			"bindPoint": point.InterfaceAddress,
+		}
+
+		if clientIdentity := clientIdentityFromRequest(request); clientIdentity != "" {
+			fields["clientIdentity"] = clientIdentity
+		}
+
+		handler.ServeHTTP(writer, request.WithContext(withLoggerFields(request.Context(), fields)))
+	})
+}
+
+// clientIdentityFromRequest returns the common name of the client certificate or an empty string if none was presented
+func clientIdentityFromRequest(request *gmhttp.Request) string {
+	if request.TLS == nil || len(request.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return request.TLS.PeerCertificates[0].Subject.CommonName
+}
+
+// isValidRequestId returns true if the value is a non-empty, bounded string of printable ASCII characters
+func isValidRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > maxRequestIdLength {
+		return false
+	}
+
+	for i := 0; i < len(requestId); i++ {
+		if requestId[i] < 33 || requestId[i] > 126 {
+			return false
+		}
+	}
+
+	return true
+}
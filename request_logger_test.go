@@ -0,0 +1,63 @@
+package xweb
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func Test_wrapRequestLogger(t *testing.T) {
+	server := &Server{ServerConfig: &ServerConfig{Name: "test-server"}}
+	point := &BindPointConfig{InterfaceAddress: "0.0.0.0:443"}
+
+	serve := func(requestId string) (*gmhttptest.ResponseRecorder, logrus.Fields) {
+		var fields logrus.Fields
+		handler := server.wrapRequestLogger(point, gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			fields = LoggerFromContext(request.Context()).Data
+		}))
+
+		request := gmhttptest.NewRequest("GET", "/things", nil)
+		if requestId != "" {
+			request.Header.Set(RequestIdHeader, requestId)
+		}
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder, fields
+	}
+
+	t.Run("request scoped loggers carry request fields", func(t *testing.T) {
+		req := require.New(t)
+		recorder, fields := serve("")
+
+		req.NotEmpty(fields["requestId"])
+		req.Equal(fields["requestId"], recorder.Header().Get(RequestIdHeader))
+		req.Equal("test-server", fields["server"])
+		req.Equal("0.0.0.0:443", fields["bindPoint"])
+		req.NotContains(fields, "clientIdentity")
+	})
+
+	t.Run("well-formed incoming request ids are reused", func(t *testing.T) {
+		req := require.New(t)
+		recorder, fields := serve("abc-123")
+
+		req.Equal("abc-123", fields["requestId"])
+		req.Equal("abc-123", recorder.Header().Get(RequestIdHeader))
+	})
+
+	t.Run("malformed incoming request ids are replaced", func(t *testing.T) {
+		req := require.New(t)
+		_, fields := serve(strings.Repeat("a", maxRequestIdLength+1))
+		req.NotEqual(strings.Repeat("a", maxRequestIdLength+1), fields["requestId"])
+
+		_, fields = serve("abc 123")
+		req.NotEqual("abc 123", fields["requestId"])
+	})
+
+	t.Run("contexts without a request scoped logger return the default logger", func(t *testing.T) {
+		req := require.New(t)
+		req.NotNil(LoggerFromContext(gmhttptest.NewRequest("GET", "/", nil).Context()))
+	})
+}
@@ -0,0 +1,113 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// parseResponseHeaders parses a map of header names to a string or list of strings as a http.Header with canonical
+// header names
+func parseResponseHeaders(value interface{}) (gmhttp.Header, error) {
+	headerMap, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("responseHeaders must be a map")
+	}
+
+	result := gmhttp.Header{}
+
+	for nameInterface, valueInterface := range headerMap {
+		name, ok := nameInterface.(string)
+		if !ok {
+			return nil, fmt.Errorf("response header name [%v] must be a string", nameInterface)
+		}
+
+		switch headerValue := valueInterface.(type) {
+		case string:
+			result.Add(name, headerValue)
+		case []interface{}:
+			for _, entry := range headerValue {
+				entryStr, ok := entry.(string)
+				if !ok {
+					return nil, fmt.Errorf("values for response header [%s] must be strings", name)
+				}
+				result.Add(name, entryStr)
+			}
+		default:
+			return nil, fmt.Errorf("value for response header [%s] must be a string or list of strings", name)
+		}
+	}
+
+	return result, nil
+}
+
+// validateResponseHeaders checks that header names are tokens and that values cannot split the response
+func validateResponseHeaders(headers gmhttp.Header) error {
+	for name, values := range headers {
+		if !isHeaderToken(name) {
+			return fmt.Errorf("invalid response header name [%s]", name)
+		}
+
+		for _, value := range values {
+			if strings.ContainsAny(value, "\r\n\x00") {
+				return fmt.Errorf("invalid value for response header [%s], must not contain control characters", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func isHeaderToken(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, r := range name {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			continue
+		}
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// injectResponseHeaders sets headers on a response before it is handled, allowing handlers to override them. Values
+// are copied per request so that handlers mutating them in place cannot alter the configuration.
+func injectResponseHeaders(header gmhttp.Header, headers gmhttp.Header) {
+	for name, values := range headers {
+		header[name] = append([]string(nil), values...)
+	}
+}
+
+// wrapResponseHeaders wraps a http.Handler with another http.Handler that injects static response headers
+func wrapResponseHeaders(headers gmhttp.Header, handler gmhttp.Handler) gmhttp.Handler {
+	if len(headers) == 0 {
+		return handler
+	}
+
+	return gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		injectResponseHeaders(writer.Header(), headers)
+		handler.ServeHTTP(writer, request)
+	})
+}
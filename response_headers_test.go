@@ -0,0 +1,95 @@
+package xweb
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestResponseHeaders(t *testing.T) {
+	t.Run("headers are parsed with canonical names", func(t *testing.T) {
+		req := require.New(t)
+		bindPoint := &BindPointConfig{}
+
+		req.NoError(bindPoint.Parse(map[interface{}]interface{}{
+			"interface": "127.0.0.1:1280",
+			"address":   "localhost:1280",
+			"responseHeaders": map[interface{}]interface{}{
+				"cache-control": "no-store",
+				"X-Instance":    []interface{}{"a", "b"},
+			},
+		}))
+		req.NoError(bindPoint.Validate())
+
+		req.Equal("no-store", bindPoint.ResponseHeaders.Get("Cache-Control"))
+		req.Equal([]string{"a", "b"}, bindPoint.ResponseHeaders.Values("X-Instance"))
+	})
+
+	t.Run("invalid headers are rejected", func(t *testing.T) {
+		req := require.New(t)
+
+		_, err := parseResponseHeaders("no-store")
+		req.Error(err)
+
+		_, err = parseResponseHeaders(map[interface{}]interface{}{"X-Count": 1})
+		req.Error(err)
+
+		req.Error(validateResponseHeaders(gmhttp.Header{"Bad Name": {"value"}}))
+		req.Error(validateResponseHeaders(gmhttp.Header{"X-Split": {"value\r\nSet-Cookie: a=b"}}))
+	})
+
+	t.Run("api headers take precedence over bind point headers and handlers may override them", func(t *testing.T) {
+		req := require.New(t)
+		api := &ApiConfig{binding: "test"}
+		req.NoError(api.Parse(map[interface{}]interface{}{
+			"binding": "test",
+			"responseHeaders": map[interface{}]interface{}{
+				"Cache-Control": "private",
+				"X-Api":         "test",
+			},
+		}))
+		req.NoError(api.Validate())
+
+		handler := gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			writer.Header().Add("X-Api", "handler")
+			writer.Header().Set("X-Override", "handler")
+		})
+
+		bindPointHeaders := gmhttp.Header{
+			"Cache-Control": {"no-store"},
+			"X-Bind-Point":  {"bp"},
+			"X-Override":    {"bp"},
+		}
+
+		wrapped := wrapResponseHeaders(bindPointHeaders, wrapApiMiddleware(api, handler))
+
+		recorder := gmhttptest.NewRecorder()
+		wrapped.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/", nil))
+
+		req.Equal("private", recorder.Header().Get("Cache-Control"))
+		req.Equal("bp", recorder.Header().Get("X-Bind-Point"))
+		req.Equal("handler", recorder.Header().Get("X-Override"))
+		req.Equal([]string{"test", "handler"}, recorder.Header().Values("X-Api"))
+		req.Equal([]string{"test"}, api.ResponseHeaders().Values("X-Api"))
+	})
+	t.Run("handlers mutating header values in place do not alter the configuration", func(t *testing.T) {
+		req := require.New(t)
+		headers := gmhttp.Header{"X-Api": {"test"}}
+
+		var seen []string
+		wrapped := wrapResponseHeaders(headers, gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+			seen = append(seen, writer.Header().Get("X-Api"))
+			writer.Header()["X-Api"][0] = "handler"
+		}))
+
+		for i := 0; i < 2; i++ {
+			recorder := gmhttptest.NewRecorder()
+			wrapped.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/", nil))
+			req.Equal("handler", recorder.Header().Get("X-Api"))
+		}
+
+		req.Equal([]string{"test", "test"}, seen)
+		req.Equal([]string{"test"}, headers.Values("X-Api"))
+	})
+}
@@ -0,0 +1,140 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"bufio"
+	"errors"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"io"
+	"net"
+	"sync"
+)
+
+// statusResponseWriter is a http.ResponseWriter that records the status code and number of bytes written. Flush, Hijack,
+// Push and ReadFrom calls are delegated to the wrapped http.ResponseWriter if supported.
+type statusResponseWriter struct {
+	gmhttp.ResponseWriter
+	status   int
+	written  int64
+	hijacked bool
+	tracker  *connTracker
+}
+
+func newStatusResponseWriter(writer gmhttp.ResponseWriter) *statusResponseWriter {
+	return &statusResponseWriter{
+		ResponseWriter: writer,
+	}
+}
+
+// statusResponseWriterPool recycles the statusResponseWriter's allocated by each request on the hot path
+var statusResponseWriterPool = sync.Pool{
+	New: func() interface{} {
+		return &statusResponseWriter{}
+	},
+}
+
+// acquireStatusResponseWriter returns a pooled statusResponseWriter for the duration of a http.Handler's ServeHTTP
+// call. Per the http.ResponseWriter contract it must not be used after ServeHTTP returns, at which point it is
+// returned to the pool with releaseStatusResponseWriter.
+func acquireStatusResponseWriter(writer gmhttp.ResponseWriter) *statusResponseWriter {
+	w := statusResponseWriterPool.Get().(*statusResponseWriter)
+	w.ResponseWriter = writer
+	return w
+}
+
+func releaseStatusResponseWriter(w *statusResponseWriter) {
+	*w = statusResponseWriter{}
+	statusResponseWriterPool.Put(w)
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = gmhttp.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.written += int64(n)
+	return n, err
+}
+
+// Status returns the status code sent, http.StatusOK if only a body was written, or 0 if nothing has been written
+func (w *statusResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *statusResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(gmhttp.Flusher); ok {
+		if w.status == 0 {
+			w.status = gmhttp.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+func (w *statusResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(gmhttp.Hijacker); ok {
+		w.hijacked = true
+		conn, readWriter, err := hijacker.Hijack()
+		if err == nil && w.tracker != nil {
+			conn = w.tracker.wrapHijacked(conn)
+		}
+		return conn, readWriter, err
+	}
+	return nil, nil, errors.New("wrapped response writer does not support hijacking")
+}
+
+func (w *statusResponseWriter) Push(target string, options *gmhttp.PushOptions) error {
+	if pusher, ok := w.ResponseWriter.(gmhttp.Pusher); ok {
+		return pusher.Push(target, options)
+	}
+	return gmhttp.ErrNotSupported
+}
+
+// ReadFrom delegates to the wrapped http.ResponseWriter's io.ReaderFrom, which lets http.Server send files with
+// sendfile, and copies otherwise
+func (w *statusResponseWriter) ReadFrom(reader io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = gmhttp.StatusOK
+	}
+
+	var n int64
+	var err error
+	if readerFrom, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = readerFrom.ReadFrom(reader)
+	} else {
+		n, err = io.Copy(writerOnly{w.ResponseWriter}, reader)
+	}
+	w.written += n
+	return n, err
+}
+
+// writerOnly hides all methods but Write, so that io.Copy does not call back into ReadFrom
+type writerOnly struct {
+	io.Writer
+}
+
+// Unwrap returns the wrapped http.ResponseWriter
+func (w *statusResponseWriter) Unwrap() gmhttp.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,75 @@
+package xweb
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+type readerFromResponseWriter struct {
+	*gmhttptest.ResponseRecorder
+	readFrom bool
+}
+
+func (w *readerFromResponseWriter) ReadFrom(reader io.Reader) (int64, error) {
+	w.readFrom = true
+	return io.Copy(w.ResponseRecorder, reader)
+}
+
+type pushingResponseWriter struct {
+	*gmhttptest.ResponseRecorder
+	pushed []string
+}
+
+func (w *pushingResponseWriter) Push(target string, _ *gmhttp.PushOptions) error {
+	w.pushed = append(w.pushed, target)
+	return nil
+}
+
+func Test_statusResponseWriter(t *testing.T) {
+	t.Run("ReadFrom delegates to the wrapped writer and counts bytes", func(t *testing.T) {
+		req := require.New(t)
+		wrapped := &readerFromResponseWriter{ResponseRecorder: gmhttptest.NewRecorder()}
+		writer := newStatusResponseWriter(wrapped)
+
+		n, err := writer.ReadFrom(strings.NewReader("hello"))
+		req.NoError(err)
+		req.Equal(int64(5), n)
+		req.True(wrapped.readFrom)
+		req.Equal(int64(5), writer.written)
+		req.Equal(gmhttp.StatusOK, writer.Status())
+		req.Equal("hello", wrapped.Body.String())
+	})
+
+	t.Run("ReadFrom copies if the wrapped writer is not a ReaderFrom", func(t *testing.T) {
+		req := require.New(t)
+		recorder := gmhttptest.NewRecorder()
+		writer := newStatusResponseWriter(recorder)
+
+		n, err := writer.ReadFrom(strings.NewReader("hello"))
+		req.NoError(err)
+		req.Equal(int64(5), n)
+		req.Equal(int64(5), writer.written)
+		req.Equal("hello", recorder.Body.String())
+	})
+
+	t.Run("Push delegates to the wrapped writer", func(t *testing.T) {
+		req := require.New(t)
+		wrapped := &pushingResponseWriter{ResponseRecorder: gmhttptest.NewRecorder()}
+		var writer gmhttp.ResponseWriter = newStatusResponseWriter(wrapped)
+
+		pusher, ok := writer.(gmhttp.Pusher)
+		req.True(ok)
+		req.NoError(pusher.Push("/style.css", nil))
+		req.Equal([]string{"/style.css"}, wrapped.pushed)
+	})
+
+	t.Run("Push is not supported if the wrapped writer is not a Pusher", func(t *testing.T) {
+		req := require.New(t)
+		writer := newStatusResponseWriter(gmhttptest.NewRecorder())
+		req.ErrorIs(writer.Push("/style.css", nil), gmhttp.ErrNotSupported)
+	})
+}
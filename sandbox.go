@@ -0,0 +1,154 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/michaelquigley/pfxlog"
+)
+
+// SandboxHook is called by InstanceImpl.Start after all bind points are listening and identities are loaded, after
+// SandboxOptions.Chroot has been applied and before privileges are dropped. Hooks may apply additional process
+// restrictions (i.e. seccomp filters). An error from a hook stops the instance from serving.
+type SandboxHook func(instance Instance) error
+
+// SandboxOptions configures filesystem sandboxing applied after all bind points are listening. The user and group of
+// PrivilegeDropOptions are resolved beforehand, then sandboxing is applied in the following order:
+//
+//  1. Chroot, which requires root
+//  2. SandboxHook's added to the InstanceImpl
+//  3. PrivilegeDropOptions
+//  4. Landlock
+//
+// Files read after startup (i.e. identity files that are reloaded, static content served by APIs) must be reachable
+// within the chroot and permitted by the Landlock rules. Host name resolution may fail within a chroot that does not
+// contain resolver configuration.
+type SandboxOptions struct {
+	// Chroot is a directory to change the process root to
+	Chroot string
+
+	Landlock LandlockOptions
+}
+
+// LandlockOptions configures Linux Landlock filesystem rules. Once applied, paths not listed are inaccessible to the
+// process. Landlock must be applied to every OS thread, which Go only supports for binaries built without cgo.
+type LandlockOptions struct {
+	// ReadOnly are paths, and everything beneath them, that may be read and executed
+	ReadOnly []string
+
+	// ReadWrite are paths, and everything beneath them, that may be read, written, created and removed
+	ReadWrite []string
+
+	// BestEffort will log and continue if Landlock is not supported by the host kernel or binary
+	BestEffort bool
+}
+
+// IsEnabled returns true if any Landlock paths have been configured
+func (options *LandlockOptions) IsEnabled() bool {
+	return len(options.ReadOnly) > 0 || len(options.ReadWrite) > 0
+}
+
+// Parse parses a configuration map
+func (options *SandboxOptions) Parse(optionsMap map[interface{}]interface{}) error {
+	if interfaceVal, ok := optionsMap["chroot"]; ok {
+		if chroot, ok := interfaceVal.(string); ok {
+			options.Chroot = chroot
+		} else {
+			return errors.New("could not use value for chroot, not a string")
+		}
+	}
+
+	if interfaceVal, ok := optionsMap["landlock"]; ok {
+		landlockMap, ok := interfaceVal.(map[interface{}]interface{})
+		if !ok {
+			return errors.New("could not use value for landlock, not a map")
+		}
+
+		if err := options.Landlock.Parse(landlockMap); err != nil {
+			return fmt.Errorf("error parsing landlock: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Parse parses a configuration map
+func (options *LandlockOptions) Parse(optionsMap map[interface{}]interface{}) error {
+	for field, target := range map[string]*[]string{"readOnly": &options.ReadOnly, "readWrite": &options.ReadWrite} {
+		if interfaceVal, ok := optionsMap[field]; ok {
+			paths, ok := interfaceVal.([]interface{})
+			if !ok {
+				return fmt.Errorf("could not use value for %s, not an array", field)
+			}
+
+			for i, pathInterface := range paths {
+				path, ok := pathInterface.(string)
+				if !ok || path == "" {
+					return fmt.Errorf("could not use value for %s[%d], not a non-empty string", field, i)
+				}
+				*target = append(*target, path)
+			}
+		}
+	}
+
+	if interfaceVal, ok := optionsMap["bestEffort"]; ok {
+		if bestEffort, ok := interfaceVal.(bool); ok {
+			options.BestEffort = bestEffort
+		} else {
+			return errors.New("could not use value for bestEffort, not a boolean")
+		}
+	}
+
+	return nil
+}
+
+// ApplyChroot changes the process root to Chroot, if configured
+func (options *SandboxOptions) ApplyChroot() error {
+	if options.Chroot == "" {
+		return nil
+	}
+
+	if err := chroot(options.Chroot); err != nil {
+		return fmt.Errorf("could not chroot to [%s]: %v", options.Chroot, err)
+	}
+
+	pfxlog.Logger().Infof("xweb changed root to [%s]", options.Chroot)
+
+	return nil
+}
+
+// ApplyLandlock restricts filesystem access to the configured Landlock paths, if any are configured
+func (options *SandboxOptions) ApplyLandlock() error {
+	if !options.Landlock.IsEnabled() {
+		return nil
+	}
+
+	if err := applyLandlock(&options.Landlock); err != nil {
+		if options.Landlock.BestEffort && errors.Is(err, errLandlockUnsupported) {
+			pfxlog.Logger().Warnf("xweb landlock rules not applied: %v", err)
+			return nil
+		}
+		return fmt.Errorf("could not apply landlock rules: %v", err)
+	}
+
+	pfxlog.Logger().Infof("xweb applied landlock rules, read only: %v, read write: %v", options.Landlock.ReadOnly, options.Landlock.ReadWrite)
+
+	return nil
+}
+
+var errLandlockUnsupported = errors.New("landlock is not supported")
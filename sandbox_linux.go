@@ -0,0 +1,117 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	landlockReadAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+	// landlockFileAccess are the only rights that may be granted on a path that is not a directory
+	landlockFileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+
+	landlockAbiV1Access = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR | unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+)
+
+// landlockHandledAccess returns the filesystem rights known to the given Landlock ABI version
+func landlockHandledAccess(abi int) uint64 {
+	access := uint64(landlockAbiV1Access)
+	if abi >= 2 {
+		access |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		access |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	return access
+}
+
+func applyLandlock(options *LandlockOptions) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return fmt.Errorf("%w by the kernel: %v", errLandlockUnsupported, errno)
+	}
+
+	handled := landlockHandledAccess(int(abi))
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	rulesetFd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("could not create ruleset: %v", errno)
+	}
+	defer func() { _ = unix.Close(int(rulesetFd)) }()
+
+	for _, path := range options.ReadOnly {
+		if err := addLandlockRule(int(rulesetFd), path, landlockReadAccess&handled); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range options.ReadWrite {
+		if err := addLandlockRule(int(rulesetFd), path, handled); err != nil {
+			return err
+		}
+	}
+
+	// Landlock and no_new_privs apply per thread, every thread of the process must be restricted. This is only
+	// possible when the runtime manages all threads, i.e. when cgo is not in use.
+	if _, _, errno = syscall.AllThreadsSyscall(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0); errno != 0 {
+		if errors.Is(errno, syscall.ENOTSUP) {
+			return fmt.Errorf("%w by binaries built with cgo", errLandlockUnsupported)
+		}
+		return fmt.Errorf("could not set no_new_privs: %v", errno)
+	}
+
+	if _, _, errno = syscall.AllThreadsSyscall(unix.SYS_LANDLOCK_RESTRICT_SELF, rulesetFd, 0, 0); errno != 0 {
+		return fmt.Errorf("could not restrict process: %v", errno)
+	}
+
+	return nil
+}
+
+func addLandlockRule(rulesetFd int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("could not open landlock path [%s]: %v", path, err)
+	}
+	defer func() { _ = unix.Close(fd) }()
+
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		access &= landlockFileAccess
+	}
+
+	pathBeneath := unix.LandlockPathBeneathAttr{
+		Allowed_access: access,
+		Parent_fd:      int32(fd),
+	}
+
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(rulesetFd), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&pathBeneath)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("could not add landlock rule for path [%s]: %v", path, errno)
+	}
+
+	return nil
+}
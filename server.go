@@ -18,17 +18,21 @@ package xweb
 
 import (
 	"context"
-	"gitee.com/zhaochuninhefei/gmgo/gmtls"
 	"errors"
 	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
 	"github.com/michaelquigley/pfxlog"
 	"github.com/openziti/foundation/v2/debugz"
 	transporttls "github.com/openziti/transport/v2/tls"
+	"github.com/openziti/xweb/v2/factory"
+	"github.com/openziti/xweb/v2/metrics"
 	"github.com/openziti/xweb/v2/middleware"
 	"io"
 	"log"
 	"net"
-	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"runtime"
+	"sync"
+	"time"
 )
 
 type ContextKey string
@@ -41,6 +45,8 @@ type ServerContext struct {
 	BindPoint    *BindPointConfig
 	ServerConfig *ServerConfig
 	Config       *InstanceConfig
+	FeatureFlags *FeatureFlags
+	Services     *factory.Services
 }
 
 type namedHttpServer struct {
@@ -49,6 +55,10 @@ type namedHttpServer struct {
 	BindPointConfig *BindPointConfig
 	ServerConfig    *ServerConfig
 	InstanceConfig  *InstanceConfig
+	FeatureFlags    *FeatureFlags
+	Services        *factory.Services
+	connTracker     *connTracker
+	listeners       []net.Listener
 }
 
 func (s namedHttpServer) NewBaseContext(_ net.Listener) context.Context {
@@ -56,10 +66,13 @@ func (s namedHttpServer) NewBaseContext(_ net.Listener) context.Context {
 		BindPoint:    s.BindPointConfig,
 		ServerConfig: s.ServerConfig,
 		Config:       s.InstanceConfig,
+		FeatureFlags: s.FeatureFlags,
+		Services:     s.Services,
 	}
 
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, ServerContextKey, serverContext)
+	ctx = context.WithValue(ctx, connTrackerContextKey, s.connTracker)
 
 	return ctx
 }
@@ -74,43 +87,68 @@ type Server struct {
 	Handle         gmhttp.Handler
 	OnHandlerPanic func(writer gmhttp.ResponseWriter, request *gmhttp.Request, panicVal interface{})
 	ServerConfig   *ServerConfig
+	apiInstances   []*apiInstance
+	sloWatcher     *sloWatcher
+
+	handshakeLimiter *handshakeLimiter
 }
 
 // NewServer creates a new Server from a ServerConfig. All necessary http.Handler's will be created from the supplied
 // DemuxFactory and Registry.
 func NewServer(instance Instance, serverConfig *ServerConfig) (*Server, error) {
 	logWriter := pfxlog.Logger().Writer()
+	capabilities := resolveInstanceCapabilities(instance)
 
 	tlsConfig := serverConfig.Identity.ServerTLSConfig()
-	tlsConfig.ClientAuth = gmtls.RequestClientCert
 	tlsConfig.MinVersion = uint16(serverConfig.Options.MinTLSVersion)
 	tlsConfig.MaxVersion = uint16(serverConfig.Options.MaxTLSVersion)
+	serverConfig.Options.TlsProfileOptions.apply(tlsConfig)
+
+	var clientCaBundle *CaBundle
+	if serverConfig.Options.ClientCaBundle != "" {
+		var err error
+		if clientCaBundle, err = capabilities.getCaBundle(serverConfig.Options.ClientCaBundle); err != nil {
+			return nil, fmt.Errorf("error loading client CA bundle for server %s: %v", serverConfig.Name, err)
+		}
+	}
+	serverConfig.Options.ClientCaOptions.applyClientCas(tlsConfig, clientCaBundle, serverConfig.Identity.CA)
+
+	var limiter *handshakeLimiter
+	if serverConfig.Options.HandshakeLimitsEnabled {
+		limiter = newHandshakeLimiter(&serverConfig.Options.HandshakeLimitOptions, serverConfig.Name, capabilities.metrics, capabilities.events)
+		limiter.apply(tlsConfig)
+	}
 
 	server := &Server{
-		logWriter:    logWriter,
-		config:       &serverConfig,
-		httpServers:  []*namedHttpServer{},
-		ServerConfig: serverConfig,
+		logWriter:        logWriter,
+		config:           &serverConfig,
+		httpServers:      []*namedHttpServer{},
+		ServerConfig:     serverConfig,
+		handshakeLimiter: limiter,
 	}
 
 	server.SetParent(instance)
 
-	var handlers []ApiHandler
+	var apiInstances []*apiInstance
 	var apiBindingList []string
 
 	for _, api := range serverConfig.APIs {
 		if apiFactory := instance.GetRegistry().Get(api.Binding()); apiFactory != nil {
-			if handler, err := apiFactory.New(serverConfig, api.Options()); err != nil {
+			if handler, err := newApiHandler(apiFactory, serverConfig, api, capabilities); err != nil {
 				pfxlog.Logger().Fatalf("encountered error building handler for api binding [%s]: %v", api.Binding(), err)
 			} else {
-				handlers = append(handlers, handler)
-				apiBindingList = append(apiBindingList, api.binding)
+				apiInstances = append(apiInstances, newApiInstance(serverConfig, api, handler, capabilities.metrics))
+				apiBindingList = append(apiBindingList, api.Name())
 			}
 		} else {
 			pfxlog.Logger().Fatalf("encountered api binding [%s] which has no associated factory registered", api.Binding())
 		}
 	}
 
+	server.apiInstances = apiInstances
+	server.sloWatcher = newSloWatcher(apiInstances, capabilities.events)
+	handlers := balanceApiInstances(apiInstances, serverConfig.Options.BalanceStrategy)
+
 	demuxHandler, err := instance.GetDemuxFactory().Build(handlers)
 	demuxHandler.SetParent(server)
 
@@ -119,52 +157,104 @@ func NewServer(instance Instance, serverConfig *ServerConfig) (*Server, error) {
 	}
 
 	for _, bindPoint := range serverConfig.BindPoints {
+		timeouts := bindPoint.timeouts(&serverConfig.Options.TimeoutOptions)
+
 		namedServer := &namedHttpServer{
 			ApiBindingList:  apiBindingList,
 			ServerConfig:    serverConfig,
 			BindPointConfig: bindPoint,
 			InstanceConfig:  instance.GetConfig(),
+			FeatureFlags:    capabilities.featureFlags,
+			Services:        capabilities.services,
 			Server: &gmhttp.Server{
-				Addr:         bindPoint.InterfaceAddress,
-				WriteTimeout: serverConfig.Options.WriteTimeout,
-				ReadTimeout:  serverConfig.Options.ReadTimeout,
-				IdleTimeout:  serverConfig.Options.IdleTimeout,
-				Handler:      server.wrapHandler(serverConfig, bindPoint, demuxHandler),
-				TLSConfig:    tlsConfig,
-				ErrorLog:     log.New(logWriter, "", 0),
+				Addr:              bindPoint.InterfaceAddress,
+				WriteTimeout:      timeouts.WriteTimeout,
+				ReadTimeout:       timeouts.ReadTimeout,
+				ReadHeaderTimeout: bindPoint.ReadHeaderTimeout,
+				IdleTimeout:       timeouts.IdleTimeout,
+				MaxHeaderBytes:    bindPoint.MaxHeaderBytes,
+				Handler:           server.wrapHandler(capabilities, bindPoint, demuxHandler),
+				TLSConfig:         bindPoint.tlsConfig(tlsConfig),
+				ErrorLog:          log.New(logWriter, "", 0),
 			},
 		}
 
+		namedServer.connTracker = newConnTracker(serverConfig.Options.ConnectionReapOptions, capabilities.metrics, metrics.Labels{
+			"server":    serverConfig.Name,
+			"bindPoint": bindPoint.InterfaceAddress,
+		})
+		namedServer.ConnState = namedServer.connTracker.ConnState
+
+		//NewBaseContext has a value receiver, assign it once the connTracker is set
 		namedServer.BaseContext = namedServer.NewBaseContext
 
+		namedServer.ConnContext = newConnContext(serverConfig.Options.TlsFingerprints)
+
 		server.httpServers = append(server.httpServers, namedServer)
 	}
 
 	return server, nil
 }
 
-func (server *Server) wrapHandler(_ *ServerConfig, point *BindPointConfig, handler gmhttp.Handler) gmhttp.Handler {
+// newApiHandler creates an ApiHandler for an ApiConfig, providing the ApiConfig or normalized options to factories that
+// accept them. Adapted factory.Factory's are given the TaskRunner and Services of the instance, if it provides them.
+func newApiHandler(factory ApiHandlerFactory, serverConfig *ServerConfig, api *ApiConfig, capabilities *instanceCapabilities) (ApiHandler, error) {
+	if configFactory, ok := factory.(apiConfigHandlerFactory); ok {
+		return configFactory.newFromApiConfig(serverConfig, api, capabilities)
+	}
+	if optionsFactory, ok := factory.(ApiOptionsHandlerFactory); ok {
+		return optionsFactory.NewWithOptions(serverConfig, api.ApiOptions())
+	}
+	return factory.New(serverConfig, api.Options())
+}
+
+func (server *Server) wrapHandler(capabilities *instanceCapabilities, point *BindPointConfig, handler gmhttp.Handler) gmhttp.Handler {
 	//innermost/bottom -> outermost/top
+	handler = server.wrapAffinity(capabilities.metrics, handler)
+	handler = wrapAllowedMethods(point.AllowedMethods, handler)
+	handler = wrapResponseHeaders(point.ResponseHeaders, handler)
 	handler = server.wrapSetCtrlAddressHeader(point, handler)
 	handler = server.wrapPanicRecovery(handler)
-	handler = middleware.NewCompressionHandler(handler)
+	handler = middleware.NewCompressionHandlerWithOptions(&middleware.CompressionOptions{
+		ExcludedContentTypes: server.ServerConfig.Options.CompressionExcludedContentTypes,
+	}, handler)
+	handler = server.wrapRequestLogger(point, handler)
+	handler = server.wrapAccessLog(point, capabilities, handler)
 	return handler
 }
 
-// wrapPanicRecovery wraps a http.Handler with another http.Handler that provides recovery.
+// wrapPanicRecovery wraps a http.Handler with another http.Handler that provides recovery. An error response is only
+// written if the handler had not written anything yet, otherwise the response is aborted with http.ErrAbortHandler.
 func (server *Server) wrapPanicRecovery(handler gmhttp.Handler) gmhttp.Handler {
 	wrappedHandler := gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		statusWriter := acquireStatusResponseWriter(writer)
+		statusWriter.tracker = connTrackerFromContext(request.Context())
+		defer releaseStatusResponseWriter(statusWriter)
+
 		defer func() {
 			if panicVal := recover(); panicVal != nil {
+				if panicVal == gmhttp.ErrAbortHandler {
+					panic(panicVal)
+				}
+
 				if server.OnHandlerPanic != nil {
 					server.OnHandlerPanic(writer, request, panicVal)
 					return
 				}
-				pfxlog.Logger().Errorf("panic caught by server handler: %v\n%v", panicVal, debugz.GenerateLocalStack())
+
+				id := newErrorId()
+				stack := debugz.GenerateLocalStack()
+				LoggerFromContext(request.Context()).WithField("errorId", id).Errorf("panic caught by server handler: %v\n%v", panicVal, stack)
+
+				if statusWriter.Status() != 0 || statusWriter.hijacked {
+					panic(gmhttp.ErrAbortHandler)
+				}
+
+				writeErrorResponse(writer, request, gmhttp.StatusInternalServerError, id, fmt.Errorf("panic: %v", panicVal), nil, stack)
 			}
 		}()
 
-		handler.ServeHTTP(writer, request)
+		handler.ServeHTTP(statusWriter, request)
 	})
 
 	return wrappedHandler
@@ -188,38 +278,203 @@ func (server *Server) wrapSetCtrlAddressHeader(point *BindPointConfig, handler g
 	return wrappedHandler
 }
 
-// Start the server and all underlying http.Server's
+// getApiInstance returns the apiInstance with the supplied instance name or nil
+func (server *Server) getApiInstance(name string) *apiInstance {
+	for _, instance := range server.apiInstances {
+		if instance.Name() == name {
+			return instance
+		}
+	}
+	return nil
+}
+
+// Start the server and all underlying http.Server's. All bind points are listened on before any is served, bind points
+// are then served concurrently and Start blocks until all of them have stopped.
 func (server *Server) Start() error {
+	if err := server.Listen(); err != nil {
+		return err
+	}
+
+	return server.Serve()
+}
+
+// Listen opens listeners for all bind points without serving requests. Any listeners opened are closed if a bind point
+// fails to listen.
+func (server *Server) Listen() error {
 	logger := pfxlog.Logger()
 
 	for _, httpServer := range server.httpServers {
+		if len(httpServer.listeners) > 0 {
+			continue
+		}
+
 		logger.Infof("starting ApiConfig to listen and serve tls on %s for server %s with APIs: %v", httpServer.Addr, httpServer.ServerConfig.Name, httpServer.ApiBindingList)
 
 		cfg := httpServer.TLSConfig
 		// make sure to listen to the expected protocols
 		cfg.NextProtos = append(cfg.NextProtos, "h2", "http/1.1", "")
+
+		acceptLoops := httpServer.ServerConfig.Options.AcceptLoops
+		if acceptLoops > 1 && !reusePortSupported {
+			logger.Warnf("SO_REUSEPORT is not supported on %s, serving %s with a single accept loop instead of %d", runtime.GOOS, httpServer.Addr, acceptLoops)
+			acceptLoops = 1
+		}
+
+		//fingerprints are read from the connections of sockets the server binds itself
+		fingerprints := httpServer.ServerConfig.Options.TlsFingerprints
+
+		if acceptLoops > 1 || fingerprints {
+			sockets, err := listenAcceptLoops(httpServer.Addr, acceptLoops)
+			if err != nil {
+				server.closeListeners()
+				return fmt.Errorf("error listening on %s with %d accept loops: %s", httpServer.Addr, acceptLoops, err)
+			}
+
+			for _, socket := range sockets {
+				httpServer.listeners = append(httpServer.listeners, newTlsListener(socket, cfg, fingerprints))
+			}
+			continue
+		}
+
 		l, err := transporttls.ListenTLS(httpServer.Addr, httpServer.ServerConfig.Name, cfg)
 		if err != nil {
-			return fmt.Errorf("error listening: %s", err)
+			server.closeListeners()
+			return fmt.Errorf("error listening on %s: %s", httpServer.Addr, err)
 		}
-		err = httpServer.Serve(l)
 
-		if !errors.Is(err, gmhttp.ErrServerClosed) {
-			return fmt.Errorf("error listening: %s", err)
-		}
+		httpServer.listeners = []net.Listener{l}
 	}
 
 	return nil
 }
 
-// Shutdown stops the server and all underlying http.Server's
-func (server *Server) Shutdown(ctx context.Context) {
-	_ = server.logWriter.Close()
+// Serve serves requests on all bind points that Listen has opened listeners for and blocks until all have stopped.
+// Each listener is served by its own accept loop, see AcceptLoopOptions. A bind point failing to serve does not stop
+// the others. The first error other than http.ErrServerClosed is returned.
+func (server *Server) Serve() error {
+	server.sloWatcher.Start()
 
+	serving := 0
 	for _, httpServer := range server.httpServers {
+		serving += len(httpServer.listeners)
+	}
+
+	errs := make(chan error, serving)
+
+	for _, httpServer := range server.httpServers {
+		if len(httpServer.listeners) == 0 {
+			continue
+		}
+
 		localServer := httpServer
-		func() {
-			_ = localServer.Shutdown(ctx)
+		localServer.connTracker.Start()
+
+		for _, listener := range localServer.listeners {
+			localListener := listener
+			go func() {
+				err := localServer.Serve(localListener)
+
+				if errors.Is(err, gmhttp.ErrServerClosed) {
+					err = nil
+				} else if err != nil {
+					err = fmt.Errorf("error serving on %s: %s", localServer.Addr, err)
+				}
+
+				errs <- err
+			}()
+		}
+	}
+
+	var result error
+	for i := 0; i < serving; i++ {
+		if err := <-errs; err != nil && result == nil {
+			result = err
+		}
+	}
+
+	return result
+}
+
+func (server *Server) closeListeners() {
+	for _, httpServer := range server.httpServers {
+		for _, listener := range httpServer.listeners {
+			_ = listener.Close()
+		}
+		httpServer.listeners = nil
+	}
+}
+
+// Shutdown stops the server and all underlying http.Server's. Connections still busy when ctx is done are closed.
+// Handlers that hold resources are stopped once serving has stopped.
+func (server *Server) Shutdown(ctx context.Context) *ServerShutdownReport {
+	start := time.Now()
+	report := &ServerShutdownReport{
+		Server: server.ServerConfig.Name,
+	}
+
+	_ = server.logWriter.Close()
+	server.sloWatcher.Stop()
+
+	//bind points drain concurrently so that a slow bind point does not use up the drain timeout of the others
+	report.BindPoints = make([]*BindPointShutdownReport, len(server.httpServers))
+	shutdownGroup := &sync.WaitGroup{}
+	for idx, httpServer := range server.httpServers {
+		localIdx, localServer := idx, httpServer
+		shutdownGroup.Add(1)
+		go func() {
+			defer shutdownGroup.Done()
+			report.BindPoints[localIdx] = localServer.shutdown(ctx)
 		}()
 	}
+	shutdownGroup.Wait()
+
+	for _, instance := range server.apiInstances {
+		if stopped, err := stopApiHandler(ctx, instance.ApiHandler); stopped {
+			apiReport := &ApiShutdownReport{
+				Name:    instance.Name(),
+				Binding: instance.Binding(),
+			}
+			if err != nil {
+				apiReport.Error = err.Error()
+				pfxlog.Logger().Errorf("error stopping api [%s] on server [%s]: %v", instance.Name(), server.ServerConfig.Name, err)
+			}
+			report.Apis = append(report.Apis, apiReport)
+		}
+	}
+
+	if server.handshakeLimiter != nil {
+		if err := server.handshakeLimiter.close(); err != nil {
+			pfxlog.Logger().Errorf("error closing handshake limit backend on server [%s]: %v", server.ServerConfig.Name, err)
+		}
+	}
+
+	report.Duration = time.Since(start)
+	return report
+}
+
+// shutdown gracefully shuts down the http.Server, closing connections that are still busy once ctx is done
+func (s *namedHttpServer) shutdown(ctx context.Context) *BindPointShutdownReport {
+	start := time.Now()
+	report := &BindPointShutdownReport{
+		Address: s.Addr,
+	}
+
+	s.connTracker.Stop()
+	active := s.connTracker.countActive()
+
+	if err := s.Shutdown(ctx); err != nil {
+		report.Error = err.Error()
+		report.ForcedClosed = s.connTracker.closeBusy()
+		_ = s.Close()
+	}
+
+	//http.Server.Shutdown neither waits for nor closes hijacked connections
+	report.HijackedClosed = s.connTracker.closeHijacked()
+
+	if report.Drained = active - report.ForcedClosed; report.Drained < 0 {
+		report.Drained = 0
+	}
+
+	report.Duration = time.Since(start)
+	return report
 }
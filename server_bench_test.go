@@ -0,0 +1,48 @@
+package xweb
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/openziti/xweb/v2/metrics"
+	"testing"
+)
+
+// Benchmark_wrapHandler measures the per request cost of the Server middleware layers, demux and apiInstance wrapping,
+// excluding the ApiHandler itself
+func Benchmark_wrapHandler(b *testing.B) {
+	instance := NewDefaultInstance(NewRegistryMap(), nil)
+	serverConfig := &ServerConfig{Name: "bench"}
+	point := &BindPointConfig{InterfaceAddress: "0.0.0.0:443"}
+	server := &Server{ServerConfig: serverConfig}
+
+	var apis []ApiHandler
+	for _, rootPath := range []string{"/edge/management/", "/edge/client/", "/fabric/", "/"} {
+		apiHandler := &benchApiHandler{testMethodApiHandler{rootPath: rootPath}}
+		apis = append(apis, newApiInstance(serverConfig, &ApiConfig{binding: rootPath, name: rootPath}, apiHandler, metrics.NewRegistry()))
+	}
+
+	demux, err := (&PathPrefixDemuxFactory{}).Build(apis)
+	if err != nil {
+		b.Fatal(err)
+	}
+	handler := server.wrapHandler(resolveInstanceCapabilities(instance), point, demux)
+
+	request := gmhttptest.NewRequest("GET", "/fabric/things", nil)
+	writer := gmhttptest.NewRecorder()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(writer, request)
+	}
+}
+
+// benchApiHandler does not record served requests
+type benchApiHandler struct {
+	testMethodApiHandler
+}
+
+func (handler *benchApiHandler) ServeHTTP(writer gmhttp.ResponseWriter, _ *gmhttp.Request) {
+	writer.WriteHeader(gmhttp.StatusOK)
+}
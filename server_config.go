@@ -75,6 +75,13 @@ func (config *ServerConfig) Parse(configMap map[interface{}]interface{}, pathCon
 		if addressesArrayInterfaces, ok := addressInterface.([]interface{}); ok {
 			for i, addressInterface := range addressesArrayInterfaces {
 				if addressMap, ok := addressInterface.(map[interface{}]interface{}); ok {
+					if isBindPointTemplate(addressMap) {
+						if err := config.parseBindPointTemplate(addressMap); err != nil {
+							return fmt.Errorf("error parsing address configuration at index [%d]: %v", i, err)
+						}
+						continue
+					}
+
 					address := &BindPointConfig{}
 					if err := address.Parse(addressMap); err != nil {
 						return fmt.Errorf("error parsing address configuration at index [%d]: %v", i, err)
@@ -125,6 +132,30 @@ func (config *ServerConfig) Parse(configMap map[interface{}]interface{}, pathCon
 	return nil
 }
 
+// parseBindPointTemplate expands a bind point template and parses each of the bind points it generates
+func (config *ServerConfig) parseBindPointTemplate(templateMap map[interface{}]interface{}) error {
+	template, err := parseBindPointTemplate(templateMap)
+	if err != nil {
+		return err
+	}
+
+	addressMaps, err := template.Expand()
+	if err != nil {
+		return err
+	}
+
+	for i, addressMap := range addressMaps {
+		address := &BindPointConfig{}
+		if err := address.Parse(addressMap); err != nil {
+			return fmt.Errorf("error parsing generated bind point [%d]: %v", i, err)
+		}
+
+		config.BindPoints = append(config.BindPoints, address)
+	}
+
+	return nil
+}
+
 // Validate all ServerConfig values
 func (config *ServerConfig) Validate(registry Registry) error {
 	if config.Name == "" {
@@ -135,11 +166,18 @@ func (config *ServerConfig) Validate(registry Registry) error {
 		return errors.New("no APIs specified, must specify at least one")
 	}
 
+	apiNames := map[string]int{}
+
 	for i, api := range config.APIs {
 		if err := api.Validate(); err != nil {
 			return fmt.Errorf("invalid ApiConfig at index [%d]: %v", i, err)
 		}
 
+		if existingIndex, ok := apiNames[api.Name()]; ok {
+			return fmt.Errorf("invalid ApiConfig at index [%d]: name [%s] already used at index [%d], bindings declared more than once must specify distinct names", i, api.Name(), existingIndex)
+		}
+		apiNames[api.Name()] = i
+
 		//check if binding is valid
 		if binding := registry.Get(api.Binding()); binding == nil {
 			return fmt.Errorf("invalid ApiConfig at index [%d]: invalid binding %s", i, api.Binding())
@@ -168,10 +206,56 @@ func (config *ServerConfig) Validate(registry Registry) error {
 		return fmt.Errorf("invalid TLS version option: %v", err)
 	}
 
+	for i, bindPoint := range config.BindPoints {
+		if bindPoint.MinTLSVersion > config.Options.MaxTLSVersion {
+			return fmt.Errorf("invalid address at index [%d]: minTLSVersion [%s] must be less than or equal to maxTLSVersion [%s]", i, ReverseTlsVersionMap[bindPoint.MinTLSVersion], ReverseTlsVersionMap[config.Options.MaxTLSVersion])
+		}
+	}
+
+	if err := config.Options.TlsProfileOptions.Validate(&config.Options.TlsVersionOptions, config.Identity); err != nil {
+		return fmt.Errorf("invalid TLS profile option: %v", err)
+	}
+
+	if err := config.Options.ClientCaOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid client CA option: %v", err)
+	}
+
 	if err := config.Options.TimeoutOptions.Validate(); err != nil {
 		return fmt.Errorf("invalid timeout option: %v", err)
 	}
 
+	if err := config.Options.BalanceOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid balance option: %v", err)
+	}
+
+	if err := config.Options.AffinityOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid affinity option: %v", err)
+	}
+
+	if err := config.Options.ConnectionReapOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid connection reap option: %v", err)
+	}
+
+	if err := config.Options.AcceptLoopOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid accept loop option: %v", err)
+	}
+
+	if err := config.Options.HandshakeLimitOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid handshake limit option: %v", err)
+	}
+
+	if err := config.Options.AccessLogOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid access log option: %v", err)
+	}
+
+	if err := config.Options.CompressionOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid compression option: %v", err)
+	}
+
+	if err := config.Options.ShutdownOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid shutdown option: %v", err)
+	}
+
 	return nil
 
 }
@@ -0,0 +1,116 @@
+package xweb
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/openziti/xweb/v2/metrics"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_Server_Serve(t *testing.T) {
+	t.Run("all bind points are served concurrently", func(t *testing.T) {
+		req := require.New(t)
+		server := &Server{sloWatcher: newSloWatcher(nil, nil)}
+
+		var addresses []string
+		for i := 0; i < 2; i++ {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			req.NoError(err)
+
+			httpServer := &namedHttpServer{
+				Server: &gmhttp.Server{
+					Addr: listener.Addr().String(),
+					Handler: gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, _ *gmhttp.Request) {
+						_, _ = writer.Write([]byte("ok"))
+					}),
+				},
+				connTracker: newConnTracker(ConnectionReapOptions{}, metrics.NewRegistry(), metrics.Labels{}),
+				listeners:   []net.Listener{listener},
+			}
+			server.httpServers = append(server.httpServers, httpServer)
+			addresses = append(addresses, listener.Addr().String())
+		}
+
+		served := make(chan error, 1)
+		go func() {
+			served <- server.Serve()
+		}()
+
+		client := &gmhttp.Client{Timeout: 5 * time.Second}
+		for _, address := range addresses {
+			response, err := client.Get("http://" + address + "/")
+			req.NoError(err)
+			body, _ := io.ReadAll(response.Body)
+			_ = response.Body.Close()
+			req.Equal("ok", string(body))
+		}
+
+		for _, httpServer := range server.httpServers {
+			req.NoError(httpServer.Close())
+		}
+
+		select {
+		case err := <-served:
+			req.NoError(err)
+		case <-time.After(5 * time.Second):
+			req.Fail("serve did not return after all bind points closed")
+		}
+	})
+}
+
+func Test_Server_wrapPanicRecovery(t *testing.T) {
+	server := &Server{}
+
+	t.Run("panics before anything is written are answered with 500", func(t *testing.T) {
+		req := require.New(t)
+		handler := server.wrapPanicRecovery(gmhttp.HandlerFunc(func(gmhttp.ResponseWriter, *gmhttp.Request) {
+			panic("boom")
+		}))
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/things", nil))
+
+		req.Equal(gmhttp.StatusInternalServerError, recorder.Code)
+		req.Equal("application/json", recorder.Header().Get("Content-Type"))
+	})
+
+	t.Run("panics after the response was started abort the response", func(t *testing.T) {
+		req := require.New(t)
+		handler := server.wrapPanicRecovery(gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, _ *gmhttp.Request) {
+			_, _ = writer.Write([]byte("partial"))
+			panic("boom")
+		}))
+
+		recorder := gmhttptest.NewRecorder()
+		req.PanicsWithValue(gmhttp.ErrAbortHandler, func() {
+			handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/things", nil))
+		})
+
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal("partial", recorder.Body.String())
+	})
+
+	t.Run("aborted handlers are not recovered", func(t *testing.T) {
+		req := require.New(t)
+		handler := server.wrapPanicRecovery(gmhttp.HandlerFunc(func(gmhttp.ResponseWriter, *gmhttp.Request) {
+			panic(gmhttp.ErrAbortHandler)
+		}))
+
+		req.PanicsWithValue(gmhttp.ErrAbortHandler, func() {
+			handler.ServeHTTP(gmhttptest.NewRecorder(), gmhttptest.NewRequest("GET", "/things", nil))
+		})
+	})
+}
+
+func Test_handler404(t *testing.T) {
+	req := require.New(t)
+	recorder := gmhttptest.NewRecorder()
+	handler404(recorder, gmhttptest.NewRequest("GET", "/things", nil))
+
+	req.Equal(gmhttp.StatusNotFound, recorder.Code)
+	req.Empty(recorder.Body.String())
+}
@@ -0,0 +1,169 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"github.com/michaelquigley/pfxlog"
+	"net"
+	"time"
+)
+
+const (
+	DefaultServiceRegistrationTimeout = 10 * time.Second
+)
+
+// ServiceRegistration describes a listening bind point to a ServiceRegistrar
+type ServiceRegistration struct {
+	// Server is the name of the ServerConfig the bind point belongs to
+	Server string
+
+	// InterfaceAddress is the configured interface address of the bind point
+	InterfaceAddress string
+
+	// ListenAddress is the address the bind point is listening on, with any port 0 resolved to the assigned port
+	ListenAddress string
+
+	// AdvertiseAddress is the address clients should use to reach the bind point. A port of 0 is resolved to the
+	// port of the ListenAddress.
+	AdvertiseAddress string
+
+	// Apis are the names of the APIs served on the bind point
+	Apis []string
+}
+
+// ServiceRegistrar registers bind points with external service discovery (i.e. Consul, etcd or DNS-SD).
+// InstanceImpl.Start calls Register in the background for each bind point once it is serving. Deregister is called
+// by InstanceImpl.Shutdown before the bind point stops serving, or once it fails to serve. Errors are logged and do not
+// affect serving.
+type ServiceRegistrar interface {
+	Register(ctx context.Context, registration *ServiceRegistration) error
+	Deregister(ctx context.Context, registration *ServiceRegistration) error
+}
+
+// serviceRegistrations returns a ServiceRegistration for every bind point of the server that is listening
+func (server *Server) serviceRegistrations() []*ServiceRegistration {
+	var result []*ServiceRegistration
+
+	for _, httpServer := range server.httpServers {
+		if len(httpServer.listeners) == 0 {
+			continue
+		}
+
+		listenAddress := httpServer.listeners[0].Addr().String()
+
+		result = append(result, &ServiceRegistration{
+			Server:           httpServer.ServerConfig.Name,
+			InterfaceAddress: httpServer.BindPointConfig.InterfaceAddress,
+			ListenAddress:    listenAddress,
+			AdvertiseAddress: resolveAdvertiseAddress(httpServer.BindPointConfig.Address, listenAddress),
+			Apis:             httpServer.ApiBindingList,
+		})
+	}
+
+	return result
+}
+
+// resolveAdvertiseAddress replaces a port of 0 in the advertise address with the port of the listen address
+func resolveAdvertiseAddress(advertiseAddress, listenAddress string) string {
+	host, port, err := net.SplitHostPort(advertiseAddress)
+	if err != nil || port != "0" {
+		return advertiseAddress
+	}
+
+	if _, listenPort, err := net.SplitHostPort(listenAddress); err == nil {
+		return net.JoinHostPort(host, listenPort)
+	}
+
+	return advertiseAddress
+}
+
+// startServing records servers as serving and not yet registered
+func (i *InstanceImpl) startServing(servers []*Server) {
+	i.servingLock.Lock()
+	defer i.servingLock.Unlock()
+
+	if i.serving == nil {
+		i.serving = map[*Server]bool{}
+	}
+
+	for _, server := range servers {
+		i.serving[server] = false
+	}
+}
+
+// registerServing registers the bind points of a server with the ServiceRegistrars, unless it has stopped serving
+// or is already registered
+func (i *InstanceImpl) registerServing(server *Server) {
+	i.servingLock.Lock()
+	defer i.servingLock.Unlock()
+
+	if registered, ok := i.serving[server]; !ok || registered {
+		return
+	}
+
+	i.updateServiceRegistrations([]*Server{server}, true)
+	i.serving[server] = true
+}
+
+// stopServing records that a server has stopped serving and deregisters its bind points if they were registered
+func (i *InstanceImpl) stopServing(server *Server) {
+	i.servingLock.Lock()
+	defer i.servingLock.Unlock()
+
+	registered, ok := i.serving[server]
+	if !ok {
+		return
+	}
+
+	delete(i.serving, server)
+
+	if registered {
+		i.updateServiceRegistrations([]*Server{server}, false)
+	}
+}
+
+// updateServiceRegistrations calls Register or Deregister on every ServiceRegistrar for the listening bind points of
+// servers
+func (i *InstanceImpl) updateServiceRegistrations(servers []*Server, register bool) {
+	if len(i.ServiceRegistrars) == 0 {
+		return
+	}
+
+	for _, server := range servers {
+		for _, registration := range server.serviceRegistrations() {
+			for _, registrar := range i.ServiceRegistrars {
+				ctx, cancel := context.WithTimeout(context.Background(), DefaultServiceRegistrationTimeout)
+
+				var err error
+				operation := "register"
+				if register {
+					err = registrar.Register(ctx, registration)
+				} else {
+					operation = "deregister"
+					err = registrar.Deregister(ctx, registration)
+				}
+				cancel()
+
+				if err != nil {
+					pfxlog.Logger().WithField("server", registration.Server).WithField("address", registration.AdvertiseAddress).
+						Errorf("could not %s xweb bind point with service registrar: %v", operation, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package xweb
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+type testServiceRegistrar struct {
+	registered   []*ServiceRegistration
+	deregistered []*ServiceRegistration
+	err          error
+}
+
+func (registrar *testServiceRegistrar) Register(_ context.Context, registration *ServiceRegistration) error {
+	registrar.registered = append(registrar.registered, registration)
+	return registrar.err
+}
+
+func (registrar *testServiceRegistrar) Deregister(_ context.Context, registration *ServiceRegistration) error {
+	registrar.deregistered = append(registrar.deregistered, registration)
+	return registrar.err
+}
+
+func TestServiceRegistration(t *testing.T) {
+	newListeningServer := func(req *require.Assertions, advertiseAddress string) (*Server, net.Listener) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+
+		serverConfig := &ServerConfig{Name: "test-server"}
+		return &Server{
+			ServerConfig: serverConfig,
+			httpServers: []*namedHttpServer{
+				{
+					ApiBindingList:  []string{"edge"},
+					ServerConfig:    serverConfig,
+					BindPointConfig: &BindPointConfig{InterfaceAddress: "127.0.0.1:0", Address: advertiseAddress},
+					listeners:       []net.Listener{listener},
+				},
+				{
+					ServerConfig:    serverConfig,
+					BindPointConfig: &BindPointConfig{InterfaceAddress: "127.0.0.1:1281", Address: "127.0.0.1:1281"},
+				},
+			},
+		}, listener
+	}
+
+	t.Run("listening bind points are registered and deregistered", func(t *testing.T) {
+		req := require.New(t)
+		server, listener := newListeningServer(req, "edge.example.com:0")
+		defer func() { _ = listener.Close() }()
+
+		_, port, err := net.SplitHostPort(listener.Addr().String())
+		req.NoError(err)
+
+		registrar := &testServiceRegistrar{}
+		instance := &InstanceImpl{}
+		instance.AddServiceRegistrar(registrar)
+
+		instance.updateServiceRegistrations([]*Server{server}, true)
+		req.Len(registrar.registered, 1)
+		req.Equal(&ServiceRegistration{
+			Server:           "test-server",
+			InterfaceAddress: "127.0.0.1:0",
+			ListenAddress:    listener.Addr().String(),
+			AdvertiseAddress: "edge.example.com:" + port,
+			Apis:             []string{"edge"},
+		}, registrar.registered[0])
+
+		instance.updateServiceRegistrations([]*Server{server}, false)
+		req.Equal(registrar.registered, registrar.deregistered)
+	})
+
+	t.Run("registrar errors do not stop other registrars", func(t *testing.T) {
+		req := require.New(t)
+		server, listener := newListeningServer(req, "edge.example.com:443")
+		defer func() { _ = listener.Close() }()
+
+		failing := &testServiceRegistrar{err: errors.New("unavailable")}
+		working := &testServiceRegistrar{}
+		instance := &InstanceImpl{}
+		instance.AddServiceRegistrar(failing)
+		instance.AddServiceRegistrar(working)
+
+		instance.updateServiceRegistrations([]*Server{server}, true)
+		req.Len(failing.registered, 1)
+		req.Len(working.registered, 1)
+		req.Equal("edge.example.com:443", working.registered[0].AdvertiseAddress)
+	})
+
+	t.Run("servers that stop serving are deregistered", func(t *testing.T) {
+		req := require.New(t)
+		server, listener := newListeningServer(req, "edge.example.com:443")
+		defer func() { _ = listener.Close() }()
+
+		registrar := &testServiceRegistrar{}
+		instance := &InstanceImpl{}
+		instance.AddServiceRegistrar(registrar)
+
+		instance.startServing([]*Server{server})
+		instance.registerServing(server)
+		instance.registerServing(server)
+		req.Len(registrar.registered, 1)
+
+		instance.stopServing(server)
+		instance.stopServing(server)
+		req.Equal(registrar.registered, registrar.deregistered)
+	})
+
+	t.Run("servers that stop serving before registering are not registered", func(t *testing.T) {
+		req := require.New(t)
+		server, listener := newListeningServer(req, "edge.example.com:443")
+		defer func() { _ = listener.Close() }()
+
+		registrar := &testServiceRegistrar{}
+		instance := &InstanceImpl{}
+		instance.AddServiceRegistrar(registrar)
+
+		instance.startServing([]*Server{server})
+		instance.stopServing(server)
+		instance.registerServing(server)
+		req.Empty(registrar.registered)
+		req.Empty(registrar.deregistered)
+	})
+
+	t.Run("servers are deregistered after a registration in progress completes", func(t *testing.T) {
+		req := require.New(t)
+		server, listener := newListeningServer(req, "edge.example.com:443")
+		defer func() { _ = listener.Close() }()
+
+		registrar := &blockingServiceRegistrar{
+			registering:  make(chan struct{}),
+			release:      make(chan struct{}),
+			deregistered: make(chan struct{}),
+		}
+		instance := &InstanceImpl{}
+		instance.AddServiceRegistrar(registrar)
+
+		instance.startServing([]*Server{server})
+		go instance.registerServing(server)
+		<-registrar.registering
+
+		stopped := make(chan struct{})
+		go func() {
+			instance.stopServing(server)
+			close(stopped)
+		}()
+
+		select {
+		case <-registrar.deregistered:
+			req.Fail("deregistered while registering")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(registrar.release)
+		<-stopped
+		<-registrar.deregistered
+	})
+}
+
+// blockingServiceRegistrar is a ServiceRegistrar whose Register blocks until release is closed
+type blockingServiceRegistrar struct {
+	registering  chan struct{}
+	release      chan struct{}
+	deregistered chan struct{}
+}
+
+func (registrar *blockingServiceRegistrar) Register(context.Context, *ServiceRegistration) error {
+	close(registrar.registering)
+	<-registrar.release
+	return nil
+}
+
+func (registrar *blockingServiceRegistrar) Deregister(context.Context, *ServiceRegistration) error {
+	close(registrar.deregistered)
+	return nil
+}
@@ -0,0 +1,192 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/openziti/foundation/v2/debugz"
+	"github.com/openziti/xweb/v2/factory"
+	"time"
+)
+
+const (
+	EventTypeShutdownCompleted = "xweb.shutdown.completed"
+
+	DefaultDrainTimeout = 15 * time.Second
+)
+
+// ShutdownOptions represents options for shutting down a Server
+type ShutdownOptions struct {
+	// DrainTimeout is how long a Server waits for in flight requests to complete on shutdown before busy connections
+	// are forced closed
+	DrainTimeout time.Duration
+}
+
+// Default defaults the drain timeout to DefaultDrainTimeout
+func (shutdownOptions *ShutdownOptions) Default() {
+	shutdownOptions.DrainTimeout = DefaultDrainTimeout
+}
+
+// Parse parses a config map
+func (shutdownOptions *ShutdownOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["drainTimeout"]; ok {
+		if timeoutStr, ok := interfaceVal.(string); ok {
+			if timeout, err := time.ParseDuration(timeoutStr); err == nil {
+				shutdownOptions.DrainTimeout = timeout
+			} else {
+				return fmt.Errorf("could not parse drainTimeout %s as a duration (e.g. 15s): %v", timeoutStr, err)
+			}
+		} else {
+			return errors.New("could not use value for drainTimeout, not a string")
+		}
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (shutdownOptions *ShutdownOptions) Validate() error {
+	if shutdownOptions.DrainTimeout <= 0 {
+		return fmt.Errorf("value [%s] for drainTimeout too low, must be positive", shutdownOptions.DrainTimeout.String())
+	}
+
+	return nil
+}
+
+// ShutdownReport describes how an Instance shut down, so that operators can verify that shutdowns are clean and tune
+// drain timeouts
+type ShutdownReport struct {
+	Started  time.Time               `json:"started"`
+	Duration time.Duration           `json:"duration"`
+	Servers  []*ServerShutdownReport `json:"servers"`
+}
+
+// ServerShutdownReport describes how a Server shut down
+type ServerShutdownReport struct {
+	Server     string                     `json:"server"`
+	Duration   time.Duration              `json:"duration"`
+	BindPoints []*BindPointShutdownReport `json:"bindPoints"`
+	Apis       []*ApiShutdownReport       `json:"apis,omitempty"`
+}
+
+// BindPointShutdownReport describes how a bind point stopped serving. Drained connections had a request in flight when
+// shutdown began and finished it before the drain timeout, forced closed connections were still busy and were closed.
+// Idle connections are closed without being counted. Hijacked
+// connections (i.e. websockets) are not drained by the http.Server and are always closed once draining completes.
+type BindPointShutdownReport struct {
+	Address        string        `json:"address"`
+	Drained        int           `json:"drained"`
+	ForcedClosed   int           `json:"forcedClosed"`
+	HijackedClosed int           `json:"hijackedClosed"`
+	Duration       time.Duration `json:"duration"`
+	Error          string        `json:"error,omitempty"`
+}
+
+// ApiShutdownReport describes the outcome of stopping an ApiHandler that is a StoppableApiHandler or was created by a
+// factory.Factory whose WebHandler is a factory.Stopper
+type ApiShutdownReport struct {
+	Name    string `json:"name"`
+	Binding string `json:"binding"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Clean returns true if no connections were forced closed and all bind points and handlers stopped without errors
+func (report *ShutdownReport) Clean() bool {
+	for _, server := range report.Servers {
+		for _, bindPoint := range server.BindPoints {
+			if bindPoint.ForcedClosed > 0 || bindPoint.Error != "" {
+				return false
+			}
+		}
+
+		for _, api := range server.Apis {
+			if api.Error != "" {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// String returns a one line summary of the report for logging
+func (report *ShutdownReport) String() string {
+	drained, forcedClosed, hijackedClosed, errCount := 0, 0, 0, 0
+	for _, server := range report.Servers {
+		for _, bindPoint := range server.BindPoints {
+			drained += bindPoint.Drained
+			forcedClosed += bindPoint.ForcedClosed
+			hijackedClosed += bindPoint.HijackedClosed
+			if bindPoint.Error != "" {
+				errCount++
+			}
+		}
+
+		for _, api := range server.Apis {
+			if api.Error != "" {
+				errCount++
+			}
+		}
+	}
+
+	return fmt.Sprintf("%d servers shut down in %s, %d connections drained, %d forced closed, %d hijacked closed, %d errors", len(report.Servers), report.Duration, drained, forcedClosed, hijackedClosed, errCount)
+}
+
+// ShutdownCompletedEvent is dispatched once all servers of an Instance have shut down
+type ShutdownCompletedEvent struct {
+	Report *ShutdownReport `json:"report"`
+}
+
+func (event *ShutdownCompletedEvent) EventType() string {
+	return EventTypeShutdownCompleted
+}
+
+// StoppableApiHandler is an ApiHandler that holds resources which must be released when its Server shuts down. Stop is
+// called once the Server has stopped serving requests, errors are reported in the ShutdownReport.
+type StoppableApiHandler interface {
+	ApiHandler
+	Stop(ctx context.Context) error
+}
+
+// stopApiHandler stops handler if it is a StoppableApiHandler or adapts a factory.Stopper, returning false if it is
+// neither
+func stopApiHandler(ctx context.Context, handler ApiHandler) (stopped bool, err error) {
+	var stop func(ctx context.Context) error
+
+	if stoppable, ok := handler.(StoppableApiHandler); ok {
+		stop = stoppable.Stop
+	} else if adapter, ok := handler.(*webHandlerAdapter); ok {
+		if stopper, ok := adapter.WebHandler.(factory.Stopper); ok {
+			stop = stopper.Stop
+		}
+	}
+
+	if stop == nil {
+		return false, nil
+	}
+
+	defer func() {
+		if panicVal := recover(); panicVal != nil {
+			err = fmt.Errorf("panic while stopping: %v\n%v", panicVal, debugz.GenerateLocalStack())
+		}
+	}()
+
+	stopped = true
+	return stopped, stop(ctx)
+}
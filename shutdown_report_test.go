@@ -0,0 +1,353 @@
+package xweb
+
+import (
+	"context"
+	"errors"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/openziti/xweb/v2/factory"
+	"github.com/openziti/xweb/v2/metrics"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type stoppingWebHandler struct {
+	stopErr error
+	panics  bool
+	stopped bool
+}
+
+func (handler *stoppingWebHandler) ServeHTTP(gmhttp.ResponseWriter, *gmhttp.Request) {}
+
+func (handler *stoppingWebHandler) RootPath() string {
+	return "/"
+}
+
+func (handler *stoppingWebHandler) IsHandler(*gmhttp.Request) bool {
+	return true
+}
+
+func (handler *stoppingWebHandler) Stop(context.Context) error {
+	handler.stopped = true
+	if handler.panics {
+		panic("stop failed")
+	}
+	return handler.stopErr
+}
+
+var _ factory.Stopper = &stoppingWebHandler{}
+
+func newShutdownTestServer(req *require.Assertions, handler gmhttp.Handler) (*namedHttpServer, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+
+	server := &namedHttpServer{
+		Server: &gmhttp.Server{
+			Addr:    listener.Addr().String(),
+			Handler: handler,
+		},
+		connTracker: newConnTracker(ConnectionReapOptions{}, metrics.NewRegistry(), metrics.Labels{}),
+	}
+	server.ConnState = server.connTracker.ConnState
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	return server, listener.Addr().String()
+}
+
+// sendShutdownTestRequest writes a request on a new connection without waiting for the response
+func sendShutdownTestRequest(req *require.Assertions, address string) net.Conn {
+	conn, err := net.Dial("tcp", address)
+	req.NoError(err)
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n"))
+	req.NoError(err)
+	return conn
+}
+
+func TestBindPointShutdownReport(t *testing.T) {
+	t.Run("idle connections are closed without being counted as drained", func(t *testing.T) {
+		req := require.New(t)
+		server, address := newShutdownTestServer(req, gmhttp.HandlerFunc(func(gmhttp.ResponseWriter, *gmhttp.Request) {}))
+
+		client := &gmhttp.Client{}
+		response, err := client.Get("http://" + address + "/")
+		req.NoError(err)
+		_, _ = io.Copy(io.Discard, response.Body)
+		_ = response.Body.Close()
+
+		req.Eventually(func() bool { return server.connTracker.Count() == 1 }, time.Second, 10*time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		report := server.shutdown(ctx)
+		req.Equal(address, report.Address)
+		req.Equal(0, report.Drained)
+		req.Equal(0, report.ForcedClosed)
+		req.Empty(report.Error)
+	})
+
+	t.Run("busy connections that finish before the drain timeout are drained", func(t *testing.T) {
+		req := require.New(t)
+		started := make(chan struct{})
+
+		server, address := newShutdownTestServer(req, gmhttp.HandlerFunc(func(gmhttp.ResponseWriter, *gmhttp.Request) {
+			close(started)
+			time.Sleep(100 * time.Millisecond)
+		}))
+
+		conn := sendShutdownTestRequest(req, address)
+		defer func() { _ = conn.Close() }()
+
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			req.Fail("handler did not start")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		report := server.shutdown(ctx)
+		req.Equal(1, report.Drained)
+		req.Equal(0, report.ForcedClosed)
+		req.Empty(report.Error)
+	})
+
+	t.Run("busy connections are forced closed once the drain timeout elapses", func(t *testing.T) {
+		req := require.New(t)
+		started := make(chan struct{})
+		release := make(chan struct{})
+		defer close(release)
+
+		server, address := newShutdownTestServer(req, gmhttp.HandlerFunc(func(gmhttp.ResponseWriter, *gmhttp.Request) {
+			close(started)
+			<-release
+		}))
+
+		conn, err := net.Dial("tcp", address)
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n"))
+		req.NoError(err)
+
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			req.Fail("handler did not start")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		report := server.shutdown(ctx)
+		req.Equal(0, report.Drained)
+		req.Equal(1, report.ForcedClosed)
+		req.NotEmpty(report.Error)
+		req.GreaterOrEqual(report.Duration, 100*time.Millisecond)
+	})
+
+	t.Run("hijacked connections are closed and counted separately", func(t *testing.T) {
+		req := require.New(t)
+		hijacked := make(chan struct{})
+
+		server, address := newShutdownTestServer(req, gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, _ *gmhttp.Request) {
+			if _, _, err := writer.(gmhttp.Hijacker).Hijack(); err == nil {
+				close(hijacked)
+			}
+		}))
+
+		conn, err := net.Dial("tcp", address)
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n"))
+		req.NoError(err)
+
+		select {
+		case <-hijacked:
+		case <-time.After(5 * time.Second):
+			req.Fail("handler did not hijack")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		report := server.shutdown(ctx)
+		req.Equal(0, report.Drained)
+		req.Equal(0, report.ForcedClosed)
+		req.Equal(1, report.HijackedClosed)
+		req.Empty(report.Error)
+
+		req.NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+		_, err = conn.Read(make([]byte, 1))
+		req.ErrorIs(err, io.EOF)
+	})
+}
+
+func TestStopApiHandler(t *testing.T) {
+	adapt := func(handler factory.WebHandler) ApiHandler {
+		return &webHandlerAdapter{
+			WebHandler: handler,
+			binding:    &apiBindingAdapter{binding: "test", name: "test"},
+		}
+	}
+
+	t.Run("factory stoppers are stopped", func(t *testing.T) {
+		req := require.New(t)
+		handler := &stoppingWebHandler{}
+
+		stopped, err := stopApiHandler(context.Background(), adapt(handler))
+		req.True(stopped)
+		req.NoError(err)
+		req.True(handler.stopped)
+	})
+
+	t.Run("stop errors are returned", func(t *testing.T) {
+		req := require.New(t)
+
+		stopped, err := stopApiHandler(context.Background(), adapt(&stoppingWebHandler{stopErr: errors.New("flush failed")}))
+		req.True(stopped)
+		req.EqualError(err, "flush failed")
+	})
+
+	t.Run("panics are returned as errors", func(t *testing.T) {
+		req := require.New(t)
+
+		stopped, err := stopApiHandler(context.Background(), adapt(&stoppingWebHandler{panics: true}))
+		req.True(stopped)
+		req.ErrorContains(err, "panic while stopping")
+	})
+
+	t.Run("other handlers are not stopped", func(t *testing.T) {
+		req := require.New(t)
+
+		stopped, err := stopApiHandler(context.Background(), adapt(&testWebHandlerWithoutStop{}))
+		req.False(stopped)
+		req.NoError(err)
+	})
+}
+
+type testWebHandlerWithoutStop struct{}
+
+func (handler *testWebHandlerWithoutStop) ServeHTTP(gmhttp.ResponseWriter, *gmhttp.Request) {}
+
+func (handler *testWebHandlerWithoutStop) RootPath() string {
+	return "/"
+}
+
+func (handler *testWebHandlerWithoutStop) IsHandler(*gmhttp.Request) bool {
+	return true
+}
+
+func TestServerShutdown(t *testing.T) {
+	req := require.New(t)
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	defer close(release)
+
+	slow, slowAddress := newShutdownTestServer(req, gmhttp.HandlerFunc(func(gmhttp.ResponseWriter, *gmhttp.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	fast, fastAddress := newShutdownTestServer(req, gmhttp.HandlerFunc(func(gmhttp.ResponseWriter, *gmhttp.Request) {
+		started <- struct{}{}
+		time.Sleep(100 * time.Millisecond)
+	}))
+
+	_, logWriter := io.Pipe()
+	server := &Server{
+		ServerConfig: &ServerConfig{Name: "test"},
+		httpServers:  []*namedHttpServer{slow, fast},
+		logWriter:    logWriter,
+		sloWatcher:   newSloWatcher(nil, nil),
+	}
+
+	for _, address := range []string{slowAddress, fastAddress} {
+		conn := sendShutdownTestRequest(req, address)
+		defer func() { _ = conn.Close() }()
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			req.Fail("handler did not start")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	//bind points drain concurrently, the slow bind point does not use up the drain timeout of the fast one
+	report := server.Shutdown(ctx)
+	req.Len(report.BindPoints, 2)
+	req.Equal(slowAddress, report.BindPoints[0].Address)
+	req.Equal(1, report.BindPoints[0].ForcedClosed)
+	req.Equal(fastAddress, report.BindPoints[1].Address)
+	req.Equal(1, report.BindPoints[1].Drained)
+	req.Equal(0, report.BindPoints[1].ForcedClosed)
+	req.Empty(report.BindPoints[1].Error)
+	req.Less(report.Duration, 600*time.Millisecond)
+}
+
+func TestShutdownReport(t *testing.T) {
+	req := require.New(t)
+
+	report := &ShutdownReport{
+		Duration: time.Second,
+		Servers: []*ServerShutdownReport{{
+			Server: "api",
+			BindPoints: []*BindPointShutdownReport{
+				{Address: "127.0.0.1:443", Drained: 3},
+				{Address: "127.0.0.1:8443", Drained: 1},
+			},
+			Apis: []*ApiShutdownReport{{Name: "test", Binding: "test"}},
+		}},
+	}
+	req.True(report.Clean())
+	req.Equal("1 servers shut down in 1s, 4 connections drained, 0 forced closed, 0 hijacked closed, 0 errors", report.String())
+
+	report.Servers[0].BindPoints[0].HijackedClosed = 1
+	req.True(report.Clean())
+
+	report.Servers[0].BindPoints[1].ForcedClosed = 2
+	report.Servers[0].Apis[0].Error = "flush failed"
+	req.False(report.Clean())
+	req.Equal("1 servers shut down in 1s, 4 connections drained, 2 forced closed, 1 hijacked closed, 1 errors", report.String())
+}
+
+func TestShutdownOptions(t *testing.T) {
+	t.Run("the drain timeout defaults to 15s", func(t *testing.T) {
+		req := require.New(t)
+		options := ShutdownOptions{}
+		options.Default()
+		req.NoError(options.Parse(map[interface{}]interface{}{}))
+		req.NoError(options.Validate())
+		req.Equal(DefaultDrainTimeout, options.DrainTimeout)
+	})
+
+	t.Run("the drain timeout is parsed", func(t *testing.T) {
+		req := require.New(t)
+		options := ShutdownOptions{}
+		options.Default()
+		req.NoError(options.Parse(map[interface{}]interface{}{"drainTimeout": "1m"}))
+		req.NoError(options.Validate())
+		req.Equal(time.Minute, options.DrainTimeout)
+	})
+
+	t.Run("invalid drain timeouts are rejected", func(t *testing.T) {
+		req := require.New(t)
+		options := ShutdownOptions{}
+		req.Error(options.Parse(map[interface{}]interface{}{"drainTimeout": 15}))
+		req.Error(options.Parse(map[interface{}]interface{}{"drainTimeout": "soon"}))
+		req.NoError(options.Parse(map[interface{}]interface{}{"drainTimeout": "0s"}))
+		req.Error(options.Validate())
+	})
+}
@@ -0,0 +1,408 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	EventTypeSlo = "xweb.slo"
+
+	SloKindLatency   = "latency"
+	SloKindErrorRate = "errorRate"
+	SloKindInFlight  = "inFlight"
+
+	DefaultSloWindow      = time.Minute
+	DefaultSloMinRequests = 20
+
+	sloBucketsPerWindow = 10
+)
+
+// sloLatencyBounds are the upper bounds of the latency histogram used to estimate percentiles. Bounds grow by 25%
+// from 1ms, giving a worst case estimation error of 25%.
+var sloLatencyBounds = func() []time.Duration {
+	var result []time.Duration
+	for bound := float64(time.Millisecond); bound < float64(5*time.Minute); bound *= 1.25 {
+		result = append(result, time.Duration(bound))
+	}
+	return result
+}()
+
+// SloConfig declares the service level objectives for an ApiConfig. Any threshold left at zero is not evaluated.
+type SloConfig struct {
+	// P99Latency is the maximum 99th percentile response time
+	P99Latency time.Duration
+
+	// ErrorRate is the maximum ratio (0-1) of responses with a 5xx status
+	ErrorRate float64
+
+	// MaxInFlight is the maximum number of concurrently served requests, a measure of request queue depth
+	MaxInFlight int64
+
+	// Window is the sliding window latency and error rates are measured over
+	Window time.Duration
+
+	// MinRequests is the minimum number of requests in the window before latency and error rate are evaluated
+	MinRequests int64
+}
+
+// Parse parses a config map
+func (config *SloConfig) Parse(configMap map[interface{}]interface{}) error {
+	config.Window = DefaultSloWindow
+	config.MinRequests = DefaultSloMinRequests
+
+	if interfaceVal, ok := configMap["p99Latency"]; ok {
+		if latencyStr, ok := interfaceVal.(string); ok {
+			if latency, err := time.ParseDuration(latencyStr); err == nil {
+				config.P99Latency = latency
+			} else {
+				return fmt.Errorf("could not parse p99Latency %s as a duration (e.g. 250ms): %v", latencyStr, err)
+			}
+		} else {
+			return errors.New("could not use value for p99Latency, not a string")
+		}
+	}
+
+	if interfaceVal, ok := configMap["errorRate"]; ok {
+		switch errorRate := interfaceVal.(type) {
+		case float64:
+			config.ErrorRate = errorRate
+		case int:
+			config.ErrorRate = float64(errorRate)
+		default:
+			return errors.New("could not use value for errorRate, not a number")
+		}
+	}
+
+	if interfaceVal, ok := configMap["maxInFlight"]; ok {
+		if maxInFlight, ok := interfaceVal.(int); ok {
+			config.MaxInFlight = int64(maxInFlight)
+		} else {
+			return errors.New("could not use value for maxInFlight, not an integer")
+		}
+	}
+
+	if interfaceVal, ok := configMap["window"]; ok {
+		if windowStr, ok := interfaceVal.(string); ok {
+			if window, err := time.ParseDuration(windowStr); err == nil {
+				config.Window = window
+			} else {
+				return fmt.Errorf("could not parse window %s as a duration (e.g. 1m): %v", windowStr, err)
+			}
+		} else {
+			return errors.New("could not use value for window, not a string")
+		}
+	}
+
+	if interfaceVal, ok := configMap["minRequests"]; ok {
+		if minRequests, ok := interfaceVal.(int); ok {
+			config.MinRequests = int64(minRequests)
+		} else {
+			return errors.New("could not use value for minRequests, not an integer")
+		}
+	}
+
+	return nil
+}
+
+// Validate validates the configuration values and returns nil or error
+func (config *SloConfig) Validate() error {
+	if config.P99Latency < 0 {
+		return errors.New("p99Latency must not be negative")
+	}
+
+	if config.ErrorRate < 0 || config.ErrorRate > 1 {
+		return errors.New("errorRate must be between 0 and 1")
+	}
+
+	if config.MaxInFlight < 0 {
+		return errors.New("maxInFlight must not be negative")
+	}
+
+	if config.Window < sloBucketsPerWindow*time.Millisecond {
+		return fmt.Errorf("window must be at least %s", sloBucketsPerWindow*time.Millisecond)
+	}
+
+	if config.MinRequests < 0 {
+		return errors.New("minRequests must not be negative")
+	}
+
+	return nil
+}
+
+// SloEvent is dispatched when an objective is breached and again when it has recovered
+type SloEvent struct {
+	Server    string  `json:"server"`
+	Binding   string  `json:"binding"`
+	Name      string  `json:"name"`
+	Kind      string  `json:"kind"`
+	Breached  bool    `json:"breached"`
+	Threshold float64 `json:"threshold"`
+	Observed  float64 `json:"observed"`
+}
+
+func (event *SloEvent) EventType() string {
+	return EventTypeSlo
+}
+
+// sloBucket holds measurements for a 1/sloBucketsPerWindow slice of the window
+type sloBucket struct {
+	start     time.Time
+	requests  int64
+	errors    int64
+	latencies []int64
+}
+
+// sloTracker records measurements for a single apiInstance and evaluates them against a SloConfig
+type sloTracker struct {
+	config   *SloConfig
+	lock     sync.Mutex
+	buckets  []*sloBucket
+	inFlight int64
+	breached map[string]bool
+	now      func() time.Time
+}
+
+func newSloTracker(config *SloConfig) *sloTracker {
+	result := &sloTracker{
+		config:   config,
+		breached: map[string]bool{},
+		now:      time.Now,
+	}
+
+	for i := 0; i < sloBucketsPerWindow; i++ {
+		result.buckets = append(result.buckets, &sloBucket{
+			latencies: make([]int64, len(sloLatencyBounds)+1),
+		})
+	}
+
+	return result
+}
+
+func (tracker *sloTracker) bucketDuration() time.Duration {
+	return tracker.config.Window / sloBucketsPerWindow
+}
+
+// begin records the start of a request, the returned function records its completion. Requests are recorded in the
+// bucket they complete in, so that requests longer than a bucket are counted in the window they are observed in.
+func (tracker *sloTracker) begin() func(status int) {
+	start := tracker.now()
+	atomic.AddInt64(&tracker.inFlight, 1)
+
+	return func(status int) {
+		atomic.AddInt64(&tracker.inFlight, -1)
+		end := tracker.now()
+		tracker.record(end, end.Sub(start), status >= 500)
+	}
+}
+
+func (tracker *sloTracker) record(at time.Time, latency time.Duration, isError bool) {
+	bucketDuration := tracker.bucketDuration()
+	bucketStart := at.Truncate(bucketDuration)
+	index := int((bucketStart.UnixNano() / int64(bucketDuration)) % sloBucketsPerWindow)
+
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	bucket := tracker.buckets[index]
+	if !bucket.start.Equal(bucketStart) {
+		bucket.start = bucketStart
+		bucket.requests = 0
+		bucket.errors = 0
+		for i := range bucket.latencies {
+			bucket.latencies[i] = 0
+		}
+	}
+
+	bucket.requests++
+	if isError {
+		bucket.errors++
+	}
+
+	bucket.latencies[latencyBucketIndex(latency)]++
+}
+
+func latencyBucketIndex(latency time.Duration) int {
+	for i, bound := range sloLatencyBounds {
+		if latency <= bound {
+			return i
+		}
+	}
+	return len(sloLatencyBounds)
+}
+
+// sloObservation is the state of a window at a point in time
+type sloObservation struct {
+	requests   int64
+	errorRate  float64
+	p99Latency time.Duration
+	inFlight   int64
+}
+
+func (tracker *sloTracker) observe(now time.Time) *sloObservation {
+	result := &sloObservation{
+		inFlight: atomic.LoadInt64(&tracker.inFlight),
+	}
+
+	oldest := now.Add(-tracker.config.Window)
+	latencies := make([]int64, len(sloLatencyBounds)+1)
+	var errorCount int64
+
+	tracker.lock.Lock()
+	for _, bucket := range tracker.buckets {
+		if bucket.start.Before(oldest) {
+			continue
+		}
+		result.requests += bucket.requests
+		errorCount += bucket.errors
+		for i, count := range bucket.latencies {
+			latencies[i] += count
+		}
+	}
+	tracker.lock.Unlock()
+
+	if result.requests == 0 {
+		return result
+	}
+
+	result.errorRate = float64(errorCount) / float64(result.requests)
+
+	target := int64(math.Ceil(float64(result.requests) * 0.99))
+	var seen int64
+	for i, count := range latencies {
+		seen += count
+		if seen >= target {
+			if i < len(sloLatencyBounds) {
+				result.p99Latency = sloLatencyBounds[i]
+			} else {
+				result.p99Latency = sloLatencyBounds[len(sloLatencyBounds)-1]
+			}
+			break
+		}
+	}
+
+	return result
+}
+
+// evaluate compares the current window to the configured thresholds and returns events for any transitions between
+// breached and recovered.
+func (tracker *sloTracker) evaluate(now time.Time, instance *apiInstance) []*SloEvent {
+	observation := tracker.observe(now)
+	var result []*SloEvent
+
+	check := func(kind string, threshold, observed float64, applicable bool) {
+		if threshold <= 0 {
+			return
+		}
+
+		tracker.lock.Lock()
+		wasBreached := tracker.breached[kind]
+		isBreached := wasBreached
+		if applicable {
+			isBreached = observed > threshold
+		}
+		tracker.breached[kind] = isBreached
+		tracker.lock.Unlock()
+
+		if isBreached != wasBreached {
+			result = append(result, &SloEvent{
+				Server:    instance.serverConfig.Name,
+				Binding:   instance.config.Binding(),
+				Name:      instance.config.Name(),
+				Kind:      kind,
+				Breached:  isBreached,
+				Threshold: threshold,
+				Observed:  observed,
+			})
+		}
+	}
+
+	enoughRequests := observation.requests >= tracker.config.MinRequests
+
+	check(SloKindLatency, tracker.config.P99Latency.Seconds(), observation.p99Latency.Seconds(), enoughRequests)
+	check(SloKindErrorRate, tracker.config.ErrorRate, observation.errorRate, enoughRequests)
+	check(SloKindInFlight, float64(tracker.config.MaxInFlight), float64(observation.inFlight), true)
+
+	return result
+}
+
+// sloWatcher periodically evaluates all sloTracker's for a Server and dispatches SloEvent's
+type sloWatcher struct {
+	instances  []*apiInstance
+	dispatcher EventDispatcher
+	stopOnce   sync.Once
+	stopNotify chan struct{}
+}
+
+func newSloWatcher(instances []*apiInstance, dispatcher EventDispatcher) *sloWatcher {
+	result := &sloWatcher{
+		dispatcher: dispatcher,
+		stopNotify: make(chan struct{}),
+	}
+
+	for _, instance := range instances {
+		if instance.slo != nil {
+			result.instances = append(result.instances, instance)
+		}
+	}
+
+	return result
+}
+
+// Start begins evaluating objectives at the smallest bucket duration of all watched instances
+func (watcher *sloWatcher) Start() {
+	if len(watcher.instances) == 0 {
+		return
+	}
+
+	interval := watcher.instances[0].slo.bucketDuration()
+	for _, instance := range watcher.instances[1:] {
+		if bucketDuration := instance.slo.bucketDuration(); bucketDuration < interval {
+			interval = bucketDuration
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-watcher.stopNotify:
+				return
+			case now := <-ticker.C:
+				for _, instance := range watcher.instances {
+					for _, event := range instance.slo.evaluate(now, instance) {
+						watcher.dispatcher.Dispatch(event)
+					}
+				}
+			}
+		}
+	}()
+}
+
+func (watcher *sloWatcher) Stop() {
+	watcher.stopOnce.Do(func() {
+		close(watcher.stopNotify)
+	})
+}
@@ -0,0 +1,87 @@
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func Test_sloTracker(t *testing.T) {
+	newInstance := func(config *SloConfig) *apiInstance {
+		return &apiInstance{
+			serverConfig: &ServerConfig{Name: "server1"},
+			config:       &ApiConfig{binding: "test"},
+			slo:          newSloTracker(config),
+		}
+	}
+
+	t.Run("p99 latency breach and recovery emit events once", func(t *testing.T) {
+		req := require.New(t)
+		instance := newInstance(&SloConfig{P99Latency: 100 * time.Millisecond, Window: time.Second, MinRequests: 10})
+		now := time.Now()
+
+		for i := 0; i < 98; i++ {
+			instance.slo.record(now, 10*time.Millisecond, false)
+		}
+		for i := 0; i < 2; i++ {
+			instance.slo.record(now, 500*time.Millisecond, false)
+		}
+
+		events := instance.slo.evaluate(now, instance)
+		req.Len(events, 1)
+		req.Equal(SloKindLatency, events[0].Kind)
+		req.True(events[0].Breached)
+		req.Equal("server1", events[0].Server)
+
+		req.Empty(instance.slo.evaluate(now, instance))
+
+		later := now.Add(2 * time.Second)
+		for i := 0; i < 20; i++ {
+			instance.slo.record(later, 10*time.Millisecond, false)
+		}
+
+		events = instance.slo.evaluate(later, instance)
+		req.Len(events, 1)
+		req.False(events[0].Breached)
+	})
+
+	t.Run("error rate is not evaluated below min requests", func(t *testing.T) {
+		req := require.New(t)
+		instance := newInstance(&SloConfig{ErrorRate: 0.1, Window: time.Second, MinRequests: 10})
+		now := time.Now()
+
+		for i := 0; i < 5; i++ {
+			instance.slo.record(now, time.Millisecond, true)
+		}
+		req.Empty(instance.slo.evaluate(now, instance))
+
+		for i := 0; i < 5; i++ {
+			instance.slo.record(now, time.Millisecond, true)
+		}
+		events := instance.slo.evaluate(now, instance)
+		req.Len(events, 1)
+		req.Equal(SloKindErrorRate, events[0].Kind)
+		req.Equal(1.0, events[0].Observed)
+	})
+
+	t.Run("requests are recorded when they complete", func(t *testing.T) {
+		req := require.New(t)
+		instance := newInstance(&SloConfig{P99Latency: 100 * time.Millisecond, Window: time.Second, MinRequests: 1})
+		start := time.Now().Truncate(instance.slo.bucketDuration())
+		end := start.Add(1500 * time.Millisecond)
+
+		instance.slo.now = func() time.Time { return start }
+		done := instance.slo.begin()
+		instance.slo.now = func() time.Time { return end }
+		done(200)
+
+		observation := instance.slo.observe(end)
+		req.Equal(int64(1), observation.requests)
+		req.Equal(int64(0), observation.inFlight)
+
+		events := instance.slo.evaluate(end, instance)
+		req.Len(events, 1)
+		req.Equal(SloKindLatency, events[0].Kind)
+		req.True(events[0].Breached)
+	})
+}
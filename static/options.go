@@ -0,0 +1,116 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package static
+
+import (
+	"errors"
+	"fmt"
+	"github.com/openziti/xweb/v2/factory"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultIndex         = "index.html"
+	DefaultPrecompressed = true
+)
+
+// Options configures the directory served by a static WebHandler
+type Options struct {
+	// Root is the directory files are served from
+	Root string
+
+	// RootPath is the request path the directory is served at, defaults to "/"
+	RootPath string
+
+	// Index is the file served for requests of a directory, empty to answer them with http.StatusNotFound
+	Index string
+
+	// Precompressed enables serving a file's .br or .gz variant, when it exists next to the file and the client
+	// accepts the encoding
+	Precompressed bool
+
+	// MaxAge is sent as the Cache-Control max-age of all files, zero to not send Cache-Control
+	MaxAge time.Duration
+}
+
+// Default provides defaults for all necessary values
+func (options *Options) Default() {
+	options.Root = ""
+	options.RootPath = "/"
+	options.Index = DefaultIndex
+	options.Precompressed = DefaultPrecompressed
+	options.MaxAge = 0
+}
+
+// Parse parses options
+func (options *Options) Parse(config factory.Options) error {
+	for field, target := range map[string]*string{"root": &options.Root, "rootPath": &options.RootPath, "index": &options.Index} {
+		if value, err := config.GetString(field); err == nil {
+			*target = value
+		} else if !isNotFound(err) {
+			return err
+		}
+	}
+
+	if precompressed, err := config.GetBool("precompressed"); err == nil {
+		options.Precompressed = precompressed
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	if maxAge, err := config.GetDuration("maxAge"); err == nil {
+		options.MaxAge = maxAge
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (options *Options) Validate() error {
+	if options.Root == "" {
+		return errors.New("root must be specified")
+	}
+
+	if info, err := os.Stat(options.Root); err != nil {
+		return fmt.Errorf("could not access root [%s]: %v", options.Root, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("root [%s] is not a directory", options.Root)
+	}
+
+	if !strings.HasPrefix(options.RootPath, "/") {
+		return fmt.Errorf("root path [%s] must start with /", options.RootPath)
+	}
+
+	if options.Index != "" && (strings.Contains(options.Index, "/") || path.Clean(options.Index) != options.Index || options.Index == "..") {
+		return fmt.Errorf("index [%s] must be a file name", options.Index)
+	}
+
+	if options.MaxAge < 0 {
+		return fmt.Errorf("value [%s] for maxAge too low, must be zero or positive", options.MaxAge)
+	}
+
+	return nil
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, factory.ErrOptionNotFound)
+}
@@ -0,0 +1,288 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package static provides an xweb API factory that serves files from a directory, such as the asset bundle of a web
+// UI. Register it with xweb.RegisterFactory and configure APIs with the "static" binding:
+//
+//	apis:
+//	  - binding: static
+//	    options:
+//	      root: /opt/app/ui
+//	      rootPath: /ui
+//	      maxAge: 1h
+//
+// Directory listings are never served, requests of a directory are answered with its index file. Files that were
+// compressed at build time, i.e. app.js.br and app.js.gz next to app.js, are served as-is to clients accepting the
+// encoding, so that the compression middleware neither spends CPU on them nor compresses them again.
+package static
+
+import (
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/openziti/xweb/v2"
+	"github.com/openziti/xweb/v2/factory"
+	"io/fs"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Binding is the binding name of the static Factory
+	Binding = "static"
+)
+
+// precompressedVariant is a file extension of a pre-compressed variant and the encoding of its content
+type precompressedVariant struct {
+	extension string
+	encoding  string
+}
+
+// precompressedVariants are checked in order of preference
+var precompressedVariants = []precompressedVariant{
+	{extension: ".br", encoding: "br"},
+	{extension: ".gz", encoding: "gzip"},
+}
+
+// Factory creates static WebHandler's
+type Factory struct{}
+
+var _ factory.Factory = &Factory{}
+
+// NewFactory returns a static Factory
+func NewFactory() *Factory {
+	return &Factory{}
+}
+
+func (f *Factory) Binding() string {
+	return Binding
+}
+
+func (f *Factory) New(_ factory.Server, binding factory.APIBinding) (factory.WebHandler, error) {
+	options := &Options{}
+	options.Default()
+
+	if err := options.Parse(binding.Options()); err != nil {
+		return nil, fmt.Errorf("error parsing options for static api [%s]: %v", binding.Name(), err)
+	}
+
+	if err := options.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options for static api [%s]: %v", binding.Name(), err)
+	}
+
+	return NewHandler(options), nil
+}
+
+// Handler is a factory.MethodHandler that serves the files of validated Options
+type Handler struct {
+	root          gmhttp.FileSystem
+	rootPath      string
+	index         string
+	precompressed bool
+	cacheControl  string
+}
+
+var _ factory.MethodHandler = &Handler{}
+
+// NewHandler returns a Handler for validated Options
+func NewHandler(options *Options) *Handler {
+	handler := &Handler{
+		root:          gmhttp.Dir(options.Root),
+		rootPath:      options.RootPath,
+		index:         options.Index,
+		precompressed: options.Precompressed,
+	}
+
+	if handler.rootPath != "/" {
+		handler.rootPath = strings.TrimSuffix(handler.rootPath, "/")
+	}
+
+	if options.MaxAge > 0 {
+		handler.cacheControl = "public, max-age=" + strconv.FormatInt(int64(options.MaxAge/time.Second), 10)
+	}
+
+	return handler
+}
+
+func (handler *Handler) RootPath() string {
+	return handler.rootPath
+}
+
+// IsHandler returns true for requests whose path is the root path or below it
+func (handler *Handler) IsHandler(request *gmhttp.Request) bool {
+	if handler.rootPath == "/" {
+		return true
+	}
+
+	requestPath := request.URL.Path
+	return requestPath == handler.rootPath || strings.HasPrefix(requestPath, handler.rootPath+"/")
+}
+
+func (handler *Handler) AllowedMethods(*gmhttp.Request) []string {
+	return []string{gmhttp.MethodGet, gmhttp.MethodHead}
+}
+
+func (handler *Handler) ServeHTTP(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	if request.Method != gmhttp.MethodGet && request.Method != gmhttp.MethodHead {
+		writer.Header().Set("Allow", strings.Join(handler.AllowedMethods(request), ", "))
+		xweb.WriteError(writer, request, gmhttp.StatusMethodNotAllowed, fmt.Errorf("method [%s] is not allowed for path [%s]", request.Method, request.URL.Path), nil)
+		return
+	}
+
+	relativePath := request.URL.Path
+	if handler.rootPath != "/" {
+		relativePath = strings.TrimPrefix(relativePath, handler.rootPath)
+	}
+	name := path.Clean("/" + relativePath)
+
+	info, err := handler.stat(name)
+	if err != nil {
+		handler.writeOpenError(writer, request, err)
+		return
+	}
+
+	if info.IsDir() {
+		if handler.index == "" {
+			xweb.WriteError(writer, request, gmhttp.StatusNotFound, fmt.Errorf("no file found for path [%s]", request.URL.Path), nil)
+			return
+		}
+
+		// relative references in the index file resolve against the directory only if its path ends in a slash
+		if !strings.HasSuffix(request.URL.Path, "/") {
+			target := request.URL.Path + "/"
+			if request.URL.RawQuery != "" {
+				target += "?" + request.URL.RawQuery
+			}
+			gmhttp.Redirect(writer, request, target, gmhttp.StatusMovedPermanently)
+			return
+		}
+
+		name = path.Join(name, handler.index)
+	}
+
+	handler.serveFile(writer, request, name)
+}
+
+// serveFile serves the file with the given name, or its most preferred pre-compressed variant that the client accepts
+func (handler *Handler) serveFile(writer gmhttp.ResponseWriter, request *gmhttp.Request, name string) {
+	served, encoding := name, ""
+
+	if handler.precompressed {
+		writer.Header().Add("Vary", "Accept-Encoding")
+
+		for _, variant := range precompressedVariants {
+			if !acceptsEncoding(request, variant.encoding) {
+				continue
+			}
+			if info, err := handler.stat(name + variant.extension); err == nil && !info.IsDir() {
+				served, encoding = name+variant.extension, variant.encoding
+				break
+			}
+		}
+	}
+
+	file, err := handler.root.Open(served)
+	if err != nil {
+		handler.writeOpenError(writer, request, err)
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		xweb.WriteError(writer, request, gmhttp.StatusInternalServerError, err, nil)
+		return
+	}
+
+	if info.IsDir() {
+		xweb.WriteError(writer, request, gmhttp.StatusNotFound, fmt.Errorf("no file found for path [%s]", request.URL.Path), nil)
+		return
+	}
+
+	if handler.cacheControl != "" {
+		writer.Header().Set("Cache-Control", handler.cacheControl)
+	}
+
+	if encoding != "" {
+		// the content type is that of the original file, sniffing the compressed content would not find it
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		writer.Header().Set("Content-Type", contentType)
+		writer.Header().Set("Content-Encoding", encoding)
+	}
+
+	gmhttp.ServeContent(writer, request, name, info.ModTime(), file)
+}
+
+func (handler *Handler) stat(name string) (fs.FileInfo, error) {
+	file, err := handler.root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	return file.Stat()
+}
+
+func (handler *Handler) writeOpenError(writer gmhttp.ResponseWriter, request *gmhttp.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		xweb.WriteError(writer, request, gmhttp.StatusNotFound, fmt.Errorf("no file found for path [%s]", request.URL.Path), nil)
+	case errors.Is(err, fs.ErrPermission):
+		xweb.WriteError(writer, request, gmhttp.StatusForbidden, fmt.Errorf("access to path [%s] is forbidden", request.URL.Path), nil)
+	default:
+		xweb.WriteError(writer, request, gmhttp.StatusInternalServerError, err, nil)
+	}
+}
+
+// acceptsEncoding returns true if the Accept-Encoding headers of the request name the encoding, or *, with a non-zero
+// quality
+func acceptsEncoding(request *gmhttp.Request, encoding string) bool {
+	accepted := false
+
+	for _, header := range request.Header.Values("Accept-Encoding") {
+		for _, value := range strings.Split(header, ",") {
+			parts := strings.Split(value, ";")
+			name := strings.ToLower(strings.TrimSpace(parts[0]))
+			if name != encoding && name != "*" {
+				continue
+			}
+
+			quality := 1.0
+			for _, param := range parts[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					if parsed, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+						quality = parsed
+					}
+				}
+			}
+
+			// an explicit entry for the encoding takes precedence over *
+			if name == encoding {
+				return quality > 0
+			}
+			accepted = quality > 0
+		}
+	}
+
+	return accepted
+}
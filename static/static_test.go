@@ -0,0 +1,188 @@
+package static
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/openziti/xweb/v2/factory"
+	"github.com/openziti/xweb/v2/middleware"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testBinding struct {
+	options factory.Options
+}
+
+func (binding *testBinding) Binding() string {
+	return Binding
+}
+
+func (binding *testBinding) Name() string {
+	return Binding
+}
+
+func (binding *testBinding) Options() factory.Options {
+	return binding.options
+}
+
+func newTestRoot(t *testing.T, files map[string]string) string {
+	req := require.New(t)
+	root := t.TempDir()
+
+	for name, content := range files {
+		filePath := filepath.Join(root, filepath.FromSlash(name))
+		req.NoError(os.MkdirAll(filepath.Dir(filePath), 0755))
+		req.NoError(os.WriteFile(filePath, []byte(content), 0644))
+	}
+
+	return root
+}
+
+func newTestHandler(req *require.Assertions, options map[interface{}]interface{}) factory.WebHandler {
+	handler, err := NewFactory().New(nil, &testBinding{options: factory.NormalizeOptions(options)})
+	req.NoError(err)
+	return handler
+}
+
+func serve(handler factory.WebHandler, requestPath, acceptEncoding string) *gmhttptest.ResponseRecorder {
+	request := gmhttptest.NewRequest("GET", requestPath, nil)
+	if acceptEncoding != "" {
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
+	recorder := gmhttptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func Test_Handler(t *testing.T) {
+	root := newTestRoot(t, map[string]string{
+		"index.html":      "<html></html>",
+		"app.js":          "console.log('app')",
+		"app.js.br":       "brotli bytes",
+		"app.js.gz":       "gzip bytes",
+		"style.css":       "body {}",
+		"style.css.gz":    "gzip css",
+		"docs/index.html": "<html>docs</html>",
+	})
+
+	options := map[interface{}]interface{}{
+		"root":     root,
+		"rootPath": "/ui",
+		"maxAge":   "1h",
+	}
+
+	t.Run("files are served below the root path", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestHandler(req, options)
+
+		req.True(handler.IsHandler(gmhttptest.NewRequest("GET", "/ui/app.js", nil)))
+		req.False(handler.IsHandler(gmhttptest.NewRequest("GET", "/uix/app.js", nil)))
+
+		recorder := serve(handler, "/ui/app.js", "")
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal("console.log('app')", recorder.Body.String())
+		req.Empty(recorder.Header().Get("Content-Encoding"))
+		req.Equal("public, max-age=3600", recorder.Header().Get("Cache-Control"))
+		req.Equal("Accept-Encoding", recorder.Header().Get("Vary"))
+	})
+
+	t.Run("the preferred accepted pre-compressed variant is served", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestHandler(req, options)
+
+		recorder := serve(handler, "/ui/app.js", "gzip, br")
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal("brotli bytes", recorder.Body.String())
+		req.Equal("br", recorder.Header().Get("Content-Encoding"))
+		req.Contains(recorder.Header().Get("Content-Type"), "javascript")
+
+		recorder = serve(handler, "/ui/app.js", "gzip, br;q=0")
+		req.Equal("gzip bytes", recorder.Body.String())
+		req.Equal("gzip", recorder.Header().Get("Content-Encoding"))
+
+		recorder = serve(handler, "/ui/style.css", "br, gzip")
+		req.Equal("gzip css", recorder.Body.String())
+		req.Equal("gzip", recorder.Header().Get("Content-Encoding"))
+		req.Equal("text/css; charset=utf-8", recorder.Header().Get("Content-Type"))
+	})
+
+	t.Run("files compressed by the compression middleware vary by accept encoding once", func(t *testing.T) {
+		req := require.New(t)
+		handler := middleware.NewCompressionHandler(newTestHandler(req, options))
+
+		request := gmhttptest.NewRequest("GET", "/ui/index.html", nil)
+		request.Header.Set("Accept-Encoding", "gzip")
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		req.Equal("gzip", recorder.Header().Get("Content-Encoding"))
+		req.Equal([]string{"Accept-Encoding"}, recorder.Header().Values("Vary"))
+	})
+
+	t.Run("pre-compressed variants may be disabled", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestHandler(req, map[interface{}]interface{}{
+			"root":          root,
+			"precompressed": false,
+		})
+
+		recorder := serve(handler, "/app.js", "br")
+		req.Equal("console.log('app')", recorder.Body.String())
+		req.Empty(recorder.Header().Get("Content-Encoding"))
+		req.Empty(recorder.Header().Get("Vary"))
+	})
+
+	t.Run("directories are served by their index file", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestHandler(req, options)
+
+		recorder := serve(handler, "/ui/docs/", "")
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal("<html>docs</html>", recorder.Body.String())
+
+		recorder = serve(handler, "/ui/docs", "")
+		req.Equal(gmhttp.StatusMovedPermanently, recorder.Code)
+		req.Equal("/ui/docs/", recorder.Header().Get("Location"))
+
+		recorder = serve(handler, "/ui/", "")
+		req.Equal("<html></html>", recorder.Body.String())
+	})
+
+	t.Run("directories without an index file are not listed", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestHandler(req, map[interface{}]interface{}{
+			"root":  root,
+			"index": "",
+		})
+
+		recorder := serve(handler, "/docs/", "")
+		req.Equal(gmhttp.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("missing files and paths outside of the root are not found", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestHandler(req, options)
+
+		req.Equal(gmhttp.StatusNotFound, serve(handler, "/ui/missing.js", "").Code)
+		req.Equal(gmhttp.StatusNotFound, serve(handler, "/ui/../../etc/passwd", "").Code)
+	})
+
+	t.Run("invalid options are rejected", func(t *testing.T) {
+		req := require.New(t)
+
+		for _, invalid := range []map[interface{}]interface{}{
+			{},
+			{"root": filepath.Join(root, "missing")},
+			{"root": filepath.Join(root, "app.js")},
+			{"root": root, "rootPath": "ui"},
+			{"root": root, "index": "../index.html"},
+			{"root": root, "maxAge": "-1s"},
+		} {
+			_, err := NewFactory().New(nil, &testBinding{options: factory.NormalizeOptions(invalid)})
+			req.Error(err, "%v", invalid)
+		}
+	})
+}
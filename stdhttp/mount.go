@@ -0,0 +1,112 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package stdhttp
+
+import (
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/openziti/xweb/v2"
+	"github.com/openziti/xweb/v2/factory"
+	"net/http"
+	"strings"
+)
+
+// MountMux registers a Factory for a standard library http.Handler, such as a *http.ServeMux, chi.Router or
+// gorilla/mux Router, with a Registry. APIs configured with the binding are served by the handler for requests at or
+// below rootPath.
+func MountMux(registry xweb.Registry, binding string, rootPath string, mux http.Handler) error {
+	muxFactory, err := NewFactory(binding, rootPath, mux)
+	if err != nil {
+		return err
+	}
+	return xweb.RegisterFactory(registry, muxFactory)
+}
+
+// Factory creates WebHandler's that serve requests with a standard library http.Handler. Every API configured with
+// the binding shares the handler, API options are ignored.
+type Factory struct {
+	binding  string
+	rootPath string
+	handler  gmhttp.Handler
+}
+
+var _ factory.Factory = &Factory{}
+
+// NewFactory returns a Factory serving requests at or below rootPath with mux. A trailing slash on rootPath is
+// ignored, "/" serves all requests.
+func NewFactory(binding string, rootPath string, mux http.Handler) (*Factory, error) {
+	if binding == "" {
+		return nil, errors.New("binding must be specified")
+	}
+
+	if !strings.HasPrefix(rootPath, "/") {
+		return nil, fmt.Errorf("root path [%s] must start with /", rootPath)
+	}
+
+	if mux == nil {
+		return nil, fmt.Errorf("no handler provided for binding [%s]", binding)
+	}
+
+	if rootPath != "/" {
+		rootPath = strings.TrimSuffix(rootPath, "/")
+	}
+
+	return &Factory{
+		binding:  binding,
+		rootPath: rootPath,
+		handler:  NewHandler(mux),
+	}, nil
+}
+
+func (f *Factory) Binding() string {
+	return f.binding
+}
+
+func (f *Factory) New(_ factory.Server, _ factory.APIBinding) (factory.WebHandler, error) {
+	return &Handler{
+		rootPath: f.rootPath,
+		handler:  f.handler,
+	}, nil
+}
+
+// Handler is a WebHandler serving requests with a standard library http.Handler
+type Handler struct {
+	rootPath string
+	handler  gmhttp.Handler
+}
+
+var _ factory.WebHandler = &Handler{}
+
+func (handler *Handler) RootPath() string {
+	return handler.rootPath
+}
+
+// IsHandler returns true for requests whose path is the root path or below it, i.e. /api serves /api and /api/x but
+// not /apix
+func (handler *Handler) IsHandler(request *gmhttp.Request) bool {
+	if handler.rootPath == "/" {
+		return true
+	}
+
+	path := request.URL.Path
+	return path == handler.rootPath || strings.HasPrefix(path, handler.rootPath+"/")
+}
+
+func (handler *Handler) ServeHTTP(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	handler.handler.ServeHTTP(writer, request)
+}
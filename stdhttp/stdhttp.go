@@ -0,0 +1,158 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package stdhttp adapts handlers written against the standard library's net/http, such as *http.ServeMux, chi
+// routers and gorilla/mux routers, to xweb, which serves requests with the gmhttp fork of net/http. MountMux registers
+// such a handler as an xweb API factory so existing services can be ported without rewriting their routing:
+//
+//	mux := http.NewServeMux()
+//	mux.HandleFunc("/legacy/status", statusHandler)
+//
+//	if err := stdhttp.MountMux(instance.GetRegistry(), "legacy", "/legacy", mux); err != nil {
+//		...
+//	}
+//
+// The handler receives the complete request path. Handlers written to be served at "/" can be wrapped with
+// http.StripPrefix. The gmhttp request a standard request was adapted from is available via OriginalRequest, for use
+// with xweb functions that require it.
+package stdhttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	gmx509 "gitee.com/zhaochuninhefei/gmgo/x509"
+	"net"
+	"net/http"
+)
+
+type originalRequestKey struct{}
+
+// NewHandler returns a gmhttp.Handler that serves requests with a standard library http.Handler
+func NewHandler(handler http.Handler) gmhttp.Handler {
+	return gmhttp.HandlerFunc(func(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+		handler.ServeHTTP(&responseWriter{ResponseWriter: writer}, NewRequest(request))
+	})
+}
+
+// NewRequest returns a standard library http.Request sharing the URL, headers, body and context of a gmhttp.Request.
+// TLS connection state is converted where the standard library can represent it, peer certificates it cannot parse,
+// such as SM2 certificates, are omitted.
+func NewRequest(request *gmhttp.Request) *http.Request {
+	result := &http.Request{
+		Method:           request.Method,
+		URL:              request.URL,
+		Proto:            request.Proto,
+		ProtoMajor:       request.ProtoMajor,
+		ProtoMinor:       request.ProtoMinor,
+		Header:           http.Header(request.Header),
+		Body:             request.Body,
+		GetBody:          request.GetBody,
+		ContentLength:    request.ContentLength,
+		TransferEncoding: request.TransferEncoding,
+		Close:            request.Close,
+		Host:             request.Host,
+		Form:             request.Form,
+		PostForm:         request.PostForm,
+		MultipartForm:    request.MultipartForm,
+		Trailer:          http.Header(request.Trailer),
+		RemoteAddr:       request.RemoteAddr,
+		RequestURI:       request.RequestURI,
+		TLS:              newConnectionState(request.TLS),
+	}
+
+	return result.WithContext(context.WithValue(request.Context(), originalRequestKey{}, request))
+}
+
+// OriginalRequest returns the gmhttp.Request a standard library http.Request was adapted from by NewRequest
+func OriginalRequest(request *http.Request) (*gmhttp.Request, bool) {
+	original, ok := request.Context().Value(originalRequestKey{}).(*gmhttp.Request)
+	return original, ok
+}
+
+// newConnectionState converts the parts of a gmtls.ConnectionState the standard library can represent. Certificates
+// the standard library cannot parse, such as SM2 certificates, are left out. Verified chains containing such a
+// certificate are left out entirely, so that a chain never appears verified with a certificate missing.
+func newConnectionState(state *gmtls.ConnectionState) *tls.ConnectionState {
+	if state == nil {
+		return nil
+	}
+
+	result := &tls.ConnectionState{
+		Version:            state.Version,
+		HandshakeComplete:  state.HandshakeComplete,
+		DidResume:          state.DidResume,
+		CipherSuite:        state.CipherSuite,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		ServerName:         state.ServerName,
+	}
+
+	for _, cert := range state.PeerCertificates {
+		if parsed, err := x509.ParseCertificate(cert.Raw); err == nil {
+			result.PeerCertificates = append(result.PeerCertificates, parsed)
+		}
+	}
+
+	for _, chain := range state.VerifiedChains {
+		if converted, ok := newVerifiedChain(chain); ok {
+			result.VerifiedChains = append(result.VerifiedChains, converted)
+		}
+	}
+
+	return result
+}
+
+// newVerifiedChain parses each certificate of a gmtls verified chain with the standard library, returning false if any
+// certificate could not be parsed
+func newVerifiedChain(chain []*gmx509.Certificate) ([]*x509.Certificate, bool) {
+	result := make([]*x509.Certificate, 0, len(chain))
+	for _, cert := range chain {
+		parsed, err := x509.ParseCertificate(cert.Raw)
+		if err != nil {
+			return nil, false
+		}
+		result = append(result, parsed)
+	}
+	return result, true
+}
+
+// responseWriter provides a gmhttp.ResponseWriter as a standard library http.ResponseWriter. Headers are shared, not
+// copied.
+type responseWriter struct {
+	gmhttp.ResponseWriter
+}
+
+func (w *responseWriter) Header() http.Header {
+	return http.Header(w.ResponseWriter.Header())
+}
+
+func (w *responseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(gmhttp.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(gmhttp.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("wrapped response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
@@ -0,0 +1,189 @@
+package stdhttp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"gitee.com/zhaochuninhefei/gmgo/x509"
+	"github.com/openziti/xweb/v2"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCert returns a certificate for commonName signed by parent, or self signed if parent is nil
+func newTestCert(req *require.Assertions, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	req.NoError(err)
+
+	cert, err := x509.ParseCertificate(der)
+	req.NoError(err)
+	return cert, key
+}
+
+func Test_MountMux(t *testing.T) {
+	newHandler := func(req *require.Assertions, rootPath string, mux http.Handler) xweb.ApiHandler {
+		registry := xweb.NewRegistryMap()
+		req.NoError(MountMux(registry, "legacy", rootPath, mux))
+
+		apiFactory := registry.Get("legacy")
+		req.NotNil(apiFactory)
+
+		handler, err := apiFactory.New(&xweb.ServerConfig{Name: "test"}, nil)
+		req.NoError(err)
+		return handler
+	}
+
+	t.Run("requests are served by the standard library mux", func(t *testing.T) {
+		req := require.New(t)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/legacy/echo", func(writer http.ResponseWriter, request *http.Request) {
+			original, ok := OriginalRequest(request)
+			req.True(ok)
+			req.Equal("/legacy/echo", original.URL.Path)
+
+			body, err := io.ReadAll(request.Body)
+			req.NoError(err)
+
+			writer.Header().Set("X-Method", request.Method)
+			writer.WriteHeader(http.StatusCreated)
+			_, _ = writer.Write(body)
+			writer.(http.Flusher).Flush()
+		})
+
+		handler := newHandler(req, "/legacy/", mux)
+		req.Equal("/legacy", handler.RootPath())
+
+		recorder := gmhttptest.NewRecorder()
+		request := gmhttptest.NewRequest("POST", "/legacy/echo?x=1", strings.NewReader("hello"))
+		req.True(handler.IsHandler(request))
+		handler.ServeHTTP(recorder, request)
+
+		req.Equal(gmhttp.StatusCreated, recorder.Code)
+		req.Equal("POST", recorder.Header().Get("X-Method"))
+		req.Equal("hello", recorder.Body.String())
+		req.True(recorder.Flushed)
+
+		recorder = gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/legacy/missing", nil))
+		req.Equal(gmhttp.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("only paths at or below the root path are handled", func(t *testing.T) {
+		req := require.New(t)
+		handler := newHandler(req, "/legacy", http.NotFoundHandler())
+
+		req.True(handler.IsHandler(gmhttptest.NewRequest("GET", "/legacy", nil)))
+		req.True(handler.IsHandler(gmhttptest.NewRequest("GET", "/legacy/things", nil)))
+		req.False(handler.IsHandler(gmhttptest.NewRequest("GET", "/legacyish", nil)))
+		req.False(handler.IsHandler(gmhttptest.NewRequest("GET", "/", nil)))
+
+		root := newHandler(req, "/", http.NotFoundHandler())
+		req.Equal("/", root.RootPath())
+		req.True(root.IsHandler(gmhttptest.NewRequest("GET", "/anything", nil)))
+	})
+
+	t.Run("tls connection state is converted", func(t *testing.T) {
+		req := require.New(t)
+		var serverName string
+		handler := NewHandler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			req.NotNil(request.TLS)
+			serverName = request.TLS.ServerName
+		}))
+
+		request := gmhttptest.NewRequest("GET", "https://example.com/", nil)
+		handler.ServeHTTP(gmhttptest.NewRecorder(), request)
+		req.Equal("example.com", serverName)
+	})
+
+	t.Run("verified client certificate chains are converted", func(t *testing.T) {
+		req := require.New(t)
+		ca, caKey := newTestCert(req, "ca", nil, nil)
+		client, clientKey := newTestCert(req, "client", ca, caKey)
+
+		var chains [][]string
+		server := gmhttptest.NewUnstartedServer(NewHandler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			for _, chain := range request.TLS.VerifiedChains {
+				var names []string
+				for _, cert := range chain {
+					names = append(names, cert.Subject.CommonName)
+				}
+				chains = append(chains, names)
+			}
+		})))
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(ca)
+		server.TLS = &gmtls.Config{
+			ClientAuth: gmtls.RequireAndVerifyClientCert,
+			ClientCAs:  clientCAs,
+		}
+		server.StartTLS()
+		defer server.Close()
+
+		httpClient := server.Client()
+		httpClient.Transport.(*gmhttp.Transport).TLSClientConfig.Certificates = []gmtls.Certificate{{
+			Certificate: [][]byte{client.Raw},
+			PrivateKey:  clientKey,
+		}}
+
+		response, err := httpClient.Get(server.URL + "/")
+		req.NoError(err)
+		_ = response.Body.Close()
+		req.Equal(gmhttp.StatusOK, response.StatusCode)
+		req.Equal([][]string{{"client", "ca"}}, chains)
+	})
+
+	t.Run("verified chains with certificates the standard library cannot parse are left out", func(t *testing.T) {
+		req := require.New(t)
+		ca, caKey := newTestCert(req, "ca", nil, nil)
+		client, _ := newTestCert(req, "client", ca, caKey)
+		unparseable := &x509.Certificate{Raw: []byte("not a certificate")}
+
+		state := newConnectionState(&gmtls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{unparseable, ca},
+			VerifiedChains:   [][]*x509.Certificate{{unparseable, ca}, {client, ca}},
+		})
+		req.Len(state.PeerCertificates, 1)
+		req.Len(state.VerifiedChains, 1)
+		req.Equal("client", state.VerifiedChains[0][0].Subject.CommonName)
+	})
+
+	t.Run("invalid mounts are rejected", func(t *testing.T) {
+		req := require.New(t)
+		_, err := NewFactory("legacy", "legacy", http.NotFoundHandler())
+		req.EqualError(err, "root path [legacy] must start with /")
+
+		_, err = NewFactory("", "/", http.NotFoundHandler())
+		req.EqualError(err, "binding must be specified")
+
+		_, err = NewFactory("legacy", "/", nil)
+		req.EqualError(err, "no handler provided for binding [legacy]")
+	})
+}
@@ -0,0 +1,179 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"fmt"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/foundation/v2/debugz"
+	"github.com/openziti/xweb/v2/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	MetricTaskRuns     = "xweb.task.runs"
+	MetricTaskErrors   = "xweb.task.errors"
+	MetricTaskPanics   = "xweb.task.panics"
+	MetricTaskDuration = "xweb.task.duration"
+	MetricTasksRunning = "xweb.tasks.running"
+)
+
+// TaskRunner runs background tasks, such as cache refreshes or polling, for the lifetime of an Instance. Tasks are
+// given a context that is cancelled when the Instance shuts down, panics are recovered and logged, and each run is
+// recorded in the metrics.Registry labeled by task name. Factories should use the Instance's TaskRunner rather than
+// starting their own goroutines so that background work stops with the Instance.
+type TaskRunner struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	metrics metrics.Registry
+	running metrics.Gauge
+	active  int64
+	wg      sync.WaitGroup
+
+	lock    sync.Mutex
+	stopped bool
+}
+
+// NewTaskRunner returns a TaskRunner recording metrics to registry
+func NewTaskRunner(registry metrics.Registry) *TaskRunner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TaskRunner{
+		ctx:     ctx,
+		cancel:  cancel,
+		metrics: registry,
+		running: registry.Gauge(MetricTasksRunning, nil),
+	}
+}
+
+// Context returns the context tasks are run with, which is cancelled by Stop
+func (runner *TaskRunner) Context() context.Context {
+	return runner.ctx
+}
+
+// Go runs a task once in the background. Tasks started after Stop are not run.
+func (runner *TaskRunner) Go(name string, task func(ctx context.Context) error) {
+	runner.start(name, task, func(run func()) {
+		run()
+	})
+}
+
+// Every runs a task in the background each interval, starting one interval from now, until Stop is called. Runs
+// that fail or panic do not stop the schedule, and runs do not overlap. An error is returned if interval is not
+// positive.
+func (runner *TaskRunner) Every(name string, interval time.Duration, task func(ctx context.Context) error) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval [%s] for task [%s] must be positive", interval, name)
+	}
+
+	runner.start(name, task, func(run func()) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				run()
+			case <-runner.ctx.Done():
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// start registers a task's goroutine, schedule decides when the task's runs are performed
+func (runner *TaskRunner) start(name string, task func(ctx context.Context) error, schedule func(run func())) {
+	runner.lock.Lock()
+	defer runner.lock.Unlock()
+
+	if runner.stopped {
+		pfxlog.Logger().WithField("task", name).Warn("task not started, task runner is stopped")
+		return
+	}
+
+	labels := metrics.Labels{"task": name}
+	runs := runner.metrics.Counter(MetricTaskRuns, labels)
+	errs := runner.metrics.Counter(MetricTaskErrors, labels)
+	panics := runner.metrics.Counter(MetricTaskPanics, labels)
+	duration := runner.metrics.Timer(MetricTaskDuration, labels)
+
+	run := func() {
+		if runner.ctx.Err() != nil {
+			return
+		}
+
+		start := time.Now()
+		runs.Inc(1)
+
+		defer func() {
+			duration.UpdateSince(start)
+
+			if panicVal := recover(); panicVal != nil {
+				panics.Inc(1)
+				pfxlog.Logger().WithField("task", name).Errorf("panic caught in task: %v\n%v", panicVal, debugz.GenerateLocalStack())
+			}
+		}()
+
+		if err := task(runner.ctx); err != nil && runner.ctx.Err() == nil {
+			errs.Inc(1)
+			pfxlog.Logger().WithField("task", name).WithError(err).Error("task failed")
+		}
+	}
+
+	runner.wg.Add(1)
+	runner.running.Set(atomic.AddInt64(&runner.active, 1))
+
+	go func() {
+		defer func() {
+			runner.running.Set(atomic.AddInt64(&runner.active, -1))
+			runner.wg.Done()
+		}()
+		schedule(run)
+	}()
+}
+
+// Stop cancels the context of all tasks and waits for them to return or for ctx to be done. An error is returned if
+// tasks are still running when ctx is done.
+func (runner *TaskRunner) Stop(ctx context.Context) error {
+	runner.lock.Lock()
+	runner.stopped = true
+	runner.lock.Unlock()
+
+	runner.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		runner.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d tasks did not stop: %v", atomic.LoadInt64(&runner.active), ctx.Err())
+	}
+}
+
+// Running returns the number of tasks currently scheduled or running
+func (runner *TaskRunner) Running() int {
+	return int(atomic.LoadInt64(&runner.active))
+}
@@ -0,0 +1,170 @@
+package xweb
+
+import (
+	"context"
+	"errors"
+	"github.com/openziti/xweb/v2/factory"
+	"github.com/openziti/xweb/v2/metrics"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func Test_TaskRunner(t *testing.T) {
+	t.Run("tasks are run with the runner context", func(t *testing.T) {
+		req := require.New(t)
+		runner := NewTaskRunner(metrics.NewRegistry())
+
+		ran := make(chan context.Context, 1)
+		runner.Go("once", func(ctx context.Context) error {
+			ran <- ctx
+			return nil
+		})
+
+		select {
+		case ctx := <-ran:
+			req.Equal(runner.Context(), ctx)
+		case <-time.After(time.Second):
+			req.Fail("task did not run")
+		}
+
+		req.NoError(runner.Stop(context.Background()))
+		req.Zero(runner.Running())
+	})
+
+	t.Run("scheduled tasks continue after errors and panics", func(t *testing.T) {
+		req := require.New(t)
+		registry := metrics.NewRegistry()
+		runner := NewTaskRunner(registry)
+
+		runs := make(chan int, 10)
+		count := 0
+		err := runner.Every("flaky", time.Millisecond, func(ctx context.Context) error {
+			count++
+			runs <- count
+			switch count {
+			case 1:
+				return errors.New("failed")
+			case 2:
+				panic("boom")
+			}
+			return nil
+		})
+		req.NoError(err)
+
+		for expected := 1; expected <= 3; expected++ {
+			select {
+			case run := <-runs:
+				req.Equal(expected, run)
+			case <-time.After(time.Second):
+				req.Fail("task was not rescheduled")
+			}
+		}
+
+		req.NoError(runner.Stop(context.Background()))
+
+		labels := metrics.Labels{"task": "flaky"}
+		req.GreaterOrEqual(registry.Counter(MetricTaskRuns, labels).Count(), int64(3))
+		req.Equal(int64(1), registry.Counter(MetricTaskErrors, labels).Count())
+		req.Equal(int64(1), registry.Counter(MetricTaskPanics, labels).Count())
+	})
+
+	t.Run("scheduled tasks require a positive interval", func(t *testing.T) {
+		req := require.New(t)
+		runner := NewTaskRunner(metrics.NewRegistry())
+
+		for _, interval := range []time.Duration{0, -time.Second} {
+			req.Error(runner.Every("invalid", interval, func(ctx context.Context) error {
+				return nil
+			}))
+		}
+		req.Zero(runner.Running())
+	})
+
+	t.Run("stop cancels and waits for tasks", func(t *testing.T) {
+		req := require.New(t)
+		registry := metrics.NewRegistry()
+		runner := NewTaskRunner(registry)
+
+		started := make(chan struct{})
+		stopped := false
+		runner.Go("blocking", func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			stopped = true
+			return ctx.Err()
+		})
+
+		<-started
+		req.Equal(1, runner.Running())
+
+		req.NoError(runner.Stop(context.Background()))
+		req.True(stopped)
+		req.Zero(runner.Running())
+		req.Zero(registry.Counter(MetricTaskErrors, metrics.Labels{"task": "blocking"}).Count())
+	})
+
+	t.Run("stop times out on tasks that ignore cancellation", func(t *testing.T) {
+		req := require.New(t)
+		runner := NewTaskRunner(metrics.NewRegistry())
+
+		release := make(chan struct{})
+		defer close(release)
+
+		started := make(chan struct{})
+		runner.Go("stuck", func(ctx context.Context) error {
+			close(started)
+			<-release
+			return nil
+		})
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		req.EqualError(runner.Stop(ctx), "1 tasks did not stop: context deadline exceeded")
+	})
+
+	t.Run("tasks are not started after stop", func(t *testing.T) {
+		req := require.New(t)
+		runner := NewTaskRunner(metrics.NewRegistry())
+		req.NoError(runner.Stop(context.Background()))
+
+		ran := false
+		runner.Go("late", func(ctx context.Context) error {
+			ran = true
+			return nil
+		})
+
+		req.NoError(runner.Stop(context.Background()))
+		req.False(ran)
+		req.Zero(runner.Running())
+	})
+
+	t.Run("factories are given task servers with scoped task names", func(t *testing.T) {
+		req := require.New(t)
+		registry := metrics.NewRegistry()
+		runner := NewTaskRunner(registry)
+
+		serverConfig := &ServerConfig{Name: "test-server"}
+		api := &ApiConfig{binding: "stable", name: "stable-a"}
+		stableFactory := &testStableFactory{}
+
+		_, err := newApiHandler(NewFactoryAdapter(stableFactory), serverConfig, api, &instanceCapabilities{tasks: runner})
+		req.NoError(err)
+
+		taskServer, ok := stableFactory.server.(factory.TaskServer)
+		req.True(ok)
+
+		done := make(chan struct{})
+		taskServer.Tasks().Go("refresh", func(ctx context.Context) error {
+			close(done)
+			return nil
+		})
+		<-done
+
+		req.NoError(runner.Stop(context.Background()))
+		req.Equal(int64(1), registry.Counter(MetricTaskRuns, metrics.Labels{"task": "test-server/stable-a/refresh"}).Count())
+	})
+}
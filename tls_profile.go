@@ -0,0 +1,348 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"gitee.com/zhaochuninhefei/gmgo/x509"
+	"github.com/openziti/identity"
+	"strings"
+)
+
+// TlsProfile is the name of an approved set of TLS versions, cipher suites, curves and certificate algorithms
+type TlsProfile string
+
+const (
+	// TlsProfileNone applies no restrictions beyond the TlsVersionOptions
+	TlsProfileNone TlsProfile = ""
+
+	// TlsProfileFips restricts TLS to FIPS 140 approved algorithms: AES-GCM, NIST curves, RSA (2048+) and ECDSA
+	TlsProfileFips TlsProfile = "fips"
+
+	// TlsProfileGm restricts TLS to the GM/T (ShangMi) algorithms: SM2, SM3 and SM4 over TLS 1.3
+	TlsProfileGm TlsProfile = "gm"
+
+	// TlsProfileModern restricts TLS to TLS 1.3 with forward secret AEAD suites only
+	TlsProfileModern TlsProfile = "modern"
+
+	// TlsProfileCompatible allows TLS 1.2 and 1.3 with forward secret key exchange for older clients
+	TlsProfileCompatible TlsProfile = "compatible"
+)
+
+// TlsProfileDefinition is the approved algorithm set for a TlsProfile
+type TlsProfileDefinition struct {
+	Name       TlsProfile
+	MinVersion int
+	MaxVersion int
+
+	// CipherSuites are the approved suites for TLS 1.2 and TLS 1.3. TLS 1.3 suites may not be configured in the
+	// underlying TLS library and are enforced after the handshake.
+	CipherSuites []uint16
+
+	Curves              []gmtls.CurveID
+	PublicKeyAlgorithms []x509.PublicKeyAlgorithm
+	SignatureAlgorithms []x509.SignatureAlgorithm
+	MinRsaBits          int
+}
+
+var tls12EcdheAesGcmSuites = []uint16{
+	gmtls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	gmtls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	gmtls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	gmtls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+var rsaAndEcdsaSignatureAlgorithms = []x509.SignatureAlgorithm{
+	x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+	x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS,
+	x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512,
+	x509.ECDSAEXTWithSHA256, x509.ECDSAEXTWithSHA384, x509.ECDSAEXTWithSHA512,
+}
+
+// TlsProfiles are the known TlsProfileDefinition's by name
+var TlsProfiles = map[TlsProfile]*TlsProfileDefinition{
+	TlsProfileFips: {
+		Name:                TlsProfileFips,
+		MinVersion:          gmtls.VersionTLS12,
+		MaxVersion:          gmtls.VersionTLS13,
+		CipherSuites:        append([]uint16{gmtls.TLS_AES_128_GCM_SHA256, gmtls.TLS_AES_256_GCM_SHA384}, tls12EcdheAesGcmSuites...),
+		Curves:              []gmtls.CurveID{gmtls.CurveP256, gmtls.CurveP384, gmtls.CurveP521},
+		PublicKeyAlgorithms: []x509.PublicKeyAlgorithm{x509.RSA, x509.ECDSA, x509.ECDSAEXT},
+		SignatureAlgorithms: rsaAndEcdsaSignatureAlgorithms,
+		MinRsaBits:          2048,
+	},
+	TlsProfileGm: {
+		Name:                TlsProfileGm,
+		MinVersion:          gmtls.VersionTLS13,
+		MaxVersion:          gmtls.VersionTLS13,
+		CipherSuites:        []uint16{gmtls.TLS_SM4_GCM_SM3},
+		Curves:              []gmtls.CurveID{gmtls.Curve256Sm2},
+		PublicKeyAlgorithms: []x509.PublicKeyAlgorithm{x509.SM2},
+		SignatureAlgorithms: []x509.SignatureAlgorithm{x509.SM2WithSM3},
+	},
+	TlsProfileModern: {
+		Name:                TlsProfileModern,
+		MinVersion:          gmtls.VersionTLS13,
+		MaxVersion:          gmtls.VersionTLS13,
+		CipherSuites:        []uint16{gmtls.TLS_AES_128_GCM_SHA256, gmtls.TLS_AES_256_GCM_SHA384, gmtls.TLS_CHACHA20_POLY1305_SHA256, gmtls.TLS_SM4_GCM_SM3},
+		Curves:              []gmtls.CurveID{gmtls.X25519, gmtls.CurveP256, gmtls.CurveP384, gmtls.Curve256Sm2},
+		PublicKeyAlgorithms: []x509.PublicKeyAlgorithm{x509.RSA, x509.ECDSA, x509.ECDSAEXT, x509.Ed25519, x509.SM2},
+		SignatureAlgorithms: append([]x509.SignatureAlgorithm{x509.PureEd25519, x509.SM2WithSM3}, rsaAndEcdsaSignatureAlgorithms...),
+		MinRsaBits:          2048,
+	},
+	TlsProfileCompatible: {
+		Name:       TlsProfileCompatible,
+		MinVersion: gmtls.VersionTLS12,
+		MaxVersion: gmtls.VersionTLS13,
+		CipherSuites: append([]uint16{
+			gmtls.TLS_AES_128_GCM_SHA256, gmtls.TLS_AES_256_GCM_SHA384, gmtls.TLS_CHACHA20_POLY1305_SHA256, gmtls.TLS_SM4_GCM_SM3,
+			gmtls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, gmtls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			gmtls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA, gmtls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			gmtls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, gmtls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		}, tls12EcdheAesGcmSuites...),
+		Curves:              []gmtls.CurveID{gmtls.X25519, gmtls.CurveP256, gmtls.CurveP384, gmtls.CurveP521, gmtls.Curve256Sm2},
+		PublicKeyAlgorithms: []x509.PublicKeyAlgorithm{x509.RSA, x509.ECDSA, x509.ECDSAEXT, x509.Ed25519, x509.SM2},
+		SignatureAlgorithms: append([]x509.SignatureAlgorithm{x509.PureEd25519, x509.SM2WithSM3}, rsaAndEcdsaSignatureAlgorithms...),
+		MinRsaBits:          2048,
+	},
+}
+
+// AllowsCipherSuite returns true if the suite is approved by the profile
+func (profile *TlsProfileDefinition) AllowsCipherSuite(suite uint16) bool {
+	for _, allowed := range profile.CipherSuites {
+		if allowed == suite {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateCertificate returns an error if the certificate's key or signature algorithm is not approved by the profile
+func (profile *TlsProfileDefinition) ValidateCertificate(cert *x509.Certificate) error {
+	keyAllowed := false
+	for _, algorithm := range profile.PublicKeyAlgorithms {
+		if algorithm == cert.PublicKeyAlgorithm {
+			keyAllowed = true
+			break
+		}
+	}
+
+	if !keyAllowed {
+		return fmt.Errorf("public key algorithm %s is not allowed by TLS profile %s", cert.PublicKeyAlgorithm, profile.Name)
+	}
+
+	signatureAllowed := false
+	for _, algorithm := range profile.SignatureAlgorithms {
+		if algorithm == cert.SignatureAlgorithm {
+			signatureAllowed = true
+			break
+		}
+	}
+
+	if !signatureAllowed {
+		return fmt.Errorf("signature algorithm %s is not allowed by TLS profile %s", cert.SignatureAlgorithm, profile.Name)
+	}
+
+	if cert.PublicKeyAlgorithm == x509.RSA && profile.MinRsaBits > 0 {
+		if bits := rsaKeyBits(cert); bits < profile.MinRsaBits {
+			return fmt.Errorf("RSA key size %d is less than the %d bits required by TLS profile %s", bits, profile.MinRsaBits, profile.Name)
+		}
+	}
+
+	return nil
+}
+
+func rsaKeyBits(cert *x509.Certificate) int {
+	if key, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+		return key.N.BitLen()
+	}
+	return 0
+}
+
+// cipherSuitesByName contains all cipher suites supported by gmtls, including insecure suites so that they may be
+// named and rejected by profiles
+var cipherSuitesByName = func() map[string]uint16 {
+	result := map[string]uint16{}
+	for _, suite := range append(gmtls.CipherSuites(), gmtls.InsecureCipherSuites()...) {
+		result[suite.Name] = suite.ID
+	}
+	return result
+}()
+
+// TlsProfileOptions represents the TLS profile and explicit cipher suite options
+type TlsProfileOptions struct {
+	TlsProfile      TlsProfile
+	CipherSuites    []uint16
+	cipherSuiteStrs []string
+}
+
+// Default provides defaults for all necessary values
+func (options *TlsProfileOptions) Default() {
+	options.TlsProfile = TlsProfileNone
+	options.CipherSuites = nil
+	options.cipherSuiteStrs = nil
+}
+
+// Parse parses a configuration map
+func (options *TlsProfileOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["tlsProfile"]; ok {
+		if profile, ok := interfaceVal.(string); ok {
+			options.TlsProfile = TlsProfile(strings.ToLower(profile))
+		} else {
+			return errors.New("could not use value for tlsProfile, not a string")
+		}
+	}
+
+	if interfaceVal, ok := config["cipherSuites"]; ok {
+		suites, ok := interfaceVal.([]interface{})
+		if !ok {
+			return errors.New("could not use value for cipherSuites, not an array")
+		}
+
+		for i, suiteInterface := range suites {
+			name, ok := suiteInterface.(string)
+			if !ok {
+				return fmt.Errorf("could not use value for cipherSuites[%d], not a string", i)
+			}
+
+			suite, ok := cipherSuitesByName[name]
+			if !ok {
+				return fmt.Errorf("could not use value for cipherSuites[%d], unknown cipher suite [%s]", i, name)
+			}
+
+			options.CipherSuites = append(options.CipherSuites, suite)
+			options.cipherSuiteStrs = append(options.cipherSuiteStrs, name)
+		}
+	}
+
+	return nil
+}
+
+// Profile returns the TlsProfileDefinition for the configured TlsProfile or nil if there is none
+func (options *TlsProfileOptions) Profile() *TlsProfileDefinition {
+	return TlsProfiles[options.TlsProfile]
+}
+
+// applyVersionDefaults sets the TLS versions to those of the profile if they were not explicitly configured
+func (options *TlsProfileOptions) applyVersionDefaults(versions *TlsVersionOptions) {
+	profile := options.Profile()
+	if profile == nil {
+		return
+	}
+
+	if versions.minTLSVersionStr == "" {
+		versions.MinTLSVersion = profile.MinVersion
+		versions.minTLSVersionStr = ReverseTlsVersionMap[profile.MinVersion]
+	}
+
+	if versions.maxTLSVersionStr == "" {
+		versions.MaxTLSVersion = profile.MaxVersion
+		versions.maxTLSVersionStr = ReverseTlsVersionMap[profile.MaxVersion]
+	}
+}
+
+// Validate validates the TLS versions, explicit cipher suites and server identity certificates against the profile
+func (options *TlsProfileOptions) Validate(versions *TlsVersionOptions, serverIdentity identity.Identity) error {
+	if options.TlsProfile == TlsProfileNone {
+		return nil
+	}
+
+	profile := options.Profile()
+	if profile == nil {
+		return fmt.Errorf("invalid tlsProfile [%s], must be one of: %s, %s, %s, %s", options.TlsProfile, TlsProfileFips, TlsProfileGm, TlsProfileModern, TlsProfileCompatible)
+	}
+
+	if versions.MinTLSVersion < profile.MinVersion || versions.MaxTLSVersion > profile.MaxVersion {
+		return fmt.Errorf("TLS versions %s-%s are not allowed by TLS profile %s, which allows %s-%s", ReverseTlsVersionMap[versions.MinTLSVersion], ReverseTlsVersionMap[versions.MaxTLSVersion], profile.Name, ReverseTlsVersionMap[profile.MinVersion], ReverseTlsVersionMap[profile.MaxVersion])
+	}
+
+	for i, suite := range options.CipherSuites {
+		if !profile.AllowsCipherSuite(suite) {
+			return fmt.Errorf("cipher suite [%s] is not allowed by TLS profile %s", options.cipherSuiteStrs[i], profile.Name)
+		}
+	}
+
+	if serverIdentity == nil {
+		return nil
+	}
+
+	var certs []*gmtls.Certificate
+	certs = append(certs, serverIdentity.ServerCert()...)
+	if cert := serverIdentity.Cert(); cert != nil {
+		certs = append(certs, cert)
+	}
+
+	for _, cert := range certs {
+		if cert == nil || len(cert.Certificate) == 0 {
+			continue
+		}
+
+		leaf := cert.Leaf
+		if leaf == nil {
+			var err error
+			if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return fmt.Errorf("could not parse identity certificate: %v", err)
+			}
+		}
+
+		if err := profile.ValidateCertificate(leaf); err != nil {
+			return fmt.Errorf("identity certificate [%s] is not allowed: %v", leaf.Subject.CommonName, err)
+		}
+	}
+
+	return nil
+}
+
+// apply restricts a server TLS configuration to the profile. TLS 1.2 cipher suites and curves are configured directly,
+// the negotiated cipher suite is also verified after each handshake as TLS 1.3 suites cannot be configured.
+func (options *TlsProfileOptions) apply(tlsConfig *gmtls.Config) {
+	if len(options.CipherSuites) > 0 {
+		tlsConfig.CipherSuites = options.CipherSuites
+	}
+
+	profile := options.Profile()
+	if profile == nil {
+		return
+	}
+
+	if len(options.CipherSuites) == 0 {
+		tlsConfig.CipherSuites = profile.CipherSuites
+	}
+
+	tlsConfig.CurvePreferences = profile.Curves
+
+	allowed := map[uint16]struct{}{}
+	for _, suite := range tlsConfig.CipherSuites {
+		allowed[suite] = struct{}{}
+	}
+
+	verifyConnection := tlsConfig.VerifyConnection
+	tlsConfig.VerifyConnection = func(state gmtls.ConnectionState) error {
+		if _, ok := allowed[state.CipherSuite]; !ok {
+			return fmt.Errorf("negotiated cipher suite %s is not allowed by TLS profile %s", gmtls.CipherSuiteName(state.CipherSuite), profile.Name)
+		}
+
+		if verifyConnection != nil {
+			return verifyConnection(state)
+		}
+
+		return nil
+	}
+}
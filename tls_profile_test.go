@@ -0,0 +1,82 @@
+package xweb
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"gitee.com/zhaochuninhefei/gmgo/gmtls"
+	"gitee.com/zhaochuninhefei/gmgo/x509"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTlsProfileOptions(t *testing.T) {
+	parse := func(req *require.Assertions, config map[interface{}]interface{}) *Options {
+		options := &Options{}
+		options.Default()
+		req.NoError(options.Parse(config))
+		return options
+	}
+
+	t.Run("profiles default the TLS versions", func(t *testing.T) {
+		req := require.New(t)
+		options := parse(req, map[interface{}]interface{}{"tlsProfile": "gm"})
+		req.Equal(gmtls.VersionTLS13, options.MinTLSVersion)
+		req.Equal(gmtls.VersionTLS13, options.MaxTLSVersion)
+		req.NoError(options.TlsProfileOptions.Validate(&options.TlsVersionOptions, nil))
+	})
+
+	t.Run("explicit TLS versions outside the profile fail validation", func(t *testing.T) {
+		req := require.New(t)
+		options := parse(req, map[interface{}]interface{}{"tlsProfile": "modern", "minTLSVersion": "TLS1.2"})
+		req.Error(options.TlsProfileOptions.Validate(&options.TlsVersionOptions, nil))
+	})
+
+	t.Run("explicit cipher suites outside the profile fail validation", func(t *testing.T) {
+		req := require.New(t)
+		options := parse(req, map[interface{}]interface{}{"tlsProfile": "fips", "cipherSuites": []interface{}{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"}})
+		err := options.TlsProfileOptions.Validate(&options.TlsVersionOptions, nil)
+		req.Error(err)
+		req.Contains(err.Error(), "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256")
+	})
+
+	t.Run("unknown cipher suites fail parsing", func(t *testing.T) {
+		req := require.New(t)
+		options := &Options{}
+		options.Default()
+		req.Error(options.Parse(map[interface{}]interface{}{"cipherSuites": []interface{}{"TLS_NOT_A_SUITE"}}))
+	})
+
+	t.Run("unknown profiles fail validation", func(t *testing.T) {
+		req := require.New(t)
+		options := parse(req, map[interface{}]interface{}{"tlsProfile": "legacy"})
+		req.Error(options.TlsProfileOptions.Validate(&options.TlsVersionOptions, nil))
+	})
+}
+
+func TestTlsProfileDefinition_ValidateCertificate(t *testing.T) {
+	t.Run("fips allows ecdsa", func(t *testing.T) {
+		req := require.New(t)
+		cert := &x509.Certificate{PublicKeyAlgorithm: x509.ECDSA, SignatureAlgorithm: x509.ECDSAWithSHA256}
+		req.NoError(TlsProfiles[TlsProfileFips].ValidateCertificate(cert))
+	})
+
+	t.Run("fips rejects sm2", func(t *testing.T) {
+		req := require.New(t)
+		cert := &x509.Certificate{PublicKeyAlgorithm: x509.SM2, SignatureAlgorithm: x509.SM2WithSM3}
+		req.Error(TlsProfiles[TlsProfileFips].ValidateCertificate(cert))
+	})
+
+	t.Run("gm rejects ecdsa", func(t *testing.T) {
+		req := require.New(t)
+		cert := &x509.Certificate{PublicKeyAlgorithm: x509.ECDSA, SignatureAlgorithm: x509.ECDSAWithSHA256}
+		req.Error(TlsProfiles[TlsProfileGm].ValidateCertificate(cert))
+	})
+
+	t.Run("small rsa keys are rejected", func(t *testing.T) {
+		req := require.New(t)
+		key, err := rsa.GenerateKey(rand.Reader, 1024)
+		req.NoError(err)
+		cert := &x509.Certificate{PublicKeyAlgorithm: x509.RSA, SignatureAlgorithm: x509.SHA256WithRSA, PublicKey: &key.PublicKey}
+		req.Error(TlsProfiles[TlsProfileCompatible].ValidateCertificate(cert))
+	})
+}
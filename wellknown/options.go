@@ -0,0 +1,209 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package wellknown
+
+import (
+	"errors"
+	"fmt"
+	"github.com/openziti/xweb/v2/factory"
+	"mime"
+	"path"
+	"strings"
+	"time"
+)
+
+// Options configures the documents served by a well-known WebHandler
+type Options struct {
+	// SecurityTxt is served at SecurityTxtPath if not empty
+	SecurityTxt string
+
+	// Documents are additional documents keyed by their path below WellKnownPath, e.g. "change-password"
+	Documents map[string]*Document
+
+	// MaxAge is sent as the Cache-Control max-age of all documents, zero to not send Cache-Control
+	MaxAge time.Duration
+}
+
+// Document is the content served for a path
+type Document struct {
+	// Content is the response body
+	Content string
+
+	// ContentType is the Content-Type of the document, determined from the path's extension or the content if empty
+	ContentType string
+}
+
+// Default provides defaults for all necessary values
+func (options *Options) Default() {
+	options.SecurityTxt = ""
+	options.Documents = map[string]*Document{}
+	options.MaxAge = 0
+}
+
+// Parse parses options. Documents may be configured as a string of content or a map with `content` and
+// `contentType` values.
+func (options *Options) Parse(config factory.Options) error {
+	if config.Has("robotsTxt") {
+		return fmt.Errorf("robotsTxt is served by the %s binding", RobotsBinding)
+	}
+
+	if securityTxt, err := config.GetString("securityTxt"); err == nil {
+		options.SecurityTxt = securityTxt
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	if maxAge, err := config.GetDuration("maxAge"); err == nil {
+		options.MaxAge = maxAge
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	documents, err := config.GetOptions("documents")
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	for name := range documents {
+		document := &Document{}
+
+		if content, err := documents.GetString(name); err == nil {
+			document.Content = content
+		} else if documentOptions, err := documents.GetOptions(name); err == nil {
+			if document.Content, err = documentOptions.GetString("content"); err != nil {
+				return fmt.Errorf("error parsing documents.%s: %v", name, err)
+			}
+			if document.ContentType, err = documentOptions.GetString("contentType"); err != nil && !isNotFound(err) {
+				return fmt.Errorf("error parsing documents.%s: %v", name, err)
+			}
+		} else {
+			return fmt.Errorf("could not use value for documents.%s, not a string or map", name)
+		}
+
+		options.Documents[name] = document
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (options *Options) Validate() error {
+	if options.MaxAge < 0 {
+		return fmt.Errorf("value [%s] for maxAge too low, must be zero or positive", options.MaxAge)
+	}
+
+	for name := range options.Documents {
+		if name == "" || strings.HasPrefix(name, "/") || path.Clean(name) != name || strings.HasPrefix(name, "..") {
+			return fmt.Errorf("document name [%s] must be a clean relative path", name)
+		}
+	}
+
+	if len(options.paths()) == 0 {
+		return errors.New("at least one of securityTxt or documents must be configured")
+	}
+
+	return nil
+}
+
+// paths returns the documents to serve keyed by request path
+func (options *Options) paths() map[string]*Document {
+	result := map[string]*Document{}
+
+	if options.SecurityTxt != "" {
+		result[SecurityTxtPath] = &Document{Content: options.SecurityTxt}
+	}
+
+	for name, document := range options.Documents {
+		result[WellKnownPath+name] = document
+	}
+
+	for documentPath, document := range result {
+		if document.ContentType == "" {
+			result[documentPath] = &Document{
+				Content:     document.Content,
+				ContentType: contentType(documentPath, document.Content),
+			}
+		}
+	}
+
+	return result
+}
+
+// RobotsOptions configures the robots.txt served by a robots-txt WebHandler
+type RobotsOptions struct {
+	// RobotsTxt is served at RobotsTxtPath
+	RobotsTxt string
+
+	// MaxAge is sent as the Cache-Control max-age, zero to not send Cache-Control
+	MaxAge time.Duration
+}
+
+// Default provides defaults for all necessary values
+func (options *RobotsOptions) Default() {
+	options.RobotsTxt = ""
+	options.MaxAge = 0
+}
+
+// Parse parses options
+func (options *RobotsOptions) Parse(config factory.Options) error {
+	if robotsTxt, err := config.GetString("robotsTxt"); err == nil {
+		options.RobotsTxt = robotsTxt
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	if maxAge, err := config.GetDuration("maxAge"); err == nil {
+		options.MaxAge = maxAge
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Validate validates all settings and return nil or an error
+func (options *RobotsOptions) Validate() error {
+	if options.MaxAge < 0 {
+		return fmt.Errorf("value [%s] for maxAge too low, must be zero or positive", options.MaxAge)
+	}
+
+	if options.RobotsTxt == "" {
+		return errors.New("robotsTxt must be configured")
+	}
+
+	return nil
+}
+
+// contentType returns the Content-Type for a document from its path's extension, its content or text/plain
+func contentType(documentPath, content string) string {
+	if byExtension := mime.TypeByExtension(path.Ext(documentPath)); byExtension != "" {
+		return byExtension
+	}
+
+	if trimmed := strings.TrimSpace(content); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		return "application/json"
+	}
+
+	return "text/plain; charset=utf-8"
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, factory.ErrOptionNotFound)
+}
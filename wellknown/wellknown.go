@@ -0,0 +1,210 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package wellknown provides xweb API factories that serve security.txt and other /.well-known documents, and
+// robots.txt, from configured content. Register them with xweb.RegisterFactory and configure APIs with the
+// "well-known" and "robots-txt" bindings:
+//
+//	apis:
+//	  - binding: robots-txt
+//	    options:
+//	      maxAge: 1h
+//	      robotsTxt: |
+//	        User-agent: *
+//	        Disallow: /
+//	  - binding: well-known
+//	    options:
+//	      maxAge: 1h
+//	      securityTxt: |
+//	        Contact: mailto:security@example.com
+//	        Expires: 2030-01-01T00:00:00.000Z
+//	      documents:
+//	        change-password: https://example.com/account/password
+//	        openid-configuration:
+//	          contentType: application/json
+//	          content: '{"issuer": "https://example.com"}'
+//
+// The well-known handler's root path is WellKnownPath and the robots-txt handler's root path is RobotsTxtPath, so both
+// are routed by path prefix demultiplexing.
+package wellknown
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	"github.com/openziti/xweb/v2"
+	"github.com/openziti/xweb/v2/factory"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Binding is the binding name of the well-known Factory
+	Binding = "well-known"
+
+	// RobotsBinding is the binding name of the robots.txt Factory
+	RobotsBinding = "robots-txt"
+
+	WellKnownPath   = "/.well-known/"
+	RobotsTxtPath   = "/robots.txt"
+	SecurityTxtPath = WellKnownPath + "security.txt"
+)
+
+// Factory creates well-known WebHandler's
+type Factory struct{}
+
+var _ factory.Factory = &Factory{}
+
+// NewFactory returns a well-known Factory
+func NewFactory() *Factory {
+	return &Factory{}
+}
+
+func (f *Factory) Binding() string {
+	return Binding
+}
+
+func (f *Factory) New(_ factory.Server, binding factory.APIBinding) (factory.WebHandler, error) {
+	options := &Options{}
+	options.Default()
+
+	if err := options.Parse(binding.Options()); err != nil {
+		return nil, fmt.Errorf("error parsing options for well-known api [%s]: %v", binding.Name(), err)
+	}
+
+	if err := options.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options for well-known api [%s]: %v", binding.Name(), err)
+	}
+
+	return NewHandler(options), nil
+}
+
+// RobotsFactory creates robots.txt WebHandler's
+type RobotsFactory struct{}
+
+var _ factory.Factory = &RobotsFactory{}
+
+// NewRobotsFactory returns a robots.txt Factory
+func NewRobotsFactory() *RobotsFactory {
+	return &RobotsFactory{}
+}
+
+func (f *RobotsFactory) Binding() string {
+	return RobotsBinding
+}
+
+func (f *RobotsFactory) New(_ factory.Server, binding factory.APIBinding) (factory.WebHandler, error) {
+	options := &RobotsOptions{}
+	options.Default()
+
+	if err := options.Parse(binding.Options()); err != nil {
+		return nil, fmt.Errorf("error parsing options for robots-txt api [%s]: %v", binding.Name(), err)
+	}
+
+	if err := options.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options for robots-txt api [%s]: %v", binding.Name(), err)
+	}
+
+	return NewRobotsHandler(options), nil
+}
+
+// Handler is a factory.MethodHandler that serves the documents of validated Options or RobotsOptions
+type Handler struct {
+	rootPath     string
+	documents    map[string]*document
+	cacheControl string
+}
+
+var _ factory.MethodHandler = &Handler{}
+
+// document is a Document prepared for serving
+type document struct {
+	content     string
+	contentType string
+	etag        string
+}
+
+// NewHandler returns a Handler serving the documents of validated Options below WellKnownPath
+func NewHandler(options *Options) *Handler {
+	return newHandler(WellKnownPath, options.paths(), options.MaxAge)
+}
+
+// NewRobotsHandler returns a Handler serving the robots.txt of validated RobotsOptions at RobotsTxtPath
+func NewRobotsHandler(options *RobotsOptions) *Handler {
+	documents := map[string]*Document{
+		RobotsTxtPath: {Content: options.RobotsTxt, ContentType: contentType(RobotsTxtPath, options.RobotsTxt)},
+	}
+	return newHandler(RobotsTxtPath, documents, options.MaxAge)
+}
+
+func newHandler(rootPath string, documents map[string]*Document, maxAge time.Duration) *Handler {
+	handler := &Handler{
+		rootPath:  rootPath,
+		documents: map[string]*document{},
+	}
+
+	if maxAge > 0 {
+		handler.cacheControl = "public, max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	}
+
+	for documentPath, configured := range documents {
+		sum := sha256.Sum256([]byte(configured.Content))
+		handler.documents[documentPath] = &document{
+			content:     configured.Content,
+			contentType: configured.ContentType,
+			etag:        `"` + hex.EncodeToString(sum[:16]) + `"`,
+		}
+	}
+
+	return handler
+}
+
+func (handler *Handler) RootPath() string {
+	return handler.rootPath
+}
+
+func (handler *Handler) IsHandler(request *gmhttp.Request) bool {
+	_, ok := handler.documents[request.URL.Path]
+	return ok
+}
+
+func (handler *Handler) AllowedMethods(*gmhttp.Request) []string {
+	return []string{gmhttp.MethodGet, gmhttp.MethodHead}
+}
+
+func (handler *Handler) ServeHTTP(writer gmhttp.ResponseWriter, request *gmhttp.Request) {
+	served, ok := handler.documents[request.URL.Path]
+	if !ok {
+		xweb.WriteError(writer, request, gmhttp.StatusNotFound, fmt.Errorf("no document found for path [%s]", request.URL.Path), nil)
+		return
+	}
+
+	if request.Method != gmhttp.MethodGet && request.Method != gmhttp.MethodHead {
+		writer.Header().Set("Allow", strings.Join(handler.AllowedMethods(request), ", "))
+		xweb.WriteError(writer, request, gmhttp.StatusMethodNotAllowed, fmt.Errorf("method [%s] is not allowed for path [%s]", request.Method, request.URL.Path), nil)
+		return
+	}
+
+	writer.Header().Set("Content-Type", served.contentType)
+	writer.Header().Set("ETag", served.etag)
+	if handler.cacheControl != "" {
+		writer.Header().Set("Cache-Control", handler.cacheControl)
+	}
+
+	gmhttp.ServeContent(writer, request, request.URL.Path, time.Time{}, strings.NewReader(served.content))
+}
@@ -0,0 +1,160 @@
+package wellknown
+
+import (
+	"gitee.com/zhaochuninhefei/gmgo/gmhttp"
+	gmhttptest "gitee.com/zhaochuninhefei/gmgo/gmhttp/httptest"
+	"github.com/openziti/xweb/v2/factory"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type testBinding struct {
+	binding string
+	options factory.Options
+}
+
+func (binding *testBinding) Binding() string {
+	return binding.binding
+}
+
+func (binding *testBinding) Name() string {
+	return binding.binding
+}
+
+func (binding *testBinding) Options() factory.Options {
+	return binding.options
+}
+
+func newTestHandler(req *require.Assertions, options map[interface{}]interface{}) factory.WebHandler {
+	handler, err := NewFactory().New(nil, &testBinding{binding: Binding, options: factory.NormalizeOptions(options)})
+	req.NoError(err)
+	return handler
+}
+
+func newTestRobotsHandler(req *require.Assertions) factory.WebHandler {
+	handler, err := NewRobotsFactory().New(nil, &testBinding{binding: RobotsBinding, options: factory.NormalizeOptions(map[interface{}]interface{}{
+		"maxAge":    "1h",
+		"robotsTxt": "User-agent: *\nDisallow: /\n",
+	})})
+	req.NoError(err)
+	return handler
+}
+
+func Test_Handler(t *testing.T) {
+	options := map[interface{}]interface{}{
+		"maxAge":      "1h",
+		"securityTxt": "Contact: mailto:security@example.com\n",
+		"documents": map[interface{}]interface{}{
+			"change-password": "https://example.com/password",
+			"openid-configuration": map[interface{}]interface{}{
+				"content":     `{"issuer": "https://example.com"}`,
+				"contentType": "application/json",
+			},
+			"assetlinks.json": `[]`,
+		},
+	}
+
+	t.Run("configured documents are served", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestHandler(req, options)
+
+		req.Equal(WellKnownPath, handler.RootPath())
+
+		for documentPath, expected := range map[string][]string{
+			"/.well-known/security.txt":         {"Contact: mailto:security@example.com\n", "text/plain; charset=utf-8"},
+			"/.well-known/change-password":      {"https://example.com/password", "text/plain; charset=utf-8"},
+			"/.well-known/openid-configuration": {`{"issuer": "https://example.com"}`, "application/json"},
+			"/.well-known/assetlinks.json":      {`[]`, "application/json"},
+		} {
+			request := gmhttptest.NewRequest("GET", documentPath, nil)
+			req.True(handler.IsHandler(request), documentPath)
+
+			recorder := gmhttptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			req.Equal(gmhttp.StatusOK, recorder.Code, documentPath)
+			req.Equal(expected[0], recorder.Body.String(), documentPath)
+			req.Equal(expected[1], recorder.Header().Get("Content-Type"), documentPath)
+			req.Equal("public, max-age=3600", recorder.Header().Get("Cache-Control"), documentPath)
+		}
+	})
+
+	t.Run("robots.txt is served by a separate root path handler", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestRobotsHandler(req)
+		req.Equal(RobotsTxtPath, handler.RootPath())
+
+		request := gmhttptest.NewRequest("GET", "/robots.txt", nil)
+		req.True(handler.IsHandler(request))
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		req.Equal(gmhttp.StatusOK, recorder.Code)
+		req.Equal("User-agent: *\nDisallow: /\n", recorder.Body.String())
+		req.Equal("text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+		req.Equal("public, max-age=3600", recorder.Header().Get("Cache-Control"))
+
+		req.False(newTestHandler(req, options).IsHandler(request))
+	})
+
+	t.Run("unknown paths are not handled", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestHandler(req, options)
+
+		request := gmhttptest.NewRequest("GET", "/.well-known/other", nil)
+		req.False(handler.IsHandler(request))
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		req.Equal(gmhttp.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("unchanged documents are not resent", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestHandler(req, options)
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("GET", "/.well-known/security.txt", nil))
+
+		request := gmhttptest.NewRequest("GET", "/.well-known/security.txt", nil)
+		request.Header.Set("If-None-Match", recorder.Header().Get("ETag"))
+		recorder = gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		req.Equal(gmhttp.StatusNotModified, recorder.Code)
+	})
+
+	t.Run("only GET and HEAD are allowed", func(t *testing.T) {
+		req := require.New(t)
+		handler := newTestHandler(req, options)
+
+		recorder := gmhttptest.NewRecorder()
+		handler.ServeHTTP(recorder, gmhttptest.NewRequest("POST", "/.well-known/security.txt", nil))
+		req.Equal(gmhttp.StatusMethodNotAllowed, recorder.Code)
+		req.Equal("GET, HEAD", recorder.Header().Get("Allow"))
+	})
+
+	t.Run("invalid options are rejected", func(t *testing.T) {
+		req := require.New(t)
+
+		for _, invalid := range []map[interface{}]interface{}{
+			{},
+			{"securityTxt": 5},
+			{"securityTxt": "x", "maxAge": "-1s"},
+			{"securityTxt": "x", "robotsTxt": "x"},
+			{"documents": map[interface{}]interface{}{"../secret": "x"}},
+			{"documents": map[interface{}]interface{}{"empty": map[interface{}]interface{}{}}},
+		} {
+			_, err := NewFactory().New(nil, &testBinding{binding: Binding, options: factory.NormalizeOptions(invalid)})
+			req.Error(err, "%v", invalid)
+		}
+
+		for _, invalid := range []map[interface{}]interface{}{
+			{},
+			{"robotsTxt": 5},
+			{"robotsTxt": "x", "maxAge": "-1s"},
+		} {
+			_, err := NewRobotsFactory().New(nil, &testBinding{binding: RobotsBinding, options: factory.NormalizeOptions(invalid)})
+			req.Error(err, "%v", invalid)
+		}
+	})
+}